@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+// event is an API Gateway Lambda authorizer request: a REST API's TOKEN
+// or REQUEST event (version 1.0), or an HTTP API's (version 2.0).
+type event struct {
+	Version   string `json:"version"`
+	Type      string `json:"type"`
+	MethodArn string `json:"methodArn"`
+	RouteArn  string `json:"routeArn"`
+	// AuthorizationToken is set on TOKEN events, IdentitySource on 2.0
+	// ones.
+	AuthorizationToken string   `json:"authorizationToken"`
+	IdentitySource     []string `json:"identitySource"`
+
+	Resource              string            `json:"resource"`
+	Path                  string            `json:"path"`
+	HTTPMethod            string            `json:"httpMethod"`
+	RouteKey              string            `json:"routeKey"`
+	RawPath               string            `json:"rawPath"`
+	Headers               map[string]string `json:"headers"`
+	QueryStringParameters map[string]string `json:"queryStringParameters"`
+	PathParameters        map[string]string `json:"pathParameters"`
+	RequestContext        struct {
+		AccountID string `json:"accountId"`
+		APIID     string `json:"apiId"`
+		Stage     string `json:"stage"`
+		Identity  struct {
+			SourceIP  string `json:"sourceIp"`
+			UserAgent string `json:"userAgent"`
+		} `json:"identity"`
+		HTTP struct {
+			Method    string `json:"method"`
+			Path      string `json:"path"`
+			SourceIP  string `json:"sourceIp"`
+			UserAgent string `json:"userAgent"`
+		} `json:"http"`
+	} `json:"requestContext"`
+}
+
+// field returns the event's value for a key template placeholder: one of
+// source_ip, method, path, route, api_id, stage, account_id, user_agent
+// and token, or header.<name>, query.<name> and path.<name>.
+func (e *event) field(name string) string {
+	switch prefix, rest, _ := strings.Cut(name, "."); prefix {
+	case "header":
+		for k, v := range e.Headers {
+			if strings.EqualFold(k, rest) {
+				return v
+			}
+		}
+		return ""
+	case "query":
+		return e.QueryStringParameters[rest]
+	case "path":
+		if rest != "" {
+			return e.PathParameters[rest]
+		}
+	}
+	ctx := &e.RequestContext
+	switch name {
+	case "source_ip":
+		return first(ctx.HTTP.SourceIP, ctx.Identity.SourceIP)
+	case "method":
+		return first(ctx.HTTP.Method, e.HTTPMethod)
+	case "path":
+		return first(e.RawPath, ctx.HTTP.Path, e.Path)
+	case "route":
+		return first(e.RouteKey, e.Resource)
+	case "api_id":
+		return ctx.APIID
+	case "stage":
+		return ctx.Stage
+	case "account_id":
+		return ctx.AccountID
+	case "user_agent":
+		return first(ctx.HTTP.UserAgent, ctx.Identity.UserAgent)
+	case "token":
+		if e.AuthorizationToken != "" {
+			return e.AuthorizationToken
+		}
+		if len(e.IdentitySource) > 0 {
+			return e.IdentitySource[0]
+		}
+	}
+	return ""
+}
+
+func first(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+var placeholder = regexp.MustCompile(`\{([A-Za-z0-9_.-]+)\}`)
+
+// keyTemplate builds a key from an event's fields, such as "{source_ip}"
+// or "{header.x-api-key}:{route}". It reports false when a field is
+// missing or empty, so such requests are not lumped under one key.
+type keyTemplate string
+
+func (t keyTemplate) key(e *event) (string, bool) {
+	ok := true
+	key := placeholder.ReplaceAllStringFunc(string(t), func(m string) string {
+		v := e.field(m[1 : len(m)-1])
+		if v == "" {
+			ok = false
+		}
+		return v
+	})
+	return key, ok
+}
+
+// decision is what the authorizer tells API Gateway about one request.
+type decision struct {
+	allowed bool
+	key     string
+	// context is passed on to the integration as $context.authorizer.*,
+	// and to gateway responses.
+	context map[string]interface{}
+}
+
+// simpleResponse answers a 2.0 event when the authorizer is set to
+// simple responses.
+type simpleResponse struct {
+	IsAuthorized bool                   `json:"isAuthorized"`
+	Context      map[string]interface{} `json:"context,omitempty"`
+}
+
+// policyResponse answers with an IAM policy allowing or denying the
+// method or route invoked.
+type policyResponse struct {
+	PrincipalID    string                 `json:"principalId"`
+	PolicyDocument policyDocument         `json:"policyDocument"`
+	Context        map[string]interface{} `json:"context,omitempty"`
+}
+
+type policyDocument struct {
+	Version   string            `json:"Version"`
+	Statement []policyStatement `json:"Statement"`
+}
+
+type policyStatement struct {
+	Action   string `json:"Action"`
+	Effect   string `json:"Effect"`
+	Resource string `json:"Resource"`
+}
+
+// response encodes d in the form e's API expects: a simple response for
+// 2.0 events when simple is set, an IAM policy otherwise.
+func response(e *event, d decision, simple bool) ([]byte, error) {
+	if e.Version == "2.0" && simple {
+		return json.Marshal(simpleResponse{IsAuthorized: d.allowed, Context: d.context})
+	}
+	effect := "Deny"
+	if d.allowed {
+		effect = "Allow"
+	}
+	principal := d.key
+	if principal == "" {
+		principal = "anonymous"
+	}
+	return json.Marshal(policyResponse{
+		PrincipalID: principal,
+		PolicyDocument: policyDocument{
+			Version: "2012-10-17",
+			Statement: []policyStatement{{
+				Action:   "execute-api:Invoke",
+				Effect:   effect,
+				Resource: first(e.MethodArn, e.RouteArn),
+			}},
+		},
+		Context: d.context,
+	})
+}
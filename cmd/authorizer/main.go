@@ -0,0 +1,249 @@
+// Command authorizer is an API Gateway Lambda authorizer that enforces the
+// rate limiter's limits: it builds a key from each request's event, checks
+// it with the limiter and allows or denies the request, passing the
+// decision on as authorizer context. Built as "bootstrap", it runs on a
+// provided.al2023 Lambda runtime; run elsewhere, it answers one event read
+// from stdin.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	httpapi "rate-limiter-service/internal/http"
+)
+
+// settings are read from the environment, as Lambda configures functions.
+type settings struct {
+	limiterURL string
+	apiKey     string
+	timeout    time.Duration
+	key        keyTemplate
+	check      httpapi.CheckRequest
+	failMode   string
+	simple     bool
+}
+
+func loadSettings() (*settings, error) {
+	s := &settings{
+		limiterURL: strings.TrimSuffix(env("LIMITER_URL", "http://127.0.0.1:8080"), "/"),
+		apiKey:     os.Getenv("LIMITER_API_KEY"),
+		key:        keyTemplate(env("AUTHORIZER_KEY", "{source_ip}")),
+		failMode:   env("AUTHORIZER_FAIL_MODE", httpapi.FailModeError),
+	}
+	timeoutMs, err := strconv.Atoi(env("LIMITER_TIMEOUT_MS", "1000"))
+	if err != nil || timeoutMs <= 0 {
+		return nil, fmt.Errorf("LIMITER_TIMEOUT_MS: must be a positive number of milliseconds")
+	}
+	s.timeout = time.Duration(timeoutMs) * time.Millisecond
+	check := env("AUTHORIZER_CHECK", `{"algorithm":"fixed_window","limit":60,"window_ms":60000}`)
+	if err := json.Unmarshal([]byte(check), &s.check); err != nil {
+		return nil, fmt.Errorf("AUTHORIZER_CHECK: %w", err)
+	}
+	switch s.failMode {
+	case httpapi.FailModeError, httpapi.FailModeOpen, httpapi.FailModeClosed:
+	default:
+		return nil, fmt.Errorf("AUTHORIZER_FAIL_MODE: must be error, open or closed")
+	}
+	if s.simple, err = strconv.ParseBool(env("AUTHORIZER_SIMPLE_RESPONSES", "true")); err != nil {
+		return nil, fmt.Errorf("AUTHORIZER_SIMPLE_RESPONSES: %w", err)
+	}
+	return s, nil
+}
+
+func env(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func main() {
+	var rt *runtime
+	if api := os.Getenv("AWS_LAMBDA_RUNTIME_API"); api != "" {
+		rt = &runtime{base: "http://" + api + "/2018-06-01/runtime", client: &http.Client{}}
+	}
+	s, err := loadSettings()
+	if err != nil {
+		if rt != nil {
+			rt.post("/init/error", errorBody(err))
+		}
+		fail(err)
+	}
+	if rt != nil {
+		rt.serve(s)
+	}
+	out, err := s.handle(context.Background(), os.Stdin)
+	if err != nil {
+		fail(err)
+	}
+	os.Stdout.Write(append(out, '\n'))
+}
+
+// limiterClient calls the limiter; each call is bounded by its own timeout.
+var limiterClient = &http.Client{}
+
+// handle answers the event read from in.
+func (s *settings) handle(ctx context.Context, in io.Reader) ([]byte, error) {
+	var e event
+	if err := json.NewDecoder(in).Decode(&e); err != nil {
+		return nil, fmt.Errorf("decoding event: %w", err)
+	}
+	d, err := s.decide(ctx, &e)
+	if err != nil {
+		return nil, err
+	}
+	return response(&e, d, s.simple)
+}
+
+// decide checks the key built for e. When the limiter cannot answer, the
+// fail mode allows or denies the request, or returns the error so that
+// API Gateway answers 500.
+func (s *settings) decide(ctx context.Context, e *event) (decision, error) {
+	key, ok := s.key.key(e)
+	if !ok {
+		return decision{context: map[string]interface{}{"limit_error": "key_unavailable"}}, nil
+	}
+	resp, err := s.checkKey(ctx, key)
+	if err != nil {
+		if s.failMode == httpapi.FailModeError {
+			return decision{}, err
+		}
+		log.Printf("check for %q failed, %s: %v", key, s.failMode, err)
+		return decision{
+			allowed: s.failMode == httpapi.FailModeOpen,
+			key:     key,
+			context: map[string]interface{}{"limit_key": key, "limit_error": "limiter_unavailable"},
+		}, nil
+	}
+	return decision{
+		allowed: resp.Allowed,
+		key:     key,
+		context: map[string]interface{}{
+			"limit_key":      key,
+			"allowed":        resp.Allowed,
+			"remaining":      resp.Remaining,
+			"reset_at_ms":    resp.ResetAtMs,
+			"retry_after_ms": resp.RetryAfterMs,
+		},
+	}, nil
+}
+
+// checkAnswer is a check's response, or the error answered instead.
+type checkAnswer struct {
+	httpapi.CheckResponse
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
+
+// checkKey sends the configured check for key. A denial, including one of
+// the caller's own limit, is an answer; other failures are errors.
+func (s *settings) checkKey(ctx context.Context, key string) (checkAnswer, error) {
+	check := s.check
+	check.Key = key
+	body, err := json.Marshal(check)
+	if err != nil {
+		return checkAnswer{}, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.limiterURL+"/v1/limit/check", bytes.NewReader(body))
+	if err != nil {
+		return checkAnswer{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.apiKey != "" {
+		req.Header.Set("Authorization", "ApiKey "+s.apiKey)
+	}
+	resp, err := limiterClient.Do(req)
+	if err != nil {
+		return checkAnswer{}, err
+	}
+	defer resp.Body.Close()
+	var answer checkAnswer
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&answer); err != nil {
+		return checkAnswer{}, fmt.Errorf("check: %s", resp.Status)
+	}
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return answer, nil
+	case resp.StatusCode == http.StatusTooManyRequests:
+		answer.Allowed = false
+		return answer, nil
+	case answer.Error != "":
+		return checkAnswer{}, fmt.Errorf("check: %s: %s %s", resp.Status, answer.Error, answer.Message)
+	}
+	return checkAnswer{}, fmt.Errorf("check: %s", resp.Status)
+}
+
+// runtime speaks the Lambda runtime API, for functions on a provided
+// runtime.
+type runtime struct {
+	base   string
+	client *http.Client
+}
+
+// serve answers invocations until the function is shut down. A failed
+// invocation is reported as an error, which API Gateway answers with 500.
+func (rt *runtime) serve(s *settings) {
+	for {
+		resp, err := rt.client.Get(rt.base + "/invocation/next")
+		if err != nil {
+			fail(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			fail(fmt.Errorf("runtime: next invocation: %s", resp.Status))
+		}
+		id := resp.Header.Get("Lambda-Runtime-Aws-Request-Id")
+		deadline, err := strconv.ParseInt(resp.Header.Get("Lambda-Runtime-Deadline-Ms"), 10, 64)
+		if err != nil {
+			deadline = time.Now().Add(30 * time.Second).UnixMilli()
+		}
+		ctx, cancel := context.WithDeadline(context.Background(), time.UnixMilli(deadline))
+		out, err := s.handle(ctx, resp.Body)
+		resp.Body.Close()
+		cancel()
+		if err != nil {
+			log.Printf("invocation %s: %v", id, err)
+			rt.post("/invocation/"+id+"/error", errorBody(err))
+			continue
+		}
+		rt.post("/invocation/"+id+"/response", out)
+	}
+}
+
+func (rt *runtime) post(path string, body []byte) {
+	resp, err := rt.client.Post(rt.base+path, "application/json", bytes.NewReader(body))
+	if err != nil {
+		fail(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		log.Printf("runtime %s: %s", path, resp.Status)
+	}
+}
+
+func errorBody(err error) []byte {
+	errorType := "Error"
+	if errors.Is(err, context.DeadlineExceeded) {
+		errorType = "Timeout"
+	}
+	body, _ := json.Marshal(map[string]string{"errorMessage": err.Error(), "errorType": errorType})
+	return body
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "authorizer:", err)
+	os.Exit(1)
+}
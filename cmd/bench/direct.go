@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	"rate-limiter-service/internal/backend"
+)
+
+// directTarget calls a backend in-process, skipping HTTP and JSON entirely,
+// so algorithm and Lua cost can be measured on their own.
+type directTarget struct {
+	store backend.Backend
+	reqs  []backend.Request
+}
+
+func newDirectTarget(store backend.Backend, payloads []payload) (*directTarget, error) {
+	if store == nil {
+		return nil, fmt.Errorf("-protocol direct requires a backend")
+	}
+	reqs := make([]backend.Request, len(payloads))
+	for i, p := range payloads {
+		cost := p.Cost
+		if cost == 0 {
+			cost = 1
+		}
+		reqs[i] = backend.Request{
+			Algorithm:    p.Algorithm,
+			Key:          directKey(p),
+			Limit:        p.Limit,
+			WindowMs:     p.WindowMs,
+			Capacity:     p.Capacity,
+			RefillPerSec: p.RefillPerSec,
+			LeakPerSec:   p.LeakPerSec,
+			Cost:         cost,
+		}
+	}
+	return &directTarget{store: store, reqs: reqs}, nil
+}
+
+func (t *directTarget) Check(ctx context.Context, idx int) (int, error) {
+	res, err := backend.Evaluate(ctx, t.store, t.reqs[idx])
+	if err != nil {
+		return http.StatusInternalServerError, nil
+	}
+	if !res.Allowed {
+		return http.StatusTooManyRequests, nil
+	}
+	return http.StatusOK, nil
+}
+
+// directKey derives the key the HTTP API would use for p.
+func directKey(p payload) string {
+	switch {
+	case p.Key != "":
+		return p.Key
+	case p.UserID != "":
+		return "user:" + p.UserID
+	case p.DeviceID != "":
+		return "device:" + p.DeviceID
+	case p.JWT != "":
+		sum := sha256.Sum256([]byte(p.JWT))
+		return "jwt:" + hex.EncodeToString(sum[:])
+	default:
+		return ""
+	}
+}
+
+func newDirectBackend(kind, redisAddr, redisPassword string, redisDB int) (backend.Backend, error) {
+	switch kind {
+	case "memory":
+		return backend.NewMemoryBackend(backend.MemoryOptions{}), nil
+	case "redis":
+		return backend.NewRedisBackend(backend.RedisOptions{
+			Addr:     redisAddr,
+			Password: redisPassword,
+			DB:       redisDB,
+		})
+	default:
+		return nil, fmt.Errorf("unknown backend %q", kind)
+	}
+}
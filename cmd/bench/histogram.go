@@ -0,0 +1,101 @@
+package main
+
+import (
+	"math/bits"
+	"time"
+)
+
+// histogram is a log-linear latency histogram in the style of HdrHistogram:
+// every power-of-two range is split into 2^subBucketBits linear buckets, so
+// recorded values keep ~0.1% precision while memory stays fixed no matter
+// how many samples are recorded.
+type histogram struct {
+	counts []int64
+	count  int64
+	sum    int64
+	min    int64
+	max    int64
+}
+
+const (
+	subBucketBits  = 10
+	maxTrackable   = int64(time.Hour)
+	subBucketCount = 1 << subBucketBits
+)
+
+func newHistogram() *histogram {
+	return &histogram{counts: make([]int64, bucketIndex(maxTrackable)+1)}
+}
+
+func bucketIndex(v int64) int {
+	if v < subBucketCount {
+		return int(v)
+	}
+	shift := bits.Len64(uint64(v)) - 1 - subBucketBits
+	return shift<<subBucketBits + int(v>>uint(shift))
+}
+
+// bucketUpper returns the highest value that maps to idx, which is what
+// percentiles report so they never understate latency.
+func bucketUpper(idx int) int64 {
+	if idx < subBucketCount {
+		return int64(idx)
+	}
+	shift := idx>>subBucketBits - 1
+	mantissa := int64(idx - shift<<subBucketBits)
+	return (mantissa+1)<<uint(shift) - 1
+}
+
+func (h *histogram) Record(d time.Duration) {
+	v := int64(d)
+	if v < 0 {
+		v = 0
+	}
+	if v > maxTrackable {
+		v = maxTrackable
+	}
+	h.counts[bucketIndex(v)]++
+	if h.count == 0 || v < h.min {
+		h.min = v
+	}
+	if v > h.max {
+		h.max = v
+	}
+	h.count++
+	h.sum += v
+}
+
+func (h *histogram) Count() int64 { return h.count }
+
+func (h *histogram) Min() time.Duration { return time.Duration(h.min) }
+
+func (h *histogram) Max() time.Duration { return time.Duration(h.max) }
+
+func (h *histogram) Mean() time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	return time.Duration(h.sum / h.count)
+}
+
+func (h *histogram) Percentile(p float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	target := int64(p*float64(h.count) + 0.5)
+	if target < 1 {
+		target = 1
+	}
+	var seen int64
+	for idx, c := range h.counts {
+		seen += c
+		if seen >= target {
+			v := bucketUpper(idx)
+			if v > h.max {
+				v = h.max
+			}
+			return time.Duration(v)
+		}
+	}
+	return time.Duration(h.max)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// keyPicker chooses which of the N benchmark keys the next request targets.
+// It is not safe for concurrent use; each worker owns one.
+type keyPicker interface {
+	Next() int
+}
+
+type singleKey struct{}
+
+func (singleKey) Next() int { return 0 }
+
+type uniformKeys struct {
+	rng *rand.Rand
+	n   int
+}
+
+func (u *uniformKeys) Next() int { return u.rng.Intn(u.n) }
+
+type zipfKeys struct {
+	zipf *rand.Zipf
+}
+
+func (z *zipfKeys) Next() int { return int(z.zipf.Uint64()) }
+
+func newKeyPicker(dist string, n int, s, v float64, seed int64) (keyPicker, error) {
+	if n <= 1 {
+		return singleKey{}, nil
+	}
+	rng := rand.New(rand.NewSource(seed))
+	switch dist {
+	case "uniform":
+		return &uniformKeys{rng: rng, n: n}, nil
+	case "zipf":
+		if s <= 1 || v < 1 {
+			return nil, fmt.Errorf("zipf requires -zipf_s > 1 and -zipf_v >= 1")
+		}
+		return &zipfKeys{zipf: rand.NewZipf(rng, s, v, uint64(n-1))}, nil
+	default:
+		return nil, fmt.Errorf("unknown key distribution %q", dist)
+	}
+}
+
+// withKeyIndex suffixes every selector the payload sets, so the derived key
+// differs per index whichever selector the server ends up using.
+func withKeyIndex(p payload, idx int) payload {
+	suffix := fmt.Sprintf(":%d", idx)
+	if p.Key != "" {
+		p.Key += suffix
+	}
+	if p.UserID != "" {
+		p.UserID += suffix
+	}
+	if p.DeviceID != "" {
+		p.DeviceID += suffix
+	}
+	if p.JWT != "" {
+		p.JWT += suffix
+	}
+	return p
+}
@@ -1,167 +1,154 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"flag"
 	"fmt"
-	"math"
-	"net/http"
-	"sort"
+	"io"
+	"os"
 	"sync"
-	"sync/atomic"
 	"time"
+
+	"rate-limiter-service/internal/backend"
 )
 
 type payload struct {
-	Key          string  `json:"key,omitempty"`
-	UserID       string  `json:"user_id,omitempty"`
-	DeviceID     string  `json:"device_id,omitempty"`
-	JWT          string  `json:"jwt,omitempty"`
-	Algorithm    string  `json:"algorithm"`
-	Limit        int64   `json:"limit,omitempty"`
-	WindowMs     int64   `json:"window_ms,omitempty"`
-	Capacity     int64   `json:"capacity,omitempty"`
-	RefillPerSec float64 `json:"refill_per_sec,omitempty"`
-	LeakPerSec   float64 `json:"leak_per_sec,omitempty"`
-	Cost         int64   `json:"cost,omitempty"`
+	Key          string  `json:"key,omitempty" yaml:"key"`
+	UserID       string  `json:"user_id,omitempty" yaml:"user_id"`
+	DeviceID     string  `json:"device_id,omitempty" yaml:"device_id"`
+	JWT          string  `json:"jwt,omitempty" yaml:"jwt"`
+	Algorithm    string  `json:"algorithm" yaml:"algorithm"`
+	Limit        int64   `json:"limit,omitempty" yaml:"limit"`
+	WindowMs     int64   `json:"window_ms,omitempty" yaml:"window_ms"`
+	Capacity     int64   `json:"capacity,omitempty" yaml:"capacity"`
+	RefillPerSec float64 `json:"refill_per_sec,omitempty" yaml:"refill_per_sec"`
+	LeakPerSec   float64 `json:"leak_per_sec,omitempty" yaml:"leak_per_sec"`
+	Cost         int64   `json:"cost,omitempty" yaml:"cost"`
 }
 
 func main() {
-	var (
-		url         = flag.String("url", "http://127.0.0.1:8080/v1/limit/check", "target URL")
-		concurrency = flag.Int("concurrency", 8, "number of workers")
-		duration    = flag.Duration("duration", 10*time.Second, "test duration")
-		qps         = flag.Int("qps", 200, "total QPS (approx)")
-	)
-
-	req := payload{
-		Algorithm:    "token_bucket",
-		Key:          "bench:key",
-		Capacity:     100,
-		RefillPerSec: 50,
-		Cost:         1,
+	w := workload{
+		Request: payload{
+			Algorithm:    "token_bucket",
+			Key:          "bench:key",
+			Capacity:     100,
+			RefillPerSec: 50,
+			Cost:         1,
+		},
 	}
 
-	flag.StringVar(&req.Key, "key", req.Key, "rate limit key")
-	flag.StringVar(&req.UserID, "user_id", "", "user id key")
-	flag.StringVar(&req.DeviceID, "device_id", "", "device id key")
-	flag.StringVar(&req.JWT, "jwt", "", "jwt token key")
-	flag.StringVar(&req.Algorithm, "algorithm", req.Algorithm, "algorithm")
-	flag.Int64Var(&req.Limit, "limit", 0, "limit for window algorithms")
-	flag.Int64Var(&req.WindowMs, "window_ms", 0, "window size in ms")
-	flag.Int64Var(&req.Capacity, "capacity", req.Capacity, "capacity for bucket algorithms")
-	flag.Float64Var(&req.RefillPerSec, "refill_per_sec", req.RefillPerSec, "refill per sec (token bucket)")
-	flag.Float64Var(&req.LeakPerSec, "leak_per_sec", 0, "leak per sec (leaky bucket)")
-	flag.Int64Var(&req.Cost, "cost", req.Cost, "cost per request")
+	flag.StringVar(&w.URL, "url", "http://127.0.0.1:8080/v1/limit/check", "target URL")
+	flag.StringVar(&w.Protocol, "protocol", "http", "how requests reach the limiter (http|grpc|direct)")
+	flag.IntVar(&w.Concurrency, "concurrency", 8, "maximum requests in flight")
+	flag.IntVar(&w.QPS, "qps", 200, "target QPS, sent on a fixed schedule regardless of response latency")
+	flag.IntVar(&w.Keys, "keys", 1, "number of distinct keys to spread requests over")
+	flag.StringVar(&w.Dist, "dist", "uniform", "key distribution when -keys > 1 (uniform|zipf)")
+	flag.Float64Var(&w.ZipfS, "zipf_s", 1.1, "zipf skew exponent (> 1)")
+	flag.Float64Var(&w.ZipfV, "zipf_v", 1, "zipf offset (>= 1)")
+	flag.StringVar(&w.Ramp, "ramp", "none", "load profile over -duration (none|step|linear)")
+	flag.IntVar(&w.RampTo, "ramp_to", 0, "final QPS for step and linear ramps")
+	flag.IntVar(&w.RampSteps, "ramp_steps", 5, "number of steps (and reported stages) for ramps")
+
+	flag.StringVar(&w.Request.Key, "key", w.Request.Key, "rate limit key")
+	flag.StringVar(&w.Request.UserID, "user_id", "", "user id key")
+	flag.StringVar(&w.Request.DeviceID, "device_id", "", "device id key")
+	flag.StringVar(&w.Request.JWT, "jwt", "", "jwt token key")
+	flag.StringVar(&w.Request.Algorithm, "algorithm", w.Request.Algorithm, "algorithm")
+	flag.Int64Var(&w.Request.Limit, "limit", 0, "limit for window algorithms")
+	flag.Int64Var(&w.Request.WindowMs, "window_ms", 0, "window size in ms")
+	flag.Int64Var(&w.Request.Capacity, "capacity", w.Request.Capacity, "capacity for bucket algorithms")
+	flag.Float64Var(&w.Request.RefillPerSec, "refill_per_sec", w.Request.RefillPerSec, "refill per sec (token bucket)")
+	flag.Float64Var(&w.Request.LeakPerSec, "leak_per_sec", 0, "leak per sec (leaky bucket)")
+	flag.Int64Var(&w.Request.Cost, "cost", w.Request.Cost, "cost per request")
+
+	var (
+		duration     = flag.Duration("duration", 10*time.Second, "test duration")
+		warmup       = flag.Duration("warmup", 0, "send load for this long before measuring; excluded from results")
+		seed         = flag.Int64("seed", time.Now().UnixNano(), "random seed for key selection")
+		output       = flag.String("output", "text", "result format (text|json|csv)")
+		outPath      = flag.String("out", "", "write results to this file instead of stdout")
+		scenarioPath = flag.String("scenario", "", "YAML file describing several workloads to run concurrently")
+		interval     = flag.Duration("interval", 0, "print live per-interval stats to stderr at this period (0 disables)")
+
+		backendKind   = flag.String("backend", "memory", "backend for -protocol direct (memory|redis)")
+		redisAddr     = flag.String("redis_addr", "127.0.0.1:6379", "redis address for -backend redis")
+		redisPassword = flag.String("redis_password", "", "redis password for -backend redis")
+		redisDB       = flag.Int("redis_db", 0, "redis db for -backend redis")
+	)
 
 	flag.Parse()
 
-	body, err := json.Marshal(req)
-	if err != nil {
-		panic(err)
+	switch *output {
+	case "text", "json", "csv":
+	default:
+		fmt.Fprintf(os.Stderr, "unknown -output %q\n", *output)
+		os.Exit(2)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), *duration)
-	defer cancel()
+	sc := scenario{Duration: *duration, Warmup: *warmup, Workloads: []workload{w}}
+	if *scenarioPath != "" {
+		var err error
+		sc, err = loadScenario(*scenarioPath, w, *duration, *warmup)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
 
-	client := &http.Client{
-		Timeout: 5 * time.Second,
+	var store backend.Backend
+	for _, wl := range sc.Workloads {
+		if wl.Protocol != "direct" {
+			continue
+		}
+		var err error
+		store, err = newDirectBackend(*backendKind, *redisAddr, *redisPassword, *redisDB)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		defer store.Close()
+		break
 	}
 
-	var (
-		total     int64
-		okCount   int64
-		errorCount int64
-		durationsMu sync.Mutex
-		durations    []time.Duration
-	)
+	prepared := make([]*preparedWorkload, len(sc.Workloads))
+	for i, wl := range sc.Workloads {
+		p, err := wl.prepare(sc.Duration, *seed+int64(i)*1000, store)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", wl.Name, err)
+			os.Exit(2)
+		}
+		prepared[i] = p
+	}
 
-	ticker := time.NewTicker(time.Second / time.Duration(max(1, *qps)))
-	defer ticker.Stop()
+	runStart := time.Now()
+	ctx, cancel := context.WithDeadline(context.Background(), runStart.Add(sc.Warmup+sc.Duration))
+	defer cancel()
 
+	reports := make([]report, len(prepared))
 	var wg sync.WaitGroup
-	wg.Add(*concurrency)
-
-	for i := 0; i < *concurrency; i++ {
-		go func() {
+	for i, p := range prepared {
+		wg.Add(1)
+		go func(i int, p *preparedWorkload) {
 			defer wg.Done()
-			for {
-				select {
-				case <-ctx.Done():
-					return
-				case <-ticker.C:
-					start := time.Now()
-					resp, err := client.Post(*url, "application/json", bytes.NewReader(body))
-					if err != nil {
-						atomic.AddInt64(&errorCount, 1)
-						continue
-					}
-					_ = resp.Body.Close()
-					atomic.AddInt64(&total, 1)
-					if resp.StatusCode < 500 {
-						atomic.AddInt64(&okCount, 1)
-					}
-					elapsed := time.Since(start)
-					durationsMu.Lock()
-					durations = append(durations, elapsed)
-					durationsMu.Unlock()
-				}
-			}
-		}()
+			reports[i] = p.run(ctx, runStart, sc.Warmup, *interval)
+		}(i, p)
 	}
-
 	wg.Wait()
 
-	durationsMu.Lock()
-	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
-	durationsMu.Unlock()
-
-	printStats(total, okCount, errorCount, durations)
-}
-
-func printStats(total, okCount, errorCount int64, samples []time.Duration) {
-	if len(samples) == 0 {
-		fmt.Println("no samples collected")
-		return
-	}
-	p50 := percentile(samples, 0.50)
-	p95 := percentile(samples, 0.95)
-	p99 := percentile(samples, 0.99)
-	min := samples[0]
-	max := samples[len(samples)-1]
-	avg := average(samples)
-
-	fmt.Printf("total=%d ok=%d errors=%d\n", total, okCount, errorCount)
-	fmt.Printf("min=%s p50=%s p95=%s p99=%s max=%s avg=%s\n",
-		min, p50, p95, p99, max, avg,
-	)
-}
-
-func percentile(samples []time.Duration, p float64) time.Duration {
-	if len(samples) == 0 {
-		return 0
-	}
-	idx := int(math.Ceil(float64(len(samples))*p)) - 1
-	if idx < 0 {
-		idx = 0
-	}
-	if idx >= len(samples) {
-		idx = len(samples) - 1
-	}
-	return samples[idx]
-}
-
-func average(samples []time.Duration) time.Duration {
-	if len(samples) == 0 {
-		return 0
+	out := io.Writer(os.Stdout)
+	if *outPath != "" {
+		f, err := os.Create(*outPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		out = f
 	}
-	var total time.Duration
-	for _, s := range samples {
-		total += s
+	if err := writeReports(out, *output, reports); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
-	return total / time.Duration(len(samples))
 }
 
 func max(a, b int) int {
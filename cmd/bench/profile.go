@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// profile describes the offered load over the measured part of a run. A flat
+// profile holds startQPS throughout; step and linear profiles move from
+// startQPS to endQPS so the latency knee shows up in one run.
+type profile struct {
+	kind     string
+	startQPS float64
+	endQPS   float64
+	steps    int
+	duration time.Duration
+}
+
+func newProfile(kind string, startQPS, endQPS float64, steps int, duration time.Duration) (profile, error) {
+	p := profile{kind: kind, startQPS: startQPS, endQPS: endQPS, steps: steps, duration: duration}
+	switch kind {
+	case "none":
+		p.endQPS = startQPS
+		p.steps = 1
+	case "step", "linear":
+		if endQPS <= 0 {
+			return p, fmt.Errorf("-ramp %s requires -ramp_to > 0", kind)
+		}
+		if steps < 1 {
+			return p, fmt.Errorf("-ramp_steps must be >= 1")
+		}
+	default:
+		return p, fmt.Errorf("unknown ramp profile %q", kind)
+	}
+	return p, nil
+}
+
+func (p profile) stageLen() time.Duration {
+	return p.duration / time.Duration(p.steps)
+}
+
+// stage returns which reporting stage elapsed falls into.
+func (p profile) stage(elapsed time.Duration) int {
+	idx := int(elapsed / p.stageLen())
+	if idx >= p.steps {
+		idx = p.steps - 1
+	}
+	if idx < 0 {
+		idx = 0
+	}
+	return idx
+}
+
+// rateAt is the target QPS at elapsed into the measured phase.
+func (p profile) rateAt(elapsed time.Duration) float64 {
+	switch p.kind {
+	case "step":
+		if p.steps == 1 {
+			return p.startQPS
+		}
+		frac := float64(p.stage(elapsed)) / float64(p.steps-1)
+		return p.startQPS + (p.endQPS-p.startQPS)*frac
+	case "linear":
+		frac := math.Min(1, math.Max(0, float64(elapsed)/float64(p.duration)))
+		return p.startQPS + (p.endQPS-p.startQPS)*frac
+	default:
+		return p.startQPS
+	}
+}
+
+// stageTarget is the mean target QPS over a stage, for reporting.
+func (p profile) stageTarget(idx int) float64 {
+	if p.kind == "linear" {
+		mid := p.stageLen()*time.Duration(idx) + p.stageLen()/2
+		return p.rateAt(mid)
+	}
+	return p.rateAt(p.stageLen() * time.Duration(idx))
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+type report struct {
+	Name  string `json:"name,omitempty"`
+	Total int64  `json:"total"`
+	// Allowed are 2xx answers, Denied are 429s; ClientErrors and
+	// ServerErrors are the remaining 4xx and 5xx. Errors counts requests
+	// that got no response at all.
+	Allowed      int64   `json:"allowed"`
+	Denied       int64   `json:"denied"`
+	ClientErrors int64   `json:"client_errors"`
+	ServerErrors int64   `json:"server_errors"`
+	Errors       int64   `json:"errors"`
+	DurationSec  float64 `json:"duration_sec"`
+	AchievedQPS  float64 `json:"achieved_qps"`
+	// AllowRate is allowed / (allowed + denied). ConfiguredAllowQPS is the
+	// steady-state rate the limit should let through across all keys, to
+	// compare with ObservedAllowQPS; initial bursts push the observed rate
+	// above it on short runs.
+	AllowRate          float64 `json:"allow_rate"`
+	ObservedAllowQPS   float64 `json:"observed_allow_qps"`
+	ConfiguredAllowQPS float64 `json:"configured_allow_qps,omitempty"`
+	// Latency is measured from the scheduled send time, Service from the
+	// actual one; SendLag is the gap between the two.
+	Latency    summary `json:"latency"`
+	Service    summary `json:"service"`
+	SendLag    summary `json:"send_lag"`
+	Throughput []int64 `json:"throughput_per_sec"`
+	// Stages splits a ramped run into its steps (or equal time slices for
+	// a linear ramp) so the latency knee can be located.
+	Stages []stageReport `json:"stages,omitempty"`
+}
+
+type stageReport struct {
+	TargetQPS   float64 `json:"target_qps"`
+	Total       int64   `json:"total"`
+	AchievedQPS float64 `json:"achieved_qps"`
+	Latency     summary `json:"latency"`
+}
+
+type summary struct {
+	Count int64
+	Min   time.Duration
+	P50   time.Duration
+	P95   time.Duration
+	P99   time.Duration
+	Max   time.Duration
+	Avg   time.Duration
+}
+
+func summarize(h *histogram) summary {
+	return summary{
+		Count: h.Count(),
+		Min:   h.Min(),
+		P50:   h.Percentile(0.50),
+		P95:   h.Percentile(0.95),
+		P99:   h.Percentile(0.99),
+		Max:   h.Max(),
+		Avg:   h.Mean(),
+	}
+}
+
+// fields lists the summary in a stable order, in milliseconds, for the
+// machine-readable formats.
+func (s summary) fields() [][2]string {
+	return [][2]string{
+		{"count", strconv.FormatInt(s.Count, 10)},
+		{"min_ms", formatMs(s.Min)},
+		{"p50_ms", formatMs(s.P50)},
+		{"p95_ms", formatMs(s.P95)},
+		{"p99_ms", formatMs(s.P99)},
+		{"max_ms", formatMs(s.Max)},
+		{"avg_ms", formatMs(s.Avg)},
+	}
+}
+
+func (s summary) MarshalJSON() ([]byte, error) {
+	buf := []byte{'{'}
+	for i, f := range s.fields() {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = strconv.AppendQuote(buf, f[0])
+		buf = append(buf, ':')
+		buf = append(buf, f[1]...)
+	}
+	return append(buf, '}'), nil
+}
+
+func formatMs(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+}
+
+// writeReports writes a single workload exactly as writeReport does; for a
+// scenario each workload is reported under its name.
+func writeReports(w io.Writer, format string, reports []report) error {
+	if len(reports) == 1 {
+		return writeReport(w, format, reports[0])
+	}
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(struct {
+			Workloads []report `json:"workloads"`
+		}{reports})
+	case "csv":
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{"metric", "value"}); err != nil {
+			return err
+		}
+		for _, r := range reports {
+			for _, row := range csvRows(r) {
+				row[0] = r.Name + "." + row[0]
+				if err := cw.Write(row); err != nil {
+					return err
+				}
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		for _, r := range reports {
+			if _, err := fmt.Fprintf(w, "== %s\n", r.Name); err != nil {
+				return err
+			}
+			if err := writeText(w, r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func writeReport(w io.Writer, format string, r report) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	case "csv":
+		return writeCSV(w, r)
+	default:
+		return writeText(w, r)
+	}
+}
+
+// writeCSV emits one metric,value row per figure so runs can be diffed or
+// joined on the metric column.
+func writeCSV(w io.Writer, r report) error {
+	cw := csv.NewWriter(w)
+	rows := append([][]string{{"metric", "value"}}, csvRows(r)...)
+	if err := cw.WriteAll(rows); err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+func csvRows(r report) [][]string {
+	rows := [][]string{
+		{"total", strconv.FormatInt(r.Total, 10)},
+		{"allowed", strconv.FormatInt(r.Allowed, 10)},
+		{"denied", strconv.FormatInt(r.Denied, 10)},
+		{"client_errors", strconv.FormatInt(r.ClientErrors, 10)},
+		{"server_errors", strconv.FormatInt(r.ServerErrors, 10)},
+		{"errors", strconv.FormatInt(r.Errors, 10)},
+		{"duration_sec", strconv.FormatFloat(r.DurationSec, 'f', 3, 64)},
+		{"achieved_qps", strconv.FormatFloat(r.AchievedQPS, 'f', 1, 64)},
+		{"allow_rate", strconv.FormatFloat(r.AllowRate, 'f', 4, 64)},
+		{"observed_allow_qps", strconv.FormatFloat(r.ObservedAllowQPS, 'f', 1, 64)},
+		{"configured_allow_qps", strconv.FormatFloat(r.ConfiguredAllowQPS, 'f', 1, 64)},
+	}
+	for _, section := range []struct {
+		name string
+		s    summary
+	}{{"latency", r.Latency}, {"service", r.Service}, {"send_lag", r.SendLag}} {
+		for _, f := range section.s.fields() {
+			rows = append(rows, []string{section.name + "." + f[0], f[1]})
+		}
+	}
+	for i, stage := range r.Stages {
+		prefix := "stage." + strconv.Itoa(i) + "."
+		rows = append(rows,
+			[]string{prefix + "target_qps", strconv.FormatFloat(stage.TargetQPS, 'f', 1, 64)},
+			[]string{prefix + "total", strconv.FormatInt(stage.Total, 10)},
+			[]string{prefix + "achieved_qps", strconv.FormatFloat(stage.AchievedQPS, 'f', 1, 64)},
+		)
+		for _, f := range stage.Latency.fields() {
+			rows = append(rows, []string{prefix + "latency." + f[0], f[1]})
+		}
+	}
+	for sec, n := range r.Throughput {
+		rows = append(rows, []string{"throughput_per_sec." + strconv.Itoa(sec), strconv.FormatInt(n, 10)})
+	}
+	return rows
+}
+
+func writeText(w io.Writer, r report) error {
+	if r.Latency.Count == 0 {
+		_, err := fmt.Fprintln(w, "no samples collected")
+		return err
+	}
+	fmt.Fprintf(w, "total=%d allowed=%d denied=%d 4xx=%d 5xx=%d errors=%d achieved_qps=%.1f\n",
+		r.Total, r.Allowed, r.Denied, r.ClientErrors, r.ServerErrors, r.Errors, r.AchievedQPS,
+	)
+	fmt.Fprintf(w, "allow_rate=%.4f observed_allow_qps=%.1f configured_allow_qps=%.1f\n",
+		r.AllowRate, r.ObservedAllowQPS, r.ConfiguredAllowQPS,
+	)
+	writeTextSummary(w, "latency", r.Latency)
+	writeTextSummary(w, "service", r.Service)
+	_, err := writeTextSummary(w, "send_lag", r.SendLag)
+	for i, stage := range r.Stages {
+		_, err = fmt.Fprintf(w, "stage %d target_qps=%.1f achieved_qps=%.1f p50=%s p99=%s max=%s\n",
+			i, stage.TargetQPS, stage.AchievedQPS, stage.Latency.P50, stage.Latency.P99, stage.Latency.Max,
+		)
+	}
+	return err
+}
+
+func writeTextSummary(w io.Writer, name string, s summary) (int, error) {
+	return fmt.Fprintf(w, "%-8s min=%s p50=%s p95=%s p99=%s max=%s avg=%s\n",
+		name, s.Min, s.P50, s.P95, s.P99, s.Max, s.Avg,
+	)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// schedule emits the intended send time of each request until ctx is done.
+// The first warmup of the run is sent at the profile's starting rate, then
+// the profile drives the rate. When every worker is busy the send blocks,
+// but the timestamps keep to the original schedule, so a slow server shows
+// up as lag and latency rather than as a silently lower offered rate
+// (coordinated omission).
+func schedule(ctx context.Context, start time.Time, warmup time.Duration, p profile, out chan<- time.Time) {
+	defer close(out)
+	measureStart := start.Add(warmup)
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	<-timer.C
+
+	next := start
+	for {
+		if wait := time.Until(next); wait > 0 {
+			timer.Reset(wait)
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case out <- next:
+		}
+
+		rate := p.startQPS
+		if !next.Before(measureStart) {
+			rate = p.rateAt(next.Sub(measureStart))
+		}
+		if rate < 1 {
+			rate = 1
+		}
+		next = next.Add(time.Duration(float64(time.Second) / rate))
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// stats accumulates the measured part of a run. Requests scheduled during
+// warmup are sent but never recorded.
+type stats struct {
+	mu           sync.Mutex
+	measureStart time.Time
+	profile      profile
+
+	total        int64
+	allowed      int64
+	denied       int64
+	clientErrors int64
+	serverErrors int64
+	errors       int64
+	latency      *histogram
+	service      *histogram
+	lag          *histogram
+	perSecond    []int64
+	stages       []stageStats
+	interval     intervalStats
+}
+
+// intervalStats covers everything sent since the last progress line,
+// warmup included, and is reset each time it is taken.
+type intervalStats struct {
+	total   int64
+	denied  int64
+	failed  int64
+	latency *histogram
+}
+
+type stageStats struct {
+	total   int64
+	latency *histogram
+}
+
+func newStats(measureStart time.Time, p profile) *stats {
+	s := &stats{
+		measureStart: measureStart,
+		profile:      p,
+		latency:      newHistogram(),
+		service:      newHistogram(),
+		lag:          newHistogram(),
+		stages:       make([]stageStats, p.steps),
+		interval:     intervalStats{latency: newHistogram()},
+	}
+	for i := range s.stages {
+		s.stages[i].latency = newHistogram()
+	}
+	return s
+}
+
+func (s *stats) measured(scheduled time.Time) bool {
+	return !scheduled.Before(s.measureStart)
+}
+
+func (s *stats) recordError(scheduled time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.interval.total++
+	s.interval.failed++
+	if s.measured(scheduled) {
+		s.errors++
+	}
+}
+
+// takeInterval returns the progress counters and starts a new interval.
+func (s *stats) takeInterval() intervalStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	taken := s.interval
+	s.interval = intervalStats{latency: newHistogram()}
+	return taken
+}
+
+func (s *stats) recordResponse(scheduled, sent, done time.Time, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	latency := done.Sub(scheduled)
+	s.interval.total++
+	s.interval.latency.Record(latency)
+	switch {
+	case status == http.StatusTooManyRequests:
+		s.interval.denied++
+	case status >= 500:
+		s.interval.failed++
+	}
+	if !s.measured(scheduled) {
+		return
+	}
+
+	s.total++
+	switch {
+	case status == http.StatusTooManyRequests:
+		s.denied++
+	case status >= 500:
+		s.serverErrors++
+	case status >= 400:
+		s.clientErrors++
+	default:
+		s.allowed++
+	}
+	s.latency.Record(latency)
+	s.service.Record(done.Sub(sent))
+	s.lag.Record(sent.Sub(scheduled))
+
+	sec := int(done.Sub(s.measureStart) / time.Second)
+	for len(s.perSecond) <= sec {
+		s.perSecond = append(s.perSecond, 0)
+	}
+	s.perSecond[sec]++
+
+	stage := &s.stages[s.profile.stage(scheduled.Sub(s.measureStart))]
+	stage.total++
+	stage.latency.Record(latency)
+}
+
+func (s *stats) report() report {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seconds := s.profile.duration.Seconds()
+	rep := report{
+		Total:            s.total,
+		Allowed:          s.allowed,
+		Denied:           s.denied,
+		ClientErrors:     s.clientErrors,
+		ServerErrors:     s.serverErrors,
+		Errors:           s.errors,
+		DurationSec:      seconds,
+		AchievedQPS:      float64(s.total) / seconds,
+		ObservedAllowQPS: float64(s.allowed) / seconds,
+		Latency:          summarize(s.latency),
+		Service:          summarize(s.service),
+		SendLag:          summarize(s.lag),
+		Throughput:       s.perSecond,
+	}
+	if decided := s.allowed + s.denied; decided > 0 {
+		rep.AllowRate = float64(s.allowed) / float64(decided)
+	}
+	if s.profile.kind != "none" {
+		stageSeconds := s.profile.stageLen().Seconds()
+		for i, st := range s.stages {
+			rep.Stages = append(rep.Stages, stageReport{
+				TargetQPS:   s.profile.stageTarget(i),
+				Total:       st.total,
+				AchievedQPS: float64(st.total) / stageSeconds,
+				Latency:     summarize(st.latency),
+			})
+		}
+	}
+	return rep
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"rate-limiter-service/internal/backend"
+	"rate-limiter-service/internal/extauthz"
+)
+
+// target is what the workers drive. Payloads are prepared up front, one per
+// key index, so encoding cost stays out of the measured path.
+type target interface {
+	// Check sends the request for key index idx and returns its status
+	// expressed as an HTTP status code.
+	Check(ctx context.Context, idx int) (int, error)
+}
+
+// newTarget builds the target for w. store is only used, and only needs to
+// be non-nil, for the direct protocol.
+func newTarget(w workload, payloads []payload, store backend.Backend) (target, error) {
+	switch w.Protocol {
+	case "http":
+		return newHTTPTarget(w.URL, w.Concurrency, payloads)
+	case "direct":
+		return newDirectTarget(store, payloads)
+	case "grpc":
+		return newGRPCTarget(w.URL, w.Concurrency, payloads)
+	default:
+		return nil, fmt.Errorf("unknown protocol %q", w.Protocol)
+	}
+}
+
+type httpTarget struct {
+	client *http.Client
+	url    string
+	bodies [][]byte
+}
+
+func newHTTPTarget(url string, concurrency int, payloads []payload) (*httpTarget, error) {
+	bodies := make([][]byte, len(payloads))
+	for i, p := range payloads {
+		body, err := json.Marshal(p)
+		if err != nil {
+			return nil, err
+		}
+		bodies[i] = body
+	}
+	return &httpTarget{
+		client: &http.Client{
+			Timeout: 5 * time.Second,
+			Transport: &http.Transport{
+				MaxIdleConns:        concurrency,
+				MaxIdleConnsPerHost: concurrency,
+			},
+		},
+		url:    url,
+		bodies: bodies,
+	}, nil
+}
+
+func (t *httpTarget) Check(ctx context.Context, idx int) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(t.bodies[idx]))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// grpcTarget calls the ext_authz Check RPC, the service's gRPC API, as
+// Envoy would, over HTTP/2 without TLS for an http URL. Each call names
+// its key and limit in context extensions, so the server checks what an
+// HTTP check with the payload would.
+type grpcTarget struct {
+	client *http.Client
+	url    string
+	frames [][]byte
+}
+
+func newGRPCTarget(rawURL string, concurrency int, payloads []payload) (*grpcTarget, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	u.Path, u.RawQuery = extauthz.Path, ""
+	frames := make([][]byte, len(payloads))
+	for i, p := range payloads {
+		frames[i] = extauthz.EncodeRequest(&extauthz.Request{
+			SourceAddress:     "127.0.0.1",
+			Method:            http.MethodGet,
+			Path:              "/",
+			Host:              u.Host,
+			Scheme:            "http",
+			Protocol:          "HTTP/1.1",
+			ContextExtensions: contextExtensions(p),
+		})
+	}
+	protocols := new(http.Protocols)
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(true)
+	return &grpcTarget{
+		client: &http.Client{
+			Timeout: 5 * time.Second,
+			Transport: &http.Transport{
+				Protocols:           protocols,
+				MaxIdleConns:        concurrency,
+				MaxIdleConnsPerHost: concurrency,
+			},
+		},
+		url:    u.String(),
+		frames: frames,
+	}, nil
+}
+
+// contextExtensions carries p as a route's ext_authz context extensions:
+// its key as a template with no placeholders, and its limit by field name.
+func contextExtensions(p payload) map[string]string {
+	ext := map[string]string{"key": directKey(p), "algorithm": p.Algorithm}
+	for name, v := range map[string]int64{"limit": p.Limit, "window_ms": p.WindowMs, "capacity": p.Capacity, "cost": p.Cost} {
+		if v != 0 {
+			ext[name] = strconv.FormatInt(v, 10)
+		}
+	}
+	for name, v := range map[string]float64{"refill_per_sec": p.RefillPerSec, "leak_per_sec": p.LeakPerSec} {
+		if v != 0 {
+			ext[name] = strconv.FormatFloat(v, 'g', -1, 64)
+		}
+	}
+	return ext
+}
+
+func (t *grpcTarget) Check(ctx context.Context, idx int) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(t.frames[idx]))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	answer, err := extauthz.ReadResponse(resp, 1<<20)
+	var failed *extauthz.StatusError
+	switch {
+	case errors.As(err, &failed):
+		return extauthz.StatusForCode(failed.Code), nil
+	case err != nil:
+		return 0, err
+	case !answer.Allowed:
+		return answer.Status, nil
+	}
+	return http.StatusOK, nil
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"rate-limiter-service/internal/backend"
+)
+
+// workload is one stream of traffic: a request shape, its key space and its
+// offered load. A plain invocation runs a single workload built from flags;
+// a scenario file runs several side by side.
+type workload struct {
+	Name        string  `yaml:"name"`
+	URL         string  `yaml:"url"`
+	Protocol    string  `yaml:"protocol"`
+	QPS         int     `yaml:"qps"`
+	Concurrency int     `yaml:"concurrency"`
+	Keys        int     `yaml:"keys"`
+	Dist        string  `yaml:"dist"`
+	ZipfS       float64 `yaml:"zipf_s"`
+	ZipfV       float64 `yaml:"zipf_v"`
+	Ramp        string  `yaml:"ramp"`
+	RampTo      int     `yaml:"ramp_to"`
+	RampSteps   int     `yaml:"ramp_steps"`
+	Request     payload `yaml:"request"`
+}
+
+// scenario is the file format accepted by -scenario. Top-level url and
+// protocol apply to workloads that do not set their own.
+type scenario struct {
+	URL       string        `yaml:"url"`
+	Protocol  string        `yaml:"protocol"`
+	Duration  time.Duration `yaml:"duration"`
+	Warmup    time.Duration `yaml:"warmup"`
+	Workloads []workload    `yaml:"workloads"`
+}
+
+func loadScenario(path string, defaults workload, duration, warmup time.Duration) (scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return scenario{}, err
+	}
+	sc := scenario{Duration: duration, Warmup: warmup}
+	if err := yaml.Unmarshal(data, &sc); err != nil {
+		return scenario{}, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if len(sc.Workloads) == 0 {
+		return scenario{}, fmt.Errorf("%s: no workloads defined", path)
+	}
+	if sc.URL != "" {
+		defaults.URL = sc.URL
+	}
+	if sc.Protocol != "" {
+		defaults.Protocol = sc.Protocol
+	}
+	for i := range sc.Workloads {
+		w := &sc.Workloads[i]
+		if w.Name == "" {
+			w.Name = fmt.Sprintf("workload-%d", i)
+		}
+		w.applyDefaults(defaults)
+	}
+	return sc, nil
+}
+
+// applyDefaults fills every unset field from d, except the request, which
+// a scenario must spell out in full.
+func (w *workload) applyDefaults(d workload) {
+	if w.URL == "" {
+		w.URL = d.URL
+	}
+	if w.Protocol == "" {
+		w.Protocol = d.Protocol
+	}
+	if w.QPS == 0 {
+		w.QPS = d.QPS
+	}
+	if w.Concurrency == 0 {
+		w.Concurrency = d.Concurrency
+	}
+	if w.Keys == 0 {
+		w.Keys = d.Keys
+	}
+	if w.Dist == "" {
+		w.Dist = d.Dist
+	}
+	if w.ZipfS == 0 {
+		w.ZipfS = d.ZipfS
+	}
+	if w.ZipfV == 0 {
+		w.ZipfV = d.ZipfV
+	}
+	if w.Ramp == "" {
+		w.Ramp = d.Ramp
+	}
+	if w.RampSteps == 0 {
+		w.RampSteps = d.RampSteps
+	}
+	if w.Request.Cost == 0 {
+		w.Request.Cost = 1
+	}
+}
+
+// preparedWorkload holds everything built ahead of the run so that setup
+// errors surface before any traffic is sent.
+type preparedWorkload struct {
+	workload
+	profile profile
+	target  target
+	pickers []keyPicker
+}
+
+func (w workload) prepare(duration time.Duration, seed int64, store backend.Backend) (*preparedWorkload, error) {
+	prof, err := newProfile(w.Ramp, float64(max(1, w.QPS)), float64(w.RampTo), w.RampSteps, duration)
+	if err != nil {
+		return nil, err
+	}
+	keys := max(1, w.Keys)
+	payloads := make([]payload, keys)
+	for i := range payloads {
+		payloads[i] = w.Request
+		if keys > 1 {
+			payloads[i] = withKeyIndex(w.Request, i)
+		}
+	}
+	tgt, err := newTarget(w, payloads, store)
+	if err != nil {
+		return nil, err
+	}
+	pickers := make([]keyPicker, max(1, w.Concurrency))
+	for i := range pickers {
+		picker, err := newKeyPicker(w.Dist, keys, w.ZipfS, w.ZipfV, seed+int64(i))
+		if err != nil {
+			return nil, err
+		}
+		pickers[i] = picker
+	}
+	return &preparedWorkload{workload: w, profile: prof, target: tgt, pickers: pickers}, nil
+}
+
+func (p *preparedWorkload) run(ctx context.Context, runStart time.Time, warmup, interval time.Duration) report {
+	st := newStats(runStart.Add(warmup), p.profile)
+	jobs := make(chan time.Time)
+	go schedule(ctx, runStart, warmup, p.profile, jobs)
+	if interval > 0 {
+		go p.progress(ctx, st, runStart, warmup, interval)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(len(p.pickers))
+	for _, picker := range p.pickers {
+		go func(picker keyPicker) {
+			defer wg.Done()
+			for scheduled := range jobs {
+				sent := time.Now()
+				status, err := p.target.Check(context.Background(), picker.Next())
+				if err != nil {
+					st.recordError(scheduled)
+					continue
+				}
+				st.recordResponse(scheduled, sent, time.Now(), status)
+			}
+		}(picker)
+	}
+	wg.Wait()
+
+	rep := st.report()
+	rep.Name = p.Name
+	rep.ConfiguredAllowQPS = configuredAllowQPS(p.Request, max(1, p.Keys))
+	return rep
+}
+
+// progress prints one line per interval to stderr, keeping stdout clean for
+// the final report, until ctx is done.
+func (p *preparedWorkload) progress(ctx context.Context, st *stats, runStart time.Time, warmup, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	last := runStart
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			iv := st.takeInterval()
+			elapsed := now.Sub(last).Seconds()
+			phase := ""
+			if last.Sub(runStart) < warmup {
+				phase = " (warmup)"
+			}
+			last = now
+			label := ""
+			if p.Name != "" {
+				label = "[" + p.Name + "] "
+			}
+			var deniedPct, failedPct float64
+			if iv.total > 0 {
+				deniedPct = 100 * float64(iv.denied) / float64(iv.total)
+				failedPct = 100 * float64(iv.failed) / float64(iv.total)
+			}
+			fmt.Fprintf(os.Stderr, "%st=%s qps=%.1f denied=%.1f%% errors=%.1f%% p99=%s%s\n",
+				label,
+				now.Sub(runStart).Round(time.Second),
+				float64(iv.total)/elapsed,
+				deniedPct,
+				failedPct,
+				iv.latency.Percentile(0.99),
+				phase,
+			)
+		}
+	}
+}
+
+// configuredAllowQPS is the sustained rate the limit admits per key times
+// the number of keys, assuming every key receives enough traffic to hit it.
+func configuredAllowQPS(req payload, keys int) float64 {
+	cost := float64(max(1, int(req.Cost)))
+	var perKey float64
+	switch req.Algorithm {
+	case "token_bucket":
+		perKey = req.RefillPerSec / cost
+	case "leaky_bucket":
+		perKey = req.LeakPerSec / cost
+	case "fixed_window", "sliding_window_log", "sliding_window_counter", "count_min_sketch":
+		if req.WindowMs > 0 {
+			perKey = float64(req.Limit) / cost / (float64(req.WindowMs) / 1000)
+		}
+	}
+	return perKey * float64(keys)
+}
@@ -0,0 +1,455 @@
+// Command limitctl calls the rate limiter's API for operators: checking,
+// peeking at, inspecting, adjusting and resetting keys, counting keys and
+// listing the busiest, reading policies, tenant usage and the audit log,
+// exporting and importing limiter state, and reloading the configuration.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	httpapi "rate-limiter-service/internal/http"
+)
+
+const usage = `usage: limitctl [-profile name] <command> [flags]
+
+commands:
+  check     run a check, charging for it
+  peek      show what a check would get, without charging for it
+  inspect   show what the backend stores for a key
+  adjust    grant or take away quota for a key's current period
+  reset     forget a key's state so it starts afresh
+  keys      count the keys each algorithm tracks
+  top       list the keys checked most often lately
+  multiply  scale a key's or prefix's limits for a while, or list or remove such multipliers
+  usage     show a tenant's usage: limitctl usage [flags] <tenant>
+  history   show a key's usage: limitctl history [flags] <key>
+  policies  show the global policy and each tenant's
+  audit     list admin actions
+  reload    reload the server's configuration
+  export    write the limiter state as JSON lines: limitctl export [flags] [file]
+  import    load exported state: limitctl import [file]
+  profiles  list the configured profiles
+
+Profiles are read from $LIMITCTL_CONFIG, else limitctl/config.yaml in the
+user config directory. LIMITCTL_PROFILE, LIMITCTL_URL and LIMITCTL_API_KEY
+override it. Run "limitctl <command> -h" for a command's flags.
+`
+
+func main() {
+	global := flag.NewFlagSet("limitctl", flag.ExitOnError)
+	global.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	profileName := global.String("profile", "", "profile to use (default: the current one)")
+	global.Parse(os.Args[1:])
+	if global.NArg() == 0 {
+		global.Usage()
+		os.Exit(2)
+	}
+
+	file, err := loadProfiles(configPath())
+	if err != nil {
+		fail(err)
+	}
+	command, args := global.Arg(0), global.Args()[1:]
+	if command == "profiles" {
+		for _, name := range file.names() {
+			marker := " "
+			if name == file.Current {
+				marker = "*"
+			}
+			fmt.Printf("%s %s\t%s\n", marker, name, file.Profiles[name].URL)
+		}
+		return
+	}
+	p, err := file.resolve(*profileName)
+	if err != nil {
+		fail(err)
+	}
+	client, apiKey, err := p.client()
+	if err != nil {
+		fail(err)
+	}
+	c := &caller{client: client, base: p.URL, apiKey: apiKey}
+
+	switch command {
+	case "check", "peek":
+		os.Exit(runCheck(c, command, args, p.Tenant))
+	case "inspect":
+		os.Exit(runInspect(c, args, p.Tenant))
+	case "adjust":
+		os.Exit(runAdjust(c, args, p.Tenant))
+	case "reset":
+		os.Exit(runReset(c, args, p.Tenant))
+	case "keys":
+		os.Exit(c.do(http.MethodGet, "/v1/admin/keys/count", nil, nil))
+	case "top":
+		os.Exit(runTop(c, args, p.Tenant))
+	case "policies":
+		os.Exit(runPolicies(c, args, p.Tenant))
+	case "multiply":
+		os.Exit(runMultiply(c, args, p.Tenant))
+	case "usage":
+		os.Exit(runUsage(c, args))
+	case "history":
+		os.Exit(runHistory(c, args, p.Tenant))
+	case "audit":
+		os.Exit(runAudit(c, args))
+	case "reload":
+		os.Exit(c.do(http.MethodPost, "/v1/admin/reload", nil, nil))
+	case "export", "import":
+		// State moves in one request however many keys there are.
+		c.client.Timeout = 0
+		if command == "export" {
+			os.Exit(runExport(c, args))
+		}
+		os.Exit(runImport(c, args))
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", command)
+		global.Usage()
+		os.Exit(2)
+	}
+}
+
+func runCheck(c *caller, command string, args []string, tenant string) int {
+	fs := flag.NewFlagSet(command, flag.ExitOnError)
+	var req httpapi.CheckRequest
+	requestFlags(fs, &req, tenant)
+	fs.Int64Var(&req.Cost, "cost", 0, "cost of the check (default 1)")
+	method := fs.String("method", "", "method of the request to price by the cost map, instead of -cost")
+	path := fs.String("path", "", "path of the request to price by the cost map, instead of -cost")
+	consistency := fs.String("consistency", "", "peek only: strong reads from the Redis primary")
+	fs.Parse(args)
+	if *method != "" || *path != "" {
+		req.Request = &httpapi.RequestDescriptor{Method: *method, Path: *path}
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		fail(err)
+	}
+	query := url.Values{}
+	if *consistency != "" {
+		query.Set("consistency", *consistency)
+	}
+	// A denied check is an answer, not an error.
+	return c.do(http.MethodPost, "/v1/limit/"+command, query, body, http.StatusTooManyRequests)
+}
+
+func runAdjust(c *caller, args []string, tenant string) int {
+	fs := flag.NewFlagSet("adjust", flag.ExitOnError)
+	var req httpapi.AdjustRequest
+	requestFlags(fs, &req.CheckRequest, tenant)
+	fs.Int64Var(&req.Amount, "amount", 0, "cost to grant back, or to take away when negative")
+	fs.StringVar(&req.Reason, "reason", "", "why, for the audit log")
+	fs.Parse(args)
+	body, err := json.Marshal(req)
+	if err != nil {
+		fail(err)
+	}
+	return c.do(http.MethodPost, "/v1/admin/keys/adjust", nil, body)
+}
+
+func runReset(c *caller, args []string, tenant string) int {
+	fs := flag.NewFlagSet("reset", flag.ExitOnError)
+	var req httpapi.ResetRequest
+	fs.StringVar(&req.Key, "key", "", "rate limit key")
+	fs.StringVar(&req.Tenant, "tenant", tenant, "tenant owning the key")
+	fs.StringVar(&req.UserID, "user_id", "", "user id key")
+	fs.StringVar(&req.DeviceID, "device_id", "", "device id key")
+	fs.Func("dimension", "also reset this dimension of the key; repeat for more", func(v string) error {
+		req.Dimensions = append(req.Dimensions, v)
+		return nil
+	})
+	fs.StringVar(&req.Reason, "reason", "", "why, for the audit log")
+	fs.Parse(args)
+	body, err := json.Marshal(req)
+	if err != nil {
+		fail(err)
+	}
+	return c.do(http.MethodPost, "/v1/admin/keys/reset", nil, body)
+}
+
+func runMultiply(c *caller, args []string, tenant string) int {
+	fs := flag.NewFlagSet("multiply", flag.ExitOnError)
+	var req httpapi.MultiplierRequest
+	fs.StringVar(&req.Key, "key", "", "rate limit key")
+	fs.StringVar(&req.Tenant, "tenant", tenant, "tenant owning the key")
+	fs.StringVar(&req.UserID, "user_id", "", "user id key")
+	fs.StringVar(&req.DeviceID, "device_id", "", "device id key")
+	fs.StringVar(&req.Prefix, "prefix", "", "scale every key starting with this instead of one key")
+	fs.Float64Var(&req.Factor, "factor", 0, "what to multiply limits by")
+	fs.Int64Var(&req.TTLMs, "ttl_ms", 0, "how long the multiplier lasts")
+	fs.StringVar(&req.Reason, "reason", "", "why, for the audit log")
+	list := fs.Bool("list", false, "list the multipliers in force")
+	remove := fs.Bool("delete", false, "remove the multiplier")
+	fs.Parse(args)
+	if *list {
+		return c.do(http.MethodGet, "/v1/admin/multipliers", nil, nil)
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		fail(err)
+	}
+	if *remove {
+		return c.do(http.MethodDelete, "/v1/admin/multipliers", nil, body)
+	}
+	return c.do(http.MethodPost, "/v1/admin/multipliers", nil, body)
+}
+
+// requestFlags binds the flags naming a key and its limit to req.
+func requestFlags(fs *flag.FlagSet, req *httpapi.CheckRequest, tenant string) {
+	fs.StringVar(&req.Key, "key", "", "rate limit key")
+	fs.StringVar(&req.Tenant, "tenant", tenant, "tenant owning the key")
+	fs.StringVar(&req.UserID, "user_id", "", "user id key")
+	fs.StringVar(&req.DeviceID, "device_id", "", "device id key")
+	fs.StringVar(&req.JWT, "jwt", "", "jwt token key")
+	fs.StringVar(&req.Algorithm, "algorithm", "", "algorithm")
+	fs.Int64Var(&req.Limit, "limit", 0, "limit for window algorithms")
+	fs.Int64Var(&req.WindowMs, "window_ms", 0, "window size in ms")
+	fs.Int64Var(&req.Capacity, "capacity", 0, "capacity for bucket algorithms")
+	fs.Float64Var(&req.RefillPerSec, "refill_per_sec", 0, "refill per sec (token bucket)")
+	fs.Float64Var(&req.LeakPerSec, "leak_per_sec", 0, "leak per sec (leaky bucket)")
+	fs.Int64Var(&req.MaxDebt, "max_debt", 0, "how far the bucket may go below empty (token bucket)")
+	fs.StringVar(&req.Timezone, "timezone", "", "align a fixed window with this zone's midnight")
+	fs.StringVar(&req.Client, "client", "", "client sharing the key's pool (weighted fair share)")
+	fs.Int64Var(&req.Weight, "weight", 0, "the client's weight (weighted fair share)")
+	fs.IntVar(&req.RecentHits, "recent_hits", 0, "list up to this many of the key's latest hits (sliding window log)")
+	fs.StringVar(&req.Group, "group", "", "also charge the check to this configured budget group")
+	fs.StringVar(&req.Domain, "domain", "", "take the key and limit from this configured descriptor domain")
+	fs.Func("descriptor", "descriptor entry key=value for -domain; repeat in order", func(v string) error {
+		key, value, _ := strings.Cut(v, "=")
+		req.Descriptors = append(req.Descriptors, httpapi.DescriptorEntry{Key: key, Value: value})
+		return nil
+	})
+}
+
+func runInspect(c *caller, args []string, tenant string) int {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	query := url.Values{}
+	queryFlag(fs, query, "key", "rate limit key")
+	queryFlag(fs, query, "user_id", "user id key")
+	queryFlag(fs, query, "device_id", "device id key")
+	queryFlag(fs, query, "consistency", "strong reads from the Redis primary")
+	fs.Func("tenant", "tenant owning the key", func(v string) error {
+		tenant = v
+		return nil
+	})
+	fs.Parse(args)
+	if tenant != "" {
+		query.Set("tenant", tenant)
+	}
+	return c.do(http.MethodGet, "/v1/admin/keys/inspect", query, nil)
+}
+
+func runTop(c *caller, args []string, tenant string) int {
+	fs := flag.NewFlagSet("top", flag.ExitOnError)
+	query := url.Values{}
+	queryFlag(fs, query, "limit", "keys to list (default 20)")
+	fs.StringVar(&tenant, "tenant", tenant, "only this tenant's keys")
+	fs.Parse(args)
+	if tenant != "" {
+		query.Set("tenant", tenant)
+	}
+	return c.do(http.MethodGet, "/v1/admin/keys/top", query, nil)
+}
+
+func runPolicies(c *caller, args []string, tenant string) int {
+	fs := flag.NewFlagSet("policies", flag.ExitOnError)
+	fs.StringVar(&tenant, "tenant", tenant, "only this tenant's policy")
+	fs.Parse(args)
+	query := url.Values{}
+	if tenant != "" {
+		query.Set("tenant", tenant)
+	}
+	return c.do(http.MethodGet, "/v1/admin/policies", query, nil)
+}
+
+func runUsage(c *caller, args []string) int {
+	fs := flag.NewFlagSet("usage", flag.ExitOnError)
+	query := url.Values{}
+	queryFlag(fs, query, "granularity", "hour or day (default hour)")
+	queryFlag(fs, query, "from_ms", "start of the report (default: 24 hours or 30 days ago)")
+	queryFlag(fs, query, "to_ms", "end of the report (default: now)")
+	queryFlag(fs, query, "consistency", "strong reads from the Redis primary")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: limitctl usage [flags] <tenant>")
+		return 2
+	}
+	return c.do(http.MethodGet, "/v1/admin/tenants/"+url.PathEscape(fs.Arg(0))+"/usage", query, nil)
+}
+
+func runHistory(c *caller, args []string, tenant string) int {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	query := url.Values{}
+	queryFlag(fs, query, "granularity", "hour or day (default hour)")
+	queryFlag(fs, query, "from_ms", "start of the report (default: 24 hours or 30 days ago)")
+	queryFlag(fs, query, "to_ms", "end of the report (default: now)")
+	queryFlag(fs, query, "consistency", "strong reads from the Redis primary")
+	fs.StringVar(&tenant, "tenant", tenant, "tenant owning the key")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: limitctl history [flags] <key>")
+		return 2
+	}
+	if tenant != "" {
+		query.Set("tenant", tenant)
+	}
+	return c.do(http.MethodGet, "/v1/admin/keys/"+url.PathEscape(fs.Arg(0))+"/history", query, nil)
+}
+
+func runAudit(c *caller, args []string) int {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	query := url.Values{}
+	queryFlag(fs, query, "actor", "only actions by this actor")
+	queryFlag(fs, query, "action", "only this action")
+	queryFlag(fs, query, "tenant", "only actions on this tenant")
+	queryFlag(fs, query, "since_ms", "only actions at or after this time")
+	queryFlag(fs, query, "limit", "most recent entries to return (default 100)")
+	fs.Parse(args)
+	return c.do(http.MethodGet, "/v1/admin/audit", query, nil)
+}
+
+// runExport streams the state to a file, or to stdout, as it arrives; an
+// export the server cuts short fails rather than leave a partial file
+// looking complete.
+func runExport(c *caller, args []string) int {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	query := url.Values{}
+	queryFlag(fs, query, "tenant", "only this tenant's keys")
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		fmt.Fprintln(os.Stderr, "usage: limitctl export [flags] [file]")
+		return 2
+	}
+	resp := c.send(http.MethodGet, "/v1/admin/state", query, nil)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return c.report(http.MethodGet, "/v1/admin/state", resp)
+	}
+	out := os.Stdout
+	if fs.NArg() == 1 {
+		f, err := os.Create(fs.Arg(0))
+		if err != nil {
+			fail(err)
+		}
+		defer f.Close()
+		out = f
+	}
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		fail(fmt.Errorf("export cut short: %w", err))
+	}
+	if err := out.Sync(); err != nil && out != os.Stdout {
+		fail(err)
+	}
+	return 0
+}
+
+func runImport(c *caller, args []string) int {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		fmt.Fprintln(os.Stderr, "usage: limitctl import [file]")
+		return 2
+	}
+	var in io.Reader = os.Stdin
+	if fs.NArg() == 1 {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			fail(err)
+		}
+		defer f.Close()
+		in = f
+	}
+	resp := c.send(http.MethodPost, "/v1/admin/state", nil, in)
+	defer resp.Body.Close()
+	return c.report(http.MethodPost, "/v1/admin/state", resp)
+}
+
+// queryFlag defines a flag that sets the query parameter of the same name
+// when given.
+func queryFlag(fs *flag.FlagSet, query url.Values, name, help string) {
+	fs.Func(name, help, func(v string) error {
+		query.Set(name, v)
+		return nil
+	})
+}
+
+type caller struct {
+	client *http.Client
+	base   string
+	apiKey string
+}
+
+// do sends one request and prints the response, indented, to stdout when
+// it succeeded or its status is one of ok, and to stderr otherwise. It
+// returns the exit code.
+func (c *caller) do(method, path string, query url.Values, body []byte, ok ...int) int {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	resp := c.send(method, path, query, reader)
+	defer resp.Body.Close()
+	return c.report(method, path, resp, ok...)
+}
+
+// send makes a request, sending body as JSON when it is not nil.
+func (c *caller) send(method, path string, query url.Values, body io.Reader) *http.Response {
+	target := c.base + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, target, body)
+	if err != nil {
+		fail(err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "ApiKey "+c.apiKey)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		fail(err)
+	}
+	return resp
+}
+
+// report prints resp as do describes and returns the exit code.
+func (c *caller) report(method, path string, resp *http.Response, ok ...int) int {
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		fail(err)
+	}
+	data = bytes.TrimSpace(data)
+	var out bytes.Buffer
+	if json.Indent(&out, data, "", "  ") != nil {
+		out.Reset()
+		out.Write(data)
+	}
+	out.WriteByte('\n')
+
+	success := resp.StatusCode >= 200 && resp.StatusCode < 300
+	for _, status := range ok {
+		success = success || resp.StatusCode == status
+	}
+	if !success {
+		fmt.Fprintf(os.Stderr, "%s %s: %s\n%s", method, path, resp.Status, out.Bytes())
+		return 1
+	}
+	os.Stdout.Write(out.Bytes())
+	return 0
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "limitctl:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// profile says how to reach one deployment of the service.
+type profile struct {
+	URL    string `yaml:"url"`
+	APIKey string `yaml:"api_key"`
+	// APIKeyFile holds the key instead of APIKey.
+	APIKeyFile string `yaml:"api_key_file"`
+	// CAFile verifies the server's certificate when it is not signed by a
+	// system CA.
+	CAFile string `yaml:"ca_file"`
+	// Tenant is used by check and peek when -tenant is not given.
+	Tenant string `yaml:"tenant"`
+}
+
+type profileFile struct {
+	// Current is the profile used without -profile.
+	Current  string             `yaml:"current"`
+	Profiles map[string]profile `yaml:"profiles"`
+}
+
+// configPath is $LIMITCTL_CONFIG, else limitctl/config.yaml in the user's
+// config directory.
+func configPath() string {
+	if path := os.Getenv("LIMITCTL_CONFIG"); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "limitctl", "config.yaml")
+}
+
+// loadProfiles reads the profile file; a missing one holds no profiles.
+func loadProfiles(path string) (profileFile, error) {
+	var file profileFile
+	if path == "" {
+		return file, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return file, nil
+	}
+	if err != nil {
+		return file, err
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return file, fmt.Errorf("%s: %w", path, err)
+	}
+	return file, nil
+}
+
+// resolve picks the named profile (or the current one) and applies the
+// LIMITCTL_URL and LIMITCTL_API_KEY overrides.
+func (f profileFile) resolve(name string) (profile, error) {
+	if name == "" {
+		name = os.Getenv("LIMITCTL_PROFILE")
+	}
+	if name == "" {
+		name = f.Current
+	}
+	var p profile
+	if name != "" {
+		var ok bool
+		if p, ok = f.Profiles[name]; !ok {
+			return p, fmt.Errorf("no profile %q in %s", name, configPath())
+		}
+	}
+	if url := os.Getenv("LIMITCTL_URL"); url != "" {
+		p.URL = url
+	}
+	if key := os.Getenv("LIMITCTL_API_KEY"); key != "" {
+		p.APIKey, p.APIKeyFile = key, ""
+	}
+	if p.URL == "" {
+		p.URL = "http://127.0.0.1:8080"
+	}
+	p.URL = strings.TrimRight(p.URL, "/")
+	return p, nil
+}
+
+func (f profileFile) names() []string {
+	names := make([]string, 0, len(f.Profiles))
+	for name := range f.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// client builds the HTTP client and API key for p.
+func (p profile) client() (*http.Client, string, error) {
+	key := p.APIKey
+	if p.APIKeyFile != "" {
+		data, err := os.ReadFile(p.APIKeyFile)
+		if err != nil {
+			return nil, "", err
+		}
+		key = strings.TrimSpace(string(data))
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	if p.CAFile != "" {
+		pem, err := os.ReadFile(p.CAFile)
+		if err != nil {
+			return nil, "", err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, "", fmt.Errorf("%s: no certificates found", p.CAFile)
+		}
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}
+	}
+	return client, key, nil
+}
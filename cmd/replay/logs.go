@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// entry is one parsed access log line: when it happened and its fields by
+// name, for building keys.
+type entry struct {
+	at     time.Time
+	fields map[string]string
+}
+
+// commonLine matches the common log format, with the referer and user
+// agent of the combined format when present.
+var commonLine = regexp.MustCompile(`^(\S+) (\S+) (\S+) \[([^\]]+)\] "(\S+) (\S+)(?: (\S+))?" (\d{3}) (\S+)(?: "([^"]*)" "([^"]*)")?`)
+
+const commonTime = "02/Jan/2006:15:04:05 -0700"
+
+var errUnparsed = errors.New("not an access log line")
+
+// parser turns one line into an entry.
+type parser func(line string) (entry, error)
+
+// newParser returns the parser for format. Fields of the common and
+// combined formats are named ip, ident, user, time, method, path,
+// protocol, status, bytes, referer and user_agent, plus route, the path
+// without its query. JSON lines are named by their top-level fields, and
+// their time is read from timeField.
+func newParser(format, timeField string) (parser, error) {
+	switch format {
+	case "common", "combined":
+		return parseCommon, nil
+	case "jsonl":
+		return func(line string) (entry, error) { return parseJSON(line, timeField) }, nil
+	case "auto":
+		return func(line string) (entry, error) {
+			if strings.HasPrefix(line, "{") {
+				return parseJSON(line, timeField)
+			}
+			return parseCommon(line)
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown -format %q", format)
+	}
+}
+
+func parseCommon(line string) (entry, error) {
+	m := commonLine.FindStringSubmatch(line)
+	if m == nil {
+		return entry{}, errUnparsed
+	}
+	at, err := time.Parse(commonTime, m[4])
+	if err != nil {
+		return entry{}, err
+	}
+	names := []string{"ip", "ident", "user", "time", "method", "path", "protocol", "status", "bytes", "referer", "user_agent"}
+	fields := make(map[string]string, len(names))
+	for i, name := range names {
+		fields[name] = m[i+1]
+	}
+	fields["route"], _, _ = strings.Cut(fields["path"], "?")
+	return entry{at: at, fields: fields}, nil
+}
+
+func parseJSON(line, timeField string) (entry, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &raw); err != nil {
+		return entry{}, err
+	}
+	fields := make(map[string]string, len(raw))
+	for name, v := range raw {
+		switch v := v.(type) {
+		case string:
+			fields[name] = v
+		case float64:
+			fields[name] = strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			fields[name] = strconv.FormatBool(v)
+		}
+	}
+	at, err := parseTime(fields[timeField])
+	if err != nil {
+		return entry{}, fmt.Errorf("%s: %w", timeField, err)
+	}
+	return entry{at: at, fields: fields}, nil
+}
+
+// parseTime reads RFC 3339, the common log format's time, or Unix time in
+// seconds or, when too large to be seconds, milliseconds.
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, errors.New("missing")
+	}
+	if n, err := strconv.ParseFloat(s, 64); err == nil {
+		if n > 1e11 {
+			return time.UnixMilli(int64(n)), nil
+		}
+		return time.UnixMilli(int64(n * 1000)), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(commonTime, s)
+}
+
+var placeholder = regexp.MustCompile(`\{([A-Za-z0-9_.-]+)\}`)
+
+// keyTemplate builds a key from an entry's fields, such as "{ip}" or
+// "{user}:{method}". It reports false when a field is missing or empty,
+// so such lines are skipped rather than lumped under one key.
+type keyTemplate string
+
+func (t keyTemplate) key(e entry) (string, bool) {
+	ok := true
+	key := placeholder.ReplaceAllStringFunc(string(t), func(m string) string {
+		v := e.fields[m[1:len(m)-1]]
+		if v == "" || v == "-" {
+			ok = false
+		}
+		return v
+	})
+	return key, ok
+}
@@ -0,0 +1,184 @@
+// Command replay sends the requests of an access log to the rate limiter,
+// at their original pace or scaled, and reports how many a policy would
+// have limited.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+)
+
+type payload struct {
+	Key          string  `json:"key"`
+	Tenant       string  `json:"tenant,omitempty"`
+	Algorithm    string  `json:"algorithm"`
+	Limit        int64   `json:"limit,omitempty"`
+	WindowMs     int64   `json:"window_ms,omitempty"`
+	Capacity     int64   `json:"capacity,omitempty"`
+	RefillPerSec float64 `json:"refill_per_sec,omitempty"`
+	LeakPerSec   float64 `json:"leak_per_sec,omitempty"`
+	Cost         int64   `json:"cost,omitempty"`
+}
+
+// job is one log line ready to send, with the time it is due.
+type job struct {
+	due  time.Time
+	body []byte
+	key  string
+}
+
+func main() {
+	policy := payload{Algorithm: "fixed_window", Limit: 60, WindowMs: 60000}
+	flag.StringVar(&policy.Algorithm, "algorithm", policy.Algorithm, "algorithm")
+	flag.Int64Var(&policy.Limit, "limit", policy.Limit, "limit for window algorithms")
+	flag.Int64Var(&policy.WindowMs, "window_ms", policy.WindowMs, "window size in ms")
+	flag.Int64Var(&policy.Capacity, "capacity", 0, "capacity for bucket algorithms")
+	flag.Float64Var(&policy.RefillPerSec, "refill_per_sec", 0, "refill per sec (token bucket)")
+	flag.Float64Var(&policy.LeakPerSec, "leak_per_sec", 0, "leak per sec (leaky bucket)")
+	flag.Int64Var(&policy.Cost, "cost", 1, "cost per request")
+	flag.StringVar(&policy.Tenant, "tenant", "", "tenant owning the keys")
+
+	var (
+		url         = flag.String("url", "http://127.0.0.1:8080/v1/limit/check", "target URL")
+		apiKey      = flag.String("api_key", os.Getenv("REPLAY_API_KEY"), "API key with the check role (default $REPLAY_API_KEY)")
+		format      = flag.String("format", "auto", "log format (auto|common|combined|jsonl)")
+		timeField   = flag.String("time_field", "time", "field holding the time of JSON lines")
+		template    = flag.String("key", "{ip}", "key built from each line's fields, e.g. {user}:{route}")
+		prefix      = flag.String("key_prefix", "replay:", "prepended to every key, so a replay does not touch live counters")
+		speed       = flag.Float64("speed", 1, "replay speed relative to the log; 0 sends as fast as possible")
+		concurrency = flag.Int("concurrency", 16, "maximum requests in flight")
+		top         = flag.Int("top", 10, "most limited keys to report")
+		output      = flag.String("output", "text", "result format (text|json)")
+	)
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: replay [flags] [access.log]  (reads stdin without a file)")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *output != "text" && *output != "json" {
+		fmt.Fprintf(os.Stderr, "unknown -output %q\n", *output)
+		os.Exit(2)
+	}
+	if *speed < 0 || *concurrency < 1 || flag.NArg() > 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	parse, err := newParser(*format, *timeField)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	in := io.Reader(os.Stdin)
+	if flag.NArg() == 1 {
+		f, err := os.Open(flag.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	// An interrupted replay still reports what it sent.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	client := &http.Client{
+		Timeout:   5 * time.Second,
+		Transport: &http.Transport{MaxIdleConns: *concurrency, MaxIdleConnsPerHost: *concurrency},
+	}
+	rep := newReport(*top)
+	jobs := make(chan job, *concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				rep.lag(time.Since(j.due))
+				status, err := send(ctx, client, *url, *apiKey, j.body)
+				rep.result(j.key, status, err)
+			}
+		}()
+	}
+
+	start := time.Now()
+	var first time.Time
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() && ctx.Err() == nil {
+		e, err := parse(scanner.Text())
+		if err != nil {
+			rep.skip()
+			continue
+		}
+		key, ok := keyTemplate(*template).key(e)
+		if !ok {
+			rep.skip()
+			continue
+		}
+		p := policy
+		p.Key = *prefix + key
+		body, err := json.Marshal(p)
+		if err != nil {
+			rep.skip()
+			continue
+		}
+		if first.IsZero() {
+			first = e.at
+		}
+		due := time.Now()
+		if *speed > 0 {
+			due = start.Add(time.Duration(float64(e.at.Sub(first)) / *speed))
+			// Lines logged out of order are sent as soon as they are read.
+			select {
+			case <-time.After(time.Until(due)):
+			case <-ctx.Done():
+			}
+		}
+		rep.span(e.at)
+		select {
+		case jobs <- job{due: due, body: body, key: key}:
+		case <-ctx.Done():
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+
+	if err := rep.write(os.Stdout, *output, time.Since(start)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func send(ctx context.Context, client *http.Client, url, apiKey string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		req.Header.Set("Authorization", "ApiKey "+apiKey)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	return resp.StatusCode, nil
+}
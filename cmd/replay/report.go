@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+type report struct {
+	mu sync.Mutex
+	// Skipped lines could not be parsed or lacked a field of the key.
+	Skipped int64 `json:"skipped"`
+	Sent    int64 `json:"sent"`
+	// Allowed are 2xx answers and Limited are 429s; Failed are other
+	// statuses and requests that got no response.
+	Allowed int64 `json:"allowed"`
+	Limited int64 `json:"limited"`
+	Failed  int64 `json:"failed"`
+	// LimitedFraction is limited / (allowed + limited).
+	LimitedFraction float64 `json:"limited_fraction"`
+	Keys            int     `json:"keys"`
+	LimitedKeys     int     `json:"limited_keys"`
+	// LogSpanSec is the time the replayed lines cover in the log, and
+	// DurationSec how long replaying them took.
+	LogSpanSec  float64 `json:"log_span_sec"`
+	DurationSec float64 `json:"duration_sec"`
+	// MaxLagMs is how far behind the log's pace a request was sent at worst.
+	MaxLagMs int64      `json:"max_lag_ms"`
+	Top      []keyCount `json:"top_limited,omitempty"`
+
+	top          int
+	first, last  time.Time
+	maxLag       time.Duration
+	perKey       map[string]*keyCount
+	firstFailure string
+}
+
+type keyCount struct {
+	Key     string `json:"key"`
+	Allowed int64  `json:"allowed"`
+	Limited int64  `json:"limited"`
+}
+
+func newReport(top int) *report {
+	return &report{top: top, perKey: make(map[string]*keyCount)}
+}
+
+func (r *report) skip() {
+	r.mu.Lock()
+	r.Skipped++
+	r.mu.Unlock()
+}
+
+// span widens the log time the replay covers to at.
+func (r *report) span(at time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.first.IsZero() || at.Before(r.first) {
+		r.first = at
+	}
+	if at.After(r.last) {
+		r.last = at
+	}
+}
+
+func (r *report) lag(d time.Duration) {
+	r.mu.Lock()
+	r.maxLag = max(r.maxLag, d)
+	r.mu.Unlock()
+}
+
+func (r *report) result(key string, status int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Sent++
+	k := r.perKey[key]
+	if k == nil {
+		k = &keyCount{Key: key}
+		r.perKey[key] = k
+	}
+	switch {
+	case err != nil:
+		r.Failed++
+		r.failure(err.Error())
+	case status >= 200 && status < 300:
+		r.Allowed++
+		k.Allowed++
+	case status == http.StatusTooManyRequests:
+		r.Limited++
+		k.Limited++
+	default:
+		r.Failed++
+		r.failure(fmt.Sprintf("status %d", status))
+	}
+}
+
+// failure keeps the first failure to show why requests failed.
+func (r *report) failure(reason string) {
+	if r.firstFailure == "" {
+		r.firstFailure = reason
+	}
+}
+
+func (r *report) finish(elapsed time.Duration) {
+	if decided := r.Allowed + r.Limited; decided > 0 {
+		r.LimitedFraction = float64(r.Limited) / float64(decided)
+	}
+	r.Keys = len(r.perKey)
+	var limited []keyCount
+	for _, k := range r.perKey {
+		if k.Limited > 0 {
+			limited = append(limited, *k)
+		}
+	}
+	r.LimitedKeys = len(limited)
+	sort.Slice(limited, func(i, j int) bool {
+		if limited[i].Limited != limited[j].Limited {
+			return limited[i].Limited > limited[j].Limited
+		}
+		return limited[i].Key < limited[j].Key
+	})
+	r.Top = limited[:min(r.top, len(limited))]
+	r.LogSpanSec = r.last.Sub(r.first).Seconds()
+	r.DurationSec = elapsed.Seconds()
+	r.MaxLagMs = r.maxLag.Milliseconds()
+}
+
+func (r *report) write(w io.Writer, format string, elapsed time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.finish(elapsed)
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	}
+	fmt.Fprintf(w, "lines:    %d sent, %d skipped\n", r.Sent, r.Skipped)
+	fmt.Fprintf(w, "limited:  %.2f%% (%d of %d decided), %d failed\n", r.LimitedFraction*100, r.Limited, r.Allowed+r.Limited, r.Failed)
+	fmt.Fprintf(w, "keys:     %d, %d of them limited at least once\n", r.Keys, r.LimitedKeys)
+	fmt.Fprintf(w, "time:     %.1fs of log replayed in %.1fs, at most %dms behind\n", r.LogSpanSec, r.DurationSec, r.MaxLagMs)
+	if r.Failed > 0 {
+		fmt.Fprintf(w, "failures: first was %s\n", r.firstFailure)
+	}
+	if len(r.Top) > 0 {
+		fmt.Fprintln(w, "most limited keys:")
+		for _, k := range r.Top {
+			fmt.Fprintf(w, "  %-40s %d limited, %d allowed\n", k.Key, k.Limited, k.Allowed)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"rate-limiter-service/internal/config"
+)
+
+// secretSettings are shown in audit entries only as changed or not.
+var secretSettings = map[string]bool{
+	"backend.redis.password":         true,
+	"backend.compare.redis.password": true,
+	"auth.keys":                      true,
+	"secrets.vault_token":            true,
+	"key_hashing.secret":             true,
+	"key_hashing.previous_secret":    true,
+	"replication.api_key":            true,
+	"gossip.api_key":                 true,
+	"cluster.api_key":                true,
+}
+
+func isSecret(path string) bool {
+	return secretSettings[path] ||
+		strings.HasPrefix(path, "tenants.list.") && strings.HasSuffix(path, ".backend.redis.password")
+}
+
+// configDiff returns the settings that differ between before and after,
+// keyed by their YAML path, with secrets redacted.
+func configDiff(before, after config.Config) (map[string]string, map[string]string) {
+	old, cur := flattenConfig(before), flattenConfig(after)
+	changedBefore, changedAfter := make(map[string]string), make(map[string]string)
+	note := func(path string) {
+		if old[path] == cur[path] {
+			return
+		}
+		changedBefore[path], changedAfter[path] = old[path], cur[path]
+		if isSecret(path) {
+			changedBefore[path], changedAfter[path] = "[redacted]", "[redacted]"
+		}
+	}
+	for path := range old {
+		note(path)
+	}
+	for path := range cur {
+		note(path)
+	}
+	return changedBefore, changedAfter
+}
+
+func flattenConfig(cfg config.Config) map[string]string {
+	out := make(map[string]string)
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return out
+	}
+	var tree map[string]interface{}
+	if err := yaml.Unmarshal(data, &tree); err != nil {
+		return out
+	}
+	var walk func(prefix string, node interface{})
+	walk = func(prefix string, node interface{}) {
+		switch node := node.(type) {
+		case map[string]interface{}:
+			for k, v := range node {
+				if prefix != "" {
+					k = prefix + "." + k
+				}
+				walk(k, v)
+			}
+			return
+		case []interface{}:
+			for i, v := range node {
+				walk(fmt.Sprintf("%s.%d", prefix, i), v)
+			}
+			return
+		}
+		out[prefix] = fmt.Sprint(node)
+	}
+	walk("", tree)
+	return out
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// listenFDsStart is the first descriptor systemd passes (SD_LISTEN_FDS_START).
+const listenFDsStart = 3
+
+// listen returns the socket systemd passed through socket activation, or a
+// new listener on addr when the process was started any other way. The
+// second result says which.
+func listen(addr string) (net.Listener, bool, error) {
+	ln, err := activatedListener()
+	if err != nil || ln != nil {
+		return ln, ln != nil, err
+	}
+	ln, err = net.Listen("tcp", addr)
+	return ln, false, err
+}
+
+// activatedListener follows sd_listen_fds(3): the descriptors are ours only
+// if LISTEN_PID names this process. The variables are cleared so children
+// do not mistake the sockets for their own.
+func activatedListener() (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, nil
+	}
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if n > 1 {
+		return nil, fmt.Errorf("socket activation: got %d sockets, want 1", n)
+	}
+
+	f := os.NewFile(uintptr(listenFDsStart), "LISTEN_FD_3")
+	defer f.Close()
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("socket activation: %w", err)
+	}
+	return ln, nil
+}
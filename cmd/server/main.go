@@ -2,67 +2,647 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
+	"rate-limiter-service/internal/audit"
 	"rate-limiter-service/internal/backend"
+	"rate-limiter-service/internal/cluster"
 	"rate-limiter-service/internal/config"
+	"rate-limiter-service/internal/gossip"
+	"rate-limiter-service/internal/hook"
 	httpapi "rate-limiter-service/internal/http"
+	"rate-limiter-service/internal/jwt"
+	"rate-limiter-service/internal/metrics"
+	"rate-limiter-service/internal/replication"
 )
 
 func main() {
-	cfg := config.Load()
+	loader := config.NewLoader(flag.CommandLine)
+	validateOnly := flag.Bool("validate-config", false, "load and validate the configuration, then exit")
+	flag.Parse()
 
-	var (
-		store backend.Backend
-		err   error
-	)
-
-	switch cfg.Backend {
-	case "redis":
-		store, err = backend.NewRedisBackend(cfg.RedisAddr, cfg.RedisPassword, cfg.RedisDB)
-	default:
-		store = backend.NewMemoryBackend()
+	cfg, err := loader.Load()
+	if *validateOnly {
+		if err == nil {
+			_, err = handlerOptions(cfg)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("configuration ok")
+		return
 	}
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+
+	store, err := openBackends(cfg)
 	if err != nil {
 		log.Fatalf("backend init failed: %v", err)
 	}
+	var clustered *cluster.Backend
+	if cfg.Cluster.Enabled() {
+		if clustered, err = newCluster(cfg.Cluster, store); err != nil {
+			log.Fatalf("cluster init failed: %v", err)
+		}
+		store = clustered
+	}
 	defer func() {
 		if err := store.Close(); err != nil {
 			log.Printf("backend close failed: %v", err)
 		}
 	}()
 
-	handler := httpapi.NewHandler(store)
+	opts, err := handlerOptions(cfg)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	auditLog, err := audit.Open(audit.Options{Path: cfg.Audit.File, MemoryEntries: cfg.Audit.MemoryEntries})
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	defer auditLog.Close()
+
+	handler := httpapi.NewHandler(store, opts)
+	handler.SetAuditLog(auditLog)
+	if compare := cfg.Backend.Compare; compare.Kind != "" {
+		candidate, err := openBackend(compare.Kind, compare.RedisConfig(cfg.Backend.Redis), cfg.Backend)
+		if err != nil {
+			log.Fatalf("compare backend init failed: %v", err)
+		}
+		defer candidate.Close()
+		handler.SetCompare(candidate, httpapi.CompareOptions{
+			Timeout:     millis(compare.TimeoutMs),
+			MaxInFlight: compare.MaxInFlight,
+			Recent:      compare.Recent,
+		})
+		log.Printf("comparing decisions with a %s candidate backend", compare.Kind)
+	}
+	if clustered != nil {
+		handler.SetCluster(clustered, cfg.Cluster.Mode == "redirect")
+	}
+	reloads := &reloader{loader: loader, current: cfg, handler: handler, audit: auditLog}
+	handler.OnReload(reloads.reload)
+	reloads.watchSignals()
+	if cfg.Secrets.RefreshIntervalMs > 0 {
+		go reloads.watchSecrets(millis(cfg.Secrets.RefreshIntervalMs))
+	}
+	go flushUsage(handler, millis(cfg.Usage.FlushIntervalMs))
+	go reconcileShadow(handler)
+	go writeBackNewKeys(handler)
+	go expireKeys(handler)
+	go refreshMultipliers(handler)
+	go followInvalidations(handler)
+	if cfg.Metrics.Pushing() {
+		go pushMetrics(handler, cfg.Metrics)
+	}
+	if peers := cfg.Replication.PeerList(); len(peers) > 0 {
+		rep := newReplicator(cfg.Replication, peers)
+		handler.SetReplicator(rep)
+		go rep.Run(context.Background())
+		log.Printf("replicating region %s to %d peer regions", cfg.Replication.Region, len(peers))
+	}
+	if cfg.Gossip.Enabled() {
+		node, rep := newGossip(cfg.Gossip)
+		handler.SetGossip(node)
+		handler.SetReplicator(rep)
+		go rep.Run(context.Background())
+		go node.Run(context.Background())
+	}
+	warmStart(cfg.Backend.WarmStart, handler, store)
+
 	server := &http.Server{
-		Addr:              ":" + cfg.Port,
+		Addr:              ":" + cfg.Server.Port,
 		Handler:           httpapi.Routes(handler),
-		ReadHeaderTimeout: 5 * time.Second,
+		ReadHeaderTimeout: millis(cfg.Server.HTTP.ReadHeaderTimeoutMs),
+		ReadTimeout:       millis(cfg.Server.HTTP.ReadTimeoutMs),
+		WriteTimeout:      millis(cfg.Server.HTTP.WriteTimeoutMs),
+		IdleTimeout:       millis(cfg.Server.HTTP.IdleTimeoutMs),
+		MaxHeaderBytes:    cfg.Server.HTTP.MaxHeaderBytes,
+		Protocols:         new(http.Protocols),
+	}
+	server.Protocols.SetHTTP1(true)
+	server.Protocols.SetHTTP2(true)
+	// Envoy reaches gRPC services such as ext_authz over HTTP/2, which
+	// plaintext listeners take with prior knowledge. Without ext_authz no
+	// client needs it, so it is left off.
+	server.Protocols.SetUnencryptedHTTP2(cfg.ExtAuthz.Enabled)
+	if cfg.Server.TLS.Enabled() {
+		certs, err := newCertReloader(cfg.Server.TLS)
+		if err != nil {
+			log.Fatalf("tls init failed: %v", err)
+		}
+		reloads.certs = certs
+		server.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: certs.GetCertificate,
+		}
+		if err := requireClientCerts(server.TLSConfig, cfg.Server.TLS); err != nil {
+			log.Fatalf("tls init failed: %v", err)
+		}
 	}
 
+	ln, activated, err := listen(server.Addr)
+	if err != nil {
+		log.Fatalf("listen failed: %v", err)
+	}
 	go func() {
-		log.Printf("rate limiter listening on :%s (backend=%s)", cfg.Port, cfg.Backend)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		scheme := "http"
+		serve := server.Serve
+		if server.TLSConfig != nil {
+			scheme = "https"
+			serve = func(ln net.Listener) error { return server.ServeTLS(ln, "", "") }
+		}
+		where := ":" + cfg.Server.Port
+		if activated {
+			where = ln.Addr().String() + " via socket activation"
+		}
+		log.Printf("rate limiter listening on %s (%s, backend=%s)", where, scheme, cfg.Backend.Kind)
+		if cfg.Chaos.Enabled {
+			log.Printf("chaos mode is on: checks get injected faults; never enable it in production")
+		}
+		if err := serve(ln); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("server error: %v", err)
 		}
 	}()
 
-	waitForShutdown(server)
+	stopMaintenance := startMaintenance(cfg, store)
+	waitForShutdown(server, handler, millis(cfg.Server.HTTP.ShutdownGraceMs))
+	stopMaintenance()
+	saveHotKeys(cfg.Backend.WarmStart, handler)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := handler.WriteBackNewKeys(ctx); err != nil {
+		log.Printf("new key write back failed: %v", err)
+	}
+	if err := handler.FlushUsage(ctx); err != nil {
+		log.Printf("usage flush failed: %v", err)
+	}
 }
 
-func waitForShutdown(server *http.Server) {
+// flushUsage writes tenant usage to the backend every interval.
+func flushUsage(handler *httpapi.Handler, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		if err := handler.FlushUsage(ctx); err != nil {
+			log.Printf("usage flush failed: %v", err)
+		}
+		cancel()
+	}
+}
+
+// expireKeys publishes key expiry events every second.
+func expireKeys(handler *httpapi.Handler) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		handler.ExpireKeys()
+	}
+}
+
+// followInvalidations applies the invalidations instances publish when
+// admin changes make copies of state stale, subscribing again a second
+// after the subscription fails. Invalidations published in between are
+// missed: shadows expire and multipliers are refreshed on their own.
+func followInvalidations(handler *httpapi.Handler) {
+	failing := false
+	for {
+		started := time.Now()
+		err := handler.FollowInvalidations(context.Background())
+		if errors.Is(err, backend.ErrInvalidationsUnsupported) {
+			return
+		}
+		// A subscription that lasted was working.
+		if time.Since(started) > 10*time.Second {
+			failing = false
+		}
+		if !failing {
+			log.Printf("invalidations subscription failed, retrying: %v", err)
+		}
+		failing = true
+		time.Sleep(time.Second)
+	}
+}
+
+// pushMetrics sends the metrics to the Pushgateway and remote-write
+// receiver configured, on their interval.
+func pushMetrics(handler *httpapi.Handler, cfg config.MetricsConfig) {
+	instance := cfg.Instance
+	if instance == "" {
+		instance, _ = os.Hostname()
+	}
+	pusher := metrics.NewPusher(handler.MetricsRegistry(), metrics.Options{
+		PushgatewayURL: strings.TrimSuffix(cfg.PushgatewayURL, "/"),
+		RemoteWriteURL: cfg.RemoteWriteURL,
+		Job:            cfg.Job,
+		Instance:       instance,
+	})
+	ticker := time.NewTicker(millis(cfg.PushIntervalMs))
+	defer ticker.Stop()
+	failing := false
+	for range ticker.C {
+		err := pusher.Push(context.Background())
+		if err != nil && !failing {
+			log.Printf("metrics push failed, retrying: %v", err)
+		} else if err == nil && failing {
+			log.Printf("metrics push recovered")
+		}
+		failing = err != nil
+	}
+}
+
+// refreshMultipliers picks up multipliers set on other instances every
+// second.
+func refreshMultipliers(handler *httpapi.Handler) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	failing := false
+	for ; ; <-ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		err := handler.RefreshMultipliers(ctx)
+		cancel()
+		if err != nil && !failing {
+			log.Printf("multiplier refresh failed, retrying: %v", err)
+		} else if err == nil && failing {
+			log.Printf("multiplier refresh recovered")
+		}
+		failing = err != nil
+	}
+}
+
+// reconcileShadow charges the backend for checks decided from the shadow
+// while it was failing, once it is back.
+func reconcileShadow(handler *httpapi.Handler) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	failing := false
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		err := handler.ReconcileShadow(ctx)
+		cancel()
+		if err != nil && !failing {
+			log.Printf("shadow reconcile failed, retrying: %v", err)
+		} else if err == nil && failing {
+			log.Printf("shadow reconciled with the backend")
+		}
+		failing = err != nil
+	}
+}
+
+// writeBackNewKeys charges the backend for checks on new keys allowed
+// without it, every 100ms.
+func writeBackNewKeys(handler *httpapi.Handler) {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	failing := false
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		err := handler.WriteBackNewKeys(ctx)
+		cancel()
+		if err != nil && !failing {
+			log.Printf("new key write back failed, retrying: %v", err)
+		} else if err == nil && failing {
+			log.Printf("new key write back recovered")
+		}
+		failing = err != nil
+	}
+}
+
+// openBackends connects the shared backend and, behind a router, those of
+// tenants that have their own.
+func openBackends(cfg config.Config) (backend.Backend, error) {
+	shared, err := openBackend(cfg.Backend.Kind, cfg.Backend.Redis, cfg.Backend)
+	if err != nil {
+		return nil, err
+	}
+	routes := make(map[string]backend.Backend)
+	for _, tenant := range cfg.Tenants.List {
+		if tenant.Backend.Kind == "" {
+			continue
+		}
+		b, err := openBackend(tenant.Backend.Kind, tenant.Backend.RedisConfig(cfg.Backend.Redis), cfg.Backend)
+		if err != nil {
+			backend.NewRouter(shared, routes).Close()
+			return nil, fmt.Errorf("tenant %s: %w", tenant.ID, err)
+		}
+		routes[httpapi.TenantKeyPrefix(tenant.ID)] = b
+	}
+	if len(routes) == 0 {
+		return shared, nil
+	}
+	return backend.NewRouter(shared, routes), nil
+}
+
+func responseHeaders(c config.HeadersConfig) httpapi.ResponseHeaders {
+	if !c.Enabled {
+		return httpapi.ResponseHeaders{Disabled: true}
+	}
+	headers := httpapi.HeaderNames(c.Prefix)
+	for key, name := range c.Names {
+		headers.Rename(key, name)
+	}
+	return headers
+}
+
+func denyBody(c config.DenyBodyConfig) httpapi.DenyBody {
+	return httpapi.DenyBody{Message: c.Message, DocumentationURL: c.DocumentationURL, UpgradeURL: c.UpgradeURL}
+}
+
+func gracePolicy(grace config.GraceConfig) httpapi.GracePolicy {
+	return httpapi.GracePolicy{Requests: grace.Requests, Duration: millis(grace.DurationMs), Period: millis(grace.PeriodMs)}
+}
+
+// openBackend opens a backend of kind, with the memory, sketch and state
+// TTL settings of shared.
+func openBackend(kind string, redis config.RedisConfig, shared config.BackendConfig) (backend.Backend, error) {
+	sketchOpts := backend.SketchOptions{Epsilon: shared.Sketch.Epsilon, Delta: shared.Sketch.Delta}
+	ttls := stateTTLs(shared.StateTTL)
+	if kind != "redis" {
+		return backend.NewMemoryBackend(backend.MemoryOptions{MaxLogEntries: shared.Memory.SlidingLogMaxEntries, Sketch: sketchOpts, StateTTL: ttls, MaxBytes: shared.Memory.MaxBytes}), nil
+	}
+	return backend.NewRedisBackend(backend.RedisOptions{
+		Addr:         redis.Addr,
+		ReplicaAddrs: redis.ReplicaAddrs,
+		Password:     redis.Password,
+		DB:           redis.DB,
+		KeyPrefix:    redis.KeyPrefix,
+		HashTags:     redis.HashTags,
+		ServerTime:   redis.ServerTime,
+		Sketch:       sketchOpts,
+		StateTTL:     ttls,
+	})
+}
+
+func stateTTLs(c config.StateTTLConfig) backend.StateTTLs {
+	ttls := backend.StateTTLs{Default: backend.StateTTL{Padding: millis(c.PaddingMs), Max: millis(c.MaxMs)}}
+	for name, ttl := range c.Algorithms {
+		if ttls.Algorithms == nil {
+			ttls.Algorithms = make(map[string]backend.StateTTL)
+		}
+		ttls.Algorithms[name] = backend.StateTTL{Padding: millis(ttl.PaddingMs), Max: millis(ttl.MaxMs)}
+	}
+	return ttls
+}
+
+// handlerOptions maps cfg onto the handler, reading the API keys and
+// descriptor files.
+func handlerOptions(cfg config.Config) (httpapi.Options, error) {
+	keys, err := cfg.Auth.LoadKeys()
+	if err != nil {
+		return httpapi.Options{}, err
+	}
+	signingKeys, err := cfg.Auth.LoadSigningKeys()
+	if err != nil {
+		return httpapi.Options{}, err
+	}
+	domains, err := cfg.Descriptors.Load()
+	if err != nil {
+		return httpapi.Options{}, err
+	}
+	opts := httpapi.Options{
+		BatchMaxItems:    cfg.Server.BatchMaxItems,
+		BatchConcurrency: cfg.Server.BatchConcurrency,
+		MaxBodyBytes:     cfg.Server.MaxBodyBytes,
+		StrictRequests:   cfg.Server.StrictRequests,
+		Headers:          responseHeaders(cfg.Server.Headers),
+		ErrorFormat:      cfg.Server.Errors.Format,
+		ErrorTypeBase:    cfg.Server.Errors.TypeBaseURL,
+		FailMode:         cfg.Backend.FailMode,
+		BackendTimeout:   millis(cfg.Backend.TimeoutMs),
+		ShadowTTL:        millis(cfg.Backend.ShadowTTLMs),
+		ShadowMaxKeys:    cfg.Backend.ShadowMaxKeys,
+		NewKeyFilterKeys: cfg.Backend.NewKeyFilterKeys,
+		MaxCost:          cfg.Policies.MaxCost,
+		MaxCapacity:      cfg.Policies.MaxCapacity,
+		MaxLimit:         cfg.Policies.MaxLimit,
+		MaxWindowMs:      cfg.Policies.MaxWindowMs,
+		MaxLogLimit:      cfg.Policies.MaxLogLimit,
+		Algorithms:       cfg.Policies.Algorithms,
+		Costs:            httpapi.CostMap(cfg.Policies.Costs),
+		RetryJitter:      cfg.Policies.RetryJitter,
+		DenyBody:         denyBody(cfg.Policies.DenyBody),
+		Grace:            gracePolicy(cfg.Policies.Grace),
+		APIKeys:          keys,
+		APIKeyHeader:     cfg.Auth.Header,
+		ClientRoles:      cfg.Auth.ClientRoles(),
+		AdminNetworks:    cfg.Auth.AdminNetworks(),
+		SignatureMaxSkew: millis(cfg.Auth.SignatureMaxSkewMs),
+		CheckCallerRate:  cfg.CallerLimits.CheckPerSec,
+		CheckCallerBurst: cfg.CallerLimits.CheckBurst,
+		AdminCallerRate:  cfg.CallerLimits.AdminPerSec,
+		AdminCallerBurst: cfg.CallerLimits.AdminBurst,
+		KeyHashSecret:    cfg.KeyHashing.Secret,
+		KeyHashPrevious:  cfg.KeyHashing.PreviousSecret,
+		KeyHashGrace:     millis(cfg.KeyHashing.GraceMs),
+		ReconcileSecret:  []byte(cfg.Reconcile.Secret),
+		ReconcileTTL:     millis(cfg.Reconcile.TTLMs),
+		RequireTenant:    cfg.Tenants.Require,
+		KnownTenantsOnly: cfg.Tenants.KnownOnly,
+		UsageRetention:   time.Duration(cfg.Usage.RetentionDays) * 24 * time.Hour,
+		KeyHistory:       cfg.Usage.KeyHistory,
+		HistoryRetention: time.Duration(cfg.Usage.KeyHistoryDays) * 24 * time.Hour,
+		KeyEvents:        cfg.Events.Enabled,
+		KeyEventsMaxKeys: cfg.Events.MaxKeys,
+		KeyEventsFormat:  cfg.Events.Format,
+		GraphQL:          cfg.GraphQL.Enabled,
+		Metrics:          cfg.Metrics.Enabled,
+		KeyCountInterval: millis(cfg.Metrics.KeyCountIntervalMs),
+	}
+	opts.CloudEventsSource, opts.CloudEventsTypePrefix = cfg.Events.CloudEventsSource, cfg.Events.CloudEventsTypePrefix
+	if opts.CloudEventsSource == "" {
+		host, _ := os.Hostname()
+		opts.CloudEventsSource = "urn:rate-limiter:" + host
+	}
+	opts.HotKeys = cfg.Server.TopKeys
+	if cfg.Backend.WarmStart.File != "" {
+		opts.HotKeys = max(opts.HotKeys, cfg.Backend.WarmStart.Keys)
+	}
+	if cfg.ExtAuthz.Enabled {
+		opts.ExtAuthz = &httpapi.ExtAuthzPolicy{Key: cfg.ExtAuthz.Key}
+		if err := json.Unmarshal([]byte(cfg.ExtAuthz.Check), &opts.ExtAuthz.Check); err != nil {
+			return httpapi.Options{}, fmt.Errorf("ext_authz.check: %w", err)
+		}
+	}
+	if cfg.Chaos.Enabled {
+		opts.Chaos = &httpapi.ChaosPolicy{
+			Latency:     millis(cfg.Chaos.LatencyMs),
+			LatencyRate: cfg.Chaos.LatencyRate,
+			ErrorRate:   cfg.Chaos.ErrorRate,
+			DenyRate:    cfg.Chaos.DenyRate,
+		}
+	}
+	if len(cfg.Tenants.List) > 0 {
+		opts.Tenants = make(map[string]httpapi.TenantPolicies, len(cfg.Tenants.List))
+		for _, tenant := range cfg.Tenants.List {
+			opts.Tenants[tenant.ID] = httpapi.TenantPolicies{
+				MaxCost:     tenant.Policies.MaxCost,
+				MaxCapacity: tenant.Policies.MaxCapacity,
+				MaxLimit:    tenant.Policies.MaxLimit,
+				MaxWindowMs: tenant.Policies.MaxWindowMs,
+				MaxLogLimit: tenant.Policies.MaxLogLimit,
+				Algorithms:  tenant.Policies.Algorithms,
+				Costs:       httpapi.CostMap(tenant.Policies.Costs),
+				Grace:       gracePolicy(tenant.Policies.Grace),
+				MaxKeys:     tenant.Keys.Max,
+				KeyIdle:     millis(tenant.Keys.IdleMs),
+				EvictKeys:   tenant.Keys.Overflow == "evict",
+				DenyBody:    denyBody(tenant.Policies.DenyBody),
+			}
+		}
+	}
+	if len(cfg.Groups) > 0 {
+		opts.Groups = make(map[string]httpapi.GroupBudget, len(cfg.Groups))
+		for _, group := range cfg.Groups {
+			opts.Groups[group.ID] = httpapi.GroupBudget{Limit: group.Limit, WindowMs: group.WindowMs}
+		}
+	}
+	if len(cfg.LimitPolicies) > 0 {
+		opts.LimitPolicies = make(map[string]httpapi.LimitPolicy, len(cfg.LimitPolicies))
+		for _, policy := range cfg.LimitPolicies {
+			limits := make([]httpapi.PolicyLimit, len(policy.Limits))
+			for i, l := range policy.Limits {
+				limits[i] = httpapi.PolicyLimit{Name: l.Name, Limit: l.Limit, WindowMs: l.WindowMs, Capacity: l.Capacity, RefillPerSec: l.RefillPerSec, LeakPerSec: l.LeakPerSec}
+			}
+			opts.LimitPolicies[policy.Name] = httpapi.LimitPolicy{Algorithm: policy.Algorithm, ShadowMode: policy.ShadowMode, Limits: limits}
+		}
+	}
+	if len(domains) > 0 {
+		opts.Descriptors = make(httpapi.DescriptorDomains, len(domains))
+		for _, domain := range domains {
+			opts.Descriptors[domain.Domain] = descriptors(domain.Descriptors)
+		}
+	}
+	if len(signingKeys) > 0 {
+		opts.SigningKeys = make(map[string]httpapi.SigningKey, len(signingKeys))
+		for id, key := range signingKeys {
+			opts.SigningKeys[id] = httpapi.SigningKey{Secret: []byte(key.Secret), Role: key.Role}
+		}
+	}
+	if cfg.Hook.URL != "" {
+		opts.Hook = hook.New(hook.Options{URL: cfg.Hook.URL, Timeout: millis(cfg.Hook.TimeoutMs)})
+		opts.HookSkipOnError = cfg.Hook.FailMode == "skip"
+	}
+	if cfg.JWT.Enabled() {
+		opts.JWTVerifier = jwt.NewVerifier(jwt.Options{
+			JWKSURL:         cfg.JWT.JWKSURL,
+			JWKSFile:        cfg.JWT.JWKSFile,
+			RefreshInterval: millis(cfg.JWT.RefreshIntervalMs),
+			Issuer:          cfg.JWT.Issuer,
+			Audience:        cfg.JWT.Audience,
+			Leeway:          millis(cfg.JWT.LeewayMs),
+		})
+	}
+	return opts, nil
+}
+
+// descriptors maps lyft/ratelimit descriptors onto fixed windows.
+func descriptors(list []config.Descriptor) []httpapi.Descriptor {
+	out := make([]httpapi.Descriptor, len(list))
+	for i, d := range list {
+		out[i] = httpapi.Descriptor{
+			Key:         d.Key,
+			Value:       d.Value,
+			ShadowMode:  d.ShadowMode,
+			Descriptors: descriptors(d.Descriptors),
+		}
+		if l := d.RateLimit; l != nil {
+			out[i].Limit, out[i].WindowMs, out[i].Unlimited = l.RequestsPerUnit, l.WindowMs(), l.Unlimited
+		}
+	}
+	return out
+}
+
+func millis(ms int) time.Duration {
+	return time.Duration(ms) * time.Millisecond
+}
+
+// waitForShutdown blocks until SIGINT or SIGTERM, then stops taking checks,
+// lets the ones in flight finish within grace and closes the listener. The
+// backend is closed by the caller once this returns, so no admitted check
+// loses its decision.
+func waitForShutdown(server *http.Server, handler *httpapi.Handler, grace time.Duration) {
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
 	<-stop
+	log.Printf("shutting down: draining in-flight checks")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
 	defer cancel()
 
+	if err := handler.Drain(ctx); err != nil {
+		log.Printf("drain incomplete: %v", err)
+	}
 	if err := server.Shutdown(ctx); err != nil {
 		log.Printf("graceful shutdown failed: %v", err)
 	}
 }
+
+func newReplicator(cfg config.ReplicationConfig, peers []config.NamedURL) *replication.Replicator {
+	opts := replication.Options{
+		Region:   cfg.Region,
+		Interval: millis(cfg.SyncIntervalMs),
+		MaxAge:   millis(cfg.MaxDeltaAgeMs),
+		APIKey:   cfg.APIKey,
+	}
+	for _, peer := range peers {
+		opts.Peers = append(opts.Peers, replication.Peer{Region: peer.Name, URL: peer.URL})
+	}
+	return replication.New(opts)
+}
+
+// newGossip returns the membership node and the replicator it keeps
+// pointed at the live members.
+func newGossip(cfg config.GossipConfig) (*gossip.Node, *replication.Replicator) {
+	name := cfg.Name
+	if name == "" {
+		name, _ = os.Hostname()
+	}
+	rep := replication.New(replication.Options{
+		Region:   name,
+		Interval: millis(cfg.SyncIntervalMs),
+		MaxAge:   millis(cfg.DeadAfterMs),
+		APIKey:   cfg.APIKey,
+	})
+	node := gossip.New(gossip.Options{
+		Name:       name,
+		URL:        strings.TrimRight(cfg.AdvertiseURL, "/"),
+		Seeds:      cfg.SeedList(),
+		Interval:   millis(cfg.IntervalMs),
+		Fanout:     cfg.Fanout,
+		DeadAfter:  millis(cfg.DeadAfterMs),
+		APIKey:     cfg.APIKey,
+		Replicator: rep,
+	})
+	log.Printf("gossiping as %s at %s", name, cfg.AdvertiseURL)
+	return node, rep
+}
+
+// newCluster spreads keys over the cluster members, keeping the ones this
+// instance owns in local.
+func newCluster(cfg config.ClusterConfig, local backend.Backend) (*cluster.Backend, error) {
+	opts := cluster.Options{Self: cfg.Self, VirtualNodes: cfg.VirtualNodes, APIKey: cfg.APIKey}
+	for _, m := range cfg.MemberList() {
+		opts.Members = append(opts.Members, cluster.Member{Name: m.Name, URL: m.URL})
+	}
+	c, err := cluster.New(local, opts)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("cluster member %s of %d (%s mode)", cfg.Self, len(opts.Members), cfg.Mode)
+	return c, nil
+}
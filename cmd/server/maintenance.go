@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"rate-limiter-service/internal/backend"
+	"rate-limiter-service/internal/config"
+	"rate-limiter-service/internal/leader"
+)
+
+// startMaintenance runs the background jobs on whichever instance holds
+// the maintenance lease, so they run once across instances sharing Redis.
+// It does nothing without a shared backend. The returned function stops
+// the jobs and gives the lease up.
+func startMaintenance(cfg config.Config, store backend.Backend) func() {
+	leaser, canLease := store.(backend.Leaser)
+	sweeper, canSweep := store.(backend.Sweeper)
+	if cfg.Maintenance.IntervalMs == 0 || cfg.Backend.Kind != "redis" || !canLease || !canSweep {
+		return func() {}
+	}
+	elector := leader.New(leaser, "maintenance", holderID(), millis(cfg.Maintenance.LeaseMs))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		elector.Run(ctx)
+	}()
+	go func() {
+		ticker := time.NewTicker(millis(cfg.Maintenance.IntervalMs))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if elector.IsLeader() {
+				sweepOrphans(ctx, sweeper, millis(cfg.Maintenance.OrphanTTLMs))
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func sweepOrphans(ctx context.Context, sweeper backend.Sweeper, ttl time.Duration) {
+	start := time.Now()
+	stats, err := sweeper.SweepOrphans(ctx, ttl)
+	if err != nil {
+		log.Printf("maintenance: orphan sweep failed: %v", err)
+	}
+	kinds := make([]string, 0, len(stats.Scanned))
+	for kind, n := range stats.Scanned {
+		kinds = append(kinds, fmt.Sprintf("%s=%d", kind, n))
+	}
+	sort.Strings(kinds)
+	log.Printf("maintenance: swept keys in %s (%s); expired %d without a TTL",
+		time.Since(start).Round(time.Millisecond), strings.Join(kinds, " "), stats.Orphans)
+}
+
+// holderID names this instance in the lease: the hostname and a random
+// suffix, so restarts and instances on one host differ.
+func holderID() string {
+	host, _ := os.Hostname()
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	return host + "/" + hex.EncodeToString(suffix)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"rate-limiter-service/internal/audit"
+	"rate-limiter-service/internal/config"
+	httpapi "rate-limiter-service/internal/http"
+)
+
+// reloader re-reads the configuration on SIGHUP or through the admin API.
+// Handler options are swapped in place; settings bound to the listener or
+// the backend connection only take effect after a restart.
+type reloader struct {
+	mu      sync.Mutex
+	loader  *config.Loader
+	current config.Config
+	handler *httpapi.Handler
+	certs   *certReloader
+	audit   *audit.Log
+}
+
+// Actors for reloads that no API caller asked for.
+const (
+	actorSIGHUP  = "signal:SIGHUP"
+	actorSecrets = "secrets-refresh"
+)
+
+// reload applies the current configuration and records it in the audit
+// log as done by actor.
+func (r *reloader) reload(actor string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	before := r.current
+	err := r.apply()
+	entry := audit.Entry{Actor: actor, Action: "config.reload"}
+	entry.Before, entry.After = configDiff(before, r.current)
+	if err != nil {
+		entry.Error = err.Error()
+	} else if actor == actorSecrets && len(entry.After) == 0 {
+		return nil
+	}
+	if auditErr := r.audit.Record(entry); auditErr != nil {
+		log.Printf("audit record failed: %v", auditErr)
+	}
+	return err
+}
+
+func (r *reloader) apply() error {
+	next, err := r.loader.Load()
+	if err != nil {
+		return err
+	}
+	opts, err := handlerOptions(next)
+	if err != nil {
+		return err
+	}
+	if next.Server.Port != r.current.Server.Port || next.Server.TLS != r.current.Server.TLS ||
+		next.Server.HTTP != r.current.Server.HTTP || next.Audit != r.current.Audit ||
+		next.Usage.FlushIntervalMs != r.current.Usage.FlushIntervalMs ||
+		next.Replication != r.current.Replication || next.Gossip != r.current.Gossip ||
+		next.Cluster != r.current.Cluster || next.Maintenance != r.current.Maintenance ||
+		next.Backend.Kind != r.current.Backend.Kind ||
+		next.Backend.Redis != r.current.Backend.Redis || next.Backend.Memory != r.current.Backend.Memory ||
+		next.Backend.Sketch != r.current.Backend.Sketch {
+		log.Printf("config reload: listener, audit log, usage flush, replication, gossip, cluster, maintenance and backend connection settings need a restart; keeping the running ones")
+		next.Server.Port = r.current.Server.Port
+		next.Server.TLS = r.current.Server.TLS
+		next.Server.HTTP = r.current.Server.HTTP
+		next.Audit = r.current.Audit
+		next.Usage.FlushIntervalMs = r.current.Usage.FlushIntervalMs
+		next.Replication = r.current.Replication
+		next.Gossip = r.current.Gossip
+		next.Cluster = r.current.Cluster
+		next.Maintenance = r.current.Maintenance
+		next.Backend.Kind = r.current.Backend.Kind
+		next.Backend.Redis = r.current.Backend.Redis
+		next.Backend.Memory = r.current.Backend.Memory
+		next.Backend.Sketch = r.current.Backend.Sketch
+	}
+	if keepTenantBackends(&next, r.current) {
+		log.Printf("config reload: tenant backends need a restart; keeping the running ones")
+	}
+	if r.certs != nil {
+		if err := r.certs.reload(); err != nil {
+			log.Printf("tls certificate reload failed: %v", err)
+		}
+	}
+	r.handler.SetOptions(opts)
+	r.current = next
+	log.Printf("configuration reloaded")
+	return nil
+}
+
+func (r *reloader) watchSignals() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := r.reload(actorSIGHUP); err != nil {
+				log.Printf("config reload failed: %v", err)
+			}
+		}
+	}()
+}
+
+// watchSecrets renews the Vault token and reloads the configuration, and
+// with it every secret, each interval.
+func (r *reloader) watchSecrets(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		r.mu.Lock()
+		secrets := r.current.Secrets
+		r.mu.Unlock()
+		if err := secrets.RenewVaultToken(); err != nil {
+			log.Printf("vault token renewal failed: %v", err)
+		}
+		if err := r.reload(actorSecrets); err != nil {
+			log.Printf("secret refresh failed: %v", err)
+		}
+	}
+}
+
+// keepTenantBackends gives each tenant in next the backend the server was
+// started with, and reports whether that undid any change.
+func keepTenantBackends(next *config.Config, current config.Config) bool {
+	running := make(map[string]config.TenantBackendConfig, len(current.Tenants.List))
+	for _, tenant := range current.Tenants.List {
+		running[tenant.ID] = tenant.Backend
+	}
+	changed := false
+	for i := range next.Tenants.List {
+		tenant := &next.Tenants.List[i]
+		if tenant.Backend != running[tenant.ID] {
+			tenant.Backend = running[tenant.ID]
+			changed = true
+		}
+		delete(running, tenant.ID)
+	}
+	// Tenants dropped from the list keep their routes until a restart too.
+	for _, b := range running {
+		if b.Kind != "" {
+			changed = true
+		}
+	}
+	return changed
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"rate-limiter-service/internal/config"
+)
+
+// certReloader serves the most recently loaded key pair so that rotated
+// certificates are picked up without dropping the listener.
+type certReloader struct {
+	mu       sync.Mutex
+	certFile string
+	keyFile  string
+	cert     *tls.Certificate
+	modTime  time.Time
+}
+
+func newCertReloader(cfg config.TLSConfig) (*certReloader, error) {
+	c := &certReloader{certFile: cfg.CertFile, keyFile: cfg.KeyFile}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	if cfg.ReloadIntervalMs > 0 {
+		go c.watch(time.Duration(cfg.ReloadIntervalMs) * time.Millisecond)
+	}
+	return c, nil
+}
+
+// reload loads the key pair again if either file changed since the last
+// load. A failed load keeps serving the previous certificate.
+func (c *certReloader) reload() error {
+	modTime, err := latestModTime(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cert != nil && !modTime.After(c.modTime) {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	if c.cert != nil {
+		log.Printf("tls certificate reloaded from %s", c.certFile)
+	}
+	c.cert = &cert
+	c.modTime = modTime
+	return nil
+}
+
+func (c *certReloader) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := c.reload(); err != nil {
+			log.Printf("tls certificate reload failed: %v", err)
+		}
+	}
+}
+
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cert, nil
+}
+
+// requireClientCerts pins client certificates to the CAs in
+// cfg.ClientCAFile, if set. The CA file is read once; changing it needs a
+// restart.
+func requireClientCerts(tlsCfg *tls.Config, cfg config.TLSConfig) error {
+	if cfg.ClientCAFile == "" {
+		return nil
+	}
+	pem, err := os.ReadFile(cfg.ClientCAFile)
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("%s: no PEM certificates found", cfg.ClientCAFile)
+	}
+	tlsCfg.ClientCAs = pool
+	tlsCfg.ClientAuth = tls.RequireAndVerifyClientCert
+	if cfg.ClientAuth == "optional" {
+		tlsCfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return nil
+}
+
+func latestModTime(paths ...string) (time.Time, error) {
+	var latest time.Time
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
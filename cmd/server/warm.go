@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+
+	"rate-limiter-service/internal/backend"
+	"rate-limiter-service/internal/config"
+	httpapi "rate-limiter-service/internal/http"
+)
+
+// warmStart preloads handler with the busiest keys, read from the file the
+// last shutdown wrote or found in store's state.
+func warmStart(cfg config.WarmStartConfig, handler *httpapi.Handler, store backend.Backend) {
+	if cfg.Keys <= 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), millis(cfg.TimeoutMs))
+	defer cancel()
+	var reqs []backend.Request
+	source := cfg.File
+	if cfg.File != "" {
+		data, err := os.ReadFile(cfg.File)
+		if errors.Is(err, os.ErrNotExist) {
+			return
+		}
+		if err == nil {
+			err = json.Unmarshal(data, &reqs)
+		}
+		if err != nil {
+			log.Printf("warm start failed: %v", err)
+			return
+		}
+		reqs = reqs[:min(len(reqs), cfg.Keys)]
+	} else {
+		states, ok := store.(backend.StateStore)
+		if !ok {
+			log.Printf("warm start skipped: the backend's state cannot be listed; set a warm start file")
+			return
+		}
+		var err error
+		if reqs, err = backend.HottestKeys(ctx, states, cfg.Keys); err != nil {
+			log.Printf("warm start failed: %v", err)
+			return
+		}
+		source = "the backend"
+	}
+	reported := handler.WarmStart(ctx, reqs)
+	log.Printf("warm start: preloaded %d keys from %s, %d with backend state", len(reqs), source, reported)
+}
+
+// saveHotKeys writes the busiest keys to the warm start file, for the
+// next start.
+func saveHotKeys(cfg config.WarmStartConfig, handler *httpapi.Handler) {
+	if cfg.Keys <= 0 || cfg.File == "" {
+		return
+	}
+	data, err := json.Marshal(handler.HotKeys(cfg.Keys))
+	if err == nil {
+		err = writeFileAtomic(cfg.File, data)
+	}
+	if err != nil {
+		log.Printf("saving hot keys failed: %v", err)
+	}
+}
+
+// writeFileAtomic replaces path with data, so a crash mid-write leaves the
+// old file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
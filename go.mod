@@ -1,8 +1,11 @@
 module rate-limiter-service
 
-go 1.22
+go 1.24
 
-require github.com/go-redis/redis/v8 v8.11.5
+require (
+	github.com/go-redis/redis/v8 v8.11.5
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	github.com/cespare/xxhash/v2 v2.1.2 // indirect
@@ -0,0 +1,151 @@
+// Package audit records admin actions for later attribution.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Entry is one admin action. Before and After hold only the values the
+// action changed, with secrets redacted.
+type Entry struct {
+	TimeMs int64             `json:"time_ms"`
+	Actor  string            `json:"actor"`
+	Action string            `json:"action"`
+	Tenant string            `json:"tenant,omitempty"`
+	Target string            `json:"target,omitempty"`
+	Before map[string]string `json:"before,omitempty"`
+	After  map[string]string `json:"after,omitempty"`
+	Error  string            `json:"error,omitempty"`
+}
+
+// Filter selects entries; zero fields match everything.
+type Filter struct {
+	Actor   string
+	Action  string
+	Tenant  string
+	SinceMs int64
+	// Limit keeps only the newest Limit matches.
+	Limit int
+}
+
+func (f Filter) match(e Entry) bool {
+	return (f.Actor == "" || e.Actor == f.Actor) &&
+		(f.Action == "" || e.Action == f.Action) &&
+		(f.Tenant == "" || e.Tenant == f.Tenant) &&
+		e.TimeMs >= f.SinceMs
+}
+
+// Log appends entries to a JSON-lines file, synced after each write, or
+// keeps the most recent ones in memory when no file is configured.
+type Log struct {
+	mu        sync.Mutex
+	file      *os.File
+	path      string
+	memory    []Entry
+	maxMemory int
+}
+
+// Options configures a Log. Path empty keeps MemoryEntries (default 1000)
+// entries in memory only.
+type Options struct {
+	Path          string
+	MemoryEntries int
+}
+
+func Open(opts Options) (*Log, error) {
+	l := &Log{path: opts.Path, maxMemory: opts.MemoryEntries}
+	if l.maxMemory <= 0 {
+		l.maxMemory = 1000
+	}
+	if opts.Path == "" {
+		return l, nil
+	}
+	f, err := os.OpenFile(opts.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("audit log: %w", err)
+	}
+	l.file = f
+	return l, nil
+}
+
+// Record stamps e with the current time unless it has one, and stores it.
+func (l *Log) Record(e Entry) error {
+	if e.TimeMs == 0 {
+		e.TimeMs = time.Now().UnixMilli()
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		if len(l.memory) == l.maxMemory {
+			l.memory = append(l.memory[:0], l.memory[1:]...)
+		}
+		l.memory = append(l.memory, e)
+		return nil
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("audit log: %w", err)
+	}
+	return l.file.Sync()
+}
+
+// Query returns matching entries, oldest first.
+func (l *Log) Query(f Filter) ([]Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var out []Entry
+	keep := func(e Entry) {
+		if !f.match(e) {
+			return
+		}
+		out = append(out, e)
+		if f.Limit > 0 && len(out) > 2*f.Limit {
+			out = append(out[:0], out[len(out)-f.Limit:]...)
+		}
+	}
+	if l.file == nil {
+		for _, e := range l.memory {
+			keep(e)
+		}
+	} else if err := l.scan(keep); err != nil {
+		return nil, err
+	}
+	if f.Limit > 0 && len(out) > f.Limit {
+		out = out[len(out)-f.Limit:]
+	}
+	return out, nil
+}
+
+func (l *Log) scan(fn func(Entry)) error {
+	f, err := os.Open(l.path)
+	if err != nil {
+		return fmt.Errorf("audit log: %w", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// A torn last line from a crash mid-write is skipped.
+			continue
+		}
+		fn(e)
+	}
+	return scanner.Err()
+}
+
+func (l *Log) Close() error {
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
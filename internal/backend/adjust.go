@@ -0,0 +1,279 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"math"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Adjuster is implemented by backends that can change how much of a key's
+// allowance is used without a check. A positive delta grants that much
+// cost back for the current period, even past the limit; a negative one
+// uses it up. Bucket grants stop at a full bucket. req names the key and
+// the parameters it is checked with; its Cost is ignored. The result
+// describes the key afterwards. When req has Dimensions, each of them is
+// adjusted too, by its Cost, and described in the result's Dimensions.
+type Adjuster interface {
+	Adjust(ctx context.Context, req Request, delta int64) (Result, error)
+}
+
+// ErrAdjustUnsupported is returned for sliding logs, which keep no count
+// that an adjustment could change.
+var ErrAdjustUnsupported = errors.New("algorithm cannot be adjusted")
+
+// adjustable checks req's parameters as its check would.
+func adjustable(req Request) error {
+	switch req.Algorithm {
+	case TokenBucket:
+		if req.Capacity <= 0 || req.RefillPerSec <= 0 {
+			return ErrInvalidParams
+		}
+	case LeakyBucket:
+		if req.Capacity <= 0 || req.LeakPerSec <= 0 {
+			return ErrInvalidParams
+		}
+	case FixedWindow, SlidingWindowCounter:
+		if req.Limit <= 0 || req.WindowMs <= 0 {
+			return ErrInvalidParams
+		}
+		if req.Timezone != "" && (req.Algorithm != FixedWindow || !ValidZone(req.Timezone, req.WindowMs)) {
+			return ErrInvalidParams
+		}
+	case SlidingWindowLog, CountMinSketch, WeightedFairShare:
+		return ErrAdjustUnsupported
+	default:
+		return ErrUnsupportedAlgorithm
+	}
+	return nil
+}
+
+func (m *MemoryBackend) Adjust(ctx context.Context, req Request, delta int64) (Result, error) {
+	if len(req.Dimensions) > 0 {
+		m.dimensionMu.Lock()
+		defer m.dimensionMu.Unlock()
+		return adjustWithDimensions(ctx, m, m.dimensionStates(), req, delta)
+	}
+	if err := adjustable(req); err != nil {
+		return Result{}, err
+	}
+	now := m.clock.Now()
+	nowMs := now.UnixMilli()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expireIdle(nowMs)
+
+	switch req.Algorithm {
+	case TokenBucket:
+		s := live(m.tokenBuckets, req.Key, nowMs)
+		if s == nil {
+			s = &tokenBucketState{tokens: float64(req.Capacity), last: now, capacity: req.Capacity, rate: req.RefillPerSec}
+			m.tokenBuckets[req.Key] = s
+		}
+		if s.capacity != req.Capacity || s.rate != req.RefillPerSec {
+			s.tokens = s.tokens * float64(req.Capacity) / float64(s.capacity)
+			s.capacity, s.rate = req.Capacity, req.RefillPerSec
+		}
+		// Refill is capped before the adjustment, so a deduction from a full
+		// bucket is not absorbed by the cap.
+		s.tokens = math.Min(float64(s.capacity), s.tokens+now.Sub(s.last).Seconds()*s.rate)
+		s.tokens = math.Min(float64(s.capacity), s.tokens+float64(delta))
+		s.last = now
+		s.keep(nowMs, m.stateTTL.ttlMs(TokenBucket, durationMs(float64(s.capacity)/s.rate*1000.0)))
+		return Result{
+			Allowed:   s.tokens >= 1,
+			Remaining: clampInt64(math.Max(0, math.Floor(s.tokens))),
+			ResetAtMs: satAdd(nowMs, durationMs((float64(s.capacity)-s.tokens)/s.rate*1000.0)),
+		}, nil
+	case LeakyBucket:
+		s := live(m.leakyBuckets, req.Key, nowMs)
+		if s == nil {
+			s = &leakyBucketState{last: now, capacity: req.Capacity, rate: req.LeakPerSec}
+			m.leakyBuckets[req.Key] = s
+		}
+		if s.capacity != req.Capacity || s.rate != req.LeakPerSec {
+			s.water = s.water * float64(req.Capacity) / float64(s.capacity)
+			s.capacity, s.rate = req.Capacity, req.LeakPerSec
+		}
+		s.water = math.Max(0, s.water-now.Sub(s.last).Seconds()*s.rate)
+		s.water = math.Max(0, s.water-float64(delta))
+		s.last = now
+		s.keep(nowMs, m.stateTTL.ttlMs(LeakyBucket, durationMs(float64(s.capacity)/s.rate*1000.0)))
+		return Result{
+			Allowed:   s.water+1 <= float64(s.capacity),
+			Remaining: clampInt64(math.Max(0, math.Floor(float64(s.capacity)-s.water))),
+			ResetAtMs: satAdd(nowMs, durationMs(s.water/s.rate*1000.0)),
+		}, nil
+	case FixedWindow:
+		startMs, endMs := nowMs-nowMs%req.WindowMs, int64(0)
+		if req.Timezone != "" {
+			loc, _ := location(req.Timezone)
+			startMs, endMs = zonedWindow(nowMs, req.WindowMs, loc)
+		}
+		s := live(m.fixedWindows, req.Key, nowMs)
+		if s == nil || s.windowMs != req.WindowMs || nowMs >= s.endMs() || s.windowStartMs != startMs {
+			s = &fixedWindowState{windowStartMs: startMs, windowEndMs: endMs, limit: req.Limit, windowMs: req.WindowMs}
+			m.fixedWindows[req.Key] = s
+		} else if s.limit != req.Limit {
+			s.count = rescale(s.count, s.limit, req.Limit)
+			s.limit = req.Limit
+		}
+		s.count = satAdd(s.count, -delta)
+		s.keep(nowMs, m.stateTTL.ttlMs(FixedWindow, max(s.windowMs, s.endMs()-s.windowStartMs)))
+		return Result{
+			Allowed:      s.count < s.limit,
+			Remaining:    max(0, s.limit-s.count),
+			ResetAtMs:    s.endMs(),
+			CurrentCount: s.count,
+		}, nil
+	default:
+		s := live(m.slidingCounters, req.Key, nowMs)
+		if s == nil {
+			s = &slidingCounterState{windowStartMs: nowMs - nowMs%req.WindowMs, limit: req.Limit, windowMs: req.WindowMs}
+			m.slidingCounters[req.Key] = s
+		}
+		s.adapt(nowMs, req.Limit, req.WindowMs)
+		s.roll(nowMs, req.WindowMs)
+		s.currentCount = satAdd(s.currentCount, -delta)
+		s.keep(nowMs, m.stateTTL.ttlMs(SlidingWindowCounter, satAdd(req.WindowMs, req.WindowMs)))
+		weight := float64(req.WindowMs-(nowMs-s.windowStartMs)) / float64(req.WindowMs)
+		computed := float64(s.prevCount)*weight + float64(s.currentCount)
+		return Result{
+			Allowed:       computed+1 <= float64(req.Limit),
+			Remaining:     clampInt64(math.Max(0, math.Floor(float64(req.Limit)-computed))),
+			ResetAtMs:     satAdd(s.windowStartMs, satAdd(req.WindowMs, req.WindowMs)),
+			CurrentCount:  s.currentCount,
+			ComputedCount: clampInt64(math.Ceil(computed)),
+		}, nil
+	}
+}
+
+// Adjust runs the adjustment script of req's algorithm, which leaves the
+// key as its check script would find it.
+func (r *RedisBackend) Adjust(ctx context.Context, req Request, delta int64) (Result, error) {
+	if len(req.Dimensions) > 0 {
+		return adjustWithDimensions(ctx, r, r, req, delta)
+	}
+	if err := adjustable(req); err != nil {
+		return Result{}, err
+	}
+	req.Cost = 1
+	call, err := r.callFor(req)
+	if err != nil {
+		return Result{}, err
+	}
+	// The check's arguments, with the cost replaced by the adjustment.
+	call.args[2] = delta
+	switch req.Algorithm {
+	case TokenBucket:
+		call.script = adjustBucketScript
+		call.args = append(call.args, "tokens", "refill")
+	case LeakyBucket:
+		call.script = adjustBucketScript
+		call.args = append(call.args, "water", "leak")
+	case FixedWindow:
+		call.script = adjustFixedWindowScript
+	default:
+		call.script = adjustCounterScript
+	}
+	return r.run(ctx, call)
+}
+
+func (r *Router) Adjust(ctx context.Context, req Request, delta int64) (Result, error) {
+	adjuster, ok := r.backendFor(req.Key).(Adjuster)
+	if !ok {
+		return Result{}, ErrAdjustUnsupported
+	}
+	return adjuster.Adjust(ctx, req, delta)
+}
+
+// adjustBucketScript serves both buckets: a grant adds tokens to a token
+// bucket and drains water from a leaky one.
+var adjustBucketScript = redis.NewScript(clockLua + clampLua + `
+local key = KEYS[1]
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local delta = tonumber(ARGV[3])
+local now_ms = resolve_now(tonumber(ARGV[4]))
+local ttl_ms = tonumber(ARGV[5])
+local level_field = ARGV[6]
+local rate_field = ARGV[7]
+local token = level_field == "tokens"
+
+local state = redis.call("HMGET", key, level_field, "last_ms", "capacity", rate_field)
+local level = tonumber(state[1])
+local last_ms = tonumber(state[2])
+if level == nil then
+	level = 0
+	if token then level = capacity end
+end
+if last_ms == nil then last_ms = now_ms end
+if state[3] and (state[3] ~= ARGV[1] or state[4] ~= ARGV[2]) then
+	level = level * capacity / tonumber(state[3])
+end
+if now_ms < last_ms then now_ms = last_ms end
+
+local moved = (now_ms - last_ms) / 1000 * rate
+local used
+if token then
+	level = math.min(capacity, level + moved)
+	level = math.min(capacity, level + delta)
+	used = capacity - level
+else
+	level = math.max(0, level - moved)
+	level = math.max(0, level - delta)
+	used = level
+end
+
+redis.call("HSET", key, level_field, level, "last_ms", now_ms, "capacity", ARGV[1], rate_field, ARGV[2])
+redis.call("PEXPIRE", key, ttl_ms)
+
+local allowed = 0
+if used + 1 <= capacity then allowed = 1 end
+return {allowed, clamp(math.max(0, math.floor(capacity - used))), clamp(now_ms + math.ceil((used / rate) * 1000)), 0, 0, 0, 0}
+`)
+
+var adjustFixedWindowScript = redis.NewScript(clockLua + clampLua + `
+local base_key = KEYS[1]
+local limit = tonumber(ARGV[1])
+local window_ms = tonumber(ARGV[2])
+local delta = tonumber(ARGV[3])
+local now_ms = resolve_now(tonumber(ARGV[4]))
+local window_start = tonumber(ARGV[5])
+local window_end = tonumber(ARGV[6])
+local ttl_ms = tonumber(ARGV[7])
+
+if window_start == 0 then
+	window_start = now_ms - (now_ms % window_ms)
+	window_end = window_start + window_ms
+end
+local key = base_key .. ":" .. window_start
+local count = redis.call("DECRBY", key, delta)
+redis.call("PEXPIRE", key, ttl_ms)
+
+local allowed = 0
+if count < limit then allowed = 1 end
+return {allowed, clamp(math.max(0, limit - count)), clamp(window_end), 0, count, 0, 0}
+`)
+
+var adjustCounterScript = redis.NewScript(clockLua + clampLua + `
+local base_key = KEYS[1]
+local limit = tonumber(ARGV[1])
+local window_ms = tonumber(ARGV[2])
+local delta = tonumber(ARGV[3])
+local now_ms = resolve_now(tonumber(ARGV[4]))
+local ttl_ms = tonumber(ARGV[5])
+
+local current_start = now_ms - (now_ms % window_ms)
+local current_key = base_key .. ":" .. current_start
+local current_count = redis.call("DECRBY", current_key, delta)
+redis.call("PEXPIRE", current_key, ttl_ms)
+local prev_count = tonumber(redis.call("GET", base_key .. ":" .. (current_start - window_ms)) or "0")
+
+local weight = (window_ms - (now_ms - current_start)) / window_ms
+local computed = (prev_count * weight) + current_count
+local allowed = 0
+if computed + 1 <= limit then allowed = 1 end
+return {allowed, clamp(math.max(0, math.floor(limit - computed))), clamp(current_start + 2 * window_ms), 0, current_count, clamp(math.ceil(computed)), 0}
+`)
@@ -0,0 +1,43 @@
+package backend
+
+import "math"
+
+// Parameters are only bounded by int64, so results are computed with
+// saturating helpers: an extreme limit or a near-zero rate yields the
+// largest representable value instead of a wrapped, negative one.
+
+// maxInt64Float is the largest float64 that converts to int64 without
+// overflowing; float64(math.MaxInt64) itself rounds up to 2^63.
+const maxInt64Float = float64(math.MaxInt64 - 1023)
+
+func satAdd(a, b int64) int64 {
+	sum := a + b
+	switch {
+	case a > 0 && b > 0 && sum < 0:
+		return math.MaxInt64
+	case a < 0 && b < 0 && sum >= 0:
+		return math.MinInt64
+	}
+	return sum
+}
+
+// clampInt64 converts f to int64, saturating at the int64 range. NaN is 0.
+func clampInt64(f float64) int64 {
+	switch {
+	case f != f:
+		return 0
+	case f >= maxInt64Float:
+		return math.MaxInt64
+	case f <= math.MinInt64:
+		return math.MinInt64
+	}
+	return int64(f)
+}
+
+// durationMs rounds a millisecond duration up, never below zero.
+func durationMs(ms float64) int64 {
+	if ms <= 0 {
+		return 0
+	}
+	return clampInt64(math.Ceil(ms))
+}
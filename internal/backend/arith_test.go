@@ -0,0 +1,104 @@
+package backend
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSatAdd(t *testing.T) {
+	for _, tc := range []struct{ a, b, want int64 }{
+		{1, 2, 3},
+		{math.MaxInt64, 1, math.MaxInt64},
+		{math.MaxInt64, math.MaxInt64, math.MaxInt64},
+		{math.MaxInt64 - 1, 1, math.MaxInt64},
+		{math.MinInt64, -1, math.MinInt64},
+		{math.MinInt64, math.MaxInt64, -1},
+		{math.MaxInt64, -1, math.MaxInt64 - 1},
+		{0, 0, 0},
+	} {
+		if got := satAdd(tc.a, tc.b); got != tc.want {
+			t.Errorf("satAdd(%d, %d) = %d, want %d", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestClampInt64(t *testing.T) {
+	for _, tc := range []struct {
+		f    float64
+		want int64
+	}{
+		{0, 0},
+		{1.9, 1},
+		{-1.9, -1},
+		{math.NaN(), 0},
+		{math.Inf(1), math.MaxInt64},
+		{math.Inf(-1), math.MinInt64},
+		{float64(math.MaxInt64), math.MaxInt64},
+		{maxInt64Float, math.MaxInt64},
+		{1e300, math.MaxInt64},
+		{-1e300, math.MinInt64},
+		{float64(1 << 62), 1 << 62},
+	} {
+		if got := clampInt64(tc.f); got != tc.want {
+			t.Errorf("clampInt64(%g) = %d, want %d", tc.f, got, tc.want)
+		}
+	}
+}
+
+func TestDurationMs(t *testing.T) {
+	for _, tc := range []struct {
+		ms   float64
+		want int64
+	}{
+		{0, 0},
+		{-5, 0},
+		{math.NaN(), 0},
+		{0.0001, 1},
+		{1, 1},
+		{1.2, 2},
+		// A near-zero rate makes a wait of 1/rate seconds.
+		{1000 / 1e-300, math.MaxInt64},
+		{math.Inf(1), math.MaxInt64},
+	} {
+		if got := durationMs(tc.ms); got != tc.want {
+			t.Errorf("durationMs(%g) = %d, want %d", tc.ms, got, tc.want)
+		}
+	}
+}
+
+// TestExtremeParameters checks the memory backend with limits, costs and
+// windows near the int64 range and rates near zero: results must stay
+// non-negative and never wrap.
+func TestExtremeParameters(t *testing.T) {
+	ctx := context.Background()
+	const huge = math.MaxInt64
+	for _, req := range []Request{
+		{Algorithm: TokenBucket, Capacity: huge, RefillPerSec: 1e-300, Cost: huge},
+		{Algorithm: TokenBucket, Capacity: huge, RefillPerSec: math.MaxFloat64, Cost: huge / 2},
+		{Algorithm: TokenBucket, Capacity: 1, RefillPerSec: 1e-300, Cost: 1},
+		{Algorithm: LeakyBucket, Capacity: huge, LeakPerSec: 1e-300, Cost: huge},
+		{Algorithm: LeakyBucket, Capacity: 1, LeakPerSec: 1e-300, Cost: 1},
+		{Algorithm: FixedWindow, Limit: huge, WindowMs: huge, Cost: huge},
+		{Algorithm: FixedWindow, Limit: huge, WindowMs: 1, Cost: huge - 1},
+		{Algorithm: SlidingWindowLog, Limit: huge, WindowMs: huge, Cost: huge},
+		{Algorithm: SlidingWindowCounter, Limit: huge, WindowMs: huge, Cost: huge},
+		{Algorithm: SlidingWindowCounter, Limit: huge, WindowMs: huge, Cost: 1},
+	} {
+		clock := NewManualClock(time.UnixMilli(1_700_000_000_000))
+		m := NewMemoryBackend(MemoryOptions{Clock: clock})
+		req.Key = "k"
+		for i := 0; i < 3; i++ {
+			res, err := Evaluate(ctx, m, req)
+			if err != nil {
+				t.Fatalf("%+v: %v", req, err)
+			}
+			nowMs := clock.Now().UnixMilli()
+			if res.Remaining < 0 || res.RetryAfterMs < 0 || res.ResetAtMs < nowMs || res.CurrentCount < 0 {
+				t.Fatalf("%s check %d with %+v overflowed: %+v", req.Algorithm, i+1, req, res)
+			}
+			clock.Advance(time.Second)
+		}
+	}
+}
@@ -1,14 +1,42 @@
 package backend
 
-import "context"
+import (
+	"context"
+	"errors"
+)
 
+// Result is the same contract for every algorithm and backend:
+//   - Remaining is how much cost could still be allowed right now, never
+//     negative.
+//   - ResetAtMs is when, without further traffic, the full limit is
+//     available again (now if it already is).
+//   - RetryAfterMs is 0 when allowed; when denied, the wait until the same
+//     request would be allowed without further traffic.
+//   - ParamsChanged reports that the key was last checked with different
+//     parameters and its state was adapted to the new ones.
+//   - ErrorBound is, for approximate algorithms, how far CurrentCount may
+//     be above the key's true count.
+//   - Group is the decision of the group budget the check was also charged
+//     to, if any; the other fields then combine both decisions.
+//   - Grace reports that the key's limit denied the check and its grace
+//     allowance allowed it; see GraceBackend.
+//   - Dimensions are the decisions of the check's dimensions, if any; see
+//     DimensionBackend.
 type Result struct {
-	Allowed       bool  `json:"allowed"`
-	Remaining     int64 `json:"remaining"`
-	ResetAtMs     int64 `json:"reset_at_ms"`
-	RetryAfterMs  int64 `json:"retry_after_ms"`
-	CurrentCount  int64 `json:"current_count,omitempty"`
-	ComputedCount int64 `json:"computed_count,omitempty"`
+	Allowed       bool     `json:"allowed"`
+	Remaining     int64    `json:"remaining"`
+	ResetAtMs     int64    `json:"reset_at_ms"`
+	RetryAfterMs  int64    `json:"retry_after_ms"`
+	CurrentCount  int64    `json:"current_count,omitempty"`
+	ComputedCount int64    `json:"computed_count,omitempty"`
+	ParamsChanged bool     `json:"params_changed,omitempty"`
+	ErrorBound    int64    `json:"error_bound,omitempty"`
+	Group         *Result  `json:"group,omitempty"`
+	Grace         bool     `json:"grace,omitempty"`
+	Dimensions    []Result `json:"dimensions,omitempty"`
+	// Key is the key's own decision when Allowed is not that alone, being
+	// combined with its dimensions' or overridden for shadow mode.
+	Key *Result `json:"key,omitempty"`
 }
 
 type Backend interface {
@@ -19,3 +47,119 @@ type Backend interface {
 	SlidingWindowCounterAllow(ctx context.Context, key string, limit int64, windowMs int64, cost int64) (Result, error)
 	Close() error
 }
+
+const (
+	TokenBucket          = "token_bucket"
+	LeakyBucket          = "leaky_bucket"
+	FixedWindow          = "fixed_window"
+	SlidingWindowLog     = "sliding_window_log"
+	SlidingWindowCounter = "sliding_window_counter"
+	// CountMinSketch is an approximate fixed window; see SketchBackend.
+	CountMinSketch = "count_min_sketch"
+	// WeightedFairShare shares a fixed window between clients by weight;
+	// see FairShareBackend.
+	WeightedFairShare = "weighted_fair_share"
+)
+
+var ErrUnsupportedAlgorithm = errors.New("unsupported algorithm")
+
+// ErrInvalidParams is returned instead of a decision when a check's limit,
+// window, capacity, rate or cost is not positive.
+var ErrInvalidParams = errors.New("invalid rate limit parameters")
+
+// Request describes a single check independently of the per-algorithm
+// methods, for callers that evaluate checks generically such as batches.
+type Request struct {
+	Algorithm    string  `json:"algorithm"`
+	Key          string  `json:"key"`
+	Limit        int64   `json:"limit,omitempty"`
+	WindowMs     int64   `json:"window_ms,omitempty"`
+	Capacity     int64   `json:"capacity,omitempty"`
+	RefillPerSec float64 `json:"refill_per_sec,omitempty"`
+	LeakPerSec   float64 `json:"leak_per_sec,omitempty"`
+	Cost         int64   `json:"cost"`
+	// MaxDebt lets a token bucket go this far below empty; see
+	// OverdraftBackend.
+	MaxDebt int64 `json:"max_debt,omitempty"`
+	// Timezone aligns a fixed window with local midnight in this IANA
+	// zone; see ZonedWindowBackend.
+	Timezone string `json:"timezone,omitempty"`
+	// Client and Weight name the client of a weighted_fair_share pool,
+	// which is Key, and its weight.
+	Client string `json:"client,omitempty"`
+	Weight int64  `json:"weight,omitempty"`
+	// GroupKey, when set, also charges the check to the budget shared by
+	// its group; see GroupBackend.
+	GroupKey      string `json:"group_key,omitempty"`
+	GroupLimit    int64  `json:"group_limit,omitempty"`
+	GroupWindowMs int64  `json:"group_window_ms,omitempty"`
+	// Dimensions are further limits the check consumes from; see
+	// DimensionBackend.
+	Dimensions []Dimension `json:"dimensions,omitempty"`
+	// hashTag, when set, is the part of Key Redis hash tags instead of the
+	// whole of it: a dimension's key is tagged as the key it belongs to.
+	hashTag string
+}
+
+// BatchBackend is implemented by backends that can evaluate several checks
+// in a single round trip. Results and errors are index-aligned with reqs.
+type BatchBackend interface {
+	AllowBatch(ctx context.Context, reqs []Request) ([]Result, []error)
+}
+
+func Evaluate(ctx context.Context, b Backend, req Request) (Result, error) {
+	if len(req.Dimensions) > 0 {
+		dimensional, ok := b.(DimensionBackend)
+		if !ok {
+			return Result{}, ErrDimensionsUnsupported
+		}
+		return dimensional.DimensionsAllow(ctx, req)
+	}
+	if req.GroupKey != "" {
+		grouped, ok := b.(GroupBackend)
+		if !ok {
+			return Result{}, ErrGroupUnsupported
+		}
+		return grouped.GroupAllow(ctx, req)
+	}
+	switch req.Algorithm {
+	case TokenBucket:
+		if req.MaxDebt > 0 {
+			overdraft, ok := b.(OverdraftBackend)
+			if !ok {
+				return Result{}, ErrUnsupportedAlgorithm
+			}
+			return overdraft.TokenBucketOverdraftAllow(ctx, req.Key, req.Capacity, req.RefillPerSec, req.MaxDebt, req.Cost)
+		}
+		return b.TokenBucketAllow(ctx, req.Key, req.Capacity, req.RefillPerSec, req.Cost)
+	case LeakyBucket:
+		return b.LeakyBucketAllow(ctx, req.Key, req.Capacity, req.LeakPerSec, req.Cost)
+	case FixedWindow:
+		if req.Timezone != "" {
+			zoned, ok := b.(ZonedWindowBackend)
+			if !ok {
+				return Result{}, ErrUnsupportedAlgorithm
+			}
+			return zoned.ZonedFixedWindowAllow(ctx, req.Key, req.Limit, req.WindowMs, req.Timezone, req.Cost)
+		}
+		return b.FixedWindowAllow(ctx, req.Key, req.Limit, req.WindowMs, req.Cost)
+	case SlidingWindowLog:
+		return b.SlidingWindowLogAllow(ctx, req.Key, req.Limit, req.WindowMs, req.Cost)
+	case SlidingWindowCounter:
+		return b.SlidingWindowCounterAllow(ctx, req.Key, req.Limit, req.WindowMs, req.Cost)
+	case CountMinSketch:
+		sketch, ok := b.(SketchBackend)
+		if !ok {
+			return Result{}, ErrUnsupportedAlgorithm
+		}
+		return sketch.SketchAllow(ctx, req.Key, req.Limit, req.WindowMs, req.Cost)
+	case WeightedFairShare:
+		fair, ok := b.(FairShareBackend)
+		if !ok {
+			return Result{}, ErrUnsupportedAlgorithm
+		}
+		return fair.FairShareAllow(ctx, req.Key, req.Client, req.Weight, req.Limit, req.WindowMs, req.Cost)
+	default:
+		return Result{}, ErrUnsupportedAlgorithm
+	}
+}
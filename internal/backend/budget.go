@@ -0,0 +1,225 @@
+package backend
+
+import (
+	"math"
+	"sync/atomic"
+	"unsafe"
+)
+
+// BudgetStats describes how a backend is keeping its state within a
+// memory budget.
+type BudgetStats struct {
+	// Bytes is the estimated size of the state, as of the last count, and
+	// MaxBytes the budget; zero when there is none.
+	Bytes    int64 `json:"bytes"`
+	MaxBytes int64 `json:"max_bytes"`
+	// Evicted counts the states dropped to stay within the budget.
+	Evicted int64 `json:"evicted"`
+	// Pressure is set while the state is close enough to the budget that
+	// keys are, or are about to be, evicted before they expire, so their
+	// checks forget usage and allow more than their limits.
+	Pressure bool `json:"pressure"`
+}
+
+// Budgeted is implemented by backends that can keep their state within a
+// memory budget.
+type Budgeted interface {
+	Budget() BudgetStats
+}
+
+// A budget's estimate is counted again after a sixteenth of its states'
+// worth of checks, and at least budgetRecount, so it drifts by a few
+// percent at most. Over the budget, each check evicts up to evictBatch
+// states, picking the one expiring soonest among evictSample of each
+// algorithm's, as Redis does. Pressure starts at pressureFraction of the
+// budget.
+const (
+	budgetRecount    = 1024
+	evictBatch       = 32
+	evictSample      = 5
+	pressureFraction = 0.9
+)
+
+// memoryBudget bounds the estimated size of a memory backend's key states,
+// those of its group and dimension backends included. Its counters are
+// atomic so the budget can be read, and shared, without m.mu.
+type memoryBudget struct {
+	maxBytes int64
+	bytes    atomic.Int64
+	evicted  atomic.Int64
+}
+
+// Sizes are estimates: a state's struct and each map entry's overhead,
+// with the key's bytes on top.
+var (
+	mapEntryBytes       = int64(unsafe.Sizeof("") + unsafe.Sizeof(uintptr(0)) + 16)
+	tokenBucketBytes    = int64(unsafe.Sizeof(tokenBucketState{}))
+	leakyBucketBytes    = int64(unsafe.Sizeof(leakyBucketState{}))
+	fixedWindowBytes    = int64(unsafe.Sizeof(fixedWindowState{}))
+	slidingLogBytes     = int64(unsafe.Sizeof(slidingLogState{}))
+	logEntryBytes       = int64(unsafe.Sizeof(logEntry{}))
+	slidingCounterBytes = int64(unsafe.Sizeof(slidingCounterState{}))
+	fairPoolBytes       = int64(unsafe.Sizeof(fairPoolState{}))
+	fairClientBytes     = int64(unsafe.Sizeof(fairClientState{}))
+	graceBytes          = int64(unsafe.Sizeof(graceState{}))
+	multiplierBytes     = int64(unsafe.Sizeof(Multiplier{}))
+)
+
+func (e *expiry) expiresAt() int64 { return e.expiresMs }
+
+func (*tokenBucketState) size() int64    { return tokenBucketBytes }
+func (*leakyBucketState) size() int64    { return leakyBucketBytes }
+func (*fixedWindowState) size() int64    { return fixedWindowBytes }
+func (*slidingCounterState) size() int64 { return slidingCounterBytes }
+func (*graceState) size() int64          { return graceBytes }
+
+func (l *slidingLogState) size() int64 {
+	return slidingLogBytes + int64(cap(l.entries))*logEntryBytes
+}
+
+func (p *fairPoolState) size() int64 {
+	n := fairPoolBytes
+	for client := range p.clients {
+		n += mapEntryBytes + int64(len(client)) + fairClientBytes
+	}
+	return n
+}
+
+type sizedState interface {
+	expiring
+	expiresAt() int64
+	size() int64
+}
+
+// countBytes drops the expired states and returns the size of the rest.
+func countBytes[S sizedState](states map[string]S, nowMs int64) int64 {
+	var n int64
+	for key, s := range states {
+		if s.expired(nowMs) {
+			delete(states, key)
+			continue
+		}
+		n += mapEntryBytes + int64(len(key)) + s.size()
+	}
+	return n
+}
+
+// countMultipliers drops the expired multipliers and returns the size of
+// the rest. Multipliers in force are counted but never evicted: operators
+// set them, and forgetting one would change limits behind their backs.
+func countMultipliers(multipliers map[string]Multiplier, nowMs int64) int64 {
+	var n int64
+	for target, mult := range multipliers {
+		if mult.ExpiresAtMs <= nowMs {
+			delete(multipliers, target)
+			continue
+		}
+		n += mapEntryBytes + int64(len(target)) + multiplierBytes
+	}
+	return n
+}
+
+// evictionCandidate is the state an eviction would drop.
+type evictionCandidate struct {
+	expiresMs int64
+	bytes     int64
+	drop      func()
+}
+
+// sampleOldest makes the state expiring soonest among a few of states the
+// candidate, if it expires sooner. States that never expire go last.
+func sampleOldest[S sizedState](states map[string]S, c *evictionCandidate) {
+	n := 0
+	for key, s := range states {
+		at := s.expiresAt()
+		if at == 0 {
+			at = math.MaxInt64
+		}
+		if c.drop == nil || at < c.expiresMs {
+			key := key
+			*c = evictionCandidate{expiresMs: at, bytes: mapEntryBytes + int64(len(key)) + s.size(), drop: func() { delete(states, key) }}
+		}
+		if n++; n == evictSample {
+			return
+		}
+	}
+}
+
+// keyCount is the number of key states held. m.mu must be held.
+func (m *MemoryBackend) keyCount() int {
+	return len(m.tokenBuckets) + len(m.leakyBuckets) + len(m.fixedWindows) + len(m.slidingLogs) +
+		len(m.slidingCounters) + len(m.logCounters) + len(m.fairPools) + len(m.graces)
+}
+
+// countBudget estimates the size of the key states again, dropping the
+// expired ones, and updates the budget with the difference. m.mu must be
+// held.
+func (m *MemoryBackend) countBudget(nowMs int64) {
+	n := countBytes(m.tokenBuckets, nowMs) + countBytes(m.leakyBuckets, nowMs) + countBytes(m.fixedWindows, nowMs) +
+		countBytes(m.slidingLogs, nowMs) + countBytes(m.slidingCounters, nowMs) + countBytes(m.logCounters, nowMs) +
+		countBytes(m.fairPools, nowMs) + countBytes(m.graces, nowMs) + countMultipliers(m.multipliers, nowMs)
+	m.budget.bytes.Add(n - m.counted)
+	m.counted = n
+	m.budgetChecks = 0
+}
+
+// enforceBudget counts the key states again when due and, over the
+// budget, evicts a few of those expiring soonest, which for keys with the
+// same TTL are those checked longest ago. A backend evicts only its own
+// states; over a shared budget, the group and dimension backends evict
+// theirs on their own checks. m.mu must be held.
+func (m *MemoryBackend) enforceBudget(nowMs int64) {
+	b := m.budget
+	if b.maxBytes <= 0 {
+		return
+	}
+	if m.budgetChecks++; m.budgetChecks >= max(budgetRecount, m.keyCount()/16) {
+		m.countBudget(nowMs)
+	}
+	for i := 0; i < evictBatch && b.bytes.Load() > b.maxBytes; i++ {
+		var c evictionCandidate
+		sampleOldest(m.tokenBuckets, &c)
+		sampleOldest(m.leakyBuckets, &c)
+		sampleOldest(m.fixedWindows, &c)
+		sampleOldest(m.slidingLogs, &c)
+		sampleOldest(m.slidingCounters, &c)
+		sampleOldest(m.logCounters, &c)
+		sampleOldest(m.fairPools, &c)
+		sampleOldest(m.graces, &c)
+		if c.drop == nil {
+			return
+		}
+		c.drop()
+		m.counted -= c.bytes
+		b.bytes.Add(-c.bytes)
+		b.evicted.Add(1)
+	}
+}
+
+// Budget reports the memory budget of the key states, with those of group
+// budgets, dimensions, grace and multipliers. Sketches, usage and history
+// are not counted.
+func (m *MemoryBackend) Budget() BudgetStats {
+	b := m.budget
+	s := BudgetStats{Bytes: b.bytes.Load(), MaxBytes: b.maxBytes, Evicted: b.evicted.Load()}
+	s.Pressure = b.maxBytes > 0 && float64(s.Bytes) >= pressureFraction*float64(b.maxBytes)
+	return s
+}
+
+// Budget adds up the budgets of every backend that has one; there is
+// pressure when any of them is under it.
+func (r *Router) Budget() BudgetStats {
+	var total BudgetStats
+	for _, b := range r.all() {
+		budgeted, ok := b.(Budgeted)
+		if !ok {
+			continue
+		}
+		s := budgeted.Budget()
+		total.Bytes += s.Bytes
+		total.MaxBytes += s.MaxBytes
+		total.Evicted += s.Evicted
+		total.Pressure = total.Pressure || s.Pressure
+	}
+	return total
+}
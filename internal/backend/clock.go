@@ -0,0 +1,66 @@
+package backend
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock supplies the current time to a backend, and through Options.Clock
+// to the HTTP handler. Tests substitute a ManualClock to step through
+// windows, refills and expiries without sleeping.
+type Clock interface {
+	Now() time.Time
+}
+
+// NewMonotonicClock returns the default clock: the wall clock at
+// construction plus the monotonic time elapsed since, so NTP steps or manual
+// clock changes cannot move buckets or windows. Times it returns keep their
+// monotonic reading, making Sub between them immune to wall clock jumps as
+// well.
+func NewMonotonicClock() Clock {
+	return monotonicClock{base: time.Now()}
+}
+
+type monotonicClock struct {
+	base time.Time
+}
+
+func (c monotonicClock) Now() time.Time {
+	return c.base.Add(time.Since(c.base))
+}
+
+// SystemClock reads the wall clock. Backends whose state is shared between
+// instances use it so that their timestamps agree.
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// ManualClock only moves when told to.
+type ManualClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func NewManualClock(start time.Time) *ManualClock {
+	return &ManualClock{now: start}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *ManualClock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = t
+}
+
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
@@ -0,0 +1,35 @@
+package backend
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestManualClockStepsWindows(t *testing.T) {
+	clock := NewManualClock(time.UnixMilli(1_700_000_000_000))
+	m := NewMemoryBackend(MemoryOptions{Clock: clock})
+	ctx := context.Background()
+	check := func() Result {
+		t.Helper()
+		res, err := m.FixedWindowAllow(ctx, "k", 2, 1000, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	check()
+	check()
+	if res := check(); res.Allowed {
+		t.Fatalf("third check in the window allowed: %+v", res)
+	}
+	clock.Advance(999 * time.Millisecond)
+	if res := check(); res.Allowed {
+		t.Fatalf("check 1ms before the window ends allowed: %+v", res)
+	}
+	clock.Advance(time.Millisecond)
+	if res := check(); !res.Allowed || res.Remaining != 1 {
+		t.Fatalf("first check of the next window = %+v, want allowed with 1 remaining", res)
+	}
+}
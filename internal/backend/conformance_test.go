@@ -0,0 +1,102 @@
+package backend
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// conformanceCases are checks every backend must decide alike: each is
+// exhausted by its fourth unit of cost in a moment.
+var conformanceCases = []Request{
+	{Algorithm: TokenBucket, Capacity: 3, RefillPerSec: 2},
+	{Algorithm: LeakyBucket, Capacity: 3, LeakPerSec: 2},
+	{Algorithm: FixedWindow, Limit: 3, WindowMs: 1000},
+	{Algorithm: SlidingWindowLog, Limit: 3, WindowMs: 1000},
+	{Algorithm: SlidingWindowCounter, Limit: 3, WindowMs: 1000},
+}
+
+// conformanceBackends make each backend under test, driven by clock.
+// Redis is tested when REDIS_ADDR, or localhost:6379, answers.
+var conformanceBackends = []struct {
+	name string
+	new  func(t *testing.T, clock Clock) Backend
+}{
+	{"memory", func(t *testing.T, clock Clock) Backend {
+		return NewMemoryBackend(MemoryOptions{Clock: clock})
+	}},
+	{"redis", func(t *testing.T, clock Clock) Backend {
+		addr := os.Getenv("REDIS_ADDR")
+		if addr == "" {
+			addr = "localhost:6379"
+		}
+		prefix := "conformance:" + strconv.FormatInt(time.Now().UnixNano(), 36) + ":"
+		r, err := NewRedisBackend(RedisOptions{Addr: addr, KeyPrefix: prefix, Clock: clock})
+		if err != nil {
+			t.Skipf("redis at %s unavailable: %v", addr, err)
+		}
+		t.Cleanup(func() { r.Close() })
+		return r
+	}},
+}
+
+func TestBackendConformance(t *testing.T) {
+	for _, tc := range conformanceCases {
+		for _, b := range conformanceBackends {
+			t.Run(tc.Algorithm+"/"+b.name, func(t *testing.T) {
+				clock := NewManualClock(time.UnixMilli(1_700_000_000_000))
+				testConformance(t, b.new(t, clock), clock, tc)
+			})
+		}
+	}
+}
+
+func testConformance(t *testing.T, b Backend, clock *ManualClock, req Request) {
+	ctx := context.Background()
+	req.Key = "k:" + req.Algorithm
+	check := func(cost int64) Result {
+		t.Helper()
+		req.Cost = cost
+		res, err := Evaluate(ctx, b, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nowMs := clock.Now().UnixMilli()
+		switch {
+		case res.Remaining < 0:
+			t.Fatalf("remaining %d < 0: %+v", res.Remaining, res)
+		case res.ResetAtMs < nowMs:
+			t.Fatalf("reset_at_ms %d before now %d: %+v", res.ResetAtMs, nowMs, res)
+		case res.Allowed && res.RetryAfterMs != 0:
+			t.Fatalf("allowed with retry_after_ms %d: %+v", res.RetryAfterMs, res)
+		case !res.Allowed && res.RetryAfterMs <= 0:
+			t.Fatalf("denied with retry_after_ms %d: %+v", res.RetryAfterMs, res)
+		case !res.Allowed && res.Remaining >= cost:
+			t.Fatalf("denied with remaining %d covering cost %d: %+v", res.Remaining, cost, res)
+		}
+		return res
+	}
+
+	for i := 0; i < 3; i++ {
+		if res := check(1); !res.Allowed {
+			t.Fatalf("check %d of 3 denied: %+v", i+1, res)
+		}
+	}
+	denied := check(1)
+	if denied.Allowed {
+		t.Fatalf("fourth check allowed: %+v", denied)
+	}
+	// Waiting retry_after_ms, and no longer, lets the same check through.
+	clock.Advance(time.Duration(denied.RetryAfterMs) * time.Millisecond)
+	if res := check(1); !res.Allowed {
+		t.Fatalf("check after retry_after_ms %d denied: %+v", denied.RetryAfterMs, res)
+	}
+
+	// Mixed traffic keeps every result within the contract.
+	for i := 0; i < 40; i++ {
+		clock.Advance(time.Duration(90+i*17%200) * time.Millisecond)
+		check(int64(1 + i%3))
+	}
+}
@@ -0,0 +1,309 @@
+package backend
+
+import (
+	"context"
+	"errors"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Dimension is one more limit a check consumes from besides its key's own,
+// such as LLM tokens or egress bytes, with its own parameters and cost. It
+// is checked with the request's algorithm (and time zone) under the key
+// DimensionKey(Key, Name).
+type Dimension struct {
+	Name         string  `json:"name"`
+	Limit        int64   `json:"limit,omitempty"`
+	WindowMs     int64   `json:"window_ms,omitempty"`
+	Capacity     int64   `json:"capacity,omitempty"`
+	RefillPerSec float64 `json:"refill_per_sec,omitempty"`
+	LeakPerSec   float64 `json:"leak_per_sec,omitempty"`
+	Cost         int64   `json:"cost"`
+}
+
+// DimensionBackend is implemented by backends that can charge a check to
+// its key and to each of its dimensions in one step: the check is allowed
+// only if all of them allow it, and none is charged otherwise. The Result
+// is the key's, with Allowed, ResetAtMs and RetryAfterMs combining every
+// decision, the key's own in Key and each dimension's in Dimensions, in
+// the request's order.
+type DimensionBackend interface {
+	DimensionsAllow(ctx context.Context, req Request) (Result, error)
+}
+
+var ErrDimensionsUnsupported = errors.New("backend cannot charge dimensions")
+
+// DimensionKey is the key of key's dimension name.
+func DimensionKey(key, name string) string {
+	return key + "#" + name
+}
+
+// dimensionRequests are the checks of req's dimensions.
+func dimensionRequests(req Request) []Request {
+	reqs := make([]Request, len(req.Dimensions))
+	for i, d := range req.Dimensions {
+		reqs[i] = Request{
+			Algorithm:    req.Algorithm,
+			Key:          DimensionKey(req.Key, d.Name),
+			Limit:        d.Limit,
+			WindowMs:     d.WindowMs,
+			Capacity:     d.Capacity,
+			RefillPerSec: d.RefillPerSec,
+			LeakPerSec:   d.LeakPerSec,
+			Cost:         d.Cost,
+			Timezone:     req.Timezone,
+			hashTag:      req.Key,
+		}
+	}
+	return reqs
+}
+
+// withDimensions merges a key's decision with its dimensions'. When any of
+// them denied the check, the ones a dry run allowed counted a cost that is
+// not charged.
+func withDimensions(key Result, dims []Result, cost int64, costs []int64) Result {
+	allowed := key.Allowed
+	for _, d := range dims {
+		allowed = allowed && d.Allowed
+	}
+	if !allowed {
+		if key.Allowed {
+			key = uncharged(key, cost)
+		}
+		for i := range dims {
+			if dims[i].Allowed {
+				dims[i] = uncharged(dims[i], costs[i])
+			}
+		}
+	}
+	res := key
+	res.Key = &key
+	res.Allowed = allowed
+	for _, d := range dims {
+		res.ResetAtMs = max(res.ResetAtMs, d.ResetAtMs)
+		res.RetryAfterMs = max(res.RetryAfterMs, d.RetryAfterMs)
+	}
+	res.Dimensions = dims
+	return res
+}
+
+func dimensionCosts(req Request) []int64 {
+	costs := make([]int64, len(req.Dimensions))
+	for i, d := range req.Dimensions {
+		costs[i] = d.Cost
+	}
+	return costs
+}
+
+// DimensionsAllow charges dimensions, kept apart from the keys' own state,
+// under dimensionMu, so no dimension can change between deciding and
+// charging. The dimensions are tried first, the key is charged only if
+// they all have room, and they only if the key was allowed.
+func (m *MemoryBackend) DimensionsAllow(ctx context.Context, req Request) (Result, error) {
+	if req.GroupKey != "" {
+		return Result{}, ErrDimensionsUnsupported
+	}
+	m.dimensionMu.Lock()
+	defer m.dimensionMu.Unlock()
+	dreqs := dimensionRequests(req)
+	costs := dimensionCosts(req)
+	req.Dimensions = nil
+	states := m.dimensionStates()
+	dims := make([]Result, len(dreqs))
+	roomy := true
+	for i, dreq := range dreqs {
+		res, err := states.Peek(ctx, dreq)
+		if err != nil {
+			return Result{}, err
+		}
+		dims[i], roomy = res, roomy && res.Allowed
+	}
+	var key Result
+	var err error
+	if !roomy {
+		key, err = m.Peek(ctx, req)
+	} else if key, err = Evaluate(ctx, m, req); err == nil && key.Allowed {
+		for i, dreq := range dreqs {
+			if dims[i], err = Evaluate(ctx, states, dreq); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		return Result{}, err
+	}
+	return withDimensions(key, dims, req.Cost, costs), nil
+}
+
+func (m *MemoryBackend) peekDimensions(ctx context.Context, req Request) (Result, error) {
+	m.dimensionMu.Lock()
+	defer m.dimensionMu.Unlock()
+	dreqs := dimensionRequests(req)
+	costs := dimensionCosts(req)
+	req.Dimensions = nil
+	states := m.dimensionStates()
+	dims := make([]Result, len(dreqs))
+	for i, dreq := range dreqs {
+		res, err := states.Peek(ctx, dreq)
+		if err != nil {
+			return Result{}, err
+		}
+		dims[i] = res
+	}
+	key, err := m.Peek(ctx, req)
+	if err != nil {
+		return Result{}, err
+	}
+	return withDimensions(key, dims, req.Cost, costs), nil
+}
+
+// dimensionStates returns the backend holding dimension states.
+// m.dimensionMu must be held.
+func (m *MemoryBackend) dimensionStates() *MemoryBackend {
+	if m.dimensions == nil {
+		m.dimensions = NewMemoryBackend(MemoryOptions{Clock: m.clock, MaxLogEntries: m.maxLogEntries, StateTTL: m.stateTTL, budget: m.budget})
+	}
+	return m.dimensions
+}
+
+// adjustWithDimensions adjusts req's key on keys by delta, then each of
+// its dimensions on dims by the dimension's Cost.
+func adjustWithDimensions(ctx context.Context, keys, dims Adjuster, req Request, delta int64) (Result, error) {
+	dreqs := dimensionRequests(req)
+	req.Dimensions = nil
+	res, err := keys.Adjust(ctx, req, delta)
+	if err != nil {
+		return Result{}, err
+	}
+	for _, dreq := range dreqs {
+		d, err := dims.Adjust(ctx, dreq, dreq.Cost)
+		if err != nil {
+			return Result{}, err
+		}
+		res.Dimensions = append(res.Dimensions, d)
+	}
+	return res, nil
+}
+
+func (r *RedisBackend) DimensionsAllow(ctx context.Context, req Request) (Result, error) {
+	call, err := r.callFor(req)
+	if err != nil {
+		return Result{}, err
+	}
+	return r.run(ctx, call)
+}
+
+// dimensionsCall wraps a key's check call and those of its dimensions,
+// which use the same script, into one call of its dimensions script. The
+// arguments are the number of calls, the arguments per call, each call's
+// cost and then every call's arguments in turn; the keys are every call's
+// keys in turn.
+func (r *RedisBackend) dimensionsCall(call *scriptCall, req Request) (*scriptCall, error) {
+	calls := []*scriptCall{call}
+	costs := []interface{}{req.Cost}
+	for _, dreq := range dimensionRequests(req) {
+		dcall, err := r.callFor(dreq)
+		if err != nil {
+			return nil, err
+		}
+		if dcall.script != call.script || len(dcall.keys) != len(call.keys) || len(dcall.args) != len(call.args) {
+			return nil, ErrDimensionsUnsupported
+		}
+		calls = append(calls, dcall)
+		costs = append(costs, dreq.Cost)
+	}
+	out := &scriptCall{
+		script: dimensionScripts[call.script],
+		args:   append([]interface{}{len(calls), len(call.args)}, costs...),
+	}
+	if out.script == nil {
+		return nil, ErrDimensionsUnsupported
+	}
+	for _, c := range calls {
+		out.keys = append(out.keys, c.keys...)
+		out.args = append(out.args, c.args...)
+	}
+	return out, nil
+}
+
+// dimensionScripts maps each check script to its dimensions script.
+var dimensionScripts = map[*redis.Script]*redis.Script{}
+
+func init() {
+	for script, src := range checkSources {
+		dimensionScripts[script] = redis.NewScript(dimensionsLua(src))
+	}
+}
+
+// dimensionsLua runs the check script once per call, as a function. The
+// dimensions are tried first without charging them; the key is charged
+// only if they all have room, and the dimensions only if the key was
+// allowed. It returns every result and cost, the key's first.
+func dimensionsLua(src string) string {
+	return `
+local function check(KEYS, ARGV)
+` + src + `
+end
+local n = tonumber(ARGV[1])
+local per_call = tonumber(ARGV[2])
+local keys_per_call = #KEYS / n
+local dry = ARGV[3 + n + n * per_call] == "1"
+
+local function call(i, dry_run)
+	local keys, args = {}, {}
+	for j = 1, keys_per_call do keys[j] = KEYS[(i - 1) * keys_per_call + j] end
+	for j = 1, per_call do args[j] = ARGV[2 + n + (i - 1) * per_call + j] end
+	if dry_run then table.insert(args, "1") end
+	return check(keys, args)
+end
+
+local results, costs = {}, {}
+local roomy = true
+for i = 2, n do
+	results[i] = call(i, true)
+	if results[i][1] == 0 then roomy = false end
+end
+if roomy and not dry then
+	results[1] = call(1, false)
+	if results[1][1] == 1 then
+		for i = 2, n do results[i] = call(i, false) end
+	end
+else
+	results[1] = call(1, true)
+end
+for i = 1, n do costs[i] = tonumber(ARGV[2 + i]) end
+return {"dimensions", results, costs}
+`
+}
+
+// parseDimensionsResult parses what a dimensions script returns, or
+// reports false for any other reply.
+func parseDimensionsResult(value interface{}) (Result, bool) {
+	items, ok := value.([]interface{})
+	if !ok || len(items) != 3 {
+		return Result{}, false
+	}
+	if tag, _ := items[0].(string); tag != "dimensions" {
+		return Result{}, false
+	}
+	results, _ := items[1].([]interface{})
+	costs, _ := items[2].([]interface{})
+	if len(results) == 0 || len(results) != len(costs) {
+		return Result{}, false
+	}
+	dims := make([]Result, len(results)-1)
+	dimCosts := make([]int64, len(costs)-1)
+	for i := range dims {
+		dims[i] = parseResult(results[i+1])
+		dimCosts[i] = toInt64(costs[i+1])
+	}
+	return withDimensions(parseResult(results[0]), dims, toInt64(costs[0]), dimCosts), true
+}
+
+func (r *Router) DimensionsAllow(ctx context.Context, req Request) (Result, error) {
+	dimensional, ok := r.backendFor(req.Key).(DimensionBackend)
+	if !ok {
+		return Result{}, ErrDimensionsUnsupported
+	}
+	return dimensional.DimensionsAllow(ctx, req)
+}
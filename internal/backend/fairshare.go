@@ -0,0 +1,227 @@
+package backend
+
+import (
+	"context"
+	"math"
+)
+
+// FairShareBackend is implemented by backends that can share one fixed
+// window's limit between the clients of a pool by weight. Each client
+// checked in the current or the previous window is active and has a share
+// of the limit in proportion to its weight among active clients. A client
+// may use more than its share as long as the others' unused shares stay
+// available to them, so an idle client's share goes to the busy ones and a
+// client under its share is never denied for the others' usage, unless they
+// used the pool up before it became active. Clients idle for a whole window
+// drop out of the pool.
+type FairShareBackend interface {
+	FairShareAllow(ctx context.Context, key, client string, weight, limit, windowMs, cost int64) (Result, error)
+}
+
+type fairPoolState struct {
+	expiry
+	windowStartMs int64
+	limit         int64
+	windowMs      int64
+	clients       map[string]*fairClientState
+}
+
+type fairClientState struct {
+	weight int64
+	used   int64
+	// lastStartMs is the start of the last window the client was checked
+	// in.
+	lastStartMs int64
+}
+
+func (m *MemoryBackend) FairShareAllow(_ context.Context, key, client string, weight, limit, windowMs, cost int64) (Result, error) {
+	if client == "" || weight <= 0 || limit <= 0 || windowMs <= 0 || cost <= 0 {
+		return Result{}, ErrInvalidParams
+	}
+	nowMs := m.clock.Now().UnixMilli()
+	startMs := nowMs - nowMs%windowMs
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expireIdle(nowMs)
+	if m.fairPools == nil {
+		m.fairPools = make(map[string]*fairPoolState)
+	}
+	pool := live(m.fairPools, key, nowMs)
+	changed := pool != nil && (pool.limit != limit || pool.windowMs != windowMs)
+	if pool == nil || pool.windowMs != windowMs {
+		pool = &fairPoolState{windowStartMs: startMs, limit: limit, windowMs: windowMs, clients: make(map[string]*fairClientState)}
+		m.fairPools[key] = pool
+	}
+	if pool.limit != limit {
+		for _, c := range pool.clients {
+			c.used = rescale(c.used, pool.limit, limit)
+		}
+		pool.limit = limit
+	}
+	if pool.windowStartMs != startMs {
+		for name, c := range pool.clients {
+			if c.lastStartMs < startMs-windowMs {
+				delete(pool.clients, name)
+			} else {
+				c.used = 0
+			}
+		}
+		pool.windowStartMs = startMs
+	}
+	// A client's usage is kept through the next window to tell idle
+	// clients from active ones.
+	pool.keep(nowMs, m.stateTTL.ttlMs(WeightedFairShare, satAdd(windowMs, windowMs)))
+	self := pool.clients[client]
+	if self == nil {
+		self = &fairClientState{}
+		pool.clients[client] = self
+	}
+	self.weight, self.lastStartMs = weight, startMs
+
+	totalWeight := int64(0)
+	for _, c := range pool.clients {
+		totalWeight = satAdd(totalWeight, c.weight)
+	}
+	// The others keep what they used or their share, whichever is more.
+	others := int64(0)
+	for name, c := range pool.clients {
+		if name != client {
+			share := int64(math.Floor(float64(limit) * float64(c.weight) / float64(totalWeight)))
+			others = satAdd(others, max(c.used, share))
+		}
+	}
+	allowed := satAdd(satAdd(self.used, cost), others) <= limit
+	if allowed {
+		self.used += cost
+	}
+	res := Result{
+		Allowed:       allowed,
+		Remaining:     max(0, limit-others-self.used),
+		ResetAtMs:     startMs + windowMs,
+		CurrentCount:  self.used,
+		ParamsChanged: changed,
+	}
+	if !allowed {
+		res.RetryAfterMs = startMs + windowMs - nowMs
+	}
+	return res, nil
+}
+
+func (r *RedisBackend) FairShareAllow(ctx context.Context, key, client string, weight, limit, windowMs, cost int64) (Result, error) {
+	return r.run(ctx, r.fairShareCall(key, client, weight, limit, windowMs, cost))
+}
+
+// fairShareCall keeps a pool in one hash: the window it counts, its limit
+// and window size, and the weight, usage and last window of each client as
+// w:, u: and l: fields.
+func (r *RedisBackend) fairShareCall(key, client string, weight, limit, windowMs, cost int64) *scriptCall {
+	if client == "" || weight <= 0 || limit <= 0 || windowMs <= 0 || cost <= 0 {
+		return nil
+	}
+	// A client's usage is kept through the next window to tell idle
+	// clients from active ones.
+	ttlMs := r.stateTTL.ttlMs(WeightedFairShare, 2*windowMs)
+	return &scriptCall{
+		script: fairShareScript,
+		keys:   []string{r.redisKey("wfs", key)},
+		args:   []interface{}{limit, windowMs, cost, r.nowMs(), client, weight, ttlMs},
+	}
+}
+
+var fairShareScript = checkScript(clockLua + clampLua + `
+local key = KEYS[1]
+local limit = tonumber(ARGV[1])
+local window_ms = tonumber(ARGV[2])
+local cost = tonumber(ARGV[3])
+local now_ms = resolve_now(tonumber(ARGV[4]))
+local client = ARGV[5]
+local weight = tonumber(ARGV[6])
+local ttl_ms = tonumber(ARGV[7])
+local dry = ARGV[8] == "1"
+local window_start = now_ms - (now_ms % window_ms)
+
+local fields = redis.call("HGETALL", key)
+local pool = {}
+local clients = {}
+for i = 1, #fields, 2 do
+	local name, value = fields[i], tonumber(fields[i + 1])
+	local kind, who = string.match(name, "^(%a):(.*)$")
+	if kind then
+		clients[who] = clients[who] or {weight = 0, used = 0, last = 0}
+		if kind == "w" then clients[who].weight = value
+		elseif kind == "u" then clients[who].used = value
+		elseif kind == "l" then clients[who].last = value end
+	else
+		pool[name] = value
+	end
+end
+
+local params_changed = 0
+if pool.window_ms ~= nil and (pool.limit ~= limit or pool.window_ms ~= window_ms) then
+	params_changed = 1
+end
+local stale = {}
+if pool.window_ms ~= nil and pool.window_ms ~= window_ms then
+	for who in pairs(clients) do table.insert(stale, who) end
+	clients = {}
+elseif pool.limit ~= nil and pool.limit ~= limit then
+	for _, c in pairs(clients) do c.used = math.ceil(c.used * limit / pool.limit) end
+end
+if pool.win ~= nil and pool.win ~= window_start then
+	for who, c in pairs(clients) do
+		if c.last < window_start - window_ms then
+			table.insert(stale, who)
+			clients[who] = nil
+		else
+			c.used = 0
+		end
+	end
+end
+local self = clients[client] or {weight = 0, used = 0, last = 0}
+clients[client] = self
+self.weight, self.last = weight, window_start
+
+local total_weight = 0
+for _, c in pairs(clients) do total_weight = total_weight + c.weight end
+local others = 0
+for who, c in pairs(clients) do
+	if who ~= client then
+		others = others + math.max(c.used, math.floor(limit * c.weight / total_weight))
+	end
+end
+
+local allowed = 0
+if self.used + cost + others <= limit then
+	allowed = 1
+	self.used = self.used + cost
+end
+
+if not dry then
+	for _, who in ipairs(stale) do
+		redis.call("HDEL", key, "w:" .. who, "u:" .. who, "l:" .. who)
+	end
+	local out = {"win", window_start, "limit", limit, "window_ms", window_ms}
+	for who, c in pairs(clients) do
+		table.insert(out, "w:" .. who); table.insert(out, c.weight)
+		table.insert(out, "u:" .. who); table.insert(out, c.used)
+		table.insert(out, "l:" .. who); table.insert(out, c.last)
+	end
+	redis.call("HSET", key, unpack(out))
+	redis.call("PEXPIRE", key, ttl_ms)
+end
+
+local reset_at = window_start + window_ms
+local retry_after = 0
+if allowed == 0 then retry_after = reset_at - now_ms end
+
+return {allowed, clamp(math.max(0, limit - others - self.used)), clamp(reset_at), clamp(retry_after), self.used, 0, params_changed}
+`)
+
+func (r *Router) FairShareAllow(ctx context.Context, key, client string, weight, limit, windowMs, cost int64) (Result, error) {
+	fair, ok := r.backendFor(key).(FairShareBackend)
+	if !ok {
+		return Result{}, ErrUnsupportedAlgorithm
+	}
+	return fair.FairShareAllow(ctx, key, client, weight, limit, windowMs, cost)
+}
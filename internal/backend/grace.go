@@ -0,0 +1,88 @@
+package backend
+
+import (
+	"context"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// GraceBackend is implemented by backends that can let a key go on past
+// its exhausted limit for a while: up to extra cost, for up to durationMs,
+// whichever ends first (zero leaves either unbounded). A grace begins with
+// the first check it allows and is granted once per periodMs from then on.
+type GraceBackend interface {
+	GraceAllow(ctx context.Context, key string, extra, durationMs, periodMs, cost int64) (bool, error)
+}
+
+// graceState is a key's grace, which expires with its period: a new one
+// begins afterwards anyway.
+type graceState struct {
+	expiry
+	startMs int64
+	used    int64
+}
+
+func (m *MemoryBackend) GraceAllow(_ context.Context, key string, extra, durationMs, periodMs, cost int64) (bool, error) {
+	if extra < 0 || durationMs < 0 || periodMs <= 0 || cost <= 0 {
+		return false, ErrInvalidParams
+	}
+	nowMs := m.clock.Now().UnixMilli()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.graces == nil {
+		m.graces = make(map[string]*graceState)
+	}
+	s := live(m.graces, key, nowMs)
+	if s == nil || nowMs >= satAdd(s.startMs, periodMs) {
+		s = &graceState{startMs: nowMs}
+		s.keep(nowMs, periodMs)
+	}
+	if graceOver(s.startMs, s.used, extra, durationMs, cost, nowMs) {
+		return false, nil
+	}
+	s.used += cost
+	m.graces[key] = s
+	return true, nil
+}
+
+func graceOver(startMs, used, extra, durationMs, cost, nowMs int64) bool {
+	return extra > 0 && satAdd(used, cost) > extra || durationMs > 0 && nowMs >= satAdd(startMs, durationMs)
+}
+
+func (r *RedisBackend) GraceAllow(ctx context.Context, key string, extra, durationMs, periodMs, cost int64) (bool, error) {
+	if extra < 0 || durationMs < 0 || periodMs <= 0 || cost <= 0 {
+		return false, ErrInvalidParams
+	}
+	allowed, err := graceScript.Run(ctx, r.client, []string{r.redisKey("grace", key)},
+		extra, durationMs, periodMs, cost, r.nowMs()).Int64()
+	return allowed == 1, err
+}
+
+var graceScript = redis.NewScript(clockLua + `
+local key = KEYS[1]
+local extra = tonumber(ARGV[1])
+local duration_ms = tonumber(ARGV[2])
+local period_ms = tonumber(ARGV[3])
+local cost = tonumber(ARGV[4])
+local now_ms = resolve_now(tonumber(ARGV[5]))
+
+local state = redis.call("HMGET", key, "start", "used")
+local start, used = tonumber(state[1]), tonumber(state[2]) or 0
+if start == nil or now_ms >= start + period_ms then
+	start, used = now_ms, 0
+end
+if (extra > 0 and used + cost > extra) or (duration_ms > 0 and now_ms >= start + duration_ms) then
+	return 0
+end
+redis.call("HSET", key, "start", start, "used", used + cost)
+redis.call("PEXPIRE", key, start + period_ms - now_ms)
+return 1
+`)
+
+func (r *Router) GraceAllow(ctx context.Context, key string, extra, durationMs, periodMs, cost int64) (bool, error) {
+	grace, ok := r.backendFor(key).(GraceBackend)
+	if !ok {
+		return false, nil
+	}
+	return grace.GraceAllow(ctx, key, extra, durationMs, periodMs, cost)
+}
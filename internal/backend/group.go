@@ -0,0 +1,202 @@
+package backend
+
+import (
+	"context"
+	"errors"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// GroupBackend is implemented by backends that can charge a check to a
+// budget shared by a group of keys as well as to the key's own limit, in
+// one step: the check is allowed only if both allow it, and neither is
+// charged otherwise. A request names its group with GroupKey; the budget
+// is a fixed window of GroupLimit per GroupWindowMs. The Result is the
+// stricter of the two decisions, with the group's in Group.
+type GroupBackend interface {
+	GroupAllow(ctx context.Context, req Request) (Result, error)
+}
+
+var ErrGroupUnsupported = errors.New("backend cannot charge group budgets")
+
+// groupRequest is the fixed window check of req's group.
+func groupRequest(req Request) Request {
+	return Request{Algorithm: FixedWindow, Key: req.GroupKey, Limit: req.GroupLimit, WindowMs: req.GroupWindowMs, Cost: req.Cost}
+}
+
+// withGroup merges a key's decision with its group's. A dry run that
+// allowed one of them counted a cost the denied check does not charge.
+func withGroup(key, group Result, cost int64) Result {
+	if key.Allowed && !group.Allowed {
+		key = uncharged(key, cost)
+	}
+	if group.Allowed && !key.Allowed {
+		group = uncharged(group, cost)
+	}
+	res := key
+	res.Allowed = key.Allowed && group.Allowed
+	res.Remaining = min(key.Remaining, group.Remaining)
+	res.ResetAtMs = max(key.ResetAtMs, group.ResetAtMs)
+	res.RetryAfterMs = max(key.RetryAfterMs, group.RetryAfterMs)
+	res.Group = &group
+	return res
+}
+
+// uncharged undoes the cost a dry run of an allowed check counted.
+func uncharged(res Result, cost int64) Result {
+	res.Remaining += cost
+	res.CurrentCount -= cost
+	return res
+}
+
+// GroupAllow charges group windows, kept apart from the keys' own state,
+// under groupMu, so the group cannot change between deciding and charging.
+func (m *MemoryBackend) GroupAllow(ctx context.Context, req Request) (Result, error) {
+	m.groupMu.Lock()
+	defer m.groupMu.Unlock()
+	greq := groupRequest(req)
+	req.GroupKey, req.GroupLimit, req.GroupWindowMs = "", 0, 0
+	group, err := m.groupWindows().Peek(ctx, greq)
+	if err != nil {
+		return Result{}, err
+	}
+	var key Result
+	if !group.Allowed {
+		key, err = m.Peek(ctx, req)
+	} else if key, err = Evaluate(ctx, m, req); err == nil && key.Allowed {
+		group, err = Evaluate(ctx, m.groups, greq)
+	}
+	if err != nil {
+		return Result{}, err
+	}
+	return withGroup(key, group, req.Cost), nil
+}
+
+func (m *MemoryBackend) peekGroup(ctx context.Context, req Request) (Result, error) {
+	m.groupMu.Lock()
+	defer m.groupMu.Unlock()
+	greq := groupRequest(req)
+	req.GroupKey, req.GroupLimit, req.GroupWindowMs = "", 0, 0
+	group, err := m.groupWindows().Peek(ctx, greq)
+	if err != nil {
+		return Result{}, err
+	}
+	key, err := m.Peek(ctx, req)
+	if err != nil {
+		return Result{}, err
+	}
+	return withGroup(key, group, req.Cost), nil
+}
+
+// groupWindows returns the backend holding group windows. m.groupMu must
+// be held.
+func (m *MemoryBackend) groupWindows() *MemoryBackend {
+	if m.groups == nil {
+		m.groups = NewMemoryBackend(MemoryOptions{Clock: m.clock, StateTTL: m.stateTTL, budget: m.budget})
+	}
+	return m.groups
+}
+
+func (r *RedisBackend) GroupAllow(ctx context.Context, req Request) (Result, error) {
+	call, err := r.callFor(req)
+	if err != nil {
+		return Result{}, err
+	}
+	return r.run(ctx, call)
+}
+
+// groupCall wraps a key's check call into one of its group script, with
+// the group window's keys and arguments after the key's.
+func (r *RedisBackend) groupCall(call *scriptCall, req Request) *scriptCall {
+	if req.GroupLimit <= 0 || req.GroupWindowMs <= 0 {
+		return nil
+	}
+	base := r.redisKey("grp", req.GroupKey)
+	args := append([]interface{}{len(call.args)}, call.args...)
+	args = append(args, req.GroupLimit, req.GroupWindowMs, req.Cost, r.nowMs(), 0, 0, r.stateTTL.ttlMs(FixedWindow, req.GroupWindowMs))
+	return &scriptCall{
+		script: groupScripts[call.script],
+		keys:   append(append([]string{}, call.keys...), base, base+":params"),
+		args:   args,
+	}
+}
+
+// checkSources holds the source of each check script, which group scripts
+// embed.
+var checkSources = map[*redis.Script]string{}
+
+func checkScript(src string) *redis.Script {
+	script := redis.NewScript(src)
+	checkSources[script] = src
+	return script
+}
+
+// groupScripts maps each check script to its group script.
+var groupScripts = map[*redis.Script]*redis.Script{}
+
+func init() {
+	for script, src := range checkSources {
+		groupScripts[script] = redis.NewScript(groupLua(src))
+	}
+}
+
+// groupLua runs the key's check script and the group's fixed window
+// script as functions. The group is tried first without charging it; the
+// key is charged only if the group has room, and the group only if the key
+// was allowed. It returns both results and the cost.
+func groupLua(src string) string {
+	return `
+local function check_key(KEYS, ARGV)
+` + src + `
+end
+local function check_group(KEYS, ARGV)
+` + fixedWindowLua + `
+end
+local n = tonumber(ARGV[1])
+local key_keys, key_args, group_args = {}, {}, {}
+for i = 1, #KEYS - 2 do key_keys[i] = KEYS[i] end
+for i = 1, n do key_args[i] = ARGV[1 + i] end
+for i = 1, 7 do group_args[i] = ARGV[1 + n + i] end
+local group_keys = {KEYS[#KEYS - 1], KEYS[#KEYS]}
+local dry = ARGV[9 + n] == "1"
+local cost = tonumber(group_args[3])
+
+local function dry_args(args)
+	local out = {unpack(args)}
+	table.insert(out, "1")
+	return out
+end
+
+local group = check_group(group_keys, dry_args(group_args))
+local key
+if group[1] == 0 or dry then
+	key = check_key(key_keys, dry_args(key_args))
+else
+	key = check_key(key_keys, key_args)
+	if key[1] == 1 then group = check_group(group_keys, group_args) end
+end
+return {key, group, cost}
+`
+}
+
+// parseGroupResult parses what a group script returns, or reports false
+// for any other reply.
+func parseGroupResult(value interface{}) (Result, bool) {
+	items, ok := value.([]interface{})
+	if !ok || len(items) != 3 {
+		return Result{}, false
+	}
+	if _, nested := items[0].([]interface{}); !nested {
+		return Result{}, false
+	}
+	cost, _ := items[2].(int64)
+	return withGroup(parseResult(items[0]), parseResult(items[1]), cost), true
+}
+
+func (r *Router) GroupAllow(ctx context.Context, req Request) (Result, error) {
+	grouped, ok := r.backendFor(req.Key).(GroupBackend)
+	if !ok {
+		return Result{}, ErrGroupUnsupported
+	}
+	return grouped.GroupAllow(ctx, req)
+}
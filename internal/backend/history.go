@@ -0,0 +1,204 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// KeyUsage counts one key's decisions. Peak is the most of the key's
+// allowance found used after a decision, out of Limit: the limit or
+// capacity the key was last checked with.
+type KeyUsage struct {
+	Usage
+	Peak  int64 `json:"peak"`
+	Limit int64 `json:"limit"`
+}
+
+func (u *KeyUsage) Add(o KeyUsage) {
+	u.Usage.Add(o.Usage)
+	u.Peak = max(u.Peak, o.Peak)
+	if o.Limit != 0 {
+		u.Limit = o.Limit
+	}
+}
+
+// KeyUsageBucket is a key's usage within one hour or day.
+type KeyUsageBucket struct {
+	StartMs int64 `json:"start_ms"`
+	KeyUsage
+}
+
+// KeyHistoryStore keeps per-key usage by hour and by day, like UsageStore
+// does per tenant.
+type KeyHistoryStore interface {
+	// AddKeyUsage adds each key's usage to the hour and the day containing
+	// atMs. Buckets are dropped once older than retention.
+	AddKeyUsage(ctx context.Context, atMs int64, usage map[string]KeyUsage, retention time.Duration) error
+	// KeyUsage returns the buckets of size bucketMs (HourMs or DayMs) that
+	// start in [fromMs, toMs), oldest first, including empty ones.
+	KeyUsage(ctx context.Context, key string, bucketMs, fromMs, toMs int64) ([]KeyUsageBucket, error)
+}
+
+var ErrHistoryUnsupported = errors.New("backend does not store key history")
+
+type historyKey struct {
+	key      string
+	bucketMs int64
+	startMs  int64
+}
+
+func (m *MemoryBackend) AddKeyUsage(_ context.Context, atMs int64, usage map[string]KeyUsage, retention time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.history == nil {
+		m.history = make(map[historyKey]KeyUsage)
+	}
+	for key, u := range usage {
+		for _, size := range []int64{HourMs, DayMs} {
+			hk := historyKey{key: key, bucketMs: size, startMs: atMs - atMs%size}
+			total := m.history[hk]
+			total.Add(u)
+			m.history[hk] = total
+		}
+	}
+	cutoff := atMs - retention.Milliseconds()
+	for hk := range m.history {
+		if hk.startMs+hk.bucketMs < cutoff {
+			delete(m.history, hk)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryBackend) KeyUsage(_ context.Context, key string, bucketMs, fromMs, toMs int64) ([]KeyUsageBucket, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []KeyUsageBucket
+	for _, start := range usageStarts(bucketMs, fromMs, toMs) {
+		out = append(out, KeyUsageBucket{StartMs: start, KeyUsage: m.history[historyKey{key, bucketMs, start}]})
+	}
+	return out, nil
+}
+
+func (r *RedisBackend) historyKey(key string, bucketMs, startMs int64) string {
+	size := "h"
+	if bucketMs == DayMs {
+		size = "d"
+	}
+	return r.prefix + "history:" + key + ":" + size + ":" + strconv.FormatInt(startMs, 10)
+}
+
+// AddKeyUsage runs the history script on every bucket in one pipeline.
+// Buckets whose script was not loaded yet are retried once it is, so none
+// is counted twice.
+func (r *RedisBackend) AddKeyUsage(ctx context.Context, atMs int64, usage map[string]KeyUsage, retention time.Duration) error {
+	calls := make([]*scriptCall, 0, 2*len(usage))
+	for key, u := range usage {
+		for _, size := range []int64{HourMs, DayMs} {
+			start := atMs - atMs%size
+			calls = append(calls, &scriptCall{
+				script: historyScript,
+				keys:   []string{r.historyKey(key, size, start)},
+				args:   []interface{}{u.Allowed, u.Denied, u.Cost, u.Peak, u.Limit, time.UnixMilli(start + size).Add(retention).UnixMilli()},
+			})
+		}
+	}
+	cmds := r.pipelineCalls(ctx, calls)
+	var retry []*scriptCall
+	var errs []error
+	for i, cmd := range cmds {
+		switch err := cmd.Err(); {
+		case isNoScript(err):
+			retry = append(retry, calls[i])
+		case err != nil && !errors.Is(err, redis.Nil):
+			errs = append(errs, err)
+		}
+	}
+	if len(retry) > 0 {
+		if err := historyScript.Load(ctx, r.client).Err(); err != nil {
+			return err
+		}
+		for _, cmd := range r.pipelineCalls(ctx, retry) {
+			if err := cmd.Err(); err != nil && !errors.Is(err, redis.Nil) {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (r *RedisBackend) KeyUsage(ctx context.Context, key string, bucketMs, fromMs, toMs int64) ([]KeyUsageBucket, error) {
+	starts := usageStarts(bucketMs, fromMs, toMs)
+	cmds := make([]*redis.SliceCmd, len(starts))
+	err := r.read(ctx, func(client redis.UniversalClient) error {
+		pipe := client.Pipeline()
+		for i, start := range starts {
+			cmds[i] = pipe.HMGet(ctx, r.historyKey(key, bucketMs, start), "allowed", "denied", "cost", "peak", "limit")
+		}
+		_, err := pipe.Exec(ctx)
+		return err
+	})
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	out := make([]KeyUsageBucket, len(starts))
+	for i, cmd := range cmds {
+		out[i].StartMs = starts[i]
+		values := cmd.Val()
+		for j, dst := range []*int64{&out[i].Allowed, &out[i].Denied, &out[i].Cost, &out[i].Peak, &out[i].Limit} {
+			if j < len(values) {
+				if s, ok := values[j].(string); ok {
+					*dst, _ = strconv.ParseInt(s, 10, 64)
+				}
+			}
+		}
+	}
+	return out, nil
+}
+
+// historyScript adds to one bucket's counts and raises its peak.
+var historyScript = redis.NewScript(`
+local key = KEYS[1]
+redis.call("HINCRBY", key, "allowed", ARGV[1])
+redis.call("HINCRBY", key, "denied", ARGV[2])
+redis.call("HINCRBY", key, "cost", ARGV[3])
+local peak = tonumber(redis.call("HGET", key, "peak") or "0")
+if tonumber(ARGV[4]) > peak then redis.call("HSET", key, "peak", ARGV[4]) end
+if ARGV[5] ~= "0" then redis.call("HSET", key, "limit", ARGV[5]) end
+redis.call("PEXPIREAT", key, ARGV[6])
+return 1
+`)
+
+// AddKeyUsage keeps each key's history on the backend holding the key.
+func (r *Router) AddKeyUsage(ctx context.Context, atMs int64, usage map[string]KeyUsage, retention time.Duration) error {
+	groups := make(map[Backend]map[string]KeyUsage)
+	for key, u := range usage {
+		b := r.backendFor(key)
+		if groups[b] == nil {
+			groups[b] = make(map[string]KeyUsage)
+		}
+		groups[b][key] = u
+	}
+	var errs []error
+	for b, group := range groups {
+		store, ok := b.(KeyHistoryStore)
+		if !ok {
+			errs = append(errs, ErrHistoryUnsupported)
+			continue
+		}
+		errs = append(errs, store.AddKeyUsage(ctx, atMs, group, retention))
+	}
+	return errors.Join(errs...)
+}
+
+func (r *Router) KeyUsage(ctx context.Context, key string, bucketMs, fromMs, toMs int64) ([]KeyUsageBucket, error) {
+	store, ok := r.backendFor(key).(KeyHistoryStore)
+	if !ok {
+		return nil, ErrHistoryUnsupported
+	}
+	return store.KeyUsage(ctx, key, bucketMs, fromMs, toMs)
+}
@@ -0,0 +1,79 @@
+package backend
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// HitLister is implemented by backends that can list when the most recent
+// hits of a sliding_window_log key still in its window happened, newest
+// first, one timestamp per unit of cost. Keys downgraded to a counter have
+// no hits to list.
+type HitLister interface {
+	RecentHits(ctx context.Context, key string, windowMs int64, n int) ([]int64, error)
+}
+
+func (m *MemoryBackend) RecentHits(_ context.Context, key string, windowMs int64, n int) ([]int64, error) {
+	if windowMs <= 0 || n <= 0 {
+		return nil, ErrInvalidParams
+	}
+	nowMs := m.clock.Now().UnixMilli()
+	cutoff := satAdd(nowMs, -windowMs)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	log := live(m.slidingLogs, key, nowMs)
+	if log == nil {
+		return nil, nil
+	}
+	var hits []int64
+	for i := len(log.entries) - 1; i >= 0 && len(hits) < n; i-- {
+		e := log.entries[i]
+		if e.ms <= cutoff {
+			break
+		}
+		for j := int64(0); j < e.n && len(hits) < n; j++ {
+			hits = append(hits, e.ms)
+		}
+	}
+	return hits, nil
+}
+
+// RecentHits reads the log's newest members, named "<ms>:<seq>".
+func (r *RedisBackend) RecentHits(ctx context.Context, key string, windowMs int64, n int) ([]int64, error) {
+	if windowMs <= 0 || n <= 0 {
+		return nil, ErrInvalidParams
+	}
+	var members []string
+	err := r.read(ctx, func(c redis.UniversalClient) error {
+		var err error
+		members, err = recentHitsScript.Run(ctx, c, []string{r.redisKey("swl", key)}, windowMs, r.nowMs(), n).StringSlice()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	hits := make([]int64, 0, len(members))
+	for _, member := range members {
+		ms, _, _ := strings.Cut(member, ":")
+		if at, err := strconv.ParseInt(ms, 10, 64); err == nil {
+			hits = append(hits, at)
+		}
+	}
+	return hits, nil
+}
+
+var recentHitsScript = redis.NewScript(clockLua + `
+local now_ms = resolve_now(tonumber(ARGV[2]))
+return redis.call("ZREVRANGEBYSCORE", KEYS[1], "+inf", "(" .. (now_ms - tonumber(ARGV[1])), "LIMIT", 0, tonumber(ARGV[3]))
+`)
+
+func (r *Router) RecentHits(ctx context.Context, key string, windowMs int64, n int) ([]int64, error) {
+	lister, ok := r.backendFor(key).(HitLister)
+	if !ok {
+		return nil, nil
+	}
+	return lister.RecentHits(ctx, key, windowMs, n)
+}
@@ -0,0 +1,198 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// StoredRecord is one piece of state a backend keeps for a key, as stored.
+type StoredRecord struct {
+	Algorithm string `json:"algorithm"`
+	// StorageKey is the Redis key holding the record.
+	StorageKey string            `json:"storage_key,omitempty"`
+	Fields     map[string]string `json:"fields"`
+	// TTLMs is how long the record has left, or -1 when it does not expire.
+	TTLMs int64 `json:"ttl_ms"`
+}
+
+// Inspector is implemented by backends that can show what they store for a
+// key, for debugging its decisions. Records are returned for every
+// algorithm that holds state for the key, including state at rest.
+type Inspector interface {
+	Inspect(ctx context.Context, key string) ([]StoredRecord, error)
+}
+
+var ErrInspectUnsupported = errors.New("backend cannot inspect keys")
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func formatInt(n int64) string {
+	return strconv.FormatInt(n, 10)
+}
+
+// Inspect reports the key's in-memory state, with the time left until it
+// expires if it goes idle.
+func (m *MemoryBackend) Inspect(_ context.Context, key string) ([]StoredRecord, error) {
+	nowMs := m.clock.Now().UnixMilli()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var records []StoredRecord
+	add := func(algorithm string, e expiry, fields map[string]string) {
+		ttl := int64(-1)
+		if e.expiresMs != 0 {
+			ttl = e.expiresMs - nowMs
+		}
+		records = append(records, StoredRecord{Algorithm: algorithm, Fields: fields, TTLMs: ttl})
+	}
+	if s := live(m.tokenBuckets, key, nowMs); s != nil {
+		add(TokenBucket, s.expiry, map[string]string{"tokens": formatFloat(s.tokens), "last_ms": formatInt(s.last.UnixMilli()),
+			"capacity": formatInt(s.capacity), "refill": formatFloat(s.rate)})
+	}
+	if s := live(m.leakyBuckets, key, nowMs); s != nil {
+		add(LeakyBucket, s.expiry, map[string]string{"water": formatFloat(s.water), "last_ms": formatInt(s.last.UnixMilli()),
+			"capacity": formatInt(s.capacity), "leak": formatFloat(s.rate)})
+	}
+	if s := live(m.fixedWindows, key, nowMs); s != nil {
+		add(FixedWindow, s.expiry, map[string]string{"count": formatInt(s.count), "window_start_ms": formatInt(s.windowStartMs),
+			"window_end_ms": formatInt(s.endMs()), "limit": formatInt(s.limit), "window_ms": formatInt(s.windowMs)})
+	}
+	if s := live(m.slidingLogs, key, nowMs); s != nil {
+		// Entries are keyed by the millisecond they were admitted at.
+		fields := map[string]string{"count": formatInt(s.count), "limit": formatInt(s.limit), "window_ms": formatInt(s.windowMs)}
+		for _, e := range s.entries {
+			fields["entry:"+formatInt(e.ms)] = formatInt(e.n)
+		}
+		add(SlidingWindowLog, s.expiry, fields)
+	}
+	for algorithm, counters := range map[string]map[string]*slidingCounterState{SlidingWindowCounter: m.slidingCounters, SlidingWindowLog: m.logCounters} {
+		if s := live(counters, key, nowMs); s != nil {
+			add(algorithm, s.expiry, map[string]string{"window_start_ms": formatInt(s.windowStartMs), "current_count": formatInt(s.currentCount),
+				"prev_count": formatInt(s.prevCount), "limit": formatInt(s.limit), "window_ms": formatInt(s.windowMs)})
+		}
+	}
+	return records, nil
+}
+
+// Inspect reads every Redis key the scripts may keep for key, through a
+// replica unless ctx asks for the primary. Window counts are read for the
+// current and previous window of the stored parameters.
+func (r *RedisBackend) Inspect(ctx context.Context, key string) ([]StoredRecord, error) {
+	nowMs, err := r.stateNow(ctx)
+	if err != nil {
+		return nil, err
+	}
+	fixed, logKey, counter := r.redisKey("", key), r.redisKey("swl", key), r.redisKey("swc", key)
+	var records []StoredRecord
+	err = r.read(ctx, func(client redis.UniversalClient) error {
+		records = records[:0]
+		pipe := client.Pipeline()
+		hashes := map[string]*redis.StringStringMapCmd{}
+		ttls := map[string]*redis.DurationCmd{}
+		for _, k := range []string{r.redisKey("tb", key), r.redisKey("lb", key)} {
+			hashes[k] = pipe.HGetAll(ctx, k)
+		}
+		params := map[string]*redis.StringCmd{}
+		for _, base := range []string{fixed, logKey, counter} {
+			params[base] = pipe.Get(ctx, base+":params")
+			ttls[base+":params"] = pipe.PTTL(ctx, base+":params")
+		}
+		entries := pipe.ZRangeWithScores(ctx, logKey, 0, -1)
+		seq := pipe.Get(ctx, logKey+":seq")
+		for _, k := range []string{r.redisKey("tb", key), r.redisKey("lb", key), logKey, logKey + ":seq"} {
+			ttls[k] = pipe.PTTL(ctx, k)
+		}
+		if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+
+		add := func(algorithm, storageKey string, fields map[string]string) {
+			records = append(records, StoredRecord{Algorithm: algorithm, StorageKey: storageKey, Fields: fields, TTLMs: ttlMs(ttls[storageKey])})
+		}
+		if fields := hashes[r.redisKey("tb", key)].Val(); len(fields) > 0 {
+			add(TokenBucket, r.redisKey("tb", key), fields)
+		}
+		if fields := hashes[r.redisKey("lb", key)].Val(); len(fields) > 0 {
+			add(LeakyBucket, r.redisKey("lb", key), fields)
+		}
+		if z := entries.Val(); len(z) > 0 {
+			fields := make(map[string]string, len(z))
+			for _, e := range z {
+				member, _ := e.Member.(string)
+				fields[member] = formatInt(int64(e.Score))
+			}
+			add(SlidingWindowLog, logKey, fields)
+		}
+		if v, err := seq.Result(); err == nil {
+			add(SlidingWindowLog, logKey+":seq", map[string]string{"value": v})
+		}
+
+		// Window counts live under keys named by the window's start.
+		pipe = client.Pipeline()
+		type window struct {
+			algorithm, storageKey string
+			count                 *redis.StringCmd
+		}
+		var windows []window
+		for _, w := range []struct{ algorithm, base string }{{FixedWindow, fixed}, {SlidingWindowLog, logKey}, {SlidingWindowCounter, counter}} {
+			v, err := params[w.base].Result()
+			if err != nil {
+				continue
+			}
+			add(w.algorithm, w.base+":params", map[string]string{"value": v})
+			if w.algorithm == SlidingWindowLog {
+				continue
+			}
+			_, windowStr, _ := strings.Cut(v, ":")
+			windowMs, err := strconv.ParseInt(windowStr, 10, 64)
+			if err != nil || windowMs <= 0 {
+				continue
+			}
+			start := nowMs - nowMs%windowMs
+			for _, s := range []int64{start - windowMs, start} {
+				k := w.base + ":" + formatInt(s)
+				windows = append(windows, window{algorithm: w.algorithm, storageKey: k, count: pipe.Get(ctx, k)})
+				ttls[k] = pipe.PTTL(ctx, k)
+			}
+		}
+		if len(windows) == 0 {
+			return nil
+		}
+		if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+		for _, w := range windows {
+			if v, err := w.count.Result(); err == nil {
+				add(w.algorithm, w.storageKey, map[string]string{"count": v})
+			}
+		}
+		return nil
+	})
+	return records, err
+}
+
+// ttlMs turns a PTTL reply into milliseconds left, or -1 for none.
+func ttlMs(cmd *redis.DurationCmd) int64 {
+	if cmd == nil {
+		return -1
+	}
+	d, err := cmd.Result()
+	if err != nil || d < 0 {
+		return -1
+	}
+	return int64(d / time.Millisecond)
+}
+
+func (r *Router) Inspect(ctx context.Context, key string) ([]StoredRecord, error) {
+	inspector, ok := r.backendFor(key).(Inspector)
+	if !ok {
+		return nil, ErrInspectUnsupported
+	}
+	return inspector.Inspect(ctx, key)
+}
@@ -0,0 +1,87 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+)
+
+// Invalidation kinds: InvalidateKey covers the state of Key, or of every
+// key when Key is empty; InvalidateMultipliers covers the multipliers.
+const (
+	InvalidateKey         = "key"
+	InvalidateMultipliers = "multipliers"
+)
+
+// Invalidation tells instances that state they may hold copies of was
+// changed other than by a check.
+type Invalidation struct {
+	Kind string `json:"kind"`
+	Key  string `json:"key,omitempty"`
+}
+
+// Invalidator is implemented by backends that can pass invalidations
+// between the instances using them. FollowInvalidations calls fn with each
+// one published, by any instance, until ctx ends or the subscription
+// fails.
+type Invalidator interface {
+	PublishInvalidation(ctx context.Context, inv Invalidation) error
+	FollowInvalidations(ctx context.Context, fn func(Invalidation)) error
+}
+
+var ErrInvalidationsUnsupported = errors.New("backend cannot pass invalidations")
+
+// invalidationsChannel is the pub/sub channel invalidations go through.
+func (r *RedisBackend) invalidationsChannel() string {
+	return r.prefix + "invalidations"
+}
+
+func (r *RedisBackend) PublishInvalidation(ctx context.Context, inv Invalidation) error {
+	msg, err := json.Marshal(inv)
+	if err != nil {
+		return err
+	}
+	return r.client.Publish(ctx, r.invalidationsChannel(), msg).Err()
+}
+
+func (r *RedisBackend) FollowInvalidations(ctx context.Context, fn func(Invalidation)) error {
+	sub := r.client.Subscribe(ctx, r.invalidationsChannel())
+	defer sub.Close()
+	if _, err := sub.Receive(ctx); err != nil {
+		return err
+	}
+	messages := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-messages:
+			if !ok {
+				return errors.New("invalidations subscription closed")
+			}
+			var inv Invalidation
+			// Anything else on the channel is not ours to act on.
+			if json.Unmarshal([]byte(msg.Payload), &inv) != nil {
+				continue
+			}
+			fn(inv)
+		}
+	}
+}
+
+// Invalidations go through the fallback, which every instance shares.
+func (r *Router) PublishInvalidation(ctx context.Context, inv Invalidation) error {
+	inval, ok := r.fallback.(Invalidator)
+	if !ok {
+		return ErrInvalidationsUnsupported
+	}
+	return inval.PublishInvalidation(ctx, inv)
+}
+
+func (r *Router) FollowInvalidations(ctx context.Context, fn func(Invalidation)) error {
+	inval, ok := r.fallback.(Invalidator)
+	if !ok {
+		return ErrInvalidationsUnsupported
+	}
+	return inval.FollowInvalidations(ctx, fn)
+}
@@ -0,0 +1,166 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// KeyCount is how many keys one store tracks for one algorithm.
+type KeyCount struct {
+	// Backend is the kind of store: memory or redis.
+	Backend string `json:"backend"`
+	// Route is the key prefix routed to the store, empty for the default
+	// one.
+	Route     string `json:"route,omitempty"`
+	Algorithm string `json:"algorithm"`
+	Keys      int64  `json:"keys"`
+}
+
+// KeyCounter is implemented by backends that can count the keys each
+// algorithm tracks, to spot keys that leak and size the store. Count-min
+// sketches track no keys of their own and are not counted.
+type KeyCounter interface {
+	CountKeys(ctx context.Context) ([]KeyCount, error)
+}
+
+var ErrKeyCountUnsupported = errors.New("backend cannot count keys")
+
+// countedAlgorithms are the algorithms counts are reported for, so each
+// has a count even when it tracks nothing.
+var countedAlgorithms = []string{TokenBucket, LeakyBucket, FixedWindow, SlidingWindowLog, SlidingWindowCounter, WeightedFairShare}
+
+func keyCounts(kind string, counts map[string]int64) []KeyCount {
+	out := make([]KeyCount, len(countedAlgorithms))
+	for i, algorithm := range countedAlgorithms {
+		out[i] = KeyCount{Backend: kind, Algorithm: algorithm, Keys: counts[algorithm]}
+	}
+	return out
+}
+
+// CountKeys counts the live states, including those of group budgets and
+// dimensions. Sliding logs kept as counters count as sliding logs.
+func (m *MemoryBackend) CountKeys(context.Context) ([]KeyCount, error) {
+	counts := make(map[string]int64)
+	m.countKeys(counts)
+	m.groupMu.Lock()
+	if m.groups != nil {
+		m.groups.countKeys(counts)
+	}
+	m.groupMu.Unlock()
+	m.dimensionMu.Lock()
+	if m.dimensions != nil {
+		m.dimensions.countKeys(counts)
+	}
+	m.dimensionMu.Unlock()
+	return keyCounts("memory", counts), nil
+}
+
+func (m *MemoryBackend) countKeys(counts map[string]int64) {
+	nowMs := m.clock.Now().UnixMilli()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	counts[TokenBucket] += countLive(m.tokenBuckets, nowMs)
+	counts[LeakyBucket] += countLive(m.leakyBuckets, nowMs)
+	counts[FixedWindow] += countLive(m.fixedWindows, nowMs)
+	counts[SlidingWindowLog] += countLive(m.slidingLogs, nowMs) + countLive(m.logCounters, nowMs)
+	counts[SlidingWindowCounter] += countLive(m.slidingCounters, nowMs)
+	counts[WeightedFairShare] += countLive(m.fairPools, nowMs)
+}
+
+func countLive[S expiring](states map[string]S, nowMs int64) int64 {
+	var n int64
+	for _, s := range states {
+		if !s.expired(nowMs) {
+			n++
+		}
+	}
+	return n
+}
+
+// CountKeys scans every node for bucket hashes, fair share pools and the
+// parameter keys that window algorithms keep next to their counts, so a
+// key is counted once however many Redis keys hold it. As with
+// ExportState, without a key prefix any other key ending in ":params" is
+// counted as a fixed window. Group budgets are counted as fixed windows.
+func (r *RedisBackend) CountKeys(ctx context.Context) ([]KeyCount, error) {
+	var mu sync.Mutex
+	counts := make(map[string]int64)
+	prefix := escapeGlob(r.prefix)
+	err := r.eachNode(ctx, func(ctx context.Context, node redis.UniversalClient) error {
+		nodeCounts := make(map[string]int64)
+		for kind, algorithm := range map[string]string{"tb": TokenBucket, "lb": LeakyBucket, "wfs": WeightedFairShare} {
+			err := scanKeys(ctx, node, prefix+kind+":*", func(keys []string) error {
+				for _, key := range keys {
+					if !strings.HasSuffix(key, ":params") {
+						nodeCounts[algorithm]++
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		err := scanKeys(ctx, node, prefix+"*:params", func(keys []string) error {
+			for _, key := range keys {
+				if algorithm := r.paramsAlgorithm(key); algorithm != "" {
+					nodeCounts[algorithm]++
+				}
+			}
+			return nil
+		})
+		mu.Lock()
+		for algorithm, n := range nodeCounts {
+			counts[algorithm] += n
+		}
+		mu.Unlock()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keyCounts("redis", counts), nil
+}
+
+// paramsAlgorithm is the algorithm of the window-based key whose
+// parameters paramsKey holds, or "" for the parameters of other kinds.
+func (r *RedisBackend) paramsAlgorithm(paramsKey string) string {
+	kind, _, _ := strings.Cut(strings.TrimPrefix(paramsKey, r.prefix), ":")
+	switch kind {
+	case "swl":
+		return SlidingWindowLog
+	case "swc":
+		return SlidingWindowCounter
+	case "tb", "lb", "wfs", "grace", "keys", "usage", "lease", "cms":
+		return ""
+	}
+	return FixedWindow
+}
+
+// CountKeys counts every backend that can be counted, each under the
+// prefix routed to it.
+func (r *Router) CountKeys(ctx context.Context) ([]KeyCount, error) {
+	routes := append([]route(nil), r.routes...)
+	sort.Slice(routes, func(i, j int) bool { return routes[i].prefix < routes[j].prefix })
+	routes = append([]route{{backend: r.fallback}}, routes...)
+	var out []KeyCount
+	var errs []error
+	for _, rt := range routes {
+		counter, ok := rt.backend.(KeyCounter)
+		if !ok {
+			continue
+		}
+		counts, err := counter.CountKeys(ctx)
+		for _, c := range counts {
+			c.Route = rt.prefix
+			out = append(out, c)
+		}
+		errs = append(errs, err)
+	}
+	return out, errors.Join(errs...)
+}
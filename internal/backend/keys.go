@@ -0,0 +1,165 @@
+package backend
+
+import (
+	"container/list"
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// KeyTracker is implemented by backends that can bound how many distinct
+// keys make up a set, such as one tenant's, so no set can fill the store.
+type KeyTracker interface {
+	// TrackKey notes that key, a member of set, is in use. A tracked key is
+	// always admitted; a new one while fewer than limit keys are tracked. At
+	// the cap a new key is refused, or with evict the least recently used
+	// key is forgotten, state and all, to make room. Keys unused for idle
+	// stop counting.
+	TrackKey(ctx context.Context, set, key string, limit int64, idle time.Duration, evict bool) (bool, error)
+}
+
+// keySet orders a set's keys from most to least recently used.
+type keySet struct {
+	order *list.List
+	items map[string]*list.Element
+}
+
+type trackedKey struct {
+	key    string
+	seenMs int64
+}
+
+func (m *MemoryBackend) TrackKey(_ context.Context, set, key string, limit int64, idle time.Duration, evict bool) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	nowMs := m.clock.Now().UnixMilli()
+	if m.keySets == nil {
+		m.keySets = make(map[string]*keySet)
+	}
+	s := m.keySets[set]
+	if s == nil {
+		s = &keySet{order: list.New(), items: make(map[string]*list.Element)}
+		m.keySets[set] = s
+	}
+	if e, ok := s.items[key]; ok {
+		e.Value.(*trackedKey).seenMs = nowMs
+		s.order.MoveToFront(e)
+		return true, nil
+	}
+	for e := s.order.Back(); e != nil && e.Value.(*trackedKey).seenMs <= nowMs-idle.Milliseconds(); e = s.order.Back() {
+		delete(s.items, e.Value.(*trackedKey).key)
+		s.order.Remove(e)
+	}
+	if int64(s.order.Len()) >= limit {
+		if !evict {
+			return false, nil
+		}
+		oldest := s.order.Back()
+		forgotten := oldest.Value.(*trackedKey).key
+		delete(s.items, forgotten)
+		s.order.Remove(oldest)
+		m.forget(forgotten)
+	}
+	s.items[key] = s.order.PushFront(&trackedKey{key: key, seenMs: nowMs})
+	return true, nil
+}
+
+// forget drops every algorithm's state for key. Callers hold m.mu.
+func (m *MemoryBackend) forget(key string) {
+	delete(m.tokenBuckets, key)
+	delete(m.leakyBuckets, key)
+	delete(m.fixedWindows, key)
+	delete(m.slidingLogs, key)
+	delete(m.slidingCounters, key)
+	delete(m.logCounters, key)
+	delete(m.fairPools, key)
+	delete(m.graces, key)
+}
+
+var trackKeyScript = redis.NewScript(clockLua + `
+local set = KEYS[1]
+local key = ARGV[1]
+local now_ms = resolve_now(tonumber(ARGV[2]))
+local max = tonumber(ARGV[3])
+local idle_ms = tonumber(ARGV[4])
+
+if redis.call("ZSCORE", set, key) then
+	redis.call("ZADD", set, now_ms, key)
+	redis.call("PEXPIRE", set, idle_ms)
+	return {1, ""}
+end
+
+redis.call("ZREMRANGEBYSCORE", set, "-inf", now_ms - idle_ms)
+local evicted = ""
+if redis.call("ZCARD", set) >= max then
+	if ARGV[5] ~= "1" then return {0, ""} end
+	evicted = redis.call("ZRANGE", set, 0, 0)[1]
+	redis.call("ZREM", set, evicted)
+end
+redis.call("ZADD", set, now_ms, key)
+redis.call("PEXPIRE", set, idle_ms)
+return {1, evicted}
+`)
+
+// forgetScript deletes a key's state under every algorithm. Window counts
+// live under keys named for the window start, found from the stored window
+// size; older windows expire on their own within a second.
+var forgetScript = redis.NewScript(clockLua + `
+local now_ms = resolve_now(tonumber(ARGV[1]))
+local function del_windows(base_key, params_key)
+	local params = redis.call("GET", params_key)
+	if not params then return end
+	local window_ms = tonumber(string.match(params, ":(%d+)$"))
+	if not window_ms or window_ms <= 0 then return end
+	local start = now_ms - (now_ms % window_ms)
+	redis.call("DEL", base_key .. ":" .. start, base_key .. ":" .. (start - window_ms))
+end
+del_windows(KEYS[3], KEYS[4])
+del_windows(KEYS[8], KEYS[9])
+return redis.call("DEL", KEYS[1], KEYS[2], KEYS[4], KEYS[5], KEYS[6], KEYS[7], KEYS[9])
+`)
+
+func (r *RedisBackend) TrackKey(ctx context.Context, set, key string, limit int64, idle time.Duration, evict bool) (bool, error) {
+	evictArg := 0
+	if evict {
+		evictArg = 1
+	}
+	res, err := trackKeyScript.Run(ctx, r.client, []string{r.prefix + "keys:" + set},
+		key, r.nowMs(), limit, idle.Milliseconds(), evictArg).Slice()
+	if err != nil {
+		return false, err
+	}
+	if len(res) < 2 || toInt64(res[0]) != 1 {
+		return false, nil
+	}
+	if evicted, _ := res[1].(string); evicted != "" {
+		if err := r.forget(ctx, evicted); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (r *RedisBackend) forget(ctx context.Context, key string) error {
+	fixed := r.redisKey("", key)
+	logKey := r.redisKey("swl", key)
+	counter := r.redisKey("swc", key)
+	keys := []string{
+		r.redisKey("tb", key), r.redisKey("lb", key),
+		fixed, fixed + ":params",
+		logKey, logKey + ":seq", logKey + ":params",
+		counter, counter + ":params",
+		r.redisKey("wfs", key), r.redisKey("grace", key),
+	}
+	return forgetScript.Run(ctx, r.client, keys, r.nowMs()).Err()
+}
+
+// TrackKey tracks key on the backend that holds it.
+func (r *Router) TrackKey(ctx context.Context, set, key string, limit int64, idle time.Duration, evict bool) (bool, error) {
+	tracker, ok := r.backendFor(key).(KeyTracker)
+	if !ok {
+		return true, nil
+	}
+	return tracker.TrackKey(ctx, set, key, limit, idle, evict)
+}
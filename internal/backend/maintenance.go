@@ -0,0 +1,237 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Leaser is implemented by shared backends that can grant a named lease to
+// one holder at a time, so instances sharing them can elect a leader.
+type Leaser interface {
+	// AcquireLease takes the lease for holder, or extends it if holder
+	// already has it, for ttl. It reports whether holder has the lease.
+	AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error)
+	// ReleaseLease gives the lease up if holder has it.
+	ReleaseLease(ctx context.Context, name, holder string) error
+}
+
+// Sweeper is implemented by backends whose state can outlive its use. A
+// sweep gives keys without an expiry, such as ones restored from a dump
+// that dropped TTLs, one of ttl.
+type Sweeper interface {
+	SweepOrphans(ctx context.Context, ttl time.Duration) (SweepStats, error)
+}
+
+// SweepStats counts what a sweep saw, by key kind (tb, lb, swl, swc,
+// keys, usage, or "other").
+type SweepStats struct {
+	Scanned map[string]int64
+	Orphans int64
+}
+
+func (s *SweepStats) add(o SweepStats) {
+	if s.Scanned == nil {
+		s.Scanned = make(map[string]int64)
+	}
+	for kind, n := range o.Scanned {
+		s.Scanned[kind] += n
+	}
+	s.Orphans += o.Orphans
+}
+
+var acquireLeaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+	return 1
+end
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return 1
+end
+return 0
+`)
+
+var releaseLeaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+func (r *RedisBackend) leaseKey(name string) string {
+	return r.prefix + "lease:" + name
+}
+
+func (r *RedisBackend) AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	n, err := acquireLeaseScript.Run(ctx, r.client, []string{r.leaseKey(name)}, holder, ttl.Milliseconds()).Int64()
+	return n == 1, err
+}
+
+func (r *RedisBackend) ReleaseLease(ctx context.Context, name, holder string) error {
+	return releaseLeaseScript.Run(ctx, r.client, []string{r.leaseKey(name)}, holder).Err()
+}
+
+// sweepKinds are the key kinds a sweep may touch without a key prefix.
+// Fixed window counts carry no kind, so they are only swept under one.
+var sweepKinds = []string{"tb", "lb", "swl", "swc", "keys", "usage"}
+
+// SweepOrphans scans the backend's keys on every node and expires those
+// that have no TTL.
+func (r *RedisBackend) SweepOrphans(ctx context.Context, ttl time.Duration) (SweepStats, error) {
+	var patterns []string
+	if r.prefix != "" {
+		patterns = []string{escapeGlob(r.prefix) + "*"}
+	} else {
+		for _, kind := range sweepKinds {
+			patterns = append(patterns, kind+":*")
+		}
+	}
+	sweep := func(ctx context.Context, client redis.UniversalClient) (SweepStats, error) {
+		var stats SweepStats
+		for _, pattern := range patterns {
+			s, err := r.sweepPattern(ctx, client, pattern, ttl)
+			stats.add(s)
+			if err != nil {
+				return stats, err
+			}
+		}
+		return stats, nil
+	}
+	var mu sync.Mutex
+	var total SweepStats
+	err := r.eachNode(ctx, func(ctx context.Context, node redis.UniversalClient) error {
+		s, err := sweep(ctx, node)
+		mu.Lock()
+		total.add(s)
+		mu.Unlock()
+		return err
+	})
+	return total, err
+}
+
+// eachNode runs fn on every master of a cluster, concurrently, or on the
+// only server otherwise. Keys found on a node are still best read through
+// r.client, which knows their slots.
+func (r *RedisBackend) eachNode(ctx context.Context, fn func(context.Context, redis.UniversalClient) error) error {
+	cluster, ok := r.client.(*redis.ClusterClient)
+	if !ok {
+		return fn(ctx, r.client)
+	}
+	return cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+		return fn(ctx, node)
+	})
+}
+
+// scanKeys passes the keys matching pattern to fn a page at a time.
+func scanKeys(ctx context.Context, client redis.UniversalClient, pattern string, fn func([]string) error) error {
+	var cursor uint64
+	for {
+		keys, next, err := client.Scan(ctx, cursor, pattern, 1000).Result()
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			if err := fn(keys); err != nil {
+				return err
+			}
+		}
+		if cursor = next; cursor == 0 {
+			return nil
+		}
+	}
+}
+
+func (r *RedisBackend) sweepPattern(ctx context.Context, client redis.UniversalClient, pattern string, ttl time.Duration) (SweepStats, error) {
+	stats := SweepStats{Scanned: make(map[string]int64)}
+	err := scanKeys(ctx, client, pattern, func(keys []string) error {
+		pipe := client.Pipeline()
+		ttls := make([]*redis.DurationCmd, len(keys))
+		for i, key := range keys {
+			ttls[i] = pipe.PTTL(ctx, key)
+		}
+		if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+		expire := client.Pipeline()
+		for i, key := range keys {
+			stats.Scanned[r.keyKind(key)]++
+			// PTTL is -1 for a key without expiry, -2 for one that is
+			// already gone.
+			if ttls[i].Val() == -1 {
+				expire.PExpire(ctx, key, ttl)
+				stats.Orphans++
+			}
+		}
+		if expire.Len() > 0 {
+			if _, err := expire.Exec(ctx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return stats, err
+}
+
+func (r *RedisBackend) keyKind(key string) string {
+	kind, _, ok := strings.Cut(strings.TrimPrefix(key, r.prefix), ":")
+	if ok {
+		for _, k := range sweepKinds {
+			if kind == k {
+				return kind
+			}
+		}
+	}
+	if strings.HasPrefix(key, r.prefix+"lease:") {
+		return "lease"
+	}
+	return "other"
+}
+
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		if strings.ContainsRune(`*?[]\`, c) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// AcquireLease and ReleaseLease use the fallback backend, which every
+// instance shares.
+func (r *Router) AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	leaser, ok := r.fallback.(Leaser)
+	if !ok {
+		return false, errors.New("backend cannot grant leases")
+	}
+	return leaser.AcquireLease(ctx, name, holder, ttl)
+}
+
+func (r *Router) ReleaseLease(ctx context.Context, name, holder string) error {
+	leaser, ok := r.fallback.(Leaser)
+	if !ok {
+		return nil
+	}
+	return leaser.ReleaseLease(ctx, name, holder)
+}
+
+// SweepOrphans sweeps every backend that can be swept.
+func (r *Router) SweepOrphans(ctx context.Context, ttl time.Duration) (SweepStats, error) {
+	var total SweepStats
+	var errs []error
+	for _, b := range r.all() {
+		sweeper, ok := b.(Sweeper)
+		if !ok {
+			continue
+		}
+		stats, err := sweeper.SweepOrphans(ctx, ttl)
+		total.add(stats)
+		errs = append(errs, err)
+	}
+	return total, errors.Join(errs...)
+}
@@ -9,267 +9,607 @@ import (
 
 type MemoryBackend struct {
 	mu              sync.Mutex
+	clock           Clock
+	maxLogEntries   int
+	stateTTL        StateTTLs
 	tokenBuckets    map[string]*tokenBucketState
 	leakyBuckets    map[string]*leakyBucketState
 	fixedWindows    map[string]*fixedWindowState
-	slidingLogs     map[string][]int64
+	slidingLogs     map[string]*slidingLogState
 	slidingCounters map[string]*slidingCounterState
+	// logCounters holds sliding log keys that outgrew maxLogEntries and are
+	// evaluated as sliding counters until they go idle.
+	logCounters map[string]*slidingCounterState
+	sketchOpts  SketchOptions
+	sketches    map[int64]*sketchState
+	fairPools   map[string]*fairPoolState
+	graces      map[string]*graceState
+	multipliers map[string]Multiplier
+	// groups holds group budget windows; see GroupAllow.
+	groupMu sync.Mutex
+	groups  *MemoryBackend
+	// dimensions holds check dimensions; see DimensionsAllow.
+	dimensionMu sync.Mutex
+	dimensions  *MemoryBackend
+	usage       map[usageKey]Usage
+	history     map[historyKey]KeyUsage
+	keySets     map[string]*keySet
+	// budget bounds the size of the key states; see Budget. The group and
+	// dimension backends share their parent's, to which their states count
+	// as much as its own. counted is this backend's part of its bytes, and
+	// budgetChecks the checks made since it was last counted; m.mu guards
+	// both.
+	budget       *memoryBudget
+	counted      int64
+	budgetChecks int
+}
+
+// MemoryOptions configures the memory backend.
+type MemoryOptions struct {
+	// Clock defaults to NewMonotonicClock().
+	Clock Clock
+	// MaxLogEntries caps the entries a sliding log key may hold; requests
+	// arriving in the same millisecond share one entry. A key that reaches
+	// the cap is downgraded to the sliding counter algorithm. Defaults to
+	// 10000.
+	MaxLogEntries int
+	Sketch        SketchOptions
+	// StateTTL sets how long idle keys are kept, as on Redis.
+	StateTTL StateTTLs
+	// MaxBytes, when positive, caps the estimated size of the key states.
+	// Past it the states expiring soonest are evicted, trading accuracy
+	// for memory; see Budget.
+	MaxBytes int64
+	// budget, when set, is the parent's budget a group or dimension
+	// backend counts against instead of MaxBytes.
+	budget *memoryBudget
+}
+
+// Every state remembers the parameters it was last checked with. When a
+// key is checked with different ones the state is adapted and the Result
+// flags ParamsChanged: usage is rescaled to a new capacity or limit, a new
+// rate applies from now on, and a new window size starts counting afresh.
+// Like Redis keys, states expire once idle for their StateTTL.
+
+// expiry is when an idle state is dropped; zero for never.
+type expiry struct {
+	expiresMs int64
+}
+
+func (e *expiry) expired(nowMs int64) bool {
+	return e.expiresMs != 0 && nowMs >= e.expiresMs
+}
+
+// keep sets the state to expire ttlMs after nowMs.
+func (e *expiry) keep(nowMs, ttlMs int64) {
+	e.expiresMs = satAdd(nowMs, ttlMs)
+}
+
+type expiring interface {
+	expired(nowMs int64) bool
+}
+
+// live returns key's state unless it expired, in which case it is dropped.
+func live[S expiring](states map[string]S, key string, nowMs int64) S {
+	s, ok := states[key]
+	if ok && s.expired(nowMs) {
+		delete(states, key)
+		var none S
+		return none
+	}
+	return s
+}
+
+// expireSample is how many states of each algorithm a check looks at for
+// expired ones, the way Redis samples keys with a TTL.
+const expireSample = 4
+
+func expireSome[S expiring](states map[string]S, nowMs int64) {
+	n := 0
+	for key, s := range states {
+		if s.expired(nowMs) {
+			delete(states, key)
+		}
+		if n++; n == expireSample {
+			return
+		}
+	}
+}
+
+// expireIdle drops a few expired states on every check, so that keys no
+// longer checked do not pile up, and keeps within the memory budget. m.mu
+// must be held.
+func (m *MemoryBackend) expireIdle(nowMs int64) {
+	expireSome(m.tokenBuckets, nowMs)
+	expireSome(m.leakyBuckets, nowMs)
+	expireSome(m.fixedWindows, nowMs)
+	expireSome(m.slidingLogs, nowMs)
+	expireSome(m.slidingCounters, nowMs)
+	expireSome(m.logCounters, nowMs)
+	expireSome(m.fairPools, nowMs)
+	expireSome(m.graces, nowMs)
+	m.enforceBudget(nowMs)
 }
 
 type tokenBucketState struct {
-	tokens float64
-	lastMs int64
+	expiry
+	tokens   float64
+	last     time.Time
+	capacity int64
+	rate     float64
 }
 
 type leakyBucketState struct {
-	water float64
-	lastMs int64
+	expiry
+	water    float64
+	last     time.Time
+	capacity int64
+	rate     float64
 }
 
 type fixedWindowState struct {
-	count        int64
+	expiry
+	count         int64
 	windowStartMs int64
+	// windowEndMs is set for zoned windows, whose length varies.
+	windowEndMs int64
+	limit       int64
+	windowMs    int64
+}
+
+func (s *fixedWindowState) endMs() int64 {
+	if s.windowEndMs != 0 {
+		return s.windowEndMs
+	}
+	return satAdd(s.windowStartMs, s.windowMs)
+}
+
+type slidingLogState struct {
+	expiry
+	entries  []logEntry
+	count    int64
+	limit    int64
+	windowMs int64
+}
+
+type logEntry struct {
+	ms int64
+	n  int64
 }
 
 type slidingCounterState struct {
+	expiry
 	windowStartMs int64
 	currentCount  int64
 	prevCount     int64
+	limit         int64
+	windowMs      int64
+}
+
+// rescale keeps the used fraction of a count when its limit changes,
+// rounding up so an adapted key is never more permissive.
+func rescale(count, oldLimit, newLimit int64) int64 {
+	return clampInt64(math.Ceil(float64(count) * float64(newLimit) / float64(oldLimit)))
 }
 
-func NewMemoryBackend() *MemoryBackend {
-	return &MemoryBackend{
+func NewMemoryBackend(opts MemoryOptions) *MemoryBackend {
+	if opts.Clock == nil {
+		opts.Clock = NewMonotonicClock()
+	}
+	if opts.MaxLogEntries <= 0 {
+		opts.MaxLogEntries = 10000
+	}
+	m := &MemoryBackend{
+		clock:           opts.Clock,
+		maxLogEntries:   opts.MaxLogEntries,
+		stateTTL:        opts.StateTTL,
+		sketchOpts:      opts.Sketch.withDefaults(),
 		tokenBuckets:    make(map[string]*tokenBucketState),
 		leakyBuckets:    make(map[string]*leakyBucketState),
 		fixedWindows:    make(map[string]*fixedWindowState),
-		slidingLogs:     make(map[string][]int64),
+		slidingLogs:     make(map[string]*slidingLogState),
 		slidingCounters: make(map[string]*slidingCounterState),
+		logCounters:     make(map[string]*slidingCounterState),
 	}
+	m.budget = opts.budget
+	if m.budget == nil {
+		m.budget = &memoryBudget{maxBytes: opts.MaxBytes}
+	}
+	return m
+}
+
+func (m *MemoryBackend) TokenBucketAllow(ctx context.Context, key string, capacity int64, refillPerSec float64, cost int64) (Result, error) {
+	return m.TokenBucketOverdraftAllow(ctx, key, capacity, refillPerSec, 0, cost)
 }
 
-func (m *MemoryBackend) TokenBucketAllow(_ context.Context, key string, capacity int64, refillPerSec float64, cost int64) (Result, error) {
-	if capacity <= 0 || refillPerSec <= 0 || cost <= 0 {
-		return Result{}, nil
+func (m *MemoryBackend) TokenBucketOverdraftAllow(_ context.Context, key string, capacity int64, refillPerSec float64, maxDebt, cost int64) (Result, error) {
+	if capacity <= 0 || refillPerSec <= 0 || maxDebt < 0 || cost <= 0 {
+		return Result{}, ErrInvalidParams
 	}
-	nowMs := time.Now().UnixMilli()
+	now := m.clock.Now()
+	nowMs := now.UnixMilli()
 
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	m.expireIdle(nowMs)
 
-	state := m.tokenBuckets[key]
+	state := live(m.tokenBuckets, key, nowMs)
 	if state == nil {
 		state = &tokenBucketState{
-			tokens: float64(capacity),
-			lastMs: nowMs,
+			tokens:   float64(capacity),
+			last:     now,
+			capacity: capacity,
+			rate:     refillPerSec,
 		}
 		m.tokenBuckets[key] = state
 	}
+	changed := state.capacity != capacity || state.rate != refillPerSec
+	if changed {
+		state.tokens = state.tokens * float64(capacity) / float64(state.capacity)
+		state.capacity, state.rate = capacity, refillPerSec
+	}
 
-	elapsedMs := nowMs - state.lastMs
-	refill := (float64(elapsedMs) / 1000.0) * refillPerSec
+	refill := now.Sub(state.last).Seconds() * refillPerSec
 	state.tokens = math.Min(float64(capacity), state.tokens+refill)
-	state.lastMs = nowMs
+	state.last = now
+	state.keep(nowMs, m.stateTTL.ttlMs(TokenBucket, durationMs(float64(capacity+maxDebt)/refillPerSec*1000.0)))
 
-	allowed := state.tokens >= float64(cost)
+	allowed := state.tokens+float64(maxDebt) >= float64(cost)
 	if allowed {
 		state.tokens -= float64(cost)
 	}
 
-	remaining := int64(math.Floor(state.tokens))
-	resetAtMs := nowMs + int64(math.Ceil(((float64(capacity)-state.tokens)/refillPerSec)*1000.0))
+	remaining := max(0, clampInt64(math.Floor(state.tokens)))
+	resetAtMs := satAdd(nowMs, durationMs((float64(capacity)-state.tokens)/refillPerSec*1000.0))
 	retryAfterMs := int64(0)
 	if !allowed {
-		missing := float64(cost) - state.tokens
-		retryAfterMs = int64(math.Ceil((missing/refillPerSec)*1000.0))
+		missing := float64(cost) - float64(maxDebt) - state.tokens
+		retryAfterMs = durationMs(missing / refillPerSec * 1000.0)
 	}
 
 	return Result{
-		Allowed:      allowed,
-		Remaining:    remaining,
-		ResetAtMs:    resetAtMs,
-		RetryAfterMs: retryAfterMs,
+		Allowed:       allowed,
+		Remaining:     remaining,
+		ResetAtMs:     resetAtMs,
+		RetryAfterMs:  retryAfterMs,
+		ParamsChanged: changed,
 	}, nil
 }
 
 func (m *MemoryBackend) LeakyBucketAllow(_ context.Context, key string, capacity int64, leakPerSec float64, cost int64) (Result, error) {
 	if capacity <= 0 || leakPerSec <= 0 || cost <= 0 {
-		return Result{}, nil
+		return Result{}, ErrInvalidParams
 	}
-	nowMs := time.Now().UnixMilli()
+	now := m.clock.Now()
+	nowMs := now.UnixMilli()
 
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	m.expireIdle(nowMs)
 
-	state := m.leakyBuckets[key]
+	state := live(m.leakyBuckets, key, nowMs)
 	if state == nil {
 		state = &leakyBucketState{
-			water: 0,
-			lastMs: nowMs,
+			water:    0,
+			last:     now,
+			capacity: capacity,
+			rate:     leakPerSec,
 		}
 		m.leakyBuckets[key] = state
 	}
+	changed := state.capacity != capacity || state.rate != leakPerSec
+	if changed {
+		state.water = state.water * float64(capacity) / float64(state.capacity)
+		state.capacity, state.rate = capacity, leakPerSec
+	}
 
-	elapsedMs := nowMs - state.lastMs
-	leak := (float64(elapsedMs) / 1000.0) * leakPerSec
+	leak := now.Sub(state.last).Seconds() * leakPerSec
 	state.water = math.Max(0, state.water-leak)
-	state.lastMs = nowMs
+	state.last = now
+	state.keep(nowMs, m.stateTTL.ttlMs(LeakyBucket, durationMs(float64(capacity)/leakPerSec*1000.0)))
 
 	allowed := state.water+float64(cost) <= float64(capacity)
 	if allowed {
 		state.water += float64(cost)
 	}
 
-	remaining := int64(math.Floor(float64(capacity) - state.water))
-	resetAtMs := nowMs + int64(math.Ceil((state.water/leakPerSec)*1000.0))
+	remaining := clampInt64(math.Floor(float64(capacity) - state.water))
+	resetAtMs := satAdd(nowMs, durationMs(state.water/leakPerSec*1000.0))
 	retryAfterMs := int64(0)
 	if !allowed {
 		overflow := state.water + float64(cost) - float64(capacity)
-		retryAfterMs = int64(math.Ceil((overflow/leakPerSec)*1000.0))
+		retryAfterMs = durationMs(overflow / leakPerSec * 1000.0)
 	}
 
 	return Result{
-		Allowed:      allowed,
-		Remaining:    remaining,
-		ResetAtMs:    resetAtMs,
-		RetryAfterMs: retryAfterMs,
+		Allowed:       allowed,
+		Remaining:     remaining,
+		ResetAtMs:     resetAtMs,
+		RetryAfterMs:  retryAfterMs,
+		ParamsChanged: changed,
 	}, nil
 }
 
 func (m *MemoryBackend) FixedWindowAllow(_ context.Context, key string, limit int64, windowMs int64, cost int64) (Result, error) {
 	if limit <= 0 || windowMs <= 0 || cost <= 0 {
-		return Result{}, nil
+		return Result{}, ErrInvalidParams
 	}
-	nowMs := time.Now().UnixMilli()
+	return m.fixedWindow(key, limit, windowMs, cost, nil), nil
+}
+
+// fixedWindow runs a fixed window whose bounds at a time are given by
+// bounds, or aligned with the epoch when it is nil.
+func (m *MemoryBackend) fixedWindow(key string, limit int64, windowMs int64, cost int64, bounds func(nowMs int64) (int64, int64)) Result {
+	nowMs := m.clock.Now().UnixMilli()
 
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	m.expireIdle(nowMs)
 
-	state := m.fixedWindows[key]
-	if state == nil || nowMs-state.windowStartMs >= windowMs {
+	state := live(m.fixedWindows, key, nowMs)
+	changed := state != nil && (state.limit != limit || state.windowMs != windowMs)
+	if changed && state.windowMs == windowMs {
+		state.count = rescale(state.count, state.limit, limit)
+		state.limit = limit
+	}
+	startMs, endMs := nowMs-(nowMs%windowMs), int64(0)
+	if bounds != nil {
+		startMs, endMs = bounds(nowMs)
+	}
+	if state == nil || state.windowMs != windowMs || nowMs >= state.endMs() || state.windowStartMs != startMs {
 		state = &fixedWindowState{
 			count:         0,
-			windowStartMs: nowMs - (nowMs % windowMs),
+			windowStartMs: startMs,
+			windowEndMs:   endMs,
+			limit:         limit,
+			windowMs:      windowMs,
 		}
 		m.fixedWindows[key] = state
 	}
+	state.keep(nowMs, m.stateTTL.ttlMs(FixedWindow, max(windowMs, state.endMs()-state.windowStartMs)))
 
-	allowed := state.count+cost <= limit
+	allowed := cost <= limit-state.count
 	if allowed {
 		state.count += cost
 	}
 
-	resetAtMs := state.windowStartMs + windowMs
+	resetAtMs := state.endMs()
 	retryAfterMs := int64(0)
 	if !allowed {
 		retryAfterMs = resetAtMs - nowMs
 	}
 
 	return Result{
-		Allowed:      allowed,
-		Remaining:    limit - state.count,
-		ResetAtMs:    resetAtMs,
-		RetryAfterMs: retryAfterMs,
-		CurrentCount: state.count,
-	}, nil
+		Allowed:       allowed,
+		Remaining:     max(0, limit-state.count),
+		ResetAtMs:     resetAtMs,
+		RetryAfterMs:  retryAfterMs,
+		CurrentCount:  state.count,
+		ParamsChanged: changed,
+	}
 }
 
 func (m *MemoryBackend) SlidingWindowLogAllow(_ context.Context, key string, limit int64, windowMs int64, cost int64) (Result, error) {
 	if limit <= 0 || windowMs <= 0 || cost <= 0 {
-		return Result{}, nil
+		return Result{}, ErrInvalidParams
 	}
-	nowMs := time.Now().UnixMilli()
+	nowMs := m.clock.Now().UnixMilli()
 
 	m.mu.Lock()
 	defer m.mu.Unlock()
-
-	logs := m.slidingLogs[key]
-	cutoff := nowMs - windowMs
-	kept := logs[:0]
-	for _, ts := range logs {
-		if ts > cutoff {
-			kept = append(kept, ts)
+	m.expireIdle(nowMs)
+
+	// A log kept as a counter weighs a window's count through the next.
+	counterTTL := m.stateTTL.ttlMs(SlidingWindowLog, satAdd(windowMs, windowMs))
+	if counter := live(m.logCounters, key, nowMs); counter != nil {
+		changed := counter.adapt(nowMs, limit, windowMs)
+		counter.roll(nowMs, windowMs)
+		if counter.currentCount > 0 || counter.prevCount > 0 {
+			counter.keep(nowMs, counterTTL)
+			res := counter.allow(nowMs, limit, windowMs, cost)
+			res.ParamsChanged = changed
+			return res, nil
 		}
+		delete(m.logCounters, key)
+	}
+
+	log := live(m.slidingLogs, key, nowMs)
+	if log == nil {
+		log = &slidingLogState{limit: limit, windowMs: windowMs}
+		m.slidingLogs[key] = log
 	}
-	logs = kept
+	log.keep(nowMs, m.stateTTL.ttlMs(SlidingWindowLog, windowMs))
+	changed := log.limit != limit || log.windowMs != windowMs
+	log.limit, log.windowMs = limit, windowMs
+	log.expire(satAdd(nowMs, -windowMs))
 
-	allowed := int64(len(logs))+cost <= limit
+	allowed := cost <= limit-log.count
 	if allowed {
-		for i := int64(0); i < cost; i++ {
-			logs = append(logs, nowMs)
+		if n := len(log.entries); n > 0 && log.entries[n-1].ms == nowMs {
+			log.entries[n-1].n += cost
+		} else if n < m.maxLogEntries {
+			log.entries = append(log.entries, logEntry{ms: nowMs, n: cost})
+		} else {
+			delete(m.slidingLogs, key)
+			counter := log.toCounter(nowMs, limit, windowMs)
+			counter.keep(nowMs, counterTTL)
+			m.logCounters[key] = counter
+			res := counter.allow(nowMs, limit, windowMs, cost)
+			res.ParamsChanged = changed
+			return res, nil
 		}
+		log.count += cost
 	}
-	m.slidingLogs[key] = logs
 
-	var resetAtMs int64
-	if len(logs) > 0 {
-		resetAtMs = logs[0] + windowMs
-	} else {
-		resetAtMs = nowMs + windowMs
+	resetAtMs := nowMs
+	if n := len(log.entries); n > 0 {
+		resetAtMs = satAdd(log.entries[n-1].ms, windowMs)
 	}
 
 	retryAfterMs := int64(0)
 	if !allowed {
-		retryAfterMs = resetAtMs - nowMs
+		retryAfterMs = log.retryAfter(nowMs, limit, windowMs, cost)
 	}
 
 	return Result{
-		Allowed:      allowed,
-		Remaining:    limit - int64(len(logs)),
-		ResetAtMs:    resetAtMs,
-		RetryAfterMs: retryAfterMs,
-		CurrentCount: int64(len(logs)),
+		Allowed:       allowed,
+		Remaining:     max(0, limit-log.count),
+		ResetAtMs:     resetAtMs,
+		RetryAfterMs:  retryAfterMs,
+		CurrentCount:  log.count,
+		ParamsChanged: changed,
 	}, nil
 }
 
+// expire drops entries at or before cutoff.
+func (l *slidingLogState) expire(cutoff int64) {
+	drop := 0
+	for drop < len(l.entries) && l.entries[drop].ms <= cutoff {
+		l.count -= l.entries[drop].n
+		drop++
+	}
+	if drop > 0 {
+		l.entries = append(l.entries[:0], l.entries[drop:]...)
+	}
+}
+
+// retryAfter is how long until enough of the oldest entries expire for cost
+// to fit.
+func (l *slidingLogState) retryAfter(nowMs, limit, windowMs, cost int64) int64 {
+	need := l.count + cost - limit
+	if need > l.count {
+		// cost alone exceeds limit; report a full window.
+		return windowMs
+	}
+	for _, e := range l.entries {
+		need -= e.n
+		if need <= 0 {
+			return satAdd(e.ms, windowMs) - nowMs
+		}
+	}
+	return 0
+}
+
+// toCounter splits the log into the current and previous fixed windows.
+func (l *slidingLogState) toCounter(nowMs, limit, windowMs int64) *slidingCounterState {
+	counter := &slidingCounterState{
+		windowStartMs: nowMs - (nowMs % windowMs),
+		limit:         limit,
+		windowMs:      windowMs,
+	}
+	for _, e := range l.entries {
+		if e.ms >= counter.windowStartMs {
+			counter.currentCount += e.n
+		} else {
+			counter.prevCount += e.n
+		}
+	}
+	return counter
+}
+
 func (m *MemoryBackend) SlidingWindowCounterAllow(_ context.Context, key string, limit int64, windowMs int64, cost int64) (Result, error) {
 	if limit <= 0 || windowMs <= 0 || cost <= 0 {
-		return Result{}, nil
+		return Result{}, ErrInvalidParams
 	}
-	nowMs := time.Now().UnixMilli()
-	currentWindowStart := nowMs - (nowMs % windowMs)
+	nowMs := m.clock.Now().UnixMilli()
 
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	m.expireIdle(nowMs)
 
-	state := m.slidingCounters[key]
+	state := live(m.slidingCounters, key, nowMs)
 	if state == nil {
 		state = &slidingCounterState{
-			windowStartMs: currentWindowStart,
-			currentCount:  0,
-			prevCount:     0,
+			windowStartMs: nowMs - (nowMs % windowMs),
+			limit:         limit,
+			windowMs:      windowMs,
 		}
 		m.slidingCounters[key] = state
 	}
+	changed := state.adapt(nowMs, limit, windowMs)
+	state.roll(nowMs, windowMs)
+	// A window's count is still weighed through the next window.
+	state.keep(nowMs, m.stateTTL.ttlMs(SlidingWindowCounter, satAdd(windowMs, windowMs)))
+	res := state.allow(nowMs, limit, windowMs, cost)
+	res.ParamsChanged = changed
+	return res, nil
+}
 
-	if state.windowStartMs != currentWindowStart {
-		state.prevCount = state.currentCount
-		state.currentCount = 0
-		state.windowStartMs = currentWindowStart
+// adapt applies new parameters to the state and reports whether they
+// differed from the previous ones.
+func (s *slidingCounterState) adapt(nowMs, limit, windowMs int64) bool {
+	if s.limit == limit && s.windowMs == windowMs {
+		return false
+	}
+	if s.windowMs != windowMs {
+		*s = slidingCounterState{windowStartMs: nowMs - (nowMs % windowMs)}
+	} else {
+		s.currentCount = rescale(s.currentCount, s.limit, limit)
+		s.prevCount = rescale(s.prevCount, s.limit, limit)
 	}
+	s.limit, s.windowMs = limit, windowMs
+	return true
+}
 
-	elapsed := nowMs - state.windowStartMs
+// roll moves the state into the window containing nowMs.
+func (s *slidingCounterState) roll(nowMs, windowMs int64) {
+	currentWindowStart := nowMs - (nowMs % windowMs)
+	if s.windowStartMs == currentWindowStart {
+		return
+	}
+	s.prevCount = s.currentCount
+	if currentWindowStart-s.windowStartMs > windowMs {
+		s.prevCount = 0
+	}
+	s.currentCount = 0
+	s.windowStartMs = currentWindowStart
+}
+
+func (s *slidingCounterState) allow(nowMs, limit, windowMs, cost int64) Result {
+	elapsed := nowMs - s.windowStartMs
 	weight := float64(windowMs-elapsed) / float64(windowMs)
-	computed := float64(state.prevCount)*weight + float64(state.currentCount)
+	computed := float64(s.prevCount)*weight + float64(s.currentCount)
 	allowed := computed+float64(cost) <= float64(limit)
 	if allowed {
-		state.currentCount += cost
+		s.currentCount = satAdd(s.currentCount, cost)
 		computed += float64(cost)
 	}
 
-	resetAtMs := state.windowStartMs + windowMs
+	resetAtMs := nowMs
+	switch {
+	case s.currentCount > 0:
+		resetAtMs = satAdd(s.windowStartMs, satAdd(windowMs, windowMs))
+	case s.prevCount > 0:
+		resetAtMs = satAdd(s.windowStartMs, windowMs)
+	}
 	retryAfterMs := int64(0)
 	if !allowed {
-		retryAfterMs = resetAtMs - nowMs
+		retryAfterMs = slidingCounterRetry(limit, windowMs, cost, elapsed, float64(s.prevCount), float64(s.currentCount))
 	}
 
 	return Result{
 		Allowed:       allowed,
-		Remaining:     int64(math.Max(0, float64(limit)-computed)),
+		Remaining:     clampInt64(math.Max(0, math.Floor(float64(limit)-computed))),
 		ResetAtMs:     resetAtMs,
 		RetryAfterMs:  retryAfterMs,
-		CurrentCount:  state.currentCount,
-		ComputedCount: int64(math.Ceil(computed)),
-	}, nil
+		CurrentCount:  s.currentCount,
+		ComputedCount: clampInt64(math.Ceil(computed)),
+	}
+}
+
+// slidingCounterRetry is how long a denied request waits until the weighted
+// count leaves room for cost: within the current window if the previous
+// window's share alone is in the way, otherwise into the next one.
+func slidingCounterRetry(limit, windowMs, cost, elapsed int64, prev, current float64) int64 {
+	window := float64(windowMs)
+	toWindowEnd := windowMs - elapsed
+	if room := float64(limit-cost) - current; room >= 0 && prev > 0 {
+		return max(1, durationMs(window*(1-room/prev))-elapsed)
+	}
+	if limit-cost < 0 || current <= 0 {
+		return toWindowEnd
+	}
+	return satAdd(toWindowEnd, durationMs(window*(1-float64(limit-cost)/current)))
 }
 
 func (m *MemoryBackend) Close() error {
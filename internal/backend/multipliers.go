@@ -0,0 +1,123 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+)
+
+// Multiplier scales the limits of the keys its target names until
+// ExpiresAtMs.
+type Multiplier struct {
+	Factor      float64 `json:"factor"`
+	ExpiresAtMs int64   `json:"expires_at_ms"`
+}
+
+// MultiplierStore is implemented by backends that can hold limit
+// multipliers for every instance using them. Targets are opaque to the
+// store; expired multipliers are dropped and never returned.
+type MultiplierStore interface {
+	SetMultiplier(ctx context.Context, target string, m Multiplier) error
+	DeleteMultiplier(ctx context.Context, target string) error
+	Multipliers(ctx context.Context) (map[string]Multiplier, error)
+}
+
+var ErrMultipliersUnsupported = errors.New("backend cannot store multipliers")
+
+func (m *MemoryBackend) SetMultiplier(_ context.Context, target string, mult Multiplier) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.multipliers == nil {
+		m.multipliers = make(map[string]Multiplier)
+	}
+	m.multipliers[target] = mult
+	return nil
+}
+
+func (m *MemoryBackend) DeleteMultiplier(_ context.Context, target string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.multipliers, target)
+	return nil
+}
+
+func (m *MemoryBackend) Multipliers(context.Context) (map[string]Multiplier, error) {
+	nowMs := m.clock.Now().UnixMilli()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make(map[string]Multiplier, len(m.multipliers))
+	for target, mult := range m.multipliers {
+		if mult.ExpiresAtMs <= nowMs {
+			delete(m.multipliers, target)
+			continue
+		}
+		out[target] = mult
+	}
+	return out, nil
+}
+
+// multipliersKey is the hash holding every multiplier, as JSON by target.
+func (r *RedisBackend) multipliersKey() string {
+	return r.prefix + "multipliers"
+}
+
+func (r *RedisBackend) SetMultiplier(ctx context.Context, target string, m Multiplier) error {
+	value, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return r.client.HSet(ctx, r.multipliersKey(), target, value).Err()
+}
+
+func (r *RedisBackend) DeleteMultiplier(ctx context.Context, target string) error {
+	return r.client.HDel(ctx, r.multipliersKey(), target).Err()
+}
+
+func (r *RedisBackend) Multipliers(ctx context.Context) (map[string]Multiplier, error) {
+	fields, err := r.client.HGetAll(ctx, r.multipliersKey()).Result()
+	if err != nil {
+		return nil, err
+	}
+	nowMs := r.clock.Now().UnixMilli()
+	out := make(map[string]Multiplier, len(fields))
+	var expired []string
+	for target, value := range fields {
+		var m Multiplier
+		if json.Unmarshal([]byte(value), &m) != nil || m.ExpiresAtMs <= nowMs {
+			expired = append(expired, target)
+			continue
+		}
+		out[target] = m
+	}
+	if len(expired) > 0 {
+		// Another instance may have just replaced one; it is read again
+		// next time.
+		r.client.HDel(ctx, r.multipliersKey(), expired...)
+	}
+	return out, nil
+}
+
+// Multipliers apply to every backend's keys, so they live on the fallback.
+func (r *Router) SetMultiplier(ctx context.Context, target string, m Multiplier) error {
+	store, ok := r.fallback.(MultiplierStore)
+	if !ok {
+		return ErrMultipliersUnsupported
+	}
+	return store.SetMultiplier(ctx, target, m)
+}
+
+func (r *Router) DeleteMultiplier(ctx context.Context, target string) error {
+	store, ok := r.fallback.(MultiplierStore)
+	if !ok {
+		return ErrMultipliersUnsupported
+	}
+	return store.DeleteMultiplier(ctx, target)
+}
+
+func (r *Router) Multipliers(ctx context.Context) (map[string]Multiplier, error) {
+	store, ok := r.fallback.(MultiplierStore)
+	if !ok {
+		return nil, ErrMultipliersUnsupported
+	}
+	return store.Multipliers(ctx)
+}
@@ -0,0 +1,20 @@
+package backend
+
+import "context"
+
+// OverdraftBackend is implemented by backends whose token buckets can go
+// into debt: a check is allowed while the bucket holds at least its cost
+// less maxDebt, so a burst larger than what is left, or than the capacity,
+// goes through at once and is paid back from later refill. Remaining is 0
+// while the bucket is in debt.
+type OverdraftBackend interface {
+	TokenBucketOverdraftAllow(ctx context.Context, key string, capacity int64, refillPerSec float64, maxDebt, cost int64) (Result, error)
+}
+
+func (r *Router) TokenBucketOverdraftAllow(ctx context.Context, key string, capacity int64, refillPerSec float64, maxDebt, cost int64) (Result, error) {
+	overdraft, ok := r.backendFor(key).(OverdraftBackend)
+	if !ok {
+		return Result{}, ErrUnsupportedAlgorithm
+	}
+	return overdraft.TokenBucketOverdraftAllow(ctx, key, capacity, refillPerSec, maxDebt, cost)
+}
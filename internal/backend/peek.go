@@ -0,0 +1,143 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Peeker is implemented by backends that can report the decision a check
+// would get without charging for it or otherwise changing the key's state.
+type Peeker interface {
+	Peek(ctx context.Context, req Request) (Result, error)
+}
+
+var ErrPeekUnsupported = errors.New("backend cannot peek")
+
+type primaryReadsKey struct{}
+
+// WithPrimaryReads makes reads under ctx go to the primary rather than a
+// replica, so they see every write made before them.
+func WithPrimaryReads(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryReadsKey{}, true)
+}
+
+func primaryReads(ctx context.Context) bool {
+	primary, _ := ctx.Value(primaryReadsKey{}).(bool)
+	return primary
+}
+
+// Peek runs the check on a copy of the key's state.
+func (m *MemoryBackend) Peek(ctx context.Context, req Request) (Result, error) {
+	if len(req.Dimensions) > 0 {
+		return m.peekDimensions(ctx, req)
+	}
+	if req.GroupKey != "" {
+		return m.peekGroup(ctx, req)
+	}
+	if req.Algorithm == CountMinSketch {
+		// Sketches are shared by every key and too large to copy.
+		if req.Limit <= 0 || req.WindowMs <= 0 || req.Cost <= 0 {
+			return Result{}, ErrInvalidParams
+		}
+		return m.sketch(req.Key, req.Limit, req.WindowMs, req.Cost, true), nil
+	}
+	scratch := NewMemoryBackend(MemoryOptions{Clock: m.clock, MaxLogEntries: m.maxLogEntries, StateTTL: m.stateTTL})
+	key := req.Key
+	m.mu.Lock()
+	if s := m.tokenBuckets[key]; s != nil {
+		c := *s
+		scratch.tokenBuckets[key] = &c
+	}
+	if s := m.leakyBuckets[key]; s != nil {
+		c := *s
+		scratch.leakyBuckets[key] = &c
+	}
+	if s := m.fixedWindows[key]; s != nil {
+		c := *s
+		scratch.fixedWindows[key] = &c
+	}
+	if s := m.slidingLogs[key]; s != nil {
+		c := *s
+		c.entries = slices.Clone(s.entries)
+		scratch.slidingLogs[key] = &c
+	}
+	if s := m.slidingCounters[key]; s != nil {
+		c := *s
+		scratch.slidingCounters[key] = &c
+	}
+	if s := m.logCounters[key]; s != nil {
+		c := *s
+		scratch.logCounters[key] = &c
+	}
+	if s := m.fairPools[key]; s != nil {
+		c := *s
+		c.clients = make(map[string]*fairClientState, len(s.clients))
+		for name, client := range s.clients {
+			cc := *client
+			c.clients[name] = &cc
+		}
+		scratch.fairPools = map[string]*fairPoolState{key: &c}
+	}
+	m.mu.Unlock()
+	return Evaluate(ctx, scratch, req)
+}
+
+// Peek runs the check's script in a mode that writes nothing, so it can be
+// served by a replica.
+func (r *RedisBackend) Peek(ctx context.Context, req Request) (Result, error) {
+	call, err := r.callFor(req)
+	if err != nil {
+		return Result{}, err
+	}
+	call.args = append(call.args, 1)
+	var res interface{}
+	err = r.read(ctx, func(client redis.UniversalClient) error {
+		var err error
+		res, err = call.script.Run(ctx, client, call.keys, call.args...).Result()
+		return err
+	})
+	if err != nil {
+		return Result{}, err
+	}
+	return parseResult(res), nil
+}
+
+// newReplicas connects to each of the comma-separated replica addresses.
+func newReplicas(addrs string, opts RedisOptions) []redis.UniversalClient {
+	var replicas []redis.UniversalClient
+	for _, addr := range strings.Split(addrs, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			replicas = append(replicas, redis.NewClient(&redis.Options{
+				Addr:     addr,
+				Password: opts.Password,
+				DB:       opts.DB,
+			}))
+		}
+	}
+	return replicas
+}
+
+// read runs fn on the next replica in turn, or on the primary when there
+// are none, ctx asks for it, or the replica fails.
+func (r *RedisBackend) read(ctx context.Context, fn func(redis.UniversalClient) error) error {
+	if len(r.replicas) == 0 || primaryReads(ctx) {
+		return fn(r.client)
+	}
+	replica := r.replicas[int(r.nextReplica.Add(1)%uint64(len(r.replicas)))]
+	if err := fn(replica); err == nil || errors.Is(err, redis.Nil) || ctx.Err() != nil {
+		return err
+	}
+	return fn(r.client)
+}
+
+func (r *Router) Peek(ctx context.Context, req Request) (Result, error) {
+	peeker, ok := r.backendFor(req.Key).(Peeker)
+	if !ok {
+		return Result{}, ErrPeekUnsupported
+	}
+	return peeker.Peek(ctx, req)
+}
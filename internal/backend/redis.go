@@ -2,97 +2,357 @@ package backend
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math"
 	"strconv"
-	"time"
+	"strings"
+	"sync/atomic"
 
 	"github.com/go-redis/redis/v8"
 )
 
 type RedisBackend struct {
-	client *redis.Client
+	client     redis.UniversalClient
+	prefix     string
+	hashTags   bool
+	serverTime bool
+	clock      Clock
+	sketchOpts SketchOptions
+	stateTTL   StateTTLs
+	// replicas serve reads in turn; see read.
+	replicas    []redis.UniversalClient
+	nextReplica atomic.Uint64
 }
 
-func NewRedisBackend(addr, password string, db int) (*RedisBackend, error) {
-	client := redis.NewClient(&redis.Options{
-		Addr:     addr,
-		Password: password,
-		DB:       db,
+// RedisOptions configures the Redis backend. Addr may list several
+// comma-separated seed nodes, in which case a cluster client is used.
+type RedisOptions struct {
+	Addr     string
+	Password string
+	DB       int
+	// KeyPrefix is prepended to every key the backend writes.
+	KeyPrefix string
+	// HashTags wraps the caller's key in {braces} so that all keys derived
+	// from it hash to the same cluster slot. Required on Redis Cluster.
+	HashTags bool
+	// ServerTime makes the scripts read the Redis server clock instead of
+	// trusting each instance's own, so skewed instances still agree.
+	ServerTime bool
+	// Clock supplies the timestamps passed to the scripts when ServerTime
+	// is off. Defaults to the system clock.
+	Clock Clock
+	// ReplicaAddrs lists comma-separated replicas of a single primary.
+	// Peeks and usage reports are read from them.
+	ReplicaAddrs string
+	Sketch       SketchOptions
+	// StateTTL sets how long idle keys are kept.
+	StateTTL StateTTLs
+}
+
+func NewRedisBackend(opts RedisOptions) (*RedisBackend, error) {
+	client := redis.NewUniversalClient(&redis.UniversalOptions{
+		Addrs:    strings.Split(opts.Addr, ","),
+		Password: opts.Password,
+		DB:       opts.DB,
 	})
 	if err := client.Ping(context.Background()).Err(); err != nil {
 		return nil, err
 	}
-	return &RedisBackend{client: client}, nil
+	clock := opts.Clock
+	if clock == nil {
+		clock = SystemClock{}
+	}
+	return &RedisBackend{
+		client:     client,
+		prefix:     opts.KeyPrefix,
+		hashTags:   opts.HashTags,
+		serverTime: opts.ServerTime,
+		clock:      clock,
+		replicas:   newReplicas(opts.ReplicaAddrs, opts),
+		sketchOpts: opts.Sketch.withDefaults(),
+		stateTTL:   opts.StateTTL,
+	}, nil
+}
+
+// nowMs is the timestamp passed to the scripts; -1 tells them to use TIME.
+func (r *RedisBackend) nowMs() int64 {
+	if r.serverTime {
+		return -1
+	}
+	return r.clock.Now().UnixMilli()
+}
+
+// redisKey builds the storage key for an algorithm. Scripts that derive
+// extra keys (window buckets, sequence counters) only append suffixes, so
+// with hash tags enabled they stay on the caller key's slot.
+func (r *RedisBackend) redisKey(kind, key string) string {
+	if r.hashTags {
+		key = "{" + key + "}"
+	}
+	if kind == "" {
+		return r.prefix + key
+	}
+	return r.prefix + kind + ":" + key
+}
+
+// scriptCall is one prepared script invocation. A nil *scriptCall means the
+// parameters were invalid and ErrInvalidParams is returned without a round trip.
+type scriptCall struct {
+	script *redis.Script
+	keys   []string
+	args   []interface{}
 }
 
 func (r *RedisBackend) TokenBucketAllow(ctx context.Context, key string, capacity int64, refillPerSec float64, cost int64) (Result, error) {
-	if capacity <= 0 || refillPerSec <= 0 || cost <= 0 {
-		return Result{}, nil
+	return r.run(ctx, r.tokenBucketCall(key, capacity, refillPerSec, 0, cost))
+}
+
+func (r *RedisBackend) LeakyBucketAllow(ctx context.Context, key string, capacity int64, leakPerSec float64, cost int64) (Result, error) {
+	return r.run(ctx, r.leakyBucketCall(key, capacity, leakPerSec, cost))
+}
+
+func (r *RedisBackend) FixedWindowAllow(ctx context.Context, key string, limit int64, windowMs int64, cost int64) (Result, error) {
+	return r.run(ctx, r.fixedWindowCall(key, limit, windowMs, cost))
+}
+
+func (r *RedisBackend) SlidingWindowLogAllow(ctx context.Context, key string, limit int64, windowMs int64, cost int64) (Result, error) {
+	return r.run(ctx, r.slidingLogCall(key, limit, windowMs, cost))
+}
+
+func (r *RedisBackend) SlidingWindowCounterAllow(ctx context.Context, key string, limit int64, windowMs int64, cost int64) (Result, error) {
+	return r.run(ctx, r.slidingCounterCall(key, limit, windowMs, cost))
+}
+
+// AllowBatch pipelines every script invocation so a batch costs a single
+// round trip. Scripts missing from the server's cache are loaded and the
+// affected calls retried once.
+func (r *RedisBackend) AllowBatch(ctx context.Context, reqs []Request) ([]Result, []error) {
+	results := make([]Result, len(reqs))
+	errs := make([]error, len(reqs))
+	calls := make([]*scriptCall, len(reqs))
+	for i, req := range reqs {
+		call, err := r.callFor(req)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		calls[i] = call
+	}
+
+	cmds := r.pipelineCalls(ctx, calls)
+	missing := false
+	for _, cmd := range cmds {
+		if cmd != nil && isNoScript(cmd.Err()) {
+			missing = true
+			break
+		}
+	}
+	if missing {
+		loaded := make(map[*redis.Script]bool)
+		for _, call := range calls {
+			if call == nil || loaded[call.script] {
+				continue
+			}
+			loaded[call.script] = true
+			if err := call.script.Load(ctx, r.client).Err(); err != nil {
+				for i := range errs {
+					if calls[i] != nil {
+						errs[i] = err
+					}
+				}
+				return results, errs
+			}
+		}
+		cmds = r.pipelineCalls(ctx, calls)
 	}
-	nowMs := time.Now().UnixMilli()
-	ttlMs := int64(math.Ceil((float64(capacity)/refillPerSec)*1000.0)) + 1000
-	res, err := tokenBucketScript.Run(ctx, r.client, []string{"tb:" + key}, capacity, refillPerSec, cost, nowMs, ttlMs).Result()
+
+	for i, cmd := range cmds {
+		if cmd == nil {
+			continue
+		}
+		value, err := cmd.Result()
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		results[i] = parseResult(value)
+	}
+	return results, errs
+}
+
+func (r *RedisBackend) pipelineCalls(ctx context.Context, calls []*scriptCall) []*redis.Cmd {
+	cmds := make([]*redis.Cmd, len(calls))
+	pipe := r.client.Pipeline()
+	for i, call := range calls {
+		if call == nil {
+			continue
+		}
+		cmds[i] = call.script.EvalSha(ctx, pipe, call.keys, call.args...)
+	}
+	// Per-command errors are inspected by the caller.
+	_, _ = pipe.Exec(ctx)
+	return cmds
+}
+
+func isNoScript(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), "NOSCRIPT")
+}
+
+func (r *RedisBackend) run(ctx context.Context, call *scriptCall) (Result, error) {
+	if call == nil {
+		return Result{}, ErrInvalidParams
+	}
+	res, err := call.script.Run(ctx, r.client, call.keys, call.args...).Result()
 	if err != nil {
 		return Result{}, err
 	}
 	return parseResult(res), nil
 }
 
-func (r *RedisBackend) LeakyBucketAllow(ctx context.Context, key string, capacity int64, leakPerSec float64, cost int64) (Result, error) {
+func (r *RedisBackend) callFor(req Request) (*scriptCall, error) {
+	var call *scriptCall
+	switch req.Algorithm {
+	case TokenBucket:
+		call = r.tokenBucketCall(req.Key, req.Capacity, req.RefillPerSec, req.MaxDebt, req.Cost)
+	case LeakyBucket:
+		call = r.leakyBucketCall(req.Key, req.Capacity, req.LeakPerSec, req.Cost)
+	case FixedWindow:
+		if req.Timezone != "" {
+			call = r.zonedFixedWindowCall(req.Key, req.Limit, req.WindowMs, req.Timezone, req.Cost)
+			break
+		}
+		call = r.fixedWindowCall(req.Key, req.Limit, req.WindowMs, req.Cost)
+	case SlidingWindowLog:
+		call = r.slidingLogCall(req.Key, req.Limit, req.WindowMs, req.Cost)
+	case SlidingWindowCounter:
+		call = r.slidingCounterCall(req.Key, req.Limit, req.WindowMs, req.Cost)
+	case CountMinSketch:
+		call = r.sketchCall(req.Key, req.Limit, req.WindowMs, req.Cost)
+	case WeightedFairShare:
+		call = r.fairShareCall(req.Key, req.Client, req.Weight, req.Limit, req.WindowMs, req.Cost)
+	default:
+		return nil, ErrUnsupportedAlgorithm
+	}
+	if call != nil && r.hashTags && req.hashTag != "" {
+		r.retag(call, req)
+	}
+	if call != nil && req.GroupKey != "" {
+		// Hash tags put the group's window on another slot than the key.
+		if r.hashTags {
+			return nil, ErrGroupUnsupported
+		}
+		call = r.groupCall(call, req)
+	}
+	if call == nil {
+		return nil, ErrInvalidParams
+	}
+	if len(req.Dimensions) > 0 {
+		if req.GroupKey != "" {
+			return nil, ErrDimensionsUnsupported
+		}
+		return r.dimensionsCall(call, req)
+	}
+	return call, nil
+}
+
+// retag moves the hash tag of call's keys from req's whole key to
+// req.hashTag, so that a dimension's keys, {key}#name rather than
+// {key#name}, share the slot of its key's.
+func (r *RedisBackend) retag(call *scriptCall, req Request) {
+	whole := "{" + req.Key + "}"
+	tagged := "{" + req.hashTag + "}" + strings.TrimPrefix(req.Key, req.hashTag)
+	for i, key := range call.keys {
+		call.keys[i] = strings.Replace(key, whole, tagged, 1)
+	}
+}
+
+func (r *RedisBackend) TokenBucketOverdraftAllow(ctx context.Context, key string, capacity int64, refillPerSec float64, maxDebt, cost int64) (Result, error) {
+	return r.run(ctx, r.tokenBucketCall(key, capacity, refillPerSec, maxDebt, cost))
+}
+
+func (r *RedisBackend) tokenBucketCall(key string, capacity int64, refillPerSec float64, maxDebt, cost int64) *scriptCall {
+	if capacity <= 0 || refillPerSec <= 0 || maxDebt < 0 || cost <= 0 {
+		return nil
+	}
+	nowMs := r.nowMs()
+	// A bucket in debt must outlive paying it back.
+	ttlMs := r.stateTTL.ttlMs(TokenBucket, int64(math.Ceil((float64(capacity+maxDebt)/refillPerSec)*1000.0)))
+	return &scriptCall{
+		script: tokenBucketScript,
+		keys:   []string{r.redisKey("tb", key)},
+		args:   []interface{}{capacity, refillPerSec, cost, nowMs, ttlMs, maxDebt},
+	}
+}
+
+func (r *RedisBackend) leakyBucketCall(key string, capacity int64, leakPerSec float64, cost int64) *scriptCall {
 	if capacity <= 0 || leakPerSec <= 0 || cost <= 0 {
-		return Result{}, nil
+		return nil
 	}
-	nowMs := time.Now().UnixMilli()
-	ttlMs := int64(math.Ceil((float64(capacity)/leakPerSec)*1000.0)) + 1000
-	res, err := leakyBucketScript.Run(ctx, r.client, []string{"lb:" + key}, capacity, leakPerSec, cost, nowMs, ttlMs).Result()
-	if err != nil {
-		return Result{}, err
+	nowMs := r.nowMs()
+	ttlMs := r.stateTTL.ttlMs(LeakyBucket, int64(math.Ceil((float64(capacity)/leakPerSec)*1000.0)))
+	return &scriptCall{
+		script: leakyBucketScript,
+		keys:   []string{r.redisKey("lb", key)},
+		args:   []interface{}{capacity, leakPerSec, cost, nowMs, ttlMs},
 	}
-	return parseResult(res), nil
 }
 
-func (r *RedisBackend) FixedWindowAllow(ctx context.Context, key string, limit int64, windowMs int64, cost int64) (Result, error) {
+func (r *RedisBackend) fixedWindowCall(key string, limit int64, windowMs int64, cost int64) *scriptCall {
 	if limit <= 0 || windowMs <= 0 || cost <= 0 {
-		return Result{}, nil
+		return nil
 	}
-	nowMs := time.Now().UnixMilli()
-	res, err := fixedWindowScript.Run(ctx, r.client, []string{key}, limit, windowMs, cost, nowMs).Result()
-	if err != nil {
-		return Result{}, err
+	nowMs := r.nowMs()
+	return &scriptCall{
+		script: fixedWindowScript,
+		keys:   []string{r.redisKey("", key), r.redisKey("", key) + ":params"},
+		args:   []interface{}{limit, windowMs, cost, nowMs, 0, 0, r.stateTTL.ttlMs(FixedWindow, windowMs)},
 	}
-	return parseResult(res), nil
 }
 
-func (r *RedisBackend) SlidingWindowLogAllow(ctx context.Context, key string, limit int64, windowMs int64, cost int64) (Result, error) {
+func (r *RedisBackend) slidingLogCall(key string, limit int64, windowMs int64, cost int64) *scriptCall {
 	if limit <= 0 || windowMs <= 0 || cost <= 0 {
-		return Result{}, nil
+		return nil
 	}
-	nowMs := time.Now().UnixMilli()
-	res, err := slidingLogScript.Run(ctx, r.client, []string{"swl:" + key, "swl:" + key + ":seq"}, limit, windowMs, cost, nowMs).Result()
-	if err != nil {
-		return Result{}, err
+	nowMs := r.nowMs()
+	logKey := r.redisKey("swl", key)
+	return &scriptCall{
+		script: slidingLogScript,
+		keys:   []string{logKey, logKey + ":seq", logKey + ":params"},
+		args:   []interface{}{limit, windowMs, cost, nowMs, r.stateTTL.ttlMs(SlidingWindowLog, windowMs)},
 	}
-	return parseResult(res), nil
 }
 
-func (r *RedisBackend) SlidingWindowCounterAllow(ctx context.Context, key string, limit int64, windowMs int64, cost int64) (Result, error) {
+func (r *RedisBackend) slidingCounterCall(key string, limit int64, windowMs int64, cost int64) *scriptCall {
 	if limit <= 0 || windowMs <= 0 || cost <= 0 {
-		return Result{}, nil
+		return nil
 	}
-	nowMs := time.Now().UnixMilli()
-	res, err := slidingCounterScript.Run(ctx, r.client, []string{"swc:" + key}, limit, windowMs, cost, nowMs).Result()
-	if err != nil {
-		return Result{}, err
+	nowMs := r.nowMs()
+	counterKey := r.redisKey("swc", key)
+	// A window's count is still weighed through the next window.
+	ttlMs := r.stateTTL.ttlMs(SlidingWindowCounter, 2*windowMs)
+	return &scriptCall{
+		script: slidingCounterScript,
+		keys:   []string{counterKey, counterKey + ":params"},
+		args:   []interface{}{limit, windowMs, cost, nowMs, ttlMs},
 	}
-	return parseResult(res), nil
 }
 
 func (r *RedisBackend) Close() error {
-	return r.client.Close()
+	errs := []error{r.client.Close()}
+	for _, replica := range r.replicas {
+		errs = append(errs, replica.Close())
+	}
+	return errors.Join(errs...)
 }
 
 func parseResult(value interface{}) Result {
+	if res, ok := parseGroupResult(value); ok {
+		return res
+	}
+	if res, ok := parseDimensionsResult(value); ok {
+		return res
+	}
 	items, ok := value.([]interface{})
 	if !ok || len(items) < 4 {
 		return Result{}
@@ -104,6 +364,8 @@ func parseResult(value interface{}) Result {
 		RetryAfterMs:  toInt64(items[3]),
 		CurrentCount:  getOptionalInt(items, 4),
 		ComputedCount: getOptionalInt(items, 5),
+		ParamsChanged: getOptionalInt(items, 6) == 1,
+		ErrorBound:    getOptionalInt(items, 7),
 	}
 }
 
@@ -121,7 +383,7 @@ func toInt64(value interface{}) int64 {
 	case int:
 		return int64(v)
 	case float64:
-		return int64(v)
+		return clampInt64(v)
 	case string:
 		parsed, err := strconv.ParseInt(v, 10, 64)
 		if err == nil {
@@ -131,61 +393,123 @@ func toInt64(value interface{}) int64 {
 	return 0
 }
 
-var tokenBucketScript = redis.NewScript(`
+// clockLua resolves the timestamp a script runs at. A negative now_ms asks
+// for the Redis server clock; TIME is non-deterministic, so effects
+// replication is enabled first on servers that still default to verbatim.
+const clockLua = `
+if redis.replicate_commands ~= nil then redis.replicate_commands() end
+local function resolve_now(now_ms)
+	if now_ms >= 0 then return now_ms end
+	local t = redis.call("TIME")
+	return tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+end
+`
+
+// clampLua keeps returned numbers inside the int64 range. Lua computes in
+// doubles and Redis truncates them to integers on return, so anything at or
+// past 2^63 (or inf from a near-zero rate) would otherwise wrap.
+const clampLua = `
+local function clamp(x)
+	if x ~= x then return 0 end
+	if x > 9223372036854774784 then return 9223372036854774784 end
+	if x < -9223372036854774784 then return -9223372036854774784 end
+	return x
+end
+`
+
+// paramsLua records the limit and window a window-based key was last
+// checked with in a sidecar key, kept for ttl_ms like the key's state,
+// returning 1 and the previous values when they differ. A dry run only
+// compares.
+const paramsLua = `
+local function check_params(params_key, limit, window, ttl_ms, dry)
+	local params = limit .. ":" .. window
+	local old = redis.call("GET", params_key)
+	if not dry then redis.call("SET", params_key, params, "PX", ttl_ms) end
+	if not old or old == params then return 0 end
+	local old_limit, old_window = string.match(old, "^(%d+):(%d+)$")
+	return 1, tonumber(old_limit), tonumber(old_window)
+end
+`
+
+var tokenBucketScript = checkScript(clockLua + clampLua + `
 local key = KEYS[1]
 local capacity = tonumber(ARGV[1])
 local refill = tonumber(ARGV[2])
 local cost = tonumber(ARGV[3])
-local now_ms = tonumber(ARGV[4])
+local now_ms = resolve_now(tonumber(ARGV[4]))
 local ttl_ms = tonumber(ARGV[5])
+local max_debt = tonumber(ARGV[6])
+local dry = ARGV[7] == "1"
 
-local tokens = tonumber(redis.call("HGET", key, "tokens"))
-local last_ms = tonumber(redis.call("HGET", key, "last_ms"))
+local state = redis.call("HMGET", key, "tokens", "last_ms", "capacity", "refill")
+local tokens = tonumber(state[1])
+local last_ms = tonumber(state[2])
 
 if tokens == nil then tokens = capacity end
 if last_ms == nil then last_ms = now_ms end
 
-if now_ms < last_ms then last_ms = now_ms end
+-- Parameters are compared as the caller sent them, not as Lua numbers.
+local params_changed = 0
+if state[3] and (state[3] ~= ARGV[1] or state[4] ~= ARGV[2]) then
+	params_changed = 1
+	tokens = tokens * capacity / tonumber(state[3])
+end
+
+-- A caller whose clock lags the last writer must not rewind the bucket.
+if now_ms < last_ms then now_ms = last_ms end
 
 local refill_tokens = (now_ms - last_ms) / 1000 * refill
 tokens = math.min(capacity, tokens + refill_tokens)
 last_ms = now_ms
 
 local allowed = 0
-if tokens >= cost then
+if tokens + max_debt >= cost then
 	allowed = 1
 	tokens = tokens - cost
 end
 
-redis.call("HSET", key, "tokens", tokens, "last_ms", last_ms)
-redis.call("PEXPIRE", key, ttl_ms)
+if not dry then
+	redis.call("HSET", key, "tokens", tokens, "last_ms", last_ms, "capacity", ARGV[1], "refill", ARGV[2])
+	redis.call("PEXPIRE", key, ttl_ms)
+end
 
-local remaining = math.floor(tokens)
+local remaining = math.max(0, math.floor(tokens))
 local reset_at = now_ms + math.ceil(((capacity - tokens) / refill) * 1000)
 local retry_after = 0
 if allowed == 0 then
-	local missing = cost - tokens
+	local missing = cost - max_debt - tokens
 	retry_after = math.ceil((missing / refill) * 1000)
 end
 
-return {allowed, remaining, reset_at, retry_after}
+return {allowed, clamp(remaining), clamp(reset_at), clamp(retry_after), 0, 0, params_changed}
 `)
 
-var leakyBucketScript = redis.NewScript(`
+var leakyBucketScript = checkScript(clockLua + clampLua + `
 local key = KEYS[1]
 local capacity = tonumber(ARGV[1])
 local leak = tonumber(ARGV[2])
 local cost = tonumber(ARGV[3])
-local now_ms = tonumber(ARGV[4])
+local now_ms = resolve_now(tonumber(ARGV[4]))
 local ttl_ms = tonumber(ARGV[5])
+local dry = ARGV[6] == "1"
 
-local water = tonumber(redis.call("HGET", key, "water"))
-local last_ms = tonumber(redis.call("HGET", key, "last_ms"))
+local state = redis.call("HMGET", key, "water", "last_ms", "capacity", "leak")
+local water = tonumber(state[1])
+local last_ms = tonumber(state[2])
 
 if water == nil then water = 0 end
 if last_ms == nil then last_ms = now_ms end
 
-if now_ms < last_ms then last_ms = now_ms end
+-- Parameters are compared as the caller sent them, not as Lua numbers.
+local params_changed = 0
+if state[3] and (state[3] ~= ARGV[1] or state[4] ~= ARGV[2]) then
+	params_changed = 1
+	water = water * capacity / tonumber(state[3])
+end
+
+-- A caller whose clock lags the last writer must not rewind the bucket.
+if now_ms < last_ms then now_ms = last_ms end
 
 local leaked = (now_ms - last_ms) / 1000 * leak
 water = math.max(0, water - leaked)
@@ -197,8 +521,10 @@ if water + cost <= capacity then
 	water = water + cost
 end
 
-redis.call("HSET", key, "water", water, "last_ms", last_ms)
-redis.call("PEXPIRE", key, ttl_ms)
+if not dry then
+	redis.call("HSET", key, "water", water, "last_ms", last_ms, "capacity", ARGV[1], "leak", ARGV[2])
+	redis.call("PEXPIRE", key, ttl_ms)
+end
 
 local remaining = math.floor(capacity - water)
 local reset_at = now_ms + math.ceil((water / leak) * 1000)
@@ -208,76 +534,135 @@ if allowed == 0 then
 	retry_after = math.ceil((overflow / leak) * 1000)
 end
 
-return {allowed, remaining, reset_at, retry_after}
+return {allowed, clamp(remaining), clamp(reset_at), clamp(retry_after), 0, 0, params_changed}
 `)
 
-var fixedWindowScript = redis.NewScript(`
+// fixedWindowLua also charges group budgets; see groupLua.
+const fixedWindowLua = clockLua + clampLua + paramsLua + `
 local base_key = KEYS[1]
+local params_key = KEYS[2]
 local limit = tonumber(ARGV[1])
 local window_ms = tonumber(ARGV[2])
 local cost = tonumber(ARGV[3])
-local now_ms = tonumber(ARGV[4])
-
-local window_start = now_ms - (now_ms % window_ms)
+local now_ms = resolve_now(tonumber(ARGV[4]))
+-- Zoned windows come with their bounds; others are aligned with the epoch.
+local window_start = tonumber(ARGV[5])
+local window_end = tonumber(ARGV[6])
+local ttl_ms = tonumber(ARGV[7])
+local dry = ARGV[8] == "1"
+
+if window_start == 0 then
+	window_start = now_ms - (now_ms % window_ms)
+	window_end = window_start + window_ms
+end
 local key = base_key .. ":" .. window_start
-local count = redis.call("INCRBY", key, cost)
-redis.call("PEXPIRE", key, window_ms + 1000)
+local count = tonumber(redis.call("GET", key) or "0")
+
+local params_changed, old_limit, old_window = check_params(params_key, ARGV[1], ARGV[2], ttl_ms, dry)
+if params_changed == 1 and count > 0 then
+	if old_window == window_ms then
+		count = math.ceil(count * limit / old_limit)
+		if not dry then redis.call("SET", key, count, "PX", ttl_ms) end
+	else
+		count = 0
+		if not dry then redis.call("DEL", key) end
+	end
+end
 
-local allowed = 1
-if count > limit then allowed = 0 end
+local allowed = 0
+if count + cost <= limit then
+	allowed = 1
+	if dry then
+		count = count + cost
+	else
+		count = redis.call("INCRBY", key, cost)
+		redis.call("PEXPIRE", key, ttl_ms)
+	end
+end
 
-local reset_at = window_start + window_ms
+local reset_at = window_end
 local retry_after = 0
 if allowed == 0 then retry_after = reset_at - now_ms end
 
-return {allowed, limit - count, reset_at, retry_after, count}
-`)
+return {allowed, clamp(math.max(0, limit - count)), clamp(reset_at), clamp(retry_after), count, 0, params_changed}
+`
 
-var slidingLogScript = redis.NewScript(`
+var fixedWindowScript = checkScript(fixedWindowLua)
+
+var slidingLogScript = checkScript(clockLua + clampLua + paramsLua + `
 local key = KEYS[1]
 local seq_key = KEYS[2]
+local params_key = KEYS[3]
 local limit = tonumber(ARGV[1])
 local window_ms = tonumber(ARGV[2])
 local cost = tonumber(ARGV[3])
-local now_ms = tonumber(ARGV[4])
+local now_ms = resolve_now(tonumber(ARGV[4]))
+local ttl_ms = tonumber(ARGV[5])
+local dry = ARGV[6] == "1"
 
+local params_changed = check_params(params_key, ARGV[1], ARGV[2], ttl_ms, dry)
+
+-- A dry run leaves expired entries in place and skips them instead.
 local cutoff = now_ms - window_ms
-redis.call("ZREMRANGEBYSCORE", key, 0, cutoff)
-local count = redis.call("ZCARD", key)
+local count
+if dry then
+	count = redis.call("ZCOUNT", key, "(" .. cutoff, "+inf")
+else
+	redis.call("ZREMRANGEBYSCORE", key, 0, cutoff)
+	count = redis.call("ZCARD", key)
+end
 
 local allowed = 0
 if count + cost <= limit then
 	allowed = 1
-	for i = 1, cost do
-		local seq = redis.call("INCR", seq_key)
-		redis.call("ZADD", key, now_ms, now_ms .. ":" .. seq)
+	if not dry then
+		for i = 1, cost do
+			local seq = redis.call("INCR", seq_key)
+			redis.call("ZADD", key, now_ms, now_ms .. ":" .. seq)
+		end
 	end
 	count = count + cost
 end
 
-redis.call("PEXPIRE", key, window_ms + 1000)
-redis.call("PEXPIRE", seq_key, window_ms + 1000)
+if not dry then
+	redis.call("PEXPIRE", key, ttl_ms)
+	redis.call("PEXPIRE", seq_key, ttl_ms)
+end
 
-local reset_at = now_ms + window_ms
-if count > 0 then
-	local oldest = redis.call("ZRANGE", key, 0, 0, "WITHSCORES")
-	if oldest[2] ~= nil then
-		reset_at = tonumber(oldest[2]) + window_ms
+local reset_at = now_ms
+if dry and allowed == 1 then
+	reset_at = now_ms + window_ms
+elseif count > 0 then
+	local newest = redis.call("ZRANGE", key, -1, -1, "WITHSCORES")
+	if newest[2] ~= nil then
+		reset_at = tonumber(newest[2]) + window_ms
 	end
 end
 
+-- A denied request fits once enough of the oldest entries expire.
 local retry_after = 0
-if allowed == 0 then retry_after = reset_at - now_ms end
+if allowed == 0 then
+	retry_after = reset_at - now_ms
+	local need = count + cost - limit
+	if need <= count then
+		local entry = redis.call("ZRANGEBYSCORE", key, "(" .. cutoff, "+inf", "WITHSCORES", "LIMIT", need - 1, 1)
+		if entry[2] ~= nil then
+			retry_after = tonumber(entry[2]) + window_ms - now_ms
+		end
+	end
+end
 
-return {allowed, limit - count, reset_at, retry_after, count}
+return {allowed, clamp(math.max(0, limit - count)), clamp(reset_at), clamp(retry_after), count, 0, params_changed}
 `)
 
-var slidingCounterScript = redis.NewScript(`
+var slidingCounterScript = checkScript(clockLua + clampLua + paramsLua + `
 local base_key = KEYS[1]
 local limit = tonumber(ARGV[1])
 local window_ms = tonumber(ARGV[2])
 local cost = tonumber(ARGV[3])
-local now_ms = tonumber(ARGV[4])
+local now_ms = resolve_now(tonumber(ARGV[4]))
+local ttl_ms = tonumber(ARGV[5])
+local dry = ARGV[6] == "1"
 
 local current_start = now_ms - (now_ms % window_ms)
 local prev_start = current_start - window_ms
@@ -288,6 +673,20 @@ local prev_key = base_key .. ":" .. prev_start
 local current_count = tonumber(redis.call("GET", current_key) or "0")
 local prev_count = tonumber(redis.call("GET", prev_key) or "0")
 
+local params_changed, old_limit, old_window = check_params(KEYS[2], ARGV[1], ARGV[2], ttl_ms, dry)
+if params_changed == 1 then
+	if old_window == window_ms then
+		current_count = math.ceil(current_count * limit / old_limit)
+		prev_count = math.ceil(prev_count * limit / old_limit)
+		if not dry and current_count > 0 then redis.call("SET", current_key, current_count, "PX", ttl_ms) end
+		if not dry and prev_count > 0 then redis.call("SET", prev_key, prev_count, "PX", ttl_ms) end
+	else
+		current_count = 0
+		prev_count = 0
+		if not dry then redis.call("DEL", current_key, prev_key) end
+	end
+end
+
 local elapsed = now_ms - current_start
 local weight = (window_ms - elapsed) / window_ms
 local computed = (prev_count * weight) + current_count
@@ -295,18 +694,41 @@ local computed = (prev_count * weight) + current_count
 local allowed = 0
 if computed + cost <= limit then
 	allowed = 1
-	current_count = redis.call("INCRBY", current_key, cost)
+	if dry then
+		current_count = current_count + cost
+	else
+		current_count = redis.call("INCRBY", current_key, cost)
+	end
 	computed = computed + cost
 end
 
-redis.call("PEXPIRE", current_key, window_ms + 1000)
-redis.call("PEXPIRE", prev_key, window_ms + 1000)
+if not dry then
+	redis.call("PEXPIRE", current_key, ttl_ms)
+	redis.call("PEXPIRE", prev_key, ttl_ms)
+end
+
+local reset_at = now_ms
+if current_count > 0 then
+	reset_at = current_start + 2 * window_ms
+elseif prev_count > 0 then
+	reset_at = current_start + window_ms
+end
 
-local reset_at = current_start + window_ms
+-- Wait within this window if only the previous window's share is in the
+-- way, otherwise into the next one, where this window becomes previous.
 local retry_after = 0
-if allowed == 0 then retry_after = reset_at - now_ms end
+if allowed == 0 then
+	local room = limit - cost - current_count
+	if room >= 0 and prev_count > 0 then
+		retry_after = math.max(1, math.ceil(window_ms * (1 - room / prev_count)) - elapsed)
+	elseif limit - cost < 0 or current_count <= 0 then
+		retry_after = window_ms - elapsed
+	else
+		retry_after = window_ms - elapsed + math.max(0, math.ceil(window_ms * (1 - (limit - cost) / current_count)))
+	end
+end
 
-return {allowed, math.floor(limit - computed), reset_at, retry_after, current_count, math.ceil(computed)}
+return {allowed, clamp(math.max(0, math.floor(limit - computed))), clamp(reset_at), clamp(retry_after), current_count, clamp(math.ceil(computed)), params_changed}
 `)
 
 var _ = fmt.Sprintf
@@ -0,0 +1,37 @@
+package backend
+
+import (
+	"context"
+	"errors"
+)
+
+// KeyResetter is implemented by backends that can forget a key's state
+// under every algorithm, grace included, so its next check starts afresh.
+// Count-min sketch counters are shared by many keys and are left alone.
+type KeyResetter interface {
+	ResetKey(ctx context.Context, key string) error
+}
+
+// ErrResetUnsupported is returned by routers for keys routed to a backend
+// that cannot reset them.
+var ErrResetUnsupported = errors.New("backend cannot reset keys")
+
+func (m *MemoryBackend) ResetKey(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.forget(key)
+	return nil
+}
+
+func (r *RedisBackend) ResetKey(ctx context.Context, key string) error {
+	return r.forget(ctx, key)
+}
+
+// ResetKey resets key on the backend that holds it.
+func (r *Router) ResetKey(ctx context.Context, key string) error {
+	resetter, ok := r.backendFor(key).(KeyResetter)
+	if !ok {
+		return ErrResetUnsupported
+	}
+	return resetter.ResetKey(ctx, key)
+}
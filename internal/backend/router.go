@@ -0,0 +1,108 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Router sends each key to the backend registered for the longest prefix
+// of it, and every other key to a fallback. It lets some key spaces, such
+// as a noisy tenant's, live on their own store.
+type Router struct {
+	fallback Backend
+	routes   []route
+}
+
+type route struct {
+	prefix  string
+	backend Backend
+}
+
+// NewRouter routes keys starting with each prefix of routes to its backend.
+// The router owns every backend given to it and closes them all.
+func NewRouter(fallback Backend, routes map[string]Backend) *Router {
+	r := &Router{fallback: fallback}
+	for prefix, b := range routes {
+		r.routes = append(r.routes, route{prefix: prefix, backend: b})
+	}
+	sort.Slice(r.routes, func(i, j int) bool {
+		return len(r.routes[i].prefix) > len(r.routes[j].prefix)
+	})
+	return r
+}
+
+func (r *Router) backendFor(key string) Backend {
+	for _, rt := range r.routes {
+		if strings.HasPrefix(key, rt.prefix) {
+			return rt.backend
+		}
+	}
+	return r.fallback
+}
+
+func (r *Router) TokenBucketAllow(ctx context.Context, key string, capacity int64, refillPerSec float64, cost int64) (Result, error) {
+	return r.backendFor(key).TokenBucketAllow(ctx, key, capacity, refillPerSec, cost)
+}
+
+func (r *Router) LeakyBucketAllow(ctx context.Context, key string, capacity int64, leakPerSec float64, cost int64) (Result, error) {
+	return r.backendFor(key).LeakyBucketAllow(ctx, key, capacity, leakPerSec, cost)
+}
+
+func (r *Router) FixedWindowAllow(ctx context.Context, key string, limit int64, windowMs int64, cost int64) (Result, error) {
+	return r.backendFor(key).FixedWindowAllow(ctx, key, limit, windowMs, cost)
+}
+
+func (r *Router) SlidingWindowLogAllow(ctx context.Context, key string, limit int64, windowMs int64, cost int64) (Result, error) {
+	return r.backendFor(key).SlidingWindowLogAllow(ctx, key, limit, windowMs, cost)
+}
+
+func (r *Router) SlidingWindowCounterAllow(ctx context.Context, key string, limit int64, windowMs int64, cost int64) (Result, error) {
+	return r.backendFor(key).SlidingWindowCounterAllow(ctx, key, limit, windowMs, cost)
+}
+
+// AllowBatch splits reqs by backend and evaluates the groups concurrently,
+// each in one round trip where its backend can batch.
+func (r *Router) AllowBatch(ctx context.Context, reqs []Request) ([]Result, []error) {
+	groups := make(map[Backend][]int)
+	for i, req := range reqs {
+		b := r.backendFor(req.Key)
+		groups[b] = append(groups[b], i)
+	}
+	results := make([]Result, len(reqs))
+	errs := make([]error, len(reqs))
+	var wg sync.WaitGroup
+	for b, indexes := range groups {
+		wg.Add(1)
+		go func(b Backend, indexes []int) {
+			defer wg.Done()
+			batcher, ok := b.(BatchBackend)
+			if !ok {
+				for _, i := range indexes {
+					results[i], errs[i] = Evaluate(ctx, b, reqs[i])
+				}
+				return
+			}
+			group := make([]Request, len(indexes))
+			for j, i := range indexes {
+				group[j] = reqs[i]
+			}
+			groupResults, groupErrs := batcher.AllowBatch(ctx, group)
+			for j, i := range indexes {
+				results[i], errs[i] = groupResults[j], groupErrs[j]
+			}
+		}(b, indexes)
+	}
+	wg.Wait()
+	return results, errs
+}
+
+func (r *Router) Close() error {
+	errs := []error{r.fallback.Close()}
+	for _, rt := range r.routes {
+		errs = append(errs, rt.backend.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,210 @@
+package backend
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"strconv"
+)
+
+// SketchBackend is implemented by backends that can count keys in a
+// count-min sketch: a fixed window whose counts live in a table of depth
+// rows of width counters shared by every key, so memory does not grow with
+// the number of keys. A key's count is the smallest of its counters, one
+// per row. It can only be too high: by at most epsilon times the cost
+// counted in the window, except with probability delta. Each window size
+// has its own sketch.
+type SketchBackend interface {
+	SketchAllow(ctx context.Context, key string, limit int64, windowMs int64, cost int64) (Result, error)
+}
+
+// SketchOptions sets the error bounds of count-min sketches, which size
+// them: width is e/Epsilon and depth ln(1/Delta). Zero fields default to
+// an Epsilon of 0.001 and a Delta of 0.01, about 110 KB per sketch.
+type SketchOptions struct {
+	Epsilon float64
+	Delta   float64
+}
+
+func (o SketchOptions) withDefaults() SketchOptions {
+	if o.Epsilon <= 0 || o.Epsilon >= 1 {
+		o.Epsilon = 0.001
+	}
+	if o.Delta <= 0 || o.Delta >= 1 {
+		o.Delta = 0.01
+	}
+	return o
+}
+
+func (o SketchOptions) dims() (width, depth int) {
+	return int(math.Ceil(math.E / o.Epsilon)), int(math.Ceil(math.Log(1 / o.Delta)))
+}
+
+// errorBound is how far a count may be too high with total cost counted.
+func (o SketchOptions) errorBound(total int64) int64 {
+	return clampInt64(math.Ceil(o.Epsilon * float64(total)))
+}
+
+// sketchColumns picks key's counter in each row by double hashing.
+func sketchColumns(key string, width, depth int) []int {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32|1
+	cols := make([]int, depth)
+	for i := range cols {
+		cols[i] = int((h1 + uint64(i)*h2) % uint64(width))
+	}
+	return cols
+}
+
+type sketchState struct {
+	windowStartMs int64
+	total         int64
+	rows          [][]int64
+}
+
+func (m *MemoryBackend) SketchAllow(_ context.Context, key string, limit int64, windowMs int64, cost int64) (Result, error) {
+	if limit <= 0 || windowMs <= 0 || cost <= 0 {
+		return Result{}, ErrInvalidParams
+	}
+	return m.sketch(key, limit, windowMs, cost, false), nil
+}
+
+// sketch counts key in the sketch of windowMs; a dry run changes nothing.
+func (m *MemoryBackend) sketch(key string, limit, windowMs, cost int64, dry bool) Result {
+	nowMs := m.clock.Now().UnixMilli()
+	startMs := nowMs - nowMs%windowMs
+	width, depth := m.sketchOpts.dims()
+	cols := sketchColumns(key, width, depth)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.sketches[windowMs]
+	if (s == nil || s.windowStartMs != startMs) && dry {
+		// A dry run on a window nothing was counted in yet.
+		s = &sketchState{windowStartMs: startMs}
+		cols = nil
+	} else if s == nil || s.windowStartMs != startMs {
+		if m.sketches == nil {
+			m.sketches = make(map[int64]*sketchState)
+		}
+		// Sketches of other sizes go once their window has ended.
+		for size, other := range m.sketches {
+			if other.windowStartMs+size <= nowMs {
+				delete(m.sketches, size)
+			}
+		}
+		s = &sketchState{windowStartMs: startMs, rows: make([][]int64, depth)}
+		for i := range s.rows {
+			s.rows[i] = make([]int64, width)
+		}
+		m.sketches[windowMs] = s
+	}
+
+	count := int64(0)
+	for i, col := range cols {
+		if i == 0 || s.rows[i][col] < count {
+			count = s.rows[i][col]
+		}
+	}
+	allowed := satAdd(count, cost) <= limit
+	if allowed {
+		count += cost
+		if !dry {
+			// Conservative update: counters already above the new count
+			// keep their value, which keeps other keys' counts lower.
+			for i, col := range cols {
+				s.rows[i][col] = max(s.rows[i][col], count)
+			}
+			s.total = satAdd(s.total, cost)
+		}
+	}
+	res := Result{
+		Allowed:      allowed,
+		Remaining:    max(0, limit-count),
+		ResetAtMs:    startMs + windowMs,
+		CurrentCount: count,
+		ErrorBound:   m.sketchOpts.errorBound(s.total),
+	}
+	if !allowed {
+		res.RetryAfterMs = startMs + windowMs - nowMs
+	}
+	return res
+}
+
+func (r *RedisBackend) SketchAllow(ctx context.Context, key string, limit int64, windowMs int64, cost int64) (Result, error) {
+	return r.run(ctx, r.sketchCall(key, limit, windowMs, cost))
+}
+
+// sketchCall counts in one hash per window, holding the counters the key's
+// columns name as "row:column" and the total cost. The hash is shared by
+// every key, so it is not hash tagged.
+func (r *RedisBackend) sketchCall(key string, limit int64, windowMs int64, cost int64) *scriptCall {
+	if limit <= 0 || windowMs <= 0 || cost <= 0 {
+		return nil
+	}
+	width, depth := r.sketchOpts.dims()
+	ttlMs := r.stateTTL.ttlMs(CountMinSketch, windowMs)
+	args := []interface{}{limit, windowMs, cost, r.nowMs(), r.sketchOpts.Epsilon, depth, ttlMs}
+	for i, col := range sketchColumns(key, width, depth) {
+		args = append(args, strconv.Itoa(i)+":"+strconv.Itoa(col))
+	}
+	return &scriptCall{
+		script: sketchScript,
+		keys:   []string{r.prefix + "cms:" + strconv.FormatInt(windowMs, 10)},
+		args:   args,
+	}
+}
+
+var sketchScript = checkScript(clockLua + clampLua + `
+local base_key = KEYS[1]
+local limit = tonumber(ARGV[1])
+local window_ms = tonumber(ARGV[2])
+local cost = tonumber(ARGV[3])
+local now_ms = resolve_now(tonumber(ARGV[4]))
+local epsilon = tonumber(ARGV[5])
+local depth = tonumber(ARGV[6])
+local ttl_ms = tonumber(ARGV[7])
+local fields = {}
+for i = 1, depth do fields[i] = ARGV[7 + i] end
+local dry = ARGV[8 + depth] == "1"
+
+local window_start = now_ms - (now_ms % window_ms)
+local key = base_key .. ":" .. window_start
+local values = redis.call("HMGET", key, "total", unpack(fields))
+local total = tonumber(values[1]) or 0
+local counters = {}
+local count = nil
+for i = 1, depth do
+	counters[i] = tonumber(values[i + 1]) or 0
+	if count == nil or counters[i] < count then count = counters[i] end
+end
+
+local allowed = 0
+if count + cost <= limit then
+	allowed = 1
+	count = count + cost
+	if not dry then
+		for i = 1, depth do
+			if counters[i] < count then redis.call("HSET", key, fields[i], count) end
+		end
+		total = redis.call("HINCRBY", key, "total", cost)
+		redis.call("PEXPIRE", key, ttl_ms)
+	end
+end
+
+local reset_at = window_start + window_ms
+local retry_after = 0
+if allowed == 0 then retry_after = reset_at - now_ms end
+
+return {allowed, clamp(math.max(0, limit - count)), clamp(reset_at), clamp(retry_after), count, 0, 0, clamp(math.ceil(epsilon * total))}
+`)
+
+func (r *Router) SketchAllow(ctx context.Context, key string, limit int64, windowMs int64, cost int64) (Result, error) {
+	sketch, ok := r.backendFor(key).(SketchBackend)
+	if !ok {
+		return Result{}, ErrUnsupportedAlgorithm
+	}
+	return sketch.SketchAllow(ctx, key, limit, windowMs, cost)
+}
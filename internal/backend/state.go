@@ -0,0 +1,523 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// KeyState is one key's limiter state in a form every backend can load,
+// for moving state between backends. Only the fields of its algorithm are
+// set.
+type KeyState struct {
+	Algorithm string `json:"algorithm"`
+	Key       string `json:"key"`
+	// The parameters the key was last checked with.
+	Limit        int64   `json:"limit,omitempty"`
+	WindowMs     int64   `json:"window_ms,omitempty"`
+	Capacity     int64   `json:"capacity,omitempty"`
+	RefillPerSec float64 `json:"refill_per_sec,omitempty"`
+	LeakPerSec   float64 `json:"leak_per_sec,omitempty"`
+	// Level is the tokens left in a token bucket, or the water in a leaky
+	// bucket, at LastMs.
+	Level  float64 `json:"level,omitempty"`
+	LastMs int64   `json:"last_ms,omitempty"`
+	// WindowStartMs and Count describe a fixed window or the current
+	// window of a sliding counter; PrevCount is the counter's previous
+	// window. WindowEndMs is set for zoned fixed windows.
+	WindowStartMs int64 `json:"window_start_ms,omitempty"`
+	WindowEndMs   int64 `json:"window_end_ms,omitempty"`
+	Count         int64 `json:"count,omitempty"`
+	PrevCount     int64 `json:"prev_count,omitempty"`
+	// Entries are a sliding log's, oldest first.
+	Entries []StateLogEntry `json:"entries,omitempty"`
+}
+
+// StateLogEntry is the cost a sliding log admitted at one millisecond.
+type StateLogEntry struct {
+	Ms int64 `json:"ms"`
+	N  int64 `json:"n"`
+}
+
+// StateStore is implemented by backends whose state can be exported and
+// imported. Keys at rest, such as full token buckets or windows that have
+// ended, are left out of exports: they behave as if absent.
+type StateStore interface {
+	// ExportState passes every live key's state to emit, stopping at the
+	// first error emit returns.
+	ExportState(ctx context.Context, emit func(KeyState) error) error
+	// ImportState replaces the state of each key in states and returns how
+	// many it took; states with invalid parameters or algorithms are
+	// skipped.
+	ImportState(ctx context.Context, states []KeyState) (int, error)
+}
+
+var ErrStateUnsupported = errors.New("backend cannot export state")
+
+// valid reports whether s carries the parameters its algorithm needs.
+func (s KeyState) valid() bool {
+	if s.Key == "" {
+		return false
+	}
+	switch s.Algorithm {
+	case TokenBucket:
+		return s.Capacity > 0 && s.RefillPerSec > 0
+	case LeakyBucket:
+		return s.Capacity > 0 && s.LeakPerSec > 0
+	case FixedWindow, SlidingWindowLog, SlidingWindowCounter:
+		return s.Limit > 0 && s.WindowMs > 0
+	default:
+		return false
+	}
+}
+
+func (m *MemoryBackend) ExportState(ctx context.Context, emit func(KeyState) error) error {
+	nowMs := m.clock.Now().UnixMilli()
+	m.mu.Lock()
+	var states []KeyState
+	for key, s := range m.tokenBuckets {
+		if s.expired(nowMs) {
+			continue
+		}
+		tokens := math.Min(float64(s.capacity), s.tokens+float64(nowMs-s.last.UnixMilli())/1000*s.rate)
+		if tokens < float64(s.capacity) {
+			states = append(states, KeyState{Algorithm: TokenBucket, Key: key, Capacity: s.capacity, RefillPerSec: s.rate, Level: s.tokens, LastMs: s.last.UnixMilli()})
+		}
+	}
+	for key, s := range m.leakyBuckets {
+		if s.expired(nowMs) {
+			continue
+		}
+		if s.water-float64(nowMs-s.last.UnixMilli())/1000*s.rate > 0 {
+			states = append(states, KeyState{Algorithm: LeakyBucket, Key: key, Capacity: s.capacity, LeakPerSec: s.rate, Level: s.water, LastMs: s.last.UnixMilli()})
+		}
+	}
+	for key, s := range m.fixedWindows {
+		if s.expired(nowMs) {
+			continue
+		}
+		if s.count > 0 && nowMs < s.endMs() {
+			states = append(states, KeyState{Algorithm: FixedWindow, Key: key, Limit: s.limit, WindowMs: s.windowMs, WindowStartMs: s.windowStartMs, WindowEndMs: s.windowEndMs, Count: s.count})
+		}
+	}
+	for key, s := range m.slidingCounters {
+		if s.expired(nowMs) {
+			continue
+		}
+		if state, ok := counterState(SlidingWindowCounter, key, s, nowMs); ok {
+			states = append(states, state)
+		}
+	}
+	for key, s := range m.logCounters {
+		if s.expired(nowMs) {
+			continue
+		}
+		// A log kept as a counter is exported as a log of its two windows.
+		if state, ok := counterState(SlidingWindowLog, key, s, nowMs); ok {
+			state.Entries = []StateLogEntry{{Ms: state.WindowStartMs - state.WindowMs, N: state.PrevCount}, {Ms: state.WindowStartMs, N: state.Count}}
+			state.WindowStartMs, state.Count, state.PrevCount = 0, 0, 0
+			states = append(states, state)
+		}
+	}
+	for key, s := range m.slidingLogs {
+		if s.expired(nowMs) {
+			continue
+		}
+		state := KeyState{Algorithm: SlidingWindowLog, Key: key, Limit: s.limit, WindowMs: s.windowMs}
+		for _, e := range s.entries {
+			if e.ms > nowMs-s.windowMs && e.n > 0 {
+				state.Entries = append(state.Entries, StateLogEntry{Ms: e.ms, N: e.n})
+			}
+		}
+		if len(state.Entries) > 0 {
+			states = append(states, state)
+		}
+	}
+	m.mu.Unlock()
+
+	sort.Slice(states, func(i, j int) bool { return states[i].Key < states[j].Key })
+	for _, state := range states {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := emit(state); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// counterState exports a sliding counter unless both its windows have
+// passed.
+func counterState(algorithm, key string, s *slidingCounterState, nowMs int64) (KeyState, bool) {
+	c := *s
+	c.roll(nowMs, c.windowMs)
+	if c.currentCount == 0 && c.prevCount == 0 {
+		return KeyState{}, false
+	}
+	return KeyState{Algorithm: algorithm, Key: key, Limit: c.limit, WindowMs: c.windowMs, WindowStartMs: c.windowStartMs, Count: c.currentCount, PrevCount: c.prevCount}, true
+}
+
+func (m *MemoryBackend) ImportState(ctx context.Context, states []KeyState) (int, error) {
+	nowMs := m.clock.Now().UnixMilli()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	imported := 0
+	for _, s := range states {
+		if !s.valid() {
+			continue
+		}
+		m.forget(s.Key)
+		// Imported state expires as if it had just been checked.
+		windowTTL := m.stateTTL.ttlMs(s.Algorithm, s.WindowMs)
+		counterTTL := m.stateTTL.ttlMs(s.Algorithm, satAdd(s.WindowMs, s.WindowMs))
+		switch s.Algorithm {
+		case TokenBucket:
+			state := &tokenBucketState{tokens: s.Level, last: time.UnixMilli(s.LastMs), capacity: s.Capacity, rate: s.RefillPerSec}
+			state.keep(nowMs, m.stateTTL.ttlMs(s.Algorithm, durationMs(float64(s.Capacity)/s.RefillPerSec*1000.0)))
+			m.tokenBuckets[s.Key] = state
+		case LeakyBucket:
+			state := &leakyBucketState{water: s.Level, last: time.UnixMilli(s.LastMs), capacity: s.Capacity, rate: s.LeakPerSec}
+			state.keep(nowMs, m.stateTTL.ttlMs(s.Algorithm, durationMs(float64(s.Capacity)/s.LeakPerSec*1000.0)))
+			m.leakyBuckets[s.Key] = state
+		case FixedWindow:
+			state := &fixedWindowState{count: s.Count, windowStartMs: s.WindowStartMs, windowEndMs: s.WindowEndMs, limit: s.Limit, windowMs: s.WindowMs}
+			state.keep(nowMs, m.stateTTL.ttlMs(s.Algorithm, max(s.WindowMs, state.endMs()-state.windowStartMs)))
+			m.fixedWindows[s.Key] = state
+		case SlidingWindowCounter:
+			state := &slidingCounterState{windowStartMs: s.WindowStartMs, currentCount: s.Count, prevCount: s.PrevCount, limit: s.Limit, windowMs: s.WindowMs}
+			state.keep(nowMs, counterTTL)
+			m.slidingCounters[s.Key] = state
+		case SlidingWindowLog:
+			log := &slidingLogState{limit: s.Limit, windowMs: s.WindowMs}
+			log.keep(nowMs, windowTTL)
+			for _, e := range s.Entries {
+				if e.N > 0 {
+					log.entries = append(log.entries, logEntry{ms: e.Ms, n: e.N})
+					log.count += e.N
+				}
+			}
+			sort.Slice(log.entries, func(i, j int) bool { return log.entries[i].ms < log.entries[j].ms })
+			if len(log.entries) > m.maxLogEntries {
+				counter := log.toCounter(nowMs, s.Limit, s.WindowMs)
+				counter.keep(nowMs, counterTTL)
+				m.logCounters[s.Key] = counter
+			} else {
+				m.slidingLogs[s.Key] = log
+			}
+		}
+		imported++
+	}
+	return imported, ctx.Err()
+}
+
+// ExportState exports every backend that can be exported.
+func (r *Router) ExportState(ctx context.Context, emit func(KeyState) error) error {
+	for _, b := range r.all() {
+		if store, ok := b.(StateStore); ok {
+			if err := store.ExportState(ctx, emit); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ImportState hands each state to the backend its key is routed to.
+func (r *Router) ImportState(ctx context.Context, states []KeyState) (int, error) {
+	groups := make(map[Backend][]KeyState)
+	for _, s := range states {
+		b := r.backendFor(s.Key)
+		groups[b] = append(groups[b], s)
+	}
+	imported := 0
+	var errs []error
+	for b, group := range groups {
+		store, ok := b.(StateStore)
+		if !ok {
+			continue
+		}
+		n, err := store.ImportState(ctx, group)
+		imported += n
+		errs = append(errs, err)
+	}
+	return imported, errors.Join(errs...)
+}
+
+// all lists the fallback and every routed backend.
+func (r *Router) all() []Backend {
+	backends := []Backend{r.fallback}
+	for _, rt := range r.routes {
+		backends = append(backends, rt.backend)
+	}
+	return backends
+}
+
+// stateNow is the time exports and imports are made at, from the same
+// clock the scripts use.
+func (r *RedisBackend) stateNow(ctx context.Context) (int64, error) {
+	if !r.serverTime {
+		return r.clock.Now().UnixMilli(), nil
+	}
+	t, err := r.client.Time(ctx).Result()
+	return t.UnixMilli(), err
+}
+
+// callerKey recovers the caller's key from a storage key of kind.
+func (r *RedisBackend) callerKey(kind, storageKey string) string {
+	key := strings.TrimPrefix(storageKey, r.prefix)
+	if kind != "" {
+		key = strings.TrimPrefix(key, kind+":")
+	}
+	if r.hashTags {
+		key = strings.TrimSuffix(strings.TrimPrefix(key, "{"), "}")
+	}
+	return key
+}
+
+// ExportState scans every node for bucket hashes and for the parameter
+// keys that window algorithms keep next to their counts. Fixed window
+// keys carry no kind, so without a key prefix any other key ending in
+// ":params" whose value looks like parameters is read as one.
+func (r *RedisBackend) ExportState(ctx context.Context, emit func(KeyState) error) error {
+	nowMs, err := r.stateNow(ctx)
+	if err != nil {
+		return err
+	}
+	var mu sync.Mutex
+	emitOne := func(s KeyState) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return emit(s)
+	}
+	prefix := escapeGlob(r.prefix)
+	return r.eachNode(ctx, func(ctx context.Context, node redis.UniversalClient) error {
+		for _, kind := range []string{"tb", "lb"} {
+			err := scanKeys(ctx, node, prefix+kind+":*", func(keys []string) error {
+				return r.exportBuckets(ctx, kind, keys, nowMs, emitOne)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return scanKeys(ctx, node, prefix+"*:params", func(keys []string) error {
+			for _, key := range keys {
+				state, ok, err := r.exportWindow(ctx, key, nowMs)
+				if err != nil {
+					return err
+				}
+				if ok {
+					if err := emitOne(state); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		})
+	})
+}
+
+func (r *RedisBackend) exportBuckets(ctx context.Context, kind string, keys []string, nowMs int64, emit func(KeyState) error) error {
+	level, rate := "tokens", "refill"
+	if kind == "lb" {
+		level, rate = "water", "leak"
+	}
+	pipe := r.client.Pipeline()
+	cmds := make([]*redis.SliceCmd, len(keys))
+	for i, key := range keys {
+		cmds[i] = pipe.HMGet(ctx, key, level, "last_ms", "capacity", rate)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		// Keys of other types under the kind fail on their own below.
+		if _, ok := err.(redis.Error); !ok {
+			return err
+		}
+	}
+	for i, cmd := range cmds {
+		values, err := cmd.Result()
+		if err != nil || len(values) != 4 {
+			continue
+		}
+		var fields [4]float64
+		ok := true
+		for j, v := range values {
+			s, isString := v.(string)
+			if !isString {
+				ok = false
+				break
+			}
+			if fields[j], err = strconv.ParseFloat(s, 64); err != nil {
+				ok = false
+				break
+			}
+		}
+		capacity := int64(fields[2])
+		if !ok || capacity <= 0 || fields[3] <= 0 {
+			continue
+		}
+		elapsed := float64(nowMs-int64(fields[1])) / 1000 * fields[3]
+		state := KeyState{Key: r.callerKey(kind, keys[i]), Capacity: capacity, Level: fields[0], LastMs: int64(fields[1])}
+		if kind == "tb" {
+			if fields[0]+elapsed >= float64(capacity) {
+				continue
+			}
+			state.Algorithm, state.RefillPerSec = TokenBucket, fields[3]
+		} else {
+			if fields[0]-elapsed <= 0 {
+				continue
+			}
+			state.Algorithm, state.LeakPerSec = LeakyBucket, fields[3]
+		}
+		if err := emit(state); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exportWindow reads the window-based key whose parameters are kept under
+// paramsKey, reporting false for keys at rest or that are not parameters.
+func (r *RedisBackend) exportWindow(ctx context.Context, paramsKey string, nowMs int64) (KeyState, bool, error) {
+	base := strings.TrimSuffix(paramsKey, ":params")
+	kind, algorithm := "", FixedWindow
+	switch rest := strings.TrimPrefix(base, r.prefix); {
+	case strings.HasPrefix(rest, "swl:"):
+		kind, algorithm = "swl", SlidingWindowLog
+	case strings.HasPrefix(rest, "swc:"):
+		kind, algorithm = "swc", SlidingWindowCounter
+	case strings.HasPrefix(rest, "tb:"), strings.HasPrefix(rest, "lb:"):
+		return KeyState{}, false, nil
+	}
+	params, err := r.client.Get(ctx, paramsKey).Result()
+	if errors.Is(err, redis.Nil) {
+		return KeyState{}, false, nil
+	}
+	if err != nil {
+		if _, ok := err.(redis.Error); ok {
+			return KeyState{}, false, nil
+		}
+		return KeyState{}, false, err
+	}
+	limitStr, windowStr, found := strings.Cut(params, ":")
+	limit, limitErr := strconv.ParseInt(limitStr, 10, 64)
+	windowMs, windowErr := strconv.ParseInt(windowStr, 10, 64)
+	if !found || limitErr != nil || windowErr != nil || limit <= 0 || windowMs <= 0 {
+		return KeyState{}, false, nil
+	}
+	state := KeyState{Algorithm: algorithm, Key: r.callerKey(kind, base), Limit: limit, WindowMs: windowMs}
+	start := nowMs - nowMs%windowMs
+
+	if algorithm == SlidingWindowLog {
+		entries, err := r.client.ZRangeByScoreWithScores(ctx, base, &redis.ZRangeBy{Min: "(" + strconv.FormatInt(nowMs-windowMs, 10), Max: "+inf"}).Result()
+		if err != nil {
+			return KeyState{}, false, err
+		}
+		for _, z := range entries {
+			ms := int64(z.Score)
+			if n := len(state.Entries); n > 0 && state.Entries[n-1].Ms == ms {
+				state.Entries[n-1].N++
+			} else {
+				state.Entries = append(state.Entries, StateLogEntry{Ms: ms, N: 1})
+			}
+		}
+		return state, len(state.Entries) > 0, nil
+	}
+
+	counts, err := r.client.MGet(ctx, base+":"+strconv.FormatInt(start, 10), base+":"+strconv.FormatInt(start-windowMs, 10)).Result()
+	if err != nil {
+		return KeyState{}, false, err
+	}
+	state.WindowStartMs = start
+	state.Count = toInt64(counts[0])
+	if algorithm == SlidingWindowCounter {
+		state.PrevCount = toInt64(counts[1])
+	}
+	return state, state.Count > 0 || state.PrevCount > 0, nil
+}
+
+// ImportState writes each state the way the scripts lay it out, replacing
+// whatever the key held under any algorithm.
+func (r *RedisBackend) ImportState(ctx context.Context, states []KeyState) (int, error) {
+	nowMs, err := r.stateNow(ctx)
+	if err != nil {
+		return 0, err
+	}
+	imported := 0
+	for _, s := range states {
+		if !s.valid() {
+			continue
+		}
+		if err := r.forget(ctx, s.Key); err != nil {
+			return imported, err
+		}
+		pipe := r.client.TxPipeline()
+		r.importOne(ctx, pipe, s, nowMs)
+		if _, err := pipe.Exec(ctx); err != nil {
+			return imported, err
+		}
+		imported++
+	}
+	return imported, nil
+}
+
+func (r *RedisBackend) importOne(ctx context.Context, pipe redis.Pipeliner, s KeyState, nowMs int64) {
+	params := strconv.FormatInt(s.Limit, 10) + ":" + strconv.FormatInt(s.WindowMs, 10)
+	windowMs := s.WindowMs
+	if s.Algorithm == SlidingWindowCounter {
+		windowMs *= 2
+	}
+	windowTTL := time.Duration(r.stateTTL.ttlMs(s.Algorithm, windowMs)) * time.Millisecond
+	switch s.Algorithm {
+	case TokenBucket, LeakyBucket:
+		kind, level, rateField, rate := "tb", "tokens", "refill", s.RefillPerSec
+		if s.Algorithm == LeakyBucket {
+			kind, level, rateField, rate = "lb", "water", "leak", s.LeakPerSec
+		}
+		key := r.redisKey(kind, s.Key)
+		// Parameters are stored as the scripts receive them, so an import
+		// does not read as a parameter change.
+		pipe.HSet(ctx, key, level, s.Level, "last_ms", s.LastMs,
+			"capacity", strconv.FormatInt(s.Capacity, 10), rateField, strconv.FormatFloat(rate, 'f', -1, 64))
+		ttlMs := r.stateTTL.ttlMs(s.Algorithm, int64(math.Ceil(float64(s.Capacity)/rate*1000)))
+		pipe.PExpire(ctx, key, time.Duration(ttlMs)*time.Millisecond)
+	case FixedWindow, SlidingWindowCounter:
+		kind := ""
+		if s.Algorithm == SlidingWindowCounter {
+			kind = "swc"
+		}
+		base := r.redisKey(kind, s.Key)
+		pipe.Set(ctx, base+":params", params, windowTTL)
+		if s.Count > 0 {
+			pipe.Set(ctx, base+":"+strconv.FormatInt(s.WindowStartMs, 10), s.Count, windowTTL)
+		}
+		if s.PrevCount > 0 {
+			pipe.Set(ctx, base+":"+strconv.FormatInt(s.WindowStartMs-s.WindowMs, 10), s.PrevCount, windowTTL)
+		}
+	case SlidingWindowLog:
+		base := r.redisKey("swl", s.Key)
+		var members []*redis.Z
+		var seq int64
+		for _, e := range s.Entries {
+			if e.Ms <= nowMs-s.WindowMs {
+				continue
+			}
+			for i := int64(0); i < e.N; i++ {
+				seq++
+				members = append(members, &redis.Z{Score: float64(e.Ms), Member: strconv.FormatInt(e.Ms, 10) + ":" + strconv.FormatInt(seq, 10)})
+			}
+		}
+		pipe.Set(ctx, base+":params", params, windowTTL)
+		if len(members) > 0 {
+			pipe.ZAdd(ctx, base, members...)
+			pipe.PExpire(ctx, base, windowTTL)
+			pipe.Set(ctx, base+":seq", seq, windowTTL)
+		}
+	}
+}
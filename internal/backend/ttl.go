@@ -0,0 +1,57 @@
+package backend
+
+import "time"
+
+// defaultTTLPadding is how long state outlives the time it is needed for
+// unless configured otherwise, covering clock skew between instances.
+const defaultTTLPadding = time.Second
+
+// StateTTL is how long an idle key's state is kept: the time the
+// algorithm needs it for, such as a window or a bucket's refill, plus
+// Padding, capped at Max.
+type StateTTL struct {
+	// Padding defaults to a second.
+	Padding time.Duration
+	// Max caps the TTL, trading a long window's accuracy for memory: state
+	// dropped early starts afresh. Zero leaves it uncapped.
+	Max time.Duration
+}
+
+// StateTTLs picks the StateTTL for each algorithm's state.
+type StateTTLs struct {
+	Default StateTTL
+	// Algorithms overrides Default per algorithm name; zero fields take
+	// Default's values.
+	Algorithms map[string]StateTTL
+}
+
+// For returns the StateTTL of algorithm's state.
+func (t StateTTLs) For(algorithm string) StateTTL {
+	ttl := t.Default
+	if o, ok := t.Algorithms[algorithm]; ok {
+		if o.Padding != 0 {
+			ttl.Padding = o.Padding
+		}
+		if o.Max != 0 {
+			ttl.Max = o.Max
+		}
+	}
+	if ttl.Padding <= 0 {
+		ttl.Padding = defaultTTLPadding
+	}
+	return ttl
+}
+
+// ms is the TTL of state needed for neededMs.
+func (t StateTTL) ms(neededMs int64) int64 {
+	ttl := satAdd(max(0, neededMs), t.Padding.Milliseconds())
+	if t.Max > 0 {
+		ttl = min(ttl, t.Max.Milliseconds())
+	}
+	return max(1, ttl)
+}
+
+// ttlMs is the TTL of algorithm's state needed for neededMs.
+func (t StateTTLs) ttlMs(algorithm string, neededMs int64) int64 {
+	return t.For(algorithm).ms(neededMs)
+}
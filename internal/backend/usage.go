@@ -0,0 +1,168 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Usage bucket sizes. Days start at midnight UTC.
+const (
+	HourMs = int64(time.Hour / time.Millisecond)
+	DayMs  = 24 * HourMs
+)
+
+// Usage counts a tenant's decisions. Cost is the quota consumed by the
+// allowed ones.
+type Usage struct {
+	Allowed int64 `json:"allowed"`
+	Denied  int64 `json:"denied"`
+	Cost    int64 `json:"cost"`
+}
+
+func (u *Usage) Add(o Usage) {
+	u.Allowed += o.Allowed
+	u.Denied += o.Denied
+	u.Cost += o.Cost
+}
+
+// UsageBucket is the usage within one hour or day.
+type UsageBucket struct {
+	StartMs int64 `json:"start_ms"`
+	Usage
+}
+
+// UsageStore keeps per-tenant usage by hour and by day next to the limiter
+// state, so every instance adds to and reads the same totals.
+type UsageStore interface {
+	// AddUsage adds u to the hour and the day containing atMs. Buckets are
+	// dropped once older than retention.
+	AddUsage(ctx context.Context, tenant string, atMs int64, u Usage, retention time.Duration) error
+	// Usage returns the buckets of size bucketMs (HourMs or DayMs) that
+	// start in [fromMs, toMs), oldest first, including empty ones.
+	Usage(ctx context.Context, tenant string, bucketMs, fromMs, toMs int64) ([]UsageBucket, error)
+}
+
+var ErrUsageUnsupported = errors.New("backend does not store usage")
+
+// usageStarts lists the bucket starts in [fromMs, toMs).
+func usageStarts(bucketMs, fromMs, toMs int64) []int64 {
+	var starts []int64
+	for start := fromMs - fromMs%bucketMs; start < toMs; start += bucketMs {
+		if start >= fromMs {
+			starts = append(starts, start)
+		}
+	}
+	return starts
+}
+
+type usageKey struct {
+	tenant   string
+	bucketMs int64
+	startMs  int64
+}
+
+func (m *MemoryBackend) AddUsage(_ context.Context, tenant string, atMs int64, u Usage, retention time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.usage == nil {
+		m.usage = make(map[usageKey]Usage)
+	}
+	for _, size := range []int64{HourMs, DayMs} {
+		key := usageKey{tenant: tenant, bucketMs: size, startMs: atMs - atMs%size}
+		total := m.usage[key]
+		total.Add(u)
+		m.usage[key] = total
+	}
+	cutoff := atMs - retention.Milliseconds()
+	for key := range m.usage {
+		if key.startMs+key.bucketMs < cutoff {
+			delete(m.usage, key)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryBackend) Usage(_ context.Context, tenant string, bucketMs, fromMs, toMs int64) ([]UsageBucket, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []UsageBucket
+	for _, start := range usageStarts(bucketMs, fromMs, toMs) {
+		out = append(out, UsageBucket{StartMs: start, Usage: m.usage[usageKey{tenant, bucketMs, start}]})
+	}
+	return out, nil
+}
+
+func (r *RedisBackend) usageKey(tenant string, bucketMs, startMs int64) string {
+	size := "h"
+	if bucketMs == DayMs {
+		size = "d"
+	}
+	return r.prefix + "usage:" + tenant + ":" + size + ":" + strconv.FormatInt(startMs, 10)
+}
+
+// AddUsage increments a hash per bucket in one pipeline.
+func (r *RedisBackend) AddUsage(ctx context.Context, tenant string, atMs int64, u Usage, retention time.Duration) error {
+	pipe := r.client.Pipeline()
+	for _, size := range []int64{HourMs, DayMs} {
+		start := atMs - atMs%size
+		key := r.usageKey(tenant, size, start)
+		pipe.HIncrBy(ctx, key, "allowed", u.Allowed)
+		pipe.HIncrBy(ctx, key, "denied", u.Denied)
+		pipe.HIncrBy(ctx, key, "cost", u.Cost)
+		pipe.PExpireAt(ctx, key, time.UnixMilli(start+size).Add(retention))
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (r *RedisBackend) Usage(ctx context.Context, tenant string, bucketMs, fromMs, toMs int64) ([]UsageBucket, error) {
+	starts := usageStarts(bucketMs, fromMs, toMs)
+	cmds := make([]*redis.SliceCmd, len(starts))
+	err := r.read(ctx, func(client redis.UniversalClient) error {
+		pipe := client.Pipeline()
+		for i, start := range starts {
+			cmds[i] = pipe.HMGet(ctx, r.usageKey(tenant, bucketMs, start), "allowed", "denied", "cost")
+		}
+		_, err := pipe.Exec(ctx)
+		return err
+	})
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	out := make([]UsageBucket, len(starts))
+	for i, cmd := range cmds {
+		out[i].StartMs = starts[i]
+		values := cmd.Val()
+		for j, dst := range []*int64{&out[i].Allowed, &out[i].Denied, &out[i].Cost} {
+			if j < len(values) {
+				if s, ok := values[j].(string); ok {
+					*dst, _ = strconv.ParseInt(s, 10, 64)
+				}
+			}
+		}
+	}
+	return out, nil
+}
+
+// AddUsage keeps usage on the fallback backend whichever backend holds the
+// tenant's keys: it is small, and one place to read it from keeps reports
+// simple.
+func (r *Router) AddUsage(ctx context.Context, tenant string, atMs int64, u Usage, retention time.Duration) error {
+	store, ok := r.fallback.(UsageStore)
+	if !ok {
+		return ErrUsageUnsupported
+	}
+	return store.AddUsage(ctx, tenant, atMs, u, retention)
+}
+
+func (r *Router) Usage(ctx context.Context, tenant string, bucketMs, fromMs, toMs int64) ([]UsageBucket, error) {
+	store, ok := r.fallback.(UsageStore)
+	if !ok {
+		return nil, ErrUsageUnsupported
+	}
+	return store.Usage(ctx, tenant, bucketMs, fromMs, toMs)
+}
@@ -0,0 +1,84 @@
+package backend
+
+import (
+	"container/heap"
+	"context"
+)
+
+// HottestKeys returns up to n of the keys in store's state with the most
+// of their allowance used, busiest first, as checks with the parameters
+// they were last made with and a cost of 1. Zoned fixed windows are left
+// out, since their state does not record the timezone.
+func HottestKeys(ctx context.Context, store StateStore, n int) ([]Request, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	var top usedHeap
+	err := store.ExportState(ctx, func(s KeyState) error {
+		if s.WindowEndMs != 0 {
+			return nil
+		}
+		heap.Push(&top, usedState{state: s, used: s.used()})
+		if top.Len() > n {
+			heap.Pop(&top)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Request, top.Len())
+	for i := len(out) - 1; i >= 0; i-- {
+		s := heap.Pop(&top).(usedState).state
+		out[i] = Request{
+			Algorithm:    s.Algorithm,
+			Key:          s.Key,
+			Limit:        s.Limit,
+			WindowMs:     s.WindowMs,
+			Capacity:     s.Capacity,
+			RefillPerSec: s.RefillPerSec,
+			LeakPerSec:   s.LeakPerSec,
+			Cost:         1,
+		}
+	}
+	return out, nil
+}
+
+// used is the fraction of the key's allowance its state has used, as of
+// the export.
+func (s KeyState) used() float64 {
+	switch s.Algorithm {
+	case TokenBucket:
+		return 1 - s.Level/float64(s.Capacity)
+	case LeakyBucket:
+		return s.Level / float64(s.Capacity)
+	case SlidingWindowLog:
+		var n int64
+		for _, e := range s.Entries {
+			n += e.N
+		}
+		return float64(n) / float64(s.Limit)
+	default:
+		return float64(s.Count) / float64(s.Limit)
+	}
+}
+
+type usedState struct {
+	state KeyState
+	used  float64
+}
+
+// usedHeap is a min-heap on used, so the least used key is dropped first.
+type usedHeap []usedState
+
+func (h usedHeap) Len() int            { return len(h) }
+func (h usedHeap) Less(i, j int) bool  { return h[i].used < h[j].used }
+func (h usedHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *usedHeap) Push(x interface{}) { *h = append(*h, x.(usedState)) }
+
+func (h *usedHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
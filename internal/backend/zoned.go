@@ -0,0 +1,108 @@
+package backend
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ZonedWindowBackend is implemented by backends that can align fixed
+// windows with a time zone's wall clock instead of the Unix epoch, so a
+// daily window resets at local midnight. Such windows follow the clock
+// across daylight saving changes: a day may last 23 or 25 hours.
+type ZonedWindowBackend interface {
+	ZonedFixedWindowAllow(ctx context.Context, key string, limit int64, windowMs int64, timezone string, cost int64) (Result, error)
+}
+
+var locations sync.Map
+
+// location loads an IANA time zone once.
+func location(name string) (*time.Location, error) {
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	locations.Store(name, loc)
+	return loc, nil
+}
+
+// ValidZone reports whether windows of windowMs can be aligned in
+// timezone: the zone must exist and the window must divide a day, so
+// every window starts at a fixed time of day.
+func ValidZone(timezone string, windowMs int64) bool {
+	if windowMs <= 0 || DayMs%windowMs != 0 {
+		return false
+	}
+	_, err := location(timezone)
+	return err == nil
+}
+
+// zonedWindow returns the bounds of the window of windowMs that holds
+// nowMs, counted in wall-clock time from midnight in loc. A bound that
+// falls in a daylight saving gap moves past it.
+func zonedWindow(nowMs, windowMs int64, loc *time.Location) (startMs, endMs int64) {
+	now := time.UnixMilli(nowMs).In(loc)
+	year, month, day := now.Date()
+	clockMs := int64(now.Hour())*HourMs + int64(now.Minute())*60000 + int64(now.Second())*1000 + int64(now.Nanosecond())/1e6
+	index := clockMs / windowMs
+	// time.Date resolves a wall-clock time in whichever offset is in
+	// force at it.
+	wall := func(ms int64) int64 {
+		h, m, s := ms/HourMs, ms%HourMs/60000, ms%60000/1000
+		return time.Date(year, month, day, int(h), int(m), int(s), int(ms%1000)*1e6, loc).UnixMilli()
+	}
+	startMs, endMs = wall(index*windowMs), wall((index+1)*windowMs)
+	// In a repeated hour the start may resolve to the later of its two
+	// occurrences; the window then starts in the offset now is in.
+	if startMs > nowMs {
+		startMs = nowMs - (clockMs - index*windowMs)
+	}
+	return startMs, max(endMs, nowMs+1)
+}
+
+func (m *MemoryBackend) ZonedFixedWindowAllow(_ context.Context, key string, limit int64, windowMs int64, timezone string, cost int64) (Result, error) {
+	loc, err := location(timezone)
+	if err != nil || limit <= 0 || windowMs <= 0 || DayMs%windowMs != 0 || cost <= 0 {
+		return Result{}, ErrInvalidParams
+	}
+	return m.fixedWindow(key, limit, windowMs, cost, func(nowMs int64) (int64, int64) {
+		return zonedWindow(nowMs, windowMs, loc)
+	}), nil
+}
+
+func (r *RedisBackend) ZonedFixedWindowAllow(ctx context.Context, key string, limit int64, windowMs int64, timezone string, cost int64) (Result, error) {
+	call := r.zonedFixedWindowCall(key, limit, windowMs, timezone, cost)
+	if call == nil {
+		return Result{}, ErrInvalidParams
+	}
+	return r.run(ctx, call)
+}
+
+// zonedFixedWindowCall places the window by this instance's clock, even
+// with server time on: Redis cannot resolve time zones.
+func (r *RedisBackend) zonedFixedWindowCall(key string, limit int64, windowMs int64, timezone string, cost int64) *scriptCall {
+	loc, err := location(timezone)
+	if err != nil || DayMs%windowMs != 0 {
+		return nil
+	}
+	call := r.fixedWindowCall(key, limit, windowMs, cost)
+	if call == nil {
+		return nil
+	}
+	nowMs := r.clock.Now().UnixMilli()
+	startMs, endMs := zonedWindow(nowMs, windowMs, loc)
+	call.args[4], call.args[5] = startMs, endMs
+	call.args[6] = r.stateTTL.ttlMs(FixedWindow, max(windowMs, endMs-startMs))
+	return call
+}
+
+func (r *Router) ZonedFixedWindowAllow(ctx context.Context, key string, limit int64, windowMs int64, timezone string, cost int64) (Result, error) {
+	zoned, ok := r.backendFor(key).(ZonedWindowBackend)
+	if !ok {
+		return Result{}, ErrUnsupportedAlgorithm
+	}
+	return zoned.ZonedFixedWindowAllow(ctx, key, limit, windowMs, timezone, cost)
+}
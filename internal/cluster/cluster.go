@@ -0,0 +1,412 @@
+// Package cluster spreads keys over instances by consistent hashing. Each
+// key is owned by one instance, which keeps its state in its own backend;
+// the others forward checks on it there, so limits are exact without a
+// shared store.
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"rate-limiter-service/internal/backend"
+)
+
+// Member is one instance of the cluster.
+type Member struct {
+	Name string
+	URL  string
+}
+
+type Options struct {
+	// Self names this instance among Members.
+	Self    string
+	Members []Member
+	// VirtualNodes is how many points each member gets on the ring; more
+	// spread keys more evenly. Defaults to 128.
+	VirtualNodes int
+	// APIKey is sent to the other members as "Authorization: ApiKey <key>".
+	APIKey string
+	Client *http.Client
+}
+
+// Backend evaluates keys this instance owns on its local backend and
+// forwards the rest to their owners. A failed forward is a backend error,
+// so the check's fail mode applies.
+type Backend struct {
+	self    string
+	local   backend.Backend
+	ring    *ring
+	members map[string]*member
+}
+
+// New wraps local, which the Backend owns and closes.
+func New(local backend.Backend, opts Options) (*Backend, error) {
+	if opts.VirtualNodes <= 0 {
+		opts.VirtualNodes = 128
+	}
+	client := opts.Client
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Second}
+	}
+	b := &Backend{self: opts.Self, local: local, members: make(map[string]*member)}
+	names := make([]string, 0, len(opts.Members))
+	for _, m := range opts.Members {
+		if _, dup := b.members[m.Name]; dup {
+			return nil, fmt.Errorf("cluster: member %q listed twice", m.Name)
+		}
+		names = append(names, m.Name)
+		b.members[m.Name] = &member{name: m.Name, url: m.URL, apiKey: opts.APIKey, client: client}
+	}
+	if _, ok := b.members[opts.Self]; !ok {
+		return nil, fmt.Errorf("cluster: self %q is not a member", opts.Self)
+	}
+	b.ring = newRing(names, opts.VirtualNodes)
+	return b, nil
+}
+
+// Owner returns the member that owns key and whether it is this instance.
+func (b *Backend) Owner(key string) (Member, bool) {
+	name := b.ring.owner(key)
+	m := b.members[name]
+	return Member{Name: m.name, URL: m.url}, name == b.self
+}
+
+// usageKey places a tenant's usage with one owner, so every instance adds
+// to and reads the same totals.
+func usageKey(tenant string) string {
+	return "usage/" + tenant
+}
+
+func (b *Backend) evaluate(ctx context.Context, req backend.Request) (backend.Result, error) {
+	// A group's keys have different owners, none of which holds the
+	// group's budget.
+	if req.GroupKey != "" {
+		return backend.Result{}, backend.ErrGroupUnsupported
+	}
+	owner := b.ring.owner(req.Key)
+	if owner == b.self {
+		return backend.Evaluate(ctx, b.local, req)
+	}
+	reply, err := b.members[owner].call(ctx, Call{Op: OpEvaluate, Requests: []backend.Request{req}})
+	if err != nil {
+		return backend.Result{}, err
+	}
+	if len(reply.Results) != 1 || len(reply.Errors) != 1 {
+		return backend.Result{}, fmt.Errorf("cluster member %s: malformed reply", owner)
+	}
+	return reply.Results[0], decodeError(reply.Errors[0])
+}
+
+func (b *Backend) TokenBucketAllow(ctx context.Context, key string, capacity int64, refillPerSec float64, cost int64) (backend.Result, error) {
+	return b.evaluate(ctx, backend.Request{Algorithm: backend.TokenBucket, Key: key, Capacity: capacity, RefillPerSec: refillPerSec, Cost: cost})
+}
+
+func (b *Backend) TokenBucketOverdraftAllow(ctx context.Context, key string, capacity int64, refillPerSec float64, maxDebt, cost int64) (backend.Result, error) {
+	return b.evaluate(ctx, backend.Request{Algorithm: backend.TokenBucket, Key: key, Capacity: capacity, RefillPerSec: refillPerSec, MaxDebt: maxDebt, Cost: cost})
+}
+
+func (b *Backend) LeakyBucketAllow(ctx context.Context, key string, capacity int64, leakPerSec float64, cost int64) (backend.Result, error) {
+	return b.evaluate(ctx, backend.Request{Algorithm: backend.LeakyBucket, Key: key, Capacity: capacity, LeakPerSec: leakPerSec, Cost: cost})
+}
+
+func (b *Backend) FixedWindowAllow(ctx context.Context, key string, limit int64, windowMs int64, cost int64) (backend.Result, error) {
+	return b.evaluate(ctx, backend.Request{Algorithm: backend.FixedWindow, Key: key, Limit: limit, WindowMs: windowMs, Cost: cost})
+}
+
+func (b *Backend) ZonedFixedWindowAllow(ctx context.Context, key string, limit int64, windowMs int64, timezone string, cost int64) (backend.Result, error) {
+	return b.evaluate(ctx, backend.Request{Algorithm: backend.FixedWindow, Key: key, Limit: limit, WindowMs: windowMs, Timezone: timezone, Cost: cost})
+}
+
+func (b *Backend) SlidingWindowLogAllow(ctx context.Context, key string, limit int64, windowMs int64, cost int64) (backend.Result, error) {
+	return b.evaluate(ctx, backend.Request{Algorithm: backend.SlidingWindowLog, Key: key, Limit: limit, WindowMs: windowMs, Cost: cost})
+}
+
+func (b *Backend) SlidingWindowCounterAllow(ctx context.Context, key string, limit int64, windowMs int64, cost int64) (backend.Result, error) {
+	return b.evaluate(ctx, backend.Request{Algorithm: backend.SlidingWindowCounter, Key: key, Limit: limit, WindowMs: windowMs, Cost: cost})
+}
+
+func (b *Backend) SketchAllow(ctx context.Context, key string, limit int64, windowMs int64, cost int64) (backend.Result, error) {
+	return b.evaluate(ctx, backend.Request{Algorithm: backend.CountMinSketch, Key: key, Limit: limit, WindowMs: windowMs, Cost: cost})
+}
+
+func (b *Backend) FairShareAllow(ctx context.Context, key, client string, weight, limit, windowMs, cost int64) (backend.Result, error) {
+	return b.evaluate(ctx, backend.Request{Algorithm: backend.WeightedFairShare, Key: key, Client: client, Weight: weight, Limit: limit, WindowMs: windowMs, Cost: cost})
+}
+
+// DimensionsAllow decides req on its key's owner, which holds the key's
+// dimensions too.
+func (b *Backend) DimensionsAllow(ctx context.Context, req backend.Request) (backend.Result, error) {
+	return b.evaluate(ctx, req)
+}
+
+func (b *Backend) Peek(ctx context.Context, req backend.Request) (backend.Result, error) {
+	if req.GroupKey != "" {
+		return backend.Result{}, backend.ErrGroupUnsupported
+	}
+	owner := b.ring.owner(req.Key)
+	if owner == b.self {
+		peeker, ok := b.local.(backend.Peeker)
+		if !ok {
+			return backend.Result{}, backend.ErrPeekUnsupported
+		}
+		return peeker.Peek(ctx, req)
+	}
+	reply, err := b.members[owner].call(ctx, Call{Op: OpPeek, Requests: []backend.Request{req}})
+	if err != nil {
+		return backend.Result{}, err
+	}
+	if len(reply.Results) != 1 {
+		return backend.Result{}, fmt.Errorf("cluster member %s: malformed reply", owner)
+	}
+	return reply.Results[0], nil
+}
+
+// AllowBatch splits reqs by owner and evaluates the groups concurrently,
+// one call per owner.
+func (b *Backend) AllowBatch(ctx context.Context, reqs []backend.Request) ([]backend.Result, []error) {
+	groups := make(map[string][]int)
+	results := make([]backend.Result, len(reqs))
+	errs := make([]error, len(reqs))
+	for i, req := range reqs {
+		if req.GroupKey != "" {
+			errs[i] = backend.ErrGroupUnsupported
+			continue
+		}
+		owner := b.ring.owner(req.Key)
+		groups[owner] = append(groups[owner], i)
+	}
+	var wg sync.WaitGroup
+	for owner, indexes := range groups {
+		wg.Add(1)
+		go func(owner string, indexes []int) {
+			defer wg.Done()
+			group := make([]backend.Request, len(indexes))
+			for j, i := range indexes {
+				group[j] = reqs[i]
+			}
+			if owner == b.self {
+				groupResults, groupErrs := evaluateAll(ctx, b.local, group)
+				for j, i := range indexes {
+					results[i], errs[i] = groupResults[j], groupErrs[j]
+				}
+				return
+			}
+			reply, err := b.members[owner].call(ctx, Call{Op: OpEvaluate, Requests: group})
+			if err == nil && (len(reply.Results) != len(group) || len(reply.Errors) != len(group)) {
+				err = fmt.Errorf("cluster member %s: malformed reply", owner)
+			}
+			for j, i := range indexes {
+				if err != nil {
+					errs[i] = err
+					continue
+				}
+				results[i], errs[i] = reply.Results[j], decodeError(reply.Errors[j])
+			}
+		}(owner, indexes)
+	}
+	wg.Wait()
+	return results, errs
+}
+
+// TrackKey keeps a set on the owner of its name, so its cap holds across
+// the cluster. Forgetting an evicted key only drops its state when the set's
+// owner also owns the key; elsewhere the state lingers until it expires.
+func (b *Backend) TrackKey(ctx context.Context, set, key string, limit int64, idle time.Duration, evict bool) (bool, error) {
+	owner := b.ring.owner(set)
+	if owner == b.self {
+		tracker, ok := b.local.(backend.KeyTracker)
+		if !ok {
+			return true, nil
+		}
+		return tracker.TrackKey(ctx, set, key, limit, idle, evict)
+	}
+	reply, err := b.members[owner].call(ctx, Call{Op: OpTrackKey, Set: set, Key: key, Limit: limit, IdleMs: idle.Milliseconds(), Evict: evict})
+	if err != nil {
+		return false, err
+	}
+	return reply.Admitted, nil
+}
+
+func (b *Backend) AddUsage(ctx context.Context, tenant string, atMs int64, u backend.Usage, retention time.Duration) error {
+	owner := b.ring.owner(usageKey(tenant))
+	if owner == b.self {
+		store, ok := b.local.(backend.UsageStore)
+		if !ok {
+			return backend.ErrUsageUnsupported
+		}
+		return store.AddUsage(ctx, tenant, atMs, u, retention)
+	}
+	_, err := b.members[owner].call(ctx, Call{Op: OpAddUsage, Tenant: tenant, AtMs: atMs, Usage: u, RetentionMs: retention.Milliseconds()})
+	return err
+}
+
+func (b *Backend) Usage(ctx context.Context, tenant string, bucketMs, fromMs, toMs int64) ([]backend.UsageBucket, error) {
+	owner := b.ring.owner(usageKey(tenant))
+	if owner == b.self {
+		store, ok := b.local.(backend.UsageStore)
+		if !ok {
+			return nil, backend.ErrUsageUnsupported
+		}
+		return store.Usage(ctx, tenant, bucketMs, fromMs, toMs)
+	}
+	reply, err := b.members[owner].call(ctx, Call{Op: OpUsage, Tenant: tenant, BucketMs: bucketMs, FromMs: fromMs, ToMs: toMs})
+	if err != nil {
+		return nil, err
+	}
+	return reply.Buckets, nil
+}
+
+// AddKeyUsage sends each key's usage to the key's owner.
+func (b *Backend) AddKeyUsage(ctx context.Context, atMs int64, usage map[string]backend.KeyUsage, retention time.Duration) error {
+	groups := make(map[string]map[string]backend.KeyUsage)
+	for key, u := range usage {
+		owner := b.ring.owner(key)
+		if groups[owner] == nil {
+			groups[owner] = make(map[string]backend.KeyUsage)
+		}
+		groups[owner][key] = u
+	}
+	var errs []error
+	for owner, group := range groups {
+		if owner == b.self {
+			store, ok := b.local.(backend.KeyHistoryStore)
+			if !ok {
+				errs = append(errs, backend.ErrHistoryUnsupported)
+				continue
+			}
+			errs = append(errs, store.AddKeyUsage(ctx, atMs, group, retention))
+			continue
+		}
+		_, err := b.members[owner].call(ctx, Call{Op: OpAddKeyUsage, AtMs: atMs, KeyUsage: group, RetentionMs: retention.Milliseconds()})
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+func (b *Backend) KeyUsage(ctx context.Context, key string, bucketMs, fromMs, toMs int64) ([]backend.KeyUsageBucket, error) {
+	owner := b.ring.owner(key)
+	if owner == b.self {
+		store, ok := b.local.(backend.KeyHistoryStore)
+		if !ok {
+			return nil, backend.ErrHistoryUnsupported
+		}
+		return store.KeyUsage(ctx, key, bucketMs, fromMs, toMs)
+	}
+	reply, err := b.members[owner].call(ctx, Call{Op: OpKeyUsage, Key: key, BucketMs: bucketMs, FromMs: fromMs, ToMs: toMs})
+	if err != nil {
+		return nil, err
+	}
+	return reply.History, nil
+}
+
+func (b *Backend) Inspect(ctx context.Context, key string) ([]backend.StoredRecord, error) {
+	owner := b.ring.owner(key)
+	if owner == b.self {
+		inspector, ok := b.local.(backend.Inspector)
+		if !ok {
+			return nil, backend.ErrInspectUnsupported
+		}
+		return inspector.Inspect(ctx, key)
+	}
+	reply, err := b.members[owner].call(ctx, Call{Op: OpInspect, Key: key})
+	if err != nil {
+		return nil, err
+	}
+	return reply.Records, nil
+}
+
+func (b *Backend) Adjust(ctx context.Context, req backend.Request, delta int64) (backend.Result, error) {
+	owner := b.ring.owner(req.Key)
+	if owner == b.self {
+		adjuster, ok := b.local.(backend.Adjuster)
+		if !ok {
+			return backend.Result{}, backend.ErrAdjustUnsupported
+		}
+		return adjuster.Adjust(ctx, req, delta)
+	}
+	reply, err := b.members[owner].call(ctx, Call{Op: OpAdjust, Requests: []backend.Request{req}, Delta: delta})
+	if err != nil {
+		return backend.Result{}, err
+	}
+	if len(reply.Results) != 1 {
+		return backend.Result{}, fmt.Errorf("cluster member %s: malformed reply", owner)
+	}
+	return reply.Results[0], nil
+}
+
+func (b *Backend) ResetKey(ctx context.Context, key string) error {
+	owner := b.ring.owner(key)
+	if owner == b.self {
+		resetter, ok := b.local.(backend.KeyResetter)
+		if !ok {
+			return backend.ErrResetUnsupported
+		}
+		return resetter.ResetKey(ctx, key)
+	}
+	_, err := b.members[owner].call(ctx, Call{Op: OpResetKey, Key: key})
+	return err
+}
+
+// ExportState exports only this instance's backend; an export of the whole
+// cluster asks every member.
+func (b *Backend) ExportState(ctx context.Context, emit func(backend.KeyState) error) error {
+	store, ok := b.local.(backend.StateStore)
+	if !ok {
+		return backend.ErrStateUnsupported
+	}
+	return store.ExportState(ctx, emit)
+}
+
+// CountKeys counts only this instance's backend.
+func (b *Backend) CountKeys(ctx context.Context) ([]backend.KeyCount, error) {
+	counter, ok := b.local.(backend.KeyCounter)
+	if !ok {
+		return nil, backend.ErrKeyCountUnsupported
+	}
+	return counter.CountKeys(ctx)
+}
+
+// Budget reports only this instance's backend.
+func (b *Backend) Budget() backend.BudgetStats {
+	budgeted, ok := b.local.(backend.Budgeted)
+	if !ok {
+		return backend.BudgetStats{}
+	}
+	return budgeted.Budget()
+}
+
+// ImportState sends each state to the owner of its key.
+func (b *Backend) ImportState(ctx context.Context, states []backend.KeyState) (int, error) {
+	groups := make(map[string][]backend.KeyState)
+	for _, s := range states {
+		owner := b.ring.owner(s.Key)
+		groups[owner] = append(groups[owner], s)
+	}
+	imported := 0
+	var errs []error
+	for owner, group := range groups {
+		if owner == b.self {
+			store, ok := b.local.(backend.StateStore)
+			if !ok {
+				errs = append(errs, backend.ErrStateUnsupported)
+				continue
+			}
+			n, err := store.ImportState(ctx, group)
+			imported += n
+			errs = append(errs, err)
+			continue
+		}
+		reply, err := b.members[owner].call(ctx, Call{Op: OpImport, States: group})
+		imported += reply.Imported
+		errs = append(errs, err)
+	}
+	return imported, errors.Join(errs...)
+}
+
+func (b *Backend) Close() error {
+	return b.local.Close()
+}
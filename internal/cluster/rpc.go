@@ -0,0 +1,281 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"rate-limiter-service/internal/backend"
+)
+
+// maxReplyBytes bounds a member's reply; a full batch of results or a
+// year of usage buckets is far below it.
+const maxReplyBytes = 4 << 20
+
+// Operations a member performs for another on its own backend.
+const (
+	OpEvaluate    = "evaluate"
+	OpPeek        = "peek"
+	OpTrackKey    = "track_key"
+	OpAddUsage    = "add_usage"
+	OpUsage       = "usage"
+	OpImport      = "import_state"
+	OpInspect     = "inspect"
+	OpAdjust      = "adjust"
+	OpAddKeyUsage = "add_key_usage"
+	OpKeyUsage    = "key_usage"
+	OpResetKey    = "reset_key"
+)
+
+// Call asks the owner of some keys to act on them. Only the fields of Op
+// are set.
+type Call struct {
+	Op       string            `json:"op"`
+	Requests []backend.Request `json:"requests,omitempty"`
+
+	Set    string `json:"set,omitempty"`
+	Key    string `json:"key,omitempty"`
+	Limit  int64  `json:"limit,omitempty"`
+	IdleMs int64  `json:"idle_ms,omitempty"`
+	Evict  bool   `json:"evict,omitempty"`
+
+	Tenant      string        `json:"tenant,omitempty"`
+	AtMs        int64         `json:"at_ms,omitempty"`
+	Usage       backend.Usage `json:"usage"`
+	RetentionMs int64         `json:"retention_ms,omitempty"`
+	BucketMs    int64         `json:"bucket_ms,omitempty"`
+	FromMs      int64         `json:"from_ms,omitempty"`
+	ToMs        int64         `json:"to_ms,omitempty"`
+
+	States []backend.KeyState `json:"states,omitempty"`
+	Delta  int64              `json:"delta,omitempty"`
+
+	KeyUsage map[string]backend.KeyUsage `json:"key_usage,omitempty"`
+}
+
+// Reply carries a Call's outcome. Results and Errors are index-aligned
+// with the call's Requests; an empty error means success.
+type Reply struct {
+	Results  []backend.Result         `json:"results,omitempty"`
+	Errors   []string                 `json:"errors,omitempty"`
+	Admitted bool                     `json:"admitted,omitempty"`
+	Buckets  []backend.UsageBucket    `json:"buckets,omitempty"`
+	Imported int                      `json:"imported,omitempty"`
+	Records  []backend.StoredRecord   `json:"records,omitempty"`
+	History  []backend.KeyUsageBucket `json:"history,omitempty"`
+	Error    string                   `json:"error,omitempty"`
+}
+
+// Error codes for the backend errors callers tell apart.
+const (
+	errInvalidParams          = "invalid_parameters"
+	errUnsupportedAlgorithm   = "unsupported_algorithm"
+	errUsageUnsupported       = "usage_unsupported"
+	errPeekUnsupported        = "peek_unsupported"
+	errStateUnsupported       = "state_unsupported"
+	errInspectUnsupported     = "inspect_unsupported"
+	errAdjustUnsupported      = "adjust_unsupported"
+	errHistoryUnsupported     = "history_unsupported"
+	errGroupUnsupported       = "group_unsupported"
+	errResetUnsupported       = "reset_unsupported"
+	errInvalidCall            = "invalid_call"
+	errorPrefixBackendFailure = "backend_error: "
+)
+
+func encodeError(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, backend.ErrInvalidParams):
+		return errInvalidParams
+	case errors.Is(err, backend.ErrUnsupportedAlgorithm):
+		return errUnsupportedAlgorithm
+	case errors.Is(err, backend.ErrUsageUnsupported):
+		return errUsageUnsupported
+	case errors.Is(err, backend.ErrPeekUnsupported):
+		return errPeekUnsupported
+	case errors.Is(err, backend.ErrStateUnsupported):
+		return errStateUnsupported
+	case errors.Is(err, backend.ErrInspectUnsupported):
+		return errInspectUnsupported
+	case errors.Is(err, backend.ErrAdjustUnsupported):
+		return errAdjustUnsupported
+	case errors.Is(err, backend.ErrHistoryUnsupported):
+		return errHistoryUnsupported
+	case errors.Is(err, backend.ErrGroupUnsupported):
+		return errGroupUnsupported
+	case errors.Is(err, backend.ErrResetUnsupported):
+		return errResetUnsupported
+	default:
+		return errorPrefixBackendFailure + err.Error()
+	}
+}
+
+func decodeError(code string) error {
+	switch code {
+	case "":
+		return nil
+	case errInvalidParams:
+		return backend.ErrInvalidParams
+	case errUnsupportedAlgorithm:
+		return backend.ErrUnsupportedAlgorithm
+	case errUsageUnsupported:
+		return backend.ErrUsageUnsupported
+	case errPeekUnsupported:
+		return backend.ErrPeekUnsupported
+	case errStateUnsupported:
+		return backend.ErrStateUnsupported
+	case errInspectUnsupported:
+		return backend.ErrInspectUnsupported
+	case errAdjustUnsupported:
+		return backend.ErrAdjustUnsupported
+	case errHistoryUnsupported:
+		return backend.ErrHistoryUnsupported
+	case errGroupUnsupported:
+		return backend.ErrGroupUnsupported
+	case errResetUnsupported:
+		return backend.ErrResetUnsupported
+	default:
+		return fmt.Errorf("cluster member: %s", code)
+	}
+}
+
+// Serve performs call on the local backend; it never forwards, so members
+// that disagree about the ring cannot bounce a call between them.
+func (b *Backend) Serve(ctx context.Context, call Call) Reply {
+	switch call.Op {
+	case OpEvaluate:
+		results, errs := evaluateAll(ctx, b.local, call.Requests)
+		reply := Reply{Results: results, Errors: make([]string, len(errs))}
+		for i, err := range errs {
+			reply.Errors[i] = encodeError(err)
+		}
+		return reply
+	case OpPeek:
+		peeker, ok := b.local.(backend.Peeker)
+		if !ok || len(call.Requests) != 1 {
+			return Reply{Error: encodeError(backend.ErrPeekUnsupported)}
+		}
+		res, err := peeker.Peek(ctx, call.Requests[0])
+		return Reply{Results: []backend.Result{res}, Error: encodeError(err)}
+	case OpTrackKey:
+		tracker, ok := b.local.(backend.KeyTracker)
+		if !ok {
+			return Reply{Admitted: true}
+		}
+		admitted, err := tracker.TrackKey(ctx, call.Set, call.Key, call.Limit, time.Duration(call.IdleMs)*time.Millisecond, call.Evict)
+		return Reply{Admitted: admitted, Error: encodeError(err)}
+	case OpAddUsage:
+		store, ok := b.local.(backend.UsageStore)
+		if !ok {
+			return Reply{Error: errUsageUnsupported}
+		}
+		err := store.AddUsage(ctx, call.Tenant, call.AtMs, call.Usage, time.Duration(call.RetentionMs)*time.Millisecond)
+		return Reply{Error: encodeError(err)}
+	case OpUsage:
+		store, ok := b.local.(backend.UsageStore)
+		if !ok {
+			return Reply{Error: errUsageUnsupported}
+		}
+		buckets, err := store.Usage(ctx, call.Tenant, call.BucketMs, call.FromMs, call.ToMs)
+		return Reply{Buckets: buckets, Error: encodeError(err)}
+	case OpImport:
+		store, ok := b.local.(backend.StateStore)
+		if !ok {
+			return Reply{Error: errStateUnsupported}
+		}
+		imported, err := store.ImportState(ctx, call.States)
+		return Reply{Imported: imported, Error: encodeError(err)}
+	case OpInspect:
+		inspector, ok := b.local.(backend.Inspector)
+		if !ok {
+			return Reply{Error: errInspectUnsupported}
+		}
+		records, err := inspector.Inspect(ctx, call.Key)
+		return Reply{Records: records, Error: encodeError(err)}
+	case OpAdjust:
+		adjuster, ok := b.local.(backend.Adjuster)
+		if !ok || len(call.Requests) != 1 {
+			return Reply{Error: errAdjustUnsupported}
+		}
+		res, err := adjuster.Adjust(ctx, call.Requests[0], call.Delta)
+		return Reply{Results: []backend.Result{res}, Error: encodeError(err)}
+	case OpAddKeyUsage:
+		store, ok := b.local.(backend.KeyHistoryStore)
+		if !ok {
+			return Reply{Error: errHistoryUnsupported}
+		}
+		err := store.AddKeyUsage(ctx, call.AtMs, call.KeyUsage, time.Duration(call.RetentionMs)*time.Millisecond)
+		return Reply{Error: encodeError(err)}
+	case OpKeyUsage:
+		store, ok := b.local.(backend.KeyHistoryStore)
+		if !ok {
+			return Reply{Error: errHistoryUnsupported}
+		}
+		history, err := store.KeyUsage(ctx, call.Key, call.BucketMs, call.FromMs, call.ToMs)
+		return Reply{History: history, Error: encodeError(err)}
+	case OpResetKey:
+		resetter, ok := b.local.(backend.KeyResetter)
+		if !ok {
+			return Reply{Error: errResetUnsupported}
+		}
+		return Reply{Error: encodeError(resetter.ResetKey(ctx, call.Key))}
+	default:
+		return Reply{Error: errInvalidCall}
+	}
+}
+
+// evaluateAll runs reqs on b, in one round trip where b can batch.
+func evaluateAll(ctx context.Context, b backend.Backend, reqs []backend.Request) ([]backend.Result, []error) {
+	if batcher, ok := b.(backend.BatchBackend); ok {
+		return batcher.AllowBatch(ctx, reqs)
+	}
+	results := make([]backend.Result, len(reqs))
+	errs := make([]error, len(reqs))
+	for i, req := range reqs {
+		results[i], errs[i] = backend.Evaluate(ctx, b, req)
+	}
+	return results, errs
+}
+
+// member is another instance, reached over HTTP.
+type member struct {
+	name   string
+	url    string
+	apiKey string
+	client *http.Client
+}
+
+func (m *member) call(ctx context.Context, call Call) (Reply, error) {
+	var reply Reply
+	body, err := json.Marshal(call)
+	if err != nil {
+		return reply, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.url+"/v1/cluster/rpc", bytes.NewReader(body))
+	if err != nil {
+		return reply, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if m.apiKey != "" {
+		req.Header.Set("Authorization", "ApiKey "+m.apiKey)
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return reply, fmt.Errorf("cluster member %s: %w", m.name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxReplyBytes))
+		return reply, fmt.Errorf("cluster member %s: status %d", m.name, resp.StatusCode)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxReplyBytes)).Decode(&reply); err != nil {
+		return reply, fmt.Errorf("cluster member %s: %w", m.name, err)
+	}
+	return reply, decodeError(reply.Error)
+}
@@ -0,0 +1,185 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// AuthConfig turns on authentication when any API key or client
+// certificate name is configured.
+type AuthConfig struct {
+	// Keys lists static keys as comma-separated key:role pairs; the role
+	// defaults to check. Any role may be written role@tenant to confine the
+	// key to one tenant.
+	Keys string `yaml:"keys"`
+	// KeysFile holds one "key role" pair per line; blank lines and lines
+	// starting with # are skipped.
+	KeysFile string `yaml:"keys_file"`
+	// Header carries the key, as an alternative to "Authorization: ApiKey <key>".
+	Header string `yaml:"header"`
+	// ClientCertRoles grants roles to verified client certificates as
+	// comma-separated san:role pairs, matching DNS, URI or email SANs.
+	ClientCertRoles string `yaml:"client_cert_roles"`
+	// AdminAllowCIDRs limits the admin endpoints to comma-separated networks
+	// or addresses; empty allows any source.
+	AdminAllowCIDRs string `yaml:"admin_allow_cidrs"`
+	// SigningKeysFile holds one "id secret role" line per caller that signs
+	// its requests; the role defaults to check.
+	SigningKeysFile string `yaml:"signing_keys_file"`
+	// SignatureMaxSkewMs is how far a signed request's timestamp may be
+	// from the server's clock.
+	SignatureMaxSkewMs int `yaml:"signature_max_skew_ms"`
+}
+
+// SigningKey is a request signing secret and the role it grants.
+type SigningKey struct {
+	Secret string
+	Role   string
+}
+
+// LoadKeys merges the static keys with the keys file, mapping each key to
+// its role.
+func (a AuthConfig) LoadKeys() (map[string]string, error) {
+	keys, err := parseRoles(a.Keys)
+	if err != nil {
+		return nil, err
+	}
+	if a.KeysFile == "" {
+		return keys, nil
+	}
+	data, err := os.ReadFile(a.KeysFile)
+	if err != nil {
+		return nil, fmt.Errorf("auth keys file: %w", err)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("auth keys file %s:%d: want \"key role\"", a.KeysFile, line)
+		}
+		role := "check"
+		if len(fields) == 2 {
+			role = fields[1]
+		}
+		if err := checkRole(role); err != nil {
+			return nil, fmt.Errorf("auth keys file %s:%d: %w", a.KeysFile, line, err)
+		}
+		keys[fields[0]] = role
+	}
+	return keys, scanner.Err()
+}
+
+// ClientRoles maps client certificate SANs to roles.
+func (a AuthConfig) ClientRoles() map[string]string {
+	roles, _ := parseRoles(a.ClientCertRoles)
+	return roles
+}
+
+// LoadSigningKeys reads SigningKeysFile, mapping key ids to their secrets.
+func (a AuthConfig) LoadSigningKeys() (map[string]SigningKey, error) {
+	if a.SigningKeysFile == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(a.SigningKeysFile)
+	if err != nil {
+		return nil, fmt.Errorf("signing keys file: %w", err)
+	}
+	keys := make(map[string]SigningKey)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("signing keys file %s:%d: want \"id secret role\"", a.SigningKeysFile, line)
+		}
+		key := SigningKey{Secret: fields[1], Role: "check"}
+		if len(fields) == 3 {
+			key.Role = fields[2]
+		}
+		if err := checkRole(key.Role); err != nil {
+			return nil, fmt.Errorf("signing keys file %s:%d: %w", a.SigningKeysFile, line, err)
+		}
+		keys[fields[0]] = key
+	}
+	return keys, scanner.Err()
+}
+
+// AdminNetworks returns the parsed AdminAllowCIDRs.
+func (a AuthConfig) AdminNetworks() []netip.Prefix {
+	networks, _ := parseNetworks(a.AdminAllowCIDRs)
+	return networks
+}
+
+// parseNetworks reads comma-separated CIDRs; a bare address stands for
+// itself alone.
+func parseNetworks(s string) ([]netip.Prefix, error) {
+	var networks []netip.Prefix
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if addr, err := netip.ParseAddr(item); err == nil {
+			networks = append(networks, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a CIDR or address", item)
+		}
+		networks = append(networks, prefix.Masked())
+	}
+	return networks, nil
+}
+
+// parseRoles reads comma-separated name:role pairs; the role defaults to
+// check. Names may contain colons (URI SANs do), so the role follows the
+// last one and must then be given.
+func parseRoles(s string) (map[string]string, error) {
+	roles := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, role := pair, "check"
+		if i := strings.LastIndex(pair, ":"); i >= 0 {
+			name, role = pair[:i], pair[i+1:]
+		}
+		if name == "" {
+			return nil, errors.New("empty name")
+		}
+		if err := checkRole(role); err != nil {
+			return nil, err
+		}
+		roles[name] = role
+	}
+	return roles, nil
+}
+
+func checkRole(role string) error {
+	role, tenant, bound := strings.Cut(role, "@")
+	if bound {
+		if err := checkTenant(tenant); err != nil {
+			return err
+		}
+	}
+	switch role {
+	case "check", "viewer", "operator", "admin":
+		return nil
+	default:
+		return fmt.Errorf("%q is not check, viewer, operator or admin", role)
+	}
+}
@@ -0,0 +1,68 @@
+package config
+
+import "fmt"
+
+// ClusterConfig spreads keys over instances on the memory backend by
+// consistent hashing. It is on when Members is set; every member must list
+// the same members.
+type ClusterConfig struct {
+	// Self names this instance among Members.
+	Self string `yaml:"self"`
+	// Members lists every instance, this one included, as comma-separated
+	// name=url pairs.
+	Members string `yaml:"members"`
+	// Mode is "forward" (non-owners pass checks on to the owner) or
+	// "redirect" (non-owners answer single checks with a 307 to it; batches
+	// are still forwarded).
+	Mode         string `yaml:"mode"`
+	VirtualNodes int    `yaml:"virtual_nodes"`
+	// APIKey authenticates this instance to the others; it needs the
+	// operator role there.
+	APIKey string `yaml:"api_key"`
+}
+
+// Enabled reports whether keys are spread over a cluster.
+func (c ClusterConfig) Enabled() bool {
+	return c.Members != ""
+}
+
+// MemberList returns the parsed Members.
+func (c ClusterConfig) MemberList() []NamedURL {
+	members, _ := parseNamedURLs(c.Members)
+	return members
+}
+
+func (c *Config) validateCluster(bad func(field, problem string)) {
+	cl := c.Cluster
+	switch cl.Mode {
+	case "forward", "redirect":
+	default:
+		bad("cluster.mode", fmt.Sprintf("%q is not forward or redirect", cl.Mode))
+	}
+	if cl.VirtualNodes <= 0 {
+		bad("cluster.virtual_nodes", "must be positive")
+	}
+	if !cl.Enabled() {
+		return
+	}
+	members, err := parseNamedURLs(cl.Members)
+	if err != nil {
+		bad("cluster.members", err.Error())
+	}
+	seen := make(map[string]bool)
+	for _, m := range members {
+		if seen[m.Name] {
+			bad("cluster.members", fmt.Sprintf("%q is listed twice", m.Name))
+		}
+		seen[m.Name] = true
+	}
+	if err == nil && !seen[cl.Self] {
+		bad("cluster.self", fmt.Sprintf("%q is not one of cluster.members", cl.Self))
+	}
+	if c.Backend.Kind != "memory" {
+		bad("cluster.members", "only for the memory backend; instances on redis already share state")
+	}
+	if c.Gossip.Enabled() || c.Replication.Peers != "" {
+		bad("cluster.members", "cannot be combined with gossip or replication.peers")
+	}
+}
@@ -1,44 +1,943 @@
 package config
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
 	"os"
+	"path/filepath"
+	"slices"
 	"strconv"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
 )
 
+// Config is assembled from defaults, then the file named by -config or
+// CONFIG_FILE (if any), then environment variables, then command-line flags,
+// each overriding the one before.
 type Config struct {
-	Port          string
-	Backend       string
-	RedisAddr     string
-	RedisPassword string
-	RedisDB       int
+	Server ServerConfig `yaml:"server"`
+	Auth   AuthConfig   `yaml:"auth"`
+	JWT    JWTConfig    `yaml:"jwt"`
+	// CallerLimits is the service's own per-caller rate limit.
+	CallerLimits CallerLimitsConfig `yaml:"caller_limits"`
+	Secrets      SecretsConfig      `yaml:"secrets"`
+	Audit        AuditConfig        `yaml:"audit"`
+	KeyHashing   KeyHashingConfig   `yaml:"key_hashing"`
+	Reconcile    ReconcileConfig    `yaml:"reconcile"`
+	Backend      BackendConfig      `yaml:"backend"`
+	Policies     PoliciesConfig     `yaml:"policies"`
+	Tenants      TenantsConfig      `yaml:"tenants"`
+	// Groups are budgets shared by the keys of checks naming them.
+	Groups []GroupConfig `yaml:"groups"`
+	// LimitPolicies are the named limits of the v2 check API.
+	LimitPolicies []LimitPolicyConfig `yaml:"limit_policies"`
+	// Descriptors are limits checks may name by domain and descriptor,
+	// written for lyft/ratelimit.
+	Descriptors DescriptorsConfig `yaml:"descriptors"`
+	Hook        HookConfig        `yaml:"hook"`
+	Usage       UsageConfig       `yaml:"usage"`
+	Events      EventsConfig      `yaml:"events"`
+	GraphQL     GraphQLConfig     `yaml:"graphql"`
+	Metrics     MetricsConfig     `yaml:"metrics"`
+	ExtAuthz    ExtAuthzConfig    `yaml:"ext_authz"`
+	Chaos       ChaosConfig       `yaml:"chaos"`
+	Replication ReplicationConfig `yaml:"replication"`
+	Gossip      GossipConfig      `yaml:"gossip"`
+	Cluster     ClusterConfig     `yaml:"cluster"`
+	Maintenance MaintenanceConfig `yaml:"maintenance"`
 }
 
-func Load() Config {
-	return Config{
-		Port:          getEnv("PORT", "8080"),
-		Backend:       getEnv("BACKEND", "memory"),
-		RedisAddr:     getEnv("REDIS_ADDR", "127.0.0.1:6379"),
-		RedisPassword: getEnv("REDIS_PASSWORD", ""),
-		RedisDB:       getEnvInt("REDIS_DB", 0),
+type ServerConfig struct {
+	Port             string `yaml:"port"`
+	BatchMaxItems    int    `yaml:"batch_max_items"`
+	BatchConcurrency int    `yaml:"batch_concurrency"`
+	MaxBodyBytes     int64  `yaml:"max_body_bytes"`
+	// StrictRequests refuses checks with unknown fields or mistyped
+	// values instead of ignoring the former.
+	StrictRequests bool          `yaml:"strict_requests"`
+	HTTP           HTTPConfig    `yaml:"http"`
+	TLS            TLSConfig     `yaml:"tls"`
+	Headers        HeadersConfig `yaml:"headers"`
+	Errors         ErrorsConfig  `yaml:"errors"`
+	// TopKeys, when positive, counts checks by key so that
+	// /v1/admin/keys/top can list that many of the busiest.
+	TopKeys int `yaml:"top_keys"`
+}
+
+// ErrorsConfig shapes error responses: Format json answers them as
+// {"error": code} unless the client accepts application/problem+json, and
+// problem always as RFC 9457 problem details, whose type is TypeBaseURL
+// followed by the error code, or about:blank without one.
+type ErrorsConfig struct {
+	Format      string `yaml:"format"`
+	TypeBaseURL string `yaml:"type_base_url"`
+}
+
+// HeadersConfig names the headers carrying a check's decision: Prefix
+// followed by Remaining, Reset-Ms, Retry-After-Ms, Suggested-Interval-Ms,
+// Params-Changed, Degraded, Memory-Pressure and Chaos, and RateLimit-Policy.
+// Names renames single headers by their keys, remaining, reset_ms,
+// retry_after_ms, suggested_interval_ms, params_changed, degraded,
+// memory_pressure, policy and chaos; an empty name leaves that header out,
+// and Enabled false leaves them all out.
+type HeadersConfig struct {
+	Enabled bool              `yaml:"enabled"`
+	Prefix  string            `yaml:"prefix"`
+	Names   map[string]string `yaml:"names"`
+}
+
+// headerNameKeys are the keys of HeadersConfig.Names.
+var headerNameKeys = []string{"remaining", "reset_ms", "retry_after_ms", "suggested_interval_ms", "params_changed", "degraded", "memory_pressure", "policy", "chaos"}
+
+// validHeaderName reports whether name is an HTTP token.
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if c > unicode.MaxASCII || !(unicode.IsLetter(c) || unicode.IsDigit(c) || strings.ContainsRune("!#$%&'*+-.^_`|~", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// HTTPConfig tunes the HTTP server. A zero timeout means none.
+type HTTPConfig struct {
+	ReadHeaderTimeoutMs int `yaml:"read_header_timeout_ms"`
+	ReadTimeoutMs       int `yaml:"read_timeout_ms"`
+	WriteTimeoutMs      int `yaml:"write_timeout_ms"`
+	IdleTimeoutMs       int `yaml:"idle_timeout_ms"`
+	MaxHeaderBytes      int `yaml:"max_header_bytes"`
+	// ShutdownGraceMs is how long in-flight requests get to finish after
+	// SIGTERM.
+	ShutdownGraceMs int `yaml:"shutdown_grace_ms"`
+}
+
+// TLSConfig enables HTTPS when both files are set.
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// ReloadIntervalMs is how often the files are checked for rotation;
+	// 0 only reloads them with the rest of the configuration.
+	ReloadIntervalMs int `yaml:"reload_interval_ms"`
+	// ClientCAFile, when set, makes clients present a certificate signed by
+	// one of its CAs.
+	ClientCAFile string `yaml:"client_ca_file"`
+	// ClientAuth is "require" or "optional"; optional still verifies any
+	// certificate that is presented.
+	ClientAuth string `yaml:"client_auth"`
+}
+
+func (t TLSConfig) Enabled() bool {
+	return t.CertFile != "" && t.KeyFile != ""
+}
+
+// JWTConfig turns on signature checks for tokens used as keys when a key
+// set is configured.
+type JWTConfig struct {
+	JWKSURL           string `yaml:"jwks_url"`
+	JWKSFile          string `yaml:"jwks_file"`
+	RefreshIntervalMs int    `yaml:"refresh_interval_ms"`
+	Issuer            string `yaml:"issuer"`
+	Audience          string `yaml:"audience"`
+	LeewayMs          int    `yaml:"leeway_ms"`
+}
+
+func (j JWTConfig) Enabled() bool {
+	return j.JWKSURL != "" || j.JWKSFile != ""
+}
+
+// CallerLimitsConfig caps requests per second from one caller, identified
+// by API key or else source IP. A zero rate disables the cap; a zero burst
+// equals the rate.
+type CallerLimitsConfig struct {
+	CheckPerSec int64 `yaml:"check_per_sec"`
+	CheckBurst  int64 `yaml:"check_burst"`
+	AdminPerSec int64 `yaml:"admin_per_sec"`
+	AdminBurst  int64 `yaml:"admin_burst"`
+}
+
+// EventsConfig controls key lifecycle events. Each instance follows up to
+// MaxKeys of the keys it checks.
+type EventsConfig struct {
+	Enabled bool `yaml:"enabled"`
+	MaxKeys int  `yaml:"max_keys"`
+	// Format is "native" or "cloudevents", which wraps events in
+	// CloudEvents envelopes with CloudEventsSource, by default naming the
+	// host, and a type of CloudEventsTypePrefix and the event type.
+	Format                string `yaml:"format"`
+	CloudEventsSource     string `yaml:"cloudevents_source"`
+	CloudEventsTypePrefix string `yaml:"cloudevents_type_prefix"`
+}
+
+// GraphQLConfig turns on the GraphQL endpoint over the admin data.
+type GraphQLConfig struct {
+	Enabled bool `yaml:"enabled"`
+}
+
+// MetricsConfig serves Prometheus metrics at /metrics when Enabled, and
+// pushes them every PushIntervalMs to a Pushgateway and to a remote-write
+// receiver when their URLs are set, as Job and Instance, which defaults to
+// the host name. The tracked key counts are counted again once
+// KeyCountIntervalMs old.
+type MetricsConfig struct {
+	Enabled            bool   `yaml:"enabled"`
+	PushgatewayURL     string `yaml:"pushgateway_url"`
+	RemoteWriteURL     string `yaml:"remote_write_url"`
+	PushIntervalMs     int    `yaml:"push_interval_ms"`
+	Job                string `yaml:"job"`
+	Instance           string `yaml:"instance"`
+	KeyCountIntervalMs int    `yaml:"key_count_interval_ms"`
+}
+
+// Pushing reports whether metrics go anywhere on an interval.
+func (m MetricsConfig) Pushing() bool {
+	return m.PushgatewayURL != "" || m.RemoteWriteURL != ""
+}
+
+// ExtAuthzConfig serves Envoy's ext_authz gRPC API when Enabled, checking
+// the key built from the Key template with the check fields in Check, a
+// JSON object.
+type ExtAuthzConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Key     string `yaml:"key"`
+	Check   string `yaml:"check"`
+}
+
+// ChaosConfig injects faults into checks when Enabled, each into the
+// fraction of checks its rate gives: LatencyMs of latency, backend errors
+// and denials. It is for testing clients, never for production.
+type ChaosConfig struct {
+	Enabled     bool    `yaml:"enabled"`
+	LatencyMs   int     `yaml:"latency_ms"`
+	LatencyRate float64 `yaml:"latency_rate"`
+	ErrorRate   float64 `yaml:"error_rate"`
+	DenyRate    float64 `yaml:"deny_rate"`
+}
+
+// MaintenanceConfig controls the background jobs one instance runs for
+// all those sharing Redis, elected by a lease that lapses after LeaseMs
+// without renewal. IntervalMs 0 turns the jobs off. The orphan sweep gives
+// limiter keys that have no expiry one of OrphanTTLMs.
+type MaintenanceConfig struct {
+	IntervalMs  int `yaml:"interval_ms"`
+	LeaseMs     int `yaml:"lease_ms"`
+	OrphanTTLMs int `yaml:"orphan_ttl_ms"`
+}
+
+// KeyHashingConfig turns on HMAC'd user and device ids in backend keys.
+// After a rotation keys under PreviousSecret keep counting for GraceMs.
+type KeyHashingConfig struct {
+	Secret         string `yaml:"secret"`
+	PreviousSecret string `yaml:"previous_secret"`
+	GraceMs        int    `yaml:"grace_ms"`
+}
+
+// ReconcileConfig signs the decision IDs of reconcilable checks. Instances
+// sharing Secret reconcile each other's decisions; without one each signs
+// with a secret of its own. TTLMs is how long a decision can be reconciled.
+type ReconcileConfig struct {
+	Secret string `yaml:"secret"`
+	TTLMs  int    `yaml:"ttl_ms"`
+}
+
+// AuditConfig locates the audit log of admin actions. Without a file the
+// most recent MemoryEntries are kept in memory and lost on restart.
+type AuditConfig struct {
+	File          string `yaml:"file"`
+	MemoryEntries int    `yaml:"memory_entries"`
+}
+
+type BackendConfig struct {
+	Kind      string `yaml:"kind"`
+	TimeoutMs int    `yaml:"timeout_ms"`
+	FailMode  string `yaml:"fail_mode"`
+	// ShadowTTLMs, when positive, serves checks from the last state the
+	// backend reported for a key, up to this old, while the backend fails.
+	ShadowTTLMs   int `yaml:"shadow_ttl_ms"`
+	ShadowMaxKeys int `yaml:"shadow_max_keys"`
+	// NewKeyFilterKeys, when positive, allows checks on keys not among
+	// about that many recently checked ones without a backend round trip.
+	NewKeyFilterKeys int             `yaml:"new_key_filter_keys"`
+	Redis            RedisConfig     `yaml:"redis"`
+	Memory           MemoryConfig    `yaml:"memory"`
+	Sketch           SketchConfig    `yaml:"sketch"`
+	StateTTL         StateTTLConfig  `yaml:"state_ttl"`
+	Compare          CompareConfig   `yaml:"compare"`
+	WarmStart        WarmStartConfig `yaml:"warm_start"`
+}
+
+// WarmStartConfig preloads an instance's shadow and new key filter with
+// up to Keys of the busiest keys when it starts, so a restart does not set
+// off a burst of backend calls and unsettled decisions. With File the
+// instance counts its checks by key and writes the busiest there when it
+// shuts down, for the next start to read; without, they are found in the
+// backend's state. Warming gives up after TimeoutMs. Keys 0 turns it off.
+type WarmStartConfig struct {
+	Keys      int    `yaml:"keys"`
+	File      string `yaml:"file"`
+	TimeoutMs int    `yaml:"timeout_ms"`
+}
+
+// CompareConfig evaluates every check on a candidate backend as well, off
+// the request path, and records where its decisions differ from the
+// backend's; clients only ever get the backend's. An empty Kind turns it
+// off. A Redis without an address of its own uses the shared server and
+// password, like a tenant's. Candidate checks taking longer than
+// TimeoutMs fail, and those beyond MaxInFlight running at once are
+// skipped. Recent divergences are kept for /v1/admin/compare.
+type CompareConfig struct {
+	Kind        string      `yaml:"kind"`
+	Redis       RedisConfig `yaml:"redis"`
+	TimeoutMs   int         `yaml:"timeout_ms"`
+	MaxInFlight int         `yaml:"max_in_flight"`
+	Recent      int         `yaml:"recent"`
+}
+
+// RedisConfig returns the candidate's Redis settings with the shared
+// server filled in.
+func (c CompareConfig) RedisConfig(shared RedisConfig) RedisConfig {
+	return withSharedServer(c.Redis, shared)
+}
+
+type RedisConfig struct {
+	Addr     string `yaml:"addr"`
+	Password string `yaml:"password"`
+	// PasswordFile holds the password instead of Password.
+	PasswordFile string `yaml:"password_file"`
+	DB           int    `yaml:"db"`
+	// ReplicaAddrs lists replicas of Addr that serve peeks and usage
+	// reports; not supported in cluster mode.
+	ReplicaAddrs string `yaml:"replica_addrs"`
+	KeyPrefix    string `yaml:"key_prefix"`
+	HashTags     bool   `yaml:"hash_tags"`
+	ServerTime   bool   `yaml:"server_time"`
+}
+
+// MemoryConfig tunes the memory backend. MaxBytes, when positive, caps the
+// estimated size of its key states, evicting those expiring soonest.
+type MemoryConfig struct {
+	SlidingLogMaxEntries int   `yaml:"sliding_log_max_entries"`
+	MaxBytes             int64 `yaml:"max_bytes"`
+}
+
+// SketchConfig bounds the error of count_min_sketch counts: one is at most
+// Epsilon times the cost counted in its window too high, except with
+// probability Delta. Smaller values take more memory.
+type SketchConfig struct {
+	Epsilon float64 `yaml:"epsilon"`
+	Delta   float64 `yaml:"delta"`
+}
+
+// StateTTLConfig sets how long a key's state is kept once it goes idle:
+// the time its algorithm needs it for, such as a window or a bucket's
+// refill, plus PaddingMs, capped at MaxMs when that is positive. State
+// dropped early by the cap starts afresh. Algorithms overrides either per
+// algorithm; zero fields there take the values here.
+type StateTTLConfig struct {
+	PaddingMs  int                           `yaml:"padding_ms"`
+	MaxMs      int                           `yaml:"max_ms"`
+	Algorithms map[string]AlgorithmTTLConfig `yaml:"algorithms"`
+}
+
+type AlgorithmTTLConfig struct {
+	PaddingMs int `yaml:"padding_ms"`
+	MaxMs     int `yaml:"max_ms"`
+}
+
+// stateAlgorithms are the algorithms whose state expires.
+var stateAlgorithms = []string{"token_bucket", "leaky_bucket", "fixed_window", "sliding_window_log",
+	"sliding_window_counter", "count_min_sketch", "weighted_fair_share"}
+
+// PoliciesConfig bounds what a single check may ask for.
+type PoliciesConfig struct {
+	MaxCost     int64 `yaml:"max_cost"`
+	MaxCapacity int64 `yaml:"max_capacity"`
+	MaxLimit    int64 `yaml:"max_limit"`
+	MaxWindowMs int64 `yaml:"max_window_ms"`
+	// MaxLogLimit caps a sliding_window_log's limit, the hits it keeps
+	// per key, below MaxLimit; 0 leaves it at MaxLimit.
+	MaxLogLimit int64 `yaml:"max_log_limit"`
+	// Algorithms, when not empty, are the only algorithms checks may ask
+	// for with limits of their own; descriptors, limit policies and the
+	// hook may still choose any.
+	Algorithms []string    `yaml:"algorithms"`
+	Grace      GraceConfig `yaml:"grace"`
+	// RetryJitter adds up to this fraction of retry_after_ms to denials at
+	// random.
+	RetryJitter float64 `yaml:"retry_jitter"`
+	// Costs prices checks that describe their request by method and path
+	// instead of giving a cost.
+	Costs map[string]int64 `yaml:"costs"`
+	// DenyBody is text added to the bodies of denied checks.
+	DenyBody DenyBodyConfig `yaml:"deny_body"`
+}
+
+// DenyBodyConfig is text added to the bodies of denied checks, for the end
+// users of an API: a message and links to documentation and to an upgrade.
+// Each may hold placeholders such as {retry_after_s}; a tenant's replace
+// the global ones field by field.
+type DenyBodyConfig struct {
+	Message          string `yaml:"message"`
+	DocumentationURL string `yaml:"documentation_url"`
+	UpgradeURL       string `yaml:"upgrade_url"`
+}
+
+func (d DenyBodyConfig) validate(field string, bad func(field, problem string)) {
+	for _, u := range []struct {
+		name, value string
+	}{
+		{"documentation_url", d.DocumentationURL},
+		{"upgrade_url", d.UpgradeURL},
+	} {
+		if u.value != "" && !strings.HasPrefix(u.value, "https://") && !strings.HasPrefix(u.value, "http://") {
+			bad(field+"."+u.name, "must be an http or https URL")
+		}
 	}
 }
 
-func getEnv(key, fallback string) string {
-	value := os.Getenv(key)
-	if value == "" {
-		return fallback
+// HookConfig sends each check to a decision service, such as OPA, that
+// may choose its limit and cost or decide it. FailMode is "error" (fail
+// the check) or "skip" (evaluate it as sent) when the hook fails.
+type HookConfig struct {
+	URL       string `yaml:"url"`
+	TimeoutMs int    `yaml:"timeout_ms"`
+	FailMode  string `yaml:"fail_mode"`
+}
+
+// GraceConfig lets a key that exhausted its limit go on for Requests more
+// cost or for DurationMs, whichever ends first, once per PeriodMs. Zero
+// Requests and DurationMs disable it.
+type GraceConfig struct {
+	Requests   int64 `yaml:"requests"`
+	DurationMs int   `yaml:"duration_ms"`
+	PeriodMs   int   `yaml:"period_ms"`
+}
+
+func (g GraceConfig) validate(field string, bad func(field, problem string)) {
+	if g.Requests < 0 {
+		bad(field+".requests", "must not be negative")
+	}
+	if g.DurationMs < 0 {
+		bad(field+".duration_ms", "must not be negative")
+	}
+	if g.PeriodMs < 0 {
+		bad(field+".period_ms", "must not be negative")
 	}
-	return value
 }
 
-func getEnvInt(key string, fallback int) int {
-	value := os.Getenv(key)
-	if value == "" {
-		return fallback
+func Default() Config {
+	return Config{
+		Server: ServerConfig{
+			Port:             "8080",
+			BatchMaxItems:    100,
+			BatchConcurrency: 8,
+			MaxBodyBytes:     1 << 20,
+			HTTP: HTTPConfig{
+				ReadHeaderTimeoutMs: 5000,
+				ReadTimeoutMs:       10000,
+				WriteTimeoutMs:      10000,
+				IdleTimeoutMs:       120000,
+				MaxHeaderBytes:      1 << 20,
+				ShutdownGraceMs:     10000,
+			},
+			Headers: HeadersConfig{
+				Enabled: true,
+				Prefix:  "X-RateLimit-",
+			},
+			Errors: ErrorsConfig{
+				Format: "json",
+			},
+			TLS: TLSConfig{
+				ClientAuth: "require",
+			},
+		},
+		Auth: AuthConfig{
+			Header:             "X-API-Key",
+			SignatureMaxSkewMs: 300000,
+		},
+		JWT: JWTConfig{
+			RefreshIntervalMs: 300000,
+			LeewayMs:          60000,
+		},
+		Audit: AuditConfig{
+			MemoryEntries: 1000,
+		},
+		Usage: UsageConfig{
+			FlushIntervalMs: 10000,
+			RetentionDays:   400,
+			KeyHistoryDays:  7,
+		},
+		Events: EventsConfig{
+			MaxKeys:               100000,
+			Format:                "native",
+			CloudEventsTypePrefix: "io.ratelimiter.key.",
+		},
+		Metrics: MetricsConfig{
+			PushIntervalMs:     15000,
+			Job:                "rate-limiter",
+			KeyCountIntervalMs: 60000,
+		},
+		ExtAuthz: ExtAuthzConfig{
+			Key:   "{source_ip}",
+			Check: `{"algorithm":"fixed_window","limit":60,"window_ms":60000}`,
+		},
+		KeyHashing: KeyHashingConfig{
+			GraceMs: 3600000,
+		},
+		Reconcile: ReconcileConfig{
+			TTLMs: 3600000,
+		},
+		Replication: ReplicationConfig{
+			SyncIntervalMs: 200,
+			MaxDeltaAgeMs:  60000,
+		},
+		Gossip: GossipConfig{
+			IntervalMs:     1000,
+			Fanout:         3,
+			DeadAfterMs:    10000,
+			SyncIntervalMs: 200,
+		},
+		Cluster: ClusterConfig{
+			Mode:         "forward",
+			VirtualNodes: 128,
+		},
+		Maintenance: MaintenanceConfig{
+			IntervalMs:  600000,
+			LeaseMs:     30000,
+			OrphanTTLMs: 604800000,
+		},
+		Backend: BackendConfig{
+			Kind:          "memory",
+			TimeoutMs:     500,
+			FailMode:      "error",
+			ShadowMaxKeys: 100000,
+			Redis: RedisConfig{
+				Addr: "127.0.0.1:6379",
+			},
+			Memory: MemoryConfig{
+				SlidingLogMaxEntries: 10000,
+			},
+			Sketch: SketchConfig{
+				Epsilon: 0.001,
+				Delta:   0.01,
+			},
+			StateTTL: StateTTLConfig{
+				PaddingMs: 1000,
+			},
+			Compare: CompareConfig{
+				TimeoutMs:   1000,
+				MaxInFlight: 64,
+				Recent:      100,
+			},
+			WarmStart: WarmStartConfig{
+				TimeoutMs: 10000,
+			},
+		},
+		Hook: HookConfig{
+			TimeoutMs: 100,
+			FailMode:  "error",
+		},
+		Policies: PoliciesConfig{
+			MaxCost:     1000000,
+			MaxCapacity: 1000000000,
+			MaxLimit:    1000000000,
+			MaxWindowMs: 604800000,
+			Grace:       GraceConfig{PeriodMs: 86400000},
+		},
 	}
-	parsed, err := strconv.Atoi(value)
+}
+
+// Load builds the configuration from defaults, CONFIG_FILE and the
+// environment only; servers with command-line flags use a Loader.
+func Load() (Config, error) {
+	return (&Loader{}).Load()
+}
+
+func loadFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
 	if err != nil {
-		return fallback
+		return fmt.Errorf("config file: %w", err)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+	default:
+		return fmt.Errorf("config file %s: unsupported format, want .yaml or .yml", path)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate reports every invalid setting at once.
+func (c Config) Validate() error {
+	var errs []error
+	bad := func(field, problem string) {
+		errs = append(errs, fmt.Errorf("%s: %s", field, problem))
+	}
+
+	if port, err := strconv.Atoi(c.Server.Port); err != nil || port < 1 || port > 65535 {
+		bad("server.port", fmt.Sprintf("%q is not a valid port", c.Server.Port))
+	}
+	if c.Server.BatchMaxItems <= 0 {
+		bad("server.batch_max_items", "must be positive")
+	}
+	if c.Server.BatchConcurrency <= 0 {
+		bad("server.batch_concurrency", "must be positive")
+	}
+	if c.Server.TopKeys < 0 {
+		bad("server.top_keys", "must not be negative")
+	}
+	if c.Server.MaxBodyBytes <= 0 {
+		bad("server.max_body_bytes", "must be positive")
+	}
+	httpCfg := c.Server.HTTP
+	for _, t := range []struct {
+		field string
+		value int
+	}{
+		{"server.http.read_header_timeout_ms", httpCfg.ReadHeaderTimeoutMs},
+		{"server.http.read_timeout_ms", httpCfg.ReadTimeoutMs},
+		{"server.http.write_timeout_ms", httpCfg.WriteTimeoutMs},
+		{"server.http.idle_timeout_ms", httpCfg.IdleTimeoutMs},
+		{"server.http.shutdown_grace_ms", httpCfg.ShutdownGraceMs},
+	} {
+		if t.value < 0 {
+			bad(t.field, "must not be negative")
+		}
+	}
+	if httpCfg.MaxHeaderBytes <= 0 {
+		bad("server.http.max_header_bytes", "must be positive")
+	}
+	if (c.Server.TLS.CertFile == "") != (c.Server.TLS.KeyFile == "") {
+		bad("server.tls", "cert_file and key_file must be set together")
+	}
+	if c.Server.Headers.Enabled {
+		// The prefix is checked as the start of a name.
+		if !validHeaderName(c.Server.Headers.Prefix + "Remaining") {
+			bad("server.headers.prefix", "must start valid header names")
+		}
+		for key, name := range c.Server.Headers.Names {
+			if !slices.Contains(headerNameKeys, key) {
+				bad("server.headers.names", fmt.Sprintf("%q is not one of %s", key, strings.Join(headerNameKeys, ", ")))
+			} else if name != "" && !validHeaderName(name) {
+				bad("server.headers.names."+key, fmt.Sprintf("%q is not a valid header name", name))
+			}
+		}
+	}
+	if c.Server.Errors.Format != "json" && c.Server.Errors.Format != "problem" {
+		bad("server.errors.format", "must be json or problem")
+	}
+	if base := c.Server.Errors.TypeBaseURL; base != "" {
+		if u, err := url.Parse(base); err != nil || !u.IsAbs() {
+			bad("server.errors.type_base_url", "must be an absolute URI")
+		}
+	}
+	if c.Server.TLS.ReloadIntervalMs < 0 {
+		bad("server.tls.reload_interval_ms", "must not be negative")
+	}
+	if c.Server.TLS.ClientCAFile != "" && !c.Server.TLS.Enabled() {
+		bad("server.tls.client_ca_file", "requires cert_file and key_file")
+	}
+	switch c.Server.TLS.ClientAuth {
+	case "require", "optional":
+	default:
+		bad("server.tls.client_auth", fmt.Sprintf("%q is not require or optional", c.Server.TLS.ClientAuth))
+	}
+
+	if _, err := parseRoles(c.Auth.Keys); err != nil {
+		bad("auth.keys", err.Error())
+	}
+	if _, err := parseRoles(c.Auth.ClientCertRoles); err != nil {
+		bad("auth.client_cert_roles", err.Error())
+	}
+	if c.Auth.SignatureMaxSkewMs <= 0 {
+		bad("auth.signature_max_skew_ms", "must be positive")
+	}
+	if _, err := parseNetworks(c.Auth.AdminAllowCIDRs); err != nil {
+		bad("auth.admin_allow_cidrs", err.Error())
+	}
+	if c.Auth.ClientCertRoles != "" && c.Server.TLS.ClientCAFile == "" {
+		bad("auth.client_cert_roles", "requires server.tls.client_ca_file")
+	}
+	if strings.TrimSpace(c.Auth.Header) == "" {
+		bad("auth.header", "required")
+	}
+
+	if c.JWT.JWKSURL != "" && c.JWT.JWKSFile != "" {
+		bad("jwt", "set jwks_url or jwks_file, not both")
+	}
+	if c.JWT.JWKSURL != "" {
+		if u, err := url.Parse(c.JWT.JWKSURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			bad("jwt.jwks_url", fmt.Sprintf("%q is not an http(s) URL", c.JWT.JWKSURL))
+		}
+	}
+	if c.Hook.URL != "" {
+		if u, err := url.Parse(c.Hook.URL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			bad("hook.url", fmt.Sprintf("%q is not an http(s) URL", c.Hook.URL))
+		}
+	}
+	if c.Hook.TimeoutMs <= 0 {
+		bad("hook.timeout_ms", "must be positive")
+	}
+	switch c.Hook.FailMode {
+	case "error", "skip":
+	default:
+		bad("hook.fail_mode", fmt.Sprintf("%q is not error or skip", c.Hook.FailMode))
+	}
+	if c.JWT.RefreshIntervalMs <= 0 {
+		bad("jwt.refresh_interval_ms", "must be positive")
+	}
+	if c.JWT.LeewayMs < 0 {
+		bad("jwt.leeway_ms", "must not be negative")
+	}
+
+	for _, l := range []struct {
+		field string
+		value int64
+	}{
+		{"caller_limits.check_per_sec", c.CallerLimits.CheckPerSec},
+		{"caller_limits.check_burst", c.CallerLimits.CheckBurst},
+		{"caller_limits.admin_per_sec", c.CallerLimits.AdminPerSec},
+		{"caller_limits.admin_burst", c.CallerLimits.AdminBurst},
+	} {
+		if l.value < 0 {
+			bad(l.field, "must not be negative")
+		}
+	}
+
+	if c.Secrets.RefreshIntervalMs < 0 {
+		bad("secrets.refresh_interval_ms", "must not be negative")
+	}
+	if c.Secrets.RefreshIntervalMs > 0 && c.Secrets.VaultAddr == "" {
+		bad("secrets.refresh_interval_ms", "requires secrets.vault_addr")
+	}
+
+	if c.Audit.MemoryEntries <= 0 {
+		bad("audit.memory_entries", "must be positive")
+	}
+
+	if s := c.KeyHashing.Secret; s != "" && len(s) < 16 {
+		bad("key_hashing.secret", "must be at least 16 bytes")
+	}
+	if c.KeyHashing.PreviousSecret != "" && c.KeyHashing.Secret == "" {
+		bad("key_hashing.previous_secret", "requires key_hashing.secret")
+	}
+	if c.KeyHashing.GraceMs <= 0 {
+		bad("key_hashing.grace_ms", "must be positive")
+	}
+	if s := c.Reconcile.Secret; s != "" && len(s) < 16 {
+		bad("reconcile.secret", "must be at least 16 bytes")
+	}
+	if c.Reconcile.TTLMs <= 0 {
+		bad("reconcile.ttl_ms", "must be positive")
+	}
+
+	switch c.Backend.Kind {
+	case "memory":
+		for i, tenant := range c.Tenants.List {
+			if tenant.Backend.Kind == "redis" && tenant.Backend.Redis.Addr == "" {
+				bad(fmt.Sprintf("tenants.list[%d].backend.redis.addr", i), "required when the shared backend is memory")
+			}
+		}
+	case "redis":
+		if c.Backend.Redis.Addr == "" {
+			bad("backend.redis.addr", "required for the redis backend")
+		}
+	default:
+		bad("backend.kind", fmt.Sprintf("%q is not memory or redis", c.Backend.Kind))
+	}
+	if c.Backend.TimeoutMs < 0 {
+		bad("backend.timeout_ms", "must not be negative")
+	}
+	switch c.Backend.FailMode {
+	case "error", "open", "closed":
+	default:
+		bad("backend.fail_mode", fmt.Sprintf("%q is not error, open or closed", c.Backend.FailMode))
+	}
+	if c.Backend.ShadowTTLMs < 0 {
+		bad("backend.shadow_ttl_ms", "must not be negative")
+	}
+	if c.Backend.NewKeyFilterKeys < 0 {
+		bad("backend.new_key_filter_keys", "must not be negative")
+	}
+	if c.Backend.ShadowMaxKeys <= 0 {
+		bad("backend.shadow_max_keys", "must be positive")
+	}
+	if c.Backend.Redis.ReplicaAddrs != "" && strings.Contains(c.Backend.Redis.Addr, ",") {
+		bad("backend.redis.replica_addrs", "not supported with a cluster address list")
+	}
+	if c.Backend.Redis.DB < 0 {
+		bad("backend.redis.db", "must not be negative")
+	}
+	c.Backend.validateCompare(bad)
+	if c.Backend.WarmStart.Keys < 0 {
+		bad("backend.warm_start.keys", "must not be negative")
+	}
+	if c.Backend.WarmStart.TimeoutMs <= 0 {
+		bad("backend.warm_start.timeout_ms", "must be positive")
+	}
+	if c.Backend.Memory.MaxBytes < 0 {
+		bad("backend.memory.max_bytes", "must not be negative")
+	}
+	if c.Backend.Memory.SlidingLogMaxEntries <= 0 {
+		bad("backend.memory.sliding_log_max_entries", "must be positive")
+	}
+	if c.Backend.Sketch.Epsilon <= 0 || c.Backend.Sketch.Epsilon >= 1 {
+		bad("backend.sketch.epsilon", "must be between 0 and 1")
+	}
+	if c.Backend.Sketch.Delta <= 0 || c.Backend.Sketch.Delta >= 1 {
+		bad("backend.sketch.delta", "must be between 0 and 1")
+	}
+	if c.Backend.StateTTL.PaddingMs <= 0 {
+		bad("backend.state_ttl.padding_ms", "must be positive")
+	}
+	if c.Backend.StateTTL.MaxMs < 0 {
+		bad("backend.state_ttl.max_ms", "must not be negative")
+	}
+	for name, ttl := range c.Backend.StateTTL.Algorithms {
+		field := "backend.state_ttl.algorithms." + name
+		if !slices.Contains(stateAlgorithms, name) {
+			bad(field, "is not an algorithm")
+		}
+		if ttl.PaddingMs < 0 || ttl.MaxMs < 0 {
+			bad(field, "padding_ms and max_ms must not be negative")
+		}
+	}
+
+	if c.Policies.MaxCost <= 0 {
+		bad("policies.max_cost", "must be positive")
+	}
+	if c.Policies.MaxCapacity <= 0 {
+		bad("policies.max_capacity", "must be positive")
+	}
+	if c.Policies.MaxLimit <= 0 {
+		bad("policies.max_limit", "must be positive")
+	}
+	if c.Policies.MaxWindowMs <= 0 {
+		bad("policies.max_window_ms", "must be positive")
+	}
+	if c.Policies.MaxLogLimit < 0 {
+		bad("policies.max_log_limit", "must not be negative")
+	}
+	validateAlgorithms("policies.algorithms", c.Policies.Algorithms, bad)
+	c.Policies.Grace.validate("policies.grace", bad)
+	if c.Policies.RetryJitter < 0 || c.Policies.RetryJitter > 1 {
+		bad("policies.retry_jitter", "must be between 0 and 1")
+	}
+	validateCosts("policies.costs", c.Policies.Costs, bad)
+	c.Policies.DenyBody.validate("policies.deny_body", bad)
+	c.Tenants.validate(bad)
+	validateGroups(c.Groups, bad)
+	if len(c.Groups) > 0 {
+		// A group's window and its keys' state would hash to different
+		// Cluster slots, which one script cannot touch.
+		if c.Backend.Kind == "redis" && c.Backend.Redis.HashTags {
+			bad("groups", "not supported with backend.redis.hash_tags")
+		}
+		for i, tenant := range c.Tenants.List {
+			if tenant.Backend.Kind == "redis" && tenant.Backend.Redis.HashTags {
+				bad("groups", fmt.Sprintf("not supported with tenants.list[%d].backend.redis.hash_tags", i))
+			}
+		}
+	}
+	validateLimitPolicies(c.LimitPolicies, bad)
+	if c.Usage.FlushIntervalMs <= 0 {
+		bad("usage.flush_interval_ms", "must be positive")
+	}
+	if c.Usage.RetentionDays <= 0 {
+		bad("usage.retention_days", "must be positive")
+	}
+	if c.Usage.KeyHistoryDays <= 0 {
+		bad("usage.key_history_days", "must be positive")
+	}
+	if c.Events.MaxKeys <= 0 {
+		bad("events.max_keys", "must be positive")
+	}
+	if c.Events.Format != "native" && c.Events.Format != "cloudevents" {
+		bad("events.format", "must be native or cloudevents")
+	}
+	if c.Metrics.Pushing() && c.Metrics.PushIntervalMs <= 0 {
+		bad("metrics.push_interval_ms", "must be positive")
+	}
+	if c.Metrics.KeyCountIntervalMs <= 0 {
+		bad("metrics.key_count_interval_ms", "must be positive")
+	}
+	if c.Metrics.Pushing() && c.Metrics.Job == "" {
+		bad("metrics.job", "must be set to push metrics")
+	}
+	if c.ExtAuthz.Enabled {
+		var check map[string]interface{}
+		if err := json.Unmarshal([]byte(c.ExtAuthz.Check), &check); err != nil {
+			bad("ext_authz.check", "must be a JSON object")
+		}
+	}
+	if c.Chaos.Enabled {
+		for _, r := range []struct {
+			field string
+			value float64
+		}{
+			{"chaos.latency_rate", c.Chaos.LatencyRate},
+			{"chaos.error_rate", c.Chaos.ErrorRate},
+			{"chaos.deny_rate", c.Chaos.DenyRate},
+		} {
+			if r.value < 0 || r.value > 1 {
+				bad(r.field, "must be between 0 and 1")
+			}
+		}
+		if c.Chaos.ErrorRate+c.Chaos.DenyRate > 1 {
+			bad("chaos", "error_rate and deny_rate must not add up to more than 1")
+		}
+		if c.Chaos.LatencyMs < 0 {
+			bad("chaos.latency_ms", "must not be negative")
+		}
+	}
+	c.Replication.validate(bad)
+	c.validateGossip(bad)
+	c.validateCluster(bad)
+	if c.Maintenance.IntervalMs < 0 {
+		bad("maintenance.interval_ms", "must not be negative")
+	}
+	if c.Maintenance.LeaseMs < 1000 {
+		bad("maintenance.lease_ms", "must be at least 1000")
+	}
+	if int64(c.Maintenance.OrphanTTLMs) < c.Policies.MaxWindowMs {
+		bad("maintenance.orphan_ttl_ms", "must be at least policies.max_window_ms")
+	}
+	return errors.Join(errs...)
+}
+
+func (b BackendConfig) validateCompare(bad func(field, problem string)) {
+	switch b.Compare.Kind {
+	case "", "memory":
+	case "redis":
+		redis := b.Compare.RedisConfig(b.Redis)
+		if b.Kind == "redis" && redis.Addr == b.Redis.Addr && redis.DB == b.Redis.DB && redis.KeyPrefix == b.Redis.KeyPrefix {
+			bad("backend.compare.redis", "must not share the backend's keys; set another db or key_prefix")
+		}
+		if redis.DB < 0 {
+			bad("backend.compare.redis.db", "must not be negative")
+		}
+	default:
+		bad("backend.compare.kind", fmt.Sprintf("%q is not memory or redis", b.Compare.Kind))
+	}
+	if b.Compare.TimeoutMs <= 0 {
+		bad("backend.compare.timeout_ms", "must be positive")
+	}
+	if b.Compare.MaxInFlight <= 0 {
+		bad("backend.compare.max_in_flight", "must be positive")
+	}
+	if b.Compare.Recent <= 0 {
+		bad("backend.compare.recent", "must be positive")
 	}
-	return parsed
 }
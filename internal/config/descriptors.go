@@ -0,0 +1,160 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DescriptorsConfig points at rate limit definitions written for
+// lyft/ratelimit: one YAML file, or a directory of them, each holding one
+// domain.
+type DescriptorsConfig struct {
+	Path string `yaml:"path"`
+}
+
+// DescriptorDomain is one lyft/ratelimit configuration file.
+type DescriptorDomain struct {
+	Domain      string       `yaml:"domain"`
+	Descriptors []Descriptor `yaml:"descriptors"`
+}
+
+// Descriptor matches one key of a check's descriptor and, unless Value is
+// empty, its value; a Value ending in '*' matches values starting with the
+// rest. The limit of the last matching descriptor applies.
+type Descriptor struct {
+	Key            string       `yaml:"key"`
+	Value          string       `yaml:"value"`
+	RateLimit      *RateLimit   `yaml:"rate_limit"`
+	ShadowMode     bool         `yaml:"shadow_mode"`
+	DetailedMetric bool         `yaml:"detailed_metric"`
+	Descriptors    []Descriptor `yaml:"descriptors"`
+}
+
+// RateLimit allows RequestsPerUnit requests per Unit, or any number when
+// Unlimited. Name and Replaces are accepted but have no effect: each check
+// carries a single descriptor.
+type RateLimit struct {
+	Unit            string `yaml:"unit"`
+	RequestsPerUnit int64  `yaml:"requests_per_unit"`
+	Unlimited       bool   `yaml:"unlimited"`
+	Name            string `yaml:"name"`
+	Replaces        []struct {
+		Name string `yaml:"name"`
+	} `yaml:"replaces"`
+}
+
+// unitMs holds the length of each lyft/ratelimit unit, with a month of
+// 30 days and a year of 365 as lyft/ratelimit counts them.
+var unitMs = map[string]int64{
+	"second": 1000,
+	"minute": 60000,
+	"hour":   3600000,
+	"day":    86400000,
+	"week":   604800000,
+	"month":  2592000000,
+	"year":   31536000000,
+}
+
+// WindowMs is the length of the limit's unit.
+func (l RateLimit) WindowMs() int64 {
+	return unitMs[strings.ToLower(l.Unit)]
+}
+
+// Load reads the domains under Path, in file name order for a directory.
+func (d DescriptorsConfig) Load() ([]DescriptorDomain, error) {
+	if d.Path == "" {
+		return nil, nil
+	}
+	info, err := os.Stat(d.Path)
+	if err != nil {
+		return nil, fmt.Errorf("descriptors: %w", err)
+	}
+	files := []string{d.Path}
+	if info.IsDir() {
+		entries, err := os.ReadDir(d.Path)
+		if err != nil {
+			return nil, fmt.Errorf("descriptors: %w", err)
+		}
+		files = files[:0]
+		for _, entry := range entries {
+			switch strings.ToLower(filepath.Ext(entry.Name())) {
+			case ".yaml", ".yml":
+				if !entry.IsDir() {
+					files = append(files, filepath.Join(d.Path, entry.Name()))
+				}
+			}
+		}
+		sort.Strings(files)
+	}
+
+	var domains []DescriptorDomain
+	seen := make(map[string]string, len(files))
+	for _, file := range files {
+		domain, err := loadDomain(file)
+		if err != nil {
+			return nil, err
+		}
+		if other, ok := seen[domain.Domain]; ok {
+			return nil, fmt.Errorf("descriptors file %s: domain %q is also in %s", file, domain.Domain, other)
+		}
+		seen[domain.Domain] = file
+		domains = append(domains, domain)
+	}
+	return domains, nil
+}
+
+func loadDomain(file string) (DescriptorDomain, error) {
+	var domain DescriptorDomain
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return domain, fmt.Errorf("descriptors: %w", err)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&domain); err != nil && !errors.Is(err, io.EOF) {
+		return domain, fmt.Errorf("descriptors file %s: %w", file, err)
+	}
+	var errs []error
+	bad := func(field, problem string) {
+		errs = append(errs, fmt.Errorf("descriptors file %s: %s: %s", file, field, problem))
+	}
+	if strings.TrimSpace(domain.Domain) == "" {
+		bad("domain", "required")
+	}
+	validateDescriptors("descriptors", domain.Descriptors, bad)
+	return domain, errors.Join(errs...)
+}
+
+func validateDescriptors(field string, descriptors []Descriptor, bad func(field, problem string)) {
+	seen := make(map[string]bool, len(descriptors))
+	for i, d := range descriptors {
+		at := fmt.Sprintf("%s[%d]", field, i)
+		if d.Key == "" {
+			bad(at+".key", "required")
+		}
+		if seen[d.Key+"\x00"+d.Value] {
+			bad(at, fmt.Sprintf("duplicate key %q and value %q", d.Key, d.Value))
+		}
+		seen[d.Key+"\x00"+d.Value] = true
+		if l := d.RateLimit; l != nil {
+			switch {
+			case l.Unlimited && (l.Unit != "" || l.RequestsPerUnit != 0):
+				bad(at+".rate_limit", "unlimited takes no unit or requests_per_unit")
+			case l.Unlimited:
+			case l.WindowMs() == 0:
+				bad(at+".rate_limit.unit", fmt.Sprintf("%q is not second, minute, hour, day, week, month or year", l.Unit))
+			case l.RequestsPerUnit <= 0:
+				bad(at+".rate_limit.requests_per_unit", "must be positive")
+			}
+		}
+		validateDescriptors(at+".descriptors", d.Descriptors, bad)
+	}
+}
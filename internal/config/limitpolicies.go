@@ -0,0 +1,75 @@
+package config
+
+import "fmt"
+
+// LimitPolicyConfig is a named set of limits /v2/limit/check requests
+// refer to instead of sending parameters: every check is charged to each
+// of Limits, all with Algorithm, and allowed only if all of them allow it.
+// A ShadowMode policy only reports its denials.
+type LimitPolicyConfig struct {
+	Name       string              `yaml:"name"`
+	Algorithm  string              `yaml:"algorithm"`
+	ShadowMode bool                `yaml:"shadow_mode"`
+	Limits     []PolicyLimitConfig `yaml:"limits"`
+}
+
+// PolicyLimitConfig is one of a policy's limits: Limit per WindowMs for
+// the window algorithms, Capacity and RefillPerSec or LeakPerSec for the
+// buckets.
+type PolicyLimitConfig struct {
+	Name         string  `yaml:"name"`
+	Limit        int64   `yaml:"limit"`
+	WindowMs     int64   `yaml:"window_ms"`
+	Capacity     int64   `yaml:"capacity"`
+	RefillPerSec float64 `yaml:"refill_per_sec"`
+	LeakPerSec   float64 `yaml:"leak_per_sec"`
+}
+
+// maxPolicyLimits bounds a policy's limits: its first is the check's key,
+// the rest are charged as up to eight dimensions of it.
+const maxPolicyLimits = 9
+
+func validateLimitPolicies(policies []LimitPolicyConfig, bad func(field, problem string)) {
+	seen := make(map[string]bool, len(policies))
+	for i, policy := range policies {
+		field := fmt.Sprintf("limit_policies[%d]", i)
+		if !tenantPattern.MatchString(policy.Name) {
+			bad(field+".name", fmt.Sprintf("policy %q must be 1-64 letters, digits, '_', '.' or '-'", policy.Name))
+		} else if seen[policy.Name] {
+			bad(field+".name", fmt.Sprintf("duplicate policy %q", policy.Name))
+		}
+		seen[policy.Name] = true
+		switch policy.Algorithm {
+		case "token_bucket", "leaky_bucket", "fixed_window", "sliding_window_log", "sliding_window_counter":
+		default:
+			bad(field+".algorithm", fmt.Sprintf("%q is not token_bucket, leaky_bucket, fixed_window, sliding_window_log or sliding_window_counter", policy.Algorithm))
+		}
+		if len(policy.Limits) == 0 || len(policy.Limits) > maxPolicyLimits {
+			bad(field+".limits", fmt.Sprintf("must hold 1-%d limits", maxPolicyLimits))
+		}
+		names := make(map[string]bool, len(policy.Limits))
+		for j, limit := range policy.Limits {
+			at := fmt.Sprintf("%s.limits[%d]", field, j)
+			if !tenantPattern.MatchString(limit.Name) {
+				bad(at+".name", fmt.Sprintf("limit %q must be 1-64 letters, digits, '_', '.' or '-'", limit.Name))
+			} else if names[limit.Name] {
+				bad(at+".name", fmt.Sprintf("duplicate limit %q", limit.Name))
+			}
+			names[limit.Name] = true
+			switch policy.Algorithm {
+			case "token_bucket":
+				if limit.Capacity <= 0 || limit.RefillPerSec <= 0 {
+					bad(at, "capacity and refill_per_sec must be positive")
+				}
+			case "leaky_bucket":
+				if limit.Capacity <= 0 || limit.LeakPerSec <= 0 {
+					bad(at, "capacity and leak_per_sec must be positive")
+				}
+			default:
+				if limit.Limit <= 0 || limit.WindowMs <= 0 {
+					bad(at, "limit and window_ms must be positive")
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ReplicationConfig shares consumption with limiters in other regions.
+// Each region enforces against its own backend and sends what it allowed to
+// every peer every SyncIntervalMs, so a key's global consumption may
+// overshoot its limit by what the other regions allow before their deltas
+// arrive.
+type ReplicationConfig struct {
+	// Region names this instance's region; every instance of a region
+	// shares its backend.
+	Region string `yaml:"region"`
+	// Peers lists the other regions as comma-separated region=url pairs,
+	// each URL reaching that region's limiters.
+	Peers          string `yaml:"peers"`
+	SyncIntervalMs int    `yaml:"sync_interval_ms"`
+	// MaxDeltaAgeMs drops deltas a peer has not taken within it.
+	MaxDeltaAgeMs int `yaml:"max_delta_age_ms"`
+	// APIKey authenticates this instance to its peers; it needs the
+	// operator role there.
+	APIKey string `yaml:"api_key"`
+}
+
+// NamedURL is one name=url entry of a list such as ReplicationConfig.Peers.
+type NamedURL struct {
+	Name string
+	URL  string
+}
+
+// PeerList returns the parsed Peers.
+func (r ReplicationConfig) PeerList() []NamedURL {
+	peers, _ := parseNamedURLs(r.Peers)
+	return peers
+}
+
+// parseNamedURLs reads comma-separated name=url pairs.
+func parseNamedURLs(s string) ([]NamedURL, error) {
+	var peers []NamedURL
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, rawURL, ok := strings.Cut(pair, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("%q is not name=url", pair)
+		}
+		if !isHTTPURL(rawURL) {
+			return nil, fmt.Errorf("%q is not an http(s) URL", rawURL)
+		}
+		peers = append(peers, NamedURL{Name: name, URL: strings.TrimRight(rawURL, "/")})
+	}
+	return peers, nil
+}
+
+func (r ReplicationConfig) validate(bad func(field, problem string)) {
+	peers, err := parseNamedURLs(r.Peers)
+	if err != nil {
+		bad("replication.peers", err.Error())
+	}
+	if len(peers) > 0 && r.Region == "" {
+		bad("replication.region", "required with replication.peers")
+	}
+	for _, peer := range peers {
+		if peer.Name == r.Region {
+			bad("replication.peers", fmt.Sprintf("%q is this instance's own region", peer.Name))
+		}
+	}
+	if r.SyncIntervalMs <= 0 {
+		bad("replication.sync_interval_ms", "must be positive")
+	}
+	if r.MaxDeltaAgeMs <= 0 {
+		bad("replication.max_delta_age_ms", "must be positive")
+	}
+}
+
+// GossipConfig lets instances on the memory backend share consumption
+// without Redis. It is on when AdvertiseURL is set. Instances find each
+// other from Seeds, then send what they allowed to every live member every
+// SyncIntervalMs, like regions do under ReplicationConfig.
+type GossipConfig struct {
+	// Name identifies the instance; defaults to the hostname.
+	Name string `yaml:"name"`
+	// AdvertiseURL is where the other members reach this instance.
+	AdvertiseURL string `yaml:"advertise_url"`
+	// Seeds are comma-separated URLs of members to join through.
+	Seeds      string `yaml:"seeds"`
+	IntervalMs int    `yaml:"interval_ms"`
+	Fanout     int    `yaml:"fanout"`
+	// DeadAfterMs drops members not heard from within it.
+	DeadAfterMs    int `yaml:"dead_after_ms"`
+	SyncIntervalMs int `yaml:"sync_interval_ms"`
+	// APIKey authenticates this instance to the others; it needs the
+	// operator role there.
+	APIKey string `yaml:"api_key"`
+}
+
+// Enabled reports whether the instance gossips.
+func (g GossipConfig) Enabled() bool {
+	return g.AdvertiseURL != ""
+}
+
+// SeedList returns the parsed Seeds.
+func (g GossipConfig) SeedList() []string {
+	seeds, _ := parseURLs(g.Seeds)
+	return seeds
+}
+
+func parseURLs(s string) ([]string, error) {
+	var urls []string
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !isHTTPURL(item) {
+			return nil, fmt.Errorf("%q is not an http(s) URL", item)
+		}
+		urls = append(urls, strings.TrimRight(item, "/"))
+	}
+	return urls, nil
+}
+
+func isHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != ""
+}
+
+func (c *Config) validateGossip(bad func(field, problem string)) {
+	g := c.Gossip
+	if _, err := parseURLs(g.Seeds); err != nil {
+		bad("gossip.seeds", err.Error())
+	}
+	if g.Seeds != "" && !g.Enabled() {
+		bad("gossip.advertise_url", "required with gossip.seeds")
+	}
+	if g.AdvertiseURL != "" && !isHTTPURL(g.AdvertiseURL) {
+		bad("gossip.advertise_url", fmt.Sprintf("%q is not an http(s) URL", g.AdvertiseURL))
+	}
+	if g.Enabled() {
+		if c.Backend.Kind != "memory" {
+			bad("gossip.advertise_url", "only for the memory backend; instances on redis already share state")
+		}
+		if c.Replication.Peers != "" {
+			bad("gossip.advertise_url", "cannot be combined with replication.peers")
+		}
+	}
+	if g.IntervalMs <= 0 {
+		bad("gossip.interval_ms", "must be positive")
+	}
+	if g.Fanout <= 0 {
+		bad("gossip.fanout", "must be positive")
+	}
+	if g.DeadAfterMs <= g.IntervalMs {
+		bad("gossip.dead_after_ms", "must exceed gossip.interval_ms")
+	}
+	if g.SyncIntervalMs <= 0 {
+		bad("gossip.sync_interval_ms", "must be positive")
+	}
+}
@@ -0,0 +1,196 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// vaultPrefix marks a secret setting whose value is read from Vault, as in
+// "vault:secret/data/limiter#redis_password".
+const vaultPrefix = "vault:"
+
+// SecretsConfig locates Vault for settings given as vault: references.
+type SecretsConfig struct {
+	VaultAddr      string `yaml:"vault_addr"`
+	VaultToken     string `yaml:"vault_token"`
+	VaultTokenFile string `yaml:"vault_token_file"`
+	// RefreshIntervalMs renews the token and re-reads every secret this
+	// often; 0 only re-reads them on reload.
+	RefreshIntervalMs int `yaml:"refresh_interval_ms"`
+}
+
+// resolveSecrets replaces file and Vault references in cfg with the
+// secrets they point to.
+func resolveSecrets(cfg *Config) error {
+	if path := cfg.Backend.Redis.PasswordFile; path != "" {
+		if cfg.Backend.Redis.Password != "" {
+			return errors.New("backend.redis: set password or password_file, not both")
+		}
+		password, err := readSecretFile(path)
+		if err != nil {
+			return fmt.Errorf("backend.redis.password_file: %w", err)
+		}
+		cfg.Backend.Redis.Password = password
+	}
+	if path := cfg.Secrets.VaultTokenFile; path != "" {
+		token, err := readSecretFile(path)
+		if err != nil {
+			return fmt.Errorf("secrets.vault_token_file: %w", err)
+		}
+		cfg.Secrets.VaultToken = token
+	}
+
+	type secret struct {
+		field string
+		value *string
+	}
+	secrets := []secret{
+		{"backend.redis.password", &cfg.Backend.Redis.Password},
+		{"auth.keys", &cfg.Auth.Keys},
+		{"key_hashing.secret", &cfg.KeyHashing.Secret},
+		{"key_hashing.previous_secret", &cfg.KeyHashing.PreviousSecret},
+		{"reconcile.secret", &cfg.Reconcile.Secret},
+		{"replication.api_key", &cfg.Replication.APIKey},
+		{"gossip.api_key", &cfg.Gossip.APIKey},
+		{"cluster.api_key", &cfg.Cluster.APIKey},
+	}
+	type redisSettings struct {
+		field string
+		redis *RedisConfig
+	}
+	redises := []redisSettings{{"backend.compare.redis", &cfg.Backend.Compare.Redis}}
+	for i := range cfg.Tenants.List {
+		redises = append(redises, redisSettings{fmt.Sprintf("tenants.list[%d].backend.redis", i), &cfg.Tenants.List[i].Backend.Redis})
+	}
+	for _, r := range redises {
+		field, redis := r.field, r.redis
+		if redis.PasswordFile != "" {
+			if redis.Password != "" {
+				return fmt.Errorf("%s: set password or password_file, not both", field)
+			}
+			password, err := readSecretFile(redis.PasswordFile)
+			if err != nil {
+				return fmt.Errorf("%s.password_file: %w", field, err)
+			}
+			redis.Password = password
+		}
+		secrets = append(secrets, secret{field + ".password", &redis.Password})
+	}
+
+	vault := &vaultClient{addr: cfg.Secrets.VaultAddr, token: cfg.Secrets.VaultToken}
+	for _, s := range secrets {
+		ref, ok := strings.CutPrefix(*s.value, vaultPrefix)
+		if !ok {
+			continue
+		}
+		secret, err := vault.secret(ref)
+		if err != nil {
+			return fmt.Errorf("%s: %w", s.field, err)
+		}
+		*s.value = secret
+	}
+	return nil
+}
+
+// readSecretFile reads a secret, dropping the trailing newline editors and
+// secret mounts tend to leave.
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+type vaultClient struct {
+	addr  string
+	token string
+	// read caches whole secrets so that fields of one path cost one request.
+	read map[string]map[string]interface{}
+}
+
+// secret reads "path#field" from a KV secrets engine, version 1 or 2.
+func (v *vaultClient) secret(ref string) (string, error) {
+	path, field, ok := strings.Cut(ref, "#")
+	if !ok || path == "" || field == "" {
+		return "", fmt.Errorf("vault reference %q: want path#field", ref)
+	}
+	if v.addr == "" || v.token == "" {
+		return "", errors.New("vault reference needs secrets.vault_addr and a token")
+	}
+	data, ok := v.read[path]
+	if !ok {
+		var err error
+		if data, err = v.readPath(path); err != nil {
+			return "", err
+		}
+		if v.read == nil {
+			v.read = make(map[string]map[string]interface{})
+		}
+		v.read[path] = data
+	}
+	value, ok := data[field].(string)
+	if !ok {
+		return "", fmt.Errorf("vault %s: no string field %q", path, field)
+	}
+	return value, nil
+}
+
+func (v *vaultClient) readPath(path string) (map[string]interface{}, error) {
+	var body struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := vaultRequest(http.MethodGet, v.addr, "/v1/"+strings.TrimPrefix(path, "/"), v.token, &body); err != nil {
+		return nil, err
+	}
+	// KV version 2 nests the secret under data.data next to its metadata.
+	if inner, ok := body.Data["data"].(map[string]interface{}); ok {
+		if _, versioned := body.Data["metadata"]; versioned {
+			return inner, nil
+		}
+	}
+	return body.Data, nil
+}
+
+// RenewVaultToken extends the lease of the Vault token in a loaded
+// configuration so that periodic secret refreshes keep working.
+func (s SecretsConfig) RenewVaultToken() error {
+	return vaultRequest(http.MethodPost, s.VaultAddr, "/v1/auth/token/renew-self", s.VaultToken, nil)
+}
+
+func vaultRequest(method, addr, path, token string, out interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var body io.Reader
+	if method == http.MethodPost {
+		body = bytes.NewReader([]byte("{}"))
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(addr, "/")+path, body)
+	if err != nil {
+		return fmt.Errorf("vault: %w", err)
+	}
+	req.Header.Set("X-Vault-Token", token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("vault: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("vault %s: status %d", path, resp.StatusCode)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out); err != nil {
+		return fmt.Errorf("vault %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,306 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// setting ties one Config field to its environment variable and flag.
+type setting struct {
+	env   string
+	flag  string
+	usage string
+	field func(c *Config) interface{}
+}
+
+var settings = []setting{
+	{"PORT", "port", "listen port", func(c *Config) interface{} { return &c.Server.Port }},
+	{"BATCH_MAX_ITEMS", "batch-max-items", "maximum items per batch check", func(c *Config) interface{} { return &c.Server.BatchMaxItems }},
+	{"BATCH_CONCURRENCY", "batch-concurrency", "items evaluated in parallel per batch", func(c *Config) interface{} { return &c.Server.BatchConcurrency }},
+	{"MAX_BODY_BYTES", "max-body-bytes", "largest accepted request body", func(c *Config) interface{} { return &c.Server.MaxBodyBytes }},
+	{"TOP_KEYS", "top-keys", "count checks by key to list this many of the busiest at /v1/admin/keys/top (0 disables)", func(c *Config) interface{} { return &c.Server.TopKeys }},
+	{"STRICT_REQUESTS", "strict-requests", "refuse checks with unknown fields or mistyped values", func(c *Config) interface{} { return &c.Server.StrictRequests }},
+	{"RATE_LIMIT_HEADERS", "rate-limit-headers", "send a check's decision in response headers", func(c *Config) interface{} { return &c.Server.Headers.Enabled }},
+	{"RATE_LIMIT_HEADER_PREFIX", "rate-limit-header-prefix", "prefix of the decision headers' names", func(c *Config) interface{} { return &c.Server.Headers.Prefix }},
+	{"ERROR_FORMAT", "error-format", "json, or problem to answer every error as RFC 9457 problem details", func(c *Config) interface{} { return &c.Server.Errors.Format }},
+	{"ERROR_TYPE_BASE_URL", "error-type-base-url", "problem details' type, followed by the error code (default: about:blank)", func(c *Config) interface{} { return &c.Server.Errors.TypeBaseURL }},
+	{"READ_HEADER_TIMEOUT_MS", "read-header-timeout-ms", "time to read request headers in ms (0 disables)", func(c *Config) interface{} { return &c.Server.HTTP.ReadHeaderTimeoutMs }},
+	{"READ_TIMEOUT_MS", "read-timeout-ms", "time to read a whole request in ms (0 disables)", func(c *Config) interface{} { return &c.Server.HTTP.ReadTimeoutMs }},
+	{"WRITE_TIMEOUT_MS", "write-timeout-ms", "time to write a response in ms (0 disables)", func(c *Config) interface{} { return &c.Server.HTTP.WriteTimeoutMs }},
+	{"IDLE_TIMEOUT_MS", "idle-timeout-ms", "keep-alive idle timeout in ms (0 disables)", func(c *Config) interface{} { return &c.Server.HTTP.IdleTimeoutMs }},
+	{"MAX_HEADER_BYTES", "max-header-bytes", "largest accepted request header block", func(c *Config) interface{} { return &c.Server.HTTP.MaxHeaderBytes }},
+	{"SHUTDOWN_GRACE_MS", "shutdown-grace-ms", "time in-flight requests get to finish on shutdown in ms", func(c *Config) interface{} { return &c.Server.HTTP.ShutdownGraceMs }},
+	{"TLS_CERT_FILE", "tls-cert-file", "PEM certificate chain; enables HTTPS with -tls-key-file", func(c *Config) interface{} { return &c.Server.TLS.CertFile }},
+	{"TLS_KEY_FILE", "tls-key-file", "PEM private key for -tls-cert-file", func(c *Config) interface{} { return &c.Server.TLS.KeyFile }},
+	{"TLS_RELOAD_INTERVAL_MS", "tls-reload-interval-ms", "check the certificate files for rotation this often in ms (0 disables)", func(c *Config) interface{} { return &c.Server.TLS.ReloadIntervalMs }},
+	{"TLS_CLIENT_CA_FILE", "tls-client-ca-file", "PEM CAs that client certificates must chain to; enables mTLS", func(c *Config) interface{} { return &c.Server.TLS.ClientCAFile }},
+	{"TLS_CLIENT_AUTH", "tls-client-auth", "client certificates with -tls-client-ca-file (require|optional)", func(c *Config) interface{} { return &c.Server.TLS.ClientAuth }},
+
+	{"API_KEYS", "api-keys", "comma-separated key:role pairs (role check, viewer, operator or admin); enables authentication", func(c *Config) interface{} { return &c.Auth.Keys }},
+	{"API_KEYS_FILE", "api-keys-file", "file of \"key role\" lines; enables authentication", func(c *Config) interface{} { return &c.Auth.KeysFile }},
+	{"API_KEY_HEADER", "api-key-header", "header carrying the API key", func(c *Config) interface{} { return &c.Auth.Header }},
+	{"CLIENT_CERT_ROLES", "client-cert-roles", "comma-separated san:role pairs for verified client certificates", func(c *Config) interface{} { return &c.Auth.ClientCertRoles }},
+	{"SIGNING_KEYS_FILE", "signing-keys-file", "file of \"id secret role\" lines for HMAC-signed requests", func(c *Config) interface{} { return &c.Auth.SigningKeysFile }},
+	{"SIGNATURE_MAX_SKEW_MS", "signature-max-skew-ms", "largest accepted clock difference for signed requests in ms", func(c *Config) interface{} { return &c.Auth.SignatureMaxSkewMs }},
+	{"ADMIN_ALLOW_CIDRS", "admin-allow-cidrs", "comma-separated networks allowed to reach /v1/admin/* (empty allows all)", func(c *Config) interface{} { return &c.Auth.AdminAllowCIDRs }},
+
+	{"JWT_JWKS_URL", "jwt-jwks-url", "JWKS endpoint; enables signature checks for JWT keys", func(c *Config) interface{} { return &c.JWT.JWKSURL }},
+	{"JWT_JWKS_FILE", "jwt-jwks-file", "JWKS file; enables signature checks for JWT keys", func(c *Config) interface{} { return &c.JWT.JWKSFile }},
+	{"JWT_JWKS_REFRESH_MS", "jwt-jwks-refresh-ms", "how often the key set is fetched again in ms", func(c *Config) interface{} { return &c.JWT.RefreshIntervalMs }},
+	{"JWT_ISSUER", "jwt-issuer", "required iss claim", func(c *Config) interface{} { return &c.JWT.Issuer }},
+	{"JWT_AUDIENCE", "jwt-audience", "required aud claim", func(c *Config) interface{} { return &c.JWT.Audience }},
+	{"JWT_LEEWAY_MS", "jwt-leeway-ms", "clock skew allowed for exp and nbf in ms", func(c *Config) interface{} { return &c.JWT.LeewayMs }},
+
+	{"CALLER_CHECK_PER_SEC", "caller-check-per-sec", "check requests per second allowed per caller (0 disables)", func(c *Config) interface{} { return &c.CallerLimits.CheckPerSec }},
+	{"CALLER_CHECK_BURST", "caller-check-burst", "check request burst per caller (0 uses the rate)", func(c *Config) interface{} { return &c.CallerLimits.CheckBurst }},
+	{"CALLER_ADMIN_PER_SEC", "caller-admin-per-sec", "admin requests per second allowed per caller (0 disables)", func(c *Config) interface{} { return &c.CallerLimits.AdminPerSec }},
+	{"CALLER_ADMIN_BURST", "caller-admin-burst", "admin request burst per caller (0 uses the rate)", func(c *Config) interface{} { return &c.CallerLimits.AdminBurst }},
+
+	{"VAULT_ADDR", "vault-addr", "Vault address for vault: secret references", func(c *Config) interface{} { return &c.Secrets.VaultAddr }},
+	{"VAULT_TOKEN", "vault-token", "Vault token (visible in the process list; prefer the environment)", func(c *Config) interface{} { return &c.Secrets.VaultToken }},
+	{"VAULT_TOKEN_FILE", "vault-token-file", "file holding the Vault token", func(c *Config) interface{} { return &c.Secrets.VaultTokenFile }},
+	{"VAULT_REFRESH_MS", "vault-refresh-ms", "renew the Vault token and re-read secrets this often in ms (0 only on reload)", func(c *Config) interface{} { return &c.Secrets.RefreshIntervalMs }},
+
+	{"KEY_HASH_SECRET", "key-hash-secret", "HMAC user and device ids with this secret before they reach the backend", func(c *Config) interface{} { return &c.KeyHashing.Secret }},
+	{"KEY_HASH_PREVIOUS_SECRET", "key-hash-previous-secret", "secret being rotated out; its keys keep counting for the grace window", func(c *Config) interface{} { return &c.KeyHashing.PreviousSecret }},
+	{"KEY_HASH_GRACE_MS", "key-hash-grace-ms", "how long keys under the previous secret keep counting after a rotation", func(c *Config) interface{} { return &c.KeyHashing.GraceMs }},
+	{"RECONCILE_SECRET", "reconcile-secret", "sign decision IDs of reconcilable checks with this secret, shared by instances", func(c *Config) interface{} { return &c.Reconcile.Secret }},
+	{"RECONCILE_TTL_MS", "reconcile-ttl-ms", "how long a reconcilable check's decision ID can be reconciled", func(c *Config) interface{} { return &c.Reconcile.TTLMs }},
+
+	{"TENANTS_REQUIRE", "tenants-require", "reject checks that name no tenant", func(c *Config) interface{} { return &c.Tenants.Require }},
+	{"TENANTS_KNOWN_ONLY", "tenants-known-only", "reject tenants not listed under tenants.list in the config file", func(c *Config) interface{} { return &c.Tenants.KnownOnly }},
+	{"USAGE_FLUSH_INTERVAL_MS", "usage-flush-interval-ms", "how often tenant usage counted in memory is added to the backend", func(c *Config) interface{} { return &c.Usage.FlushIntervalMs }},
+	{"USAGE_RETENTION_DAYS", "usage-retention-days", "how long tenant usage is kept", func(c *Config) interface{} { return &c.Usage.RetentionDays }},
+	{"USAGE_KEY_HISTORY", "usage-key-history", "keep hourly and daily usage per key", func(c *Config) interface{} { return &c.Usage.KeyHistory }},
+	{"USAGE_KEY_HISTORY_DAYS", "usage-key-history-days", "how long per-key usage is kept", func(c *Config) interface{} { return &c.Usage.KeyHistoryDays }},
+	{"KEY_EVENTS", "key-events", "publish key lifecycle events at /v1/admin/events", func(c *Config) interface{} { return &c.Events.Enabled }},
+	{"KEY_EVENTS_MAX_KEYS", "key-events-max-keys", "keys each instance follows for lifecycle events", func(c *Config) interface{} { return &c.Events.MaxKeys }},
+	{"KEY_EVENTS_FORMAT", "key-events-format", "key event format: native or cloudevents", func(c *Config) interface{} { return &c.Events.Format }},
+	{"KEY_EVENTS_CLOUDEVENTS_SOURCE", "key-events-cloudevents-source", "CloudEvents source of key events; defaults to one naming the host", func(c *Config) interface{} { return &c.Events.CloudEventsSource }},
+	{"KEY_EVENTS_CLOUDEVENTS_TYPE_PREFIX", "key-events-cloudevents-type-prefix", "prefix of the CloudEvents type of key events", func(c *Config) interface{} { return &c.Events.CloudEventsTypePrefix }},
+	{"METRICS", "metrics", "serve Prometheus metrics at /metrics", func(c *Config) interface{} { return &c.Metrics.Enabled }},
+	{"METRICS_PUSHGATEWAY_URL", "metrics-pushgateway-url", "Pushgateway to push metrics to", func(c *Config) interface{} { return &c.Metrics.PushgatewayURL }},
+	{"METRICS_REMOTE_WRITE_URL", "metrics-remote-write-url", "Prometheus remote-write URL to push metrics to", func(c *Config) interface{} { return &c.Metrics.RemoteWriteURL }},
+	{"METRICS_PUSH_INTERVAL_MS", "metrics-push-interval-ms", "how often metrics are pushed", func(c *Config) interface{} { return &c.Metrics.PushIntervalMs }},
+	{"METRICS_JOB", "metrics-job", "job metrics are pushed as", func(c *Config) interface{} { return &c.Metrics.Job }},
+	{"METRICS_INSTANCE", "metrics-instance", "instance metrics are pushed as; defaults to the host name", func(c *Config) interface{} { return &c.Metrics.Instance }},
+	{"METRICS_KEY_COUNT_INTERVAL_MS", "metrics-key-count-interval-ms", "how often the tracked key counts in the metrics are counted", func(c *Config) interface{} { return &c.Metrics.KeyCountIntervalMs }},
+	{"EXT_AUTHZ", "ext-authz", "serve Envoy's ext_authz gRPC API", func(c *Config) interface{} { return &c.ExtAuthz.Enabled }},
+	{"EXT_AUTHZ_KEY", "ext-authz-key", "key template for ext_authz checks, e.g. {header.x-user-id}", func(c *Config) interface{} { return &c.ExtAuthz.Key }},
+	{"EXT_AUTHZ_CHECK", "ext-authz-check", "the other check fields of ext_authz checks, as JSON", func(c *Config) interface{} { return &c.ExtAuthz.Check }},
+	{"CHAOS", "chaos", "inject faults into checks, for testing clients; never in production", func(c *Config) interface{} { return &c.Chaos.Enabled }},
+	{"CHAOS_LATENCY_MS", "chaos-latency-ms", "latency injected into checks in ms", func(c *Config) interface{} { return &c.Chaos.LatencyMs }},
+	{"CHAOS_LATENCY_RATE", "chaos-latency-rate", "fraction of checks latency is injected into", func(c *Config) interface{} { return &c.Chaos.LatencyRate }},
+	{"CHAOS_ERROR_RATE", "chaos-error-rate", "fraction of checks failed with a backend error", func(c *Config) interface{} { return &c.Chaos.ErrorRate }},
+	{"CHAOS_DENY_RATE", "chaos-deny-rate", "fraction of checks denied", func(c *Config) interface{} { return &c.Chaos.DenyRate }},
+	{"GRAPHQL", "graphql", "serve GraphQL queries over the admin data at /v1/admin/graphql", func(c *Config) interface{} { return &c.GraphQL.Enabled }},
+
+	{"REPLICATION_REGION", "replication-region", "this instance's region for multi-region limiting", func(c *Config) interface{} { return &c.Replication.Region }},
+	{"REPLICATION_PEERS", "replication-peers", "comma-separated region=url pairs of the other regions' limiters", func(c *Config) interface{} { return &c.Replication.Peers }},
+	{"REPLICATION_SYNC_INTERVAL_MS", "replication-sync-interval-ms", "how often consumption is sent to the other regions in ms", func(c *Config) interface{} { return &c.Replication.SyncIntervalMs }},
+	{"REPLICATION_MAX_DELTA_AGE_MS", "replication-max-delta-age-ms", "drop consumption a region has not taken within this many ms", func(c *Config) interface{} { return &c.Replication.MaxDeltaAgeMs }},
+	{"REPLICATION_API_KEY", "replication-api-key", "API key sent to the other regions (visible in the process list; prefer the environment)", func(c *Config) interface{} { return &c.Replication.APIKey }},
+
+	{"GOSSIP_NAME", "gossip-name", "this instance's gossip member name (default: hostname)", func(c *Config) interface{} { return &c.Gossip.Name }},
+	{"GOSSIP_ADVERTISE_URL", "gossip-advertise-url", "URL other instances reach this one at; enables gossip on the memory backend", func(c *Config) interface{} { return &c.Gossip.AdvertiseURL }},
+	{"GOSSIP_SEEDS", "gossip-seeds", "comma-separated URLs of instances to join through", func(c *Config) interface{} { return &c.Gossip.Seeds }},
+	{"GOSSIP_INTERVAL_MS", "gossip-interval-ms", "how often membership is swapped with other instances in ms", func(c *Config) interface{} { return &c.Gossip.IntervalMs }},
+	{"GOSSIP_FANOUT", "gossip-fanout", "instances contacted per membership round", func(c *Config) interface{} { return &c.Gossip.Fanout }},
+	{"GOSSIP_DEAD_AFTER_MS", "gossip-dead-after-ms", "drop instances not heard from within this many ms", func(c *Config) interface{} { return &c.Gossip.DeadAfterMs }},
+	{"GOSSIP_SYNC_INTERVAL_MS", "gossip-sync-interval-ms", "how often consumption is sent to the other instances in ms", func(c *Config) interface{} { return &c.Gossip.SyncIntervalMs }},
+	{"GOSSIP_API_KEY", "gossip-api-key", "API key sent to the other instances (visible in the process list; prefer the environment)", func(c *Config) interface{} { return &c.Gossip.APIKey }},
+
+	{"CLUSTER_SELF", "cluster-self", "this instance's name among -cluster-members", func(c *Config) interface{} { return &c.Cluster.Self }},
+	{"CLUSTER_MEMBERS", "cluster-members", "comma-separated name=url pairs of every instance; spreads keys over them on the memory backend", func(c *Config) interface{} { return &c.Cluster.Members }},
+	{"CLUSTER_MODE", "cluster-mode", "how checks on keys owned elsewhere are handled (forward|redirect)", func(c *Config) interface{} { return &c.Cluster.Mode }},
+	{"CLUSTER_VIRTUAL_NODES", "cluster-virtual-nodes", "ring points per member; more spread keys more evenly", func(c *Config) interface{} { return &c.Cluster.VirtualNodes }},
+	{"CLUSTER_API_KEY", "cluster-api-key", "API key sent to the other members (visible in the process list; prefer the environment)", func(c *Config) interface{} { return &c.Cluster.APIKey }},
+
+	{"MAINTENANCE_INTERVAL_MS", "maintenance-interval-ms", "how often the elected instance runs background jobs on Redis in ms (0 disables)", func(c *Config) interface{} { return &c.Maintenance.IntervalMs }},
+	{"MAINTENANCE_LEASE_MS", "maintenance-lease-ms", "how long the maintenance lead lasts without renewal in ms", func(c *Config) interface{} { return &c.Maintenance.LeaseMs }},
+	{"MAINTENANCE_ORPHAN_TTL_MS", "maintenance-orphan-ttl-ms", "expiry given to limiter keys found without one in ms", func(c *Config) interface{} { return &c.Maintenance.OrphanTTLMs }},
+
+	{"AUDIT_LOG_FILE", "audit-log-file", "append admin actions to this JSON-lines file (empty keeps them in memory)", func(c *Config) interface{} { return &c.Audit.File }},
+	{"AUDIT_MEMORY_ENTRIES", "audit-memory-entries", "admin actions kept in memory without an audit log file", func(c *Config) interface{} { return &c.Audit.MemoryEntries }},
+
+	{"BACKEND", "backend", "backend (memory|redis)", func(c *Config) interface{} { return &c.Backend.Kind }},
+	{"BACKEND_TIMEOUT_MS", "backend-timeout-ms", "time budget per backend call in ms (0 disables)", func(c *Config) interface{} { return &c.Backend.TimeoutMs }},
+	{"FAIL_MODE", "fail-mode", "decision when the backend fails (error|open|closed)", func(c *Config) interface{} { return &c.Backend.FailMode }},
+	{"BACKEND_SHADOW_TTL_MS", "backend-shadow-ttl-ms", "while the backend fails, decide from key state it reported up to this long ago in ms (0 disables)", func(c *Config) interface{} { return &c.Backend.ShadowTTLMs }},
+	{"BACKEND_SHADOW_MAX_KEYS", "backend-shadow-max-keys", "keys whose state is kept for backend outages", func(c *Config) interface{} { return &c.Backend.ShadowMaxKeys }},
+	{"BACKEND_WARM_START_KEYS", "backend-warm-start-keys", "preload the shadow and new key filter with this many of the busiest keys at startup (0 disables)", func(c *Config) interface{} { return &c.Backend.WarmStart.Keys }},
+	{"BACKEND_WARM_START_FILE", "backend-warm-start-file", "file the busiest keys are written to at shutdown and read from at startup (default: found in the backend's state)", func(c *Config) interface{} { return &c.Backend.WarmStart.File }},
+	{"BACKEND_NEW_KEY_FILTER_KEYS", "backend-new-key-filter-keys", "allow checks on keys not among about this many recent ones without waiting for the backend (0 disables)", func(c *Config) interface{} { return &c.Backend.NewKeyFilterKeys }},
+	{"REDIS_ADDR", "redis-addr", "redis address; comma-separated seeds enable cluster mode", func(c *Config) interface{} { return &c.Backend.Redis.Addr }},
+	{"REDIS_REPLICA_ADDRS", "redis-replica-addrs", "comma-separated replicas that serve peeks and usage reports", func(c *Config) interface{} { return &c.Backend.Redis.ReplicaAddrs }},
+	{"REDIS_PASSWORD", "redis-password", "redis password (visible in the process list; prefer the environment)", func(c *Config) interface{} { return &c.Backend.Redis.Password }},
+	{"REDIS_PASSWORD_FILE", "redis-password-file", "file holding the redis password", func(c *Config) interface{} { return &c.Backend.Redis.PasswordFile }},
+	{"REDIS_DB", "redis-db", "redis database", func(c *Config) interface{} { return &c.Backend.Redis.DB }},
+	{"REDIS_KEY_PREFIX", "redis-key-prefix", "prefix for every redis key", func(c *Config) interface{} { return &c.Backend.Redis.KeyPrefix }},
+	{"REDIS_HASH_TAGS", "redis-hash-tags", "wrap keys in {} so related keys share a cluster slot", func(c *Config) interface{} { return &c.Backend.Redis.HashTags }},
+	{"REDIS_SERVER_TIME", "redis-server-time", "take timestamps from the redis server clock", func(c *Config) interface{} { return &c.Backend.Redis.ServerTime }},
+	{"COMPARE_BACKEND", "compare-backend", "candidate backend (memory|redis) every check is also evaluated on, for comparison; empty disables", func(c *Config) interface{} { return &c.Backend.Compare.Kind }},
+	{"COMPARE_REDIS_ADDR", "compare-redis-addr", "candidate redis address (default: the backend's)", func(c *Config) interface{} { return &c.Backend.Compare.Redis.Addr }},
+	{"COMPARE_REDIS_DB", "compare-redis-db", "candidate redis database", func(c *Config) interface{} { return &c.Backend.Compare.Redis.DB }},
+	{"COMPARE_REDIS_KEY_PREFIX", "compare-redis-key-prefix", "prefix for every candidate redis key", func(c *Config) interface{} { return &c.Backend.Compare.Redis.KeyPrefix }},
+	{"COMPARE_TIMEOUT_MS", "compare-timeout-ms", "time budget per candidate check in ms", func(c *Config) interface{} { return &c.Backend.Compare.TimeoutMs }},
+	{"COMPARE_MAX_IN_FLIGHT", "compare-max-in-flight", "candidate checks run at once; more are skipped", func(c *Config) interface{} { return &c.Backend.Compare.MaxInFlight }},
+	{"MEMORY_MAX_BYTES", "memory-max-bytes", "estimated size the memory backend's key states may grow to (0 for no limit)", func(c *Config) interface{} { return &c.Backend.Memory.MaxBytes }},
+	{"SLIDING_LOG_MAX_ENTRIES", "sliding-log-max-entries", "entries a memory sliding log key may hold", func(c *Config) interface{} { return &c.Backend.Memory.SlidingLogMaxEntries }},
+	{"SKETCH_EPSILON", "sketch-epsilon", "count_min_sketch overcount bound, as a fraction of the window's total cost", func(c *Config) interface{} { return &c.Backend.Sketch.Epsilon }},
+	{"SKETCH_DELTA", "sketch-delta", "probability a count_min_sketch count exceeds its bound", func(c *Config) interface{} { return &c.Backend.Sketch.Delta }},
+	{"STATE_TTL_PADDING_MS", "state-ttl-padding-ms", "how long idle state is kept past the time its algorithm needs it", func(c *Config) interface{} { return &c.Backend.StateTTL.PaddingMs }},
+	{"STATE_TTL_MAX_MS", "state-ttl-max-ms", "cap on how long idle state is kept (0 for none)", func(c *Config) interface{} { return &c.Backend.StateTTL.MaxMs }},
+
+	{"MAX_COST", "max-cost", "largest accepted cost", func(c *Config) interface{} { return &c.Policies.MaxCost }},
+	{"MAX_CAPACITY", "max-capacity", "largest accepted capacity", func(c *Config) interface{} { return &c.Policies.MaxCapacity }},
+	{"MAX_LIMIT", "max-limit", "largest accepted limit", func(c *Config) interface{} { return &c.Policies.MaxLimit }},
+	{"MAX_WINDOW_MS", "max-window-ms", "largest accepted window_ms", func(c *Config) interface{} { return &c.Policies.MaxWindowMs }},
+	{"MAX_LOG_LIMIT", "max-log-limit", "largest accepted sliding_window_log limit (0: MAX_LIMIT)", func(c *Config) interface{} { return &c.Policies.MaxLogLimit }},
+	{"GRACE_REQUESTS", "grace-requests", "cost a key may go on for after first exhausting its limit in a grace period (0 disables)", func(c *Config) interface{} { return &c.Policies.Grace.Requests }},
+	{"GRACE_DURATION_MS", "grace-duration-ms", "how long a key may go on after first exhausting its limit in a grace period in ms (0 disables)", func(c *Config) interface{} { return &c.Policies.Grace.DurationMs }},
+	{"GRACE_PERIOD_MS", "grace-period-ms", "how often a key may get grace in ms", func(c *Config) interface{} { return &c.Policies.Grace.PeriodMs }},
+	{"HOOK_URL", "hook-url", "decision service (such as an OPA rule) consulted before each check", func(c *Config) interface{} { return &c.Hook.URL }},
+	{"HOOK_TIMEOUT_MS", "hook-timeout-ms", "how long a decision hook call may take in ms", func(c *Config) interface{} { return &c.Hook.TimeoutMs }},
+	{"HOOK_FAIL_MODE", "hook-fail-mode", "when the decision hook fails: error or skip (evaluate the check as sent)", func(c *Config) interface{} { return &c.Hook.FailMode }},
+	{"DESCRIPTORS_PATH", "descriptors-path", "lyft/ratelimit configuration file, or directory of them, defining limits checks may name by domain", func(c *Config) interface{} { return &c.Descriptors.Path }},
+	{"RETRY_JITTER", "retry-jitter", "add up to this fraction of retry_after_ms to denials at random (0 disables)", func(c *Config) interface{} { return &c.Policies.RetryJitter }},
+	{"DENY_MESSAGE", "deny-message", "message added to denied checks' bodies, with placeholders such as {retry_after_s}", func(c *Config) interface{} { return &c.Policies.DenyBody.Message }},
+	{"DENY_DOCUMENTATION_URL", "deny-documentation-url", "documentation link added to denied checks' bodies", func(c *Config) interface{} { return &c.Policies.DenyBody.DocumentationURL }},
+	{"DENY_UPGRADE_URL", "deny-upgrade-url", "upgrade link added to denied checks' bodies", func(c *Config) interface{} { return &c.Policies.DenyBody.UpgradeURL }},
+}
+
+// assign parses value into the field dst points at.
+func assign(dst interface{}, value string) error {
+	switch p := dst.(type) {
+	case *string:
+		*p = value
+	case *int:
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		*p = parsed
+	case *int64:
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		*p = parsed
+	case *float64:
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		*p = parsed
+	case *bool:
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", value)
+		}
+		*p = parsed
+	default:
+		return fmt.Errorf("unsupported setting type %T", dst)
+	}
+	return nil
+}
+
+func format(field interface{}) string {
+	switch p := field.(type) {
+	case *string:
+		return *p
+	case *int:
+		return strconv.Itoa(*p)
+	case *int64:
+		return strconv.FormatInt(*p, 10)
+	case *float64:
+		return strconv.FormatFloat(*p, 'g', -1, 64)
+	case *bool:
+		return strconv.FormatBool(*p)
+	default:
+		return ""
+	}
+}
+
+// Loader loads the configuration with command-line flags layered on top.
+// The zero Loader has no flags.
+type Loader struct {
+	configPath string
+	flags      map[string]*flagValue
+}
+
+// NewLoader registers -config and one flag per setting on fs.
+func NewLoader(fs *flag.FlagSet) *Loader {
+	l := &Loader{flags: make(map[string]*flagValue)}
+	fs.StringVar(&l.configPath, "config", "", "YAML configuration file (overrides CONFIG_FILE)")
+	defaults := Default()
+	for _, s := range settings {
+		field := s.field(&defaults)
+		_, isBool := field.(*bool)
+		v := &flagValue{value: format(field), isBool: isBool}
+		fs.Var(v, s.flag, s.usage)
+		l.flags[s.flag] = v
+	}
+	return l
+}
+
+func (l *Loader) Load() (Config, error) {
+	cfg := Default()
+	path := l.configPath
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	if path != "" {
+		if err := loadFile(path, &cfg); err != nil {
+			return Config{}, err
+		}
+	}
+
+	var errs []error
+	for _, s := range settings {
+		if value := os.Getenv(s.env); value != "" {
+			if err := assign(s.field(&cfg), value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", s.env, err))
+			}
+		}
+	}
+	for _, s := range settings {
+		if v := l.flags[s.flag]; v != nil && v.set {
+			if err := assign(s.field(&cfg), v.value); err != nil {
+				errs = append(errs, fmt.Errorf("-%s: %w", s.flag, err))
+			}
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return Config{}, err
+	}
+	if err := resolveSecrets(&cfg); err != nil {
+		return Config{}, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// flagValue remembers whether a flag was given so that only explicit flags
+// override the file and environment.
+type flagValue struct {
+	value  string
+	set    bool
+	isBool bool
+}
+
+func (v *flagValue) String() string { return v.value }
+
+func (v *flagValue) Set(value string) error {
+	v.value = value
+	v.set = true
+	return nil
+}
+
+func (v *flagValue) IsBoolFlag() bool { return v.isBool }
@@ -0,0 +1,200 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// tenantPattern keeps tenant ids safe to embed in backend keys.
+var tenantPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// TenantsConfig controls the tenant field of checks. Credentials bound to a
+// tenant (a role written role@tenant) may only act within it.
+type TenantsConfig struct {
+	// Require rejects checks that name no tenant.
+	Require bool `yaml:"require"`
+	// KnownOnly rejects tenants missing from List.
+	KnownOnly bool `yaml:"known_only"`
+	// List holds per-tenant settings.
+	List []TenantConfig `yaml:"list"`
+}
+
+// UsageConfig controls tenant usage reporting. Instances count decisions
+// in memory and add them to the backend every FlushIntervalMs. KeyHistory
+// also keeps usage per key, for KeyHistoryDays.
+type UsageConfig struct {
+	FlushIntervalMs int  `yaml:"flush_interval_ms"`
+	RetentionDays   int  `yaml:"retention_days"`
+	KeyHistory      bool `yaml:"key_history"`
+	KeyHistoryDays  int  `yaml:"key_history_days"`
+}
+
+// TenantConfig scopes policies to one tenant. Zero policy fields fall back
+// to the global policies.
+type TenantConfig struct {
+	ID       string              `yaml:"id"`
+	Policies PoliciesConfig      `yaml:"policies"`
+	Backend  TenantBackendConfig `yaml:"backend"`
+	Keys     TenantKeysConfig    `yaml:"keys"`
+}
+
+// TenantKeysConfig caps how many distinct keys a tenant may have in the
+// backend. Max 0 leaves it uncapped. Keys unused for IdleMs stop counting;
+// Overflow is "reject" (refuse new keys at the cap) or "evict" (drop the
+// least recently used key's state to make room).
+type TenantKeysConfig struct {
+	Max      int64  `yaml:"max"`
+	IdleMs   int    `yaml:"idle_ms"`
+	Overflow string `yaml:"overflow"`
+}
+
+// TenantBackendConfig moves a tenant's keys off the shared backend. An
+// empty Kind keeps them on it. A Redis without an address of its own uses
+// the shared server and password, so a separate db or key_prefix is enough
+// to split a tenant off.
+type TenantBackendConfig struct {
+	Kind  string      `yaml:"kind"`
+	Redis RedisConfig `yaml:"redis"`
+}
+
+// RedisConfig returns the tenant's Redis settings with the shared server filled
+// in.
+func (t TenantBackendConfig) RedisConfig(shared RedisConfig) RedisConfig {
+	return withSharedServer(t.Redis, shared)
+}
+
+// withSharedServer fills in the shared server of a Redis without an address
+// of its own.
+func withSharedServer(redis, shared RedisConfig) RedisConfig {
+	if redis.Addr == "" {
+		redis.Addr = shared.Addr
+		redis.Password = shared.Password
+		redis.ReplicaAddrs = shared.ReplicaAddrs
+	}
+	return redis
+}
+
+func checkTenant(id string) error {
+	if !tenantPattern.MatchString(id) {
+		return fmt.Errorf("tenant %q must be 1-64 letters, digits, '_', '.' or '-'", id)
+	}
+	return nil
+}
+
+func (t TenantsConfig) validate(bad func(field, problem string)) {
+	seen := make(map[string]bool, len(t.List))
+	for i, tenant := range t.List {
+		field := fmt.Sprintf("tenants.list[%d]", i)
+		if err := checkTenant(tenant.ID); err != nil {
+			bad(field+".id", err.Error())
+		} else if seen[tenant.ID] {
+			bad(field+".id", fmt.Sprintf("duplicate tenant %q", tenant.ID))
+		}
+		seen[tenant.ID] = true
+		switch tenant.Backend.Kind {
+		case "", "memory", "redis":
+		default:
+			bad(field+".backend.kind", fmt.Sprintf("%q is not memory or redis", tenant.Backend.Kind))
+		}
+		if tenant.Keys.Max < 0 {
+			bad(field+".keys.max", "must not be negative")
+		}
+		if tenant.Keys.IdleMs < 0 {
+			bad(field+".keys.idle_ms", "must not be negative")
+		}
+		switch tenant.Keys.Overflow {
+		case "", "reject", "evict":
+		default:
+			bad(field+".keys.overflow", fmt.Sprintf("%q is not reject or evict", tenant.Keys.Overflow))
+		}
+		validateCosts(field+".policies.costs", tenant.Policies.Costs, bad)
+		validateAlgorithms(field+".policies.algorithms", tenant.Policies.Algorithms, bad)
+		tenant.Policies.Grace.validate(field+".policies.grace", bad)
+		tenant.Policies.DenyBody.validate(field+".policies.deny_body", bad)
+		if tenant.Backend.Redis.DB < 0 {
+			bad(field+".backend.redis.db", "must not be negative")
+		}
+		for _, p := range []struct {
+			name  string
+			value int64
+		}{
+			{"max_cost", tenant.Policies.MaxCost},
+			{"max_capacity", tenant.Policies.MaxCapacity},
+			{"max_limit", tenant.Policies.MaxLimit},
+			{"max_window_ms", tenant.Policies.MaxWindowMs},
+			{"max_log_limit", tenant.Policies.MaxLogLimit},
+		} {
+			if p.value < 0 {
+				bad(field+".policies."+p.name, "must not be negative")
+			}
+		}
+	}
+}
+
+// GroupConfig is a budget of Limit per WindowMs shared by all the keys of
+// checks naming the group, counted separately in each tenant.
+type GroupConfig struct {
+	ID       string `yaml:"id"`
+	Limit    int64  `yaml:"limit"`
+	WindowMs int64  `yaml:"window_ms"`
+}
+
+func validateGroups(groups []GroupConfig, bad func(field, problem string)) {
+	seen := make(map[string]bool, len(groups))
+	for i, group := range groups {
+		field := fmt.Sprintf("groups[%d]", i)
+		if !tenantPattern.MatchString(group.ID) {
+			bad(field+".id", fmt.Sprintf("group %q must be 1-64 letters, digits, '_', '.' or '-'", group.ID))
+		} else if seen[group.ID] {
+			bad(field+".id", fmt.Sprintf("duplicate group %q", group.ID))
+		}
+		seen[group.ID] = true
+		if group.Limit <= 0 {
+			bad(field+".limit", "must be positive")
+		}
+		if group.WindowMs <= 0 {
+			bad(field+".window_ms", "must be positive")
+		}
+	}
+}
+
+// validateAlgorithms checks a list of the algorithms checks may ask for.
+func validateAlgorithms(field string, algorithms []string, bad func(field, problem string)) {
+	for i, algorithm := range algorithms {
+		switch algorithm {
+		case "token_bucket", "leaky_bucket", "fixed_window", "sliding_window_log", "sliding_window_counter",
+			"count_min_sketch", "weighted_fair_share":
+		default:
+			bad(fmt.Sprintf("%s[%d]", field, i), fmt.Sprintf("%q is not an algorithm", algorithm))
+		}
+	}
+}
+
+// costMethodPattern matches the method of a cost map entry.
+var costMethodPattern = regexp.MustCompile(`^[A-Z]+$`)
+
+// validateCosts checks the entries of a cost map: "*", a method, a path
+// starting with '/' (ending in "/*" for a prefix), or a method and a path.
+func validateCosts(field string, costs map[string]int64, bad func(field, problem string)) {
+	for entry, cost := range costs {
+		method, path, both := strings.Cut(entry, " ")
+		switch {
+		case entry == "*":
+		case both && costMethodPattern.MatchString(method) && validCostPath(path):
+		case !both && (costMethodPattern.MatchString(entry) || validCostPath(entry)):
+		default:
+			bad(field, fmt.Sprintf("entry %q is not *, METHOD, /path, /prefix/* or METHOD /path", entry))
+		}
+		if cost <= 0 {
+			bad(field, fmt.Sprintf("cost of %q must be positive", entry))
+		}
+	}
+}
+
+func validCostPath(path string) bool {
+	if !strings.HasPrefix(path, "/") || strings.ContainsAny(path, " \t") {
+		return false
+	}
+	return !strings.Contains(strings.TrimSuffix(path, "/*"), "*")
+}
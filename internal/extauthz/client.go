@@ -0,0 +1,161 @@
+package extauthz
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+var errMalformedResponse = errors.New("malformed CheckResponse")
+
+// StatusError is a Check call that failed with a gRPC status other than
+// OK, as WriteError fails it.
+type StatusError struct {
+	Code    int
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("grpc status %d: %s", e.Code, e.Message)
+}
+
+// EncodeRequest frames req as the message of a Check call, as Envoy sends
+// it, for clients that stand in for Envoy such as load generators.
+func EncodeRequest(req *Request) []byte {
+	var httpRequest []byte
+	httpRequest = appendBytes(httpRequest, 2, []byte(req.Method))
+	for k, v := range req.Headers {
+		httpRequest = appendMessage(httpRequest, 3, appendEntry(k, v))
+	}
+	path := req.Path
+	if req.Query != "" {
+		path += "?" + req.Query
+	}
+	httpRequest = appendBytes(httpRequest, 4, []byte(path))
+	httpRequest = appendBytes(httpRequest, 5, []byte(req.Host))
+	httpRequest = appendBytes(httpRequest, 6, []byte(req.Scheme))
+	httpRequest = appendBytes(httpRequest, 10, []byte(req.Protocol))
+
+	// source.address.socket_address.address and source.principal.
+	socket := appendBytes(nil, 2, []byte(req.SourceAddress))
+	source := appendMessage(nil, 1, appendMessage(nil, 1, socket))
+	source = appendBytes(source, 5, []byte(req.SourcePrincipal))
+
+	attributes := appendMessage(nil, 1, source)
+	attributes = appendMessage(attributes, 4, appendMessage(nil, 2, httpRequest))
+	for k, v := range req.ContextExtensions {
+		attributes = appendMessage(attributes, 10, appendEntry(k, v))
+	}
+	msg := appendMessage(nil, 1, attributes)
+
+	frame := make([]byte, messageHeaderLength, messageHeaderLength+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	return append(frame, msg...)
+}
+
+// ReadResponse reads the answer to a Check call, of up to limit bytes, and
+// closes its body. A denial has the status, headers and body Envoy would
+// answer with; an allowed request has the headers added to its response.
+// A failed call returns a *StatusError.
+func ReadResponse(resp *http.Response, limit int64) (Response, error) {
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Response{}, fmt.Errorf("http status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit+messageHeaderLength+1))
+	if err != nil {
+		return Response{}, err
+	}
+	// A call that failed before answering has its status in the headers,
+	// otherwise it follows the message as a trailer.
+	status := resp.Header.Get("Grpc-Status")
+	message := resp.Header.Get("Grpc-Message")
+	if status == "" {
+		status, message = resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+	}
+	if code, err := strconv.Atoi(status); err != nil || code != CodeOK {
+		if err != nil {
+			code = CodeInternal
+		}
+		return Response{}, &StatusError{Code: code, Message: message}
+	}
+	if len(body) < messageHeaderLength {
+		return Response{}, errMalformedResponse
+	}
+	if body[0]&compressedMessageFlag != 0 {
+		return Response{}, ErrCompressed
+	}
+	n := binary.BigEndian.Uint32(body[1:])
+	if int64(n) > limit || int(n) != len(body)-messageHeaderLength {
+		return Response{}, errMalformedResponse
+	}
+	out, err := decodeCheckResponse(body[messageHeaderLength:])
+	if errors.Is(err, errMalformed) {
+		err = errMalformedResponse
+	}
+	return out, err
+}
+
+// decodeCheckResponse reads what WriteResponse writes.
+func decodeCheckResponse(msg []byte) (Response, error) {
+	var resp Response
+	code := uint64(CodeOK)
+	err := fields(msg, func(field uint64, data []byte) error {
+		switch field {
+		case 1: // status
+			return walk(data, func(field, v uint64) {
+				if field == 1 {
+					code = v
+				}
+			}, skipBytes)
+		case 2: // denied_response
+			return fields(data, func(field uint64, data []byte) error {
+				switch field {
+				case 1: // status.code
+					return walk(data, func(field, v uint64) {
+						if field == 1 {
+							resp.Status = int(v)
+						}
+					}, skipBytes)
+				case 2:
+					return decodeHeaderValueOption(data, &resp)
+				case 3:
+					resp.Body = string(data)
+				}
+				return nil
+			})
+		case 3: // ok_response
+			return fields(data, func(field uint64, data []byte) error {
+				if field == 6 {
+					return decodeHeaderValueOption(data, &resp)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	resp.Allowed = code == CodeOK
+	return resp, err
+}
+
+func decodeHeaderValueOption(msg []byte, resp *Response) error {
+	return fields(msg, func(field uint64, data []byte) error {
+		if field != 1 {
+			return nil
+		}
+		k, v, err := decodeEntry(data)
+		resp.Headers = append(resp.Headers, Header{Key: k, Value: v})
+		return err
+	})
+}
+
+func skipBytes(uint64, []byte) error { return nil }
+
+// appendEntry encodes one entry of a map<string, string>, which a
+// HeaderValue is laid out like too.
+func appendEntry(key, value string) []byte {
+	return appendBytes(appendBytes(nil, 1, []byte(key)), 2, []byte(value))
+}
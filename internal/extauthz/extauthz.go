@@ -0,0 +1,201 @@
+// Package extauthz serves Envoy's external authorization API,
+// envoy.service.auth.v3.Authorization/Check, as unary gRPC over net/http:
+// it decodes the request's attributes and encodes allow and deny answers,
+// without generated code. Clients standing in for Envoy can encode calls
+// and read the answers too.
+package extauthz
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Path is the gRPC method's HTTP path.
+const Path = "/envoy.service.auth.v3.Authorization/Check"
+
+// gRPC status codes. A denial is PermissionDenied; the others fail calls
+// that were not decided.
+const (
+	CodeOK                = 0
+	CodeInvalidArgument   = 3
+	CodeDeadlineExceeded  = 4
+	CodePermissionDenied  = 7
+	CodeResourceExhausted = 8
+	CodeUnimplemented     = 12
+	CodeInternal          = 13
+	CodeUnavailable       = 14
+	CodeUnauthenticated   = 16
+)
+
+const (
+	grpcContentType = "application/grpc"
+	// A message is framed by a compressed flag and its length.
+	messageHeaderLength   = 5
+	compressedMessageFlag = 1
+	// overwriteIfExistsOrAdd is HeaderValueOption's append_action that
+	// replaces a header the upstream set.
+	overwriteIfExistsOrAdd = 2
+)
+
+// ErrCompressed is returned for a compressed request, which is not
+// supported.
+var ErrCompressed = errors.New("compressed messages are not supported")
+
+// Request holds the attributes of the HTTP request Envoy asks about.
+type Request struct {
+	// SourceAddress is the downstream client's address, without the port,
+	// and SourcePrincipal the identity its client certificate proved.
+	SourceAddress   string
+	SourcePrincipal string
+	Method          string
+	// Path is the request path without its query, which is in Query.
+	Path     string
+	Query    string
+	Host     string
+	Scheme   string
+	Protocol string
+	// Headers are keyed by lowercase name.
+	Headers map[string]string
+	// ContextExtensions are set per route in Envoy's configuration.
+	ContextExtensions map[string]string
+}
+
+// Header is one header added to a response.
+type Header struct {
+	Key, Value string
+}
+
+// Response answers a Check. An allowed request goes on upstream with
+// Headers added to its response; a denied one is answered with Status,
+// Headers and Body.
+type Response struct {
+	Allowed bool
+	Status  int
+	Headers []Header
+	Body    string
+}
+
+// IsGRPC reports whether r is a gRPC call.
+func IsGRPC(r *http.Request) bool {
+	return r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), grpcContentType)
+}
+
+// ReadRequest reads and decodes the single message of a Check call, of up
+// to limit bytes.
+func ReadRequest(body io.Reader, limit int64) (*Request, error) {
+	var head [messageHeaderLength]byte
+	if _, err := io.ReadFull(body, head[:]); err != nil {
+		return nil, fmt.Errorf("reading message: %w", err)
+	}
+	if head[0]&compressedMessageFlag != 0 {
+		return nil, ErrCompressed
+	}
+	n := binary.BigEndian.Uint32(head[1:])
+	if int64(n) > limit {
+		return nil, fmt.Errorf("message of %d bytes is over the limit of %d", n, limit)
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(body, msg); err != nil {
+		return nil, fmt.Errorf("reading message: %w", err)
+	}
+	req := &Request{Headers: map[string]string{}, ContextExtensions: map[string]string{}}
+	if err := decodeCheckRequest(msg, req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// WriteResponse answers the call with resp.
+func WriteResponse(w http.ResponseWriter, resp Response) {
+	var answer []byte
+	if resp.Allowed {
+		// An OK status, and an OkHttpResponse whose
+		// response_headers_to_add reach the client.
+		var ok []byte
+		for _, h := range resp.Headers {
+			ok = appendMessage(ok, 6, headerValueOption(h))
+		}
+		answer = appendMessage(answer, 1, nil)
+		answer = appendMessage(answer, 3, ok)
+	} else {
+		// A DeniedHttpResponse with its status, headers and body.
+		denied := appendMessage(nil, 1, appendVarint(nil, 1, uint64(resp.Status)))
+		for _, h := range resp.Headers {
+			denied = appendMessage(denied, 2, headerValueOption(h))
+		}
+		denied = appendBytes(denied, 3, []byte(resp.Body))
+		answer = appendMessage(answer, 1, appendVarint(nil, 1, CodePermissionDenied))
+		answer = appendMessage(answer, 2, denied)
+	}
+
+	frame := make([]byte, messageHeaderLength, messageHeaderLength+len(answer))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(answer)))
+	frame = append(frame, answer...)
+	w.Header().Set("Content-Type", grpcContentType)
+	w.Header().Set("Trailer", "Grpc-Status")
+	w.WriteHeader(http.StatusOK)
+	w.Write(frame)
+	w.Header().Set("Grpc-Status", strconv.Itoa(CodeOK))
+}
+
+// WriteError fails the call with a gRPC status, for Envoy to handle as its
+// failure_mode_allow setting says.
+func WriteError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", grpcContentType)
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	w.Header().Set("Grpc-Message", message)
+	w.WriteHeader(http.StatusOK)
+}
+
+// CodeForStatus maps the HTTP status of a failed check to a gRPC code.
+func CodeForStatus(status int) int {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeInvalidArgument
+	case http.StatusUnauthorized:
+		return CodeUnauthenticated
+	case http.StatusForbidden:
+		return CodePermissionDenied
+	case http.StatusTooManyRequests:
+		return CodeResourceExhausted
+	case http.StatusNotImplemented:
+		return CodeUnimplemented
+	case http.StatusServiceUnavailable:
+		return CodeUnavailable
+	case http.StatusGatewayTimeout:
+		return CodeDeadlineExceeded
+	}
+	return CodeInternal
+}
+
+// StatusForCode maps the gRPC code of a failed call to the HTTP status
+// of the check that failed it.
+func StatusForCode(code int) int {
+	switch code {
+	case CodeInvalidArgument:
+		return http.StatusBadRequest
+	case CodeUnauthenticated:
+		return http.StatusUnauthorized
+	case CodePermissionDenied:
+		return http.StatusForbidden
+	case CodeResourceExhausted:
+		return http.StatusTooManyRequests
+	case CodeUnimplemented:
+		return http.StatusNotImplemented
+	case CodeUnavailable:
+		return http.StatusServiceUnavailable
+	case CodeDeadlineExceeded:
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
+
+func headerValueOption(h Header) []byte {
+	option := appendMessage(nil, 1, appendEntry(h.Key, h.Value))
+	return appendVarint(option, 3, overwriteIfExistsOrAdd)
+}
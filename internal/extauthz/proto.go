@@ -0,0 +1,206 @@
+package extauthz
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+)
+
+var errMalformed = errors.New("malformed CheckRequest")
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// fields calls fn with each length-delimited field of msg, skipping the
+// others.
+func fields(msg []byte, fn func(field uint64, data []byte) error) error {
+	return walk(msg, nil, fn)
+}
+
+// walk calls varint with each varint field of msg, when set, and bytes with
+// each length-delimited one, skipping fixed-size fields.
+func walk(msg []byte, varint func(field, v uint64), bytes func(field uint64, data []byte) error) error {
+	for len(msg) > 0 {
+		tag, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return errMalformed
+		}
+		msg = msg[n:]
+		field, wire := tag>>3, tag&7
+		switch wire {
+		case wireVarint:
+			v, n := binary.Uvarint(msg)
+			if n <= 0 {
+				return errMalformed
+			}
+			msg = msg[n:]
+			if varint != nil {
+				varint(field, v)
+			}
+		case wireFixed64, wireFixed32:
+			size := 8
+			if wire == wireFixed32 {
+				size = 4
+			}
+			if len(msg) < size {
+				return errMalformed
+			}
+			msg = msg[size:]
+		case wireBytes:
+			length, n := binary.Uvarint(msg)
+			if n <= 0 || uint64(len(msg)-n) < length {
+				return errMalformed
+			}
+			data := msg[n : n+int(length)]
+			msg = msg[n+int(length):]
+			if err := bytes(field, data); err != nil {
+				return err
+			}
+		default:
+			return errMalformed
+		}
+	}
+	return nil
+}
+
+// decodeCheckRequest reads the AttributeContext of a CheckRequest into
+// req.
+func decodeCheckRequest(msg []byte, req *Request) error {
+	return fields(msg, func(field uint64, attributes []byte) error {
+		if field != 1 {
+			return nil
+		}
+		return fields(attributes, func(field uint64, data []byte) error {
+			switch field {
+			case 1: // source
+				return decodePeer(data, req)
+			case 4: // request
+				return fields(data, func(field uint64, httpRequest []byte) error {
+					if field != 2 {
+						return nil
+					}
+					return decodeHTTPRequest(httpRequest, req)
+				})
+			case 10: // context_extensions
+				k, v, err := decodeEntry(data)
+				req.ContextExtensions[k] = v
+				return err
+			}
+			return nil
+		})
+	})
+}
+
+// decodePeer reads the source peer's address and principal.
+func decodePeer(msg []byte, req *Request) error {
+	return fields(msg, func(field uint64, data []byte) error {
+		switch field {
+		case 1: // address: Address.socket_address.address
+			return fields(data, func(field uint64, socket []byte) error {
+				if field != 1 {
+					return nil
+				}
+				return fields(socket, func(field uint64, address []byte) error {
+					if field == 2 {
+						req.SourceAddress = string(address)
+						if host, _, err := net.SplitHostPort(req.SourceAddress); err == nil {
+							req.SourceAddress = host
+						}
+					}
+					return nil
+				})
+			})
+		case 5:
+			req.SourcePrincipal = string(data)
+		}
+		return nil
+	})
+}
+
+func decodeHTTPRequest(msg []byte, req *Request) error {
+	return fields(msg, func(field uint64, data []byte) error {
+		switch field {
+		case 2:
+			req.Method = string(data)
+		case 3:
+			k, v, err := decodeEntry(data)
+			req.Headers[strings.ToLower(k)] = v
+			return err
+		case 4:
+			req.Path, req.Query, _ = strings.Cut(string(data), "?")
+		case 5:
+			req.Host = string(data)
+		case 6:
+			req.Scheme = string(data)
+		case 7:
+			if len(data) > 0 {
+				req.Query = string(data)
+			}
+		case 10:
+			req.Protocol = string(data)
+		case 13: // header_map, sent instead of headers when Envoy encodes raw headers
+			return fields(data, func(field uint64, header []byte) error {
+				if field != 1 {
+					return nil
+				}
+				var key, value, raw string
+				err := fields(header, func(field uint64, data []byte) error {
+					switch field {
+					case 1:
+						key = string(data)
+					case 2:
+						value = string(data)
+					case 3:
+						raw = string(data)
+					}
+					return nil
+				})
+				if value == "" {
+					value = raw
+				}
+				name := strings.ToLower(key)
+				if prev, ok := req.Headers[name]; ok {
+					value = prev + "," + value
+				}
+				req.Headers[name] = value
+				return err
+			})
+		}
+		return nil
+	})
+}
+
+// decodeEntry reads one entry of a map<string, string>.
+func decodeEntry(msg []byte) (key, value string, err error) {
+	err = fields(msg, func(field uint64, data []byte) error {
+		switch field {
+		case 1:
+			key = string(data)
+		case 2:
+			value = string(data)
+		}
+		return nil
+	})
+	return key, value, err
+}
+
+func appendVarint(buf []byte, field, v uint64) []byte {
+	buf = binary.AppendUvarint(buf, field<<3|wireVarint)
+	return binary.AppendUvarint(buf, v)
+}
+
+func appendBytes(buf []byte, field uint64, data []byte) []byte {
+	buf = binary.AppendUvarint(buf, field<<3|wireBytes)
+	buf = binary.AppendUvarint(buf, uint64(len(data)))
+	return append(buf, data...)
+}
+
+// appendMessage appends an embedded message, which is encoded as bytes.
+func appendMessage(buf []byte, field uint64, msg []byte) []byte {
+	return appendBytes(buf, field, msg)
+}
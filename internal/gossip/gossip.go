@@ -0,0 +1,276 @@
+// Package gossip keeps a membership list of limiter instances running the
+// memory backend, so they can share consumption without Redis. Each
+// instance periodically swaps its view of the members with a few others;
+// a member whose heartbeat stops advancing is dropped. Consumption itself
+// travels through a replication.Replicator whose peers are the live members.
+package gossip
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"rate-limiter-service/internal/replication"
+)
+
+// maxDigestBytes bounds a peer's membership reply.
+const maxDigestBytes = 1 << 20
+
+// Member is one instance as last heard of. Heartbeat only grows, even
+// across restarts, so the higher one wins when views disagree.
+type Member struct {
+	Name      string `json:"name"`
+	URL       string `json:"url"`
+	Heartbeat int64  `json:"heartbeat"`
+}
+
+// Digest is what two instances swap in one round: the sender and every
+// member it believes alive.
+type Digest struct {
+	From    Member   `json:"from"`
+	Members []Member `json:"members"`
+}
+
+type Options struct {
+	// Name identifies this instance; URL is where the others reach it.
+	Name string
+	URL  string
+	// Seeds are URLs of members to join through. One is contacted every
+	// round, so groups that formed apart, say because a seed was down,
+	// still find each other.
+	Seeds []string
+	// Interval is how often a round runs; defaults to a second.
+	Interval time.Duration
+	// Fanout is how many members each round talks to; defaults to 3.
+	Fanout int
+	// DeadAfter drops members whose heartbeat has not advanced within it;
+	// defaults to 10 intervals.
+	DeadAfter time.Duration
+	// APIKey is sent as "Authorization: ApiKey <key>".
+	APIKey string
+	Client *http.Client
+	// Replicator, when set, gets the live members as its peers.
+	Replicator *replication.Replicator
+}
+
+// Node is this instance's view of the cluster.
+type Node struct {
+	opts   Options
+	client *http.Client
+	rand   *rand.Rand
+
+	mu        sync.Mutex
+	heartbeat int64
+	members   map[string]*state
+}
+
+type state struct {
+	Member
+	// seen is when the heartbeat last advanced, by the local clock.
+	seen time.Time
+	dead bool
+}
+
+func New(opts Options) *Node {
+	if opts.Interval <= 0 {
+		opts.Interval = time.Second
+	}
+	if opts.Fanout <= 0 {
+		opts.Fanout = 3
+	}
+	if opts.DeadAfter <= 0 {
+		opts.DeadAfter = 10 * opts.Interval
+	}
+	client := opts.Client
+	if client == nil {
+		client = &http.Client{Timeout: opts.Interval}
+	}
+	return &Node{
+		opts:   opts,
+		client: client,
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+		// Starting from the clock lets a restarted instance outbid what the
+		// others remember of its previous run.
+		heartbeat: time.Now().UnixMilli(),
+		members:   make(map[string]*state),
+	}
+}
+
+// Run gossips every interval until ctx ends.
+func (n *Node) Run(ctx context.Context) {
+	ticker := time.NewTicker(n.opts.Interval)
+	defer ticker.Stop()
+	for {
+		n.round(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Merge folds a peer's digest into the local view and returns the local
+// digest for the reply.
+func (n *Node) Merge(d Digest) Digest {
+	n.merge(append(d.Members, d.From))
+	return n.digest()
+}
+
+// Members returns the live members other than this instance, by name.
+func (n *Node) Members() []Member {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.alive()
+}
+
+func (n *Node) alive() []Member {
+	var out []Member
+	for _, s := range n.members {
+		if !s.dead {
+			out = append(out, s.Member)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func (n *Node) round(ctx context.Context) {
+	n.mu.Lock()
+	n.heartbeat++
+	now := time.Now()
+	for name, s := range n.members {
+		switch {
+		case !s.dead && now.Sub(s.seen) > n.opts.DeadAfter:
+			log.Printf("gossip: %s (%s) stopped responding; dropping it", s.Name, s.URL)
+			s.dead = true
+		case s.dead && now.Sub(s.seen) > 2*n.opts.DeadAfter:
+			// Forgotten once stale digests can no longer bring it back.
+			delete(n.members, name)
+		}
+	}
+	targets := n.targets()
+	n.mu.Unlock()
+	n.updatePeers()
+
+	digest := n.digest()
+	var wg sync.WaitGroup
+	for _, url := range targets {
+		wg.Add(1)
+		go func(url string) {
+			defer wg.Done()
+			reply, err := n.exchange(ctx, url, digest)
+			if err != nil {
+				return
+			}
+			n.merge(append(reply.Members, reply.From))
+		}(url)
+	}
+	wg.Wait()
+}
+
+// targets picks up to Fanout live members and one seed at random. Called
+// with n.mu held.
+func (n *Node) targets() []string {
+	members := n.alive()
+	n.rand.Shuffle(len(members), func(i, j int) { members[i], members[j] = members[j], members[i] })
+	urls := make([]string, 0, n.opts.Fanout+1)
+	for _, m := range members[:min(len(members), n.opts.Fanout)] {
+		urls = append(urls, m.URL)
+	}
+	if len(n.opts.Seeds) > 0 {
+		seed := n.opts.Seeds[n.rand.Intn(len(n.opts.Seeds))]
+		if seed != n.opts.URL && !slices.Contains(urls, seed) {
+			urls = append(urls, seed)
+		}
+	}
+	return urls
+}
+
+func (n *Node) digest() Digest {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return Digest{
+		From:    Member{Name: n.opts.Name, URL: n.opts.URL, Heartbeat: n.heartbeat},
+		Members: n.alive(),
+	}
+}
+
+func (n *Node) merge(members []Member) {
+	n.mu.Lock()
+	changed := false
+	now := time.Now()
+	for _, m := range members {
+		if m.Name == "" || m.Name == n.opts.Name || m.URL == "" {
+			continue
+		}
+		s, ok := n.members[m.Name]
+		if !ok {
+			log.Printf("gossip: %s (%s) joined", m.Name, m.URL)
+			n.members[m.Name] = &state{Member: m, seen: now}
+			changed = true
+			continue
+		}
+		if m.Heartbeat <= s.Heartbeat {
+			continue
+		}
+		if s.dead {
+			log.Printf("gossip: %s (%s) is back", m.Name, m.URL)
+			changed = true
+		}
+		changed = changed || s.URL != m.URL
+		s.Member, s.seen, s.dead = m, now, false
+	}
+	n.mu.Unlock()
+	if changed {
+		n.updatePeers()
+	}
+}
+
+// updatePeers points the replicator at the live members.
+func (n *Node) updatePeers() {
+	if n.opts.Replicator == nil {
+		return
+	}
+	members := n.Members()
+	peers := make([]replication.Peer, 0, len(members))
+	for _, m := range members {
+		peers = append(peers, replication.Peer{Region: m.Name, URL: m.URL})
+	}
+	n.opts.Replicator.SetPeers(peers)
+}
+
+func (n *Node) exchange(ctx context.Context, url string, digest Digest) (Digest, error) {
+	var reply Digest
+	body, err := json.Marshal(digest)
+	if err != nil {
+		return reply, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url+"/v1/gossip/members", bytes.NewReader(body))
+	if err != nil {
+		return reply, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.opts.APIKey != "" {
+		req.Header.Set("Authorization", "ApiKey "+n.opts.APIKey)
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return reply, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return reply, fmt.Errorf("%s: status %d", url, resp.StatusCode)
+	}
+	err = json.NewDecoder(io.LimitReader(resp.Body, maxDigestBytes)).Decode(&reply)
+	return reply, err
+}
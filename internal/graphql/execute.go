@@ -0,0 +1,316 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// Request is a GraphQL request as sent over HTTP.
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+// Response is a GraphQL response. Data is absent when the request failed
+// before execution.
+type Response struct {
+	Data   *Object `json:"data,omitempty"`
+	Errors []Error `json:"errors,omitempty"`
+}
+
+// Error is a GraphQL error; Path names the field it nulled.
+type Error struct {
+	Message    string                 `json:"message"`
+	Locations  []Location             `json:"locations,omitempty"`
+	Path       []interface{}          `json:"path,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+func (e *Error) Error() string { return e.Message }
+
+type Location struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// Resolver answers a root field given its arguments, with any value that
+// marshals to JSON. Returning an *Error sets its message and extensions.
+type Resolver func(ctx context.Context, args map[string]interface{}) (interface{}, error)
+
+// Object is a JSON object that keeps its fields in selection order.
+type Object struct {
+	names  []string
+	values map[string]interface{}
+}
+
+func (o *Object) set(name string, value interface{}) {
+	if o.values == nil {
+		o.values = make(map[string]interface{})
+	}
+	if _, ok := o.values[name]; !ok {
+		o.names = append(o.names, name)
+	}
+	o.values[name] = value
+}
+
+// Get returns the value of field name.
+func (o *Object) Get(name string) (interface{}, bool) {
+	v, ok := o.values[name]
+	return v, ok
+}
+
+func (o *Object) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, name := range o.names {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(name)
+		value, err := json.Marshal(o.values[name])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// Execute runs req's query against the root fields. A response without
+// data means the request itself was invalid.
+func Execute(ctx context.Context, req Request, fields map[string]Resolver) Response {
+	doc, err := Parse(req.Query)
+	if err != nil {
+		e := err.(*SyntaxError)
+		return Response{Errors: []Error{{Message: e.Error(), Locations: []Location{{e.Line, e.Column}}}}}
+	}
+	op, err := pickOperation(doc, req.OperationName)
+	if err != nil {
+		return Response{Errors: []Error{{Message: err.Error()}}}
+	}
+	ex := &executor{doc: doc, vars: make(map[string]interface{})}
+	for _, def := range op.Variables {
+		if v, ok := req.Variables[def.Name]; ok {
+			ex.vars[def.Name] = v
+		} else if def.HasDef {
+			ex.vars[def.Name] = def.Default
+		}
+	}
+	if err := ex.check(op.SelectionSet, fields, map[string]bool{}); err != nil {
+		return Response{Errors: []Error{*err}}
+	}
+
+	data := &Object{}
+	for _, f := range ex.collect(op.SelectionSet) {
+		name := f.ResponseName()
+		if f.Name == "__typename" {
+			data.set(name, "Query")
+			continue
+		}
+		args := make(map[string]interface{}, len(f.Arguments))
+		for _, arg := range f.Arguments {
+			args[arg.Name] = ex.resolve(arg.Value)
+		}
+		value, err := fields[f.Name](ctx, args)
+		if err == nil {
+			value, err = ex.project(value, f.SelectionSet)
+		}
+		if err != nil {
+			e, ok := err.(*Error)
+			if !ok {
+				e = &Error{Message: err.Error()}
+			}
+			e.Path = []interface{}{name}
+			e.Locations = []Location{{f.Line, f.Column}}
+			ex.errors = append(ex.errors, *e)
+			value = nil
+		}
+		data.set(name, value)
+	}
+	return Response{Data: data, Errors: ex.errors}
+}
+
+func pickOperation(doc *Document, name string) (*Operation, error) {
+	var op *Operation
+	for _, o := range doc.Operations {
+		if name == "" || o.Name == name {
+			if op != nil {
+				return nil, fmt.Errorf("the document has several operations; name one with operationName")
+			}
+			op = o
+		}
+	}
+	switch {
+	case op == nil:
+		return nil, fmt.Errorf("unknown operation %q", name)
+	case op.Type != "query":
+		return nil, fmt.Errorf("only queries are supported, not %ss", op.Type)
+	}
+	return op, nil
+}
+
+type executor struct {
+	doc    *Document
+	vars   map[string]interface{}
+	errors []Error
+}
+
+// check rejects unknown root fields and fragments, and fragment cycles,
+// before anything is resolved.
+func (ex *executor) check(set []Selection, fields map[string]Resolver, visiting map[string]bool) *Error {
+	for _, sel := range set {
+		switch s := sel.(type) {
+		case *Field:
+			if _, ok := fields[s.Name]; !ok && s.Name != "__typename" {
+				return &Error{Message: fmt.Sprintf("unknown field %q", s.Name), Locations: []Location{{s.Line, s.Column}}}
+			}
+		case *InlineFragment:
+			if err := ex.check(s.SelectionSet, fields, visiting); err != nil {
+				return err
+			}
+		case *FragmentSpread:
+			frag, ok := ex.doc.Fragments[s.Name]
+			if !ok {
+				return &Error{Message: fmt.Sprintf("unknown fragment %q", s.Name)}
+			}
+			if visiting[s.Name] {
+				return &Error{Message: fmt.Sprintf("fragment %q spreads itself", s.Name)}
+			}
+			visiting[s.Name] = true
+			if err := ex.check(frag.SelectionSet, fields, visiting); err != nil {
+				return err
+			}
+			delete(visiting, s.Name)
+		}
+	}
+	return nil
+}
+
+// collect flattens fragments into the fields they select, dropping those
+// @skip or @include leave out. Fields sharing a response name merge their
+// selections.
+func (ex *executor) collect(set []Selection) []*Field {
+	var out []*Field
+	byName := make(map[string]*Field)
+	var walk func([]Selection, int)
+	walk = func(set []Selection, depth int) {
+		if depth > 32 {
+			return
+		}
+		for _, sel := range set {
+			switch s := sel.(type) {
+			case *Field:
+				if !ex.included(s.Directives) {
+					continue
+				}
+				name := s.ResponseName()
+				if prev, ok := byName[name]; ok {
+					merged := *prev
+					merged.SelectionSet = append(append([]Selection(nil), prev.SelectionSet...), s.SelectionSet...)
+					*prev = merged
+					continue
+				}
+				f := *s
+				byName[name] = &f
+				out = append(out, &f)
+			case *InlineFragment:
+				if ex.included(s.Directives) {
+					walk(s.SelectionSet, depth+1)
+				}
+			case *FragmentSpread:
+				if frag, ok := ex.doc.Fragments[s.Name]; ok && ex.included(s.Directives) {
+					walk(frag.SelectionSet, depth+1)
+				}
+			}
+		}
+	}
+	walk(set, 0)
+	return out
+}
+
+func (ex *executor) included(dirs []Directive) bool {
+	for _, d := range dirs {
+		for _, arg := range d.Arguments {
+			if arg.Name != "if" {
+				continue
+			}
+			on, _ := ex.resolve(arg.Value).(bool)
+			if d.Name == "skip" && on || d.Name == "include" && !on {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// resolve replaces the variables in v with their values.
+func (ex *executor) resolve(v interface{}) interface{} {
+	switch v := v.(type) {
+	case Variable:
+		return ex.vars[string(v)]
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i := range v {
+			out[i] = ex.resolve(v[i])
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k := range v {
+			out[k] = ex.resolve(v[k])
+		}
+		return out
+	}
+	return v
+}
+
+// project keeps the fields of value that set selects, applied to each
+// element of a list. Without a selection the value is kept whole.
+func (ex *executor) project(value interface{}, set []Selection) (interface{}, error) {
+	if len(set) == 0 || value == nil {
+		return value, nil
+	}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var generic interface{}
+	if err := dec.Decode(&generic); err != nil {
+		return nil, err
+	}
+	return ex.pick(generic, ex.collect(set)), nil
+}
+
+func (ex *executor) pick(value interface{}, fields []*Field) interface{} {
+	switch v := value.(type) {
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i := range v {
+			out[i] = ex.pick(v[i], fields)
+		}
+		return out
+	case map[string]interface{}:
+		obj := &Object{}
+		for _, f := range fields {
+			if f.Name == "__typename" {
+				obj.set(f.ResponseName(), "Object")
+				continue
+			}
+			child := v[f.Name]
+			if len(f.SelectionSet) > 0 {
+				child = ex.pick(child, ex.collect(f.SelectionSet))
+			}
+			obj.set(f.ResponseName(), child)
+		}
+		return obj
+	}
+	return value
+}
@@ -0,0 +1,545 @@
+// Package graphql runs GraphQL queries against a fixed set of root fields
+// whose resolvers return JSON-shaped values. It parses the full query
+// language but executes only queries, leaving types to the resolvers: a
+// selection picks fields out of whatever a resolver returned.
+package graphql
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Document is a parsed GraphQL document.
+type Document struct {
+	Operations []*Operation
+	Fragments  map[string]*Fragment
+}
+
+// Operation is a query, mutation or subscription.
+type Operation struct {
+	Type         string
+	Name         string
+	Variables    []VariableDefinition
+	SelectionSet []Selection
+}
+
+type VariableDefinition struct {
+	Name    string
+	Default interface{}
+	HasDef  bool
+}
+
+type Fragment struct {
+	Name         string
+	SelectionSet []Selection
+}
+
+// Selection is a *Field, *FragmentSpread or *InlineFragment.
+type Selection interface{}
+
+type Field struct {
+	Alias        string
+	Name         string
+	Arguments    []Argument
+	Directives   []Directive
+	SelectionSet []Selection
+	Line, Column int
+}
+
+// ResponseName is the field's alias, or its name when it has none.
+func (f *Field) ResponseName() string {
+	if f.Alias != "" {
+		return f.Alias
+	}
+	return f.Name
+}
+
+type FragmentSpread struct {
+	Name       string
+	Directives []Directive
+}
+
+type InlineFragment struct {
+	Directives   []Directive
+	SelectionSet []Selection
+}
+
+type Argument struct {
+	Name  string
+	Value interface{}
+}
+
+type Directive struct {
+	Name      string
+	Arguments []Argument
+}
+
+// Variable is a reference to an operation variable in a value. Other
+// values are nil, bool, string, json.Number, []interface{} and
+// map[string]interface{}; enum values are strings.
+type Variable string
+
+// SyntaxError is a document that does not parse.
+type SyntaxError struct {
+	Message      string
+	Line, Column int
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at %d:%d: %s", e.Line, e.Column, e.Message)
+}
+
+const (
+	tokenEOF = iota
+	tokenPunct
+	tokenName
+	tokenInt
+	tokenFloat
+	tokenString
+)
+
+type token struct {
+	kind         int
+	value        string
+	line, column int
+}
+
+type parser struct {
+	src       string
+	pos       int
+	line, col int
+	tok       token
+}
+
+// Parse parses a GraphQL document.
+func Parse(src string) (doc *Document, err error) {
+	defer func() {
+		if e, ok := recover().(*SyntaxError); ok {
+			doc, err = nil, e
+		} else if e != nil {
+			panic(e)
+		}
+	}()
+	p := &parser{src: src, line: 1, col: 1}
+	p.next()
+	doc = &Document{Fragments: make(map[string]*Fragment)}
+	for p.tok.kind != tokenEOF {
+		switch {
+		case p.peek(tokenPunct, "{"):
+			doc.Operations = append(doc.Operations, &Operation{Type: "query", SelectionSet: p.selectionSet()})
+		case p.peek(tokenName, "query"), p.peek(tokenName, "mutation"), p.peek(tokenName, "subscription"):
+			doc.Operations = append(doc.Operations, p.operation())
+		case p.peek(tokenName, "fragment"):
+			f := p.fragment()
+			if _, dup := doc.Fragments[f.Name]; dup {
+				p.fail("fragment %q is defined twice", f.Name)
+			}
+			doc.Fragments[f.Name] = f
+		default:
+			p.fail("unexpected %q", p.tok.value)
+		}
+	}
+	if len(doc.Operations) == 0 {
+		p.fail("no operation")
+	}
+	return doc, nil
+}
+
+func (p *parser) fail(format string, args ...interface{}) {
+	panic(&SyntaxError{Message: fmt.Sprintf(format, args...), Line: p.tok.line, Column: p.tok.column})
+}
+
+func (p *parser) peek(kind int, value string) bool {
+	return p.tok.kind == kind && p.tok.value == value
+}
+
+// skip consumes the punctuator value if it is next.
+func (p *parser) skip(value string) bool {
+	if p.peek(tokenPunct, value) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(value string) {
+	if !p.skip(value) {
+		p.fail("expected %q, found %q", value, p.tok.value)
+	}
+}
+
+func (p *parser) name() string {
+	if p.tok.kind != tokenName {
+		p.fail("expected a name, found %q", p.tok.value)
+	}
+	name := p.tok.value
+	p.next()
+	return name
+}
+
+func (p *parser) operation() *Operation {
+	op := &Operation{Type: p.name()}
+	if p.tok.kind == tokenName {
+		op.Name = p.name()
+	}
+	if p.skip("(") {
+		for !p.skip(")") {
+			p.expect("$")
+			def := VariableDefinition{Name: p.name()}
+			p.expect(":")
+			p.typeRef()
+			if p.skip("=") {
+				def.Default, def.HasDef = p.value(true), true
+			}
+			p.directives()
+			op.Variables = append(op.Variables, def)
+		}
+	}
+	p.directives()
+	op.SelectionSet = p.selectionSet()
+	return op
+}
+
+// typeRef skips a variable's type, which resolvers check themselves.
+func (p *parser) typeRef() {
+	if p.skip("[") {
+		p.typeRef()
+		p.expect("]")
+	} else {
+		p.name()
+	}
+	p.skip("!")
+}
+
+func (p *parser) fragment() *Fragment {
+	p.name() // fragment
+	f := &Fragment{Name: p.name()}
+	if f.Name == "on" {
+		p.fail("a fragment cannot be named on")
+	}
+	if p.name() != "on" {
+		p.fail("expected on")
+	}
+	p.name()
+	p.directives()
+	f.SelectionSet = p.selectionSet()
+	return f
+}
+
+func (p *parser) selectionSet() []Selection {
+	p.expect("{")
+	var set []Selection
+	for !p.skip("}") {
+		if p.skip("...") {
+			if p.peek(tokenName, "on") || p.peek(tokenPunct, "@") || p.peek(tokenPunct, "{") {
+				if p.tok.value == "on" {
+					p.next()
+					p.name()
+				}
+				frag := &InlineFragment{Directives: p.directives()}
+				frag.SelectionSet = p.selectionSet()
+				set = append(set, frag)
+			} else {
+				set = append(set, &FragmentSpread{Name: p.name(), Directives: p.directives()})
+			}
+			continue
+		}
+		f := &Field{Line: p.tok.line, Column: p.tok.column}
+		f.Name = p.name()
+		if p.skip(":") {
+			f.Alias, f.Name = f.Name, p.name()
+		}
+		f.Arguments = p.arguments()
+		f.Directives = p.directives()
+		if p.peek(tokenPunct, "{") {
+			f.SelectionSet = p.selectionSet()
+		}
+		set = append(set, f)
+	}
+	if len(set) == 0 {
+		p.fail("empty selection set")
+	}
+	return set
+}
+
+func (p *parser) arguments() []Argument {
+	if !p.skip("(") {
+		return nil
+	}
+	var args []Argument
+	for !p.skip(")") {
+		arg := Argument{Name: p.name()}
+		p.expect(":")
+		arg.Value = p.value(false)
+		args = append(args, arg)
+	}
+	return args
+}
+
+func (p *parser) directives() []Directive {
+	var dirs []Directive
+	for p.skip("@") {
+		dirs = append(dirs, Directive{Name: p.name(), Arguments: p.arguments()})
+	}
+	return dirs
+}
+
+// value parses a value; constant ones may not refer to variables.
+func (p *parser) value(constant bool) interface{} {
+	tok := p.tok
+	switch tok.kind {
+	case tokenInt, tokenFloat:
+		p.next()
+		return json.Number(tok.value)
+	case tokenString:
+		p.next()
+		return tok.value
+	case tokenName:
+		p.next()
+		switch tok.value {
+		case "true":
+			return true
+		case "false":
+			return false
+		case "null":
+			return nil
+		}
+		return tok.value
+	}
+	switch {
+	case p.skip("$"):
+		if constant {
+			p.fail("variables are not allowed here")
+		}
+		return Variable(p.name())
+	case p.skip("["):
+		list := []interface{}{}
+		for !p.skip("]") {
+			list = append(list, p.value(constant))
+		}
+		return list
+	case p.skip("{"):
+		obj := map[string]interface{}{}
+		for !p.skip("}") {
+			name := p.name()
+			p.expect(":")
+			obj[name] = p.value(constant)
+		}
+		return obj
+	}
+	p.fail("expected a value, found %q", tok.value)
+	return nil
+}
+
+// next reads the following token into p.tok.
+func (p *parser) next() {
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == '\n':
+			p.pos++
+			p.line, p.col = p.line+1, 1
+		case c == ' ' || c == '\t' || c == '\r' || c == ',':
+			p.advance(1)
+		case c == '#':
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		case strings.HasPrefix(p.src[p.pos:], "\xef\xbb\xbf"):
+			p.advance(3)
+		default:
+			p.scan()
+			return
+		}
+	}
+	p.tok = token{kind: tokenEOF, value: "<end>", line: p.line, column: p.col}
+}
+
+func (p *parser) advance(n int) {
+	p.pos += n
+	p.col += n
+}
+
+func (p *parser) scan() {
+	start, line, col := p.pos, p.line, p.col
+	c := p.src[p.pos]
+	p.tok = token{line: line, column: col}
+	switch {
+	case strings.HasPrefix(p.src[p.pos:], "..."):
+		p.advance(3)
+		p.tok.kind, p.tok.value = tokenPunct, "..."
+	case strings.IndexByte("!$()[]{}:=@|&", c) >= 0:
+		p.advance(1)
+		p.tok.kind, p.tok.value = tokenPunct, string(c)
+	case c == '_' || isLetter(c):
+		for p.pos < len(p.src) && (p.src[p.pos] == '_' || isLetter(p.src[p.pos]) || isDigit(p.src[p.pos])) {
+			p.advance(1)
+		}
+		p.tok.kind, p.tok.value = tokenName, p.src[start:p.pos]
+	case c == '-' || isDigit(c):
+		p.number()
+	case c == '"':
+		p.tok.kind = tokenString
+		if strings.HasPrefix(p.src[p.pos:], `"""`) {
+			p.tok.value = p.blockString()
+		} else {
+			p.tok.value = p.quotedString()
+		}
+	default:
+		r, _ := utf8.DecodeRuneInString(p.src[p.pos:])
+		p.tok.value = string(r)
+		p.fail("unexpected character %q", r)
+	}
+}
+
+func (p *parser) number() {
+	start := p.pos
+	digits := func() int {
+		n := 0
+		for p.pos < len(p.src) && isDigit(p.src[p.pos]) {
+			p.advance(1)
+			n++
+		}
+		return n
+	}
+	p.tok.kind = tokenInt
+	if p.src[p.pos] == '-' {
+		p.advance(1)
+	}
+	if digits() == 0 {
+		p.fail("invalid number")
+	}
+	if p.pos < len(p.src) && p.src[p.pos] == '.' {
+		p.advance(1)
+		p.tok.kind = tokenFloat
+		if digits() == 0 {
+			p.fail("invalid number")
+		}
+	}
+	if p.pos < len(p.src) && (p.src[p.pos] == 'e' || p.src[p.pos] == 'E') {
+		p.advance(1)
+		p.tok.kind = tokenFloat
+		if p.pos < len(p.src) && (p.src[p.pos] == '+' || p.src[p.pos] == '-') {
+			p.advance(1)
+		}
+		if digits() == 0 {
+			p.fail("invalid number")
+		}
+	}
+	if p.pos < len(p.src) && (p.src[p.pos] == '_' || p.src[p.pos] == '.' || isLetter(p.src[p.pos])) {
+		p.fail("invalid number")
+	}
+	p.tok.value = p.src[start:p.pos]
+}
+
+func (p *parser) quotedString() string {
+	p.advance(1)
+	var b strings.Builder
+	for {
+		if p.pos >= len(p.src) || p.src[p.pos] == '\n' {
+			p.fail("unterminated string")
+		}
+		c := p.src[p.pos]
+		switch c {
+		case '"':
+			p.advance(1)
+			return b.String()
+		case '\\':
+			if p.pos+1 >= len(p.src) {
+				p.fail("unterminated string")
+			}
+			esc := p.src[p.pos+1]
+			p.advance(2)
+			switch esc {
+			case '"', '\\', '/':
+				b.WriteByte(esc)
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'u':
+				if p.pos+4 > len(p.src) {
+					p.fail("invalid unicode escape")
+				}
+				r, err := strconv.ParseUint(p.src[p.pos:p.pos+4], 16, 32)
+				if err != nil {
+					p.fail("invalid unicode escape")
+				}
+				p.advance(4)
+				b.WriteRune(rune(r))
+			default:
+				p.fail("invalid escape \\%c", esc)
+			}
+		default:
+			_, size := utf8.DecodeRuneInString(p.src[p.pos:])
+			b.WriteString(p.src[p.pos : p.pos+size])
+			p.advance(size)
+		}
+	}
+}
+
+// blockString reads a """ string, removing its common indentation.
+func (p *parser) blockString() string {
+	p.advance(3)
+	var raw strings.Builder
+	for {
+		if p.pos >= len(p.src) {
+			p.fail("unterminated string")
+		}
+		switch {
+		case strings.HasPrefix(p.src[p.pos:], `"""`):
+			p.advance(3)
+			return dedent(raw.String())
+		case strings.HasPrefix(p.src[p.pos:], `\"""`):
+			raw.WriteString(`"""`)
+			p.advance(4)
+		case p.src[p.pos] == '\n':
+			raw.WriteByte('\n')
+			p.pos++
+			p.line, p.col = p.line+1, 1
+		default:
+			raw.WriteByte(p.src[p.pos])
+			p.advance(1)
+		}
+	}
+}
+
+func dedent(raw string) string {
+	lines := strings.Split(strings.ReplaceAll(raw, "\r\n", "\n"), "\n")
+	common := -1
+	for _, line := range lines[1:] {
+		indent := len(line) - len(strings.TrimLeft(line, " \t"))
+		if indent < len(line) && (common < 0 || indent < common) {
+			common = indent
+		}
+	}
+	if common > 0 {
+		for i := 1; i < len(lines); i++ {
+			if len(lines[i]) >= common {
+				lines[i] = lines[i][common:]
+			} else {
+				lines[i] = ""
+			}
+		}
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n")
+}
+
+func isLetter(c byte) bool { return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' }
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
@@ -0,0 +1,121 @@
+// Package hook consults an external decision service, such as an Open
+// Policy Agent, before a check is evaluated.
+package hook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// ErrUnavailable covers hook calls that failed or got an answer that could
+// not be used.
+var ErrUnavailable = errors.New("decision hook unavailable")
+
+// Decisions a hook may force instead of letting the limit decide.
+const (
+	Allow = "allow"
+	Deny  = "deny"
+)
+
+// maxResponseBytes bounds how much of a hook's answer is read.
+const maxResponseBytes = 64 << 10
+
+// Options configures a Hook.
+type Options struct {
+	// URL receives a POST of {"input": ...} and answers {"result": ...},
+	// as OPA's data API does.
+	URL string
+	// Timeout bounds each call; 0 means 100ms.
+	Timeout time.Duration
+	Client  *http.Client
+}
+
+// Result is a hook's answer. Zero fields leave the check as it was sent;
+// a missing result, as OPA gives for an undefined rule, changes nothing.
+type Result struct {
+	// Decision, when "allow" or "deny", settles the check without
+	// consulting its limit; RetryAfterMs is then reported for a denial.
+	Decision     string `json:"decision,omitempty"`
+	RetryAfterMs int64  `json:"retry_after_ms,omitempty"`
+	// Cost replaces the check's cost.
+	Cost int64 `json:"cost,omitempty"`
+	// Policy replaces the check's algorithm and parameters.
+	Policy *Policy `json:"policy,omitempty"`
+}
+
+// Policy is the limit a hook selects for a check. Name, if given, is
+// echoed in the check's response as the policy applied.
+type Policy struct {
+	Name         string  `json:"name,omitempty"`
+	Algorithm    string  `json:"algorithm"`
+	Limit        int64   `json:"limit,omitempty"`
+	WindowMs     int64   `json:"window_ms,omitempty"`
+	Capacity     int64   `json:"capacity,omitempty"`
+	RefillPerSec float64 `json:"refill_per_sec,omitempty"`
+	LeakPerSec   float64 `json:"leak_per_sec,omitempty"`
+}
+
+type Hook struct {
+	opts   Options
+	client *http.Client
+}
+
+func New(opts Options) *Hook {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 100 * time.Millisecond
+	}
+	client := opts.Client
+	if client == nil {
+		client = &http.Client{}
+	}
+	return &Hook{opts: opts, client: client}
+}
+
+// Decide sends input to the hook and returns its result.
+func (h *Hook) Decide(ctx context.Context, input interface{}) (Result, error) {
+	body, err := json.Marshal(struct {
+		Input interface{} `json:"input"`
+	}{input})
+	if err != nil {
+		return Result{}, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, h.opts.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.opts.URL, bytes.NewReader(body))
+	if err != nil {
+		return Result{}, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return Result{}, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Result{}, fmt.Errorf("%w: %s", ErrUnavailable, resp.Status)
+	}
+	var answer struct {
+		Result *Result `json:"result"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseBytes)).Decode(&answer); err != nil {
+		return Result{}, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	if answer.Result == nil {
+		return Result{}, nil
+	}
+	switch answer.Result.Decision {
+	case "", Allow, Deny:
+	default:
+		return Result{}, fmt.Errorf("%w: decision %q is not allow or deny", ErrUnavailable, answer.Result.Decision)
+	}
+	if answer.Result.Cost < 0 || answer.Result.RetryAfterMs < 0 {
+		return Result{}, fmt.Errorf("%w: negative cost or retry_after_ms", ErrUnavailable)
+	}
+	return *answer.Result, nil
+}
@@ -0,0 +1,122 @@
+package httpapi
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"rate-limiter-service/internal/audit"
+	"rate-limiter-service/internal/backend"
+)
+
+// AdjustRequest names a key and its limit parameters as a check would.
+// A positive Amount grants that much cost back for the current period and
+// a negative one uses it up; Cost is ignored.
+type AdjustRequest struct {
+	CheckRequest
+	Amount int64  `json:"amount"`
+	Reason string `json:"reason"`
+}
+
+// Adjust changes how much of a key's allowance is used, for one-off
+// arrangements such as a customer's extra quota, and audits why. The
+// response describes the key afterwards, as a peek would.
+func (h *Handler) Adjust(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "method_not_allowed"})
+		return
+	}
+	adjuster, ok := h.backend.(backend.Adjuster)
+	if !ok {
+		writeJSON(w, http.StatusNotImplemented, ErrorResponse{Error: "adjust_unavailable"})
+		return
+	}
+	opts := h.opts.Load()
+	body := getBuffer()
+	defer putBuffer(body)
+	if !readBody(w, r, body, opts.MaxBodyBytes) {
+		return
+	}
+	var req AdjustRequest
+	if err := decodeRequest(body.Bytes(), &req, opts.StrictRequests); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	req.Cost = 0
+	if code := h.normalize(r, &req.CheckRequest, opts); code != "" {
+		writeJSON(w, requestErrorStatus(code), ErrorResponse{Error: code})
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	switch {
+	case req.Algorithm == backend.SlidingWindowLog:
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "algorithm_not_adjustable",
+			Message: "sliding_window_log keeps no count to adjust"})
+		return
+	case req.Algorithm == backend.CountMinSketch:
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "algorithm_not_adjustable",
+			Message: "count_min_sketch counters are shared by many keys"})
+		return
+	case req.Algorithm == backend.WeightedFairShare:
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "algorithm_not_adjustable",
+			Message: "weighted_fair_share usage is shared between clients"})
+		return
+	case req.Amount == 0:
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "amount_required"})
+		return
+	case req.Reason == "":
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "reason_required"})
+		return
+	}
+
+	ctx, cancel := backendContext(r.Context(), opts.BackendTimeout)
+	defer cancel()
+	res, err := adjuster.Adjust(ctx, toBackendRequest(&req.CheckRequest), req.Amount)
+	h.recordAdjust(r, req, err)
+	if errors.Is(err, backend.ErrAdjustUnsupported) {
+		writeJSON(w, http.StatusNotImplemented, ErrorResponse{Error: "adjust_unavailable"})
+		return
+	}
+	if err != nil {
+		status, code := backendFailure(ctx, err)
+		writeJSON(w, status, ErrorResponse{Error: code})
+		return
+	}
+	h.invalidate(ctx, backend.Invalidation{Kind: backend.InvalidateKey, Key: tenantKey(req.Tenant, req.Key)})
+	writeJSON(w, http.StatusOK, CheckResponse{
+		Key:           req.Key,
+		Tenant:        req.Tenant,
+		Algorithm:     req.Algorithm,
+		Allowed:       res.Allowed,
+		Remaining:     res.Remaining,
+		ResetAtMs:     res.ResetAtMs,
+		CurrentCount:  res.CurrentCount,
+		ComputedCount: res.ComputedCount,
+	})
+}
+
+func (h *Handler) recordAdjust(r *http.Request, req AdjustRequest, err error) {
+	if h.audit == nil {
+		return
+	}
+	entry := audit.Entry{
+		Actor:  actorOf(r),
+		Action: "key.adjust",
+		Tenant: req.Tenant,
+		Target: req.Key,
+		After: map[string]string{
+			"algorithm": req.Algorithm,
+			"amount":    strconv.FormatInt(req.Amount, 10),
+			"reason":    req.Reason,
+		},
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	if auditErr := h.audit.Record(entry); auditErr != nil {
+		log.Printf("audit record failed: %v", auditErr)
+	}
+}
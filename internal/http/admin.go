@@ -0,0 +1,84 @@
+package httpapi
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"rate-limiter-service/internal/audit"
+)
+
+// Reload re-reads the configuration through the function installed with
+// OnReload and applies whatever can change without a restart.
+func (h *Handler) Reload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "method_not_allowed"})
+		return
+	}
+	if h.reload == nil {
+		writeJSON(w, http.StatusNotImplemented, ErrorResponse{Error: "reload_unavailable"})
+		return
+	}
+	// The configuration spans every tenant.
+	if tenantOf(r) != "" {
+		writeJSON(w, http.StatusForbidden, ErrorResponse{Error: "tenant_forbidden"})
+		return
+	}
+	if err := h.reload(actorOf(r)); err != nil {
+		log.Printf("config reload failed: %v", err)
+		writeJSON(w, http.StatusUnprocessableEntity, ErrorResponse{Error: "reload_failed", Message: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "reloaded"})
+}
+
+// Audit lists recorded admin actions, oldest first. Query parameters actor,
+// action, tenant and since_ms filter them; limit (default 100, at most 1000)
+// keeps the newest. Callers bound to a tenant only see its entries.
+func (h *Handler) Audit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "method_not_allowed"})
+		return
+	}
+	if h.audit == nil {
+		writeJSON(w, http.StatusNotImplemented, ErrorResponse{Error: "audit_unavailable"})
+		return
+	}
+	query := r.URL.Query()
+	filter := audit.Filter{Actor: query.Get("actor"), Action: query.Get("action"), Tenant: query.Get("tenant"), Limit: 100}
+	if tenant := tenantOf(r); tenant != "" {
+		if filter.Tenant != "" && filter.Tenant != tenant {
+			writeJSON(w, http.StatusForbidden, ErrorResponse{Error: "tenant_forbidden"})
+			return
+		}
+		filter.Tenant = tenant
+	}
+	if v := query.Get("since_ms"); v != "" {
+		since, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid_since_ms"})
+			return
+		}
+		filter.SinceMs = since
+	}
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > 1000 {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid_limit"})
+			return
+		}
+		filter.Limit = limit
+	}
+	entries, err := h.audit.Query(filter)
+	if err != nil {
+		log.Printf("audit query failed: %v", err)
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "audit_error"})
+		return
+	}
+	if entries == nil {
+		entries = []audit.Entry{}
+	}
+	writeJSON(w, http.StatusOK, AuditResponse{Entries: entries})
+}
@@ -0,0 +1,208 @@
+package httpapi
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Roles a caller can hold, from least to most privileged; each role may do
+// everything the ones before it can. Viewers read admin state, operators
+// also change limiter state, admins also change configuration. A role
+// written role@tenant is confined to that tenant.
+const (
+	RoleCheck    = "check"
+	RoleViewer   = "viewer"
+	RoleOperator = "operator"
+	RoleAdmin    = "admin"
+)
+
+var roleRank = map[string]int{RoleCheck: 1, RoleViewer: 2, RoleOperator: 3, RoleAdmin: 4}
+
+// apiKeys maps key digests to roles so that looking a key up does not
+// compare secrets byte by byte.
+type apiKeys map[[sha256.Size]byte]string
+
+func newAPIKeys(keys map[string]string) apiKeys {
+	if len(keys) == 0 {
+		return nil
+	}
+	out := make(apiKeys, len(keys))
+	for key, role := range keys {
+		out[sha256.Sum256([]byte(key))] = role
+	}
+	return out
+}
+
+// requireRole wraps next so that it only runs for callers holding role,
+// once any API keys, client certificate names or signing keys are
+// configured. A caller may hold a role through its API key, its verified
+// client certificate or its request signature; the credential that grants
+// it becomes the request's actor and sets its tenant, if bound to one.
+func (h *Handler) requireRole(role string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		opts := h.opts.Load()
+		if opts.apiKeys == nil && len(opts.ClientRoles) == 0 && len(opts.SigningKeys) == 0 {
+			if role != RoleCheck {
+				r = withActor(r, "anonymous")
+			}
+			next(w, r)
+			return
+		}
+		sigRole, code := h.signedRole(r, opts)
+		if code != "" {
+			status := http.StatusUnauthorized
+			if code == "body_too_large" {
+				status = http.StatusRequestEntityTooLarge
+			}
+			writeJSON(w, status, ErrorResponse{Error: code})
+			return
+		}
+		digest := sha256.Sum256([]byte(apiKey(r, opts.APIKeyHeader)))
+		keyRole := opts.apiKeys[digest]
+		certRole, san := clientRole(r, opts.ClientRoles)
+		if keyRole == "" && certRole == "" && sigRole == "" {
+			w.Header().Set("WWW-Authenticate", "ApiKey")
+			writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "unauthorized"})
+			return
+		}
+		if !grants(sigRole, role) && !grants(certRole, role) && !grants(keyRole, role) {
+			writeJSON(w, http.StatusForbidden, ErrorResponse{Error: "forbidden"})
+			return
+		}
+		held := keyRole
+		switch {
+		case grants(sigRole, role):
+			held = sigRole
+		case grants(certRole, role):
+			held = certRole
+		}
+		if _, tenant, bound := strings.Cut(held, "@"); bound {
+			r = r.WithContext(context.WithValue(r.Context(), boundTenantKey{}, tenant))
+		}
+		r = r.WithContext(context.WithValue(r.Context(), roleKey{}, held))
+		// Checks are never audited, so they skip naming the actor.
+		if role != RoleCheck {
+			var actor string
+			switch {
+			case grants(sigRole, role):
+				actor = "signature:" + r.Header.Get(signatureKeyHeader)
+			case grants(certRole, role):
+				actor = "cert:" + san
+			default:
+				actor = "key:" + keyID(digest)
+			}
+			r = withActor(r, actor)
+		}
+		next(w, r)
+	}
+}
+
+// keyID names an API key in logs without revealing it.
+func keyID(digest [sha256.Size]byte) string {
+	return hex.EncodeToString(digest[:8])
+}
+
+type actorKey struct{}
+
+type boundTenantKey struct{}
+
+type roleKey struct{}
+
+// withActor records who r acts as, qualified by its source address.
+func withActor(r *http.Request, actor string) *http.Request {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		actor += "@" + host
+	}
+	return r.WithContext(context.WithValue(r.Context(), actorKey{}, actor))
+}
+
+func actorOf(r *http.Request) string {
+	actor, _ := r.Context().Value(actorKey{}).(string)
+	return actor
+}
+
+// tenantOf returns the tenant r's credential is confined to, if any.
+func tenantOf(r *http.Request) string {
+	tenant, _ := r.Context().Value(boundTenantKey{}).(string)
+	return tenant
+}
+
+// permits reports whether r's credential holds role, as requireRole found
+// it; with authentication off every role is held.
+func permits(r *http.Request, role string, opts *Options) bool {
+	if opts.apiKeys == nil && len(opts.ClientRoles) == 0 && len(opts.SigningKeys) == 0 {
+		return true
+	}
+	held, _ := r.Context().Value(roleKey{}).(string)
+	return grants(held, role)
+}
+
+func grants(held, wanted string) bool {
+	return rank(held) >= roleRank[wanted]
+}
+
+func rank(role string) int {
+	role, _, _ = strings.Cut(role, "@")
+	return roleRank[role]
+}
+
+// clientRole returns the highest role granted to any DNS, URI or email SAN
+// of the verified client certificate, and that SAN. The SAN is empty when
+// no role is granted.
+func clientRole(r *http.Request, roles map[string]string) (string, string) {
+	if len(roles) == 0 || r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return "", ""
+	}
+	leaf := r.TLS.VerifiedChains[0][0]
+	names := append([]string{}, leaf.DNSNames...)
+	names = append(names, leaf.EmailAddresses...)
+	for _, uri := range leaf.URIs {
+		names = append(names, uri.String())
+	}
+	best, san := "", ""
+	for _, name := range names {
+		if role, ok := roles[name]; ok && rank(role) > rank(best) {
+			best, san = role, name
+		}
+	}
+	return best, san
+}
+
+// allowNetworks wraps next so that it only runs for sources inside
+// opts.AdminNetworks, when any are configured. It checks the connection's
+// address, never forwarding headers, so it holds even if authentication is
+// misconfigured.
+func (h *Handler) allowNetworks(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		networks := h.opts.Load().AdminNetworks
+		if len(networks) == 0 {
+			next(w, r)
+			return
+		}
+		if addr, err := netip.ParseAddrPort(r.RemoteAddr); err == nil {
+			ip := addr.Addr().Unmap()
+			for _, network := range networks {
+				if network.Contains(ip) {
+					next(w, r)
+					return
+				}
+			}
+		}
+		writeJSON(w, http.StatusForbidden, ErrorResponse{Error: "network_not_allowed"})
+	}
+}
+
+// apiKey reads "Authorization: ApiKey <key>" or, failing that, header.
+// Bearer tokens are left alone: they are JWTs used for keying.
+func apiKey(r *http.Request, header string) string {
+	if scheme, key, ok := strings.Cut(strings.TrimSpace(r.Header.Get("Authorization")), " "); ok &&
+		strings.EqualFold(scheme, "apikey") {
+		return strings.TrimSpace(key)
+	}
+	return strings.TrimSpace(r.Header.Get(header))
+}
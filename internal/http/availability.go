@@ -0,0 +1,101 @@
+package httpapi
+
+import (
+	"errors"
+	"net/http"
+
+	"rate-limiter-service/internal/backend"
+)
+
+// AvailabilityResponse tells when a key will have Units of cost available,
+// so a scheduler can plan work around its quota. It assumes no other
+// traffic on the key until then.
+type AvailabilityResponse struct {
+	Key       string `json:"key"`
+	Tenant    string `json:"tenant,omitempty"`
+	Algorithm string `json:"algorithm"`
+	Units     int64  `json:"units"`
+	// Available is true when Units could be charged now; otherwise WaitMs
+	// is how long until they can, at AvailableAtMs.
+	Available     bool  `json:"available"`
+	AvailableAtMs int64 `json:"available_at_ms"`
+	WaitMs        int64 `json:"wait_ms"`
+	Remaining     int64 `json:"remaining"`
+}
+
+// Availability answers when the cost of the check in the body will be
+// available for its key, from the key's current state, without charging
+// anything. It takes the same body as Peek, with the cost as the units
+// asked about.
+func (h *Handler) Availability(w http.ResponseWriter, r *http.Request) {
+	if !h.admit(w) {
+		return
+	}
+	defer h.drain.leave()
+	opts := h.opts.Load()
+	body := getBuffer()
+	defer putBuffer(body)
+	if !readBody(w, r, body, opts.MaxBodyBytes) {
+		return
+	}
+	req := getCheckRequest()
+	defer putCheckRequest(req)
+	if err := decodeCheckRequest(body.Bytes(), req, opts.StrictRequests); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	if code := consult(r, req, opts); code != "" {
+		writeJSON(w, requestErrorStatus(code), ErrorResponse{Error: code})
+		return
+	}
+	if code := h.normalize(r, req, opts); code != "" {
+		writeJSON(w, requestErrorStatus(code), ErrorResponse{Error: code})
+		return
+	}
+	nowMs := opts.Clock.Now().UnixMilli()
+	if resp, _, ok := settled(req); ok {
+		writeJSON(w, http.StatusOK, availability(req, backend.Result{Allowed: resp.Allowed, RetryAfterMs: resp.RetryAfterMs}, nowMs))
+		return
+	}
+	peeker, ok := h.backend.(backend.Peeker)
+	if !ok {
+		writeJSON(w, http.StatusNotImplemented, ErrorResponse{Error: "peek_unavailable"})
+		return
+	}
+	parent, ok := readContext(r)
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid_consistency"})
+		return
+	}
+
+	ctx, cancel := backendContext(parent, opts.BackendTimeout)
+	defer cancel()
+	res, err := peekResult(ctx, peeker, req)
+	if errors.Is(err, backend.ErrPeekUnsupported) {
+		writeJSON(w, http.StatusNotImplemented, ErrorResponse{Error: "peek_unavailable"})
+		return
+	}
+	if err != nil {
+		status, code := backendFailure(ctx, err)
+		writeJSON(w, status, ErrorResponse{Error: code})
+		return
+	}
+	writeJSON(w, http.StatusOK, availability(req, res, nowMs))
+}
+
+func availability(req *CheckRequest, res backend.Result, nowMs int64) AvailabilityResponse {
+	wait := res.RetryAfterMs
+	if res.Allowed {
+		wait = 0
+	}
+	return AvailabilityResponse{
+		Key:           req.Key,
+		Tenant:        req.Tenant,
+		Algorithm:     req.Algorithm,
+		Units:         req.Cost,
+		Available:     res.Allowed,
+		AvailableAtMs: nowMs + wait,
+		WaitMs:        wait,
+		Remaining:     res.Remaining,
+	}
+}
@@ -0,0 +1,206 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+
+	"rate-limiter-service/internal/backend"
+)
+
+// CheckBatch evaluates several independent checks in one call. Each item
+// carries its own status; the batch itself only fails on malformed input.
+func (h *Handler) CheckBatch(w http.ResponseWriter, r *http.Request) {
+	if !h.admit(w) {
+		return
+	}
+	defer h.drain.leave()
+	opts := h.opts.Load()
+	body := getBuffer()
+	defer putBuffer(body)
+	if !readBody(w, r, body, opts.MaxBodyBytes) {
+		return
+	}
+	var batch BatchCheckRequest
+	if err := decodeRequest(body.Bytes(), &batch, opts.StrictRequests); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	if len(batch.Items) == 0 {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "items_required"})
+		return
+	}
+	if len(batch.Items) > opts.BatchMaxItems {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "too_many_items"})
+		return
+	}
+
+	ctx, cancel := backendContext(r.Context(), opts.BackendTimeout)
+	defer cancel()
+	out := make([]BatchItemResponse, len(batch.Items))
+	pending := make([]int, 0, len(batch.Items))
+	reqs := make([]backend.Request, 0, len(batch.Items))
+	// previous maps items with a key under the rotated-out hash secret to
+	// the index of that extra request, appended after the others.
+	var previous map[int]int
+	// finish answers an item from its decision or error.
+	finish := func(i int, res backend.Result, err error) {
+		item := &batch.Items[i]
+		out[i] = h.batchItemResponse(ctx, item, opts, res, err)
+		if out[i].Error == "" {
+			h.recordDecision(item, out[i].Allowed)
+			h.recordHistory(item, out[i].Allowed, out[i].Remaining, opts)
+			h.hot.record(item, opts)
+			h.events.observe(item, out[i].Allowed, out[i].Remaining, out[i].ResetAtMs, opts)
+			h.replicate(item, out[i].Allowed, out[i].Degraded)
+		}
+	}
+	// The batch waits once for the latency injected into any item.
+	latency := false
+	for i := range batch.Items {
+		item := &batch.Items[i]
+		code := consult(r, item, opts)
+		if code == "" {
+			code = h.normalize(r, item, opts)
+		}
+		if code != "" {
+			out[i] = BatchItemResponse{
+				CheckResponse: CheckResponse{Key: item.Key, Tenant: item.Tenant, Algorithm: item.Algorithm},
+				Status:        requestErrorStatus(code),
+				Error:         code,
+			}
+			continue
+		}
+		if resp, status, ok := settled(item); ok {
+			out[i] = BatchItemResponse{CheckResponse: resp, Status: status}
+			continue
+		}
+		faults := opts.Chaos.roll()
+		item.chaos = faults.tag()
+		latency = latency || faults.latency
+		if faults.fail || faults.deny {
+			if faults.fail {
+				finish(i, backend.Result{}, errChaos)
+			} else {
+				finish(i, chaosDenial(), nil)
+			}
+			continue
+		}
+		if err := h.trackKey(ctx, item, opts); err != nil {
+			out[i] = h.batchItemResponse(ctx, item, opts, backend.Result{}, err)
+			if out[i].Error == "tenant_key_limit" {
+				h.recordDecision(item, false)
+			}
+			continue
+		}
+		h.newKeys.mark(item, opts)
+		pending = append(pending, i)
+		reqs = append(reqs, toBackendRequest(item))
+	}
+	for _, i := range pending {
+		if item := &batch.Items[i]; item.previousKey != "" {
+			if previous == nil {
+				previous = make(map[int]int)
+			}
+			extra := toBackendRequest(item)
+			extra.Key = tenantKey(item.Tenant, item.previousKey)
+			previous[i] = len(reqs)
+			reqs = append(reqs, extra)
+		}
+	}
+
+	if latency {
+		// A wait past the timeout fails the batch's backend calls.
+		chaosWait(ctx, opts.Chaos.Latency)
+	}
+	results, errs := h.evaluateBatch(ctx, reqs, opts.BatchConcurrency)
+	for j, i := range pending {
+		item := &batch.Items[i]
+		h.compareCheck(item, reqs[j], results[j], errs[j])
+		if k, ok := previous[i]; ok && errs[j] == nil && errs[k] == nil {
+			results[j] = stricter(results[j], results[k])
+		}
+		if errs[j] == nil {
+			h.shadow.observe(item, results[j], opts)
+			results[j] = enforce(item, h.graceful(ctx, item, results[j], opts))
+		}
+		finish(i, results[j], errs[j])
+	}
+
+	writeJSON(w, http.StatusOK, BatchCheckResponse{Results: out})
+}
+
+func (h *Handler) evaluateBatch(ctx context.Context, reqs []backend.Request, concurrency int) ([]backend.Result, []error) {
+	if batcher, ok := h.backend.(backend.BatchBackend); ok {
+		return batcher.AllowBatch(ctx, reqs)
+	}
+
+	results := make([]backend.Result, len(reqs))
+	errs := make([]error, len(reqs))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i := range reqs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			results[i], errs[i] = backend.Evaluate(ctx, h.backend, reqs[i])
+		}(i)
+	}
+	wg.Wait()
+	return results, errs
+}
+
+func (h *Handler) batchItemResponse(ctx context.Context, req *CheckRequest, opts *Options, res backend.Result, err error) BatchItemResponse {
+	item := BatchItemResponse{
+		CheckResponse: CheckResponse{Key: req.Key, Tenant: req.Tenant, Algorithm: req.Algorithm, Chaos: req.chaos},
+	}
+	degraded := false
+	if errors.Is(err, errKeyLimit) {
+		item.Status, item.Error = http.StatusTooManyRequests, "tenant_key_limit"
+		return item
+	}
+	if err != nil {
+		var ok bool
+		if res, ok = h.substitute(req, opts, err); !ok {
+			item.Status, item.Error = backendFailure(ctx, err)
+			return item
+		}
+		degraded = true
+	}
+	item.CheckResponse = CheckResponse{
+		Key:                 req.Key,
+		Tenant:              req.Tenant,
+		Algorithm:           req.Algorithm,
+		Allowed:             res.Allowed,
+		Remaining:           res.Remaining,
+		ResetAtMs:           res.ResetAtMs,
+		RetryAfterMs:        jitter(res.RetryAfterMs, opts),
+		SuggestedIntervalMs: suggestedInterval(req, res, degraded, opts),
+		CurrentCount:        res.CurrentCount,
+		ComputedCount:       res.ComputedCount,
+		ParamsChanged:       res.ParamsChanged,
+		ErrorBound:          res.ErrorBound,
+		Group:               groupResponse(req, res),
+		Dimensions:          dimensionResponses(req, res),
+		Multiplier:          req.multiplier,
+		Grace:               res.Grace,
+		ShadowMode:          req.shadowMode,
+		RecentHitsMs:        h.recentHits(backend.WithPrimaryReads(ctx), req),
+		Degraded:            degraded,
+		MemoryPressure:      h.memoryPressure(),
+		Chaos:               req.chaos,
+		DecisionID:          decisionID(req, res, degraded, opts),
+	}
+	describeLimit(&item.CheckResponse, req)
+	item.Status = http.StatusOK
+	if !res.Allowed {
+		item.Status = http.StatusTooManyRequests
+		opts.scoped(req.Tenant).DenyBody.fill(&item.CheckResponse, req)
+	}
+	return item
+}
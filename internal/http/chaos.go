@@ -0,0 +1,94 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"strings"
+	"time"
+
+	"rate-limiter-service/internal/backend"
+)
+
+// ChaosPolicy injects faults into checks, so that client teams can test
+// how they handle a slow, failing or denying limiter against a real
+// server. Each rate is the fraction of checks that get the fault; a check
+// gets at most one of an error and a denial.
+type ChaosPolicy struct {
+	// Latency is added before the backend is called, within the backend
+	// timeout.
+	Latency     time.Duration
+	LatencyRate float64
+	// ErrorRate fails checks as a backend error would, so the fail mode
+	// decides them.
+	ErrorRate float64
+	// DenyRate denies checks without charging them.
+	DenyRate float64
+}
+
+// Chaos fault names, listed in a response's chaos field.
+const (
+	chaosLatency = "latency"
+	chaosError   = "error"
+	chaosDeny    = "deny"
+)
+
+// chaosRetryAfterMs is the wait a forced denial asks for.
+const chaosRetryAfterMs = 1000
+
+var errChaos = errors.New("injected backend error")
+
+// chaosFaults rolls the faults of one check.
+type chaosFaults struct {
+	latency, fail, deny bool
+}
+
+func (p *ChaosPolicy) roll() chaosFaults {
+	var f chaosFaults
+	if p == nil {
+		return f
+	}
+	f.latency = p.Latency > 0 && rand.Float64() < p.LatencyRate
+	switch r := rand.Float64(); {
+	case r < p.ErrorRate:
+		f.fail = true
+	case r < p.ErrorRate+p.DenyRate:
+		f.deny = true
+	}
+	return f
+}
+
+// tag lists the faults, for the response.
+func (f chaosFaults) tag() string {
+	var names []string
+	if f.latency {
+		names = append(names, chaosLatency)
+	}
+	if f.fail {
+		names = append(names, chaosError)
+	}
+	if f.deny {
+		names = append(names, chaosDeny)
+	}
+	return strings.Join(names, ",")
+}
+
+// chaosDenial is the decision of a check the chaos policy denies.
+func chaosDenial() backend.Result {
+	return backend.Result{
+		ResetAtMs:    time.Now().UnixMilli() + chaosRetryAfterMs,
+		RetryAfterMs: chaosRetryAfterMs,
+	}
+}
+
+// chaosWait sleeps for d, or until ctx is done.
+func chaosWait(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
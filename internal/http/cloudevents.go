@@ -0,0 +1,79 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Key event stream formats.
+const (
+	// EventsNative sends each event's own JSON.
+	EventsNative = "native"
+	// EventsCloudEvents wraps each event in a CloudEvents 1.0 envelope in
+	// structured JSON mode.
+	EventsCloudEvents = "cloudevents"
+)
+
+// CloudEvent is a CloudEvents 1.0 envelope. Tenant is an extension
+// attribute, set for keys of a tenant.
+type CloudEvent struct {
+	SpecVersion     string      `json:"specversion"`
+	ID              string      `json:"id"`
+	Source          string      `json:"source"`
+	Type            string      `json:"type"`
+	Time            string      `json:"time"`
+	Subject         string      `json:"subject,omitempty"`
+	DataContentType string      `json:"datacontenttype"`
+	Tenant          string      `json:"tenant,omitempty"`
+	Data            interface{} `json:"data"`
+}
+
+// eventsEpoch qualifies envelope ids, which must be unique per source,
+// since event ids start over when the process does.
+var eventsEpoch = strconv.FormatInt(time.Now().UnixMilli(), 36)
+
+// streamEventSeq numbers the events outside the key event sequence, such
+// as snapshots, across every stream.
+var streamEventSeq atomic.Int64
+
+// eventWriter writes server-sent events in one of the stream formats.
+type eventWriter struct {
+	w      io.Writer
+	format string
+	opts   *Options
+}
+
+// writeEvent sends one event of typ; id is 0 for events outside the key
+// event sequence, which get no SSE id.
+func (ew *eventWriter) writeEvent(id int64, typ, key, tenant string, timeMs int64, data interface{}) error {
+	if ew.format == EventsCloudEvents {
+		ceID := eventsEpoch + "-" + strconv.FormatInt(id, 10)
+		if id == 0 {
+			ceID = fmt.Sprintf("%s-%s-%d", eventsEpoch, typ, streamEventSeq.Add(1))
+		}
+		data = CloudEvent{
+			SpecVersion:     "1.0",
+			ID:              ceID,
+			Source:          ew.opts.CloudEventsSource,
+			Type:            ew.opts.CloudEventsTypePrefix + typ,
+			Time:            time.UnixMilli(timeMs).UTC().Format("2006-01-02T15:04:05.000Z"),
+			Subject:         key,
+			DataContentType: "application/json",
+			Tenant:          tenant,
+			Data:            data,
+		}
+	}
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if id != 0 {
+		fmt.Fprintf(ew.w, "id: %d\n", id)
+	}
+	_, err = fmt.Fprintf(ew.w, "event: %s\ndata: %s\n\n", typ, payload)
+	return err
+}
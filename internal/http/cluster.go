@@ -0,0 +1,64 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"rate-limiter-service/internal/cluster"
+)
+
+// SetCluster serves calls forwarded by the other members of c. With
+// redirect, single checks on keys owned elsewhere are answered with a 307
+// to the owner instead of being forwarded.
+func (h *Handler) SetCluster(c *cluster.Backend, redirect bool) {
+	h.cluster = c
+	h.clusterRedirect = redirect
+}
+
+// redirectToOwner sends the client to the owner of req's key and reports
+// whether it did.
+func (h *Handler) redirectToOwner(w http.ResponseWriter, r *http.Request, req *CheckRequest) bool {
+	if h.cluster == nil || !h.clusterRedirect {
+		return false
+	}
+	owner, self := h.cluster.Owner(tenantKey(req.Tenant, req.Key))
+	if self {
+		return false
+	}
+	w.Header().Set("Location", owner.URL+r.URL.RequestURI())
+	writeJSON(w, http.StatusTemporaryRedirect, map[string]string{"owner": owner.Name})
+	return true
+}
+
+// ClusterRPC performs a call another member forwarded for keys this
+// instance owns.
+func (h *Handler) ClusterRPC(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "method_not_allowed"})
+		return
+	}
+	if h.cluster == nil {
+		writeJSON(w, http.StatusNotImplemented, ErrorResponse{Error: "cluster_unavailable"})
+		return
+	}
+	// Calls carry keys of every tenant.
+	if tenantOf(r) != "" {
+		writeJSON(w, http.StatusForbidden, ErrorResponse{Error: "tenant_forbidden"})
+		return
+	}
+	opts := h.opts.Load()
+	body := getBuffer()
+	defer putBuffer(body)
+	if !readBody(w, r, body, opts.MaxBodyBytes) {
+		return
+	}
+	var call cluster.Call
+	if err := json.Unmarshal(body.Bytes(), &call); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid_json"})
+		return
+	}
+	ctx, cancel := backendContext(r.Context(), opts.BackendTimeout)
+	defer cancel()
+	writeJSON(w, http.StatusOK, h.cluster.Serve(ctx, call))
+}
@@ -0,0 +1,536 @@
+package httpapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+	"unicode/utf8"
+)
+
+// The check endpoint is the hot path, so requests and responses are coded by
+// hand instead of through encoding/json's reflection. The decoder only
+// handles the flat shape clients actually send; anything else (escaped
+// strings, unknown or nested fields) falls back to encoding/json so the
+// accepted input is exactly what it was before. A request the fast path
+// takes has no unknown fields or mistyped values, so only the slow path
+// needs checking when decoding strictly.
+
+var errSlowPath = errors.New("json fast path not applicable")
+
+func decodeCheckRequest(data []byte, req *CheckRequest, strict bool) error {
+	if err := decodeCheckRequestFast(data, req); err == nil {
+		return nil
+	}
+	*req = CheckRequest{}
+	if strict {
+		if err := checkFields(data, checkRequestType); err != nil {
+			return err
+		}
+	}
+	return json.NewDecoder(bytes.NewReader(data)).Decode(req)
+}
+
+func decodeCheckRequestFast(data []byte, req *CheckRequest) error {
+	d := fastDecoder{data: data}
+	if !d.consume('{') {
+		return errSlowPath
+	}
+	if d.consume('}') {
+		return d.end()
+	}
+	for {
+		name, ok := d.str()
+		if !ok || !d.consume(':') {
+			return errSlowPath
+		}
+		if !d.field(name, req) {
+			return errSlowPath
+		}
+		if d.consume(',') {
+			continue
+		}
+		if d.consume('}') {
+			return d.end()
+		}
+		return errSlowPath
+	}
+}
+
+type fastDecoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *fastDecoder) skipSpace() {
+	for d.pos < len(d.data) {
+		switch d.data[d.pos] {
+		case ' ', '\t', '\n', '\r':
+			d.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (d *fastDecoder) consume(c byte) bool {
+	d.skipSpace()
+	if d.pos < len(d.data) && d.data[d.pos] == c {
+		d.pos++
+		return true
+	}
+	return false
+}
+
+func (d *fastDecoder) end() error {
+	d.skipSpace()
+	if d.pos != len(d.data) {
+		return errSlowPath
+	}
+	return nil
+}
+
+// str returns the raw bytes of a string without escape sequences; strings
+// that need unescaping are left to the slow path.
+func (d *fastDecoder) str() ([]byte, bool) {
+	if !d.consume('"') {
+		return nil, false
+	}
+	start := d.pos
+	for d.pos < len(d.data) {
+		c := d.data[d.pos]
+		switch {
+		case c == '"':
+			s := d.data[start:d.pos]
+			d.pos++
+			return s, true
+		case c == '\\' || c < 0x20 || c >= utf8.RuneSelf:
+			return nil, false
+		}
+		d.pos++
+	}
+	return nil, false
+}
+
+// number scans a token matching the JSON number grammar, which is stricter
+// than strconv (no leading '+', no leading zeros, digits around '.').
+func (d *fastDecoder) number() ([]byte, bool) {
+	d.skipSpace()
+	start := d.pos
+	if d.peek() == '-' {
+		d.pos++
+	}
+	switch c := d.peek(); {
+	case c == '0':
+		d.pos++
+	case c >= '1' && c <= '9':
+		d.digits()
+	default:
+		return nil, false
+	}
+	if d.peek() == '.' {
+		d.pos++
+		if d.digits() == 0 {
+			return nil, false
+		}
+	}
+	if c := d.peek(); c == 'e' || c == 'E' {
+		d.pos++
+		if c := d.peek(); c == '+' || c == '-' {
+			d.pos++
+		}
+		if d.digits() == 0 {
+			return nil, false
+		}
+	}
+	return d.data[start:d.pos], true
+}
+
+func (d *fastDecoder) peek() byte {
+	if d.pos < len(d.data) {
+		return d.data[d.pos]
+	}
+	return 0
+}
+
+func (d *fastDecoder) digits() int {
+	n := 0
+	for c := d.peek(); c >= '0' && c <= '9'; c = d.peek() {
+		d.pos++
+		n++
+	}
+	return n
+}
+
+func (d *fastDecoder) null() bool {
+	d.skipSpace()
+	if bytes.HasPrefix(d.data[d.pos:], []byte("null")) {
+		d.pos += 4
+		return true
+	}
+	return false
+}
+
+func (d *fastDecoder) stringField(dst *string) bool {
+	if d.null() {
+		return true
+	}
+	s, ok := d.str()
+	if ok {
+		*dst = string(s)
+	}
+	return ok
+}
+
+func (d *fastDecoder) intField(dst *int64) bool {
+	if d.null() {
+		return true
+	}
+	raw, ok := d.number()
+	if !ok {
+		return false
+	}
+	v, err := strconv.ParseInt(string(raw), 10, 64)
+	if err != nil {
+		return false
+	}
+	*dst = v
+	return true
+}
+
+func (d *fastDecoder) floatField(dst *float64) bool {
+	if d.null() {
+		return true
+	}
+	raw, ok := d.number()
+	if !ok {
+		return false
+	}
+	v, err := strconv.ParseFloat(string(raw), 64)
+	if err != nil {
+		return false
+	}
+	*dst = v
+	return true
+}
+
+func (d *fastDecoder) boolField(dst *bool) bool {
+	if d.null() {
+		return true
+	}
+	d.skipSpace()
+	switch rest := d.data[d.pos:]; {
+	case bytes.HasPrefix(rest, []byte("true")):
+		*dst = true
+		d.pos += 4
+	case bytes.HasPrefix(rest, []byte("false")):
+		*dst = false
+		d.pos += 5
+	default:
+		return false
+	}
+	return true
+}
+
+func (d *fastDecoder) field(name []byte, req *CheckRequest) bool {
+	switch string(name) {
+	case "key":
+		return d.stringField(&req.Key)
+	case "tenant":
+		return d.stringField(&req.Tenant)
+	case "user_id":
+		return d.stringField(&req.UserID)
+	case "device_id":
+		return d.stringField(&req.DeviceID)
+	case "jwt":
+		return d.stringField(&req.JWT)
+	case "algorithm":
+		return d.stringField(&req.Algorithm)
+	case "limit":
+		return d.intField(&req.Limit)
+	case "window_ms":
+		return d.intField(&req.WindowMs)
+	case "capacity":
+		return d.intField(&req.Capacity)
+	case "refill_per_sec":
+		return d.floatField(&req.RefillPerSec)
+	case "leak_per_sec":
+		return d.floatField(&req.LeakPerSec)
+	case "max_debt":
+		return d.intField(&req.MaxDebt)
+	case "recent_hits":
+		var n int64
+		if !d.intField(&n) || n != int64(int(n)) {
+			return false
+		}
+		req.RecentHits = int(n)
+		return true
+	case "cost":
+		return d.intField(&req.Cost)
+	case "fail_mode":
+		return d.stringField(&req.FailMode)
+	case "timezone":
+		return d.stringField(&req.Timezone)
+	case "client":
+		return d.stringField(&req.Client)
+	case "weight":
+		return d.intField(&req.Weight)
+	case "group":
+		return d.stringField(&req.Group)
+	case "domain":
+		return d.stringField(&req.Domain)
+	case "reconcile":
+		return d.boolField(&req.Reconcile)
+	default:
+		return false
+	}
+}
+
+// jsonDepthExceeds reports whether arrays and objects in data nest deeper
+// than max, skipping over string contents.
+func jsonDepthExceeds(data []byte, max int) bool {
+	depth := 0
+	inString := false
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		if inString {
+			switch c {
+			case '\\':
+				i++
+			case '"':
+				inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+		case '{', '[':
+			depth++
+			if depth > max {
+				return true
+			}
+		case '}', ']':
+			depth--
+		}
+	}
+	return false
+}
+
+// appendCheckResponse produces the same bytes json.Encoder would for resp,
+// including the trailing newline.
+func appendCheckResponse(buf []byte, resp *CheckResponse) []byte {
+	buf = append(buf, `{"key":`...)
+	buf = appendJSONString(buf, resp.Key)
+	if resp.Tenant != "" {
+		buf = append(buf, `,"tenant":`...)
+		buf = appendJSONString(buf, resp.Tenant)
+	}
+	buf = append(buf, `,"algorithm":`...)
+	buf = appendJSONString(buf, resp.Algorithm)
+	if resp.Policy != "" {
+		buf = append(buf, `,"policy":`...)
+		buf = appendJSONString(buf, resp.Policy)
+	}
+	for _, f := range [...]struct {
+		name  string
+		value int64
+	}{
+		{`,"limit":`, resp.Limit},
+		{`,"window_ms":`, resp.WindowMs},
+		{`,"capacity":`, resp.Capacity},
+	} {
+		if f.value != 0 {
+			buf = append(buf, f.name...)
+			buf = strconv.AppendInt(buf, f.value, 10)
+		}
+	}
+	if resp.RefillPerSec != 0 {
+		buf = append(buf, `,"refill_per_sec":`...)
+		buf = appendJSONFloat(buf, resp.RefillPerSec)
+	}
+	if resp.LeakPerSec != 0 {
+		buf = append(buf, `,"leak_per_sec":`...)
+		buf = appendJSONFloat(buf, resp.LeakPerSec)
+	}
+	buf = append(buf, `,"allowed":`...)
+	buf = strconv.AppendBool(buf, resp.Allowed)
+	buf = append(buf, `,"remaining":`...)
+	buf = strconv.AppendInt(buf, resp.Remaining, 10)
+	buf = append(buf, `,"reset_at_ms":`...)
+	buf = strconv.AppendInt(buf, resp.ResetAtMs, 10)
+	buf = append(buf, `,"retry_after_ms":`...)
+	buf = strconv.AppendInt(buf, resp.RetryAfterMs, 10)
+	if resp.SuggestedIntervalMs != 0 {
+		buf = append(buf, `,"suggested_interval_ms":`...)
+		buf = strconv.AppendInt(buf, resp.SuggestedIntervalMs, 10)
+	}
+	if resp.CurrentCount != 0 {
+		buf = append(buf, `,"current_count":`...)
+		buf = strconv.AppendInt(buf, resp.CurrentCount, 10)
+	}
+	if resp.ComputedCount != 0 {
+		buf = append(buf, `,"computed_count":`...)
+		buf = strconv.AppendInt(buf, resp.ComputedCount, 10)
+	}
+	if resp.ParamsChanged {
+		buf = append(buf, `,"params_changed":true`...)
+	}
+	if resp.ErrorBound != 0 {
+		buf = append(buf, `,"error_bound":`...)
+		buf = strconv.AppendInt(buf, resp.ErrorBound, 10)
+	}
+	if g := resp.Group; g != nil {
+		buf = append(buf, `,"group":{"id":`...)
+		buf = appendJSONString(buf, g.ID)
+		buf = append(buf, `,"allowed":`...)
+		buf = strconv.AppendBool(buf, g.Allowed)
+		buf = append(buf, `,"remaining":`...)
+		buf = strconv.AppendInt(buf, g.Remaining, 10)
+		buf = append(buf, `,"reset_at_ms":`...)
+		buf = strconv.AppendInt(buf, g.ResetAtMs, 10)
+		buf = append(buf, '}')
+	}
+	if len(resp.Dimensions) > 0 {
+		buf = append(buf, `,"dimensions":[`...)
+		for i, d := range resp.Dimensions {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = append(buf, `{"name":`...)
+			buf = appendJSONString(buf, d.Name)
+			buf = append(buf, `,"allowed":`...)
+			buf = strconv.AppendBool(buf, d.Allowed)
+			buf = append(buf, `,"remaining":`...)
+			buf = strconv.AppendInt(buf, d.Remaining, 10)
+			buf = append(buf, `,"reset_at_ms":`...)
+			buf = strconv.AppendInt(buf, d.ResetAtMs, 10)
+			buf = append(buf, `,"retry_after_ms":`...)
+			buf = strconv.AppendInt(buf, d.RetryAfterMs, 10)
+			buf = append(buf, '}')
+		}
+		buf = append(buf, ']')
+	}
+	if resp.Grace {
+		buf = append(buf, `,"grace":true`...)
+	}
+	if len(resp.RecentHitsMs) > 0 {
+		buf = append(buf, `,"recent_hits_ms":[`...)
+		for i, ms := range resp.RecentHitsMs {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = strconv.AppendInt(buf, ms, 10)
+		}
+		buf = append(buf, ']')
+	}
+	if resp.Multiplier != 0 {
+		buf = append(buf, `,"multiplier":`...)
+		buf = appendJSONFloat(buf, resp.Multiplier)
+	}
+	if resp.Unlimited {
+		buf = append(buf, `,"unlimited":true`...)
+	}
+	if resp.ShadowMode {
+		buf = append(buf, `,"shadow_mode":true`...)
+	}
+	if resp.HookDecision != "" {
+		buf = append(buf, `,"hook_decision":`...)
+		buf = appendJSONString(buf, resp.HookDecision)
+	}
+	if resp.Degraded {
+		buf = append(buf, `,"degraded":true`...)
+	}
+	if resp.MemoryPressure {
+		buf = append(buf, `,"memory_pressure":true`...)
+	}
+	if resp.Chaos != "" {
+		buf = append(buf, `,"chaos":`...)
+		buf = appendJSONString(buf, resp.Chaos)
+	}
+	for _, f := range [...]struct{ name, value string }{
+		{`,"message":`, resp.Message},
+		{`,"documentation_url":`, resp.DocumentationURL},
+		{`,"upgrade_url":`, resp.UpgradeURL},
+	} {
+		if f.value != "" {
+			buf = append(buf, f.name...)
+			buf = appendJSONString(buf, f.value)
+		}
+	}
+	if resp.DecisionID != "" {
+		buf = append(buf, `,"decision_id":`...)
+		buf = appendJSONString(buf, resp.DecisionID)
+	}
+	return append(buf, "}\n"...)
+}
+
+// appendJSONFloat mirrors encoding/json's formatting of a float64: plain
+// decimals, with exponents only for very small or large magnitudes.
+func appendJSONFloat(buf []byte, f float64) []byte {
+	format := byte('f')
+	if abs := math.Abs(f); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+	buf = strconv.AppendFloat(buf, f, format, -1, 64)
+	if format == 'e' {
+		// Like encoding/json, write e-07 as e-7.
+		if n := len(buf); n >= 4 && buf[n-4] == 'e' && buf[n-3] == '-' && buf[n-2] == '0' {
+			buf[n-2] = buf[n-1]
+			buf = buf[:n-1]
+		}
+	}
+	return buf
+}
+
+const hexDigits = "0123456789abcdef"
+
+// appendJSONString mirrors encoding/json's escaping, HTML-safe characters
+// included, so the fast path is byte-for-byte compatible.
+func appendJSONString(buf []byte, s string) []byte {
+	buf = append(buf, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if b := s[i]; b < utf8.RuneSelf {
+			if b >= 0x20 && b != '"' && b != '\\' && b != '<' && b != '>' && b != '&' {
+				i++
+				continue
+			}
+			buf = append(buf, s[start:i]...)
+			switch b {
+			case '"', '\\':
+				buf = append(buf, '\\', b)
+			case '\n':
+				buf = append(buf, '\\', 'n')
+			case '\r':
+				buf = append(buf, '\\', 'r')
+			case '\t':
+				buf = append(buf, '\\', 't')
+			default:
+				buf = append(buf, '\\', 'u', '0', '0', hexDigits[b>>4], hexDigits[b&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			buf = append(buf, s[start:i]...)
+			buf = append(buf, "\ufffd"...)
+			i += size
+			start = i
+			continue
+		}
+		if r == '\u2028' || r == '\u2029' {
+			buf = append(buf, s[start:i]...)
+			buf = append(buf, '\\', 'u', '2', '0', '2', hexDigits[r&0xF])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	buf = append(buf, s[start:]...)
+	return append(buf, '"')
+}
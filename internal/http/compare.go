@@ -0,0 +1,251 @@
+package httpapi
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"rate-limiter-service/internal/backend"
+	"rate-limiter-service/internal/metrics"
+)
+
+// CompareOptions tunes the comparison SetCompare starts.
+type CompareOptions struct {
+	// Timeout bounds each check on the candidate. Defaults to a second.
+	Timeout time.Duration
+	// MaxInFlight bounds the candidate checks running at once; checks
+	// beyond it are skipped rather than queued. Defaults to 64.
+	MaxInFlight int
+	// Recent is how many divergences are kept for CompareReport. Defaults
+	// to 100.
+	Recent int
+}
+
+// Comparison outcomes: the candidate agreed, decided otherwise, agreed
+// but reported other remaining cost, or failed where the backend did not
+// (or the other way round). Skipped checks were not compared.
+const (
+	compareMatch     = "match"
+	compareDecision  = "decision"
+	compareRemaining = "remaining"
+	compareError     = "error"
+	compareSkipped   = "skipped"
+)
+
+var compareOutcomes = []string{compareMatch, compareDecision, compareRemaining, compareError, compareSkipped}
+
+// Divergence is a check the candidate backend decided differently.
+type Divergence struct {
+	AtMs      int64           `json:"at_ms"`
+	Tenant    string          `json:"tenant,omitempty"`
+	Key       string          `json:"key"`
+	Algorithm string          `json:"algorithm"`
+	Kind      string          `json:"kind"`
+	Primary   ComparedOutcome `json:"primary"`
+	Candidate ComparedOutcome `json:"candidate"`
+}
+
+// ComparedOutcome is one backend's side of a Divergence.
+type ComparedOutcome struct {
+	Allowed   bool   `json:"allowed"`
+	Remaining int64  `json:"remaining"`
+	Error     string `json:"error,omitempty"`
+}
+
+// comparison evaluates checks on a candidate backend next to the real
+// one, off the request path, and remembers where the two disagree.
+type comparison struct {
+	candidate backend.Backend
+	timeout   time.Duration
+	slots     chan struct{}
+	// compared counts the outcomes for the metrics.
+	compared metrics.Counter
+
+	mu     sync.Mutex
+	counts map[string]int64
+	// recent is a ring of the latest divergences, next the slot to fill.
+	recent []Divergence
+	next   int
+	filled bool
+}
+
+// SetCompare evaluates every check on candidate as well, once the backend
+// has decided it, and records where the two differ. Clients only ever get
+// the backend's decisions. It is for validating a backend before cutting
+// over to it; the candidate is charged like the backend, so it should not
+// be serving other traffic.
+func (h *Handler) SetCompare(candidate backend.Backend, opts CompareOptions) {
+	if opts.Timeout <= 0 {
+		opts.Timeout = time.Second
+	}
+	if opts.MaxInFlight <= 0 {
+		opts.MaxInFlight = 64
+	}
+	if opts.Recent <= 0 {
+		opts.Recent = 100
+	}
+	h.compare = &comparison{
+		candidate: candidate,
+		timeout:   opts.Timeout,
+		slots:     make(chan struct{}, opts.MaxInFlight),
+		compared:  h.metrics.compared,
+		counts:    make(map[string]int64),
+		recent:    make([]Divergence, opts.Recent),
+	}
+}
+
+// compareCheck evaluates breq, which the backend answered with res or
+// err, on the candidate in the background.
+func (h *Handler) compareCheck(req *CheckRequest, breq backend.Request, res backend.Result, err error) {
+	c := h.compare
+	if c == nil {
+		return
+	}
+	select {
+	case c.slots <- struct{}{}:
+	default:
+		c.record(compareSkipped, nil)
+		return
+	}
+	tenant, key, algorithm := req.Tenant, req.Key, req.Algorithm
+	clock := h.opts.Load().Clock
+	go func() {
+		defer func() { <-c.slots }()
+		ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+		defer cancel()
+		cres, cerr := backend.Evaluate(ctx, c.candidate, breq)
+		kind := compareOutcome(res, err, cres, cerr)
+		if kind == compareMatch {
+			c.record(kind, nil)
+			return
+		}
+		d := Divergence{
+			AtMs:      clock.Now().UnixMilli(),
+			Tenant:    tenant,
+			Key:       key,
+			Algorithm: algorithm,
+			Kind:      kind,
+			Primary:   comparedOutcome(res, err),
+			Candidate: comparedOutcome(cres, cerr),
+		}
+		c.record(kind, &d)
+		if kind == compareError && cerr != nil {
+			log.Printf("compare: candidate failed on %s: %v", breq.Key, cerr)
+		}
+	}()
+}
+
+func compareOutcome(res backend.Result, err error, cres backend.Result, cerr error) string {
+	switch {
+	case err != nil || cerr != nil:
+		if (err == nil) != (cerr == nil) {
+			return compareError
+		}
+		return compareMatch
+	case res.Allowed != cres.Allowed:
+		return compareDecision
+	case res.Remaining != cres.Remaining:
+		return compareRemaining
+	}
+	return compareMatch
+}
+
+func comparedOutcome(res backend.Result, err error) ComparedOutcome {
+	if err != nil {
+		return ComparedOutcome{Error: err.Error()}
+	}
+	return ComparedOutcome{Allowed: res.Allowed, Remaining: res.Remaining}
+}
+
+func (c *comparison) record(kind string, d *Divergence) {
+	c.compared.Inc(kind)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[kind]++
+	if d == nil {
+		return
+	}
+	c.recent[c.next] = *d
+	c.next++
+	if c.next == len(c.recent) {
+		c.next, c.filled = 0, true
+	}
+}
+
+// divergences returns up to limit of the recent divergences of kind (any
+// if empty) in tenant (any if empty), newest first.
+func (c *comparison) divergences(tenant, kind string, limit int) []Divergence {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := c.next
+	if c.filled {
+		n = len(c.recent)
+	}
+	out := []Divergence{}
+	for i := 1; i <= n && len(out) < limit; i++ {
+		d := c.recent[(c.next-i+len(c.recent))%len(c.recent)]
+		if (tenant == "" || d.Tenant == tenant) && (kind == "" || d.Kind == kind) {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+func (c *comparison) totals() map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make(map[string]int64, len(compareOutcomes))
+	for _, kind := range compareOutcomes {
+		out[kind] = c.counts[kind]
+	}
+	return out
+}
+
+// CompareReportResponse counts the compared checks by outcome and lists
+// the latest divergences.
+type CompareReportResponse struct {
+	Checks      map[string]int64 `json:"checks,omitempty"`
+	Divergences []Divergence     `json:"divergences"`
+}
+
+// CompareReport serves how the candidate backend's decisions compare with
+// the backend's. Tenant-scoped callers see their tenant's divergences but
+// not the instance's totals.
+func (h *Handler) CompareReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "method_not_allowed"})
+		return
+	}
+	if h.compare == nil {
+		writeJSON(w, http.StatusNotImplemented, ErrorResponse{Error: "compare_unavailable",
+			Message: "no candidate backend is being compared; set COMPARE_BACKEND"})
+		return
+	}
+	query := r.URL.Query()
+	kind := query.Get("kind")
+	switch kind {
+	case "", compareDecision, compareRemaining, compareError:
+	default:
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid_kind"})
+		return
+	}
+	limit := 100
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 1000 {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid_limit"})
+			return
+		}
+		limit = n
+	}
+	tenant := tenantOf(r)
+	resp := CompareReportResponse{Divergences: h.compare.divergences(tenant, kind, limit)}
+	if tenant == "" {
+		resp.Checks = h.compare.totals()
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
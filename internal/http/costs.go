@@ -0,0 +1,64 @@
+package httpapi
+
+import (
+	"strings"
+)
+
+// RequestDescriptor describes the request a check is for, so the check is
+// priced by the cost map instead of carrying a cost.
+type RequestDescriptor struct {
+	Method string `json:"method,omitempty"`
+	Path   string `json:"path,omitempty"`
+}
+
+// CostMap prices request descriptors. Its keys are a method ("POST"), a
+// path ("/v1/export", or "/v1/export/*" for everything under it) or both
+// ("POST /v1/export"); "*" prices everything else.
+type CostMap map[string]int64
+
+// price looks d up from the most to the least specific entry: method and
+// exact path, exact path, method and the longest matching prefix, the
+// longest prefix, method, then "*".
+func (m CostMap) price(d RequestDescriptor) (int64, bool) {
+	method := strings.ToUpper(strings.TrimSpace(d.Method))
+	path := strings.TrimSpace(d.Path)
+	if path != "" {
+		if cost, ok := m[method+" "+path]; ok && method != "" {
+			return cost, true
+		}
+		if cost, ok := m[path]; ok {
+			return cost, true
+		}
+		if cost, ok := m.prefix(method, path); ok && method != "" {
+			return cost, true
+		}
+		if cost, ok := m.prefix("", path); ok {
+			return cost, true
+		}
+	}
+	if cost, ok := m[method]; ok && method != "" {
+		return cost, true
+	}
+	cost, ok := m["*"]
+	return cost, ok
+}
+
+// prefix finds the entry with the longest path prefix of path, for method
+// or, with method "", for any method.
+func (m CostMap) prefix(method, path string) (int64, bool) {
+	best, cost := -1, int64(0)
+	for entry, c := range m {
+		entryMethod, pattern, _ := strings.Cut(entry, " ")
+		if pattern == "" {
+			entryMethod, pattern = "", entryMethod
+		}
+		if entryMethod != method || !strings.HasSuffix(pattern, "/*") {
+			continue
+		}
+		under := strings.TrimSuffix(pattern, "*")
+		if (strings.HasPrefix(path, under) || path == strings.TrimSuffix(under, "/")) && len(under) > best {
+			best, cost = len(under), c
+		}
+	}
+	return cost, best >= 0
+}
@@ -0,0 +1,83 @@
+package httpapi
+
+import (
+	"net/url"
+	"strconv"
+	"time"
+
+	"rate-limiter-service/internal/backend"
+)
+
+// DenyBody is text added to the body of denied checks, so end users of an
+// API get something to act on. Each field may hold placeholders: {key},
+// {tenant}, {algorithm}, {limit} (the limit or capacity), {cost},
+// {retry_after_ms}, {retry_after_s} (rounded up), {reset_at_ms} and
+// {reset_at} (RFC 3339), query-escaped in the URLs. Unknown placeholders
+// are left as they are.
+type DenyBody struct {
+	Message          string
+	DocumentationURL string
+	UpgradeURL       string
+}
+
+// over returns d with the fields o sets replaced.
+func (d DenyBody) over(o DenyBody) DenyBody {
+	if o.Message != "" {
+		d.Message = o.Message
+	}
+	if o.DocumentationURL != "" {
+		d.DocumentationURL = o.DocumentationURL
+	}
+	if o.UpgradeURL != "" {
+		d.UpgradeURL = o.UpgradeURL
+	}
+	return d
+}
+
+// fill adds the deny body of req's tenant to resp, a denial of req.
+func (d *DenyBody) fill(resp *CheckResponse, req *CheckRequest) {
+	if d.Message == "" && d.DocumentationURL == "" && d.UpgradeURL == "" {
+		return
+	}
+	expand := func(template string, escape func(string) string) string {
+		if template == "" {
+			return ""
+		}
+		return keyPlaceholder.ReplaceAllStringFunc(template, func(m string) string {
+			if v, ok := denyField(resp, req, m[1:len(m)-1]); ok {
+				return escape(v)
+			}
+			return m
+		})
+	}
+	resp.Message = expand(d.Message, func(s string) string { return s })
+	resp.DocumentationURL = expand(d.DocumentationURL, url.QueryEscape)
+	resp.UpgradeURL = expand(d.UpgradeURL, url.QueryEscape)
+}
+
+func denyField(resp *CheckResponse, req *CheckRequest, name string) (string, bool) {
+	switch name {
+	case "key":
+		return req.Key, true
+	case "tenant":
+		return req.Tenant, true
+	case "algorithm":
+		return req.Algorithm, true
+	case "limit":
+		if req.Algorithm == backend.TokenBucket || req.Algorithm == backend.LeakyBucket {
+			return strconv.FormatInt(req.Capacity, 10), true
+		}
+		return strconv.FormatInt(req.Limit, 10), true
+	case "cost":
+		return strconv.FormatInt(req.Cost, 10), true
+	case "retry_after_ms":
+		return strconv.FormatInt(resp.RetryAfterMs, 10), true
+	case "retry_after_s":
+		return strconv.FormatInt((resp.RetryAfterMs+999)/1000, 10), true
+	case "reset_at_ms":
+		return strconv.FormatInt(resp.ResetAtMs, 10), true
+	case "reset_at":
+		return time.UnixMilli(resp.ResetAtMs).UTC().Format(time.RFC3339), true
+	}
+	return "", false
+}
@@ -0,0 +1,121 @@
+package httpapi
+
+import (
+	"strings"
+
+	"rate-limiter-service/internal/backend"
+)
+
+// DescriptorEntry is one key and value of a check's descriptor, as
+// lyft/ratelimit clients send them.
+type DescriptorEntry struct {
+	Key   string `json:"key"`
+	Value string `json:"value,omitempty"`
+}
+
+// DescriptorDomains maps each configured domain to its descriptors.
+type DescriptorDomains map[string][]Descriptor
+
+// Descriptor is a lyft/ratelimit descriptor mapped onto a fixed window of
+// Limit per WindowMs. It matches an entry with its key and, unless Value is
+// empty, its value; a Value ending in '*' matches values starting with the
+// rest. A descriptor with neither Limit nor Unlimited only leads on to
+// Descriptors.
+type Descriptor struct {
+	Key         string
+	Value       string
+	Limit       int64
+	WindowMs    int64
+	Unlimited   bool
+	ShadowMode  bool
+	Descriptors []Descriptor
+}
+
+// resolveDescriptor takes req's key and limit from the descriptors of its
+// domain, walking them with its entries in order. A descriptor that
+// matches no limit, or an unlimited one, leaves req unlimited.
+func resolveDescriptor(req *CheckRequest, opts *Options) string {
+	switch {
+	case req.Key != "" || req.UserID != "" || req.DeviceID != "":
+		return "domain_and_key_conflict"
+	case req.Algorithm != "" || req.Limit != 0 || req.WindowMs != 0 || req.Capacity != 0 || req.RefillPerSec != 0 || req.LeakPerSec != 0:
+		return "domain_and_limits_conflict"
+	case len(req.Descriptors) == 0:
+		return "descriptors_required"
+	}
+	nodes, ok := opts.Descriptors[req.Domain]
+	if !ok {
+		return "unknown_domain"
+	}
+	key, policy := "descriptor:"+req.Domain, req.Domain
+	var node *Descriptor
+	for i := range req.Descriptors {
+		entry := &req.Descriptors[i]
+		entry.Key = strings.TrimSpace(entry.Key)
+		if entry.Key == "" {
+			return "invalid_descriptor"
+		}
+		key += "/" + entry.Key + "=" + entry.Value
+		if i == 0 || node != nil {
+			node = matchDescriptor(nodes, *entry)
+		}
+		if node != nil {
+			nodes = node.Descriptors
+			policy += "/" + node.Key
+			if node.Value != "" {
+				policy += "=" + node.Value
+			}
+		}
+	}
+	req.Key = key
+	if node == nil || node.Unlimited || node.Limit == 0 {
+		req.unlimited = true
+		return ""
+	}
+	req.Algorithm = backend.FixedWindow
+	req.Limit, req.WindowMs = node.Limit, node.WindowMs
+	req.policy = policy
+	req.shadowMode = node.ShadowMode
+	return ""
+}
+
+// matchDescriptor finds the descriptor for entry: one with its key and
+// value, else the one with the longest wildcard value matching it, else
+// one with its key alone.
+func matchDescriptor(nodes []Descriptor, entry DescriptorEntry) *Descriptor {
+	var wildcard, bare *Descriptor
+	for i := range nodes {
+		node := &nodes[i]
+		if node.Key != entry.Key {
+			continue
+		}
+		switch {
+		case node.Value == "":
+			bare = node
+		case node.Value == entry.Value:
+			return node
+		case strings.HasSuffix(node.Value, "*") && strings.HasPrefix(entry.Value, strings.TrimSuffix(node.Value, "*")):
+			if wildcard == nil || len(node.Value) > len(wildcard.Value) {
+				wildcard = node
+			}
+		}
+	}
+	if wildcard != nil {
+		return wildcard
+	}
+	return bare
+}
+
+// enforce lets a shadow_mode descriptor's checks through however far over
+// the limit they are; the rest of the decision is reported as it is, and
+// the key's own in Key.
+func enforce(req *CheckRequest, res backend.Result) backend.Result {
+	if req.shadowMode && !res.Allowed {
+		if res.Key == nil {
+			key := res
+			res.Key = &key
+		}
+		res.Allowed = true
+	}
+	return res
+}
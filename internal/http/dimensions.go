@@ -0,0 +1,66 @@
+package httpapi
+
+import (
+	"strings"
+
+	"rate-limiter-service/internal/backend"
+)
+
+// maxDimensions bounds the dimensions of a check, all of which are charged
+// in one backend call.
+const maxDimensions = 8
+
+// checkDimensions defaults and validates req's dimensions the way the
+// check's own parameters are, under the check's algorithm.
+func checkDimensions(req *CheckRequest, opts *Options) string {
+	if len(req.Dimensions) == 0 {
+		return ""
+	}
+	if len(req.Dimensions) > maxDimensions {
+		return "too_many_dimensions"
+	}
+	if req.Algorithm == backend.CountMinSketch || req.Algorithm == backend.WeightedFairShare {
+		return "dimensions_unsupported_algorithm"
+	}
+	seen := make(map[string]bool, len(req.Dimensions))
+	for i := range req.Dimensions {
+		d := &req.Dimensions[i]
+		d.Name = strings.TrimSpace(d.Name)
+		if !validDimensionName(d.Name) {
+			return "invalid_dimension_name"
+		}
+		if seen[d.Name] {
+			return "duplicate_dimension"
+		}
+		seen[d.Name] = true
+		if d.Cost == 0 {
+			d.Cost = 1
+		}
+		if d.Cost < 0 {
+			return "invalid_cost"
+		}
+		if d.Cost > opts.MaxCost {
+			return "cost_too_large"
+		}
+		scratch := CheckRequest{
+			Algorithm:    req.Algorithm,
+			Limit:        d.Limit,
+			WindowMs:     d.WindowMs,
+			Capacity:     d.Capacity,
+			RefillPerSec: d.RefillPerSec,
+			LeakPerSec:   d.LeakPerSec,
+			Cost:         d.Cost,
+			Timezone:     req.Timezone,
+		}
+		if code := checkParams(&scratch, opts); code != "" {
+			return code
+		}
+	}
+	return ""
+}
+
+// validDimensionName reports whether name can name a dimension: letters,
+// digits, '_', '-' and '.'.
+func validDimensionName(name string) bool {
+	return name != "" && strings.Trim(name, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-.") == ""
+}
@@ -0,0 +1,82 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// drainRetryAfter is the Retry-After sent while draining: long enough for a
+// load balancer to route the retry to another instance.
+const drainRetryAfter = "1"
+
+// drainState counts checks in flight so shutdown can wait for them before
+// the backend is closed.
+type drainState struct {
+	mu       sync.Mutex
+	draining bool
+	inflight int
+	idle     chan struct{}
+}
+
+// enter registers a check, or reports false once draining has started.
+func (d *drainState) enter() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.draining {
+		return false
+	}
+	d.inflight++
+	return true
+}
+
+func (d *drainState) leave() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.inflight--
+	if d.draining && d.inflight == 0 {
+		close(d.idle)
+	}
+}
+
+func (d *drainState) isDraining() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.draining
+}
+
+// Drain makes new checks fail with 503, ends event and check streams and
+// waits until the checks in flight have finished or ctx is done. It must be
+// called at most once.
+func (h *Handler) Drain(ctx context.Context) error {
+	h.events.close()
+	h.streams.close()
+	d := &h.drain
+	d.mu.Lock()
+	d.draining = true
+	d.idle = make(chan struct{})
+	if d.inflight == 0 {
+		close(d.idle)
+	}
+	idle := d.idle
+	d.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// admit registers a check, answering 503 itself when the handler is draining.
+// Callers that get true must call h.drain.leave when done.
+func (h *Handler) admit(w http.ResponseWriter) bool {
+	if h.drain.enter() {
+		return true
+	}
+	w.Header().Set("Retry-After", drainRetryAfter)
+	w.Header().Set("Connection", "close")
+	writeJSON(w, http.StatusServiceUnavailable, ErrorResponse{Error: "shutting_down"})
+	return false
+}
@@ -0,0 +1,369 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Key lifecycle event types.
+const (
+	// KeyFirstSeen is a key's first check since this instance started, or
+	// since the key last expired.
+	KeyFirstSeen = "first_seen"
+	// KeyExhausted is a key's first denial after allowed checks.
+	KeyExhausted = "exhausted"
+	// KeyRecovered is a key's first allowed check after a denial.
+	KeyRecovered = "recovered"
+	// KeyExpired is a key reaching its reset time with no check since: its
+	// state is at rest, as if the key had never been checked.
+	KeyExpired = "expired"
+)
+
+// eventBuffer is how many events a slow subscriber may fall behind before
+// events are dropped for it.
+const eventBuffer = 256
+
+// KeyEvent is a change in a key's lifecycle, as this instance saw it.
+type KeyEvent struct {
+	ID        int64  `json:"id"`
+	Type      string `json:"type"`
+	Key       string `json:"key"`
+	Tenant    string `json:"tenant,omitempty"`
+	Algorithm string `json:"algorithm"`
+	TimeMs    int64  `json:"time_ms"`
+	Remaining int64  `json:"remaining"`
+	ResetAtMs int64  `json:"reset_at_ms"`
+}
+
+// keyEvents follows the keys this instance checks and publishes their
+// lifecycle changes to subscribers.
+type keyEvents struct {
+	mu     sync.Mutex
+	keys   map[string]*keyLife
+	subs   map[*eventSub]struct{}
+	nextID int64
+	closed bool
+}
+
+type keyLife struct {
+	key, tenant, algorithm string
+	exhausted              bool
+	remaining              int64
+	resetAtMs              int64
+}
+
+type eventSub struct {
+	ch     chan KeyEvent
+	tenant string
+	types  map[string]bool
+	filter keyFilter
+	// dropped counts events that did not fit in ch; guarded by keyEvents.mu.
+	dropped int64
+}
+
+// keyFilter keeps the keys listed in keys and those starting with one of
+// prefixes; an empty filter keeps every key.
+type keyFilter struct {
+	keys     map[string]bool
+	prefixes []string
+}
+
+func (f keyFilter) matches(key string) bool {
+	if f.keys == nil && f.prefixes == nil {
+		return true
+	}
+	if f.keys[key] {
+		return true
+	}
+	for _, prefix := range f.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// EventSnapshot lists the followed keys that are exhausted when a stream
+// starts, as exhausted events.
+type EventSnapshot struct {
+	Exhausted []KeyEvent `json:"exhausted"`
+}
+
+// observe follows a decision on req's key.
+func (e *keyEvents) observe(req *CheckRequest, allowed bool, remaining, resetAtMs int64, opts *Options) {
+	if !opts.KeyEvents {
+		return
+	}
+	key := tenantKey(req.Tenant, req.Key)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.keys == nil {
+		e.keys = make(map[string]*keyLife)
+	}
+	life, ok := e.keys[key]
+	if !ok {
+		if len(e.keys) >= opts.KeyEventsMaxKeys {
+			return
+		}
+		life = &keyLife{key: req.Key, tenant: req.Tenant}
+		e.keys[key] = life
+	}
+	life.algorithm, life.remaining, life.resetAtMs = req.Algorithm, remaining, resetAtMs
+	if !ok {
+		e.publish(KeyFirstSeen, life)
+	}
+	if !allowed && !life.exhausted {
+		life.exhausted = true
+		e.publish(KeyExhausted, life)
+	} else if allowed && life.exhausted {
+		life.exhausted = false
+		e.publish(KeyRecovered, life)
+	}
+}
+
+// expire publishes and forgets the keys whose reset time has passed.
+func (e *keyEvents) expire(now time.Time) {
+	nowMs := now.UnixMilli()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for key, life := range e.keys {
+		if life.resetAtMs <= nowMs {
+			delete(e.keys, key)
+			e.publish(KeyExpired, life)
+		}
+	}
+}
+
+// publish sends an event to every interested subscriber without waiting
+// for any of them. e.mu must be held.
+func (e *keyEvents) publish(typ string, life *keyLife) {
+	if len(e.subs) == 0 {
+		return
+	}
+	e.nextID++
+	ev := KeyEvent{
+		ID:        e.nextID,
+		Type:      typ,
+		Key:       life.key,
+		Tenant:    life.tenant,
+		Algorithm: life.algorithm,
+		TimeMs:    time.Now().UnixMilli(),
+		Remaining: life.remaining,
+		ResetAtMs: life.resetAtMs,
+	}
+	for sub := range e.subs {
+		if (sub.tenant != "" && sub.tenant != ev.Tenant) || (sub.types != nil && !sub.types[typ]) || !sub.filter.matches(ev.Key) {
+			continue
+		}
+		select {
+		case sub.ch <- ev:
+		default:
+			sub.dropped++
+		}
+	}
+}
+
+// subscribe returns a subscription, or nil once the handler is draining,
+// along with the snapshot of the keys it covers; no event is lost between
+// the two.
+func (e *keyEvents) subscribe(tenant string, types map[string]bool, filter keyFilter) (*eventSub, EventSnapshot) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	snap := EventSnapshot{Exhausted: []KeyEvent{}}
+	if e.closed {
+		return nil, snap
+	}
+	if e.subs == nil {
+		e.subs = make(map[*eventSub]struct{})
+	}
+	sub := &eventSub{ch: make(chan KeyEvent, eventBuffer), tenant: tenant, types: types, filter: filter}
+	e.subs[sub] = struct{}{}
+	nowMs := time.Now().UnixMilli()
+	for _, life := range e.keys {
+		if !life.exhausted || (tenant != "" && tenant != life.tenant) || !filter.matches(life.key) {
+			continue
+		}
+		snap.Exhausted = append(snap.Exhausted, KeyEvent{
+			Type:      KeyExhausted,
+			Key:       life.key,
+			Tenant:    life.tenant,
+			Algorithm: life.algorithm,
+			TimeMs:    nowMs,
+			Remaining: life.remaining,
+			ResetAtMs: life.resetAtMs,
+		})
+	}
+	sort.Slice(snap.Exhausted, func(i, j int) bool {
+		a, b := snap.Exhausted[i], snap.Exhausted[j]
+		return a.Tenant < b.Tenant || (a.Tenant == b.Tenant && a.Key < b.Key)
+	})
+	return sub, snap
+}
+
+func (e *keyEvents) unsubscribe(sub *eventSub) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.subs[sub]; ok {
+		delete(e.subs, sub)
+		close(sub.ch)
+	}
+}
+
+// takeDropped returns how many events sub missed since the last call.
+func (e *keyEvents) takeDropped(sub *eventSub) int64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	n := sub.dropped
+	sub.dropped = 0
+	return n
+}
+
+// close ends every subscription, so streams do not hold up shutdown.
+func (e *keyEvents) close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.closed = true
+	for sub := range e.subs {
+		delete(e.subs, sub)
+		close(sub.ch)
+	}
+}
+
+// ExpireKeys publishes expired events for keys left at rest. The server
+// calls it every second.
+func (h *Handler) ExpireKeys() {
+	h.events.expire(time.Now())
+}
+
+// KeyEvents streams key lifecycle events as server-sent events. The
+// tenant query parameter keeps one tenant's keys, types a comma-separated
+// list of event types, and keys and prefixes comma-separated keys and key
+// prefixes; callers bound to a tenant only see their own keys. With
+// snapshot=true the stream starts with the keys already exhausted, and
+// format overrides the configured KeyEventsFormat. Events
+// missed by a slow reader are reported as a dropped event with their
+// count.
+func (h *Handler) KeyEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "method_not_allowed"})
+		return
+	}
+	opts := h.opts.Load()
+	if !opts.KeyEvents {
+		writeJSON(w, http.StatusNotImplemented, ErrorResponse{Error: "events_unavailable",
+			Message: "key events are off; set KEY_EVENTS"})
+		return
+	}
+	query := r.URL.Query()
+	tenant := query.Get("tenant")
+	if bound := tenantOf(r); bound != "" {
+		if tenant != "" && tenant != bound {
+			writeJSON(w, http.StatusForbidden, ErrorResponse{Error: "tenant_forbidden"})
+			return
+		}
+		tenant = bound
+	}
+	var types map[string]bool
+	if v := query.Get("types"); v != "" {
+		types = make(map[string]bool)
+		for _, t := range strings.Split(v, ",") {
+			switch t = strings.TrimSpace(t); t {
+			case KeyFirstSeen, KeyExhausted, KeyRecovered, KeyExpired:
+				types[t] = true
+			default:
+				writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid_event_type", Message: t})
+				return
+			}
+		}
+	}
+	var filter keyFilter
+	for _, k := range splitList(query.Get("keys")) {
+		if filter.keys == nil {
+			filter.keys = make(map[string]bool)
+		}
+		filter.keys[k] = true
+	}
+	filter.prefixes = splitList(query.Get("prefixes"))
+	snapshot := false
+	if v := query.Get("snapshot"); v != "" {
+		var err error
+		if snapshot, err = strconv.ParseBool(v); err != nil {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid_snapshot"})
+			return
+		}
+	}
+	format := opts.KeyEventsFormat
+	switch v := query.Get("format"); v {
+	case "":
+	case EventsNative, EventsCloudEvents:
+		format = v
+	default:
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid_format", Message: v})
+		return
+	}
+	sub, snap := h.events.subscribe(tenant, types, filter)
+	if sub == nil {
+		w.Header().Set("Retry-After", drainRetryAfter)
+		writeJSON(w, http.StatusServiceUnavailable, ErrorResponse{Error: "shutting_down"})
+		return
+	}
+	defer h.events.unsubscribe(sub)
+
+	// A stream outlives the server's write timeout.
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	ew := &eventWriter{w: w, format: format, opts: opts}
+	if snapshot && ew.writeEvent(0, "snapshot", "", tenant, time.Now().UnixMilli(), snap) != nil {
+		return
+	}
+	if rc.Flush() != nil {
+		return
+	}
+
+	keepalive := time.NewTicker(15 * time.Second)
+	defer keepalive.Stop()
+	for {
+		select {
+		case ev, ok := <-sub.ch:
+			if !ok {
+				return
+			}
+			if n := h.events.takeDropped(sub); n > 0 {
+				if ew.writeEvent(0, "dropped", "", tenant, time.Now().UnixMilli(), map[string]int64{"count": n}) != nil {
+					return
+				}
+			}
+			if ew.writeEvent(ev.ID, ev.Type, ev.Key, ev.Tenant, ev.TimeMs, ev) != nil {
+				return
+			}
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case <-r.Context().Done():
+			return
+		}
+		if rc.Flush() != nil {
+			return
+		}
+	}
+}
+
+// splitList splits a comma-separated query value, dropping empty items.
+func splitList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,150 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"rate-limiter-service/internal/extauthz"
+)
+
+// ExtAuthzPolicy turns Envoy's ext_authz calls into checks: the key is
+// built from Key, and the rest of the check is Check. A route's context
+// extensions may override both, "key" with another template and the
+// others by check field name.
+type ExtAuthzPolicy struct {
+	Key   string
+	Check CheckRequest
+}
+
+var keyPlaceholder = regexp.MustCompile(`\{([A-Za-z0-9_.-]+)\}`)
+
+// check builds the check for in, or returns an error code.
+func (p *ExtAuthzPolicy) check(in *extauthz.Request) (*CheckRequest, string) {
+	req := p.Check
+	template := p.Key
+	for name, value := range in.ContextExtensions {
+		if name == "key" {
+			template = value
+			continue
+		}
+		// Numbers and booleans are set as such, anything else as a string.
+		field := []byte(value)
+		if !json.Valid(field) || strings.HasPrefix(value, "{") || strings.HasPrefix(value, "[") {
+			field, _ = json.Marshal(value)
+		}
+		if json.Unmarshal([]byte(`{`+strconv.Quote(name)+`:`+string(field)+`}`), &req) != nil {
+			return nil, "invalid_context_extension"
+		}
+	}
+	ok := true
+	req.Key = keyPlaceholder.ReplaceAllStringFunc(template, func(m string) string {
+		v := extAuthzField(in, m[1:len(m)-1])
+		if v == "" {
+			ok = false
+		}
+		return v
+	})
+	if !ok {
+		return nil, "key_unavailable"
+	}
+	return &req, ""
+}
+
+// extAuthzField returns a key template placeholder's value: source_ip,
+// principal, method, path, host or scheme, or header.<name> and
+// query.<name>.
+func extAuthzField(in *extauthz.Request, name string) string {
+	switch prefix, rest, _ := strings.Cut(name, "."); prefix {
+	case "header":
+		return in.Headers[strings.ToLower(rest)]
+	case "query":
+		values, _ := url.ParseQuery(in.Query)
+		return values.Get(rest)
+	}
+	switch name {
+	case "source_ip":
+		return in.SourceAddress
+	case "principal":
+		return in.SourcePrincipal
+	case "method":
+		return in.Method
+	case "path":
+		return in.Path
+	case "host":
+		return in.Host
+	case "scheme":
+		return in.Scheme
+	}
+	return ""
+}
+
+// ExtAuthz answers Envoy's ext_authz Check calls over gRPC with the
+// decision of the check built for the request: allowed requests go on
+// with the rate limit headers added to their response, denied ones are
+// answered with 429. Checks that cannot be decided fail the call, for
+// Envoy's failure_mode_allow to settle.
+func (h *Handler) ExtAuthz(w http.ResponseWriter, r *http.Request) {
+	if !extauthz.IsGRPC(r) {
+		writeJSON(w, http.StatusUnsupportedMediaType, ErrorResponse{Error: "grpc_required",
+			Message: "ext_authz is served as gRPC over HTTP/2"})
+		return
+	}
+	opts := h.opts.Load()
+	if opts.ExtAuthz == nil {
+		extauthz.WriteError(w, extauthz.CodeUnimplemented, "ext_authz_unavailable: set EXT_AUTHZ")
+		return
+	}
+	if !h.drain.enter() {
+		extauthz.WriteError(w, extauthz.CodeUnavailable, "shutting_down")
+		return
+	}
+	defer h.drain.leave()
+	in, err := extauthz.ReadRequest(r.Body, opts.MaxBodyBytes)
+	if err != nil {
+		extauthz.WriteError(w, extauthz.CodeInvalidArgument, err.Error())
+		return
+	}
+	req, code := opts.ExtAuthz.check(in)
+	if code == "key_unavailable" {
+		body, _ := json.Marshal(ErrorResponse{Error: code})
+		extauthz.WriteResponse(w, extauthz.Response{Status: http.StatusForbidden, Body: string(body),
+			Headers: []extauthz.Header{{Key: "Content-Type", Value: "application/json"}}})
+		return
+	}
+	if code != "" {
+		extauthz.WriteError(w, extauthz.CodeInvalidArgument, code)
+		return
+	}
+
+	item := h.checkItem(r, req, opts)
+	if item.Error != "" && item.Error != "tenant_key_limit" {
+		extauthz.WriteError(w, extauthz.CodeForStatus(item.Status), item.Error)
+		return
+	}
+	resp := extauthz.Response{Allowed: item.Allowed, Status: http.StatusTooManyRequests}
+	if item.Error == "" {
+		for _, h := range opts.Headers.decision(&item.CheckResponse) {
+			resp.Headers = append(resp.Headers, extauthz.Header{Key: h[0], Value: h[1]})
+		}
+	}
+	if name, ok := opts.Headers.chaos(item.Chaos); ok {
+		resp.Headers = append(resp.Headers, extauthz.Header{Key: name, Value: item.Chaos})
+	}
+	if !item.Allowed {
+		var body []byte
+		if item.Error != "" {
+			body, _ = json.Marshal(ErrorResponse{Error: item.Error})
+		} else {
+			body = appendCheckResponse(nil, &item.CheckResponse)
+			seconds := (item.RetryAfterMs + 999) / 1000
+			resp.Headers = append(resp.Headers, extauthz.Header{Key: "Retry-After", Value: strconv.FormatInt(max(seconds, 1), 10)})
+		}
+		resp.Body = string(body)
+		resp.Headers = append(resp.Headers, extauthz.Header{Key: "Content-Type", Value: "application/json"})
+	}
+	extauthz.WriteResponse(w, resp)
+}
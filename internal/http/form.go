@@ -0,0 +1,211 @@
+package httpapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const formContentType = "application/x-www-form-urlencoded"
+
+// maxFormIndex bounds the indexes of form fields such as
+// descriptors[3].key, so a hostile index cannot make the check allocate.
+const maxFormIndex = 1000
+
+var errInvalidForm = errors.New("invalid form")
+
+// isForm reports whether r's body is form-encoded rather than JSON. A body
+// starting with '{' is JSON whatever its label, as curl -d and others
+// send JSON labelled as a form by default.
+func isForm(r *http.Request, body []byte) bool {
+	if trimmed := bytes.TrimLeft(body, " \t\r\n"); len(trimmed) > 0 && trimmed[0] == '{' {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == formContentType
+}
+
+// decodeCheckForm decodes a form-encoded check, for callers that cannot
+// send JSON. Its fields are named as the JSON ones, with nested fields
+// written as their paths: request.method, descriptors[0].key. The form is
+// turned into the JSON check it stands for, so it is decoded, strictly or
+// not, as that would be; a value that does not fit its field fails the
+// form as invalid_form. Empty values leave fields other than strings
+// unset, and of a repeated field the first value counts.
+func decodeCheckForm(data []byte, req *CheckRequest, strict bool) error {
+	values, err := url.ParseQuery(string(data))
+	if err != nil {
+		return errInvalidForm
+	}
+	doc := make(map[string]interface{}, len(values))
+	for name, vs := range values {
+		segments, ok := formPath(name)
+		if !ok {
+			return errInvalidForm
+		}
+		value, fits, ok := formValue(doc, segments, checkRequestType, vs[0])
+		switch {
+		case !ok:
+			return errInvalidForm
+		case !fits:
+			// Left under its whole name, an unknown field is ignored, or
+			// named by a strict decoding.
+			doc[name] = vs[0]
+		default:
+			doc = value.(map[string]interface{})
+		}
+	}
+	js, err := json.Marshal(doc)
+	if err != nil {
+		return errInvalidForm
+	}
+	err = decodeCheckRequest(js, req, strict)
+	var fields fieldErrors
+	if err != nil && !errors.As(err, &fields) {
+		return errInvalidForm
+	}
+	return err
+}
+
+// formSegment is a field's name or, when index is set, an index into an
+// array.
+type formSegment struct {
+	name  string
+	index int
+	isIdx bool
+}
+
+// formPath splits a form field's name such as descriptors[0].key into its
+// segments.
+func formPath(name string) ([]formSegment, bool) {
+	var segments []formSegment
+	for rest := name; rest != ""; {
+		switch {
+		case rest[0] == '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 || len(segments) == 0 {
+				return nil, false
+			}
+			i, err := strconv.Atoi(rest[1:end])
+			if err != nil || i < 0 || i >= maxFormIndex {
+				return nil, false
+			}
+			segments = append(segments, formSegment{index: i, isIdx: true})
+			rest = rest[end+1:]
+		default:
+			if rest[0] == '.' {
+				if len(segments) == 0 {
+					return nil, false
+				}
+				rest = rest[1:]
+			} else if len(segments) > 0 {
+				return nil, false
+			}
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, false
+			}
+			segments = append(segments, formSegment{name: rest[:end]})
+			rest = rest[end:]
+		}
+	}
+	return segments, len(segments) > 0
+}
+
+// formValue sets the field segments lead to in node, decoded into a t, to
+// value, and returns node so updated. fits is false when t has no such
+// field, and ok is false when node already holds it with another shape,
+// as when both request and request.method are given.
+func formValue(node interface{}, segments []formSegment, t reflect.Type, value string) (updated interface{}, fits, ok bool) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if len(segments) == 0 {
+		switch t.Kind() {
+		case reflect.Struct, reflect.Map, reflect.Slice, reflect.Array:
+			return nil, false, true
+		}
+		if node != nil {
+			return nil, true, false
+		}
+		return formScalar(value, t), true, true
+	}
+	segment := segments[0]
+	if segment.isIdx {
+		if t.Kind() != reflect.Slice {
+			return nil, false, true
+		}
+		items, isItems := node.([]interface{})
+		if node != nil && !isItems {
+			return nil, true, false
+		}
+		for len(items) <= segment.index {
+			items = append(items, nil)
+		}
+		item, fits, ok := formValue(items[segment.index], segments[1:], t.Elem(), value)
+		if !fits || !ok {
+			return nil, fits, ok
+		}
+		items[segment.index] = item
+		return items, true, true
+	}
+	var field reflect.Type
+	switch t.Kind() {
+	case reflect.Struct:
+		field = structFields(t)[segment.name]
+	case reflect.Map:
+		field = t.Elem()
+	}
+	if field == nil {
+		return nil, false, true
+	}
+	members, isMembers := node.(map[string]interface{})
+	if node != nil && !isMembers {
+		return nil, true, false
+	}
+	if members == nil {
+		members = make(map[string]interface{})
+	}
+	member, fits, ok := formValue(members[segment.name], segments[1:], field, value)
+	if !fits || !ok {
+		return nil, fits, ok
+	}
+	if member != nil {
+		members[segment.name] = member
+	}
+	return members, true, true
+}
+
+// formScalar is value as the JSON a field of type t takes, or as a string
+// when it does not fit one, for decoding to refuse. It is nil, leaving the
+// field unset, when value is empty and t is not a string.
+func formScalar(value string, t reflect.Type) interface{} {
+	if t.Kind() == reflect.String {
+		return value
+	}
+	if value == "" {
+		return nil
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		if c := value[0]; (c == '-' || c >= '0' && c <= '9') && json.Valid([]byte(value)) {
+			return json.Number(value)
+		}
+	}
+	return value
+}
@@ -0,0 +1,46 @@
+package httpapi
+
+import (
+	"context"
+	"time"
+
+	"rate-limiter-service/internal/backend"
+)
+
+// GracePolicy lets a key that exhausted its limit go on for Requests more
+// cost or for Duration, whichever ends first (zero leaves either
+// unbounded), once per Period; defaults to a day.
+type GracePolicy struct {
+	Requests int64
+	Duration time.Duration
+	Period   time.Duration
+}
+
+func (p GracePolicy) enabled() bool {
+	return p.Requests > 0 || p.Duration > 0
+}
+
+// graceful allows a check res denied if req's key still has grace. A
+// denial by the check's group stands: grace is for the key's own limit. So
+// does any denial of a check with dimensions, which a denial leaves
+// uncharged. The grace is that of req's tenant.
+func (h *Handler) graceful(ctx context.Context, req *CheckRequest, res backend.Result, opts *Options) backend.Result {
+	opts = opts.scoped(req.Tenant)
+	if res.Allowed || !opts.Grace.enabled() || res.Group != nil && !res.Group.Allowed || len(req.Dimensions) > 0 {
+		return res
+	}
+	grace, ok := h.backend.(backend.GraceBackend)
+	if !ok {
+		return res
+	}
+	period := opts.Grace.Period
+	if period <= 0 {
+		period = 24 * time.Hour
+	}
+	allowed, err := grace.GraceAllow(ctx, tenantKey(req.Tenant, req.Key), opts.Grace.Requests, opts.Grace.Duration.Milliseconds(), period.Milliseconds(), req.Cost)
+	if err != nil || !allowed {
+		return res
+	}
+	res.Allowed, res.RetryAfterMs, res.Grace = true, 0, true
+	return res
+}
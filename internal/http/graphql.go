@@ -0,0 +1,197 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"rate-limiter-service/internal/graphql"
+)
+
+// StatsView describes this instance's own state.
+type StatsView struct {
+	Draining       bool `json:"draining"`
+	InflightChecks int  `json:"inflight_checks"`
+	CheckStreams   int  `json:"check_streams"`
+	EventStreams   int  `json:"event_streams"`
+	FollowedKeys   int  `json:"followed_keys"`
+	ShadowedKeys   int  `json:"shadowed_keys"`
+}
+
+// adminField is a GraphQL root field answered by an admin endpoint, its
+// arguments passed as query parameters but for pathArg, which fills the
+// path wildcard pathValue. unwrap picks the one field of the endpoint's
+// answer worth returning.
+type adminField struct {
+	role      string
+	handler   func(*Handler) http.HandlerFunc
+	pathArg   string
+	pathValue string
+	unwrap    string
+}
+
+var adminFields = map[string]adminField{
+	"key":          {role: RoleViewer, handler: func(h *Handler) http.HandlerFunc { return h.Inspect }},
+	"key_history":  {role: RoleViewer, handler: func(h *Handler) http.HandlerFunc { return h.KeyHistory }, pathArg: "key", pathValue: "key"},
+	"tenant_usage": {role: RoleViewer, handler: func(h *Handler) http.HandlerFunc { return h.TenantUsage }, pathArg: "tenant", pathValue: "id"},
+	"audit":        {role: RoleViewer, handler: func(h *Handler) http.HandlerFunc { return h.Audit }, unwrap: "entries"},
+	"multipliers":  {role: RoleOperator, handler: func(h *Handler) http.HandlerFunc { return h.Multipliers }, unwrap: "multipliers"},
+	"policies":     {role: RoleViewer, handler: func(h *Handler) http.HandlerFunc { return h.Policies }, unwrap: "policies"},
+}
+
+// GraphQL answers GraphQL queries over the admin data: keys, their
+// history, tenant usage, the audit trail, multipliers, policies and this
+// instance's stats. Each field behaves as the admin endpoint it stands
+// for, with the same roles and tenant scoping.
+func (h *Handler) GraphQL(w http.ResponseWriter, r *http.Request) {
+	opts := h.opts.Load()
+	if !opts.GraphQL {
+		writeJSON(w, http.StatusNotImplemented, ErrorResponse{Error: "graphql_unavailable",
+			Message: "the GraphQL endpoint is off; set GRAPHQL"})
+		return
+	}
+	var req graphql.Request
+	switch r.Method {
+	case http.MethodGet:
+		query := r.URL.Query()
+		req.Query, req.OperationName = query.Get("query"), query.Get("operationName")
+		if v := query.Get("variables"); v != "" && decodeVariables([]byte(v), &req.Variables) != nil {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid_json"})
+			return
+		}
+	case http.MethodPost:
+		body := getBuffer()
+		defer putBuffer(body)
+		if !readBody(w, r, body, opts.MaxBodyBytes) {
+			return
+		}
+		dec := json.NewDecoder(bytes.NewReader(body.Bytes()))
+		dec.UseNumber()
+		if err := dec.Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid_json"})
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "method_not_allowed"})
+		return
+	}
+	if req.Query == "" {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "query_required"})
+		return
+	}
+
+	fields := map[string]graphql.Resolver{
+		"stats": func(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+			return h.stats(), nil
+		},
+	}
+	for name, field := range adminFields {
+		fields[name] = func(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+			return h.adminQuery(r, field, args, opts)
+		}
+	}
+	resp := graphql.Execute(r.Context(), req, fields)
+	status := http.StatusOK
+	if resp.Data == nil {
+		status = http.StatusBadRequest
+	}
+	writeJSON(w, status, resp)
+}
+
+func decodeVariables(data []byte, vars *map[string]interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(vars)
+}
+
+// adminQuery runs field's endpoint as a GET with args, on behalf of r's
+// caller, and returns its answer. Errors carry the endpoint's code and
+// status as extensions.
+func (h *Handler) adminQuery(r *http.Request, field adminField, args map[string]interface{}, opts *Options) (interface{}, error) {
+	if !permits(r, field.role, opts) {
+		return nil, fieldError(http.StatusForbidden, ErrorResponse{Error: "forbidden"})
+	}
+	query := url.Values{}
+	for name, v := range args {
+		if name != field.pathArg && v != nil {
+			query.Set(name, stringArg(v))
+		}
+	}
+	sub := r.Clone(r.Context())
+	sub.Method, sub.Body, sub.ContentLength = http.MethodGet, http.NoBody, 0
+	sub.URL = &url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+	if field.pathArg != "" {
+		sub.SetPathValue(field.pathValue, stringArg(args[field.pathArg]))
+	}
+	rec := &recorder{header: make(http.Header), status: http.StatusOK}
+	field.handler(h)(rec, sub)
+
+	if rec.status >= 400 {
+		var failure ErrorResponse
+		json.Unmarshal(rec.body.Bytes(), &failure)
+		return nil, fieldError(rec.status, failure)
+	}
+	var out map[string]json.RawMessage
+	if err := json.Unmarshal(rec.body.Bytes(), &out); err != nil {
+		return nil, err
+	}
+	if field.unwrap != "" {
+		return out[field.unwrap], nil
+	}
+	return out, nil
+}
+
+func fieldError(status int, failure ErrorResponse) *graphql.Error {
+	message := failure.Message
+	if message == "" {
+		message = failure.Error
+	}
+	return &graphql.Error{Message: message, Extensions: map[string]interface{}{"code": failure.Error, "status": status}}
+}
+
+// stringArg renders an argument as a query parameter value.
+func stringArg(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+// recorder keeps what a handler writes, for adminQuery.
+type recorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *recorder) Header() http.Header { return rec.header }
+
+func (rec *recorder) Write(p []byte) (int, error) { return rec.body.Write(p) }
+
+func (rec *recorder) WriteHeader(status int) { rec.status = status }
+
+func (h *Handler) stats() StatsView {
+	var s StatsView
+	h.drain.mu.Lock()
+	s.Draining, s.InflightChecks = h.drain.draining, h.drain.inflight
+	h.drain.mu.Unlock()
+	h.streams.mu.Lock()
+	s.CheckStreams = len(h.streams.conns)
+	h.streams.mu.Unlock()
+	h.events.mu.Lock()
+	s.EventStreams, s.FollowedKeys = len(h.events.subs), len(h.events.keys)
+	h.events.mu.Unlock()
+	h.shadow.mu.Lock()
+	s.ShadowedKeys = len(h.shadow.entries)
+	h.shadow.mu.Unlock()
+	return s
+}
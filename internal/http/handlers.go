@@ -1,126 +1,870 @@
 package httpapi
 
 import (
+	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"math/rand"
 	"net/http"
+	"net/netip"
+	"slices"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
+	"rate-limiter-service/internal/audit"
 	"rate-limiter-service/internal/backend"
+	"rate-limiter-service/internal/cluster"
+	"rate-limiter-service/internal/gossip"
+	"rate-limiter-service/internal/hook"
+	"rate-limiter-service/internal/jwt"
+	"rate-limiter-service/internal/replication"
 )
 
 type Handler struct {
 	backend backend.Backend
+	opts    atomic.Pointer[Options]
+	reload  func(actor string) error
+	audit   *audit.Log
+	drain   drainState
+	// self holds the per-caller limits the service applies to itself.
+	self *backend.MemoryBackend
+	// replays remembers accepted request signatures and reconciled
+	// decisions.
+	replays replayCache
+	// reconcileKey signs decision IDs when no ReconcileSecret is set.
+	reconcileKey []byte
+	// usage counts tenant decisions, and history key decisions, until
+	// FlushUsage writes them out.
+	usage   usageRecorder
+	history historyRecorder
+	// events publishes key lifecycle changes to KeyEvents streams.
+	events keyEvents
+	// streams holds the open CheckStream connections.
+	streams streamSet
+	// replicator shares allowed decisions with other regions.
+	replicator *replication.Replicator
+	// gossip tracks the other instances sharing consumption with this one.
+	gossip *gossip.Node
+	// cluster is the backend when keys are spread over instances; see
+	// SetCluster.
+	cluster         *cluster.Backend
+	clusterRedirect bool
+	// shadow bridges backend outages; see ReconcileShadow.
+	shadow shadowStore
+	// newKeys lets checks on keys not seen recently skip the backend; see
+	// WriteBackNewKeys.
+	newKeys newKeyFilter
+	// hot counts checks by key; see HotKeys.
+	hot hotKeys
+	// multipliers scale the limits of checks; see RefreshMultipliers.
+	multipliers atomic.Pointer[map[string]backend.Multiplier]
+	// compare evaluates checks on a candidate backend too; see SetCompare.
+	compare *comparison
+	// metrics are served at /metrics and pushed; see MetricsRegistry.
+	metrics handlerMetrics
+	// keyCounts holds the last tracked key counts, for the metrics.
+	keyCounts keyCountCache
 }
 
-func NewHandler(backend backend.Backend) *Handler {
-	return &Handler{backend: backend}
+// Fail modes decide what a check returns when the backend fails.
+const (
+	// FailModeError reports the failure as a 500.
+	FailModeError = "error"
+	// FailModeOpen allows the request.
+	FailModeOpen = "open"
+	// FailModeClosed denies the request.
+	FailModeClosed = "closed"
+)
+
+// TokenVerifier checks a JWT's signature and claims.
+type TokenVerifier interface {
+	Verify(ctx context.Context, token string) error
+}
+
+type Options struct {
+	// FailMode is used for requests that do not set fail_mode.
+	FailMode string
+	// BackendTimeout bounds each backend call (or whole batch); zero
+	// leaves only the client's own cancellation.
+	BackendTimeout time.Duration
+	// BatchMaxItems caps the number of items accepted by the batch endpoint.
+	BatchMaxItems int
+	// BatchConcurrency bounds how many batch items are evaluated at once on
+	// backends that cannot pipeline.
+	BatchConcurrency int
+	// MaxBodyBytes caps request bodies; larger ones get 413.
+	MaxBodyBytes int64
+	// MaxCost, MaxCapacity, MaxLimit and MaxWindowMs cap the matching
+	// request fields; larger values are rejected.
+	MaxCost     int64
+	MaxCapacity int64
+	MaxLimit    int64
+	MaxWindowMs int64
+	// MaxLogLimit, when set, caps sliding_window_log limits below
+	// MaxLimit, since the log keeps an entry per hit.
+	MaxLogLimit int64
+	// Algorithms, when not empty, are the only algorithms checks may ask
+	// for with limits of their own; those resolved from a descriptor, a
+	// limit policy or the hook are not held to them.
+	Algorithms []string
+	// Grace lets keys go on for a while after first exhausting their limit.
+	Grace GracePolicy
+	// RetryJitter adds up to this fraction of a denial's retry_after_ms at
+	// random, so clients denied together do not all retry together.
+	RetryJitter float64
+	// Costs prices checks that describe their request instead of giving a
+	// cost.
+	Costs CostMap
+	// APIKeys maps each accepted key to one of the Role constants; when empty
+	// every endpoint is open.
+	APIKeys map[string]string
+	// APIKeyHeader carries the key for clients that cannot set
+	// "Authorization: ApiKey <key>".
+	APIKeyHeader string
+	// ClientRoles maps SANs of verified client certificates to roles.
+	ClientRoles map[string]string
+	// SigningKeys maps key ids to the shared secrets callers sign requests
+	// with.
+	SigningKeys map[string]SigningKey
+	// SignatureMaxSkew bounds how far a signed request's timestamp may be
+	// from the server's clock; defaults to 5 minutes.
+	SignatureMaxSkew time.Duration
+	// AdminNetworks, when set, are the only sources allowed to reach the
+	// admin endpoints.
+	AdminNetworks []netip.Prefix
+	// JWTVerifier, when set, checks tokens before they are used as keys.
+	JWTVerifier TokenVerifier
+	// CheckCallerRate and AdminCallerRate cap requests per second from one
+	// caller to the check and admin endpoints; 0 disables the cap. The
+	// bursts default to the rates.
+	CheckCallerRate  int64
+	CheckCallerBurst int64
+	AdminCallerRate  int64
+	AdminCallerBurst int64
+	// KeyHashSecret, when set, replaces user and device ids in keys with
+	// their HMAC under it. Keys under KeyHashPrevious are charged as well
+	// until KeyHashGrace after it was rotated out; see rotateKeyHash.
+	KeyHashSecret   string
+	KeyHashPrevious string
+	KeyHashGrace    time.Duration
+	// RequireTenant rejects checks that name no tenant; KnownTenantsOnly
+	// rejects tenants missing from Tenants.
+	RequireTenant    bool
+	KnownTenantsOnly bool
+	// Tenants overrides the policy caps per tenant.
+	Tenants map[string]TenantPolicies
+	// UsageRetention is how long tenant usage is kept; defaults to 400
+	// days.
+	UsageRetention time.Duration
+	// KeyHistory keeps usage per key as well, for HistoryRetention;
+	// defaults to 7 days.
+	KeyHistory       bool
+	HistoryRetention time.Duration
+	// KeyEvents follows checked keys to publish their lifecycle events,
+	// up to KeyEventsMaxKeys at once; defaults to 100000.
+	KeyEvents        bool
+	KeyEventsMaxKeys int
+	// KeyEventsFormat is EventsNative, the default, or EventsCloudEvents,
+	// whose envelopes carry CloudEventsSource and a type of
+	// CloudEventsTypePrefix followed by the event type.
+	KeyEventsFormat       string
+	CloudEventsSource     string
+	CloudEventsTypePrefix string
+	// GraphQL turns on the GraphQL query endpoint over the admin data.
+	GraphQL bool
+	// Metrics turns on the Prometheus metrics endpoint.
+	Metrics bool
+	// ErrorFormat is ErrorsJSON, the default, or ErrorsProblem. Problem
+	// details' types are ErrorTypeBase followed by the error code, or
+	// about:blank when it is empty.
+	ErrorFormat   string
+	ErrorTypeBase string
+	// StrictRequests refuses check requests with unknown fields or
+	// values of the wrong type, naming each, instead of ignoring the
+	// former.
+	StrictRequests bool
+	// KeyCountInterval is how old the tracked key counts in the metrics
+	// may grow before they are counted again; defaults to a minute.
+	KeyCountInterval time.Duration
+	// ExtAuthz, when set, turns on Envoy's ext_authz API and builds its
+	// checks.
+	ExtAuthz *ExtAuthzPolicy
+	// Headers names the headers carrying a check's decision; the zero
+	// value names them with DefaultHeaderPrefix.
+	Headers ResponseHeaders
+	// Chaos, when set, injects faults into checks.
+	Chaos *ChaosPolicy
+	// ShadowTTL, when set, lets checks on a failing backend be decided
+	// from the state it last reported for the key, up to this long ago.
+	// ShadowMaxKeys bounds how many keys are shadowed; defaults to 100000.
+	ShadowTTL     time.Duration
+	ShadowMaxKeys int
+	// Groups maps the budget groups checks may name to their budgets.
+	Groups map[string]GroupBudget
+	// LimitPolicies maps the policies v2 checks may name to their limits.
+	LimitPolicies map[string]LimitPolicy
+	// Hook, when set, is consulted before each check and may choose its
+	// limit and cost or decide it outright. HookSkipOnError evaluates
+	// checks as sent when it fails, instead of failing them.
+	Hook            *hook.Hook
+	HookSkipOnError bool
+	// Descriptors holds the limits checks may name by domain and
+	// descriptor instead of giving their own.
+	Descriptors DescriptorDomains
+	// NewKeyFilterKeys, when positive, allows checks on keys this instance
+	// has not checked among about that many recent keys without waiting
+	// for the backend.
+	NewKeyFilterKeys int
+	// DenyBody is added to the bodies of denied checks; tenants may
+	// replace its fields.
+	DenyBody DenyBody
+	// ReconcileSecret signs decision IDs, so any instance sharing it can
+	// reconcile them; without one they are good on this instance only.
+	// ReconcileTTL is how long they can be reconciled; defaults to an hour.
+	ReconcileSecret []byte
+	ReconcileTTL    time.Duration
+	// HotKeys, when positive, counts the checks on recent keys so that
+	// HotKeys can list the busiest for the next start's WarmStart, and
+	// TopKeys for operators.
+	HotKeys int
+
+	// Clock supplies the times the handler's decisions depend on: decision
+	// ID expiry, shadow decisions, pacing and availability. Defaults to the
+	// system clock; tests substitute the backend's ManualClock.
+	Clock backend.Clock
+
+	apiKeys              apiKeys
+	keyHashPreviousUntil time.Time
+	// tenantOpts are copies of these options with each tenant's caps.
+	tenantOpts map[string]*Options
 }
 
+func NewHandler(store backend.Backend, opts Options) *Handler {
+	h := &Handler{backend: store, self: backend.NewMemoryBackend(backend.MemoryOptions{}), reconcileKey: newReconcileKey()}
+	h.initMetrics()
+	h.SetOptions(opts)
+	return h
+}
+
+// SetOptions replaces the handler's options. Requests already in flight
+// finish with the options they started with.
+func (h *Handler) SetOptions(opts Options) {
+	if opts.BatchMaxItems <= 0 {
+		opts.BatchMaxItems = 100
+	}
+	if opts.BatchConcurrency <= 0 {
+		opts.BatchConcurrency = 8
+	}
+	if opts.FailMode == "" {
+		opts.FailMode = FailModeError
+	}
+	if opts.MaxBodyBytes <= 0 {
+		opts.MaxBodyBytes = 1 << 20
+	}
+	if opts.MaxCost <= 0 {
+		opts.MaxCost = 1000000
+	}
+	if opts.MaxCapacity <= 0 {
+		opts.MaxCapacity = 1000000000
+	}
+	if opts.MaxLimit <= 0 {
+		opts.MaxLimit = 1000000000
+	}
+	if opts.MaxWindowMs <= 0 {
+		opts.MaxWindowMs = 7 * 24 * 60 * 60 * 1000
+	}
+	if opts.SignatureMaxSkew <= 0 {
+		opts.SignatureMaxSkew = 5 * time.Minute
+	}
+	if opts.APIKeyHeader == "" {
+		opts.APIKeyHeader = "X-API-Key"
+	}
+	if opts.Headers == (ResponseHeaders{}) {
+		opts.Headers = HeaderNames(DefaultHeaderPrefix)
+	}
+	if opts.UsageRetention <= 0 {
+		opts.UsageRetention = 400 * 24 * time.Hour
+	}
+	if opts.HistoryRetention <= 0 {
+		opts.HistoryRetention = 7 * 24 * time.Hour
+	}
+	if opts.KeyEventsMaxKeys <= 0 {
+		opts.KeyEventsMaxKeys = 100000
+	}
+	if opts.KeyEventsFormat == "" {
+		opts.KeyEventsFormat = EventsNative
+	}
+	if opts.ShadowMaxKeys <= 0 {
+		opts.ShadowMaxKeys = 100000
+	}
+	if opts.KeyHashGrace <= 0 {
+		opts.KeyHashGrace = time.Hour
+	}
+	if len(opts.ReconcileSecret) == 0 {
+		opts.ReconcileSecret = h.reconcileKey
+	}
+	if opts.ReconcileTTL <= 0 {
+		opts.ReconcileTTL = time.Hour
+	}
+	if opts.Clock == nil {
+		opts.Clock = backend.SystemClock{}
+	}
+	if opts.ErrorFormat == "" {
+		opts.ErrorFormat = ErrorsJSON
+	}
+	if opts.KeyCountInterval <= 0 {
+		opts.KeyCountInterval = time.Minute
+	}
+	opts.apiKeys = newAPIKeys(opts.APIKeys)
+	h.rotateKeyHash(&opts)
+	opts.tenantOpts = tenantOptions(opts)
+	h.opts.Store(&opts)
+}
+
+// OnReload installs the function run by the admin reload endpoint. It is
+// passed the caller, for the audit trail.
+func (h *Handler) OnReload(reload func(actor string) error) {
+	h.reload = reload
+}
+
+// SetAuditLog installs the log served by the admin audit endpoint.
+func (h *Handler) SetAuditLog(log *audit.Log) {
+	h.audit = log
+}
+
+// Health turns 503 once draining starts so load balancers stop routing here.
 func (h *Handler) Health(w http.ResponseWriter, _ *http.Request) {
+	if h.drain.isDraining() {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "draining"})
+		return
+	}
 	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
 }
 
 func (h *Handler) Check(w http.ResponseWriter, r *http.Request) {
-	var req CheckRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid_json"})
+	if !h.admit(w) {
+		return
+	}
+	defer h.drain.leave()
+	opts := h.opts.Load()
+	body := getBuffer()
+	defer putBuffer(body)
+	if !readBody(w, r, body, opts.MaxBodyBytes) {
 		return
 	}
+	req := getCheckRequest()
+	defer putCheckRequest(req)
+	decode := decodeCheckRequest
+	if isForm(r, body.Bytes()) {
+		decode = decodeCheckForm
+	}
+	if err := decode(body.Bytes(), req, opts.StrictRequests); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	h.decide(w, r, req, opts, func(status int, resp *CheckResponse, _ backend.Result) {
+		writeCheckResponse(w, status, resp)
+	})
+}
+
+// decide checks req, answering failures itself and the decision through
+// reply, with the result it was made from.
+func (h *Handler) decide(w http.ResponseWriter, r *http.Request, req *CheckRequest, opts *Options, reply func(status int, resp *CheckResponse, res backend.Result)) {
+	if code := consult(r, req, opts); code != "" {
+		writeJSON(w, requestErrorStatus(code), ErrorResponse{Error: code})
+		return
+	}
+	if code := h.normalize(r, req, opts); code != "" {
+		writeJSON(w, requestErrorStatus(code), ErrorResponse{Error: code})
+		return
+	}
+	if resp, status, ok := settled(req); ok {
+		reply(status, &resp, backend.Result{})
+		return
+	}
+	if h.redirectToOwner(w, r, req) {
+		return
+	}
+
+	ctx, cancel := backendContext(r.Context(), opts.BackendTimeout)
+	res, err := h.evaluate(ctx, req, opts)
+	cancel()
+	if errors.Is(err, errKeyLimit) {
+		h.recordDecision(req, false)
+		writeJSON(w, http.StatusTooManyRequests, ErrorResponse{Error: "tenant_key_limit"})
+		return
+	}
+	degraded := false
+	if err != nil {
+		var ok bool
+		if res, ok = h.substitute(req, opts, err); !ok {
+			status, code := backendFailure(ctx, err)
+			opts.Headers.setChaos(w, req.chaos)
+			writeJSON(w, status, ErrorResponse{Error: code})
+			return
+		}
+		degraded = true
+	} else {
+		h.shadow.observe(req, res, opts)
+	}
+
+	h.recordDecision(req, res.Allowed)
+	h.recordHistory(req, res.Allowed, res.Remaining, opts)
+	h.hot.record(req, opts)
+	h.events.observe(req, res.Allowed, res.Remaining, res.ResetAtMs, opts)
+	h.replicate(req, res.Allowed, degraded)
+	var hits []int64
+	if !degraded {
+		ctx, cancel := backendContext(backend.WithPrimaryReads(r.Context()), opts.BackendTimeout)
+		hits = h.recentHits(ctx, req)
+		cancel()
+	}
+	res.RetryAfterMs = jitter(res.RetryAfterMs, opts)
+	interval := suggestedInterval(req, res, degraded, opts)
+
+	status := http.StatusOK
+	if !res.Allowed {
+		status = http.StatusTooManyRequests
+	}
+
+	resp := getCheckResponse()
+	defer putCheckResponse(resp)
+	*resp = CheckResponse{
+		Key:                 req.Key,
+		Tenant:              req.Tenant,
+		Algorithm:           req.Algorithm,
+		Allowed:             res.Allowed,
+		Remaining:           res.Remaining,
+		ResetAtMs:           res.ResetAtMs,
+		RetryAfterMs:        res.RetryAfterMs,
+		SuggestedIntervalMs: interval,
+		CurrentCount:        res.CurrentCount,
+		ComputedCount:       res.ComputedCount,
+		ParamsChanged:       res.ParamsChanged,
+		ErrorBound:          res.ErrorBound,
+		Group:               groupResponse(req, res),
+		Dimensions:          dimensionResponses(req, res),
+		Multiplier:          req.multiplier,
+		Grace:               res.Grace,
+		ShadowMode:          req.shadowMode,
+		RecentHitsMs:        hits,
+		Degraded:            degraded,
+		MemoryPressure:      h.memoryPressure(),
+		Chaos:               req.chaos,
+		DecisionID:          decisionID(req, res, degraded, opts),
+	}
+	describeLimit(resp, req)
+	if !res.Allowed {
+		opts.scoped(req.Tenant).DenyBody.fill(resp, req)
+	}
+	opts.Headers.setDecision(w, resp)
+	opts.Headers.setChaos(w, req.chaos)
+	reply(status, resp, res)
+}
+
+// evaluate admits req's key under its tenant's key cap and decides req,
+// also charging the key under the previous hash secret while it counts.
+func (h *Handler) evaluate(ctx context.Context, req *CheckRequest, opts *Options) (backend.Result, error) {
+	faults := opts.Chaos.roll()
+	req.chaos = faults.tag()
+	if faults.latency {
+		if err := chaosWait(ctx, opts.Chaos.Latency); err != nil {
+			return backend.Result{}, err
+		}
+	}
+	switch {
+	case faults.fail:
+		return backend.Result{}, errChaos
+	case faults.deny:
+		return chaosDenial(), nil
+	}
+	if err := h.trackKey(ctx, req, opts); err != nil {
+		return backend.Result{}, err
+	}
+	// Sketch counters are shared with other keys, a key's previous hash
+	// may have state of its own, and a group may have no budget left.
+	if req.Algorithm != backend.CountMinSketch && req.previousKey == "" && req.Group == "" {
+		if res, ok := h.newKeys.admit(req, opts); ok {
+			return res, nil
+		}
+	}
+	breq := toBackendRequest(req)
+	res, err := backend.Evaluate(ctx, h.backend, breq)
+	h.compareCheck(req, breq, res, err)
+	if err == nil && req.previousKey != "" {
+		previous := toBackendRequest(req)
+		previous.Key = tenantKey(req.Tenant, req.previousKey)
+		// The group and dimensions were charged with the current key.
+		previous.GroupKey, previous.GroupLimit, previous.GroupWindowMs = "", 0, 0
+		previous.Dimensions = nil
+		if prev, prevErr := backend.Evaluate(ctx, h.backend, previous); prevErr == nil {
+			res = stricter(res, prev)
+		}
+	}
+	if err == nil {
+		res = enforce(req, h.graceful(ctx, req, res, opts))
+	}
+	return res, err
+}
+
+// jitter spreads a denial's retry after by up to opts.RetryJitter of it.
+// It only ever adds: retrying sooner would be denied again.
+func jitter(retryAfterMs int64, opts *Options) int64 {
+	spread := int64(float64(retryAfterMs) * opts.RetryJitter)
+	if spread <= 0 {
+		return retryAfterMs
+	}
+	return retryAfterMs + rand.Int63n(spread+1)
+}
+
+// GroupBudget is a fixed window of Limit per WindowMs shared by every key
+// of a group, in each tenant.
+type GroupBudget struct {
+	Limit    int64
+	WindowMs int64
+}
+
+// maxWeight bounds a weighted_fair_share client's weight, so shares are
+// computed exactly.
+const maxWeight = 1000000
 
+// normalizeRequest trims and defaults req in place, derives its key and
+// validates the algorithm parameters. It returns an error code, or "" if req
+// is ready to evaluate.
+func normalizeRequest(r *http.Request, req *CheckRequest, opts *Options) string {
 	req.Algorithm = strings.ToLower(strings.TrimSpace(req.Algorithm))
 	req.Key = strings.TrimSpace(req.Key)
 	req.UserID = strings.TrimSpace(req.UserID)
 	req.DeviceID = strings.TrimSpace(req.DeviceID)
 	req.JWT = strings.TrimSpace(req.JWT)
+	req.Timezone = strings.TrimSpace(req.Timezone)
+	req.Client = strings.TrimSpace(req.Client)
+	req.Group = strings.TrimSpace(req.Group)
+	opts, code := scopeTenant(r, req, opts)
+	if code != "" {
+		return code
+	}
+	if req.Domain != "" {
+		if code := resolveDescriptor(req, opts); code != "" {
+			return code
+		}
+	}
 	if req.JWT == "" {
 		req.JWT = bearerToken(r.Header.Get("Authorization"))
 	}
 	if req.Key == "" {
-		req.Key = buildKey(req)
+		if req.UserID == "" && req.DeviceID == "" && req.JWT != "" && opts.JWTVerifier != nil {
+			if err := opts.JWTVerifier.Verify(r.Context(), req.JWT); err != nil {
+				if errors.Is(err, jwt.ErrKeysUnavailable) {
+					return "jwks_unavailable"
+				}
+				return "invalid_jwt"
+			}
+		}
+		req.Key = buildKey(*req)
+		if opts.KeyHashSecret != "" {
+			hashIdentity(req, opts)
+		}
+	}
+	if req.hook != nil {
+		applyHook(req)
+		if req.hook.Decision != "" && req.Key != "" {
+			return ""
+		}
+	}
+	if req.unlimited {
+		return ""
 	}
 	if req.Key == "" || req.Algorithm == "" {
-		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "key_and_algorithm_required"})
-		return
+		return "key_and_algorithm_required"
+	}
+	if req.Request != nil {
+		if req.Cost != 0 {
+			return "cost_and_request_conflict"
+		}
+		cost, ok := opts.Costs.price(*req.Request)
+		if !ok {
+			return "unpriced_request"
+		}
+		req.Cost = cost
 	}
 	if req.Cost == 0 {
 		req.Cost = 1
 	}
+	if req.Cost < 0 {
+		return "invalid_cost"
+	}
+	if req.Cost > opts.MaxCost {
+		return "cost_too_large"
+	}
+	if len(req.Dimensions) > 0 && req.Group != "" {
+		return "dimensions_and_group_conflict"
+	}
+	if req.Reconcile {
+		if code := checkReconcile(req); code != "" {
+			return code
+		}
+	}
+	if req.Group != "" {
+		group, ok := opts.Groups[req.Group]
+		if !ok {
+			return "unknown_group"
+		}
+		if req.Cost > group.Limit {
+			return "cost_exceeds_group_limit"
+		}
+		req.groupLimit, req.groupWindowMs = group.Limit, group.WindowMs
+	}
+	req.FailMode = strings.ToLower(strings.TrimSpace(req.FailMode))
+	switch req.FailMode {
+	case "":
+		req.FailMode = opts.FailMode
+	case FailModeError, FailModeOpen, FailModeClosed:
+	default:
+		return "invalid_fail_mode"
+	}
 
-	var (
-		res backend.Result
-		err error
-	)
+	if req.Timezone != "" && req.Algorithm != backend.FixedWindow {
+		return "timezone_requires_fixed_window"
+	}
+	if req.MaxDebt != 0 && req.Algorithm != backend.TokenBucket {
+		return "max_debt_requires_token_bucket"
+	}
+	if req.RecentHits != 0 {
+		if req.Algorithm != backend.SlidingWindowLog {
+			return "recent_hits_requires_sliding_window_log"
+		}
+		if req.RecentHits < 0 || req.RecentHits > maxRecentHits {
+			return "invalid_recent_hits"
+		}
+	}
+
+	// Limits the check sent itself are held to the allowed algorithms.
+	if req.policy == "" && len(opts.Algorithms) > 0 && !slices.Contains(opts.Algorithms, req.Algorithm) {
+		return "algorithm_not_allowed"
+	}
+	if code := checkParams(req, opts); code != "" {
+		return code
+	}
+	return checkDimensions(req, opts)
+}
 
+// checkParams validates the algorithm parameters of req.
+func checkParams(req *CheckRequest, opts *Options) string {
 	switch req.Algorithm {
-	case "token_bucket":
+	case backend.TokenBucket:
 		if req.Capacity <= 0 || req.RefillPerSec <= 0 {
-			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "capacity_and_refill_per_sec_required"})
-			return
+			return "capacity_and_refill_per_sec_required"
 		}
-		res, err = h.backend.TokenBucketAllow(r.Context(), req.Key, req.Capacity, req.RefillPerSec, req.Cost)
-	case "leaky_bucket":
+		return checkCapacity(req, opts)
+	case backend.LeakyBucket:
 		if req.Capacity <= 0 || req.LeakPerSec <= 0 {
-			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "capacity_and_leak_per_sec_required"})
-			return
+			return "capacity_and_leak_per_sec_required"
 		}
-		res, err = h.backend.LeakyBucketAllow(r.Context(), req.Key, req.Capacity, req.LeakPerSec, req.Cost)
-	case "fixed_window":
+		return checkCapacity(req, opts)
+	case backend.FixedWindow, backend.SlidingWindowLog, backend.SlidingWindowCounter, backend.CountMinSketch:
 		if req.Limit <= 0 || req.WindowMs <= 0 {
-			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "limit_and_window_ms_required"})
-			return
+			return "limit_and_window_ms_required"
 		}
-		res, err = h.backend.FixedWindowAllow(r.Context(), req.Key, req.Limit, req.WindowMs, req.Cost)
-	case "sliding_window_log":
-		if req.Limit <= 0 || req.WindowMs <= 0 {
-			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "limit_and_window_ms_required"})
-			return
+		switch {
+		case req.Limit > opts.MaxLimit:
+			return "limit_too_large"
+		case req.Algorithm == backend.SlidingWindowLog && opts.MaxLogLimit > 0 && req.Limit > opts.MaxLogLimit:
+			return "limit_too_large"
+		case req.WindowMs > opts.MaxWindowMs:
+			return "window_ms_too_large"
+		case req.Cost > req.Limit:
+			return "cost_exceeds_limit"
+		case req.Timezone != "" && !backend.ValidZone(req.Timezone, req.WindowMs):
+			return "invalid_timezone"
 		}
-		res, err = h.backend.SlidingWindowLogAllow(r.Context(), req.Key, req.Limit, req.WindowMs, req.Cost)
-	case "sliding_window_counter":
+		return ""
+	case backend.WeightedFairShare:
 		if req.Limit <= 0 || req.WindowMs <= 0 {
-			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "limit_and_window_ms_required"})
-			return
+			return "limit_and_window_ms_required"
+		}
+		if req.Weight == 0 {
+			req.Weight = 1
+		}
+		switch {
+		case req.Client == "":
+			return "client_required"
+		case req.Weight < 0:
+			return "invalid_weight"
+		case req.Weight > maxWeight:
+			return "weight_too_large"
+		case req.Limit > opts.MaxLimit:
+			return "limit_too_large"
+		case req.WindowMs > opts.MaxWindowMs:
+			return "window_ms_too_large"
+		case req.Cost > req.Limit:
+			return "cost_exceeds_limit"
 		}
-		res, err = h.backend.SlidingWindowCounterAllow(r.Context(), req.Key, req.Limit, req.WindowMs, req.Cost)
+		return ""
 	default:
-		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "unsupported_algorithm"})
-		return
+		return "unsupported_algorithm"
 	}
+}
 
-	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "backend_error"})
-		return
+// requestErrorStatus is the status for a normalizeRequest error code.
+func requestErrorStatus(code string) int {
+	switch code {
+	case "invalid_jwt":
+		return http.StatusUnauthorized
+	case "tenant_forbidden", "algorithm_not_allowed":
+		return http.StatusForbidden
+	case "jwks_unavailable", "hook_unavailable":
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusBadRequest
 	}
+}
 
-	w.Header().Set("X-RateLimit-Remaining", int64ToString(res.Remaining))
-	w.Header().Set("X-RateLimit-Reset-Ms", int64ToString(res.ResetAtMs))
-	w.Header().Set("X-RateLimit-Retry-After-Ms", int64ToString(res.RetryAfterMs))
-	status := http.StatusOK
-	if !res.Allowed {
-		status = http.StatusTooManyRequests
+// checkCapacity applies the bucket caps. A cost above capacity, and the
+// debt a token bucket may run up, could never be allowed, so it is rejected
+// rather than denied forever.
+func checkCapacity(req *CheckRequest, opts *Options) string {
+	switch {
+	case req.Capacity > opts.MaxCapacity:
+		return "capacity_too_large"
+	case req.MaxDebt < 0:
+		return "invalid_max_debt"
+	case req.MaxDebt > opts.MaxCapacity:
+		return "max_debt_too_large"
+	case req.Cost > req.Capacity+req.MaxDebt:
+		return "cost_exceeds_capacity"
 	}
+	return ""
+}
 
-	writeJSON(w, status, CheckResponse{
-		Key:           req.Key,
-		Algorithm:     req.Algorithm,
-		Allowed:       res.Allowed,
-		Remaining:     res.Remaining,
-		ResetAtMs:     res.ResetAtMs,
-		RetryAfterMs:  res.RetryAfterMs,
-		CurrentCount:  res.CurrentCount,
-		ComputedCount: res.ComputedCount,
-	})
+func backendContext(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(parent)
+	}
+	return context.WithTimeout(parent, timeout)
+}
+
+// backendFailure maps a backend error to a status and error code, telling
+// a call that ran out of its time budget apart from one that failed.
+func backendFailure(ctx context.Context, err error) (int, string) {
+	if errors.Is(err, backend.ErrInvalidParams) {
+		return http.StatusBadRequest, "invalid_parameters"
+	}
+	if errors.Is(err, backend.ErrGroupUnsupported) {
+		return http.StatusNotImplemented, "groups_unavailable"
+	}
+	if errors.Is(err, backend.ErrMultipliersUnsupported) {
+		return http.StatusNotImplemented, "multipliers_unavailable"
+	}
+	if errors.Is(err, backend.ErrDimensionsUnsupported) {
+		return http.StatusNotImplemented, "dimensions_unavailable"
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout, "backend_timeout"
+	}
+	return http.StatusInternalServerError, "backend_error"
+}
+
+// substitute is the decision served for req when the backend failed with
+// err: the shadow's where it knows the key, otherwise the fail mode's.
+func (h *Handler) substitute(req *CheckRequest, opts *Options, err error) (backend.Result, bool) {
+	if res, ok := h.shadow.decide(req, opts, err); ok {
+		return res, true
+	}
+	return failResult(req.FailMode, err)
+}
+
+// failResult is the decision substituted for a failed backend call, or
+// false when the failure should be reported as an error. Rejected
+// parameters, and groups and dimensions the backend cannot charge, are
+// always reported.
+func failResult(mode string, err error) (backend.Result, bool) {
+	if errors.Is(err, backend.ErrInvalidParams) || errors.Is(err, backend.ErrGroupUnsupported) || errors.Is(err, backend.ErrDimensionsUnsupported) {
+		return backend.Result{}, false
+	}
+	switch mode {
+	case FailModeOpen:
+		return backend.Result{Allowed: true}, true
+	case FailModeClosed:
+		return backend.Result{Allowed: false}, true
+	default:
+		return backend.Result{}, false
+	}
+}
+
+func toBackendRequest(req *CheckRequest) backend.Request {
+	breq := backend.Request{
+		Algorithm:    req.Algorithm,
+		Key:          tenantKey(req.Tenant, req.Key),
+		Limit:        req.Limit,
+		WindowMs:     req.WindowMs,
+		Capacity:     req.Capacity,
+		RefillPerSec: req.RefillPerSec,
+		LeakPerSec:   req.LeakPerSec,
+		MaxDebt:      req.MaxDebt,
+		Cost:         req.Cost,
+		Timezone:     req.Timezone,
+		Client:       req.Client,
+		Weight:       req.Weight,
+	}
+	if req.Group != "" {
+		breq.GroupKey = tenantKey(req.Tenant, req.Group)
+		breq.GroupLimit, breq.GroupWindowMs = req.groupLimit, req.groupWindowMs
+	}
+	breq.Dimensions = req.Dimensions
+	return breq
+}
+
+// maxJSONDepth is far deeper than any request shape the API accepts, so
+// only hostile payloads hit it before encoding/json has to recurse.
+const maxJSONDepth = 16
+
+// readBody reads the request body into buf, answering 413 past limit and
+// 400 for nesting beyond maxJSONDepth. It reports whether the caller can go
+// on to decode buf.
+func readBody(w http.ResponseWriter, r *http.Request, buf *bytes.Buffer, limit int64) bool {
+	if _, err := buf.ReadFrom(http.MaxBytesReader(w, r.Body, limit)); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeJSON(w, http.StatusRequestEntityTooLarge, ErrorResponse{Error: "body_too_large"})
+		} else {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid_json"})
+		}
+		return false
+	}
+	if jsonDepthExceeds(buf.Bytes(), maxJSONDepth) {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "json_too_deep"})
+		return false
+	}
+	return true
 }
 
 func writeJSON(w http.ResponseWriter, status int, payload interface{}) {
+	if e, ok := payload.(ErrorResponse); ok {
+		if p := problemWriterOf(w); p != nil {
+			writeProblem(w, p, status, e)
+			return
+		}
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	_ = json.NewEncoder(w).Encode(payload)
 }
 
+func writeCheckResponse(w http.ResponseWriter, status int, resp *CheckResponse) {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	buf.Write(appendCheckResponse(buf.AvailableBuffer(), resp))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(buf.Bytes())
+}
+
 func int64ToString(v int64) string {
 	return strconv.FormatInt(v, 10)
 }
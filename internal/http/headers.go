@@ -0,0 +1,122 @@
+package httpapi
+
+import "net/http"
+
+// DefaultHeaderPrefix starts the names of the headers carrying a decision
+// unless configured otherwise.
+const DefaultHeaderPrefix = "X-RateLimit-"
+
+// ResponseHeaders names the headers that carry a decision, for deployments
+// behind gateways that set headers of the same names. An empty name leaves
+// its header out; Disabled leaves them all out.
+type ResponseHeaders struct {
+	Disabled            bool
+	Remaining           string
+	ResetMs             string
+	RetryAfterMs        string
+	SuggestedIntervalMs string
+	ParamsChanged       string
+	Degraded            string
+	MemoryPressure      string
+	Policy              string
+	Chaos               string
+}
+
+// HeaderNames returns the headers named with prefix, such as
+// prefix+"Remaining". The policy header keeps its standard name,
+// RateLimit-Policy.
+func HeaderNames(prefix string) ResponseHeaders {
+	return ResponseHeaders{
+		Remaining:           prefix + "Remaining",
+		ResetMs:             prefix + "Reset-Ms",
+		RetryAfterMs:        prefix + "Retry-After-Ms",
+		SuggestedIntervalMs: prefix + "Suggested-Interval-Ms",
+		ParamsChanged:       prefix + "Params-Changed",
+		Degraded:            prefix + "Degraded",
+		MemoryPressure:      prefix + "Memory-Pressure",
+		Policy:              "RateLimit-Policy",
+		Chaos:               prefix + "Chaos",
+	}
+}
+
+// Rename sets the header known as key, one of remaining, reset_ms,
+// retry_after_ms, suggested_interval_ms, params_changed, degraded,
+// memory_pressure, policy and chaos, to name, reporting false for other keys.
+func (h *ResponseHeaders) Rename(key, name string) bool {
+	switch key {
+	case "remaining":
+		h.Remaining = name
+	case "reset_ms":
+		h.ResetMs = name
+	case "retry_after_ms":
+		h.RetryAfterMs = name
+	case "suggested_interval_ms":
+		h.SuggestedIntervalMs = name
+	case "params_changed":
+		h.ParamsChanged = name
+	case "degraded":
+		h.Degraded = name
+	case "memory_pressure":
+		h.MemoryPressure = name
+	case "policy":
+		h.Policy = name
+	case "chaos":
+		h.Chaos = name
+	default:
+		return false
+	}
+	return true
+}
+
+// decision returns the headers carrying resp's decision, in order. A zero
+// suggested interval is left out, as is the policy of a check without
+// limits.
+func (h *ResponseHeaders) decision(resp *CheckResponse) [][2]string {
+	if h.Disabled {
+		return nil
+	}
+	var out [][2]string
+	add := func(name, value string) {
+		if name != "" && value != "" {
+			out = append(out, [2]string{name, value})
+		}
+	}
+	if resp.Degraded {
+		add(h.Degraded, "true")
+	}
+	if resp.MemoryPressure {
+		add(h.MemoryPressure, "true")
+	}
+	if resp.ParamsChanged {
+		add(h.ParamsChanged, "true")
+	}
+	add(h.Remaining, int64ToString(resp.Remaining))
+	add(h.ResetMs, int64ToString(resp.ResetAtMs))
+	add(h.RetryAfterMs, int64ToString(resp.RetryAfterMs))
+	if resp.SuggestedIntervalMs > 0 {
+		add(h.SuggestedIntervalMs, int64ToString(resp.SuggestedIntervalMs))
+	}
+	if h.Policy != "" {
+		add(h.Policy, policyHeader(resp))
+	}
+	return out
+}
+
+// setDecision sets the headers carrying resp's decision on w.
+func (h *ResponseHeaders) setDecision(w http.ResponseWriter, resp *CheckResponse) {
+	for _, header := range h.decision(resp) {
+		w.Header().Set(header[0], header[1])
+	}
+}
+
+// chaos returns the header tagging a response with the faults injected
+// into its check, if any.
+func (h *ResponseHeaders) chaos(faults string) (string, bool) {
+	return h.Chaos, !h.Disabled && h.Chaos != "" && faults != ""
+}
+
+func (h *ResponseHeaders) setChaos(w http.ResponseWriter, faults string) {
+	if name, ok := h.chaos(faults); ok {
+		w.Header().Set(name, faults)
+	}
+}
@@ -0,0 +1,152 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"rate-limiter-service/internal/backend"
+)
+
+// historyRecorder sums key decisions per hour in memory until they are
+// flushed to the backend, like usageRecorder does per tenant.
+type historyRecorder struct {
+	mu      sync.Mutex
+	pending map[int64]map[string]backend.KeyUsage
+}
+
+func (u *historyRecorder) add(hourMs int64, key string, usage backend.KeyUsage) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.pending == nil {
+		u.pending = make(map[int64]map[string]backend.KeyUsage)
+	}
+	keys := u.pending[hourMs]
+	if keys == nil {
+		keys = make(map[string]backend.KeyUsage)
+		u.pending[hourMs] = keys
+	}
+	total := keys[key]
+	total.Add(usage)
+	keys[key] = total
+}
+
+// take empties the recorder and returns what it held, by hour.
+func (u *historyRecorder) take() map[int64]map[string]backend.KeyUsage {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	pending := u.pending
+	u.pending = nil
+	return pending
+}
+
+// recordHistory counts a decision on req's key when key history is on.
+// The peak is how much of the limit the decision left used.
+func (h *Handler) recordHistory(req *CheckRequest, allowed bool, remaining int64, opts *Options) {
+	if !opts.KeyHistory {
+		return
+	}
+	limit := req.Limit
+	if req.Algorithm == backend.TokenBucket || req.Algorithm == backend.LeakyBucket {
+		limit = req.Capacity
+	}
+	usage := backend.KeyUsage{Peak: max(0, limit-remaining), Limit: limit}
+	if allowed {
+		usage.Allowed, usage.Cost = 1, req.Cost
+	} else {
+		usage.Denied = 1
+	}
+	now := time.Now().UnixMilli()
+	h.history.add(now-now%backend.HourMs, tenantKey(req.Tenant, req.Key), usage)
+}
+
+// flushHistory adds the key usage counted since the last flush to the
+// backend. Counts that cannot be written are kept for the next flush.
+func (h *Handler) flushHistory(ctx context.Context) error {
+	store, ok := h.backend.(backend.KeyHistoryStore)
+	if !ok {
+		return nil
+	}
+	retention := h.opts.Load().HistoryRetention
+	var errs []error
+	for hourMs, keys := range h.history.take() {
+		if err := store.AddKeyUsage(ctx, hourMs, keys, retention); err != nil {
+			for key, usage := range keys {
+				h.history.add(hourMs, key, usage)
+			}
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// KeyHistory reports a key's allowed and denied checks, the quota they
+// consumed and the most of its limit in use, per hour or per day (UTC), to
+// show how close a caller has come to its limit. The key is the path
+// segment, in the tenant query parameter's namespace; the range parameters
+// are those of TenantUsage.
+func (h *Handler) KeyHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "method_not_allowed"})
+		return
+	}
+	store, ok := h.backend.(backend.KeyHistoryStore)
+	if !ok {
+		writeJSON(w, http.StatusNotImplemented, ErrorResponse{Error: "history_unavailable"})
+		return
+	}
+	opts := h.opts.Load()
+	if !opts.KeyHistory {
+		writeJSON(w, http.StatusNotImplemented, ErrorResponse{Error: "history_unavailable",
+			Message: "key history is off; set USAGE_KEY_HISTORY"})
+		return
+	}
+	req := CheckRequest{Key: strings.TrimSpace(r.PathValue("key")), Tenant: r.URL.Query().Get("tenant")}
+	if _, code := scopeTenant(r, &req, opts); code != "" {
+		writeJSON(w, requestErrorStatus(code), ErrorResponse{Error: code})
+		return
+	}
+	if req.Key == "" {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "key_required"})
+		return
+	}
+	granularity, bucketMs, fromMs, toMs, ok := usageRange(w, r)
+	if !ok {
+		return
+	}
+	parent, ok := readContext(r)
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid_consistency"})
+		return
+	}
+
+	ctx, cancel := backendContext(parent, opts.BackendTimeout)
+	defer cancel()
+	// Include this instance's unflushed counts.
+	if err := h.flushHistory(ctx); err != nil {
+		log.Printf("key history flush failed: %v", err)
+	}
+	buckets, err := store.KeyUsage(ctx, tenantKey(req.Tenant, req.Key), bucketMs, fromMs, toMs)
+	if errors.Is(err, backend.ErrHistoryUnsupported) {
+		writeJSON(w, http.StatusNotImplemented, ErrorResponse{Error: "history_unavailable"})
+		return
+	}
+	if err != nil {
+		status, code := backendFailure(ctx, err)
+		writeJSON(w, status, ErrorResponse{Error: code})
+		return
+	}
+	resp := KeyHistoryResponse{Key: req.Key, Tenant: req.Tenant, Granularity: granularity, Buckets: buckets}
+	if resp.Buckets == nil {
+		resp.Buckets = []backend.KeyUsageBucket{}
+	}
+	for _, b := range buckets {
+		resp.Total.Add(b.KeyUsage)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
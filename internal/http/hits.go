@@ -0,0 +1,28 @@
+package httpapi
+
+import (
+	"context"
+
+	"rate-limiter-service/internal/backend"
+)
+
+// maxRecentHits bounds how many hits a check may ask for.
+const maxRecentHits = 100
+
+// recentHits lists the hits req asked for, or nil when it asked for none or
+// the backend cannot list them. Listing is best effort: it runs after the
+// check and does not fail it.
+func (h *Handler) recentHits(ctx context.Context, req *CheckRequest) []int64 {
+	if req.RecentHits <= 0 {
+		return nil
+	}
+	lister, ok := h.backend.(backend.HitLister)
+	if !ok {
+		return nil
+	}
+	hits, err := lister.RecentHits(ctx, tenantKey(req.Tenant, req.Key), req.WindowMs, req.RecentHits)
+	if err != nil {
+		return nil
+	}
+	return hits
+}
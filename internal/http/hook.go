@@ -0,0 +1,79 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"rate-limiter-service/internal/hook"
+)
+
+// HookInput is what the decision hook is told about a check, as sent but
+// for its JWT.
+type HookInput struct {
+	Check       CheckRequest `json:"check"`
+	Endpoint    string       `json:"endpoint"`
+	Caller      string       `json:"caller,omitempty"`
+	BoundTenant string       `json:"bound_tenant,omitempty"`
+	RemoteAddr  string       `json:"remote_addr"`
+}
+
+// consult asks the decision hook, if any, about req before it is
+// normalized; normalizeRequest applies the answer. A failed hook fails the
+// check unless opts.HookSkipOnError.
+func consult(r *http.Request, req *CheckRequest, opts *Options) string {
+	if opts.Hook == nil {
+		return ""
+	}
+	input := HookInput{
+		Check:       *req,
+		Endpoint:    r.URL.Path,
+		Caller:      actorOf(r),
+		BoundTenant: tenantOf(r),
+		RemoteAddr:  r.RemoteAddr,
+	}
+	input.Check.JWT = ""
+	res, err := opts.Hook.Decide(r.Context(), input)
+	if err != nil {
+		if opts.HookSkipOnError {
+			return ""
+		}
+		return "hook_unavailable"
+	}
+	req.hook = &res
+	return ""
+}
+
+// applyHook replaces req's limit and cost with those the hook chose.
+func applyHook(req *CheckRequest) {
+	res := req.hook
+	if p := res.Policy; p != nil {
+		req.Algorithm = p.Algorithm
+		req.Limit, req.WindowMs = p.Limit, p.WindowMs
+		req.Capacity, req.RefillPerSec, req.LeakPerSec = p.Capacity, p.RefillPerSec, p.LeakPerSec
+		req.unlimited, req.shadowMode = false, false
+		req.policy = p.Name
+		if req.policy == "" {
+			req.policy = "hook"
+		}
+	}
+	if res.Cost > 0 {
+		req.Cost, req.Request = res.Cost, nil
+	}
+}
+
+// settled answers checks decided without their limit: those the hook
+// forced and those whose descriptor has no limit.
+func settled(req *CheckRequest) (CheckResponse, int, bool) {
+	resp := CheckResponse{Key: req.Key, Tenant: req.Tenant, Algorithm: req.Algorithm}
+	switch {
+	case req.hook != nil && req.hook.Decision == hook.Allow:
+		resp.Allowed, resp.HookDecision = true, hook.Allow
+		return resp, http.StatusOK, true
+	case req.hook != nil && req.hook.Decision == hook.Deny:
+		resp.RetryAfterMs, resp.HookDecision = req.hook.RetryAfterMs, hook.Deny
+		return resp, http.StatusTooManyRequests, true
+	case req.unlimited:
+		resp.Allowed, resp.Unlimited = true, true
+		return resp, http.StatusOK, true
+	}
+	return CheckResponse{}, 0, false
+}
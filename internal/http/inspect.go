@@ -0,0 +1,87 @@
+package httpapi
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"rate-limiter-service/internal/backend"
+)
+
+// InspectResponse shows what the backend stores for a key. Key is the
+// backend key, after tenant scoping and identity hashing.
+type InspectResponse struct {
+	Key     string                 `json:"key"`
+	NowMs   int64                  `json:"now_ms"`
+	Records []backend.StoredRecord `json:"records"`
+}
+
+// Inspect returns the raw state stored for the key named by the key,
+// user_id or device_id query parameter, as a check with the same fields
+// would name it, and how long each piece has left to live.
+func (h *Handler) Inspect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "method_not_allowed"})
+		return
+	}
+	inspector, ok := h.backend.(backend.Inspector)
+	if !ok {
+		writeJSON(w, http.StatusNotImplemented, ErrorResponse{Error: "inspect_unavailable"})
+		return
+	}
+	query := r.URL.Query()
+	opts := h.opts.Load()
+	req := CheckRequest{
+		Key:      strings.TrimSpace(query.Get("key")),
+		Tenant:   query.Get("tenant"),
+		UserID:   strings.TrimSpace(query.Get("user_id")),
+		DeviceID: strings.TrimSpace(query.Get("device_id")),
+	}
+	key, code := adminKey(r, &req, opts)
+	if code != "" {
+		writeJSON(w, requestErrorStatus(code), ErrorResponse{Error: code})
+		return
+	}
+	parent, ok := readContext(r)
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid_consistency"})
+		return
+	}
+
+	ctx, cancel := backendContext(parent, opts.BackendTimeout)
+	defer cancel()
+	records, err := inspector.Inspect(ctx, key)
+	if errors.Is(err, backend.ErrInspectUnsupported) {
+		writeJSON(w, http.StatusNotImplemented, ErrorResponse{Error: "inspect_unavailable"})
+		return
+	}
+	if err != nil {
+		status, code := backendFailure(ctx, err)
+		writeJSON(w, status, ErrorResponse{Error: code})
+		return
+	}
+	if records == nil {
+		records = []backend.StoredRecord{}
+	}
+	writeJSON(w, http.StatusOK, InspectResponse{Key: key, NowMs: time.Now().UnixMilli(), Records: records})
+}
+
+// adminKey names the backend key that req's key, user_id or device_id
+// stand for, as in a check with the same fields, or returns an error code.
+func adminKey(r *http.Request, req *CheckRequest, opts *Options) (string, string) {
+	if _, code := scopeTenant(r, req, opts); code != "" {
+		return "", code
+	}
+	if req.Key == "" {
+		req.Key = buildKey(*req)
+		if opts.KeyHashSecret != "" {
+			hashIdentity(req, opts)
+		}
+	}
+	if req.Key == "" {
+		return "", "key_required"
+	}
+	return tenantKey(req.Tenant, req.Key), ""
+}
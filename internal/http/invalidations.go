@@ -0,0 +1,92 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"rate-limiter-service/internal/backend"
+)
+
+// invalidate drops what this instance holds about the state inv names and
+// tells the other instances to do the same.
+func (h *Handler) invalidate(ctx context.Context, inv backend.Invalidation) {
+	h.Invalidated(ctx, inv)
+	inval, ok := h.backend.(backend.Invalidator)
+	if !ok {
+		return
+	}
+	if err := inval.PublishInvalidation(ctx, inv); err != nil && !errors.Is(err, backend.ErrInvalidationsUnsupported) {
+		log.Printf("invalidation publish failed: %v", err)
+	}
+}
+
+// Invalidated applies an invalidation: the key's shadow is no longer
+// trusted, the key no longer counts as new, and multipliers are read
+// again.
+func (h *Handler) Invalidated(ctx context.Context, inv backend.Invalidation) {
+	switch inv.Kind {
+	case backend.InvalidateKey:
+		h.shadow.forget(inv.Key)
+		h.newKeys.touch(inv.Key, h.opts.Load())
+	case backend.InvalidateMultipliers:
+		ctx, cancel := backendContext(ctx, h.opts.Load().BackendTimeout)
+		defer cancel()
+		if err := h.RefreshMultipliers(ctx); err != nil {
+			log.Printf("multiplier refresh failed: %v", err)
+		}
+	}
+}
+
+// FollowInvalidations applies the invalidations every instance publishes
+// until ctx ends or the subscription fails. It returns
+// backend.ErrInvalidationsUnsupported at once when the backend cannot pass
+// them.
+func (h *Handler) FollowInvalidations(ctx context.Context) error {
+	inval, ok := h.backend.(backend.Invalidator)
+	if !ok {
+		return backend.ErrInvalidationsUnsupported
+	}
+	return inval.FollowInvalidations(ctx, func(inv backend.Invalidation) {
+		h.Invalidated(ctx, inv)
+	})
+}
+
+// forget stops trusting the shadow of key, or of every key when key is
+// "". Cost still owed to the backend is kept for ReconcileShadow.
+func (s *shadowStore) forget(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, e := range s.entries {
+		if key != "" && k != key {
+			continue
+		}
+		if e.owed > 0 {
+			e.confirmed = time.Time{}
+		} else {
+			delete(s.entries, k)
+		}
+	}
+}
+
+// touch marks key as seen, or every key when key is "", so that checks on
+// it go to the backend, whose state of it changed. Every key is marked by
+// filling the previous filter, which the next rotation replaces.
+func (f *newKeyFilter) touch(key string, opts *Options) {
+	if opts.NewKeyFilterKeys <= 0 {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if key != "" {
+		f.seen(newKeyBits(key, opts.NewKeyFilterKeys), opts.NewKeyFilterKeys)
+		return
+	}
+	f.seen(nil, opts.NewKeyFilterKeys) // sizes the filters
+	f.previous = make([]uint64, len(f.current))
+	for i := range f.previous {
+		f.previous[i] = ^uint64(0)
+	}
+	f.current, f.added = make([]uint64, len(f.current)), 0
+}
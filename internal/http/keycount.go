@@ -0,0 +1,101 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"rate-limiter-service/internal/backend"
+)
+
+// KeyCountResponse is how many keys each algorithm tracks, per backend.
+type KeyCountResponse struct {
+	NowMs  int64              `json:"now_ms"`
+	Counts []backend.KeyCount `json:"counts"`
+}
+
+// keyCountCache keeps the last key counts, so a scrape does not scan the
+// whole backend each time.
+type keyCountCache struct {
+	mu         sync.Mutex
+	counts     []backend.KeyCount
+	counted    time.Time
+	refreshing bool
+}
+
+// KeyCounts counts the keys each algorithm tracks on every backend, on
+// demand. On a Redis backend this scans every key, so it may take a while.
+func (h *Handler) KeyCounts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "method_not_allowed"})
+		return
+	}
+	counter, ok := h.backend.(backend.KeyCounter)
+	if !ok {
+		writeJSON(w, http.StatusNotImplemented, ErrorResponse{Error: "key_count_unavailable"})
+		return
+	}
+	// The counts span every tenant.
+	if tenantOf(r) != "" {
+		writeJSON(w, http.StatusForbidden, ErrorResponse{Error: "tenant_forbidden"})
+		return
+	}
+	counts, err := counter.CountKeys(r.Context())
+	if errors.Is(err, backend.ErrKeyCountUnsupported) {
+		writeJSON(w, http.StatusNotImplemented, ErrorResponse{Error: "key_count_unavailable"})
+		return
+	}
+	if err != nil {
+		status, code := backendFailure(r.Context(), err)
+		writeJSON(w, status, ErrorResponse{Error: code})
+		return
+	}
+	h.keyCounts.store(counts)
+	if counts == nil {
+		counts = []backend.KeyCount{}
+	}
+	writeJSON(w, http.StatusOK, KeyCountResponse{NowMs: time.Now().UnixMilli(), Counts: counts})
+}
+
+func (c *keyCountCache) store(counts []backend.KeyCount) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts, c.counted = counts, time.Now()
+}
+
+// cachedKeyCounts returns the last key counts, counting again in the
+// background once they are older than the KeyCountInterval.
+func (h *Handler) cachedKeyCounts() []backend.KeyCount {
+	counter, ok := h.backend.(backend.KeyCounter)
+	if !ok {
+		return nil
+	}
+	interval := h.opts.Load().KeyCountInterval
+	c := &h.keyCounts
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.refreshing && time.Since(c.counted) >= interval {
+		c.refreshing = true
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			defer cancel()
+			counts, err := counter.CountKeys(ctx)
+			if err != nil && !errors.Is(err, backend.ErrKeyCountUnsupported) {
+				log.Printf("key count failed: %v", err)
+			}
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			// A failed count is kept from being retried until the next
+			// interval too.
+			c.refreshing, c.counted = false, time.Now()
+			if err == nil {
+				c.counts = counts
+			}
+		}()
+	}
+	return c.counts
+}
@@ -0,0 +1,68 @@
+package httpapi
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"rate-limiter-service/internal/backend"
+)
+
+// hashIdentity replaces the user or device id in req.Key with its HMAC
+// under the key hash secret, so raw identifiers never reach the backend.
+// While the previous secret is in its grace window the key it gives is
+// kept in req.previousKey.
+func hashIdentity(req *CheckRequest, opts *Options) {
+	var kind, id string
+	switch {
+	case req.UserID != "":
+		kind, id = "user", req.UserID
+	case req.DeviceID != "":
+		kind, id = "device", req.DeviceID
+	default:
+		return
+	}
+	req.Key = identityKey(opts.KeyHashSecret, kind, id)
+	if opts.KeyHashPrevious != "" && time.Now().Before(opts.keyHashPreviousUntil) {
+		req.previousKey = identityKey(opts.KeyHashPrevious, kind, id)
+	}
+}
+
+func identityKey(secret, kind, id string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(kind + ":" + id))
+	return kind + ":" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// rotateKeyHash sets when the previous hash secret stops counting. A reload
+// that changes the secret keeps the outgoing one for the grace window by
+// itself; a previous secret given in the configuration gets the window from
+// when it is first seen.
+func (h *Handler) rotateKeyHash(opts *Options) {
+	old := h.opts.Load()
+	switch {
+	case old != nil && old.KeyHashSecret != "" && old.KeyHashSecret != opts.KeyHashSecret:
+		opts.KeyHashPrevious = old.KeyHashSecret
+		opts.keyHashPreviousUntil = time.Now().Add(opts.KeyHashGrace)
+	case old != nil && old.KeyHashSecret == opts.KeyHashSecret &&
+		(opts.KeyHashPrevious == "" || opts.KeyHashPrevious == old.KeyHashPrevious):
+		opts.KeyHashPrevious = old.KeyHashPrevious
+		opts.keyHashPreviousUntil = old.keyHashPreviousUntil
+	case opts.KeyHashPrevious != "":
+		opts.keyHashPreviousUntil = time.Now().Add(opts.KeyHashGrace)
+	}
+}
+
+// stricter merges the decisions for a key under the current and previous
+// hash secrets: a request is allowed only if both allow it.
+func stricter(cur, prev backend.Result) backend.Result {
+	cur.Allowed = cur.Allowed && prev.Allowed
+	cur.Remaining = min(cur.Remaining, prev.Remaining)
+	cur.ResetAtMs = max(cur.ResetAtMs, prev.ResetAtMs)
+	cur.RetryAfterMs = max(cur.RetryAfterMs, prev.RetryAfterMs)
+	cur.CurrentCount = max(cur.CurrentCount, prev.CurrentCount)
+	cur.ComputedCount = max(cur.ComputedCount, prev.ComputedCount)
+	cur.ErrorBound = max(cur.ErrorBound, prev.ErrorBound)
+	return cur
+}
@@ -0,0 +1,155 @@
+package httpapi
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"rate-limiter-service/internal/backend"
+	"rate-limiter-service/internal/metrics"
+)
+
+// latencyBuckets are the request duration histogram's bounds, in seconds.
+var latencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+
+// handlerMetrics are what the handler counts for /metrics and pushes.
+type handlerMetrics struct {
+	registry  *metrics.Registry
+	requests  metrics.Counter
+	latency   metrics.Histogram
+	decisions metrics.Counter
+	compared  metrics.Counter
+}
+
+func (h *Handler) initMetrics() {
+	r := metrics.NewRegistry()
+	h.metrics = handlerMetrics{
+		registry:  r,
+		requests:  r.Counter("ratelimiter_http_requests_total", "HTTP requests by route and status code.", "route", "code"),
+		latency:   r.Histogram("ratelimiter_http_request_duration_seconds", "HTTP request durations by route.", latencyBuckets, "route"),
+		decisions: r.Counter("ratelimiter_decisions_total", "Checks decided, by decision.", "decision"),
+		compared:  r.Counter("ratelimiter_compared_checks_total", "Checks also evaluated on the candidate backend, by outcome.", "outcome"),
+	}
+	gauge := func(name, help string, fn func(s StatsView) int) {
+		r.GaugeFunc(name, help, func() float64 { return float64(fn(h.stats())) })
+	}
+	gauge("ratelimiter_draining", "1 while the instance is draining.", func(s StatsView) int {
+		if s.Draining {
+			return 1
+		}
+		return 0
+	})
+	gauge("ratelimiter_inflight_checks", "Checks being decided.", func(s StatsView) int { return s.InflightChecks })
+	gauge("ratelimiter_check_streams", "Open WebSocket check streams.", func(s StatsView) int { return s.CheckStreams })
+	gauge("ratelimiter_event_streams", "Open key event streams.", func(s StatsView) int { return s.EventStreams })
+	gauge("ratelimiter_followed_keys", "Keys followed for lifecycle events.", func(s StatsView) int { return s.FollowedKeys })
+	gauge("ratelimiter_shadowed_keys", "Keys with a shadow for backend outages.", func(s StatsView) int { return s.ShadowedKeys })
+	budget := func(fn func(s backend.BudgetStats) int64) func() float64 {
+		return func() float64 {
+			budgeted, ok := h.backend.(backend.Budgeted)
+			if !ok {
+				return 0
+			}
+			return float64(fn(budgeted.Budget()))
+		}
+	}
+	r.GaugeFunc("ratelimiter_memory_bytes", "Estimated size of the memory backend's key states.", budget(func(s backend.BudgetStats) int64 { return s.Bytes }))
+	r.GaugeFunc("ratelimiter_memory_budget_bytes", "Memory budget of the key states; 0 for none.", budget(func(s backend.BudgetStats) int64 { return s.MaxBytes }))
+	r.CounterFunc("ratelimiter_memory_evicted_keys_total", "Key states evicted to stay within the memory budget.", budget(func(s backend.BudgetStats) int64 { return s.Evicted }))
+	r.GaugeVecFunc("ratelimiter_tracked_keys", "Keys tracked by each algorithm, per backend and route, as of the last count.",
+		[]string{"backend", "route", "algorithm"}, func(set func(v float64, values ...string)) {
+			for _, c := range h.cachedKeyCounts() {
+				set(float64(c.Keys), c.Backend, c.Route, c.Algorithm)
+			}
+		})
+}
+
+// MetricsRegistry returns the registry the handler's metrics are kept in.
+func (h *Handler) MetricsRegistry() *metrics.Registry {
+	return h.metrics.registry
+}
+
+// memoryPressure reports whether the backend is evicting keys, or about
+// to, to stay within its memory budget.
+func (h *Handler) memoryPressure() bool {
+	budgeted, ok := h.backend.(backend.Budgeted)
+	return ok && budgeted.Budget().Pressure
+}
+
+// recordDecision counts a decision on req, for the tenant's usage and the
+// decision metrics.
+func (h *Handler) recordDecision(req *CheckRequest, allowed bool) {
+	h.usage.record(req.Tenant, allowed, req.Cost)
+	decision := "denied"
+	if allowed {
+		decision = "allowed"
+	}
+	h.metrics.decisions.Inc(decision)
+}
+
+// Metrics serves the metrics in the Prometheus text format.
+func (h *Handler) Metrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "method_not_allowed"})
+		return
+	}
+	if !h.opts.Load().Metrics {
+		writeJSON(w, http.StatusNotImplemented, ErrorResponse{Error: "metrics_unavailable",
+			Message: "the metrics endpoint is off; set METRICS"})
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	h.metrics.registry.WriteText(w)
+}
+
+// instrument counts the requests mux serves and how long they take, by
+// the pattern they matched.
+func (h *Handler) instrument(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, route := mux.Handler(r)
+		if route == "" {
+			route = "unmatched"
+		}
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		started := time.Now()
+		mux.ServeHTTP(sw, r)
+		h.metrics.requests.Inc(route, strconv.Itoa(sw.status))
+		h.metrics.latency.Observe(time.Since(started).Seconds(), route)
+	})
+}
+
+// statusWriter remembers the status a handler answered with; a taken over
+// connection counts as 101.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if !sw.wroteHeader {
+		sw.status, sw.wroteHeader = status, true
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(p []byte) (int, error) {
+	sw.wroteHeader = true
+	return sw.ResponseWriter.Write(p)
+}
+
+func (sw *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(sw.ResponseWriter).Hijack()
+	if err == nil {
+		sw.status, sw.wroteHeader = http.StatusSwitchingProtocols, true
+	}
+	return conn, rw, err
+}
+
+// Unwrap lets http.ResponseController reach the connection, for streams.
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
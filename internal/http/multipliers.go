@@ -0,0 +1,290 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"rate-limiter-service/internal/audit"
+	"rate-limiter-service/internal/backend"
+)
+
+// maxMultiplier bounds a multiplier's factor.
+const maxMultiplier = 100
+
+// maxMultiplierTTL bounds how long a multiplier lasts, a year, well short
+// of overflowing its expiry.
+const maxMultiplierTTL = 365 * 24 * time.Hour
+
+// Multiplier targets are stored as "key <backend key>" or "prefix <backend
+// key prefix>".
+const (
+	multiplierKey    = "key"
+	multiplierPrefix = "prefix"
+)
+
+// MultiplierRequest sets or removes a temporary multiplier on the limits of
+// one key, named as a check would name it, or of every key starting with
+// Prefix when no key is named; an empty Prefix covers the tenant, or every
+// key without one.
+type MultiplierRequest struct {
+	Tenant   string  `json:"tenant,omitempty"`
+	Key      string  `json:"key,omitempty"`
+	UserID   string  `json:"user_id,omitempty"`
+	DeviceID string  `json:"device_id,omitempty"`
+	Prefix   string  `json:"prefix,omitempty"`
+	Factor   float64 `json:"factor,omitempty"`
+	TTLMs    int64   `json:"ttl_ms,omitempty"`
+	Reason   string  `json:"reason,omitempty"`
+}
+
+// MultiplierEntry is one multiplier in force. Match is the backend key, or
+// key prefix, it applies to.
+type MultiplierEntry struct {
+	Kind        string  `json:"kind"`
+	Match       string  `json:"match"`
+	Factor      float64 `json:"factor"`
+	ExpiresAtMs int64   `json:"expires_at_ms"`
+}
+
+type MultipliersResponse struct {
+	Multipliers []MultiplierEntry `json:"multipliers"`
+}
+
+// Multipliers lists the multipliers in force on GET, sets one on POST and
+// removes one on DELETE. Checks apply them as they are evaluated: limits
+// and capacities are multiplied by the factor, and so are bucket rates.
+func (h *Handler) Multipliers(w http.ResponseWriter, r *http.Request) {
+	store, ok := h.backend.(backend.MultiplierStore)
+	if !ok {
+		writeJSON(w, http.StatusNotImplemented, ErrorResponse{Error: "multipliers_unavailable"})
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		h.listMultipliers(w, r, store)
+	case http.MethodPost, http.MethodDelete:
+		h.changeMultiplier(w, r, store)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "method_not_allowed"})
+	}
+}
+
+func (h *Handler) listMultipliers(w http.ResponseWriter, r *http.Request, store backend.MultiplierStore) {
+	opts := h.opts.Load()
+	ctx, cancel := backendContext(r.Context(), opts.BackendTimeout)
+	defer cancel()
+	all, err := store.Multipliers(ctx)
+	if err != nil {
+		status, code := backendFailure(ctx, err)
+		writeJSON(w, status, ErrorResponse{Error: code})
+		return
+	}
+	h.multipliers.Store(&all)
+	bound := tenantOf(r)
+	out := MultipliersResponse{Multipliers: []MultiplierEntry{}}
+	for target, m := range all {
+		kind, match, _ := strings.Cut(target, " ")
+		if bound != "" && !strings.HasPrefix(match, TenantKeyPrefix(bound)) {
+			continue
+		}
+		out.Multipliers = append(out.Multipliers, MultiplierEntry{Kind: kind, Match: match, Factor: m.Factor, ExpiresAtMs: m.ExpiresAtMs})
+	}
+	sort.Slice(out.Multipliers, func(i, j int) bool {
+		a, b := out.Multipliers[i], out.Multipliers[j]
+		return a.Match < b.Match || a.Match == b.Match && a.Kind < b.Kind
+	})
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (h *Handler) changeMultiplier(w http.ResponseWriter, r *http.Request, store backend.MultiplierStore) {
+	opts := h.opts.Load()
+	body := getBuffer()
+	defer putBuffer(body)
+	if !readBody(w, r, body, opts.MaxBodyBytes) {
+		return
+	}
+	var req MultiplierRequest
+	if err := json.Unmarshal(body.Bytes(), &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid_json"})
+		return
+	}
+	target, code := multiplierTarget(r, &req, opts)
+	if code == "" && r.Method == http.MethodPost {
+		switch {
+		case req.Factor <= 0 || req.Factor > maxMultiplier || math.IsNaN(req.Factor):
+			code = "invalid_factor"
+		case req.TTLMs <= 0:
+			code = "ttl_ms_required"
+		case req.TTLMs > maxMultiplierTTL.Milliseconds():
+			code = "ttl_ms_too_large"
+		}
+	}
+	if code != "" {
+		writeJSON(w, requestErrorStatus(code), ErrorResponse{Error: code})
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "reason_required"})
+		return
+	}
+
+	ctx, cancel := backendContext(r.Context(), opts.BackendTimeout)
+	defer cancel()
+	var err error
+	m := backend.Multiplier{Factor: req.Factor, ExpiresAtMs: time.Now().UnixMilli() + req.TTLMs}
+	if r.Method == http.MethodPost {
+		err = store.SetMultiplier(ctx, target, m)
+	} else {
+		err = store.DeleteMultiplier(ctx, target)
+	}
+	h.recordMultiplier(r, req, target, err)
+	if err != nil {
+		status, code := backendFailure(ctx, err)
+		writeJSON(w, status, ErrorResponse{Error: code})
+		return
+	}
+	// This instance applies the change at once, the others when told or
+	// on their next refresh.
+	h.invalidate(ctx, backend.Invalidation{Kind: backend.InvalidateMultipliers})
+	if r.Method == http.MethodDelete {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	kind, match, _ := strings.Cut(target, " ")
+	writeJSON(w, http.StatusOK, MultiplierEntry{Kind: kind, Match: match, Factor: m.Factor, ExpiresAtMs: m.ExpiresAtMs})
+}
+
+// multiplierTarget names the store entry req is about, settling its tenant
+// and deriving its key as a check would.
+func multiplierTarget(r *http.Request, req *MultiplierRequest, opts *Options) (string, string) {
+	check := CheckRequest{
+		Tenant:   req.Tenant,
+		Key:      strings.TrimSpace(req.Key),
+		UserID:   strings.TrimSpace(req.UserID),
+		DeviceID: strings.TrimSpace(req.DeviceID),
+	}
+	if _, code := scopeTenant(r, &check, opts); code != "" {
+		return "", code
+	}
+	req.Tenant = check.Tenant
+	if check.Key == "" {
+		check.Key = buildKey(check)
+		if check.Key != "" && opts.KeyHashSecret != "" {
+			hashIdentity(&check, opts)
+		}
+	}
+	if check.Key == "" {
+		return multiplierPrefix + " " + tenantKey(check.Tenant, req.Prefix), ""
+	}
+	if req.Prefix != "" {
+		return "", "key_and_prefix_conflict"
+	}
+	req.Key = check.Key
+	return multiplierKey + " " + tenantKey(check.Tenant, check.Key), ""
+}
+
+func (h *Handler) recordMultiplier(r *http.Request, req MultiplierRequest, target string, err error) {
+	if h.audit == nil {
+		return
+	}
+	entry := audit.Entry{
+		Actor:  actorOf(r),
+		Action: "multiplier.delete",
+		Tenant: req.Tenant,
+		Target: target,
+		After:  map[string]string{"reason": req.Reason},
+	}
+	if r.Method == http.MethodPost {
+		entry.Action = "multiplier.set"
+		entry.After["factor"] = strconv.FormatFloat(req.Factor, 'g', -1, 64)
+		entry.After["ttl_ms"] = strconv.FormatInt(req.TTLMs, 10)
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	if auditErr := h.audit.Record(entry); auditErr != nil {
+		log.Printf("audit record failed: %v", auditErr)
+	}
+}
+
+// RefreshMultipliers reloads the multipliers checks apply from the
+// backend, where other instances may have changed them.
+func (h *Handler) RefreshMultipliers(ctx context.Context) error {
+	store, ok := h.backend.(backend.MultiplierStore)
+	if !ok {
+		return nil
+	}
+	all, err := store.Multipliers(ctx)
+	if errors.Is(err, backend.ErrMultipliersUnsupported) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	h.multipliers.Store(&all)
+	return nil
+}
+
+// multiply scales req's limits by the multiplier of its key or, failing
+// that, of the longest prefix of it that has one.
+func (h *Handler) multiply(req *CheckRequest) {
+	all := h.multipliers.Load()
+	if all == nil || len(*all) == 0 {
+		return
+	}
+	m, ok := multiplierFor(*all, tenantKey(req.Tenant, req.Key), time.Now().UnixMilli())
+	if !ok {
+		return
+	}
+	scale := func(n int64) int64 {
+		if n <= 0 {
+			return n
+		}
+		return max(1, int64(float64(n)*m.Factor))
+	}
+	req.Limit, req.Capacity = scale(req.Limit), scale(req.Capacity)
+	req.RefillPerSec *= m.Factor
+	req.LeakPerSec *= m.Factor
+	for i := range req.Dimensions {
+		d := &req.Dimensions[i]
+		d.Limit, d.Capacity = scale(d.Limit), scale(d.Capacity)
+		d.RefillPerSec *= m.Factor
+		d.LeakPerSec *= m.Factor
+	}
+	req.multiplier = m.Factor
+}
+
+func multiplierFor(all map[string]backend.Multiplier, key string, nowMs int64) (backend.Multiplier, bool) {
+	if m, ok := all[multiplierKey+" "+key]; ok && m.ExpiresAtMs > nowMs {
+		return m, true
+	}
+	var found backend.Multiplier
+	longest := -1
+	for target, m := range all {
+		kind, prefix, _ := strings.Cut(target, " ")
+		if kind == multiplierPrefix && len(prefix) > longest && strings.HasPrefix(key, prefix) && m.ExpiresAtMs > nowMs {
+			found, longest = m, len(prefix)
+		}
+	}
+	return found, longest >= 0
+}
+
+// normalize prepares req as normalizeRequest does, then applies its
+// multiplier.
+func (h *Handler) normalize(r *http.Request, req *CheckRequest, opts *Options) string {
+	if code := normalizeRequest(r, req, opts); code != "" {
+		return code
+	}
+	h.multiply(req)
+	return ""
+}
@@ -0,0 +1,150 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"sync"
+
+	"rate-limiter-service/internal/backend"
+)
+
+// newKeyHashes is how many bits of the filter each key sets; with about
+// ten bits per key it gives a false positive rate near 1%.
+const newKeyHashes = 7
+
+// newKeyFilter remembers the keys this instance checked recently in two
+// bloom filters: keys go into the current one, and once it holds
+// NewKeyFilterKeys it becomes the previous one and a new one starts. A key
+// in neither was not checked here since, so a check on it cannot be over
+// any limit and is allowed without asking the backend; the check is
+// charged to the backend later by WriteBackNewKeys.
+type newKeyFilter struct {
+	mu       sync.Mutex
+	size     int
+	current  []uint64
+	previous []uint64
+	added    int
+	// pending are checks allowed without the backend and not yet charged
+	// to it.
+	pending []backend.Request
+}
+
+// admit records req's key as seen. It reports whether the key was new,
+// in which case the check is queued and res is the decision a key with no
+// state gets.
+func (f *newKeyFilter) admit(req *CheckRequest, opts *Options) (res backend.Result, ok bool) {
+	if opts.NewKeyFilterKeys <= 0 {
+		return backend.Result{}, false
+	}
+	breq := toBackendRequest(req)
+	f.mu.Lock()
+	seen := f.seen(newKeyBits(breq.Key, opts.NewKeyFilterKeys), opts.NewKeyFilterKeys)
+	f.mu.Unlock()
+	if seen {
+		return backend.Result{}, false
+	}
+	res, err := backend.Evaluate(context.Background(), backend.NewMemoryBackend(backend.MemoryOptions{}), breq)
+	if err != nil || !res.Allowed {
+		return backend.Result{}, false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// Without room the check goes to the backend like any other.
+	if len(f.pending) >= opts.NewKeyFilterKeys {
+		return backend.Result{}, false
+	}
+	f.pending = append(f.pending, breq)
+	return res, true
+}
+
+// mark records req's key as seen, for checks decided by the backend
+// outside of admit.
+func (f *newKeyFilter) mark(req *CheckRequest, opts *Options) {
+	if opts.NewKeyFilterKeys <= 0 {
+		return
+	}
+	bits := newKeyBits(toBackendRequest(req).Key, opts.NewKeyFilterKeys)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.seen(bits, opts.NewKeyFilterKeys)
+}
+
+// seen reports whether the key with bits was added to either filter, and
+// adds it to the current one. f.mu must be held.
+func (f *newKeyFilter) seen(bits []uint64, size int) bool {
+	if f.size != size {
+		words := (size*10 + 63) / 64
+		f.size, f.current, f.previous, f.added = size, make([]uint64, words), nil, 0
+	}
+	inCurrent, inPrevious := true, f.previous != nil
+	for _, bit := range bits {
+		word, mask := bit/64, uint64(1)<<(bit%64)
+		if f.current[word]&mask == 0 {
+			inCurrent = false
+			f.current[word] |= mask
+		}
+		if inPrevious && f.previous[word]&mask == 0 {
+			inPrevious = false
+		}
+	}
+	if !inCurrent {
+		f.added++
+		if f.added >= size {
+			f.previous, f.current, f.added = f.current, make([]uint64, len(f.current)), 0
+		}
+	}
+	return inCurrent || inPrevious
+}
+
+// newKeyBits picks key's bits in a filter sized for size keys by double
+// hashing.
+func newKeyBits(key string, size int) []uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32|1
+	n := uint64((size*10+63)/64) * 64
+	bits := make([]uint64, newKeyHashes)
+	for i := range bits {
+		bits[i] = (h1 + uint64(i)*h2) % n
+	}
+	return bits
+}
+
+func (f *newKeyFilter) take() []backend.Request {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	pending := f.pending
+	f.pending = nil
+	return pending
+}
+
+func (f *newKeyFilter) putBack(reqs []backend.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pending = append(f.pending, reqs...)
+}
+
+// WriteBackNewKeys charges the backend for checks on new keys allowed
+// without it, in one batch. Charges that fail are kept for the next call;
+// one the backend denies because other instances used the key up in the
+// meantime is dropped, the check having gone over the limit.
+func (h *Handler) WriteBackNewKeys(ctx context.Context) error {
+	reqs := h.newKeys.take()
+	if len(reqs) == 0 {
+		return nil
+	}
+	_, errs := h.evaluateBatch(ctx, reqs, h.opts.Load().BatchConcurrency)
+	var failed []backend.Request
+	for i, err := range errs {
+		if err != nil && !errors.Is(err, backend.ErrInvalidParams) {
+			failed = append(failed, reqs[i])
+		}
+	}
+	if len(failed) > 0 {
+		h.newKeys.putBack(failed)
+		return errors.Join(errs...)
+	}
+	return nil
+}
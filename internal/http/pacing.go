@@ -0,0 +1,61 @@
+package httpapi
+
+import (
+	"math"
+
+	"rate-limiter-service/internal/backend"
+)
+
+// suggestedInterval is how long a client sending checks like req should
+// wait between them to never be denied, given the allowed decision res:
+// the time a bucket takes to refill or drain req's cost, or the time to
+// the window's reset spread evenly over the checks that still fit in it.
+// A group budget the check was charged to, and each of its dimensions,
+// pace it too. It returns 0 for
+// denials, substituted decisions and checks that need no pacing.
+func suggestedInterval(req *CheckRequest, res backend.Result, degraded bool, opts *Options) int64 {
+	if !res.Allowed || degraded || req.Cost <= 0 {
+		return 0
+	}
+	nowMs := opts.Clock.Now().UnixMilli()
+	interval := limitInterval(req.Algorithm, req.Cost, req.RefillPerSec, req.LeakPerSec, res, nowMs)
+	if len(res.Dimensions) == len(req.Dimensions) {
+		for i, d := range req.Dimensions {
+			interval = max(interval, limitInterval(req.Algorithm, d.Cost, d.RefillPerSec, d.LeakPerSec, res.Dimensions[i], nowMs))
+		}
+	}
+	if res.Group != nil {
+		interval = max(interval, windowInterval(req.Cost, res.Group.Remaining, res.Group.ResetAtMs, nowMs))
+	}
+	return interval
+}
+
+// limitInterval paces checks of cost against one limit of algorithm that
+// decided res.
+func limitInterval(algorithm string, cost int64, refillPerSec, leakPerSec float64, res backend.Result, nowMs int64) int64 {
+	switch algorithm {
+	case backend.TokenBucket:
+		return bucketInterval(cost, refillPerSec)
+	case backend.LeakyBucket:
+		return bucketInterval(cost, leakPerSec)
+	default:
+		return windowInterval(cost, res.Remaining, res.ResetAtMs, nowMs)
+	}
+}
+
+func bucketInterval(cost int64, perSec float64) int64 {
+	if perSec <= 0 {
+		return 0
+	}
+	return int64(math.Ceil(float64(cost) * 1000 / perSec))
+}
+
+// windowInterval spreads the time until resetAtMs over the checks of cost
+// that remaining still allows, and the first one after the reset.
+func windowInterval(cost, remaining, resetAtMs, nowMs int64) int64 {
+	until := resetAtMs - nowMs
+	if until <= 0 {
+		return 0
+	}
+	return (until + remaining/cost) / (remaining/cost + 1)
+}
@@ -0,0 +1,113 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"rate-limiter-service/internal/backend"
+)
+
+// Peek reports the decision a check would get without charging for it or
+// counting the key towards its tenant's cap. It is always 200; allowed says
+// whether the check would pass.
+func (h *Handler) Peek(w http.ResponseWriter, r *http.Request) {
+	if !h.admit(w) {
+		return
+	}
+	defer h.drain.leave()
+	opts := h.opts.Load()
+	body := getBuffer()
+	defer putBuffer(body)
+	if !readBody(w, r, body, opts.MaxBodyBytes) {
+		return
+	}
+	req := getCheckRequest()
+	defer putCheckRequest(req)
+	if err := decodeCheckRequest(body.Bytes(), req, opts.StrictRequests); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	if code := consult(r, req, opts); code != "" {
+		writeJSON(w, requestErrorStatus(code), ErrorResponse{Error: code})
+		return
+	}
+	if code := h.normalize(r, req, opts); code != "" {
+		writeJSON(w, requestErrorStatus(code), ErrorResponse{Error: code})
+		return
+	}
+	if resp, _, ok := settled(req); ok {
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+	peeker, ok := h.backend.(backend.Peeker)
+	if !ok {
+		writeJSON(w, http.StatusNotImplemented, ErrorResponse{Error: "peek_unavailable"})
+		return
+	}
+	parent, ok := readContext(r)
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid_consistency"})
+		return
+	}
+
+	ctx, cancel := backendContext(parent, opts.BackendTimeout)
+	defer cancel()
+	res, err := peekResult(ctx, peeker, req)
+	if errors.Is(err, backend.ErrPeekUnsupported) {
+		writeJSON(w, http.StatusNotImplemented, ErrorResponse{Error: "peek_unavailable"})
+		return
+	}
+	if err != nil {
+		status, code := backendFailure(ctx, err)
+		writeJSON(w, status, ErrorResponse{Error: code})
+		return
+	}
+	resp := CheckResponse{
+		Key:           req.Key,
+		Tenant:        req.Tenant,
+		Algorithm:     req.Algorithm,
+		Allowed:       res.Allowed,
+		Remaining:     res.Remaining,
+		ResetAtMs:     res.ResetAtMs,
+		RetryAfterMs:  res.RetryAfterMs,
+		CurrentCount:  res.CurrentCount,
+		ComputedCount: res.ComputedCount,
+		ParamsChanged: res.ParamsChanged,
+		ErrorBound:    res.ErrorBound,
+		Group:         groupResponse(req, res),
+		Multiplier:    req.multiplier,
+		ShadowMode:    req.shadowMode,
+		RecentHitsMs:  h.recentHits(ctx, req),
+	}
+	describeLimit(&resp, req)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// peekResult peeks at req's decision, under the previous hash secret's
+// key too while it counts.
+func peekResult(ctx context.Context, peeker backend.Peeker, req *CheckRequest) (backend.Result, error) {
+	res, err := peeker.Peek(ctx, toBackendRequest(req))
+	if err == nil && req.previousKey != "" {
+		previous := toBackendRequest(req)
+		previous.Key = tenantKey(req.Tenant, req.previousKey)
+		if prev, prevErr := peeker.Peek(ctx, previous); prevErr == nil {
+			res = stricter(res, prev)
+		}
+	}
+	return enforce(req, res), err
+}
+
+// readContext applies the consistency query parameter of a read: eventual,
+// the default, lets a replica answer; strong reads from the primary, so the
+// caller sees its own writes. It reports false for any other value.
+func readContext(r *http.Request) (context.Context, bool) {
+	switch r.URL.Query().Get("consistency") {
+	case "", "eventual":
+		return r.Context(), true
+	case "strong":
+		return backend.WithPrimaryReads(r.Context()), true
+	default:
+		return nil, false
+	}
+}
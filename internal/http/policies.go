@@ -0,0 +1,98 @@
+package httpapi
+
+import (
+	"net/http"
+	"sort"
+)
+
+// PolicyView is the policy a tenant's checks are held to, or the global
+// one when Tenant is empty.
+type PolicyView struct {
+	Tenant      string           `json:"tenant,omitempty"`
+	MaxCost     int64            `json:"max_cost"`
+	MaxCapacity int64            `json:"max_capacity"`
+	MaxLimit    int64            `json:"max_limit"`
+	MaxWindowMs int64            `json:"max_window_ms"`
+	MaxLogLimit int64            `json:"max_log_limit,omitempty"`
+	Algorithms  []string         `json:"algorithms,omitempty"`
+	RetryJitter float64          `json:"retry_jitter"`
+	Costs       map[string]int64 `json:"costs,omitempty"`
+	Grace       *GraceView       `json:"grace,omitempty"`
+	MaxKeys     int64            `json:"max_keys,omitempty"`
+	KeyIdleMs   int64            `json:"key_idle_ms,omitempty"`
+	EvictKeys   bool             `json:"evict_keys,omitempty"`
+}
+
+type GraceView struct {
+	Requests   int64 `json:"requests,omitempty"`
+	DurationMs int64 `json:"duration_ms,omitempty"`
+	PeriodMs   int64 `json:"period_ms"`
+}
+
+// PoliciesResponse lists the policies checks are held to.
+type PoliciesResponse struct {
+	Policies []PolicyView `json:"policies"`
+}
+
+// Policies lists the global policy and every configured tenant's, or only
+// that of the tenant query parameter; callers bound to a tenant only see
+// theirs.
+func (h *Handler) Policies(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "method_not_allowed"})
+		return
+	}
+	opts := h.opts.Load()
+	tenant := r.URL.Query().Get("tenant")
+	if bound := tenantOf(r); bound != "" {
+		if tenant != "" && tenant != bound {
+			writeJSON(w, http.StatusForbidden, ErrorResponse{Error: "tenant_forbidden"})
+			return
+		}
+		tenant = bound
+	}
+	if tenant != "" {
+		scoped, ok := opts.tenantOpts[tenant]
+		if !ok {
+			if opts.KnownTenantsOnly {
+				writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "unknown_tenant"})
+				return
+			}
+			scoped = opts
+		}
+		writeJSON(w, http.StatusOK, PoliciesResponse{Policies: []PolicyView{policyView(tenant, scoped, opts.Tenants[tenant])}})
+		return
+	}
+	resp := PoliciesResponse{Policies: []PolicyView{policyView("", opts, TenantPolicies{})}}
+	ids := make([]string, 0, len(opts.tenantOpts))
+	for id := range opts.tenantOpts {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		resp.Policies = append(resp.Policies, policyView(id, opts.tenantOpts[id], opts.Tenants[id]))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func policyView(tenant string, opts *Options, p TenantPolicies) PolicyView {
+	view := PolicyView{
+		Tenant:      tenant,
+		MaxCost:     opts.MaxCost,
+		MaxCapacity: opts.MaxCapacity,
+		MaxLimit:    opts.MaxLimit,
+		MaxWindowMs: opts.MaxWindowMs,
+		MaxLogLimit: opts.MaxLogLimit,
+		Algorithms:  opts.Algorithms,
+		RetryJitter: opts.RetryJitter,
+		Costs:       opts.Costs,
+		MaxKeys:     p.MaxKeys,
+		KeyIdleMs:   p.KeyIdle.Milliseconds(),
+		EvictKeys:   p.EvictKeys,
+	}
+	if g := opts.Grace; g.enabled() {
+		view.Grace = &GraceView{Requests: g.Requests, DurationMs: g.Duration.Milliseconds(), PeriodMs: g.Period.Milliseconds()}
+	}
+	return view
+}
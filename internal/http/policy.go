@@ -0,0 +1,64 @@
+package httpapi
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"rate-limiter-service/internal/backend"
+)
+
+// describeLimit echoes the limits req was decided by, and the policy they
+// came from, in resp, so clients and logs can tell which rule applied.
+func describeLimit(resp *CheckResponse, req *CheckRequest) {
+	resp.Policy = req.policy
+	switch req.Algorithm {
+	case backend.TokenBucket:
+		resp.Capacity, resp.RefillPerSec = req.Capacity, req.RefillPerSec
+	case backend.LeakyBucket:
+		resp.Capacity, resp.LeakPerSec = req.Capacity, req.LeakPerSec
+	default:
+		resp.Limit, resp.WindowMs = req.Limit, req.WindowMs
+	}
+}
+
+// policyHeader is the RateLimit-Policy value for resp's limits, as in the
+// IETF RateLimit header fields draft: the policy's name, its quota q and
+// its window w in seconds. A bucket's window is how long it takes to
+// refill or drain from empty or full. Checks without the policy's name
+// are "default".
+func policyHeader(resp *CheckResponse) string {
+	quota, windowSec := resp.Limit, math.Ceil(float64(resp.WindowMs)/1000)
+	switch {
+	case resp.RefillPerSec > 0:
+		quota, windowSec = resp.Capacity, math.Ceil(float64(resp.Capacity)/resp.RefillPerSec)
+	case resp.LeakPerSec > 0:
+		quota, windowSec = resp.Capacity, math.Ceil(float64(resp.Capacity)/resp.LeakPerSec)
+	}
+	if quota <= 0 || windowSec <= 0 {
+		return ""
+	}
+	name := resp.Policy
+	if name == "" {
+		name = "default"
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, c := range []byte(name) {
+		// A structured field string holds printable ASCII only.
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c >= 0x20 && c < 0x7f:
+			b.WriteByte(c)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	b.WriteString(`";q=`)
+	b.WriteString(strconv.FormatInt(quota, 10))
+	b.WriteString(";w=")
+	b.WriteString(strconv.FormatInt(int64(min(windowSec, 1<<53)), 10))
+	return b.String()
+}
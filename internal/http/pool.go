@@ -0,0 +1,52 @@
+package httpapi
+
+import (
+	"bytes"
+	"sync"
+)
+
+// Buffers that grew past this size (an unusually large body) are dropped
+// rather than pinned in the pool forever.
+const maxPooledBufferSize = 64 << 10
+
+var (
+	checkRequestPool = sync.Pool{
+		New: func() interface{} { return new(CheckRequest) },
+	}
+	checkResponsePool = sync.Pool{
+		New: func() interface{} { return new(CheckResponse) },
+	}
+	bufferPool = sync.Pool{
+		New: func() interface{} { return new(bytes.Buffer) },
+	}
+)
+
+func getCheckRequest() *CheckRequest {
+	return checkRequestPool.Get().(*CheckRequest)
+}
+
+func putCheckRequest(req *CheckRequest) {
+	*req = CheckRequest{}
+	checkRequestPool.Put(req)
+}
+
+func getCheckResponse() *CheckResponse {
+	return checkResponsePool.Get().(*CheckResponse)
+}
+
+func putCheckResponse(resp *CheckResponse) {
+	*resp = CheckResponse{}
+	checkResponsePool.Put(resp)
+}
+
+func getBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBufferSize {
+		return
+	}
+	buf.Reset()
+	bufferPool.Put(buf)
+}
@@ -0,0 +1,121 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// Error formats: ErrorsJSON answers errors as ErrorResponse unless the
+// client accepts application/problem+json; ErrorsProblem always answers
+// them as ProblemResponse.
+const (
+	ErrorsJSON    = "json"
+	ErrorsProblem = "problem"
+)
+
+const problemContentType = "application/problem+json"
+
+// ProblemResponse is an error as RFC 9457 problem details. Type is the
+// error type base URL followed by the error code, or about:blank without
+// one, Title then being the status's. Error keeps the code, as in
+// ErrorResponse, for clients that read it.
+type ProblemResponse struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Error    string       `json:"error"`
+	Fields   []FieldError `json:"fields,omitempty"`
+}
+
+// problemWriter marks a response whose errors are problem details.
+type problemWriter struct {
+	http.ResponseWriter
+	instance string
+	typeBase string
+}
+
+// Unwrap lets http.ResponseController reach the connection, for streams.
+func (p *problemWriter) Unwrap() http.ResponseWriter {
+	return p.ResponseWriter
+}
+
+// problems answers the errors of requests that take problem details as
+// such; writeJSON finds the problemWriter under any other wrapper.
+func (h *Handler) problems(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		opts := h.opts.Load()
+		if opts.ErrorFormat == ErrorsProblem || acceptsProblem(r) {
+			w = &problemWriter{ResponseWriter: w, instance: r.URL.Path, typeBase: opts.ErrorTypeBase}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// acceptsProblem reports whether r's Accept header names
+// application/problem+json with a non-zero quality.
+func acceptsProblem(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		if !strings.Contains(accept, problemContentType) {
+			continue
+		}
+		for _, part := range strings.Split(accept, ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err != nil || mediaType != problemContentType {
+				continue
+			}
+			// q=0, or 0.0 and so on, refuses the type.
+			if q, ok := params["q"]; !ok || strings.Trim(q, "0.") != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// problemWriterOf returns the problemWriter under w, if any.
+func problemWriterOf(w http.ResponseWriter) *problemWriter {
+	for w != nil {
+		if p, ok := w.(*problemWriter); ok {
+			return p
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return nil
+		}
+		w = u.Unwrap()
+	}
+	return nil
+}
+
+func writeProblem(w http.ResponseWriter, p *problemWriter, status int, e ErrorResponse) {
+	problem := ProblemResponse{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   e.Message,
+		Instance: p.instance,
+		Error:    e.Error,
+		Fields:   e.Fields,
+	}
+	if p.typeBase != "" {
+		problem.Type = p.typeBase + e.Error
+		problem.Title = errorTitle(e.Error)
+	}
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(problem)
+}
+
+// errorTitle turns an error code such as tenant_key_limit into a title,
+// "Tenant key limit".
+func errorTitle(code string) string {
+	title := strings.ReplaceAll(code, "_", " ")
+	if title == "" {
+		return title
+	}
+	return strings.ToUpper(title[:1]) + title[1:]
+}
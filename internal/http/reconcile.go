@@ -0,0 +1,223 @@
+package httpapi
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"rate-limiter-service/internal/backend"
+)
+
+// ReconcileRequest settles a check made with "reconcile": true once its
+// real cost is known, such as the tokens an LLM call used against the
+// estimate it was checked with. Cost is the real cost of the key and
+// Dimensions that of each named dimension; those left out keep their
+// estimate.
+type ReconcileRequest struct {
+	DecisionID string           `json:"decision_id"`
+	Cost       *int64           `json:"cost,omitempty"`
+	Dimensions map[string]int64 `json:"dimensions,omitempty"`
+}
+
+// decision is what a decision ID carries: the allowed check, as charged,
+// and when it can no longer be reconciled.
+type decision struct {
+	Key       string          `json:"k"`
+	Tenant    string          `json:"t,omitempty"`
+	Request   backend.Request `json:"r"`
+	ExpiresMs int64           `json:"e"`
+}
+
+// checkReconcile validates a check that asks for a decision ID. Its
+// estimate is settled by an adjustment, which sliding logs, sketches, fair
+// share pools and group budgets cannot take.
+func checkReconcile(req *CheckRequest) string {
+	switch {
+	case req.Group != "":
+		return "reconcile_and_group_conflict"
+	case req.Algorithm == backend.SlidingWindowLog || req.Algorithm == backend.CountMinSketch || req.Algorithm == backend.WeightedFairShare:
+		return "reconcile_unsupported_algorithm"
+	}
+	return ""
+}
+
+// newReconcileKey is the secret decision IDs are signed with when none is
+// configured, so they are only good on this instance.
+func newReconcileKey() []byte {
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	return key
+}
+
+// decisionID signs an allowed check for reconciliation. Checks that did
+// not ask for one, and decisions that charged nothing to the backend, get
+// none.
+func decisionID(req *CheckRequest, res backend.Result, degraded bool, opts *Options) string {
+	if !req.Reconcile || !res.Allowed || degraded || req.unlimited || req.shadowMode {
+		return ""
+	}
+	payload, err := json.Marshal(decision{
+		Key:       req.Key,
+		Tenant:    req.Tenant,
+		Request:   toBackendRequest(req),
+		ExpiresMs: opts.Clock.Now().Add(opts.ReconcileTTL).UnixMilli(),
+	})
+	if err != nil {
+		return ""
+	}
+	mac := hmac.New(sha256.New, opts.ReconcileSecret)
+	mac.Write(payload)
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(payload) + "." + enc.EncodeToString(mac.Sum(nil))
+}
+
+// openDecision verifies id and returns its decision, or an error code.
+func openDecision(id string, opts *Options) (decision, string) {
+	enc := base64.RawURLEncoding
+	body, sig, ok := strings.Cut(id, ".")
+	payload, err := enc.DecodeString(body)
+	given, sigErr := enc.DecodeString(sig)
+	if !ok || err != nil || sigErr != nil {
+		return decision{}, "invalid_decision_id"
+	}
+	mac := hmac.New(sha256.New, opts.ReconcileSecret)
+	mac.Write(payload)
+	var d decision
+	if !hmac.Equal(mac.Sum(nil), given) || json.Unmarshal(payload, &d) != nil {
+		return decision{}, "invalid_decision_id"
+	}
+	if opts.Clock.Now().UnixMilli() >= d.ExpiresMs {
+		return decision{}, "decision_expired"
+	}
+	return d, ""
+}
+
+// Reconcile charges the difference between a reconcilable check's
+// estimate and its real cost: a refund when it cost less, an extra charge
+// when it cost more, even past the limit. Each decision is reconciled once
+// per instance. The response describes the key afterwards, as a peek
+// would.
+func (h *Handler) Reconcile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "method_not_allowed"})
+		return
+	}
+	adjuster, ok := h.backend.(backend.Adjuster)
+	if !ok {
+		writeJSON(w, http.StatusNotImplemented, ErrorResponse{Error: "reconcile_unavailable"})
+		return
+	}
+	opts := h.opts.Load()
+	body := getBuffer()
+	defer putBuffer(body)
+	if !readBody(w, r, body, opts.MaxBodyBytes) {
+		return
+	}
+	var req ReconcileRequest
+	if err := decodeRequest(body.Bytes(), &req, opts.StrictRequests); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	if req.DecisionID == "" {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "decision_id_required"})
+		return
+	}
+	d, code := openDecision(req.DecisionID, opts)
+	if code == "" {
+		code = settle(&d.Request, req, opts)
+	}
+	if code != "" {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: code})
+		return
+	}
+	if tenant := tenantOf(r); tenant != "" && tenant != d.Tenant {
+		writeJSON(w, http.StatusForbidden, ErrorResponse{Error: "tenant_forbidden"})
+		return
+	}
+	if !h.replays.first("decision:"+req.DecisionID, time.Until(time.UnixMilli(d.ExpiresMs))) {
+		writeJSON(w, http.StatusConflict, ErrorResponse{Error: "decision_reconciled"})
+		return
+	}
+
+	ctx, cancel := backendContext(r.Context(), opts.BackendTimeout)
+	defer cancel()
+	res, err := adjuster.Adjust(ctx, d.Request, d.Request.Cost)
+	if errors.Is(err, backend.ErrAdjustUnsupported) {
+		writeJSON(w, http.StatusNotImplemented, ErrorResponse{Error: "reconcile_unavailable"})
+		return
+	}
+	if err != nil {
+		// Nothing was settled, so the decision may be sent again.
+		h.replays.forget("decision:" + req.DecisionID)
+		status, code := backendFailure(ctx, err)
+		writeJSON(w, status, ErrorResponse{Error: code})
+		return
+	}
+	h.invalidate(ctx, backend.Invalidation{Kind: backend.InvalidateKey, Key: d.Request.Key})
+	check := CheckRequest{Key: d.Key, Dimensions: d.Request.Dimensions}
+	writeJSON(w, http.StatusOK, CheckResponse{
+		Key:           d.Key,
+		Tenant:        d.Tenant,
+		Algorithm:     d.Request.Algorithm,
+		Allowed:       res.Allowed,
+		Remaining:     res.Remaining,
+		ResetAtMs:     res.ResetAtMs,
+		CurrentCount:  res.CurrentCount,
+		ComputedCount: res.ComputedCount,
+		Dimensions:    dimensionResponses(&check, res),
+	})
+}
+
+// settle turns the estimates in breq into the adjustments req's real
+// costs call for: each Cost becomes the estimate less the real cost, so a
+// refund is positive and an extra charge negative.
+func settle(breq *backend.Request, req ReconcileRequest, opts *Options) string {
+	adjustment := func(estimate int64, actual *int64) (int64, string) {
+		switch {
+		case actual == nil:
+			return 0, ""
+		case *actual < 0:
+			return 0, "invalid_cost"
+		case *actual > opts.MaxCost:
+			return 0, "cost_too_large"
+		}
+		return estimate - *actual, ""
+	}
+	var code string
+	if breq.Cost, code = adjustment(breq.Cost, req.Cost); code != "" {
+		return code
+	}
+	for name := range req.Dimensions {
+		if !dimensionNamed(breq.Dimensions, name) {
+			return "unknown_dimension"
+		}
+	}
+	for i := range breq.Dimensions {
+		d := &breq.Dimensions[i]
+		actual, ok := req.Dimensions[d.Name]
+		if !ok {
+			d.Cost = 0
+			continue
+		}
+		if d.Cost, code = adjustment(d.Cost, &actual); code != "" {
+			return code
+		}
+	}
+	return ""
+}
+
+func dimensionNamed(dims []backend.Dimension, name string) bool {
+	for _, d := range dims {
+		if d.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,38 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"rate-limiter-service/internal/backend"
+)
+
+func TestDecisionIDExpiresOnTheHandlerClock(t *testing.T) {
+	clock := backend.NewManualClock(time.UnixMilli(1_700_000_000_000))
+	h := NewHandler(backend.NewMemoryBackend(backend.MemoryOptions{Clock: clock}), Options{Clock: clock, ReconcileTTL: time.Minute})
+
+	rec := httptest.NewRecorder()
+	h.Check(rec, httptest.NewRequest(http.MethodPost, "/v1/limit/check",
+		strings.NewReader(`{"key":"k","algorithm":"fixed_window","limit":5,"window_ms":60000,"cost":2,"reconcile":true}`)))
+	var check CheckResponse
+	if err := json.NewDecoder(rec.Body).Decode(&check); err != nil || check.DecisionID == "" {
+		t.Fatalf("check = %d %+v, %v; want a decision ID", rec.Code, check, err)
+	}
+
+	reconcile := func() (int, ErrorResponse) {
+		rec := httptest.NewRecorder()
+		h.Reconcile(rec, httptest.NewRequest(http.MethodPost, "/v1/limit/reconcile",
+			strings.NewReader(`{"decision_id":"`+check.DecisionID+`","cost":1}`)))
+		var resp ErrorResponse
+		json.NewDecoder(rec.Body).Decode(&resp)
+		return rec.Code, resp
+	}
+	clock.Advance(time.Minute)
+	if code, resp := reconcile(); code != http.StatusBadRequest || resp.Error != "decision_expired" {
+		t.Fatalf("reconcile after the TTL = %d %q, want 400 decision_expired", code, resp.Error)
+	}
+}
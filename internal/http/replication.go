@@ -0,0 +1,109 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"rate-limiter-service/internal/gossip"
+	"rate-limiter-service/internal/replication"
+)
+
+// SetReplicator shares what this instance allows with other regions, or
+// with the other gossip members, and accepts what they allow on the
+// replication endpoint.
+func (h *Handler) SetReplicator(r *replication.Replicator) {
+	h.replicator = r
+}
+
+// replicate notes an allowed decision for peer regions. Degraded decisions
+// never reached the backend, so there is nothing to share.
+func (h *Handler) replicate(req *CheckRequest, allowed, degraded bool) {
+	if h.replicator == nil || !allowed || degraded {
+		return
+	}
+	h.replicator.Record(toBackendRequest(req))
+}
+
+// ReplicationDeltas charges a peer region's consumption to the local
+// backend. A batch the peer resends after a lost reply is charged once.
+func (h *Handler) ReplicationDeltas(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "method_not_allowed"})
+		return
+	}
+	if h.replicator == nil {
+		writeJSON(w, http.StatusNotImplemented, ErrorResponse{Error: "replication_unavailable"})
+		return
+	}
+	// Deltas carry keys of every tenant.
+	if tenantOf(r) != "" {
+		writeJSON(w, http.StatusForbidden, ErrorResponse{Error: "tenant_forbidden"})
+		return
+	}
+	opts := h.opts.Load()
+	body := getBuffer()
+	defer putBuffer(body)
+	if !readBody(w, r, body, opts.MaxBodyBytes) {
+		return
+	}
+	var batch replication.Batch
+	if err := json.Unmarshal(body.Bytes(), &batch); err != nil || batch.Sender == "" || batch.Seq <= 0 {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid_json"})
+		return
+	}
+	if batch.Region == h.replicator.Region() {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "same_region"})
+		return
+	}
+
+	ctx, cancel := backendContext(r.Context(), opts.BackendTimeout)
+	defer cancel()
+	if err := h.replicator.Apply(ctx, h.backend, batch); err != nil {
+		log.Printf("replication: applying deltas from %s failed: %v", batch.Sender, err)
+		status, code := backendFailure(ctx, err)
+		writeJSON(w, status, ErrorResponse{Error: code})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"applied": len(batch.Deltas)})
+}
+
+// SetGossip serves the membership endpoint from node.
+func (h *Handler) SetGossip(node *gossip.Node) {
+	h.gossip = node
+}
+
+// GossipMembers swaps membership views with another instance: a POST
+// carries the caller's view and gets this instance's back. GET only reads
+// it.
+func (h *Handler) GossipMembers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET, POST")
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "method_not_allowed"})
+		return
+	}
+	if h.gossip == nil {
+		writeJSON(w, http.StatusNotImplemented, ErrorResponse{Error: "gossip_unavailable"})
+		return
+	}
+	if tenantOf(r) != "" {
+		writeJSON(w, http.StatusForbidden, ErrorResponse{Error: "tenant_forbidden"})
+		return
+	}
+	if r.Method == http.MethodGet {
+		writeJSON(w, http.StatusOK, h.gossip.Merge(gossip.Digest{}))
+		return
+	}
+	body := getBuffer()
+	defer putBuffer(body)
+	if !readBody(w, r, body, h.opts.Load().MaxBodyBytes) {
+		return
+	}
+	var digest gossip.Digest
+	if err := json.Unmarshal(body.Bytes(), &digest); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid_json"})
+		return
+	}
+	writeJSON(w, http.StatusOK, h.gossip.Merge(digest))
+}
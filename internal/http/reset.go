@@ -0,0 +1,122 @@
+package httpapi
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"rate-limiter-service/internal/audit"
+	"rate-limiter-service/internal/backend"
+)
+
+// ResetRequest names a key as Inspect does, and the dimensions of it to
+// reset along with it.
+type ResetRequest struct {
+	Key        string   `json:"key"`
+	Tenant     string   `json:"tenant"`
+	UserID     string   `json:"user_id"`
+	DeviceID   string   `json:"device_id"`
+	Dimensions []string `json:"dimensions"`
+	Reason     string   `json:"reason"`
+}
+
+// ResetResponse names the backend keys that were reset.
+type ResetResponse struct {
+	Keys []string `json:"keys"`
+}
+
+// ResetKey forgets what the backend holds for a key under every algorithm,
+// so its next check starts from a full allowance, and audits why. Unlike
+// Adjust it needs no limit parameters, and it clears the key's grace too.
+func (h *Handler) ResetKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "method_not_allowed"})
+		return
+	}
+	resetter, ok := h.backend.(backend.KeyResetter)
+	if !ok {
+		writeJSON(w, http.StatusNotImplemented, ErrorResponse{Error: "reset_unavailable"})
+		return
+	}
+	opts := h.opts.Load()
+	body := getBuffer()
+	defer putBuffer(body)
+	if !readBody(w, r, body, opts.MaxBodyBytes) {
+		return
+	}
+	var req ResetRequest
+	if err := decodeRequest(body.Bytes(), &req, opts.StrictRequests); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	check := CheckRequest{
+		Key:      strings.TrimSpace(req.Key),
+		Tenant:   req.Tenant,
+		UserID:   strings.TrimSpace(req.UserID),
+		DeviceID: strings.TrimSpace(req.DeviceID),
+	}
+	key, code := adminKey(r, &check, opts)
+	if code != "" {
+		writeJSON(w, requestErrorStatus(code), ErrorResponse{Error: code})
+		return
+	}
+	req.Key, req.Tenant = check.Key, check.Tenant
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "reason_required"})
+		return
+	}
+	keys := []string{key}
+	for _, name := range req.Dimensions {
+		if name = strings.TrimSpace(name); name == "" {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid_dimension"})
+			return
+		}
+		keys = append(keys, backend.DimensionKey(key, name))
+	}
+
+	ctx, cancel := backendContext(r.Context(), opts.BackendTimeout)
+	defer cancel()
+	var err error
+	for _, k := range keys {
+		if err = resetter.ResetKey(ctx, k); err != nil {
+			break
+		}
+		h.invalidate(ctx, backend.Invalidation{Kind: backend.InvalidateKey, Key: k})
+	}
+	h.recordReset(r, req, err)
+	if errors.Is(err, backend.ErrResetUnsupported) {
+		writeJSON(w, http.StatusNotImplemented, ErrorResponse{Error: "reset_unavailable"})
+		return
+	}
+	if err != nil {
+		status, code := backendFailure(ctx, err)
+		writeJSON(w, status, ErrorResponse{Error: code})
+		return
+	}
+	writeJSON(w, http.StatusOK, ResetResponse{Keys: keys})
+}
+
+func (h *Handler) recordReset(r *http.Request, req ResetRequest, err error) {
+	if h.audit == nil {
+		return
+	}
+	entry := audit.Entry{
+		Actor:  actorOf(r),
+		Action: "key.reset",
+		Tenant: req.Tenant,
+		Target: req.Key,
+		After:  map[string]string{"reason": req.Reason},
+	}
+	if len(req.Dimensions) > 0 {
+		entry.After["dimensions"] = strings.Join(req.Dimensions, ",")
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	if auditErr := h.audit.Record(entry); auditErr != nil {
+		log.Printf("audit record failed: %v", auditErr)
+	}
+}
@@ -1,10 +1,47 @@
 package httpapi
 
-import "net/http"
+import (
+	"net/http"
+
+	"rate-limiter-service/internal/extauthz"
+)
 
 func Routes(handler *Handler) http.Handler {
+	check := func(fn http.HandlerFunc) http.HandlerFunc {
+		return handler.limitCaller(callerCheck, handler.requireRole(RoleCheck, fn))
+	}
+	admin := func(role string, fn http.HandlerFunc) http.HandlerFunc {
+		return handler.allowNetworks(handler.limitCaller(callerAdmin, handler.requireRole(role, fn)))
+	}
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("/healthz", handler.Health)
-	mux.HandleFunc("/v1/limit/check", handler.Check)
-	return mux
+	mux.HandleFunc("/metrics", admin(RoleViewer, handler.Metrics))
+	mux.HandleFunc("/v1/limit/check", check(handler.Check))
+	mux.HandleFunc("/v1/limit/check/batch", check(handler.CheckBatch))
+	mux.HandleFunc("/v1/limit/peek", check(handler.Peek))
+	mux.HandleFunc("/v1/limit/availability", check(handler.Availability))
+	mux.HandleFunc("/v1/limit/reconcile", check(handler.Reconcile))
+	mux.HandleFunc("/v1/limit/check/ws", check(handler.CheckStream))
+	mux.HandleFunc("/v2/limit/check", check(handler.CheckV2))
+	mux.HandleFunc(extauthz.Path, check(handler.ExtAuthz))
+	mux.HandleFunc("/v1/admin/reload", admin(RoleAdmin, handler.Reload))
+	mux.HandleFunc("/v1/admin/audit", admin(RoleViewer, handler.Audit))
+	mux.HandleFunc("/v1/admin/tenants/{id}/usage", admin(RoleViewer, handler.TenantUsage))
+	mux.HandleFunc("/v1/admin/state", admin(RoleOperator, handler.State))
+	mux.HandleFunc("/v1/admin/keys/inspect", admin(RoleViewer, handler.Inspect))
+	mux.HandleFunc("/v1/admin/keys/count", admin(RoleViewer, handler.KeyCounts))
+	mux.HandleFunc("/v1/admin/keys/top", admin(RoleViewer, handler.TopKeys))
+	mux.HandleFunc("/v1/admin/keys/adjust", admin(RoleOperator, handler.Adjust))
+	mux.HandleFunc("/v1/admin/keys/reset", admin(RoleOperator, handler.ResetKey))
+	mux.HandleFunc("/v1/admin/keys/{key}/history", admin(RoleViewer, handler.KeyHistory))
+	mux.HandleFunc("/v1/admin/multipliers", admin(RoleOperator, handler.Multipliers))
+	mux.HandleFunc("/v1/admin/policies", admin(RoleViewer, handler.Policies))
+	mux.HandleFunc("/v1/admin/events", admin(RoleViewer, handler.KeyEvents))
+	mux.HandleFunc("/v1/admin/compare", admin(RoleViewer, handler.CompareReport))
+	mux.HandleFunc("/v1/admin/graphql", admin(RoleViewer, handler.GraphQL))
+	mux.HandleFunc("/v1/replication/deltas", handler.requireRole(RoleOperator, handler.ReplicationDeltas))
+	mux.HandleFunc("/v1/gossip/members", handler.requireRole(RoleOperator, handler.GossipMembers))
+	mux.HandleFunc("/v1/cluster/rpc", handler.requireRole(RoleOperator, handler.ClusterRPC))
+	return handler.problems(handler.instrument(mux))
 }
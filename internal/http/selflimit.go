@@ -0,0 +1,67 @@
+package httpapi
+
+import (
+	"crypto/sha256"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// Caller classes with separate self limits.
+const (
+	callerCheck = "check"
+	callerAdmin = "admin"
+)
+
+// limitCaller wraps next with a token bucket per caller and class, kept in
+// the handler's own memory backend so that one noisy integration cannot
+// starve the others. It runs before authentication so that key guessing is
+// throttled too.
+func (h *Handler) limitCaller(class string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if retryAfterMs, ok := h.callerAllowed(r, class); !ok {
+			seconds := (retryAfterMs + 999) / 1000
+			w.Header().Set("Retry-After", strconv.FormatInt(max(seconds, 1), 10))
+			writeJSON(w, http.StatusTooManyRequests, ErrorResponse{Error: "caller_rate_limited"})
+			return
+		}
+		next(w, r)
+	}
+}
+
+// callerAllowed takes one request from the bucket of r's caller and class,
+// returning how long to wait when it is empty.
+func (h *Handler) callerAllowed(r *http.Request, class string) (int64, bool) {
+	opts := h.opts.Load()
+	rate, burst := opts.CheckCallerRate, opts.CheckCallerBurst
+	if class == callerAdmin {
+		rate, burst = opts.AdminCallerRate, opts.AdminCallerBurst
+	}
+	if rate <= 0 {
+		return 0, true
+	}
+	if burst <= 0 {
+		burst = rate
+	}
+	res, err := h.self.TokenBucketAllow(r.Context(), class+":"+callerID(r, opts), burst, float64(rate), 1)
+	if err == nil && !res.Allowed {
+		return res.RetryAfterMs, false
+	}
+	return 0, true
+}
+
+// callerID names the caller by its API key when it presents a valid one,
+// otherwise by source IP; unknown keys must not mint fresh buckets.
+func callerID(r *http.Request, opts *Options) string {
+	if opts.apiKeys != nil {
+		digest := sha256.Sum256([]byte(apiKey(r, opts.APIKeyHeader)))
+		if _, ok := opts.apiKeys[digest]; ok {
+			return "key:" + keyID(digest)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
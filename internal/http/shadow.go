@@ -0,0 +1,210 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"rate-limiter-service/internal/backend"
+)
+
+// shadowStore remembers the last state the backend reported for recently
+// checked keys, so a short backend outage can be bridged with approximate
+// decisions instead of failures. Cost allowed from the shadow is owed to
+// the backend and charged there by ReconcileShadow once it is back.
+type shadowStore struct {
+	mu      sync.Mutex
+	entries map[string]*shadowEntry
+}
+
+type shadowEntry struct {
+	// req holds the key and parameters it was last checked with.
+	req backend.Request
+	// confirmed is when the backend last reported on the key; the entry
+	// is only trusted for ShadowTTL after it.
+	confirmed time.Time
+	// available is the cost that could be allowed as of updated, and
+	// resetAtMs when all of it is available again.
+	available float64
+	resetAtMs int64
+	updated   time.Time
+	// owed is cost allowed from the shadow and not yet charged to the
+	// backend.
+	owed int64
+}
+
+// observe records a decision the backend made. Checks with dimensions are
+// left out: the shadow keeps a key's state only.
+func (s *shadowStore) observe(req *CheckRequest, res backend.Result, opts *Options) {
+	if opts.ShadowTTL <= 0 || len(req.Dimensions) > 0 {
+		return
+	}
+	s.put(toBackendRequest(req), res, opts)
+}
+
+// put records res as the backend's latest report on breq's key.
+func (s *shadowStore) put(breq backend.Request, res backend.Result, opts *Options) {
+	now := opts.Clock.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.entries == nil {
+		s.entries = make(map[string]*shadowEntry)
+	}
+	e, ok := s.entries[breq.Key]
+	if !ok {
+		if len(s.entries) >= opts.ShadowMaxKeys {
+			return
+		}
+		e = &shadowEntry{}
+		s.entries[breq.Key] = e
+	}
+	if !sameParams(e.req, breq) {
+		e.owed = 0
+	}
+	e.req = breq
+	e.confirmed, e.updated = now, now
+	e.available = float64(res.Remaining)
+	e.resetAtMs = res.ResetAtMs
+}
+
+// decide stands in for the backend after err, from a fresh shadow of req's
+// key checked with the same parameters.
+func (s *shadowStore) decide(req *CheckRequest, opts *Options, err error) (backend.Result, bool) {
+	if opts.ShadowTTL <= 0 || len(req.Dimensions) > 0 || errors.Is(err, backend.ErrInvalidParams) || errors.Is(err, backend.ErrGroupUnsupported) {
+		return backend.Result{}, false
+	}
+	breq := toBackendRequest(req)
+	now := opts.Clock.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[breq.Key]
+	if !ok || now.Sub(e.confirmed) > opts.ShadowTTL || !sameParams(e.req, breq) {
+		return backend.Result{}, false
+	}
+	e.advance(now)
+	nowMs := now.UnixMilli()
+	res := backend.Result{Allowed: e.available >= float64(breq.Cost)}
+	if res.Allowed {
+		e.available -= float64(breq.Cost)
+		e.owed += breq.Cost
+		if e.resetAtMs < nowMs && !isBucket(breq.Algorithm) {
+			e.resetAtMs = nowMs + breq.WindowMs
+		}
+	}
+	res.Remaining = int64(e.available)
+	res.ResetAtMs = max(e.resetAtMs, nowMs)
+	if isBucket(breq.Algorithm) {
+		rate := bucketRate(breq)
+		res.ResetAtMs = nowMs + int64(math.Ceil((float64(breq.Capacity)-e.available)/rate*1000))
+		if !res.Allowed {
+			res.RetryAfterMs = int64(math.Ceil((float64(breq.Cost) - e.available) / rate * 1000))
+		}
+	} else if !res.Allowed {
+		res.RetryAfterMs = max(e.resetAtMs-nowMs, 1)
+	}
+	return res, true
+}
+
+// advance brings the entry's available cost up to now: buckets refill or
+// drain at their rate, and windows are whole again once they reset.
+func (e *shadowEntry) advance(now time.Time) {
+	if isBucket(e.req.Algorithm) {
+		e.available += now.Sub(e.updated).Seconds() * bucketRate(e.req)
+		e.available = min(e.available, float64(e.req.Capacity))
+	} else if now.UnixMilli() >= e.resetAtMs {
+		e.available = float64(e.req.Limit)
+	}
+	e.updated = now
+}
+
+func isBucket(algorithm string) bool {
+	return algorithm == backend.TokenBucket || algorithm == backend.LeakyBucket
+}
+
+func bucketRate(req backend.Request) float64 {
+	if req.Algorithm == backend.LeakyBucket {
+		return req.LeakPerSec
+	}
+	return req.RefillPerSec
+}
+
+// sameParams compares everything but the cost. The shadow holds no
+// dimensions, so they are left out too.
+func sameParams(a, b backend.Request) bool {
+	return a.Algorithm == b.Algorithm && a.Key == b.Key &&
+		a.Limit == b.Limit && a.WindowMs == b.WindowMs &&
+		a.Capacity == b.Capacity && a.RefillPerSec == b.RefillPerSec && a.LeakPerSec == b.LeakPerSec &&
+		a.MaxDebt == b.MaxDebt && a.Timezone == b.Timezone &&
+		a.Client == b.Client && a.Weight == b.Weight &&
+		a.GroupKey == b.GroupKey && a.GroupLimit == b.GroupLimit && a.GroupWindowMs == b.GroupWindowMs
+}
+
+// take removes the owed cost of every entry and returns it, dropping
+// entries that can no longer be trusted.
+func (s *shadowStore) take(now time.Time, ttl time.Duration) []backend.Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var owed []backend.Request
+	for key, e := range s.entries {
+		if e.owed > 0 {
+			req := e.req
+			req.Cost = e.owed
+			owed = append(owed, req)
+			e.owed = 0
+		}
+		if now.Sub(e.confirmed) > ttl {
+			delete(s.entries, key)
+		}
+	}
+	return owed
+}
+
+func (s *shadowStore) putBack(req backend.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.entries == nil {
+		s.entries = make(map[string]*shadowEntry)
+	}
+	e, ok := s.entries[req.Key]
+	if !ok {
+		// Dropped as stale; keep it only to settle the debt.
+		e = &shadowEntry{req: req}
+		s.entries[req.Key] = e
+	}
+	if sameParams(e.req, req) {
+		e.owed += req.Cost
+	}
+}
+
+// ReconcileShadow charges the backend for cost allowed from the shadow
+// while it was failing, and forgets shadows older than ShadowTTL. A charge
+// the backend cannot take in full uses up what is left. Charges that fail
+// are kept for the next call.
+func (h *Handler) ReconcileShadow(ctx context.Context) error {
+	var errs []error
+	opts := h.opts.Load()
+	for _, req := range h.shadow.take(opts.Clock.Now(), opts.ShadowTTL) {
+		// A charge above the limit or capacity would be rejected outright.
+		if isBucket(req.Algorithm) {
+			req.Cost = min(req.Cost, req.Capacity)
+		} else {
+			req.Cost = min(req.Cost, req.Limit)
+		}
+		charge := req
+		res, err := backend.Evaluate(ctx, h.backend, charge)
+		if err == nil && !res.Allowed && res.Remaining > 0 {
+			charge.Cost = res.Remaining
+			_, err = backend.Evaluate(ctx, h.backend, charge)
+		}
+		if errors.Is(err, backend.ErrInvalidParams) {
+			continue
+		}
+		if err != nil {
+			h.shadow.putBack(req)
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
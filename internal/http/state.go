@@ -0,0 +1,175 @@
+package httpapi
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"rate-limiter-service/internal/audit"
+	"rate-limiter-service/internal/backend"
+)
+
+// importChunk is how many states an import hands the backend at a time.
+const importChunk = 500
+
+// ImportResponse reports an import. Skipped states had an unknown
+// algorithm or invalid parameters.
+type ImportResponse struct {
+	Imported int `json:"imported"`
+	Skipped  int `json:"skipped"`
+}
+
+// State exports the limiter state as JSON lines on GET, one key per line,
+// and imports lines in that format on POST, replacing the state of every
+// key they name. The tenant query parameter limits an export to one
+// tenant's keys.
+func (h *Handler) State(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.exportState(w, r)
+	case http.MethodPost:
+		h.importState(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "method_not_allowed"})
+	}
+}
+
+func (h *Handler) exportState(w http.ResponseWriter, r *http.Request) {
+	store, ok := h.backend.(backend.StateStore)
+	if !ok {
+		writeJSON(w, http.StatusNotImplemented, ErrorResponse{Error: "state_unavailable"})
+		return
+	}
+	tenant := r.URL.Query().Get("tenant")
+	if bound := tenantOf(r); bound != "" {
+		if tenant != "" && tenant != bound {
+			writeJSON(w, http.StatusForbidden, ErrorResponse{Error: "tenant_forbidden"})
+			return
+		}
+		tenant = bound
+	}
+	prefix := ""
+	if tenant != "" {
+		prefix = tenantKey(tenant, "")
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	out := bufio.NewWriter(w)
+	enc := json.NewEncoder(out)
+	exported := 0
+	err := store.ExportState(r.Context(), func(s backend.KeyState) error {
+		if !strings.HasPrefix(s.Key, prefix) {
+			return nil
+		}
+		exported++
+		return enc.Encode(s)
+	})
+	if err == nil {
+		err = out.Flush()
+	}
+	h.recordState(r, audit.Entry{Action: "state.export", Tenant: tenant}, exported, err)
+	if errors.Is(err, backend.ErrStateUnsupported) && exported == 0 {
+		writeJSON(w, http.StatusNotImplemented, ErrorResponse{Error: "state_unavailable"})
+		return
+	}
+	if err != nil {
+		// The status is already sent, so a failed export is cut short
+		// rather than let pass for a complete one.
+		log.Printf("state export failed: %v", err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func (h *Handler) importState(w http.ResponseWriter, r *http.Request) {
+	store, ok := h.backend.(backend.StateStore)
+	if !ok {
+		writeJSON(w, http.StatusNotImplemented, ErrorResponse{Error: "state_unavailable"})
+		return
+	}
+	// Imported lines may name any tenant's keys.
+	if tenantOf(r) != "" {
+		writeJSON(w, http.StatusForbidden, ErrorResponse{Error: "tenant_forbidden"})
+		return
+	}
+
+	var resp ImportResponse
+	var chunk []backend.KeyState
+	flush := func() error {
+		n, err := store.ImportState(r.Context(), chunk)
+		resp.Imported += n
+		resp.Skipped += len(chunk) - n
+		chunk = chunk[:0]
+		return err
+	}
+	dec := json.NewDecoder(r.Body)
+	var err error
+	for line := 1; ; line++ {
+		var s backend.KeyState
+		if decodeErr := dec.Decode(&s); decodeErr == io.EOF {
+			break
+		} else if decodeErr != nil {
+			if err = flush(); err == nil {
+				err = errInvalidState{line: line, err: decodeErr}
+			}
+			break
+		}
+		if chunk = append(chunk, s); len(chunk) == importChunk {
+			if err = flush(); err != nil {
+				break
+			}
+		}
+	}
+	if err == nil && len(chunk) > 0 {
+		err = flush()
+	}
+	h.recordState(r, audit.Entry{Action: "state.import"}, resp.Imported, err)
+	if resp.Imported > 0 {
+		h.invalidate(r.Context(), backend.Invalidation{Kind: backend.InvalidateKey})
+	}
+
+	var invalid errInvalidState
+	switch {
+	case errors.As(err, &invalid):
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid_json", Message: invalid.Error()})
+	case errors.Is(err, backend.ErrStateUnsupported) && resp.Imported == 0:
+		writeJSON(w, http.StatusNotImplemented, ErrorResponse{Error: "state_unavailable"})
+	case err != nil:
+		log.Printf("state import failed after %d keys: %v", resp.Imported, err)
+		status, code := backendFailure(r.Context(), err)
+		writeJSON(w, status, ErrorResponse{Error: code, Message: strconv.Itoa(resp.Imported) + " keys were imported first"})
+	default:
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// errInvalidState reports an import line that is not a key's state. The
+// lines before it are imported.
+type errInvalidState struct {
+	line int
+	err  error
+}
+
+func (e errInvalidState) Error() string {
+	return "line " + strconv.Itoa(e.line) + ": " + e.err.Error()
+}
+
+// recordState audits an export or import of count keys.
+func (h *Handler) recordState(r *http.Request, entry audit.Entry, count int, err error) {
+	if h.audit == nil {
+		return
+	}
+	entry.Actor = actorOf(r)
+	entry.After = map[string]string{"keys": strconv.Itoa(count)}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	if auditErr := h.audit.Record(entry); auditErr != nil {
+		log.Printf("audit record failed: %v", auditErr)
+	}
+}
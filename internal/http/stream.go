@@ -0,0 +1,165 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"rate-limiter-service/internal/websocket"
+)
+
+// streamIdleTimeout closes check streams that send nothing for this long.
+const streamIdleTimeout = 2 * time.Minute
+
+// streamSet holds the open check streams so that Drain can close them.
+type streamSet struct {
+	mu     sync.Mutex
+	conns  map[*websocket.Conn]struct{}
+	closed bool
+}
+
+// add registers conn, or reports false once the handler is draining.
+func (s *streamSet) add(conn *websocket.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[*websocket.Conn]struct{})
+	}
+	s.conns[conn] = struct{}{}
+	return true
+}
+
+func (s *streamSet) remove(conn *websocket.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, conn)
+}
+
+// close ends every stream with a going-away close, so clients reconnect
+// to another instance. The streams are closed outside s.mu, so that their
+// handlers can remove them meanwhile.
+func (s *streamSet) close() {
+	s.mu.Lock()
+	s.closed = true
+	conns := s.conns
+	s.conns = nil
+	s.mu.Unlock()
+	for conn := range conns {
+		conn.Close(websocket.CloseGoingAway, "shutting down")
+	}
+}
+
+// CheckStream upgrades to a WebSocket on which each text message is a
+// check, answered in order with its decision. Every check is charged to
+// the caller's own limit, as a request to Check would be.
+func (h *Handler) CheckStream(w http.ResponseWriter, r *http.Request) {
+	if h.drain.isDraining() {
+		w.Header().Set("Retry-After", drainRetryAfter)
+		writeJSON(w, http.StatusServiceUnavailable, ErrorResponse{Error: "shutting_down"})
+		return
+	}
+	conn, err := websocket.Upgrade(w, r, h.opts.Load().MaxBodyBytes)
+	if err != nil {
+		var handshake websocket.HandshakeError
+		if errors.As(err, &handshake) {
+			if handshake.Status == http.StatusMethodNotAllowed {
+				w.Header().Set("Allow", http.MethodGet)
+			}
+			writeJSON(w, handshake.Status, ErrorResponse{Error: "websocket_upgrade_required", Message: handshake.Message})
+		}
+		return
+	}
+	if !h.streams.add(conn) {
+		conn.Close(websocket.CloseGoingAway, "shutting down")
+		return
+	}
+	defer h.streams.remove(conn)
+	defer conn.Close(websocket.CloseNormal, "")
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(streamIdleTimeout))
+		msg, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		out, err := json.Marshal(h.streamCheck(r, msg))
+		if err != nil || conn.WriteMessage(out) != nil {
+			return
+		}
+	}
+}
+
+// streamCheck decides the check in msg, answering failures in the frame.
+func (h *Handler) streamCheck(r *http.Request, msg []byte) StreamCheckResponse {
+	if retryAfterMs, ok := h.callerAllowed(r, callerCheck); !ok {
+		return streamError(nil, http.StatusTooManyRequests, "caller_rate_limited", retryAfterMs)
+	}
+	if !h.drain.enter() {
+		return streamError(nil, http.StatusServiceUnavailable, "shutting_down", 0)
+	}
+	defer h.drain.leave()
+	if jsonDepthExceeds(msg, maxJSONDepth) {
+		return streamError(nil, http.StatusBadRequest, "json_too_deep", 0)
+	}
+	opts := h.opts.Load()
+	var in StreamCheckRequest
+	if err := decodeRequest(msg, &in, opts.StrictRequests); err != nil {
+		var fields fieldErrors
+		if !errors.As(err, &fields) {
+			return streamError(nil, http.StatusBadRequest, "invalid_json", 0)
+		}
+		// The frame is JSON, so its id can still be echoed.
+		json.Unmarshal(msg, &in)
+		resp := streamError(in.ID, http.StatusBadRequest, "invalid_fields", 0)
+		resp.Message, resp.Fields = fields.message(), fields
+		return resp
+	}
+	return StreamCheckResponse{ID: in.ID, BatchItemResponse: h.checkItem(r, &in.CheckRequest, opts)}
+}
+
+// checkItem decides req on its own, as a batch item, for callers that
+// answer each check within a message of their own.
+func (h *Handler) checkItem(r *http.Request, req *CheckRequest, opts *Options) BatchItemResponse {
+	code := consult(r, req, opts)
+	if code == "" {
+		code = h.normalize(r, req, opts)
+	}
+	if code != "" {
+		return BatchItemResponse{Status: requestErrorStatus(code), Error: code}
+	}
+	if resp, status, ok := settled(req); ok {
+		return BatchItemResponse{CheckResponse: resp, Status: status}
+	}
+
+	ctx, cancel := backendContext(r.Context(), opts.BackendTimeout)
+	defer cancel()
+	res, err := h.evaluate(ctx, req, opts)
+	if err == nil {
+		h.shadow.observe(req, res, opts)
+	}
+	item := h.batchItemResponse(ctx, req, opts, res, err)
+	switch item.Error {
+	case "":
+		h.recordDecision(req, item.Allowed)
+		h.recordHistory(req, item.Allowed, item.Remaining, opts)
+		h.hot.record(req, opts)
+		h.events.observe(req, item.Allowed, item.Remaining, item.ResetAtMs, opts)
+		h.replicate(req, item.Allowed, item.Degraded)
+	case "tenant_key_limit":
+		h.recordDecision(req, false)
+	}
+	return item
+}
+
+func streamError(id json.RawMessage, status int, code string, retryAfterMs int64) StreamCheckResponse {
+	return StreamCheckResponse{ID: id, BatchItemResponse: BatchItemResponse{
+		CheckResponse: CheckResponse{RetryAfterMs: retryAfterMs},
+		Status:        status,
+		Error:         code,
+	}}
+}
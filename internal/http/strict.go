@@ -0,0 +1,244 @@
+package httpapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// FieldError is what is wrong with one field of a request decoded
+// strictly. Field is its path, such as items[2].window_ms, and Error
+// unknown_field, or invalid_type with the JSON type Expected.
+type FieldError struct {
+	Field    string `json:"field"`
+	Error    string `json:"error"`
+	Expected string `json:"expected,omitempty"`
+}
+
+// fieldErrors fails a strictly decoded request.
+type fieldErrors []FieldError
+
+func (e fieldErrors) Error() string {
+	return "invalid fields: " + e.message()
+}
+
+// message names the fields at fault, for ErrorResponse's Message.
+func (e fieldErrors) message() string {
+	parts := make([]string, len(e))
+	for i, f := range e {
+		switch f.Error {
+		case "unknown_field":
+			parts[i] = "unknown field " + strconv.Quote(f.Field)
+		default:
+			parts[i] = strconv.Quote(f.Field) + " must be " + f.Expected
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+// decodeRequest decodes data into v. Strictly, a field v has no place for,
+// or a value of the wrong type, fails it with every such field's
+// FieldError; otherwise unknown fields are ignored, as they always were.
+func decodeRequest(data []byte, v interface{}, strict bool) error {
+	if strict {
+		if err := checkFields(data, reflect.TypeOf(v)); err != nil {
+			return err
+		}
+	}
+	return json.Unmarshal(data, v)
+}
+
+// writeDecodeError answers a request that failed to decode: 400
+// invalid_fields, listing them, when it was decoded strictly, invalid_form
+// for a form that is not a check, and invalid_json otherwise.
+func writeDecodeError(w http.ResponseWriter, err error) {
+	var fields fieldErrors
+	switch {
+	case errors.As(err, &fields):
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid_fields", Message: fields.message(), Fields: fields})
+	case errors.Is(err, errInvalidForm):
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid_form"})
+	default:
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid_json"})
+	}
+}
+
+// checkFields walks data against t, the type it is to be decoded into,
+// and returns the fields that do not fit it, if any. Malformed JSON is
+// left to encoding/json to refuse.
+func checkFields(data []byte, t reflect.Type) error {
+	if !json.Valid(data) {
+		return nil
+	}
+	var errs fieldErrors
+	checkValue(data, t, "", &errs)
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+func checkValue(raw json.RawMessage, t reflect.Type, path string, errs *fieldErrors) {
+	raw = bytes.TrimSpace(raw)
+	// null leaves any field as it was.
+	if string(raw) == "null" {
+		return
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if reflect.PointerTo(t).Implements(unmarshalerType) {
+		checkScalar(raw, t, path, errs)
+		return
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		fields := structFields(t)
+		ok := eachMember(raw, func(name string, value json.RawMessage) {
+			field, known := fields[name]
+			if !known {
+				*errs = append(*errs, FieldError{Field: join(path, name), Error: "unknown_field"})
+				return
+			}
+			checkValue(value, field, join(path, name), errs)
+		})
+		if !ok {
+			*errs = append(*errs, FieldError{Field: path, Error: "invalid_type", Expected: "object"})
+		}
+	case reflect.Map:
+		ok := eachMember(raw, func(name string, value json.RawMessage) {
+			checkValue(value, t.Elem(), join(path, name), errs)
+		})
+		if !ok {
+			*errs = append(*errs, FieldError{Field: path, Error: "invalid_type", Expected: "object"})
+		}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 && raw[0] == '"' {
+			// []byte takes base64.
+			checkScalar(raw, t, path, errs)
+			return
+		}
+		var items []json.RawMessage
+		if json.Unmarshal(raw, &items) != nil {
+			*errs = append(*errs, FieldError{Field: path, Error: "invalid_type", Expected: "array"})
+			return
+		}
+		for i, item := range items {
+			checkValue(item, t.Elem(), path+"["+strconv.Itoa(i)+"]", errs)
+		}
+	case reflect.Interface:
+		// Anything goes.
+	default:
+		checkScalar(raw, t, path, errs)
+	}
+}
+
+var (
+	checkRequestType = reflect.TypeOf(CheckRequest{})
+	unmarshalerType  = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+)
+
+// checkScalar decodes raw into a t to see whether it fits.
+func checkScalar(raw json.RawMessage, t reflect.Type, path string, errs *fieldErrors) {
+	if json.Unmarshal(raw, reflect.New(t).Interface()) != nil {
+		*errs = append(*errs, FieldError{Field: path, Error: "invalid_type", Expected: jsonType(t)})
+	}
+}
+
+// jsonType names the JSON type a Go type is decoded from.
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "string"
+		}
+		return "array"
+	}
+	return "object"
+}
+
+// eachMember calls fn with each member of the object raw, in order, and
+// reports whether raw is an object.
+func eachMember(raw json.RawMessage, fn func(name string, value json.RawMessage)) bool {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return false
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return false
+		}
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return false
+		}
+		fn(tok.(string), value)
+	}
+	return true
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// fieldTypes caches structFields by type.
+var fieldTypes sync.Map
+
+// structFields maps the JSON names of t's fields, those of embedded
+// structs included, to their types. Names must match exactly: strictly,
+// the case-insensitive matches encoding/json would make are typos too.
+func structFields(t reflect.Type) map[string]reflect.Type {
+	if fields, ok := fieldTypes.Load(t); ok {
+		return fields.(map[string]reflect.Type)
+	}
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			embedded := f.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				for name, typ := range structFields(embedded) {
+					if _, ok := fields[name]; !ok {
+						fields[name] = typ
+					}
+				}
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f.Type
+	}
+	fieldTypes.Store(t, fields)
+	return fields
+}
@@ -0,0 +1,178 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"rate-limiter-service/internal/backend"
+)
+
+// tenantPrefix namespaces tenant keys in the backend. Keys without a tenant
+// may not start with it, so no caller can reach into a tenant's counters.
+const tenantPrefix = "tenant/"
+
+// TenantPolicies caps one tenant's requests; zero fields keep the global
+// caps.
+type TenantPolicies struct {
+	MaxCost     int64
+	MaxCapacity int64
+	MaxLimit    int64
+	MaxWindowMs int64
+	MaxLogLimit int64
+	// Algorithms, when not empty, replaces the global algorithms.
+	Algorithms []string
+	// Costs, when not empty, replaces the global cost map.
+	Costs CostMap
+	// Grace, when enabled, replaces the global grace policy; without a
+	// Period it keeps the global one's.
+	Grace GracePolicy
+	// MaxKeys caps the distinct keys the tenant may have in the backend;
+	// keys unused for KeyIdle stop counting. At the cap a new key is
+	// refused, or with EvictKeys the least recently used one is dropped.
+	MaxKeys   int64
+	KeyIdle   time.Duration
+	EvictKeys bool
+	// DenyBody's fields replace the global deny body's.
+	DenyBody DenyBody
+}
+
+// errKeyLimit refuses a new key of a tenant at its key cap.
+var errKeyLimit = errors.New("tenant key limit reached")
+
+// trackKey applies the key cap of req's tenant, if it has one.
+func (h *Handler) trackKey(ctx context.Context, req *CheckRequest, opts *Options) error {
+	if req.Tenant == "" {
+		return nil
+	}
+	policy, ok := opts.Tenants[req.Tenant]
+	if !ok || policy.MaxKeys <= 0 {
+		return nil
+	}
+	tracker, ok := h.backend.(backend.KeyTracker)
+	if !ok {
+		return nil
+	}
+	idle := policy.KeyIdle
+	if idle <= 0 {
+		idle = 24 * time.Hour
+	}
+	admitted, err := tracker.TrackKey(ctx, TenantKeyPrefix(req.Tenant), tenantKey(req.Tenant, req.Key), policy.MaxKeys, idle, policy.EvictKeys)
+	if err != nil {
+		return err
+	}
+	if !admitted {
+		return errKeyLimit
+	}
+	return nil
+}
+
+func tenantOptions(opts Options) map[string]*Options {
+	if len(opts.Tenants) == 0 {
+		return nil
+	}
+	out := make(map[string]*Options, len(opts.Tenants))
+	for id, p := range opts.Tenants {
+		scoped := opts
+		if p.MaxCost > 0 {
+			scoped.MaxCost = p.MaxCost
+		}
+		if p.MaxCapacity > 0 {
+			scoped.MaxCapacity = p.MaxCapacity
+		}
+		if p.MaxLimit > 0 {
+			scoped.MaxLimit = p.MaxLimit
+		}
+		if p.MaxWindowMs > 0 {
+			scoped.MaxWindowMs = p.MaxWindowMs
+		}
+		if p.MaxLogLimit > 0 {
+			scoped.MaxLogLimit = p.MaxLogLimit
+		}
+		if len(p.Algorithms) > 0 {
+			scoped.Algorithms = p.Algorithms
+		}
+		if len(p.Costs) > 0 {
+			scoped.Costs = p.Costs
+		}
+		if p.Grace.enabled() {
+			period := scoped.Grace.Period
+			scoped.Grace = p.Grace
+			if scoped.Grace.Period <= 0 {
+				scoped.Grace.Period = period
+			}
+		}
+		scoped.DenyBody = scoped.DenyBody.over(p.DenyBody)
+		out[id] = &scoped
+	}
+	return out
+}
+
+// scoped returns the options of tenant's checks.
+func (opts *Options) scoped(tenant string) *Options {
+	if s, ok := opts.tenantOpts[tenant]; ok {
+		return s
+	}
+	return opts
+}
+
+// TenantKeyPrefix is what every backend key of tenant starts with.
+func TenantKeyPrefix(tenant string) string {
+	return tenantKey(tenant, "")
+}
+
+func tenantKey(tenant, key string) string {
+	if tenant == "" {
+		return key
+	}
+	return tenantPrefix + tenant + "/" + key
+}
+
+// scopeTenant settles req's tenant against the tenant its credential is
+// confined to, and returns the options that apply to that tenant along
+// with an error code.
+func scopeTenant(r *http.Request, req *CheckRequest, opts *Options) (*Options, string) {
+	req.Tenant = strings.TrimSpace(req.Tenant)
+	if bound := tenantOf(r); bound != "" {
+		if req.Tenant == "" {
+			req.Tenant = bound
+		} else if req.Tenant != bound {
+			return opts, "tenant_forbidden"
+		}
+	}
+	if req.Tenant == "" {
+		switch {
+		case opts.RequireTenant:
+			return opts, "tenant_required"
+		case strings.HasPrefix(req.Key, tenantPrefix):
+			return opts, "reserved_key_prefix"
+		}
+		return opts, ""
+	}
+	if !validTenant(req.Tenant) {
+		return opts, "invalid_tenant"
+	}
+	if scoped, ok := opts.tenantOpts[req.Tenant]; ok {
+		return scoped, ""
+	}
+	if opts.KnownTenantsOnly {
+		return opts, "unknown_tenant"
+	}
+	return opts, ""
+}
+
+// validTenant matches the tenant ids the configuration accepts.
+func validTenant(id string) bool {
+	if len(id) > 64 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '_' || c == '.' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
@@ -1,7 +1,16 @@
 package httpapi
 
+import (
+	"encoding/json"
+
+	"rate-limiter-service/internal/audit"
+	"rate-limiter-service/internal/backend"
+	"rate-limiter-service/internal/hook"
+)
+
 type CheckRequest struct {
 	Key          string  `json:"key"`
+	Tenant       string  `json:"tenant,omitempty"`
 	UserID       string  `json:"user_id,omitempty"`
 	DeviceID     string  `json:"device_id,omitempty"`
 	JWT          string  `json:"jwt,omitempty"`
@@ -11,20 +20,194 @@ type CheckRequest struct {
 	Capacity     int64   `json:"capacity,omitempty"`
 	RefillPerSec float64 `json:"refill_per_sec,omitempty"`
 	LeakPerSec   float64 `json:"leak_per_sec,omitempty"`
-	Cost         int64   `json:"cost,omitempty"`
+	// MaxDebt lets a token bucket go this far below empty, paid back from
+	// later refill.
+	MaxDebt  int64  `json:"max_debt,omitempty"`
+	Cost     int64  `json:"cost,omitempty"`
+	FailMode string `json:"fail_mode,omitempty"`
+	// Timezone aligns a fixed window with local midnight in this IANA zone
+	// instead of with the Unix epoch.
+	Timezone string `json:"timezone,omitempty"`
+	// Client names the caller sharing a weighted_fair_share pool, the key,
+	// with the others by Weight (default 1).
+	Client string `json:"client,omitempty"`
+	Weight int64  `json:"weight,omitempty"`
+	// Group also charges the check to the budget of this configured group,
+	// shared by all its keys.
+	Group string `json:"group,omitempty"`
+	// Request, instead of Cost, lets the configured cost map price the
+	// check.
+	Request *RequestDescriptor `json:"request,omitempty"`
+	// RecentHits asks a sliding_window_log check for the timestamps of up
+	// to this many of the key's latest hits.
+	RecentHits int `json:"recent_hits,omitempty"`
+	// Domain and Descriptors take the key and limit from the configured
+	// descriptors, as a lyft/ratelimit client would, instead of from the
+	// check.
+	Domain      string            `json:"domain,omitempty"`
+	Descriptors []DescriptorEntry `json:"descriptors,omitempty"`
+	// Dimensions are further limits the check consumes from at once, such
+	// as LLM tokens or egress bytes, each with its own parameters and cost.
+	Dimensions []backend.Dimension `json:"dimensions,omitempty"`
+	// Reconcile marks Cost and the dimensions' costs as estimates: an
+	// allowed check gets a DecisionID to settle them with once the real
+	// costs are known.
+	Reconcile bool `json:"reconcile,omitempty"`
+
+	// previousKey is the key under the rotated-out hash secret, charged
+	// alongside Key during the grace window.
+	previousKey string
+	// multiplier is the live multiplier applied to the limits, if any.
+	multiplier float64
+	// chaos lists the faults injected into the check.
+	chaos string
+	// groupLimit and groupWindowMs are Group's budget.
+	groupLimit, groupWindowMs int64
+	// unlimited is set when the check's descriptor has no limit, and
+	// shadowMode when its limit is only reported, not enforced.
+	unlimited, shadowMode bool
+	// hook is the decision hook's answer about the check.
+	hook *hook.Result
+	// policy names the server-side rule the limits were resolved from, if
+	// any.
+	policy string
 }
 
 type CheckResponse struct {
-	Key           string `json:"key"`
-	Algorithm     string `json:"algorithm"`
-	Allowed       bool   `json:"allowed"`
-	Remaining     int64  `json:"remaining"`
-	ResetAtMs     int64  `json:"reset_at_ms"`
-	RetryAfterMs  int64  `json:"retry_after_ms"`
-	CurrentCount  int64  `json:"current_count,omitempty"`
-	ComputedCount int64  `json:"computed_count,omitempty"`
+	Key       string `json:"key"`
+	Tenant    string `json:"tenant,omitempty"`
+	Algorithm string `json:"algorithm"`
+	// Policy names the server-side rule the check's limits came from, a
+	// descriptor or the decision hook's; Limit, WindowMs, Capacity,
+	// RefillPerSec and LeakPerSec are the limits applied, after tenant
+	// caps and multipliers.
+	Policy       string  `json:"policy,omitempty"`
+	Limit        int64   `json:"limit,omitempty"`
+	WindowMs     int64   `json:"window_ms,omitempty"`
+	Capacity     int64   `json:"capacity,omitempty"`
+	RefillPerSec float64 `json:"refill_per_sec,omitempty"`
+	LeakPerSec   float64 `json:"leak_per_sec,omitempty"`
+	Allowed      bool    `json:"allowed"`
+	Remaining    int64   `json:"remaining"`
+	ResetAtMs    int64   `json:"reset_at_ms"`
+	RetryAfterMs int64   `json:"retry_after_ms"`
+	// SuggestedIntervalMs paces an allowed client: waiting this long
+	// between checks like this one keeps it from being denied.
+	SuggestedIntervalMs int64               `json:"suggested_interval_ms,omitempty"`
+	CurrentCount        int64               `json:"current_count,omitempty"`
+	ComputedCount       int64               `json:"computed_count,omitempty"`
+	ParamsChanged       bool                `json:"params_changed,omitempty"`
+	ErrorBound          int64               `json:"error_bound,omitempty"`
+	Group               *GroupResponse      `json:"group,omitempty"`
+	Dimensions          []DimensionResponse `json:"dimensions,omitempty"`
+	Grace               bool                `json:"grace,omitempty"`
+	RecentHitsMs        []int64             `json:"recent_hits_ms,omitempty"`
+	Multiplier          float64             `json:"multiplier,omitempty"`
+	Unlimited           bool                `json:"unlimited,omitempty"`
+	ShadowMode          bool                `json:"shadow_mode,omitempty"`
+	HookDecision        string              `json:"hook_decision,omitempty"`
+	Degraded            bool                `json:"degraded,omitempty"`
+	// MemoryPressure is set while the backend evicts keys to stay within
+	// its memory budget, so limits may be enforced loosely.
+	MemoryPressure bool `json:"memory_pressure,omitempty"`
+	// Chaos lists the faults the chaos policy injected into the check.
+	Chaos string `json:"chaos,omitempty"`
+	// Message, DocumentationURL and UpgradeURL are a denial's DenyBody.
+	Message          string `json:"message,omitempty"`
+	DocumentationURL string `json:"documentation_url,omitempty"`
+	UpgradeURL       string `json:"upgrade_url,omitempty"`
+	// DecisionID settles a reconcilable check; see ReconcileRequest.
+	DecisionID string `json:"decision_id,omitempty"`
+}
+
+// GroupResponse is the decision of the group budget a check was charged
+// to.
+type GroupResponse struct {
+	ID        string `json:"id"`
+	Allowed   bool   `json:"allowed"`
+	Remaining int64  `json:"remaining"`
+	ResetAtMs int64  `json:"reset_at_ms"`
+}
+
+func groupResponse(req *CheckRequest, res backend.Result) *GroupResponse {
+	if res.Group == nil {
+		return nil
+	}
+	return &GroupResponse{ID: req.Group, Allowed: res.Group.Allowed, Remaining: res.Group.Remaining, ResetAtMs: res.Group.ResetAtMs}
+}
+
+// DimensionResponse is the decision of one of a check's dimensions.
+type DimensionResponse struct {
+	Name         string `json:"name"`
+	Allowed      bool   `json:"allowed"`
+	Remaining    int64  `json:"remaining"`
+	ResetAtMs    int64  `json:"reset_at_ms"`
+	RetryAfterMs int64  `json:"retry_after_ms"`
+}
+
+func dimensionResponses(req *CheckRequest, res backend.Result) []DimensionResponse {
+	if len(res.Dimensions) != len(req.Dimensions) || len(res.Dimensions) == 0 {
+		return nil
+	}
+	out := make([]DimensionResponse, len(res.Dimensions))
+	for i, d := range res.Dimensions {
+		out[i] = DimensionResponse{Name: req.Dimensions[i].Name, Allowed: d.Allowed, Remaining: d.Remaining, ResetAtMs: d.ResetAtMs, RetryAfterMs: d.RetryAfterMs}
+	}
+	return out
+}
+
+type TenantUsageResponse struct {
+	Tenant      string                `json:"tenant"`
+	Granularity string                `json:"granularity"`
+	Buckets     []backend.UsageBucket `json:"buckets"`
+	Total       backend.Usage         `json:"total"`
+}
+
+// KeyHistoryResponse reports one key's usage; Total.Peak is the highest
+// peak of any bucket.
+type KeyHistoryResponse struct {
+	Key         string                   `json:"key"`
+	Tenant      string                   `json:"tenant,omitempty"`
+	Granularity string                   `json:"granularity"`
+	Buckets     []backend.KeyUsageBucket `json:"buckets"`
+	Total       backend.KeyUsage         `json:"total"`
+}
+
+type AuditResponse struct {
+	Entries []audit.Entry `json:"entries"`
 }
 
 type ErrorResponse struct {
-	Error string `json:"error"`
+	Error   string `json:"error"`
+	Message string `json:"message,omitempty"`
+	// Fields are those at fault in a request decoded strictly.
+	Fields []FieldError `json:"fields,omitempty"`
+}
+
+type BatchCheckRequest struct {
+	Items []CheckRequest `json:"items"`
+}
+
+type BatchCheckResponse struct {
+	Results []BatchItemResponse `json:"results"`
+}
+
+type BatchItemResponse struct {
+	CheckResponse
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// StreamCheckRequest is a check sent on a check stream. ID, any JSON
+// value, is echoed in its answer.
+type StreamCheckRequest struct {
+	ID json.RawMessage `json:"id,omitempty"`
+	CheckRequest
+}
+
+type StreamCheckResponse struct {
+	ID json.RawMessage `json:"id,omitempty"`
+	BatchItemResponse
+	// Fields are what is wrong with a frame refused as invalid_fields.
+	Fields []FieldError `json:"fields,omitempty"`
 }
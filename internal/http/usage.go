@@ -0,0 +1,198 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"rate-limiter-service/internal/backend"
+)
+
+// maxUsageBuckets bounds one usage report: six weeks of hours, or close to
+// three years of days.
+const maxUsageBuckets = 1000
+
+// usageRecorder sums tenant decisions per hour in memory until they are
+// flushed to the backend, so checks do not pay for an extra round trip.
+type usageRecorder struct {
+	mu      sync.Mutex
+	pending map[usageSlot]backend.Usage
+}
+
+type usageSlot struct {
+	tenant string
+	hourMs int64
+}
+
+func (u *usageRecorder) record(tenant string, allowed bool, cost int64) {
+	if tenant == "" {
+		return
+	}
+	now := time.Now().UnixMilli()
+	slot := usageSlot{tenant: tenant, hourMs: now - now%backend.HourMs}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.pending == nil {
+		u.pending = make(map[usageSlot]backend.Usage)
+	}
+	total := u.pending[slot]
+	if allowed {
+		total.Allowed++
+		total.Cost += cost
+	} else {
+		total.Denied++
+	}
+	u.pending[slot] = total
+}
+
+// take empties the recorder and returns what it held.
+func (u *usageRecorder) take() map[usageSlot]backend.Usage {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	pending := u.pending
+	u.pending = nil
+	return pending
+}
+
+func (u *usageRecorder) putBack(slot usageSlot, usage backend.Usage) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.pending == nil {
+		u.pending = make(map[usageSlot]backend.Usage)
+	}
+	total := u.pending[slot]
+	total.Add(usage)
+	u.pending[slot] = total
+}
+
+// FlushUsage adds the tenant usage counted since the last flush to the
+// backend, and the key history when it is kept. Counts that cannot be
+// written are kept for the next flush.
+func (h *Handler) FlushUsage(ctx context.Context) error {
+	store, ok := h.backend.(backend.UsageStore)
+	if !ok {
+		return nil
+	}
+	retention := h.opts.Load().UsageRetention
+	var errs []error
+	for slot, usage := range h.usage.take() {
+		if err := store.AddUsage(ctx, slot.tenant, slot.hourMs, usage, retention); err != nil {
+			h.usage.putBack(slot, usage)
+			errs = append(errs, err)
+		}
+	}
+	errs = append(errs, h.flushHistory(ctx))
+	return errors.Join(errs...)
+}
+
+// TenantUsage reports a tenant's allowed and denied checks and the quota
+// they consumed, per hour or per day (UTC). Query parameters: granularity
+// (hour, the default, or day) and from_ms and to_ms, which default to the
+// last 24 hours or 30 days.
+func (h *Handler) TenantUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "method_not_allowed"})
+		return
+	}
+	store, ok := h.backend.(backend.UsageStore)
+	if !ok {
+		writeJSON(w, http.StatusNotImplemented, ErrorResponse{Error: "usage_unavailable"})
+		return
+	}
+	opts := h.opts.Load()
+	tenant := r.PathValue("id")
+	if tenant == "" || !validTenant(tenant) {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid_tenant"})
+		return
+	}
+	if bound := tenantOf(r); bound != "" && bound != tenant {
+		writeJSON(w, http.StatusForbidden, ErrorResponse{Error: "tenant_forbidden"})
+		return
+	}
+	if _, known := opts.tenantOpts[tenant]; opts.KnownTenantsOnly && !known {
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "unknown_tenant"})
+		return
+	}
+
+	granularity, bucketMs, fromMs, toMs, ok := usageRange(w, r)
+	if !ok {
+		return
+	}
+
+	parent, ok := readContext(r)
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid_consistency"})
+		return
+	}
+
+	ctx, cancel := backendContext(parent, opts.BackendTimeout)
+	defer cancel()
+	// Include this instance's unflushed counts; other instances' show up
+	// after their next flush.
+	if err := h.FlushUsage(ctx); err != nil {
+		log.Printf("usage flush failed: %v", err)
+	}
+	buckets, err := store.Usage(ctx, tenant, bucketMs, fromMs, toMs)
+	if err != nil {
+		status, code := backendFailure(ctx, err)
+		writeJSON(w, status, ErrorResponse{Error: code})
+		return
+	}
+	resp := TenantUsageResponse{Tenant: tenant, Granularity: granularity, Buckets: buckets}
+	if resp.Buckets == nil {
+		resp.Buckets = []backend.UsageBucket{}
+	}
+	for _, b := range buckets {
+		resp.Total.Add(b.Usage)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// usageRange reads the granularity, from_ms and to_ms query parameters of
+// a usage report, answering the request itself when they are invalid.
+func usageRange(w http.ResponseWriter, r *http.Request) (granularity string, bucketMs, fromMs, toMs int64, ok bool) {
+	query := r.URL.Query()
+	granularity = query.Get("granularity")
+	bucketMs, span := backend.HourMs, 24*backend.HourMs
+	switch granularity {
+	case "", "hour":
+		granularity = "hour"
+	case "day":
+		bucketMs, span = backend.DayMs, 30*backend.DayMs
+	default:
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid_granularity"})
+		return "", 0, 0, 0, false
+	}
+	toMs = time.Now().UnixMilli()
+	if v := query.Get("to_ms"); v != "" {
+		var err error
+		if toMs, err = strconv.ParseInt(v, 10, 64); err != nil {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid_to_ms"})
+			return "", 0, 0, 0, false
+		}
+	}
+	fromMs = toMs - span
+	if v := query.Get("from_ms"); v != "" {
+		var err error
+		if fromMs, err = strconv.ParseInt(v, 10, 64); err != nil {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid_from_ms"})
+			return "", 0, 0, 0, false
+		}
+	}
+	// Whole buckets only: the one containing from_ms is included.
+	fromMs -= fromMs % bucketMs
+	switch {
+	case fromMs < 0 || fromMs >= toMs:
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid_range"})
+		return "", 0, 0, 0, false
+	case (toMs-fromMs)/bucketMs > maxUsageBuckets:
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "range_too_large"})
+		return "", 0, 0, 0, false
+	}
+	return granularity, bucketMs, fromMs, toMs, true
+}
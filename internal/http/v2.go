@@ -0,0 +1,202 @@
+package httpapi
+
+import (
+	"net/http"
+	"strings"
+
+	"rate-limiter-service/internal/backend"
+)
+
+// LimitPolicy is a named set of limits v2 checks refer to: each check is
+// charged to every one of Limits, all with Algorithm, the first as the
+// check's key and the rest as its dimensions.
+type LimitPolicy struct {
+	Algorithm  string
+	ShadowMode bool
+	Limits     []PolicyLimit
+}
+
+// PolicyLimit is one of a LimitPolicy's limits.
+type PolicyLimit struct {
+	Name         string
+	Limit        int64
+	WindowMs     int64
+	Capacity     int64
+	RefillPerSec float64
+	LeakPerSec   float64
+}
+
+// CheckRequestV2 checks Key against a configured policy instead of
+// parameters of its own. Cost is charged to each of the policy's limits
+// unless Costs names a cost of the limit's own.
+type CheckRequestV2 struct {
+	Policy   string           `json:"policy"`
+	Key      string           `json:"key"`
+	Tenant   string           `json:"tenant,omitempty"`
+	Cost     int64            `json:"cost,omitempty"`
+	Costs    map[string]int64 `json:"costs,omitempty"`
+	FailMode string           `json:"fail_mode,omitempty"`
+}
+
+// Decisions of v2 checks.
+const (
+	DecisionAllow = "allow"
+	DecisionDeny  = "deny"
+)
+
+// CheckResponseV2 is a v2 check's decision and each of its policy's limits'.
+// DecisionID, on allowed checks, settles the check's costs, or refunds
+// them, through /v1/limit/reconcile. Degraded decisions were substituted
+// after a backend failure and carry no limits; Shadow ones were allowed by
+// a shadow mode policy whatever its limits decided.
+type CheckResponseV2 struct {
+	Decision     string              `json:"decision"`
+	DecisionID   string              `json:"decision_id,omitempty"`
+	Policy       string              `json:"policy"`
+	Key          string              `json:"key"`
+	Tenant       string              `json:"tenant,omitempty"`
+	RetryAfterMs int64               `json:"retry_after_ms"`
+	Limits       []DimensionResponse `json:"limits"`
+	Degraded     bool                `json:"degraded"`
+	Shadow       bool                `json:"shadow"`
+	// Message, DocumentationURL and UpgradeURL are a denial's DenyBody.
+	Message          string `json:"message,omitempty"`
+	DocumentationURL string `json:"documentation_url,omitempty"`
+	UpgradeURL       string `json:"upgrade_url,omitempty"`
+}
+
+// CheckV2 checks a key against a configured limit policy, answering as
+// the v1 check does but for its body.
+func (h *Handler) CheckV2(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "method_not_allowed"})
+		return
+	}
+	if !h.admit(w) {
+		return
+	}
+	defer h.drain.leave()
+	opts := h.opts.Load()
+	body := getBuffer()
+	defer putBuffer(body)
+	if !readBody(w, r, body, opts.MaxBodyBytes) {
+		return
+	}
+	var in CheckRequestV2
+	if err := decodeRequest(body.Bytes(), &in, opts.StrictRequests); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	in.Policy, in.Key = strings.TrimSpace(in.Policy), strings.TrimSpace(in.Key)
+	if in.Policy == "" || in.Key == "" {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "policy_and_key_required"})
+		return
+	}
+	policy, ok := opts.LimitPolicies[in.Policy]
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "unknown_policy"})
+		return
+	}
+	req := getCheckRequest()
+	defer putCheckRequest(req)
+	if code := policyRequest(req, &in, policy); code != "" {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: code})
+		return
+	}
+	h.decide(w, r, req, opts, func(status int, resp *CheckResponse, res backend.Result) {
+		writeJSON(w, status, checkResponseV2(&in, policy, resp, res))
+	})
+}
+
+// policyRequest makes req the check in asks for under policy. Its key is
+// kept apart from v1 checks' and other policies' as policy:<name>/<key>.
+func policyRequest(req *CheckRequest, in *CheckRequestV2, policy LimitPolicy) string {
+	for name := range in.Costs {
+		if !policy.hasLimit(name) {
+			return "unknown_limit"
+		}
+	}
+	cost := func(name string) int64 {
+		if c, ok := in.Costs[name]; ok {
+			return c
+		}
+		return in.Cost
+	}
+	first := policy.Limits[0]
+	*req = CheckRequest{
+		Key:          "policy:" + in.Policy + "/" + in.Key,
+		Tenant:       in.Tenant,
+		Algorithm:    policy.Algorithm,
+		Limit:        first.Limit,
+		WindowMs:     first.WindowMs,
+		Capacity:     first.Capacity,
+		RefillPerSec: first.RefillPerSec,
+		LeakPerSec:   first.LeakPerSec,
+		Cost:         cost(first.Name),
+		FailMode:     in.FailMode,
+		Reconcile:    checkReconcile(&CheckRequest{Algorithm: policy.Algorithm}) == "",
+		policy:       in.Policy,
+		shadowMode:   policy.ShadowMode,
+	}
+	for _, limit := range policy.Limits[1:] {
+		req.Dimensions = append(req.Dimensions, backend.Dimension{
+			Name:         limit.Name,
+			Limit:        limit.Limit,
+			WindowMs:     limit.WindowMs,
+			Capacity:     limit.Capacity,
+			RefillPerSec: limit.RefillPerSec,
+			LeakPerSec:   limit.LeakPerSec,
+			Cost:         cost(limit.Name),
+		})
+	}
+	return ""
+}
+
+func (p LimitPolicy) hasLimit(name string) bool {
+	for _, limit := range p.Limits {
+		if limit.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// checkResponseV2 turns the v1 answer to a policy's check into the v2 one.
+func checkResponseV2(in *CheckRequestV2, policy LimitPolicy, resp *CheckResponse, res backend.Result) CheckResponseV2 {
+	out := CheckResponseV2{
+		Decision:         DecisionDeny,
+		DecisionID:       resp.DecisionID,
+		Policy:           in.Policy,
+		Key:              in.Key,
+		Tenant:           resp.Tenant,
+		RetryAfterMs:     resp.RetryAfterMs,
+		Limits:           []DimensionResponse{},
+		Degraded:         resp.Degraded,
+		Shadow:           resp.ShadowMode,
+		Message:          resp.Message,
+		DocumentationURL: resp.DocumentationURL,
+		UpgradeURL:       resp.UpgradeURL,
+	}
+	if resp.Allowed {
+		out.Decision = DecisionAllow
+	}
+	// Decisions settled without the backend, by the hook or after a
+	// failure, have no limits to report.
+	if resp.Degraded || resp.HookDecision != "" || len(res.Dimensions) != len(policy.Limits)-1 {
+		return out
+	}
+	own := res
+	if res.Key != nil {
+		own = *res.Key
+	}
+	out.Limits = append(out.Limits, DimensionResponse{
+		Name:         policy.Limits[0].Name,
+		Allowed:      own.Allowed,
+		Remaining:    own.Remaining,
+		ResetAtMs:    own.ResetAtMs,
+		RetryAfterMs: own.RetryAfterMs,
+	})
+	out.Limits = append(out.Limits, resp.Dimensions...)
+	return out
+}
@@ -0,0 +1,204 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"rate-limiter-service/internal/backend"
+)
+
+// hotKeys counts the checks on up to four times Options.HotKeys keys.
+// When it is full every count is halved and keys left at zero are
+// forgotten, so keys that went quiet make room for new ones.
+type hotKeys struct {
+	mu   sync.Mutex
+	keys map[string]*hotKey
+}
+
+type hotKey struct {
+	// req holds the parameters the key was last checked with.
+	req    backend.Request
+	checks int64
+}
+
+func (t *hotKeys) record(req *CheckRequest, opts *Options) {
+	if opts.HotKeys <= 0 {
+		return
+	}
+	breq := toBackendRequest(req)
+	breq.Cost = 1
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.add(breq, opts.HotKeys)
+}
+
+// add counts a check on breq's key. t.mu must be held.
+func (t *hotKeys) add(breq backend.Request, size int) {
+	if t.keys == nil {
+		t.keys = make(map[string]*hotKey)
+	}
+	k, ok := t.keys[breq.Key]
+	if !ok {
+		if len(t.keys) >= 4*size {
+			t.decay()
+		}
+		if len(t.keys) >= 4*size {
+			return
+		}
+		k = &hotKey{}
+		t.keys[breq.Key] = k
+	}
+	k.req = breq
+	k.checks++
+}
+
+// decay halves every count. t.mu must be held.
+func (t *hotKeys) decay() {
+	for key, k := range t.keys {
+		if k.checks /= 2; k.checks == 0 {
+			delete(t.keys, key)
+		}
+	}
+}
+
+// HotKeys returns up to n of the keys checked most often lately, busiest
+// first, as checks with the parameters they were last made with and a
+// cost of 1. Only checks made while Options.HotKeys is positive count.
+func (h *Handler) HotKeys(n int) []backend.Request {
+	keys := h.hot.busiest(n, "")
+	out := make([]backend.Request, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, k.req)
+	}
+	return out
+}
+
+// busiest returns up to n of the keys starting with prefix that were
+// checked most often lately, busiest first.
+func (t *hotKeys) busiest(n int, prefix string) []hotKey {
+	t.mu.Lock()
+	keys := make([]hotKey, 0, len(t.keys))
+	for key, k := range t.keys {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, *k)
+		}
+	}
+	t.mu.Unlock()
+	sort.Slice(keys, func(i, j int) bool { return keys[i].checks > keys[j].checks })
+	return keys[:min(n, len(keys))]
+}
+
+// TopKey is one of the keys checked most often lately. Key is the backend
+// key, as in InspectResponse, and Checks a count halved now and then to
+// favour recent checks.
+type TopKey struct {
+	Key       string `json:"key"`
+	Algorithm string `json:"algorithm"`
+	Checks    int64  `json:"checks"`
+}
+
+type TopKeysResponse struct {
+	Keys []TopKey `json:"keys"`
+}
+
+// TopKeys lists the keys this instance checked most often lately, busiest
+// first, from the counts kept for warm starts. The tenant query parameter
+// keeps one tenant's keys; callers bound to a tenant only see theirs.
+func (h *Handler) TopKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "method_not_allowed"})
+		return
+	}
+	opts := h.opts.Load()
+	if opts.HotKeys <= 0 {
+		writeJSON(w, http.StatusNotImplemented, ErrorResponse{Error: "top_keys_unavailable",
+			Message: "checks are not counted by key; set BACKEND_WARM_START_KEYS"})
+		return
+	}
+	query := r.URL.Query()
+	tenant := query.Get("tenant")
+	if bound := tenantOf(r); bound != "" {
+		if tenant != "" && tenant != bound {
+			writeJSON(w, http.StatusForbidden, ErrorResponse{Error: "tenant_forbidden"})
+			return
+		}
+		tenant = bound
+	}
+	limit := 20
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 1000 {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid_limit"})
+			return
+		}
+		limit = n
+	}
+	prefix := ""
+	if tenant != "" {
+		prefix = tenantKey(tenant, "")
+	}
+	resp := TopKeysResponse{Keys: []TopKey{}}
+	for _, k := range h.hot.busiest(limit, prefix) {
+		resp.Keys = append(resp.Keys, TopKey{Key: k.req.Key, Algorithm: k.req.Algorithm, Checks: k.checks})
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// WarmStart preloads what the instance keeps locally about keys with
+// reqs, typically the busiest keys before a restart, so its first checks
+// on them behave as they would have before: the new key filter counts
+// them as seen, and with a shadow TTL the shadow starts from the state the
+// backend reports for them. The keys also count a check each towards
+// HotKeys, so they are listed again unless busier ones displace them. It
+// returns how many keys the backend reported on, when the shadow is on.
+func (h *Handler) WarmStart(ctx context.Context, reqs []backend.Request) int {
+	opts := h.opts.Load()
+	for _, req := range reqs {
+		h.newKeys.touch(req.Key, opts)
+	}
+	if opts.HotKeys > 0 {
+		h.hot.mu.Lock()
+		for _, req := range reqs {
+			h.hot.add(req, opts.HotKeys)
+		}
+		h.hot.mu.Unlock()
+	}
+	peeker, ok := h.backend.(backend.Peeker)
+	if !ok || opts.ShadowTTL <= 0 {
+		return 0
+	}
+	var mu sync.Mutex
+	warmed := 0
+	sem := make(chan struct{}, opts.BatchConcurrency)
+	var wg sync.WaitGroup
+	for _, req := range reqs {
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(req backend.Request) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			callCtx, cancel := backendContext(ctx, opts.BackendTimeout)
+			defer cancel()
+			res, err := peeker.Peek(callCtx, req)
+			if err != nil {
+				return
+			}
+			h.shadow.put(req, res, opts)
+			mu.Lock()
+			warmed++
+			mu.Unlock()
+		}(req)
+	}
+	wg.Wait()
+	return warmed
+}
@@ -0,0 +1,319 @@
+// Package jwt verifies JWT signatures against a JSON Web Key Set.
+package jwt
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrInvalidToken covers malformed, unsigned, badly signed, expired and
+	// otherwise unacceptable tokens.
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrKeysUnavailable means no key set could be loaded to check against.
+	ErrKeysUnavailable = errors.New("jwks unavailable")
+)
+
+// minRefetch spaces out key set fetches triggered by unknown key ids, so
+// tokens with made-up kids cannot hammer the JWKS endpoint.
+const minRefetch = 10 * time.Second
+
+// Options configures a Verifier. Exactly one of JWKSURL and JWKSFile is set.
+type Options struct {
+	JWKSURL  string
+	JWKSFile string
+	// RefreshInterval is how long a fetched key set is used before it is
+	// fetched again in the background; 0 means 5 minutes.
+	RefreshInterval time.Duration
+	// Issuer and Audience, when set, must match the iss and aud claims.
+	Issuer   string
+	Audience string
+	// Leeway absorbs clock skew when checking exp and nbf.
+	Leeway time.Duration
+	Client *http.Client
+}
+
+// Verifier checks tokens against a cached key set. Keys are loaded on
+// first use, refreshed after RefreshInterval, and fetched early when a
+// token names a key id the set does not have (signing key rotation).
+type Verifier struct {
+	opts   Options
+	client *http.Client
+
+	fetchMu    sync.Mutex
+	refreshing atomic.Bool
+
+	mu      sync.Mutex
+	keys    []key
+	fetched time.Time
+	tried   time.Time
+}
+
+func NewVerifier(opts Options) *Verifier {
+	if opts.RefreshInterval <= 0 {
+		opts.RefreshInterval = 5 * time.Minute
+	}
+	client := opts.Client
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Second}
+	}
+	return &Verifier{opts: opts, client: client}
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type claims struct {
+	Iss string          `json:"iss"`
+	Aud json.RawMessage `json:"aud"`
+	Exp *json.Number    `json:"exp"`
+	Nbf *json.Number    `json:"nbf"`
+}
+
+// Verify checks the token's signature and its exp, nbf, iss and aud claims.
+func (v *Verifier) Verify(ctx context.Context, token string) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("%w: not a signed JWT", ErrInvalidToken)
+	}
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return err
+	}
+	hash, ok := hashFor(h.Alg)
+	if !ok {
+		return fmt.Errorf("%w: unsupported alg %q", ErrInvalidToken, h.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return fmt.Errorf("%w: bad signature encoding", ErrInvalidToken)
+	}
+
+	keys, err := v.keysFor(ctx, h.Kid)
+	if err != nil {
+		return err
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, k := range keys {
+		if h.Kid != "" && k.id != h.Kid {
+			continue
+		}
+		if k.alg != "" && k.alg != h.Alg {
+			continue
+		}
+		if verifySignature(k.pub, h.Alg, hash, signed, sig) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return fmt.Errorf("%w: signature not verified", ErrInvalidToken)
+	}
+
+	var c claims
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return err
+	}
+	return v.checkClaims(c)
+}
+
+func decodeSegment(seg string, dst interface{}) error {
+	raw, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return fmt.Errorf("%w: bad encoding", ErrInvalidToken)
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(dst); err != nil {
+		return fmt.Errorf("%w: bad JSON", ErrInvalidToken)
+	}
+	return nil
+}
+
+func (v *Verifier) checkClaims(c claims) error {
+	// Compared as float seconds: exp and nbf may be fractional, and far-future
+	// values would overflow a time.Duration.
+	now := float64(time.Now().UnixNano()) / 1e9
+	leeway := v.opts.Leeway.Seconds()
+	if c.Exp != nil {
+		exp, err := c.Exp.Float64()
+		if err != nil || exp < now-leeway {
+			return fmt.Errorf("%w: expired", ErrInvalidToken)
+		}
+	}
+	if c.Nbf != nil {
+		nbf, err := c.Nbf.Float64()
+		if err != nil || nbf > now+leeway {
+			return fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+		}
+	}
+	if v.opts.Issuer != "" && c.Iss != v.opts.Issuer {
+		return fmt.Errorf("%w: wrong issuer", ErrInvalidToken)
+	}
+	if v.opts.Audience != "" && !hasAudience(c.Aud, v.opts.Audience) {
+		return fmt.Errorf("%w: wrong audience", ErrInvalidToken)
+	}
+	return nil
+}
+
+// hasAudience accepts aud as a single string or an array of strings.
+func hasAudience(raw json.RawMessage, want string) bool {
+	var one string
+	if json.Unmarshal(raw, &one) == nil {
+		return one == want
+	}
+	var many []string
+	if json.Unmarshal(raw, &many) != nil {
+		return false
+	}
+	for _, aud := range many {
+		if aud == want {
+			return true
+		}
+	}
+	return false
+}
+
+// hashFor maps a JWS alg to its digest. "none" and the HMAC algorithms are
+// not accepted: a public key set cannot verify them.
+func hashFor(alg string) (crypto.Hash, bool) {
+	switch alg {
+	case "RS256", "PS256", "ES256":
+		return crypto.SHA256, true
+	case "RS384", "PS384", "ES384":
+		return crypto.SHA384, true
+	case "RS512", "PS512", "ES512":
+		return crypto.SHA512, true
+	case "EdDSA":
+		return 0, true
+	default:
+		return 0, false
+	}
+}
+
+func verifySignature(pub crypto.PublicKey, alg string, hash crypto.Hash, signed, sig []byte) bool {
+	var digest []byte
+	if hash != 0 {
+		h := hash.New()
+		h.Write(signed)
+		digest = h.Sum(nil)
+	}
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		switch alg[:2] {
+		case "RS":
+			return rsa.VerifyPKCS1v15(pub, hash, digest, sig) == nil
+		case "PS":
+			return rsa.VerifyPSS(pub, hash, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil
+		}
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if alg[:2] != "ES" || len(sig) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		return ecdsa.Verify(pub, digest, r, s)
+	case ed25519.PublicKey:
+		return alg == "EdDSA" && ed25519.Verify(pub, signed, sig)
+	}
+	return false
+}
+
+// keysFor returns the cached key set, fetching it first if it has never
+// been loaded or lacks kid. A stale set is still used while a background
+// refresh runs.
+func (v *Verifier) keysFor(ctx context.Context, kid string) ([]key, error) {
+	v.mu.Lock()
+	keys, fetched, tried := v.keys, v.fetched, v.tried
+	v.mu.Unlock()
+
+	if !fetched.IsZero() && (kid == "" || hasKid(keys, kid)) {
+		if time.Since(fetched) >= v.opts.RefreshInterval && time.Since(tried) >= minRefetch {
+			v.refreshAsync()
+		}
+		return keys, nil
+	}
+	if time.Since(tried) < minRefetch {
+		if fetched.IsZero() {
+			return nil, ErrKeysUnavailable
+		}
+		return keys, nil
+	}
+	return v.refresh(ctx, tried)
+}
+
+// refresh fetches the key set unless another caller already tried since
+// after.
+func (v *Verifier) refresh(ctx context.Context, after time.Time) ([]key, error) {
+	v.fetchMu.Lock()
+	defer v.fetchMu.Unlock()
+
+	v.mu.Lock()
+	if v.tried.After(after) {
+		keys, fetched := v.keys, v.fetched
+		v.mu.Unlock()
+		if fetched.IsZero() {
+			return nil, ErrKeysUnavailable
+		}
+		return keys, nil
+	}
+	v.tried = time.Now()
+	v.mu.Unlock()
+
+	keys, err := v.fetch(ctx)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if err != nil {
+		log.Printf("jwks fetch failed: %v", err)
+		if v.fetched.IsZero() {
+			return nil, fmt.Errorf("%w: %v", ErrKeysUnavailable, err)
+		}
+		return v.keys, nil
+	}
+	v.keys = keys
+	v.fetched = time.Now()
+	return keys, nil
+}
+
+func (v *Verifier) refreshAsync() {
+	if !v.refreshing.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer v.refreshing.Store(false)
+		v.mu.Lock()
+		tried := v.tried
+		v.mu.Unlock()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_, _ = v.refresh(ctx, tried)
+	}()
+}
+
+func hasKid(keys []key, kid string) bool {
+	for _, k := range keys {
+		if k.id == kid {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+// Package leader elects one instance among those sharing a backend, so
+// background jobs run once rather than on every instance.
+package leader
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"rate-limiter-service/internal/backend"
+)
+
+// Elector holds a lease on the shared backend while it can. The lease is
+// renewed every third of its TTL; an instance that stops renewing, say
+// because it crashed or lost Redis, loses the lead when the lease lapses
+// and another instance takes it over.
+type Elector struct {
+	leaser backend.Leaser
+	name   string
+	holder string
+	ttl    time.Duration
+
+	mu sync.Mutex
+	// until is when the lease held by this instance lapses; zero when it
+	// holds none.
+	until time.Time
+	// leading is what was last logged, so changes are logged once.
+	leading bool
+}
+
+// New elects among instances that use the same name. Holder identifies
+// this instance and must differ between instances.
+func New(leaser backend.Leaser, name, holder string, ttl time.Duration) *Elector {
+	return &Elector{leaser: leaser, name: name, holder: holder, ttl: ttl}
+}
+
+// IsLeader reports whether this instance holds the lease. It turns false
+// when the lease may have lapsed, even before a renewal has failed.
+func (e *Elector) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return time.Now().Before(e.until)
+}
+
+// Run contends for the lease until ctx ends, then gives it up.
+func (e *Elector) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.ttl / 3)
+	defer ticker.Stop()
+	for {
+		e.contend(ctx)
+		select {
+		case <-ctx.Done():
+			e.release()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *Elector) contend(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, e.ttl/3)
+	defer cancel()
+	// The lease counts from before the request, in case it arrives late.
+	start := time.Now()
+	held, err := e.leaser.AcquireLease(ctx, e.name, e.holder, e.ttl)
+	e.mu.Lock()
+	switch {
+	case err != nil:
+		// Keep what is left of a held lease; another instance cannot take
+		// it before it lapses.
+		if e.leading {
+			log.Printf("leader: renewing %s failed: %v", e.name, err)
+		}
+	case held:
+		e.until = start.Add(e.ttl)
+	default:
+		e.until = time.Time{}
+	}
+	now := time.Now().Before(e.until)
+	changed := now != e.leading
+	e.leading = now
+	e.mu.Unlock()
+	if changed && now {
+		log.Printf("leader: %s is now led by this instance (%s)", e.name, e.holder)
+	} else if changed {
+		log.Printf("leader: this instance no longer leads %s", e.name)
+	}
+}
+
+func (e *Elector) release() {
+	e.mu.Lock()
+	held := time.Now().Before(e.until)
+	e.until, e.leading = time.Time{}, false
+	e.mu.Unlock()
+	if !held {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := e.leaser.ReleaseLease(ctx, e.name, e.holder); err != nil {
+		log.Printf("leader: releasing %s failed: %v", e.name, err)
+	}
+}
@@ -0,0 +1,274 @@
+// Package metrics keeps the service's Prometheus metrics and writes them
+// in the text exposition format, for scraping or pushing.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Metric types.
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+)
+
+// Registry holds metric families in the order they were added.
+type Registry struct {
+	mu       sync.Mutex
+	families []*family
+}
+
+type family struct {
+	name, help, typ string
+	labels          []string
+	buckets         []float64
+	gauge           func() float64
+	gauges          func(set func(v float64, values ...string))
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	values []string
+	value  float64
+	// counts are cumulative per bucket, the last being +Inf; histograms
+	// only.
+	counts []uint64
+}
+
+// Sample is one value of a metric, its labels including __name__.
+type Sample struct {
+	Labels map[string]string
+	Value  float64
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) add(f *family) *family {
+	f.series = make(map[string]*series)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.families = append(r.families, f)
+	return f
+}
+
+// Counter is a family of counters told apart by label values.
+type Counter struct{ f *family }
+
+// Counter adds a counter family with the given label names.
+func (r *Registry) Counter(name, help string, labels ...string) Counter {
+	return Counter{r.add(&family{name: name, help: help, typ: typeCounter, labels: labels})}
+}
+
+// Add adds v to the counter with values for the family's labels.
+func (c Counter) Add(v float64, values ...string) {
+	s := c.f.get(values)
+	c.f.mu.Lock()
+	s.value += v
+	c.f.mu.Unlock()
+}
+
+func (c Counter) Inc(values ...string) { c.Add(1, values...) }
+
+// GaugeFunc adds a gauge read from fn whenever metrics are gathered.
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	r.add(&family{name: name, help: help, typ: typeGauge, gauge: fn})
+}
+
+// CounterFunc adds a counter read from fn whenever metrics are gathered,
+// for totals kept elsewhere.
+func (r *Registry) CounterFunc(name, help string, fn func() float64) {
+	r.add(&family{name: name, help: help, typ: typeCounter, gauge: fn})
+}
+
+// GaugeVecFunc adds a gauge family with the given label names, read from
+// fn whenever metrics are gathered. fn calls set once for each series.
+func (r *Registry) GaugeVecFunc(name, help string, labels []string, fn func(set func(v float64, values ...string))) {
+	r.add(&family{name: name, help: help, typ: typeGauge, labels: labels, gauges: fn})
+}
+
+// Histogram is a family of histograms told apart by label values.
+type Histogram struct{ f *family }
+
+// Histogram adds a histogram family with the given upper bounds, in
+// increasing order, and label names.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) Histogram {
+	return Histogram{r.add(&family{name: name, help: help, typ: typeHistogram, labels: labels, buckets: buckets})}
+}
+
+// Observe records v in the histogram with values for the family's labels.
+func (h Histogram) Observe(v float64, values ...string) {
+	s := h.f.get(values)
+	h.f.mu.Lock()
+	defer h.f.mu.Unlock()
+	s.value += v
+	for i, bound := range h.f.buckets {
+		if v <= bound {
+			s.counts[i]++
+		}
+	}
+	s.counts[len(h.f.buckets)]++
+}
+
+func (f *family) get(values []string) *series {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+	id := strings.Join(values, "\xff")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.series[id]
+	if !ok {
+		s = &series{values: append([]string(nil), values...)}
+		if f.typ == typeHistogram {
+			s.counts = make([]uint64, len(f.buckets)+1)
+		}
+		f.series[id] = s
+	}
+	return s
+}
+
+// Gather returns every sample, histograms expanded into their _bucket,
+// _sum and _count series, with extra labels added to each.
+func (r *Registry) Gather(extra map[string]string) []Sample {
+	var out []Sample
+	r.each(func(f *family, name string, labels map[string]string, value float64) {
+		all := make(map[string]string, len(labels)+len(extra)+1)
+		for k, v := range extra {
+			all[k] = v
+		}
+		for k, v := range labels {
+			all[k] = v
+		}
+		all["__name__"] = name
+		out = append(out, Sample{Labels: all, Value: value})
+	})
+	return out
+}
+
+// WriteText writes every metric in the Prometheus text format.
+func (r *Registry) WriteText(w io.Writer) error {
+	var err error
+	var current *family
+	r.each(func(f *family, name string, labels map[string]string, value float64) {
+		if err != nil {
+			return
+		}
+		if f != current {
+			current = f
+			_, err = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, escapeHelp(f.help), f.name, f.typ)
+		}
+		if err == nil {
+			_, err = fmt.Fprintf(w, "%s%s %s\n", name, formatLabels(labels), formatValue(value))
+		}
+	})
+	return err
+}
+
+// each calls fn with every sample, in family order and, within a family,
+// in label order.
+func (r *Registry) each(fn func(f *family, name string, labels map[string]string, value float64)) {
+	r.mu.Lock()
+	families := append([]*family(nil), r.families...)
+	r.mu.Unlock()
+	for _, f := range families {
+		if f.gauge != nil {
+			fn(f, f.name, nil, f.gauge())
+			continue
+		}
+		var all []*series
+		if f.gauges != nil {
+			f.gauges(func(v float64, values ...string) {
+				if len(values) != len(f.labels) {
+					panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", f.name, len(f.labels), len(values)))
+				}
+				all = append(all, &series{values: append([]string(nil), values...), value: v})
+			})
+		} else {
+			f.mu.Lock()
+			all = make([]*series, 0, len(f.series))
+			for _, s := range f.series {
+				all = append(all, &series{values: s.values, value: s.value, counts: append([]uint64(nil), s.counts...)})
+			}
+			f.mu.Unlock()
+		}
+		sort.Slice(all, func(i, j int) bool {
+			return strings.Join(all[i].values, "\xff") < strings.Join(all[j].values, "\xff")
+		})
+		for _, s := range all {
+			labels := make(map[string]string, len(f.labels)+1)
+			for i, name := range f.labels {
+				labels[name] = s.values[i]
+			}
+			if f.typ != typeHistogram {
+				fn(f, f.name, labels, s.value)
+				continue
+			}
+			bounds := append(append([]float64(nil), f.buckets...), math.Inf(1))
+			for i, bound := range bounds {
+				bucket := make(map[string]string, len(labels)+1)
+				for k, v := range labels {
+					bucket[k] = v
+				}
+				bucket["le"] = formatValue(bound)
+				fn(f, f.name+"_bucket", bucket, float64(s.counts[i]))
+			}
+			fn(f, f.name+"_sum", labels, s.value)
+			fn(f, f.name+"_count", labels, float64(s.counts[len(f.buckets)]))
+		}
+	}
+}
+
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(labels[name]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
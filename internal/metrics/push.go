@@ -0,0 +1,166 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+)
+
+// Options configure a Pusher. Either URL may be empty; credentials go in
+// a URL's user info.
+type Options struct {
+	// PushgatewayURL is the Pushgateway's base URL; metrics replace the
+	// group of Job and Instance there.
+	PushgatewayURL string
+	// RemoteWriteURL receives Prometheus remote-write requests, whose
+	// series carry job and instance labels.
+	RemoteWriteURL string
+	Job            string
+	Instance       string
+	// Timeout bounds each push; defaults to 10 seconds.
+	Timeout time.Duration
+	Client  *http.Client
+}
+
+// Pusher sends a registry's metrics to where Options name.
+type Pusher struct {
+	opts     Options
+	registry *Registry
+}
+
+// NewPusher returns a Pusher for registry.
+func NewPusher(registry *Registry, opts Options) *Pusher {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{}
+	}
+	return &Pusher{opts: opts, registry: registry}
+}
+
+// Push sends the current metrics once to each destination.
+func (p *Pusher) Push(ctx context.Context) error {
+	var errs []error
+	if p.opts.PushgatewayURL != "" {
+		if err := p.pushGateway(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("pushgateway: %w", err))
+		}
+	}
+	if p.opts.RemoteWriteURL != "" {
+		if err := p.remoteWrite(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("remote write: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (p *Pusher) pushGateway(ctx context.Context) error {
+	var body bytes.Buffer
+	if err := p.registry.WriteText(&body); err != nil {
+		return err
+	}
+	target := p.opts.PushgatewayURL + "/metrics/job/" + url.PathEscape(p.opts.Job)
+	if p.opts.Instance != "" {
+		target += "/instance/" + url.PathEscape(p.opts.Instance)
+	}
+	return p.send(ctx, http.MethodPut, target, &body, map[string]string{
+		"Content-Type": "text/plain; version=0.0.4",
+	})
+}
+
+func (p *Pusher) remoteWrite(ctx context.Context) error {
+	extra := map[string]string{"job": p.opts.Job}
+	if p.opts.Instance != "" {
+		extra["instance"] = p.opts.Instance
+	}
+	samples := p.registry.Gather(extra)
+	body := snappyEncode(encodeWriteRequest(samples, time.Now().UnixMilli()))
+	return p.send(ctx, http.MethodPost, p.opts.RemoteWriteURL, bytes.NewReader(body), map[string]string{
+		"Content-Type":                      "application/x-protobuf",
+		"Content-Encoding":                  "snappy",
+		"X-Prometheus-Remote-Write-Version": "0.1.0",
+	})
+}
+
+func (p *Pusher) send(ctx context.Context, method, target string, body io.Reader, headers map[string]string) error {
+	ctx, cancel := context.WithTimeout(ctx, p.opts.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := p.opts.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// encodeWriteRequest encodes samples as a remote-write WriteRequest
+// protobuf, one series per sample, all at timestampMs.
+func encodeWriteRequest(samples []Sample, timestampMs int64) []byte {
+	var out, ts, item []byte
+	for _, s := range samples {
+		ts = ts[:0]
+		names := make([]string, 0, len(s.Labels))
+		for name := range s.Labels {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			item = item[:0]
+			item = appendBytesField(item, 1, []byte(name))
+			item = appendBytesField(item, 2, []byte(s.Labels[name]))
+			ts = appendBytesField(ts, 1, item)
+		}
+		item = item[:0]
+		item = binary.AppendUvarint(item, 1<<3|1)
+		item = binary.LittleEndian.AppendUint64(item, math.Float64bits(s.Value))
+		item = binary.AppendUvarint(item, 2<<3)
+		item = binary.AppendUvarint(item, uint64(timestampMs))
+		ts = appendBytesField(ts, 2, item)
+		out = appendBytesField(out, 1, ts)
+	}
+	return out
+}
+
+func appendBytesField(buf []byte, field uint64, data []byte) []byte {
+	buf = binary.AppendUvarint(buf, field<<3|2)
+	buf = binary.AppendUvarint(buf, uint64(len(data)))
+	return append(buf, data...)
+}
+
+// snappyEncode frames data in the snappy block format as literals only:
+// valid for any decoder, without compressing.
+func snappyEncode(data []byte) []byte {
+	out := binary.AppendUvarint(nil, uint64(len(data)))
+	for len(data) > 0 {
+		n := min(len(data), 1<<16)
+		if n <= 60 {
+			out = append(out, byte(n-1)<<2)
+		} else {
+			out = append(out, 61<<2)
+			out = binary.LittleEndian.AppendUint16(out, uint16(n-1))
+		}
+		out = append(out, data[:n]...)
+		data = data[n:]
+	}
+	return out
+}
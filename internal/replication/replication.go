@@ -0,0 +1,395 @@
+// Package replication shares consumption between regions that each enforce
+// limits against their own backend. Every instance sums what it allowed per
+// key and periodically sends those deltas to each peer region, which charges
+// them to its own backend. Counters only grow, so deltas can be applied in
+// any order; each region converges on the global consumption within a sync
+// interval plus the round trip.
+package replication
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"rate-limiter-service/internal/backend"
+)
+
+// maxBatchDeltas bounds one batch to a few hundred kilobytes of JSON.
+const maxBatchDeltas = 1000
+
+// Delta is the cost one sender allowed for a key, with the parameters it
+// was checked with so the receiver can charge the same state.
+type Delta struct {
+	Key          string  `json:"key"`
+	Algorithm    string  `json:"algorithm"`
+	Limit        int64   `json:"limit,omitempty"`
+	WindowMs     int64   `json:"window_ms,omitempty"`
+	Capacity     int64   `json:"capacity,omitempty"`
+	RefillPerSec float64 `json:"refill_per_sec,omitempty"`
+	LeakPerSec   float64 `json:"leak_per_sec,omitempty"`
+	Cost         int64   `json:"cost"`
+	Timezone     string  `json:"timezone,omitempty"`
+}
+
+// Batch is what one sender ships to a peer in one sync. Seq grows with
+// every batch a sender makes, so a resent batch is recognised.
+type Batch struct {
+	Region string  `json:"region"`
+	Sender string  `json:"sender"`
+	Seq    int64   `json:"seq"`
+	Deltas []Delta `json:"deltas"`
+}
+
+// Peer is another region's limiter.
+type Peer struct {
+	Region string
+	URL    string
+}
+
+type Options struct {
+	Region string
+	Peers  []Peer
+	// Interval is how often deltas are sent; defaults to 200ms.
+	Interval time.Duration
+	// MaxAge drops deltas a peer has not taken within it, as they no longer
+	// matter to any window; defaults to a minute.
+	MaxAge time.Duration
+	// APIKey is sent to peers as "Authorization: ApiKey <key>".
+	APIKey string
+	Client *http.Client
+}
+
+// Replicator records local consumption, ships it to peers and applies what
+// peers ship to it.
+type Replicator struct {
+	opts   Options
+	sender string
+	client *http.Client
+	// seq numbers the batches to every peer, so a peer's queue made anew,
+	// after it left and came back, goes on past the batches it already took.
+	seq atomic.Int64
+
+	mu      sync.Mutex
+	pending map[deltaKey]int64
+	peers   []*peerQueue
+
+	appliedMu sync.Mutex
+	applied   map[string]*senderState
+	pruned    time.Time
+}
+
+// senderExpiry is how long a sender's progress is kept once it stops
+// sending: well past the MaxAge within which it resends a batch.
+const senderExpiry = 10 * time.Minute
+
+// senderState is how far a sender's batches have been applied: deltas
+// [0, done) of batch seq. lock, a one-slot channel so a waiting resend can
+// give up with its context, is held while a batch of the sender's applies.
+type senderState struct {
+	lock chan struct{}
+	seq  int64
+	done int
+	seen time.Time
+}
+
+// deltaKey is a check's key and parameters, with Cost zeroed. Requests
+// differ by more fields than deltas carry, so it holds only those.
+type deltaKey struct {
+	Key, Algorithm, Timezone  string
+	Limit, WindowMs, Capacity int64
+	RefillPerSec, LeakPerSec  float64
+}
+
+// peerQueue holds what one peer has yet to take: a batch in flight, which
+// is resent as-is until the peer takes it, and the deltas gathered since.
+type peerQueue struct {
+	peer     Peer
+	inflight *Batch
+	waiting  map[deltaKey]int64
+	// since is when the oldest delta the peer has yet to take was queued.
+	since time.Time
+	// failing is set while sends fail, so an outage is logged once.
+	failing bool
+}
+
+func New(opts Options) *Replicator {
+	if opts.Interval <= 0 {
+		opts.Interval = 200 * time.Millisecond
+	}
+	if opts.MaxAge <= 0 {
+		opts.MaxAge = time.Minute
+	}
+	client := opts.Client
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Second}
+	}
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	r := &Replicator{
+		opts:    opts,
+		sender:  opts.Region + "/" + hex.EncodeToString(id),
+		client:  client,
+		applied: make(map[string]*senderState),
+	}
+	for _, peer := range opts.Peers {
+		r.peers = append(r.peers, &peerQueue{peer: peer})
+	}
+	return r
+}
+
+// SetPeers replaces the peers deltas are sent to. Peers that stay keep
+// their queues; deltas for dropped peers are discarded.
+func (r *Replicator) SetPeers(peers []Peer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	queues := make([]*peerQueue, 0, len(peers))
+	for _, peer := range peers {
+		q := &peerQueue{peer: peer}
+		for _, old := range r.peers {
+			if old.peer == peer {
+				q = old
+				break
+			}
+		}
+		queues = append(queues, q)
+	}
+	r.peers = queues
+}
+
+// Region is the region this instance enforces for.
+func (r *Replicator) Region() string {
+	return r.opts.Region
+}
+
+// Record notes that req was allowed locally. Only its key's consumption is
+// shipped, not its dimensions'.
+func (r *Replicator) Record(req backend.Request) {
+	k := deltaKey{
+		Key:          req.Key,
+		Algorithm:    req.Algorithm,
+		Timezone:     req.Timezone,
+		Limit:        req.Limit,
+		WindowMs:     req.WindowMs,
+		Capacity:     req.Capacity,
+		RefillPerSec: req.RefillPerSec,
+		LeakPerSec:   req.LeakPerSec,
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.pending == nil {
+		r.pending = make(map[deltaKey]int64)
+	}
+	r.pending[k] += req.Cost
+}
+
+// Run sends deltas to every peer each interval until ctx ends.
+func (r *Replicator) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.sync(ctx)
+		}
+	}
+}
+
+func (r *Replicator) sync(ctx context.Context) {
+	r.mu.Lock()
+	pending := r.pending
+	r.pending = nil
+	peers := r.peers
+	r.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, q := range peers {
+		for k, cost := range pending {
+			if q.waiting == nil {
+				q.waiting = make(map[deltaKey]int64)
+			}
+			q.waiting[k] += cost
+		}
+		wg.Add(1)
+		go func(q *peerQueue) {
+			defer wg.Done()
+			r.sendTo(ctx, q)
+		}(q)
+	}
+	wg.Wait()
+}
+
+// sendTo delivers the peer's batch in flight, if any, then what is waiting.
+// Only one sync runs at a time, so the queue needs no lock.
+func (r *Replicator) sendTo(ctx context.Context, q *peerQueue) {
+	if q.inflight == nil && len(q.waiting) == 0 {
+		q.since = time.Time{}
+		return
+	}
+	if q.since.IsZero() {
+		q.since = time.Now()
+	}
+	if time.Since(q.since) > r.opts.MaxAge {
+		log.Printf("replication: %s has taken no deltas for %s; dropping them", q.peer.Region, r.opts.MaxAge)
+		q.inflight, q.waiting, q.since = nil, nil, time.Time{}
+		return
+	}
+	if q.inflight == nil {
+		q.inflight = &Batch{Region: r.opts.Region, Sender: r.sender, Seq: r.seq.Add(1), Deltas: takeDeltas(q.waiting)}
+	}
+	if err := r.post(ctx, q.peer, q.inflight); err != nil {
+		if !q.failing {
+			log.Printf("replication: sending to %s failed: %v; retrying", q.peer.Region, err)
+			q.failing = true
+		}
+		return
+	}
+	if q.failing {
+		log.Printf("replication: %s reachable again", q.peer.Region)
+		q.failing = false
+	}
+	q.inflight = nil
+	if len(q.waiting) == 0 {
+		q.since = time.Time{}
+	}
+}
+
+// takeDeltas removes up to maxBatchDeltas entries from costs, so a batch
+// stays well under the receiver's body limit; the rest wait for later syncs.
+func takeDeltas(costs map[deltaKey]int64) []Delta {
+	deltas := make([]Delta, 0, min(len(costs), maxBatchDeltas))
+	for k, cost := range costs {
+		if len(deltas) == maxBatchDeltas {
+			break
+		}
+		delete(costs, k)
+		deltas = append(deltas, Delta{
+			Key:          k.Key,
+			Algorithm:    k.Algorithm,
+			Limit:        k.Limit,
+			WindowMs:     k.WindowMs,
+			Capacity:     k.Capacity,
+			RefillPerSec: k.RefillPerSec,
+			LeakPerSec:   k.LeakPerSec,
+			Cost:         cost,
+			Timezone:     k.Timezone,
+		})
+	}
+	return deltas
+}
+
+func (r *Replicator) post(ctx context.Context, peer Peer, batch *Batch) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, peer.URL+"/v1/replication/deltas", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if r.opts.APIKey != "" {
+		req.Header.Set("Authorization", "ApiKey "+r.opts.APIKey)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: status %d", peer.URL, resp.StatusCode)
+	}
+	return nil
+}
+
+// Apply charges a peer's batch to b, once per sender and seq. A delta is
+// charged as far as the limit allows: a key the peers have exhausted
+// between them stays exhausted, and the overshoot is dropped. Batches of
+// one sender apply one at a time, and progress is kept per delta, so a
+// batch resent after a failure or a lost reply charges only what it has
+// not yet charged.
+func (r *Replicator) Apply(ctx context.Context, b backend.Backend, batch Batch) error {
+	state := r.senderState(batch.Sender)
+	select {
+	case state.lock <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-state.lock }()
+
+	switch {
+	case batch.Seq < state.seq:
+		return nil
+	case batch.Seq > state.seq:
+		state.seq, state.done = batch.Seq, 0
+	}
+	for ; state.done < len(batch.Deltas); state.done++ {
+		d := batch.Deltas[state.done]
+		if d.Cost <= 0 {
+			continue
+		}
+		req := backend.Request{
+			Algorithm:    d.Algorithm,
+			Key:          d.Key,
+			Limit:        d.Limit,
+			WindowMs:     d.WindowMs,
+			Capacity:     d.Capacity,
+			RefillPerSec: d.RefillPerSec,
+			LeakPerSec:   d.LeakPerSec,
+			Cost:         d.Cost,
+			Timezone:     d.Timezone,
+		}
+		res, err := backend.Evaluate(ctx, b, req)
+		if errors.Is(err, backend.ErrInvalidParams) || errors.Is(err, backend.ErrUnsupportedAlgorithm) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !res.Allowed && res.Remaining > 0 {
+			req.Cost = res.Remaining
+			if _, err := backend.Evaluate(ctx, b, req); err != nil {
+				// What the first charge took stays charged; a resend
+				// goes on from the next delta rather than repeat it.
+				state.done++
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// senderState returns sender's progress, dropping that of senders not
+// seen for senderExpiry.
+func (r *Replicator) senderState(sender string) *senderState {
+	now := time.Now()
+	r.appliedMu.Lock()
+	defer r.appliedMu.Unlock()
+	if now.Sub(r.pruned) > senderExpiry {
+		for id, state := range r.applied {
+			if now.Sub(state.seen) > senderExpiry {
+				delete(r.applied, id)
+			}
+		}
+		r.pruned = now
+	}
+	state, ok := r.applied[sender]
+	if !ok {
+		state = &senderState{lock: make(chan struct{}, 1)}
+		r.applied[sender] = state
+	}
+	state.seen = now
+	return state
+}
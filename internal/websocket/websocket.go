@@ -0,0 +1,289 @@
+// Package websocket implements the server side of RFC 6455 WebSockets, as
+// much as exchanging messages needs: no extensions or subprotocols.
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// acceptGUID is appended to the client's key to prove the handshake was
+// understood.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// writeTimeout bounds sending one message, so a client that stops reading
+// fails its connection rather than hold it open.
+const writeTimeout = 10 * time.Second
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// Close codes sent when ending a connection.
+const (
+	CloseNormal        = 1000
+	CloseGoingAway     = 1001
+	CloseProtocolError = 1002
+	CloseTooBig        = 1009
+)
+
+var (
+	// ErrClosed is returned once the client has closed the connection.
+	ErrClosed = errors.New("websocket closed")
+	// ErrProtocol covers frames that break RFC 6455.
+	ErrProtocol = errors.New("websocket protocol error")
+	// ErrTooBig is returned for a message longer than the connection's
+	// limit.
+	ErrTooBig = errors.New("websocket message too big")
+)
+
+// HandshakeError is a request that cannot be upgraded, with the HTTP
+// status to answer it with.
+type HandshakeError struct {
+	Status  int
+	Message string
+}
+
+func (e HandshakeError) Error() string { return e.Message }
+
+// Conn is an upgraded connection. One goroutine may read while others
+// write or close.
+type Conn struct {
+	conn       net.Conn
+	reader     *bufio.Reader
+	maxMessage int64
+
+	mu     sync.Mutex
+	writer *bufio.Writer
+	closed atomic.Bool
+}
+
+// IsUpgrade reports whether r asks for a WebSocket.
+func IsUpgrade(r *http.Request) bool {
+	return headerHas(r.Header, "Connection", "upgrade") && headerHas(r.Header, "Upgrade", "websocket")
+}
+
+// Upgrade completes the handshake for r and takes over its connection.
+// Messages longer than maxMessage bytes end it. A HandshakeError is
+// returned, with nothing written, when r is not a valid upgrade request.
+func Upgrade(w http.ResponseWriter, r *http.Request, maxMessage int64) (*Conn, error) {
+	switch {
+	case r.Method != http.MethodGet:
+		return nil, HandshakeError{http.StatusMethodNotAllowed, "websocket upgrades use GET"}
+	case !IsUpgrade(r):
+		return nil, HandshakeError{http.StatusUpgradeRequired, "expected a websocket upgrade"}
+	case r.Header.Get("Sec-WebSocket-Version") != "13":
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, HandshakeError{http.StatusUpgradeRequired, "websocket version 13 required"}
+	case r.Header.Get("Sec-WebSocket-Key") == "":
+		return nil, HandshakeError{http.StatusBadRequest, "missing Sec-WebSocket-Key"}
+	}
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if errors.Is(err, http.ErrNotSupported) {
+		return nil, HandshakeError{http.StatusInternalServerError, "connection cannot be taken over"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	sum := sha1.Sum([]byte(r.Header.Get("Sec-WebSocket-Key") + acceptGUID))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: ")
+	rw.WriteString(base64.StdEncoding.EncodeToString(sum[:]))
+	rw.WriteString("\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// Deadlines the server set for the request no longer apply.
+	conn.SetDeadline(time.Time{})
+	return &Conn{conn: conn, reader: rw.Reader, writer: rw.Writer, maxMessage: maxMessage}, nil
+}
+
+func headerHas(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SetReadDeadline bounds the wait for the next message.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// ReadMessage returns the next text or binary message, answering pings
+// on the way. It returns ErrClosed after the client closed the connection,
+// and closes it itself on ErrProtocol and ErrTooBig.
+func (c *Conn) ReadMessage() ([]byte, error) {
+	var msg []byte
+	started := false
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return nil, c.fail(err)
+		}
+		switch op {
+		case opPing:
+			if err := c.write(opPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			// The close is answered with the client's own code.
+			code := CloseNormal
+			if len(payload) >= 2 {
+				code = int(binary.BigEndian.Uint16(payload))
+			}
+			c.Close(code, "")
+			return nil, ErrClosed
+		case opText, opBinary:
+			if started {
+				return nil, c.fail(fmt.Errorf("%w: new message inside a fragmented one", ErrProtocol))
+			}
+			started, msg = true, payload
+		case opContinuation:
+			if !started {
+				return nil, c.fail(fmt.Errorf("%w: continuation without a message", ErrProtocol))
+			}
+			msg = append(msg, payload...)
+		default:
+			return nil, c.fail(fmt.Errorf("%w: opcode %d", ErrProtocol, op))
+		}
+		if int64(len(msg)) > c.maxMessage {
+			return nil, c.fail(ErrTooBig)
+		}
+		if fin {
+			return msg, nil
+		}
+	}
+}
+
+// readFrame reads one frame, unmasking its payload.
+func (c *Conn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(c.reader, head[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin, op = head[0]&0x80 != 0, head[0]&0x0f
+	if head[0]&0x70 != 0 {
+		return false, 0, nil, fmt.Errorf("%w: reserved bits set", ErrProtocol)
+	}
+	if head[1]&0x80 == 0 {
+		return false, 0, nil, fmt.Errorf("%w: unmasked client frame", ErrProtocol)
+	}
+	length := uint64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.reader, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.reader, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if op >= opClose && (!fin || length > 125) {
+		return false, 0, nil, fmt.Errorf("%w: fragmented or long control frame", ErrProtocol)
+	}
+	if length > uint64(c.maxMessage) {
+		return false, 0, nil, ErrTooBig
+	}
+	var mask [4]byte
+	if _, err = io.ReadFull(c.reader, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, op, payload, nil
+}
+
+// fail closes the connection with the close code err calls for.
+func (c *Conn) fail(err error) error {
+	switch {
+	case errors.Is(err, ErrTooBig):
+		c.Close(CloseTooBig, "message too big")
+	case errors.Is(err, ErrProtocol):
+		c.Close(CloseProtocolError, "")
+	}
+	return err
+}
+
+// WriteMessage sends data as a text message.
+func (c *Conn) WriteMessage(data []byte) error {
+	return c.write(opText, data)
+}
+
+func (c *Conn) write(op byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed.Load() {
+		return ErrClosed
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return c.writeFrame(op, payload)
+}
+
+// writeFrame sends one unmasked frame. c.mu must be held.
+func (c *Conn) writeFrame(op byte, payload []byte) error {
+	head := []byte{0x80 | op, 0}
+	switch n := len(payload); {
+	case n <= 125:
+		head[1] = byte(n)
+	case n <= 0xffff:
+		head[1] = 126
+		head = binary.BigEndian.AppendUint16(head, uint16(n))
+	default:
+		head[1] = 127
+		head = binary.BigEndian.AppendUint64(head, uint64(n))
+	}
+	c.writer.Write(head)
+	c.writer.Write(payload)
+	return c.writer.Flush()
+}
+
+// Close sends a close frame with code and reason, unless code is 0, and
+// closes the connection. It does not wait for a write in progress, which
+// may be stuck on a client that stopped reading: the connection is closed
+// under it without a close frame. Closing twice does nothing.
+func (c *Conn) Close(code int, reason string) error {
+	if !c.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+	if code != 0 && c.mu.TryLock() {
+		payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+		c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+		c.writeFrame(opClose, append(payload, reason...))
+		c.mu.Unlock()
+	}
+	return c.conn.Close()
+}